@@ -31,12 +31,16 @@ var (
 		Run: func(cmd *cobra.Command, args []string) {
 			cmd.Printf("mantle/%s version %s\n",
 				cmd.Root().Name(), version.Version)
+			if version.Commit != "" {
+				cmd.Printf("commit %s\n", version.Commit)
+			}
 		},
 	}
 
 	logDebug   bool
 	logVerbose bool
 	logLevel   = capnslog.NOTICE
+	formatter  capnslog.Formatter
 
 	plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "cli")
 )
@@ -86,7 +90,8 @@ func startLogging(cmd *cobra.Command) {
 		logLevel = capnslog.INFO
 	}
 
-	capnslog.SetFormatter(capnslog.NewStringFormatter(cmd.Out()))
+	formatter = capnslog.NewStringFormatter(cmd.Out())
+	capnslog.SetFormatter(formatter)
 	capnslog.SetGlobalLogLevel(logLevel)
 
 	// In the context of the internally linked etcd, the NOTICE messages
@@ -0,0 +1,83 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/coreos/pkg/capnslog"
+)
+
+// LogRecord is a message logged at WARNING or above.
+type LogRecord struct {
+	Level   capnslog.LogLevel
+	Package string
+	Message string
+}
+
+func (r LogRecord) String() string {
+	return fmt.Sprintf("%s %s: %s", r.Level, r.Package, r.Message)
+}
+
+// recorder passes messages through to the real formatter, keeping a
+// copy of those at WARNING or above.
+type recorder struct {
+	capnslog.Formatter
+
+	mu      sync.Mutex
+	records []LogRecord
+}
+
+func (r *recorder) Format(pkg string, l capnslog.LogLevel, depth int, entries ...interface{}) {
+	if l <= capnslog.WARNING {
+		r.mu.Lock()
+		r.records = append(r.records, LogRecord{
+			Level:   l,
+			Package: pkg,
+			Message: fmt.Sprint(entries...),
+		})
+		r.mu.Unlock()
+	}
+	r.Formatter.Format(pkg, l, depth+1, entries...)
+}
+
+var warnings *recorder
+
+// RecordWarnings keeps every message subsequently logged at WARNING or
+// above so that commands can fail when something went wrong that did not
+// otherwise cause an error. Messages filtered out by the log level are
+// not seen.
+func RecordWarnings() {
+	if warnings != nil {
+		return
+	}
+	if formatter == nil {
+		formatter = capnslog.NewStringFormatter(os.Stderr)
+	}
+	warnings = &recorder{Formatter: formatter}
+	capnslog.SetFormatter(warnings)
+}
+
+// Warnings returns the messages kept since RecordWarnings was called.
+func Warnings() []LogRecord {
+	if warnings == nil {
+		return nil
+	}
+	warnings.mu.Lock()
+	defer warnings.mu.Unlock()
+	return append([]LogRecord(nil), warnings.records...)
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	"github.com/coreos/pkg/capnslog"
+)
+
+func TestRecordWarnings(t *testing.T) {
+	formatter = capnslog.NewNilFormatter()
+	RecordWarnings()
+	capnslog.SetGlobalLogLevel(capnslog.INFO)
+
+	l := capnslog.NewPackageLogger("github.com/coreos/mantle", "cli_test")
+	l.Infof("not kept")
+	l.Noticef("not kept")
+	l.Warningf("destroy %s", "slow")
+	l.Errorf("destroy %s", "failed")
+
+	expect := []LogRecord{
+		{capnslog.WARNING, "cli_test", "destroy slow"},
+		{capnslog.ERROR, "cli_test", "destroy failed"},
+	}
+	got := Warnings()
+	if len(got) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, got)
+	}
+	for i := range expect {
+		if got[i] != expect[i] {
+			t.Errorf("record %d: expected %v, got %v", i, expect[i], got[i])
+		}
+	}
+}
@@ -57,7 +57,8 @@ func runBootchart(cmd *cobra.Command, args []string) {
 	}
 
 	cluster, err := kola.NewCluster(kolaPlatform, &platform.RuntimeConfig{
-		OutputDir: outputDir,
+		OutputDir:    outputDir,
+		StrictCrypto: kola.StrictCrypto,
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cluster failed: %v\n", err)
@@ -0,0 +1,95 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/mantle/platform/local"
+	"github.com/coreos/mantle/platform/machine/qemu"
+)
+
+var (
+	cmdCleanup = &cobra.Command{
+		Run:   runCleanup,
+		Use:   "cleanup",
+		Short: "Kill qemu processes left behind by kola",
+		Long: `Kill qemu processes left behind by kola.
+
+A qemu process is left behind if its machine was destroyed or the kola
+process which started it died. They are recognized by the QMP socket
+on their command line. On systemd hosts, the kola-* scopes of kola
+processes which died are killed too, along with anything qemu and
+dnsmasq forked.
+
+This must run as root, or as the user which ran kola!`,
+	}
+
+	cleanupDryRun bool
+)
+
+func init() {
+	cmdCleanup.Flags().BoolVarP(&cleanupDryRun, "dry-run", "n", false, "List the processes but don't kill them")
+	root.AddCommand(cmdCleanup)
+}
+
+func runCleanup(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "No args accepted\n")
+		os.Exit(2)
+	}
+
+	failed := false
+
+	// a scope holds everything its processes forked, so kill those first
+	scopes, err := local.FindLeftoverScopes()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Finding leftover systemd scopes failed: %v\n", err)
+		failed = true
+	}
+	for _, s := range scopes {
+		fmt.Printf("scope %s\t%s\n", s.Unit, s.Description)
+		if cleanupDryRun {
+			continue
+		}
+		if err := s.Kill(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			failed = true
+		}
+	}
+
+	strays, err := qemu.FindStrays()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Finding stray qemu processes failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, s := range strays {
+		fmt.Printf("%d\tmachine %s\t%s\n", s.PID, s.MachineID, s.Cmdline)
+		if cleanupDryRun {
+			continue
+		}
+		if err := s.Kill(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/mantle/kola"
+)
+
+var (
+	cmdDiffResults = &cobra.Command{
+		Use:   "diff-results old/report.json new/report.json",
+		Short: "Compare the results of two kola runs",
+		Long: `Compare the results of two kola runs.
+
+Lists tests which newly failed, were fixed, were newly skipped, changed
+duration, or were added or removed. Only platforms tested by both runs
+are compared. Differences which make the runs less comparable, such as
+different image versions, are listed as caveats.
+
+Either report may be the report.jsonl streamed while a run was going,
+e.g. if the run did not finish.`,
+		Run: runDiffResults,
+	}
+
+	diffJSON          bool
+	diffThreshold     float64
+	diffMinimumChange time.Duration
+)
+
+func init() {
+	root.AddCommand(cmdDiffResults)
+	cmdDiffResults.Flags().BoolVar(&diffJSON, "json", false, "output the differences as JSON")
+	cmdDiffResults.Flags().Float64Var(&diffThreshold, "duration-threshold", 0.5, "report duration changes larger than this fraction of the old duration")
+	cmdDiffResults.Flags().DurationVar(&diffMinimumChange, "min-duration-change", 30*time.Second, "ignore duration changes smaller than this")
+}
+
+func runDiffResults(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: kola diff-results old/report.json new/report.json\n")
+		os.Exit(2)
+	}
+
+	old, err := kola.ReadReport(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	new, err := kola.ReadReport(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	diff := kola.DiffReports(old, new, diffThreshold, diffMinimumChange)
+
+	if diffJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diff); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, c := range diff.Caveats {
+		fmt.Printf("NOTE: %s\n", c)
+	}
+	printChanges("Newly failing", diff.NewlyFailed, func(c kola.TestChange) string {
+		return fmt.Sprintf("was %s", c.OldResult)
+	})
+	printChanges("Fixed", diff.Fixed, nil)
+	printChanges("Newly skipped", diff.NewlySkipped, func(c kola.TestChange) string {
+		return fmt.Sprintf("was %s", c.OldResult)
+	})
+	printChanges("Duration changed", diff.DurationChanged, func(c kola.TestChange) string {
+		return fmt.Sprintf("%v -> %v", c.OldDuration.Round(time.Second), c.NewDuration.Round(time.Second))
+	})
+	printChanges("Added", diff.Added, func(c kola.TestChange) string {
+		return string(c.NewResult)
+	})
+	printChanges("Removed", diff.Removed, nil)
+}
+
+func printChanges(title string, changes []kola.TestChange, detail func(kola.TestChange) string) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Printf("\n%s (%d):\n", title, len(changes))
+	for _, c := range changes {
+		line := fmt.Sprintf("  %s on %s", c.Name, c.Platform)
+		if detail != nil {
+			line += ": " + detail(c)
+		}
+		fmt.Println(line)
+	}
+}
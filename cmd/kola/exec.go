@@ -0,0 +1,129 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/mantle/kola"
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+func init() {
+	root.AddCommand(newCmdExec(&execOptions{}))
+}
+
+// execOptions are the flags of exec.
+type execOptions struct {
+	nodeCount int
+	userData  string
+}
+
+// newCmdExec returns the command, binding its flags to opts.
+func newCmdExec(opts *execOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "exec [flags] -- command...",
+		Short: "Run a command on fresh CoreOS instances",
+		Long: `Run a command on fresh CoreOS instances and destroy them.
+
+The command runs through SSH on every instance in turn, with its output
+prefixed by the instance's ID. kola exits with status 1 if the command
+failed on any instance.`,
+		PreRun: preRun,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.validate(args); err != nil {
+				return err
+			}
+			failed, err := opts.run(os.Stdout, args)
+			if err != nil {
+				return err
+			}
+			if failed {
+				os.Exit(1)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVarP(&opts.nodeCount, "nodecount", "c", 1, "number of instances to run the command on")
+	cmd.Flags().StringVarP(&opts.userData, "userdata", "u", "", "file containing userdata to pass to the instances")
+	return cmd
+}
+
+func (o *execOptions) validate(args []string) error {
+	if len(args) == 0 {
+		return errors.New("no command to run")
+	}
+	if o.nodeCount <= 0 {
+		return errors.New("nodecount must be one or more")
+	}
+	return nil
+}
+
+// run runs the command args on o.nodeCount new machines, reporting
+// whether it failed on any.
+func (o *execOptions) run(stdout io.Writer, args []string) (bool, error) {
+	var userdata *conf.UserData
+	if o.userData != "" {
+		var err error
+		if userdata, err = kola.LoadUserData(o.userData); err != nil {
+			return false, fmt.Errorf("reading userdata: %v", err)
+		}
+	}
+
+	dir, err := kola.SetupOutputDir(outputDir, kolaPlatform)
+	if err != nil {
+		return false, err
+	}
+	c, err := kola.NewCluster(kolaPlatform, &platform.RuntimeConfig{
+		OutputDir:    dir,
+		StrictCrypto: kola.StrictCrypto,
+	})
+	if err != nil {
+		return false, fmt.Errorf("cluster failed: %v", err)
+	}
+	defer c.Destroy()
+
+	var machines []platform.Machine
+	for i := 0; i < o.nodeCount; i++ {
+		m, err := c.NewMachine(userdata)
+		if err != nil {
+			return false, fmt.Errorf("machine failed: %v", err)
+		}
+		machines = append(machines, m)
+	}
+
+	command := strings.Join(args, " ")
+	var failed bool
+	for _, m := range machines {
+		out, stderr, err := m.SSH(command)
+		if output := strings.TrimSuffix(string(out)+string(stderr), "\n"); output != "" {
+			for _, line := range strings.Split(output, "\n") {
+				fmt.Fprintf(stdout, "%s: %s\n", m.ID(), line)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "%s: %v\n", m.ID(), err)
+			failed = true
+		}
+	}
+	return failed, nil
+}
@@ -19,15 +19,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/coreos/pkg/capnslog"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/coreos/mantle/cli"
 	"github.com/coreos/mantle/kola"
-	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/system"
+	"github.com/coreos/mantle/version"
 
 	// register OS test suite
 	_ "github.com/coreos/mantle/kola/registry"
@@ -36,6 +38,11 @@ import (
 var (
 	plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "kola")
 
+	listJSON   bool
+	strict     bool
+	dryRun     bool
+	selectOpts selectionOptions // of run or list
+
 	root = &cobra.Command{
 		Use:   "kola [command]",
 		Short: "The CoreOS Superdeep Borehole",
@@ -43,28 +50,54 @@ var (
 	}
 
 	cmdRun = &cobra.Command{
-		Use:   "run [glob pattern]",
+		Use:   "run [pattern...]",
 		Short: "Run kola tests by category",
 		Long: `Run all kola tests (default) or related groups.
 
-If the glob pattern is exactly equal to the name of a single test, any
+Several platforms may be given as a comma-separated list, in which case
+each test runs once per platform it supports, concurrently when
+--parallel allows.
+
+Tests are selected by the patterns given, all by default: globs, or
+regular expressions if prefixed with re: or with --regex. Each matches
+whole, case-sensitive test names, and a test runs if any pattern matches
+it and no --skip pattern does.
+
+If a glob pattern is exactly equal to the name of a test, any
 restrictions on the versions of Container Linux supported by that test
 will be ignored.
+
+With --dry-run, the tests which would run on each platform are listed
+along with the firewall changes made for their required ports, and
+nothing is created. Like list, it doesn't check version restrictions.
 `,
 		Run:    runRun,
 		PreRun: preRun,
 	}
 
 	cmdList = &cobra.Command{
-		Use:   "list",
+		Use:   "list [pattern...]",
 		Short: "List kola test names",
-		Run:   runList,
+		Long: `List the registered tests selected by the patterns, all by default,
+which would run on the platforms given with --platform, or on any
+platform if it isn't given. Patterns select tests as they do for run.
+
+Unlike run, list doesn't check restrictions on the versions of Container
+Linux supported by tests.
+`,
+		Run: runList,
 	}
 )
 
 func init() {
 	root.AddCommand(cmdRun)
 	root.AddCommand(cmdList)
+	cmdList.Flags().BoolVar(&listJSON, "json", false, "output the test list as JSON")
+	for _, cmd := range []*cobra.Command{cmdRun, cmdList} {
+		selectOpts.addFlags(cmd.Flags())
+	}
+	cmdRun.Flags().BoolVar(&strict, "strict", false, "exit non-zero if any warnings or errors are logged")
+	cmdRun.Flags().BoolVar(&dryRun, "dry-run", false, "list the tests and firewall changes without running anything")
 }
 
 func main() {
@@ -75,7 +108,20 @@ func preRun(cmd *cobra.Command, args []string) {
 	err := syncOptions()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(3)
+		if cmd.Name() == "run" {
+			// run reports every way it ends in its result line
+			os.Exit(kola.UsageResult(err).Finish(os.Stdout))
+		}
+		os.Exit(kola.ExitUsage)
+	}
+
+	if strict {
+		cli.RecordWarnings()
+	}
+
+	// parallel runs hold many connections and files open
+	if _, err := system.RaiseFileLimit(); err != nil {
+		plog.Warningf("Raising the open file limit: %v", err)
 	}
 
 	// Packet uses storage, and storage talks too much.
@@ -87,37 +133,97 @@ func preRun(cmd *cobra.Command, args []string) {
 	}
 }
 
+// selectionOptions are the flags of commands which select tests with
+// pattern arguments.
+type selectionOptions struct {
+	skip  []string
+	regex bool
+}
+
+func (o *selectionOptions) addFlags(fs *pflag.FlagSet) {
+	fs.StringSliceVar(&o.skip, "skip", nil, "Leave out the tests matching this pattern. Specify multiple times for multiple patterns.")
+	fs.BoolVar(&o.regex, "regex", false, "Treat all patterns as regular expressions")
+}
+
+// selection returns the tests selected by the pattern arguments and
+// --skip, checking every pattern before anything is run.
+func (o *selectionOptions) selection(args []string) (*kola.Selection, error) {
+	return kola.NewSelection(args, o.skip, o.regex)
+}
+
 func runRun(cmd *cobra.Command, args []string) {
-	if len(args) > 1 {
-		fmt.Fprintf(os.Stderr, "Extra arguments specified. Usage: 'kola run [glob pattern]'\n")
-		os.Exit(2)
+	sel, err := selectOpts.selection(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(kola.UsageResult(err).Finish(os.Stdout))
 	}
-	var pattern string
-	if len(args) == 1 {
-		pattern = args[0]
-	} else {
-		pattern = "*" // run all tests by default
+
+	platforms := strings.Split(kolaPlatform, ",")
+
+	if dryRun {
+		if err := runDryRun(sel, platforms); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(kola.UsageResult(err).Finish(os.Stdout))
+		}
+		return
 	}
 
-	var err error
-	outputDir, err = kola.SetupOutputDir(outputDir, kolaPlatform)
+	defaultedOutput := outputDir == ""
+	outputDir, err = kola.SetupOutputDir(outputDir, strings.Join(platforms, "-"))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
+		os.Exit(kola.UsageResult(err).Finish(os.Stdout))
 	}
 
-	runErr := kola.RunTests(pattern, kolaPlatform, outputDir)
+	result := kola.RunTests(sel, platforms, outputDir)
+	if result.Err != nil {
+		plog.Errorf("%v", result.Err)
+	}
 
 	// needs to be after RunTests() because harness empties the directory
 	if err := writeProps(); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
+		result.Fail(err)
 	}
 
-	if runErr != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", runErr)
-		os.Exit(1)
+	// once the run has unlocked its directories, so it counts as a
+	// past run
+	if err := kola.Prune(runsDirs(defaultedOutput), kola.CacheDir, kola.Retention, os.Stdout); err != nil {
+		plog.Warningf("Pruning old runs: %v", err)
+	}
+
+	if warnings := cli.Warnings(); len(warnings) > 0 {
+		fmt.Fprintf(os.Stderr, "--strict: %d warnings or errors logged during the run:\n", len(warnings))
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "  %s\n", w)
+		}
+		result.Fail(fmt.Errorf("--strict: %d warnings or errors logged", len(warnings)))
+	}
+
+	os.Exit(result.Finish(os.Stdout))
+}
+
+// runDryRun prints the tests selected by sel which would run on each of
+// platforms, and how the platforms' firewalls are changed for them.
+func runDryRun(sel *kola.Selection, platforms []string) error {
+	entries, err := kola.List(sel, platforms)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Fprintln(w, "Test Name\tPlatform\tFirewall")
+	fmt.Fprintln(w, "\t")
+	for _, e := range entries {
+		for _, pltfrm := range e.RunsOn {
+			firewall := e.Firewall[pltfrm]
+			if firewall == "" {
+				firewall = "-"
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\n", e.Name, pltfrm, firewall)
+		}
 	}
+	return w.Flush()
 }
 
 func writeProps() error {
@@ -155,22 +261,29 @@ func writeProps() error {
 		ImageURL              string `json:"image"`
 	}
 	type QEMU struct {
-		Image string `json:"image"`
+		Image   string `json:"image"`
+		Version string `json:"version,omitempty"`
 	}
 	return enc.Encode(&struct {
-		Cmdline  []string `json:"cmdline"`
-		Platform string   `json:"platform"`
-		Board    string   `json:"board"`
-		AWS      AWS      `json:"aws"`
-		DO       DO       `json:"do"`
-		ESX      ESX      `json:"esx"`
-		GCE      GCE      `json:"gce"`
-		Packet   Packet   `json:"packet"`
-		QEMU     QEMU     `json:"qemu"`
+		Cmdline      []string `json:"cmdline"`
+		Platform     string   `json:"platform"`
+		Board        string   `json:"board"`
+		KolaCommit   string   `json:"kola_commit"`
+		KoletCommit  string   `json:"kolet_commit,omitempty"`
+		StrictCrypto bool     `json:"strict_crypto"`
+		AWS          AWS      `json:"aws"`
+		DO           DO       `json:"do"`
+		ESX          ESX      `json:"esx"`
+		GCE          GCE      `json:"gce"`
+		Packet       Packet   `json:"packet"`
+		QEMU         QEMU     `json:"qemu"`
 	}{
-		Cmdline:  os.Args,
-		Platform: kolaPlatform,
-		Board:    kola.QEMUOptions.Board,
+		Cmdline:      os.Args,
+		Platform:     kolaPlatform,
+		Board:        kola.QEMUOptions.Board,
+		KolaCommit:   version.Commit,
+		KoletCommit:  kola.KoletCommit,
+		StrictCrypto: kola.StrictCrypto,
 		AWS: AWS{
 			Region:       kola.AWSOptions.Region,
 			AMI:          kola.AWSOptions.AMI,
@@ -190,70 +303,78 @@ func writeProps() error {
 			MachineType: kola.GCEOptions.MachineType,
 		},
 		Packet: Packet{
-			Facility: kola.PacketOptions.Facility,
-			Plan:     kola.PacketOptions.Plan,
+			Facility:              kola.PacketOptions.Facility,
+			Plan:                  kola.PacketOptions.Plan,
 			InstallerImageBaseURL: kola.PacketOptions.InstallerImageBaseURL,
 			ImageURL:              kola.PacketOptions.ImageURL,
 		},
 		QEMU: QEMU{
-			Image: kola.QEMUOptions.DiskImage,
+			Image:   kola.QEMUOptions.DiskImage,
+			Version: qemuImageVersion,
 		},
 	})
 }
 
 func runList(cmd *cobra.Command, args []string) {
-	var w = tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
-	var testlist []item
+	sel, err := selectOpts.selection(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(kola.ExitUsage)
+	}
 
-	for name, test := range register.Tests {
-		testlist = append(testlist, item{
-			name,
-			test.Platforms,
-			test.ExcludePlatforms,
-			test.Architectures})
+	// without --platform, list the tests of every platform
+	platforms := kolaPlatforms
+	if root.PersistentFlags().Changed("platform") {
+		if err := syncOptions(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(kola.ExitUsage)
+		}
+		platforms = strings.Split(kolaPlatform, ",")
 	}
 
-	sort.Slice(testlist, func(i, j int) bool {
-		return testlist[i].Name < testlist[j].Name
-	})
+	entries, err := kola.List(sel, platforms)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 
-	fmt.Fprintln(w, "Test Name\tPlatforms\tArchitectures")
+	if listJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var w = tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Fprintln(w, "Test Name\tPlatforms\tArchitectures\tMachines\tNative")
 	fmt.Fprintln(w, "\t")
-	for _, item := range testlist {
-		fmt.Fprintf(w, "%v\n", item)
+	for _, e := range entries {
+		fmt.Fprintln(w, listLine(e, platforms))
 	}
 	w.Flush()
 }
 
-type item struct {
-	Name             string
-	Platforms        []string
-	ExcludePlatforms []string
-	Architectures    []string
-}
-
-func (i item) String() string {
-	if len(i.ExcludePlatforms) > 0 {
-		excludePlatforms := map[string]struct{}{}
-		for _, platform := range i.ExcludePlatforms {
-			excludePlatforms[platform] = struct{}{}
-		}
-		if len(i.Platforms) == 0 {
-			i.Platforms = kolaPlatforms
-		}
-		platforms := []string{}
-		for _, platform := range i.Platforms {
-			if _, ok := excludePlatforms[platform]; !ok {
-				platforms = append(platforms, platform)
-			}
-		}
-		i.Platforms = platforms
+// listLine formats e as a line of the kola list table, in which "all"
+// stands for every platform listed for.
+func listLine(e kola.ListEntry, platforms []string) string {
+	runsOn := e.RunsOn
+	if len(runsOn) == len(platforms) {
+		runsOn = []string{"all"}
+	}
+	architectures := e.Architectures
+	if len(architectures) == 0 {
+		architectures = []string{"all"}
 	}
-	if len(i.Platforms) == 0 {
-		i.Platforms = []string{"all"}
+	name := e.Name
+	if e.DestructiveHost {
+		name += " (host-destructive)"
 	}
-	if len(i.Architectures) == 0 {
-		i.Architectures = []string{"all"}
+	native := "-"
+	if len(e.NativeFuncs) > 0 {
+		native = strings.Join(e.NativeFuncs, ",")
 	}
-	return fmt.Sprintf("%v\t%v\t%v", i.Name, i.Platforms, i.Architectures)
+	return fmt.Sprintf("%v\t%v\t%v\t%v\t%v", name, runsOn, architectures, e.ClusterSize, native)
 }
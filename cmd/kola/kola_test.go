@@ -0,0 +1,170 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/coreos/mantle/harness/testresult"
+	"github.com/coreos/mantle/kola"
+)
+
+func TestSelectionFlags(t *testing.T) {
+	for _, cmd := range []*cobra.Command{cmdRun, cmdList} {
+		for _, name := range []string{"skip", "regex"} {
+			if cmd.Flags().Lookup(name) == nil {
+				t.Errorf("%s has no --%s", cmd.Name(), name)
+			}
+		}
+	}
+
+	parse := func(args ...string) *selectionOptions {
+		var opts selectionOptions
+		fs := pflag.NewFlagSet("run", pflag.ContinueOnError)
+		opts.addFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return &opts
+	}
+	if _, err := parse("--skip=[").selection([]string{"coreos.*"}); err == nil {
+		t.Error("invalid --skip pattern accepted")
+	}
+	sel, err := parse("--regex").selection([]string{"coreos\\.(basic|cluster)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sel.Match("coreos.cluster") || sel.Match("coreos.fleet") {
+		t.Errorf("--regex selection %v matches the wrong tests", sel)
+	}
+}
+
+func TestFlagSets(t *testing.T) {
+	platformFlags := pflag.NewFlagSet("platform", pflag.ContinueOnError)
+	addPlatformFlags(platformFlags)
+	runFlags := pflag.NewFlagSet("run", pflag.ContinueOnError)
+	addRunFlags(runFlags)
+
+	runFlags.VisitAll(func(f *pflag.Flag) {
+		if platformFlags.Lookup(f.Name) != nil {
+			t.Errorf("--%s is both a platform and a run flag", f.Name)
+		}
+	})
+	for _, name := range []string{"platform", "board", "gce-project", "qemu-image", "strict-crypto"} {
+		if platformFlags.Lookup(name) == nil {
+			t.Errorf("--%s is not a platform flag", name)
+		}
+	}
+	for _, name := range []string{"parallel", "tapfile", "fail-fast", "master-seed", "kolet"} {
+		if runFlags.Lookup(name) == nil {
+			t.Errorf("--%s is not a run flag", name)
+		}
+	}
+}
+
+func TestShuffleFlag(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		seed int64
+		ok   bool
+	}{
+		{[]string{"--shuffle"}, 0, true},
+		{[]string{"--shuffle=1234"}, 1234, true},
+		{[]string{"--shuffle=random"}, 0, true},
+		{[]string{"--shuffle=0"}, 0, false},
+		{[]string{"--shuffle=x"}, 0, false},
+	} {
+		var shuffle bool
+		var seed int64
+		fs := pflag.NewFlagSet("run", pflag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		addShuffleFlag(fs, &shuffle, &seed)
+		err := fs.Parse(tt.args)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%v accepted", tt.args)
+			}
+			continue
+		}
+		if err != nil || !shuffle || seed != tt.seed {
+			t.Errorf("%v set shuffle %v with seed %d, want seed %d: %v", tt.args, shuffle, seed, tt.seed, err)
+		}
+	}
+}
+
+func TestMergeResultsCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first, rerun, merged := filepath.Join(dir, "report.json"), filepath.Join(dir, "rerun.jsonl"), filepath.Join(dir, "merged.json")
+	if err := ioutil.WriteFile(first, []byte(`{"platform": "qemu", "version": "1800.0.0", "tests": [
+		{"name": "a", "result": "PASS"},
+		{"name": "b", "result": "FAIL"}
+	]}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(rerun, []byte(`{"name": "b", "result": "PASS", "platform": "qemu", "version": "1800.0.0"}`+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := newCmdMergeResults(&mergeOptions{})
+	cmd.SetArgs([]string{"-o", merged, first, rerun})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := kola.ReadReport(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Result != testresult.Pass || len(r.Tests) != 2 || r.Tests[1].Name != "b" || r.Tests[1].Result != testresult.Pass {
+		t.Errorf("unexpected merged report %+v", r)
+	}
+
+	cmd = newCmdMergeResults(&mergeOptions{})
+	cmd.SetOutput(ioutil.Discard)
+	cmd.SetArgs(nil)
+	if err := cmd.Execute(); err == nil {
+		t.Error("merged no reports")
+	}
+}
+
+func TestExecOptions(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		ok   bool
+	}{
+		{[]string{"--", "true"}, true},
+		{[]string{"-c", "3", "--", "systemctl", "is-system-running"}, true},
+		{nil, false},
+		{[]string{"-c", "0", "--", "true"}, false},
+	} {
+		var opts execOptions
+		cmd := newCmdExec(&opts)
+		if err := cmd.Flags().Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := opts.validate(cmd.Flags().Args()); (err == nil) != tt.ok {
+			t.Errorf("%v: got %v", tt.args, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/mantle/kola"
+)
+
+func init() {
+	root.AddCommand(newCmdMergeResults(&mergeOptions{}))
+}
+
+// mergeOptions are the flags of merge-results.
+type mergeOptions struct {
+	output string
+}
+
+// newCmdMergeResults returns the command, binding its flags to opts.
+func newCmdMergeResults(opts *mergeOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "merge-results report.json...",
+		Short: "Merge the results of several kola runs",
+		Long: `Merge the results of several kola runs into one report.
+
+Useful to combine the runs of shards of a test suite, or a run with a
+rerun of its failures. A test run on the same platform by several runs
+takes its result from the last report given. If the runs cover more
+than one platform, tests are named test/platform/subtest as in a
+multi-platform run.
+
+Any report may be the report.jsonl streamed while a run was going,
+e.g. if the run did not finish.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(os.Stdout, args)
+		},
+	}
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "", "write the merged report to this file rather than stdout")
+	return cmd
+}
+
+func (o *mergeOptions) run(stdout io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New("no reports to merge")
+	}
+	var reports []*kola.Report
+	for _, path := range args {
+		r, err := kola.ReadReport(path)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, r)
+	}
+	merged := kola.MergeReports(reports...)
+
+	w := stdout
+	if o.output != "" {
+		f, err := os.Create(o.output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(merged)
+}
@@ -16,19 +16,34 @@ package main
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
 
 	"github.com/coreos/mantle/auth"
 	"github.com/coreos/mantle/kola"
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/network"
 	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+	"github.com/coreos/mantle/platform/machine/qemu"
 	"github.com/coreos/mantle/sdk"
 )
 
 var (
 	outputDir          string
 	kolaPlatform       string
+	proxy              string
+	noProxy            string
+	debugSystemdUnits  []string
+	platformParallel   []string
 	defaultTargetBoard = sdk.DefaultBoard()
+	qemuImageVersion   string // from the version.txt next to --qemu-image
 	kolaPlatforms      = []string{"aws", "do", "esx", "gce", "packet", "qemu"}
 	kolaDefaultImages  = map[string]string{
 		"amd64-usr": sdk.BuildRoot() + "/images/amd64-usr/latest/coreos_production_image.bin",
@@ -42,19 +57,116 @@ var (
 )
 
 func init() {
-	sv := root.PersistentFlags().StringVar
-	bv := root.PersistentFlags().BoolVar
-	ss := root.PersistentFlags().StringSlice
+	addPlatformFlags(root.PersistentFlags())
+	addRunFlags(cmdRun.Flags())
+	addRetentionFlags(cmdRun.Flags())
+}
+
+// addRunFlags adds the flags only kola run has, which control how tests
+// are selected, run and reported, to fs.
+func addRunFlags(fs *pflag.FlagSet) {
+	sv := fs.StringVar
+	bv := fs.BoolVar
 
-	// general options
-	sv(&outputDir, "output-dir", "", "Temporary output directory for test data and logs")
 	sv(&kola.TorcxManifestFile, "torcx-manifest", "", "Path to a torcx manifest that should be made available to tests")
-	root.PersistentFlags().StringVarP(&kolaPlatform, "platform", "p", "qemu", "VM platform: "+strings.Join(kolaPlatforms, ", "))
-	root.PersistentFlags().IntVarP(&kola.TestParallelism, "parallel", "j", 1, "number of tests to run in parallel")
+	fs.IntVarP(&kola.TestParallelism, "parallel", "j", 1, "number of tests to run in parallel")
 	sv(&kola.TAPFile, "tapfile", "", "file to write TAP results to")
-	sv(&kola.Options.BaseName, "basename", "kola", "Cluster name prefix")
-	ss("debug-systemd-unit", []string{}, "full-unit-name.service to enable SYSTEMD_LOG_LEVEL=debug on. Specify multiple times for multiple units.")
+	sv(&kola.JUnitFile, "output-junit", "", "file to write JUnit XML results to, updated as tests finish")
 	sv(&kola.UpdatePayloadFile, "update-payload", "", "Path to an update payload that should be made available to tests")
+	sv(&kola.CacheDir, "cache-dir", "", "Record passing tests in this directory, keyed by image and test")
+	bv(&kola.UseCache, "use-cache", false, "Skip tests that have a cached pass in --cache-dir")
+	sv(&kola.HookExec, "hook-exec", "", "Executable run with a JSON event on stdin when clusters and tests start and finish")
+	bv(&kola.StrictHooks, "strict-hooks", false, "Fail tests whose hooks fail")
+	fs.DurationVar(&kola.MaxMachineLifetime, "max-machine-lifetime", 0, "Destroy machines older than this and flag their tests (0 for no limit)")
+	fs.DurationVar(&kola.SharedMachineLifetime, "shared-machine-lifetime", 0, "Destroy machines of shared clusters older than this (default 4 times --max-machine-lifetime)")
+	fs.DurationVar(&kola.ResourceSampleInterval, "resource-sample-interval", 20*time.Second, "How often to measure the CPU time and memory of machines run as host processes, such as qemu (0 to disable)")
+	fs.IntVar(&kola.MachineBackoff.Attempts, "machine-attempts", 3, "Attempts to start each machine of a test before failing it, unless its config is invalid")
+	fs.DurationVar(&kola.MachineBackoff.Base, "machine-backoff", 30*time.Second, "Delay before retrying to start a machine, doubled for each later attempt and jittered")
+	fs.DurationVar(&kola.RunTimeout, "run-timeout", 0, fmt.Sprintf("Fail the tests still running after this long and exit with status %d (0 for no limit)", kola.ExitDeadline))
+	fs.DurationVar(&kola.Timeouts.Test, "test-timeout", 0, fmt.Sprintf("Fail tests which take longer than this to set up and run, unless they set their own timeout (default %v)", cluster.DefaultTimeouts.Test))
+	fs.DurationVar(&kola.Timeouts.SSH, "ssh-timeout", 0, fmt.Sprintf("Fail commands tests run over SSH which take longer than this, or than what is left of the test timeout (default %v)", cluster.DefaultTimeouts.SSH))
+	fs.DurationVar(&kola.Timeouts.Native, "native-timeout", 0, fmt.Sprintf("Fail native functions which take longer than this, or than what is left of the test timeout (default %v)", cluster.DefaultTimeouts.Native))
+	sv(&kola.ConfigFormat, "config-format", "", "Render tests written against a config intent as cloud-config, ignition or all (default: platform default)")
+	bv(&kola.FailFast, "fail-fast", false, "Don't start any more tests once one has failed, other than on an experimental platform")
+	fs.StringSliceVar(&platformParallel, "platform-parallel", nil, "Limit on tests running on a platform at once, as <platform>=<n>, e.g. to stay within cloud quotas. Specify multiple times for multiple platforms.")
+	fs.StringSliceVar(&kola.ExperimentalPlatforms, "experimental-platform", nil, "Platform whose test failures are reported separately and don't fail the run. Specify multiple times for multiple platforms.")
+	sv(&kola.TriageRulesFile, "triage-rules", "", "YAML file of rules recognizing known causes of failures, added to the built-in ones")
+	fs.Int64Var(&kola.MasterSeed, "master-seed", 0, "Seed of the tests' randomness, to replay a run whose tests failed (default: random)")
+	fs.Int64Var(&kola.FaultSeed, "fault-inject", 0, "Inject failures into the platform layer as decided by this seed, to test the harness")
+	fs.MarkHidden("fault-inject")
+	addShuffleFlag(fs, &kola.Shuffle, &kola.ShuffleSeed)
+	bv(&kola.DebugInteractive, "debug-interactive", false, "Pause a single test at breakpoints and failed subtests to inspect its machines")
+	sv(&kola.EtcdVersion, "etcd-version", "", "Run etcd-member from this etcd release, e.g. 3.3.9, in tests which use it")
+	sv(&kola.ArtifactsDir, "artifacts-dir", "", "Write test artifacts under this directory, which may be shared between runs, instead of the output directory")
+	sv(&kola.RunID, "run-id", "", "Name of this run in the artifacts directory (default: generated from the time, host and process)")
+	bv(&kola.IncludeHostDestructive, "include-host-destructive", false, "Also run tests which change the state of the host running kola")
+	fs.StringSliceVar(&kola.Tags, "tag", nil, "Only run tests with this tag, on top of the pattern. Specify multiple times to run tests with any of the tags.")
+	fs.StringSliceVar(&kola.SkipTags, "skip-tag", nil, "Don't run tests with this tag. Specify multiple times for multiple tags.")
+	bv(&kola.AuditCleanup, "audit-cleanup", false, fmt.Sprintf("Compare the host's network links, namespaces, kola processes, temporary files and iptables chains before and after the run, and exit with status %d if anything was left behind", kola.ExitLeaked))
+	fs.StringSliceVar(&kola.AuditAllow, "audit-allow", nil, "Glob pattern of an item --audit-cleanup may find left behind, such as 'file /tmp/kola-cache*'. Specify multiple times for multiple patterns.")
+	sv(&kola.KoletPath, "kolet", "", "kolet binary to copy to machines for native functions (default: found next to kola or in $PATH)")
+	bv(&kola.AllowKoletSkew, "allow-kolet-skew", false, "Run native functions even if kolet was built from a different commit")
+	fs.Int64Var(&kola.ArtifactLimits.CommandOutput, "max-command-output", 64<<20, "Maximum bytes of stdout and stderr kept per SSH command (0 for unlimited)")
+	fs.Int64Var(&kola.ArtifactLimits.Journal, "max-journal-size", 256<<20, "Maximum bytes of journal kept per machine (0 for unlimited)")
+	fs.Int64Var(&kola.ArtifactLimits.Console, "max-console-size", 64<<20, "Maximum bytes of console output kept per machine (0 for unlimited)")
+	bv(&kola.ShareClusters, "share-clusters", false, "Let tests with the same platform, cluster size and config share clusters, reset between tests, instead of each creating its own")
+	fs.Int64Var(&kola.DiskLowWater, "disk-low-water", 2<<30, "Free bytes on the filesystems of the output, artifacts and temporary directories below which no more tests start and passed tests' artifacts are pruned (0 to disable)")
+}
+
+// addShuffleFlag adds --shuffle[=seed] to fs, setting shuffle and seed.
+func addShuffleFlag(fs *pflag.FlagSet, shuffle *bool, seed *int64) {
+	fs.Var(shuffleValue{shuffle, seed}, "shuffle", "Start the tests in a random order rather than sorted by name, shuffled with this seed to replay an order (default: random seed)")
+	fs.Lookup("shuffle").NoOptDefVal = "random"
+}
+
+// shuffleValue is the value of --shuffle[=seed]. Without a seed, or with
+// "random", the seed is left 0 for one to be picked.
+type shuffleValue struct {
+	shuffle *bool
+	seed    *int64
+}
+
+func (v shuffleValue) String() string {
+	if v.shuffle == nil || !*v.shuffle || *v.seed == 0 {
+		return ""
+	}
+	return strconv.FormatInt(*v.seed, 10)
+}
+
+func (shuffleValue) Type() string {
+	return "seed"
+}
+
+func (v shuffleValue) Set(value string) error {
+	var seed int64
+	if value != "random" {
+		var err error
+		if seed, err = strconv.ParseInt(value, 10, 64); err != nil || seed == 0 {
+			return fmt.Errorf("seed must be a non-zero integer or \"random\"")
+		}
+	}
+	*v.shuffle = true
+	*v.seed = seed
+	return nil
+}
+
+// addPlatformFlags adds the flags which choose and configure the
+// platforms machines are created on, shared by every command creating
+// them, to fs.
+func addPlatformFlags(fs *pflag.FlagSet) {
+	sv := fs.StringVar
+	bv := fs.BoolVar
+
+	// general options
+	sv(&outputDir, "output-dir", "", "Temporary output directory for test data and logs")
+	fs.StringVarP(&kolaPlatform, "platform", "p", "qemu", "VM platform, or comma-separated platforms for run: "+strings.Join(kolaPlatforms, ", "))
+	sv(&kola.Options.BaseName, "basename", "kola", "Cluster name prefix")
+	sv(&kola.Options.DiscoveryURL, "discovery-url", "", "Base URL of the etcd discovery service used on cloud platforms (default "+platform.DefaultDiscoveryURL+")")
+	sv(&proxy, "proxy", "", "Proxy URL for HTTP and HTTPS requests, overriding HTTP_PROXY and HTTPS_PROXY")
+	sv(&noProxy, "no-proxy", "", "Comma-separated hosts not to proxy, overriding NO_PROXY")
+	fs.StringSliceVar(&debugSystemdUnits, "debug-systemd-unit", []string{}, "full-unit-name.service to enable SYSTEMD_LOG_LEVEL=debug on. Specify multiple times for multiple units.")
+	sv(&kola.ConfigDir, "config-dir", "", "Resolve relative paths to config files, such as --userdata and test config files, against this directory")
+	bv(&kola.StrictCrypto, "strict-crypto", false, "Only use FIPS 140-2 approved SSH keys and algorithms")
 
 	// aws-specific options
 	defaultRegion := os.Getenv("AWS_REGION")
@@ -106,24 +218,90 @@ func init() {
 
 	// QEMU-specific options
 	sv(&kola.QEMUOptions.Board, "board", defaultTargetBoard, "target board")
-	sv(&kola.QEMUOptions.DiskImage, "qemu-image", "", "path to CoreOS disk image")
+	sv(&kola.QEMUOptions.DiskImage, "qemu-image", "", "path to CoreOS disk image, or to a build directory holding one")
 	sv(&kola.QEMUOptions.BIOSImage, "qemu-bios", "", "BIOS to use for QEMU vm")
+	sv(&kola.QEMUOptions.Subnet, "qemu-subnet", "", "IPv4 /16 for QEMU machines (default: first private /16 not used by the host)")
+	sv(&kola.QEMUOptions.AdoptRun, "adopt-run", "", "reuse the QEMU machines left running by the killed run with this ID instead of booting fresh ones (development only)")
+	bv(&kola.QEMUOptions.KeepForAdoption, "keep-for-adoption", false, "record QEMU machines so that --adopt-run can reuse them should this run be killed (development only)")
 }
 
 // Sync up the command line options if there is dependency
 func syncOptions() error {
+	if err := network.SetProxy(proxy, noProxy); err != nil {
+		return err
+	}
+
 	kola.PacketOptions.Board = kola.QEMUOptions.Board
 	kola.PacketOptions.GSOptions = &kola.GCEOptions
 
-	ok := false
-	for _, platform := range kolaPlatforms {
-		if platform == kolaPlatform {
-			ok = true
-			break
+	for _, requested := range strings.Split(kolaPlatform, ",") {
+		ok := false
+		for _, platform := range kolaPlatforms {
+			if platform == requested {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("unsupport platform %q", requested)
 		}
 	}
-	if !ok {
-		return fmt.Errorf("unsupport platform %q", kolaPlatform)
+
+	for _, experimental := range kola.ExperimentalPlatforms {
+		found := false
+		for _, platform := range kolaPlatforms {
+			if platform == experimental {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unsupport platform %q", experimental)
+		}
+	}
+
+	limits, err := kola.ParsePlatformParallelism(platformParallel)
+	if err != nil {
+		return err
+	}
+	for limited := range limits {
+		found := false
+		for _, platform := range kolaPlatforms {
+			if platform == limited {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unsupport platform %q", limited)
+		}
+	}
+	kola.PlatformParallelism = limits
+
+	if kola.ConfigFormat != "" && kola.ConfigFormat != "all" {
+		if _, err := conf.ParseFormat(kola.ConfigFormat); err != nil {
+			return err
+		}
+	}
+
+	if kola.EtcdVersion != "" {
+		if _, err := kola.ParseEtcdVersion(kola.EtcdVersion); err != nil {
+			return err
+		}
+	}
+
+	if u := kola.Options.DiscoveryURL; u != "" {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("--discovery-url %q is not an http or https URL", u)
+		}
+	}
+
+	if err := kola.Retention.Check(); err != nil {
+		return err
+	}
+
+	if kola.UseCache && kola.CacheDir == "" {
+		return fmt.Errorf("--use-cache requires --cache-dir")
 	}
 
 	image, ok := kolaDefaultImages[kola.QEMUOptions.Board]
@@ -134,12 +312,23 @@ func syncOptions() error {
 	if kola.QEMUOptions.DiskImage == "" {
 		kola.QEMUOptions.DiskImage = image
 	}
+	resolved, err := qemu.ResolveDiskImage(kola.QEMUOptions.DiskImage)
+	if err != nil {
+		return err
+	}
+	if resolved != kola.QEMUOptions.DiskImage {
+		plog.Infof("Using %s from build directory %s", filepath.Base(resolved), kola.QEMUOptions.DiskImage)
+		kola.QEMUOptions.DiskImage = resolved
+	}
+	// the SDK writes version.txt next to every image it builds
+	if ver, err := sdk.VersionsFromDir(filepath.Dir(kola.QEMUOptions.DiskImage)); err == nil {
+		qemuImageVersion = ver.Version
+	}
 
 	if kola.QEMUOptions.BIOSImage == "" {
 		kola.QEMUOptions.BIOSImage = kolaDefaultBIOS[kola.QEMUOptions.Board]
 	}
-	units, _ := root.PersistentFlags().GetStringSlice("debug-systemd-units")
-	for _, unit := range units {
+	for _, unit := range debugSystemdUnits {
 		kola.Options.SystemdDropins = append(kola.Options.SystemdDropins, platform.SystemdDropin{
 			Unit:     unit,
 			Name:     "10-debug.conf",
@@ -0,0 +1,88 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/coreos/mantle/kola"
+)
+
+var (
+	cmdPrune = &cobra.Command{
+		Run:   runPrune,
+		Use:   "prune [dir...]",
+		Short: "Remove the artifacts of old runs and least recently used cache entries",
+		Long: `Remove the artifacts of old runs and least recently used cache entries.
+
+The runs in each directory given, by default _kola_temp and the
+--artifacts-dir, are pruned newest first: the last --keep-runs runs are
+kept whole, the failed tests of the last --keep-failed-runs runs are
+kept, and older runs are removed. The --cache-dir is pruned of its least
+recently used entries down to --max-cache-size. kola run does the same
+when it finishes.
+
+Runs and caches in use by a kola process are never touched. Directories
+of runs by kola versions which didn't lock them aren't recognized as
+runs and are left alone.`,
+	}
+)
+
+func init() {
+	addRetentionFlags(cmdPrune.Flags())
+	cmdPrune.Flags().StringVar(&kola.ArtifactsDir, "artifacts-dir", "", "Directory of the artifacts of runs to prune, along with _kola_temp")
+	cmdPrune.Flags().StringVar(&kola.CacheDir, "cache-dir", "", "Cache directory to prune")
+	root.AddCommand(cmdPrune)
+}
+
+// addRetentionFlags adds the flags setting kola.Retention to fs.
+func addRetentionFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&kola.Retention.KeepRuns, "keep-runs", kola.Retention.KeepRuns, "Number of the latest runs whose artifacts are all kept (0 to keep every run)")
+	fs.IntVar(&kola.Retention.KeepFailedRuns, "keep-failed-runs", kola.Retention.KeepFailedRuns, "Number of the latest runs whose failed tests' artifacts are kept; older runs are removed")
+	fs.Int64Var(&kola.Retention.MaxCacheSize, "max-cache-size", kola.Retention.MaxCacheSize, "Maximum bytes of the cache directory, evicting the least recently used entries (0 for unlimited)")
+}
+
+// runsDirs returns the directories holding the runs to prune after a run:
+// the artifacts directory, and the directory of the output directories
+// if the run's was defaulted.
+func runsDirs(defaultedOutput bool) []string {
+	var dirs []string
+	if defaultedOutput {
+		dirs = append(dirs, kola.DefaultOutputBaseDir)
+	}
+	if kola.ArtifactsDir != "" {
+		dirs = append(dirs, kola.ArtifactsDir)
+	}
+	return dirs
+}
+
+func runPrune(cmd *cobra.Command, args []string) {
+	if err := kola.Retention.Check(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(kola.ExitUsage)
+	}
+	dirs := args
+	if len(dirs) == 0 {
+		dirs = runsDirs(true)
+	}
+	if err := kola.Prune(dirs, kola.CacheDir, kola.Retention, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -94,11 +94,10 @@ func doSpawn(cmd *cobra.Command, args []string) error {
 
 	var userdata *conf.UserData
 	if spawnUserData != "" {
-		userbytes, err := ioutil.ReadFile(spawnUserData)
+		userdata, err = kola.LoadUserData(spawnUserData)
 		if err != nil {
 			return fmt.Errorf("Reading userdata failed: %v", err)
 		}
-		userdata = conf.Unknown(string(userbytes))
 	}
 	if spawnSetSSHKeys {
 		if userdata == nil {
@@ -121,6 +120,7 @@ func doSpawn(cmd *cobra.Command, args []string) error {
 	cluster, err := kola.NewCluster(kolaPlatform, &platform.RuntimeConfig{
 		OutputDir:        outputDir,
 		AllowFailedUnits: true,
+		StrictCrypto:     kola.StrictCrypto,
 	})
 	if err != nil {
 		return fmt.Errorf("Cluster failed: %v", err)
@@ -145,7 +145,7 @@ func doSpawn(cmd *cobra.Command, args []string) error {
 		if err := qc.OmahaServer.AddPackage(updatePayload, "update.gz"); err != nil {
 			return fmt.Errorf("bad payload: %v", err)
 		}
-		updateConf = strings.NewReader("GROUP=developer\nSERVER=http://10.0.0.1:34567/v1/update/\n")
+		updateConf = strings.NewReader(fmt.Sprintf("GROUP=developer\nSERVER=http://%s:34567/v1/update/\n", qc.GatewayIP()))
 	}
 
 	var someMach platform.Machine
@@ -188,6 +188,12 @@ func doSpawn(cmd *cobra.Command, args []string) error {
 		someMach = mach
 	}
 
+	if sc, ok := cluster.(interface {
+		SSHConfigPath() string
+	}); ok && spawnVerbose {
+		fmt.Printf("Connect with: ssh -F %s %v\n", sc.SSHConfigPath(), someMach.ID())
+	}
+
 	if spawnShell {
 		if spawnRemove {
 			reader := strings.NewReader(`PS1="\[\033[0;31m\][bound]\[\033[0m\] $PS1"` + "\n")
@@ -55,8 +55,9 @@ sudo kola updatepayload
 )
 
 type userdataParams struct {
-	Port int
-	Keys []*agent.Key
+	Gateway net.IP
+	Port    int
+	Keys    []*agent.Key
 }
 
 // The user data is a bash script executed by cloudinit to ensure
@@ -75,7 +76,7 @@ trap do_ssh_keys EXIT
 # update atomicly so nothing reading update.conf fails
 cat >/etc/coreos/update.conf.new <<EOF
 GROUP=developer
-SERVER=http://10.0.0.1:{{printf "%d" .Port}}/v1/update/
+SERVER=http://{{.Gateway}}:{{printf "%d" .Port}}/v1/update/
 EOF
 mv /etc/coreos/update.conf{.new,}
 
@@ -138,7 +139,8 @@ func runUpdateTest() error {
 	}
 
 	cluster, err := qemu.NewCluster(&kola.QEMUOptions, &platform.RuntimeConfig{
-		OutputDir: outputDir,
+		OutputDir:    outputDir,
+		StrictCrypto: kola.StrictCrypto,
 	})
 	if err != nil {
 		return fmt.Errorf("new cluster: %v", err)
@@ -250,8 +252,9 @@ func newUserdata(qc *qemu.Cluster) (*conf.UserData, error) {
 	}
 
 	params := userdataParams{
-		Port: qc.OmahaServer.Addr().(*net.TCPAddr).Port,
-		Keys: keys,
+		Gateway: qc.GatewayIP(),
+		Port:    qc.OmahaServer.Addr().(*net.TCPAddr).Port,
+		Keys:    keys,
 	}
 	tmpl, err := template.New("userdata").Parse(userdataTmpl)
 	if err != nil {
@@ -15,12 +15,17 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/coreos/pkg/capnslog"
 	"github.com/spf13/cobra"
 
 	"github.com/coreos/mantle/cli"
+	"github.com/coreos/mantle/kola/native"
 	"github.com/coreos/mantle/kola/register"
 
 	// Register any tests that we may wish to execute in kolet.
@@ -41,6 +46,18 @@ var (
 		Short: "Run a given test's native function",
 		Run:   run,
 	}
+
+	cmdCall = &cobra.Command{
+		Use:   "call [test] [func]",
+		Short: "Call a given test's native function with JSON on stdin, printing its result as JSON",
+		Run:   run,
+	}
+
+	cmdLib = &cobra.Command{
+		Use:   "lib [func] [args...]",
+		Short: "Run a library function, printing its result as JSON",
+		Run:   run,
+	}
 )
 
 func run(cmd *cobra.Command, args []string) {
@@ -49,7 +66,7 @@ func run(cmd *cobra.Command, args []string) {
 }
 
 func main() {
-	for testName, testObj := range register.Tests {
+	for testName, testObj := range register.All() {
 		if len(testObj.NativeFuncs) == 0 {
 			continue
 		}
@@ -78,7 +95,64 @@ func main() {
 		}
 		cmdRun.AddCommand(testCmd)
 	}
+	for testName, testObj := range register.All() {
+		if len(testObj.NativeCalls) == 0 {
+			continue
+		}
+		testCmd := &cobra.Command{
+			Use: testName + " [func]",
+			Run: run,
+		}
+		for callName, callFunc := range testObj.NativeCalls {
+			callFunc := callFunc
+			testCmd.AddCommand(&cobra.Command{
+				Use: callName,
+				Run: func(cmd *cobra.Command, args []string) {
+					if len(args) != 0 {
+						cmd.Usage()
+						os.Exit(2)
+					}
+					var result native.Result
+					if in, err := ioutil.ReadAll(os.Stdin); err != nil {
+						result.Error = fmt.Sprintf("reading argument: %v", err)
+					} else {
+						result = native.Call(callFunc, in)
+					}
+					if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+						plog.Fatal(err)
+					}
+					os.Exit(0)
+				},
+			})
+		}
+		cmdCall.AddCommand(testCmd)
+	}
+	for libName, libFunc := range native.Funcs {
+		libFunc := libFunc
+		cmdLib.AddCommand(&cobra.Command{
+			Use: strings.Join(append([]string{libName}, libFunc.Args...), " "),
+			Run: func(cmd *cobra.Command, args []string) {
+				if len(args) != len(libFunc.Args) {
+					cmd.Usage()
+					os.Exit(2)
+				}
+				var result native.Result
+				value, err := libFunc.Run(args...)
+				if err != nil {
+					result.Error = err.Error()
+				} else {
+					result.Value = value
+				}
+				if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+					plog.Fatal(err)
+				}
+				os.Exit(0)
+			},
+		})
+	}
 	root.AddCommand(cmdRun)
+	root.AddCommand(cmdCall)
+	root.AddCommand(cmdLib)
 
 	cli.Execute(root)
 }
@@ -60,7 +60,7 @@ func runCreate(cmd *cobra.Command, args []string) {
 
 	var vms []*compute.Instance
 	for i := 0; i < createNumInstances; i++ {
-		vm, err := api.CreateInstance(cloudConfig, nil)
+		vm, err := api.CreateInstance("", cloudConfig, nil)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed creating vm: %v\n", err)
 			os.Exit(1)
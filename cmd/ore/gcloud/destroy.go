@@ -52,14 +52,24 @@ func runDestroy(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	var count int
+	// deletions are throttled by the API, so queue them all at once
+	errs := make(chan error, len(vms))
 	for _, vm := range vms {
-		if err := api.TerminateInstance(vm.Name); err != nil {
+		go func(name string) {
+			errs <- api.TerminateInstance(name)
+		}(vm.Name)
+	}
+
+	var failed int
+	for range vms {
+		if err := <-errs; err != nil {
 			fmt.Fprintf(os.Stderr, "Failed destroying vm: %v\n", err)
-			os.Exit(1)
+			failed++
 		}
-		count++
 	}
 
-	fmt.Printf("%v instance(s) scheduled for deletion\n", count)
+	fmt.Printf("%v instance(s) deleted\n", len(vms)-failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
 }
@@ -70,12 +70,23 @@ type H struct {
 	sub      []*H      // Queue of subtests to be run in parallel.
 
 	isParallel bool
+	nonFatal   bool // failures don't fail the parent; guarded by mu
+	abandoned  bool // timed out, the test function may still run; guarded by mu
+
+	limit time.Duration // set by Timeout
+
+	annotations map[string]interface{} // Extra data for reporters.
+	cleanups    []func()               // Registered by Cleanup, run in reverse.
+	cleanedUp   bool                   // cleanups have run; guarded by mu
 
 	reporters reporters.Reporters
 }
 
 func (c *H) parentContext() context.Context {
 	if c == nil || c.parent == nil || c.parent.ctx == nil {
+		if c != nil && c.suite != nil && c.suite.ctx != nil {
+			return c.suite.ctx
+		}
 		return context.Background()
 	}
 	return c.parent.ctx
@@ -175,9 +186,75 @@ func (c *H) setRan() {
 	c.ran = true
 }
 
+// Annotate attaches a piece of structured data to the test's result.
+// Reporters that support it, such as the JSON reporter, include the
+// annotations in their output. Annotating an existing key replaces it.
+// Annotate may be called simultaneously from multiple goroutines.
+func (c *H) Annotate(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.annotations == nil {
+		c.annotations = make(map[string]interface{})
+	}
+	c.annotations[key] = value
+}
+
+// Cleanup registers f to be called after the test and all its subtests
+// have completed, even if the test failed. Cleanup functions run in the
+// reverse order they were registered, and may report failures with the
+// Log and Error methods. A parallel test keeps its place among those
+// allowed to run at once until they return. If the cleanup functions
+// have already run, as when a test function which timed out carries on,
+// f is called at once.
+func (c *H) Cleanup(f func()) {
+	c.mu.Lock()
+	if c.cleanedUp {
+		c.mu.Unlock()
+		f()
+		return
+	}
+	defer c.mu.Unlock()
+	c.cleanups = append(c.cleanups, f)
+}
+
+// runCleanups calls the registered cleanup functions, last first. Each one
+// runs in its own goroutine so that one calling FailNow cannot prevent the
+// rest from running.
+func (c *H) runCleanups() {
+	for {
+		var f func()
+		c.mu.Lock()
+		if n := len(c.cleanups); n > 0 {
+			f = c.cleanups[n-1]
+			c.cleanups = c.cleanups[:n-1]
+		} else {
+			c.cleanedUp = true
+		}
+		c.mu.Unlock()
+		if f == nil {
+			return
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f()
+		}()
+		<-done
+	}
+}
+
 // Fail marks the function as having failed but continues execution.
 func (c *H) Fail() {
-	if c.parent != nil {
+	c.mu.RLock()
+	nonFatal := c.nonFatal
+	late := c.abandoned && c.done
+	c.mu.RUnlock()
+	if late {
+		// the result of a test which timed out is already reported
+		return
+	}
+	if c.parent != nil && !nonFatal {
 		c.parent.Fail()
 	}
 	c.mu.Lock()
@@ -189,6 +266,15 @@ func (c *H) Fail() {
 	c.failed = true
 }
 
+// NonFatal keeps failures of the test and its subtests from failing its
+// parent, and so the suite. The test itself is still reported as failed.
+// It is meant for results which are collected but not yet relied upon.
+func (c *H) NonFatal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nonFatal = true
+}
+
 // Failed reports whether the function has failed.
 func (c *H) Failed() bool {
 	c.mu.RLock()
@@ -228,6 +314,87 @@ func (c *H) FailNow() {
 	runtime.Goexit()
 }
 
+// Timeout runs f, the rest of the test function, in a new goroutine and
+// waits at most d for it to return. If f takes longer the test fails
+// with a timeout error, its context is cancelled and it ends, running
+// its cleanups, while f may still be running; failures f reports after
+// the test has completed are dropped. f may call FailNow, SkipNow and
+// their variants, which end the test as usual, but not Parallel.
+// Timeout must be called from the goroutine running the test function.
+//
+// The test's context gets the deadline, so it bounds whatever f runs,
+// including subtests. If the context already has an earlier deadline,
+// e.g. from a Timeout of an enclosing test, that deadline applies.
+func (c *H) Timeout(d time.Duration, f func()) {
+	deadline := time.Now().Add(d)
+	reason := fmt.Sprintf("test timed out after %v", d)
+	if outer, ok := c.ctx.Deadline(); ok && outer.Before(deadline) {
+		reason = fmt.Sprintf("test timed out after %v, when %s expired", time.Until(outer).Round(time.Millisecond), c.describeOuterLimit())
+		deadline = outer
+	}
+	ctx, cancel := context.WithDeadline(c.ctx, deadline)
+	defer cancel()
+	c.ctx = ctx
+	c.limit = d
+
+	done := make(chan struct{})
+	var returned bool
+	var panicked interface{}
+	go func() {
+		defer close(done)
+		defer func() {
+			panicked = recover()
+		}()
+		f()
+		returned = true
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-done:
+		if panicked != nil {
+			panic(panicked)
+		}
+		if !returned {
+			// f called FailNow or SkipNow
+			runtime.Goexit()
+		}
+	case <-timer.C:
+		c.mu.Lock()
+		c.abandoned = true
+		c.mu.Unlock()
+		cancel()
+		c.Fatal(reason)
+	}
+}
+
+// describeOuterLimit names the limit of an enclosing test or, if there
+// is none, of the suite's context.
+func (c *H) describeOuterLimit() string {
+	for h := c.parent; h != nil; h = h.parent {
+		if h.limit > 0 {
+			return fmt.Sprintf("the %v limit of %s", h.limit, h.name)
+		}
+	}
+	return "the run's deadline"
+}
+
+// Limit returns the limit set with Timeout on the test or the nearest
+// enclosing test which set one, and the deadline of the test's context,
+// which an earlier enclosing limit may have brought forward. ok is false
+// if the test's context has no deadline.
+func (c *H) Limit() (limit time.Duration, deadline time.Time, ok bool) {
+	for h := c; h != nil; h = h.parent {
+		if h.limit > 0 {
+			limit = h.limit
+			break
+		}
+	}
+	deadline, ok = c.ctx.Deadline()
+	return limit, deadline, ok
+}
+
 // log generates the output. It's always at the same stack depth.
 func (c *H) log(s string) {
 	c.mu.Lock()
@@ -235,6 +402,14 @@ func (c *H) log(s string) {
 	c.logger.Output(3, s)
 }
 
+// Output returns a copy of what the test and its finished subtests have
+// logged so far.
+func (c *H) Output() []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]byte(nil), c.output.Bytes()...)
+}
+
 // Log formats its arguments using default formatting, analogous to Println,
 // and records the text in the error log. The text will be printed only if
 // the test fails or the -harness.v flag is set.
@@ -398,6 +573,7 @@ func tRunner(t *H, fn func(t *H)) {
 		}
 		if err != nil {
 			t.Fail()
+			t.runCleanups()
 			t.report()
 			panic(err)
 		}
@@ -412,14 +588,21 @@ func tRunner(t *H, fn func(t *H)) {
 			for _, sub := range t.sub {
 				<-sub.signal
 			}
+			t.runCleanups()
 			if !t.isParallel {
 				// Reacquire the count for sequential tests. See comment in Run.
 				t.suite.waitParallel()
 			}
-		} else if t.isParallel {
-			// Only release the count for this test if it was run as a parallel
-			// test. See comment in Run method.
-			t.suite.release()
+		} else {
+			// Clean up before giving up the count, so that what the
+			// test holds, such as machines, counts against the
+			// parallelism until it is released.
+			t.runCleanups()
+			if t.isParallel {
+				// Only release the count for this test if it was run as a
+				// parallel test. See comment in Run method.
+				t.suite.release()
+			}
 		}
 		t.report() // Report after all subtests have finished.
 
@@ -500,7 +683,13 @@ func (t *H) report() {
 	// could also write verbosely to the 'reporter sink'.  I'm fine with
 	// this being a TODO if you don't want to tackle it in this initial
 	// PR.
-	t.reporters.ReportTest(t.name, status, t.duration, t.output.Bytes())
+	t.mu.RLock()
+	annotations := make(map[string]interface{}, len(t.annotations))
+	for k, v := range t.annotations {
+		annotations[k] = v
+	}
+	t.mu.RUnlock()
+	t.reporters.ReportTest(t.name, status, t.duration, t.output.Bytes(), annotations)
 }
 
 // CleanOutputDir creates/empties an output directory and returns the cleaned path.
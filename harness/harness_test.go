@@ -17,6 +17,7 @@ package harness
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -25,6 +26,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -487,3 +489,281 @@ func TestTempFile(t *testing.T) {
 		t.Errorf("%q missing %q prefix", second, "second")
 	}
 }
+
+func TestCleanup(t *testing.T) {
+	var order []string
+	var mu sync.Mutex
+	record := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, s)
+	}
+
+	suite := NewSuite(Options{}, Tests{
+		"Cleanup": func(h *H) {
+			h.Cleanup(func() { record("first") })
+			h.Cleanup(func() {
+				record("second")
+				h.FailNow()
+			})
+			h.Run("sub", func(h *H) {
+				h.Parallel()
+				record("sub")
+			})
+			record("test")
+			h.Fatal("failing")
+		},
+	})
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != SuiteFailed {
+		t.Log("\n" + buf.String())
+		t.Errorf("expected SuiteFailed, got %v", err)
+	}
+
+	expect := []string{"test", "sub", "second", "first"}
+	if !reflect.DeepEqual(order, expect) {
+		t.Errorf("%v != %v", order, expect)
+	}
+}
+
+func TestParallelCleanup(t *testing.T) {
+	// each test holds its "cluster" until its cleanup, which must count
+	// against the parallelism
+	var alive, maxAlive int32
+	test := func(h *H) {
+		h.Parallel()
+		n := atomic.AddInt32(&alive, 1)
+		for {
+			max := atomic.LoadInt32(&maxAlive)
+			if n <= max || atomic.CompareAndSwapInt32(&maxAlive, max, n) {
+				break
+			}
+		}
+		h.Cleanup(func() {
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&alive, -1)
+		})
+	}
+	suite := NewSuite(Options{Parallel: 1}, Tests{
+		"A": test,
+		"B": test,
+		"C": test,
+	})
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != nil {
+		t.Log("\n" + buf.String())
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if maxAlive != 1 {
+		t.Errorf("%d parallel tests alive at once with Parallel 1", maxAlive)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	var output string
+	suite := NewSuite(Options{}, Tests{
+		"Output": func(h *H) {
+			h.Cleanup(func() { output = string(h.Output()) })
+			h.Run("sub", func(h *H) {
+				h.Error("sub failing")
+			})
+			h.Log("parent logging")
+		},
+	})
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != SuiteFailed {
+		t.Log("\n" + buf.String())
+		t.Errorf("expected SuiteFailed, got %v", err)
+	}
+	for _, want := range []string{"sub failing", "parent logging"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output lacks %q:\n%s", want, output)
+		}
+	}
+}
+
+func TestSuiteContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	suite := NewSuite(Options{}, Tests{
+		"SuiteContext": func(h *H) {
+			h.Run("sub", func(h *H) {
+				if err := h.Context().Err(); err != context.Canceled {
+					h.Errorf("expected context.Canceled, got %v", err)
+				}
+			})
+		},
+	})
+	suite.ctx = ctx
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != nil {
+		t.Log("\n" + buf.String())
+		t.Error(err)
+	}
+}
+
+func TestNonFatal(t *testing.T) {
+	var parentFailed, subFailed bool
+	suite := NewSuite(Options{Verbose: true}, Tests{
+		"NonFatal": func(h *H) {
+			h.Run("experimental", func(h *H) {
+				h.NonFatal()
+				h.Run("sub", func(h *H) {
+					h.Error("failing")
+				})
+				subFailed = h.Failed()
+			})
+			h.Run("passing", func(h *H) {})
+			parentFailed = h.Failed()
+		},
+	})
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != nil {
+		t.Log("\n" + buf.String())
+		t.Errorf("expected the suite to pass, got %v", err)
+	}
+	if !subFailed {
+		t.Error("non-fatal test not marked failed")
+	}
+	if parentFailed {
+		t.Error("failure of non-fatal test reached its parent")
+	}
+	if !strings.Contains(buf.String(), "--- FAIL: NonFatal/experimental") {
+		t.Errorf("non-fatal failure not reported:\n%s", buf)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	hung := make(chan struct{})
+	late := make(chan struct{})
+	var cleaned, lateCleaned, cancelled, afterFatal, afterHang int32
+	suite := NewSuite(Options{}, Tests{
+		"Hung": func(h *H) {
+			h.Cleanup(func() { atomic.StoreInt32(&cleaned, 1) })
+			h.Timeout(10*time.Millisecond, func() {
+				<-hung
+				if h.Context().Err() != nil {
+					atomic.StoreInt32(&cancelled, 1)
+				}
+				// reported after the test completed
+				h.Error("late failure")
+				h.Cleanup(func() { atomic.StoreInt32(&lateCleaned, 1) })
+				close(late)
+			})
+			atomic.StoreInt32(&afterHang, 1)
+		},
+		"Fatal": func(h *H) {
+			h.Timeout(time.Minute, func() {
+				h.Fatal("failing")
+			})
+			atomic.StoreInt32(&afterFatal, 1)
+		},
+		"Quick": func(h *H) {
+			h.Timeout(time.Minute, func() {})
+		},
+	})
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != SuiteFailed {
+		t.Errorf("expected SuiteFailed, got %v", err)
+	}
+	close(hung)
+	<-late
+
+	out := buf.String()
+	if !strings.Contains(out, "--- FAIL: Hung") || !strings.Contains(out, "test timed out after 10ms") {
+		t.Errorf("timeout not reported:\n%s", out)
+	}
+	if !strings.Contains(out, "--- FAIL: Fatal") {
+		t.Errorf("failure in f not reported:\n%s", out)
+	}
+	if strings.Contains(out, "--- FAIL: Quick") {
+		t.Errorf("test finishing in time failed:\n%s", out)
+	}
+	if atomic.LoadInt32(&cleaned) == 0 {
+		t.Error("cleanups of a timed out test did not run")
+	}
+	if atomic.LoadInt32(&lateCleaned) == 0 {
+		t.Error("cleanup registered after the test completed did not run")
+	}
+	if atomic.LoadInt32(&cancelled) == 0 {
+		t.Error("context of a timed out test not cancelled")
+	}
+	if atomic.LoadInt32(&afterHang) != 0 {
+		t.Error("test function continued after timing out")
+	}
+	if atomic.LoadInt32(&afterFatal) != 0 {
+		t.Error("test function continued after FailNow in f")
+	}
+}
+
+func TestTimeoutCapped(t *testing.T) {
+	type limit struct {
+		limit time.Duration
+		left  time.Duration
+		ok    bool
+	}
+	var outer, inner, capped, none limit
+	get := func(h *H) limit {
+		l, deadline, ok := h.Limit()
+		return limit{l, time.Until(deadline), ok}
+	}
+	suite := NewSuite(Options{}, Tests{
+		"Outer": func(h *H) {
+			none = get(h)
+			h.Timeout(time.Hour, func() {
+				outer = get(h)
+				h.Run("inner", func(h *H) {
+					h.Timeout(time.Minute, func() { inner = get(h) })
+				})
+				h.Run("capped", func(h *H) {
+					h.Timeout(2*time.Hour, func() { capped = get(h) })
+				})
+			})
+		},
+	})
+	if err := suite.runTests(&bytes.Buffer{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if none.ok || none.limit != 0 {
+		t.Errorf("test without a timeout has limit %+v", none)
+	}
+	if !outer.ok || outer.limit != time.Hour || outer.left > time.Hour {
+		t.Errorf("outer limit %+v", outer)
+	}
+	if !inner.ok || inner.limit != time.Minute || inner.left > time.Minute {
+		t.Errorf("inner limit %+v", inner)
+	}
+	// the enclosing test's deadline bounds a longer limit
+	if !capped.ok || capped.limit != 2*time.Hour || capped.left > time.Hour {
+		t.Errorf("capped limit %+v", capped)
+	}
+}
+
+func TestTimeoutRunDeadline(t *testing.T) {
+	hung := make(chan struct{})
+	defer close(hung)
+	suite := NewSuite(Options{}, Tests{
+		"Hung": func(h *H) {
+			h.Timeout(time.Minute, func() { <-hung })
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	suite.ctx = ctx
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != SuiteFailed {
+		t.Errorf("expected SuiteFailed, got %v", err)
+	}
+	if out := buf.String(); !strings.Contains(out, "when the run's deadline expired") {
+		t.Errorf("expiring run deadline not reported:\n%s", out)
+	}
+}
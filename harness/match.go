@@ -17,7 +17,6 @@ package harness
 
 import (
 	"fmt"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -50,7 +49,7 @@ func matchString(pat, str string) (result bool, err error) {
 	return matchRe.MatchString(str), nil
 }
 
-func newMatcher(patterns, name string) *matcher {
+func newMatcher(patterns, name string) (*matcher, error) {
 	var filter []string
 	if patterns != "" {
 		filter = splitRegexp(patterns)
@@ -60,15 +59,14 @@ func newMatcher(patterns, name string) *matcher {
 		// Verify filters before doing any processing.
 		for i, s := range filter {
 			if _, err := matchString(s, "non-empty"); err != nil {
-				fmt.Fprintf(os.Stderr, "testing: invalid regexp for element %d of %s (%q): %s\n", i, name, s, err)
-				os.Exit(1)
+				return nil, fmt.Errorf("harness: invalid regexp for element %d of %s (%q): %s", i, name, s, err)
 			}
 		}
 	}
 	return &matcher{
 		filter:   filter,
 		subNames: map[string]int64{},
-	}
+	}, nil
 }
 
 func (m *matcher) fullName(c *H, subname string) (name string, ok bool) {
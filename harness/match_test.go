@@ -18,6 +18,7 @@ package harness
 import (
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -125,7 +126,10 @@ func TestMatcher(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		m := newMatcher(tc.pattern, "-harness.run")
+		m, err := newMatcher(tc.pattern, "-harness.run")
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		parent := &H{name: tc.parent}
 		if tc.parent != "" {
@@ -139,7 +143,10 @@ func TestMatcher(t *testing.T) {
 }
 
 func TestNaming(t *testing.T) {
-	m := newMatcher("", "")
+	m, err := newMatcher("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	parent := &H{name: "x", level: 1} // top-level test.
 
@@ -180,3 +187,15 @@ func TestNaming(t *testing.T) {
 		}
 	}
 }
+
+func TestInvalidMatch(t *testing.T) {
+	suite := NewSuite(Options{Match: "Foo/a("}, Tests{
+		"Foo": func(h *H) {
+			t.Error("test ran despite invalid pattern")
+		},
+	})
+	err := suite.Run()
+	if err == nil || !strings.Contains(err.Error(), "invalid regexp for element 1 of Match") {
+		t.Errorf("expected invalid regexp error, got %v", err)
+	}
+}
@@ -18,6 +18,8 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/mantle/harness/testresult"
@@ -31,6 +33,13 @@ type jsonReporter struct {
 	// Context variables
 	Platform string `json:"platform"`
 	Version  string `json:"version"`
+
+	// Environment describes the host's setup for the run, e.g. its
+	// proxy settings.
+	Environment map[string]string `json:"environment,omitempty"`
+
+	mu     sync.Mutex // guards Tests
+	stream *jsonLines // set once started
 }
 
 type jsonTest struct {
@@ -38,8 +47,22 @@ type jsonTest struct {
 	Result   testresult.TestResult `json:"result"`
 	Duration time.Duration         `json:"duration"`
 	Output   string                `json:"output"`
+
+	Annotations map[string]interface{} `json:"annotations,omitempty"`
+}
+
+// jsonLine is a test's result in the streamed form of the report, which
+// carries the context of the run on every line.
+type jsonLine struct {
+	jsonTest
+	Platform string `json:"platform"`
+	Version  string `json:"version"`
 }
 
+// NewJSONReporter returns a Reporter writing the results of a run to
+// filename. Once started, it also appends each test's result to a JSON
+// Lines file named like filename with a .jsonl extension as the test
+// finishes, and assembles filename from it at the end.
 func NewJSONReporter(filename, platform, version string) *jsonReporter {
 	return &jsonReporter{
 		Platform: platform,
@@ -48,16 +71,56 @@ func NewJSONReporter(filename, platform, version string) *jsonReporter {
 	}
 }
 
-func (r *jsonReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte) {
-	r.Tests = append(r.Tests, jsonTest{
-		Name:     name,
-		Result:   result,
-		Duration: duration,
-		Output:   string(b),
-	})
+// StreamName returns the name of the JSON Lines file streamed alongside
+// the JSON report filename.
+func StreamName(filename string) string {
+	return strings.TrimSuffix(filename, ".json") + ".jsonl"
+}
+
+func (r *jsonReporter) Start(path string) error {
+	stream, err := newJSONLines(filepath.Join(path, StreamName(r.filename)))
+	if err != nil {
+		return err
+	}
+	r.stream = stream
+	return nil
+}
+
+func (r *jsonReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, annotations map[string]interface{}) {
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	test := jsonTest{
+		Name:        name,
+		Result:      result,
+		Duration:    duration,
+		Output:      string(b),
+		Annotations: annotations,
+	}
+	if r.stream != nil {
+		// a failure is returned again by Output
+		r.stream.write(jsonLine{test, r.Platform, r.Version})
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Tests = append(r.Tests, test)
 }
 
 func (r *jsonReporter) Output(path string) error {
+	if r.stream != nil {
+		if err := r.stream.close(); err != nil {
+			return err
+		}
+		// Assemble the report from what was streamed rather than
+		// holding every test's output in memory for the whole run.
+		tests, err := readJSONLines(r.stream.path)
+		if err != nil {
+			return err
+		}
+		r.Tests = tests
+	}
+
 	f, err := os.Create(filepath.Join(path, r.filename))
 	if err != nil {
 		return err
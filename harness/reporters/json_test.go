@@ -0,0 +1,112 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporters
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+func TestJSONReporterStreams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "json-reporter-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewJSONReporter("report.json", "qemu", "1800.0.0")
+	if err := r.Start(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	const tests = 50
+	var wg sync.WaitGroup
+	for i := 0; i < tests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.ReportTest(fmt.Sprintf("test%02d", i), testresult.Pass, 0, []byte("output\n"), nil)
+		}(i)
+	}
+	wg.Wait()
+
+	// the results are on disk before the report is written
+	streamed, err := readJSONLines(filepath.Join(dir, "report.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streamed) != tests {
+		t.Errorf("streamed %d results, expected %d", len(streamed), tests)
+	}
+
+	r.SetResult(testresult.Pass)
+	if err := r.Output(dir); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Platform string
+		Result   testresult.TestResult
+		Tests    []jsonTest
+	}
+	if err := json.Unmarshal(b, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Platform != "qemu" || report.Result != testresult.Pass {
+		t.Errorf("unexpected report context %q %q", report.Platform, report.Result)
+	}
+	var names []string
+	for _, test := range report.Tests {
+		names = append(names, test.Name)
+		if test.Output != "output\n" {
+			t.Errorf("%s: unexpected output %q", test.Name, test.Output)
+		}
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if expect := fmt.Sprintf("test%02d", i); name != expect {
+			t.Fatalf("expected %s, got %s", expect, name)
+		}
+	}
+}
+
+func TestReadJSONLinesTruncated(t *testing.T) {
+	f, err := ioutil.TempFile("", "report-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintf(f, "{\"name\": \"a\", \"result\": \"PASS\"}\n{\"name\": \"b\", \"res")
+	f.Close()
+
+	tests, err := readJSONLines(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tests) != 1 || tests[0].Name != "a" {
+		t.Errorf("expected only test a, got %+v", tests)
+	}
+}
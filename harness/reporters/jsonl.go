@@ -0,0 +1,114 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporters
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// jsonLines appends records to a JSON Lines file. Records from parallel
+// tests are serialized through a single writer goroutine, which syncs
+// the file after each so that a crash loses at most the record being
+// written.
+type jsonLines struct {
+	path    string
+	records chan jsonLinesWrite
+	done    chan error
+}
+
+// jsonLinesWrite is a record for the writer, which sends the outcome of
+// writing and syncing it to done.
+type jsonLinesWrite struct {
+	record interface{}
+	done   chan error
+}
+
+func newJSONLines(path string) (*jsonLines, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	l := &jsonLines{
+		path:    path,
+		records: make(chan jsonLinesWrite),
+		done:    make(chan error, 1),
+	}
+	go l.run(f)
+	return l, nil
+}
+
+func (l *jsonLines) run(f *os.File) {
+	enc := json.NewEncoder(f)
+	var err error
+	for w := range l.records {
+		if err == nil {
+			if err = enc.Encode(w.record); err == nil {
+				err = f.Sync()
+			}
+			if err != nil {
+				err = fmt.Errorf("writing %s: %v", l.path, err)
+			}
+		}
+		// after a failure, keep answering so writers don't block
+		w.done <- err
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	l.done <- err
+}
+
+// write appends record, returning once it is synced to disk, or the
+// first error writing the file.
+func (l *jsonLines) write(record interface{}) error {
+	w := jsonLinesWrite{record, make(chan error, 1)}
+	l.records <- w
+	return <-w.done
+}
+
+// close waits for the queued records to be written and closes the file,
+// returning the first error.
+func (l *jsonLines) close() error {
+	close(l.records)
+	return <-l.done
+}
+
+// readJSONLines reads the test results streamed to path by a JSON
+// reporter. A final record cut short, as by a crash while writing it,
+// is ignored.
+func readJSONLines(path string) ([]jsonTest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tests []jsonTest
+	dec := json.NewDecoder(f)
+	for {
+		var test jsonTest
+		switch err := dec.Decode(&test); err {
+		case nil:
+			tests = append(tests, test)
+		case io.EOF, io.ErrUnexpectedEOF:
+			return tests, nil
+		default:
+			return nil, fmt.Errorf("parsing %s: %v", path, err)
+		}
+	}
+}
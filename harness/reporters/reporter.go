@@ -22,9 +22,9 @@ import (
 
 type Reporters []Reporter
 
-func (reps Reporters) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte) {
+func (reps Reporters) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, annotations map[string]interface{}) {
 	for _, r := range reps {
-		r.ReportTest(name, result, duration, b)
+		r.ReportTest(name, result, duration, b, annotations)
 	}
 }
 
@@ -38,14 +38,57 @@ func (reps Reporters) Output(path string) error {
 	return nil
 }
 
+// Start starts the reporters which write results as tests report them,
+// with path as the directory for their output.
+func (reps Reporters) Start(path string) error {
+	for _, r := range reps {
+		if sr, ok := r.(StreamingReporter); ok {
+			if err := sr.Start(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (reps Reporters) SetResult(s testresult.TestResult) {
 	for _, r := range reps {
 		r.SetResult(s)
 	}
 }
 
+// Reporter receives the result of each test of a run and writes a report
+// of them on Output.
+//
+// Reports are one of the two machine-readable outputs of a kola run. The
+// other is the stream of hook events, which an executable given with
+// --hook-exec receives as a JSON object on stdin for each event, as
+// defined by kola.HookEvent:
+//
+//	schema_version  kola.HookSchemaVersion, currently 1
+//	event           cluster-created, test-started, test-finished or cluster-destroyed
+//	time            when the event occurred, in UTC
+//	test            the test's name; empty for a shared cluster destroyed after its tests
+//	platform        the platform of the cluster
+//	cluster         "primary" or the name of an additional cluster
+//	output_dir      the cluster's output directory
+//	machines        id, ip and private_ip of each machine, omitted if none
+//	                are up yet or any longer
+//	result          PASS, FAIL or SKIP, only for test-finished
+//
+// The schema version is incremented whenever a field changes meaning or is
+// removed. Fields may be added without changing it, so consumers should
+// ignore fields they don't know.
 type Reporter interface {
-	ReportTest(string, testresult.TestResult, time.Duration, []byte)
+	ReportTest(string, testresult.TestResult, time.Duration, []byte, map[string]interface{})
 	Output(string) error
 	SetResult(testresult.TestResult)
 }
+
+// StreamingReporter is implemented by Reporters which write each test's
+// result as it is reported, so results survive if the run dies. Start
+// is called before any test runs; Output must finish the stream.
+type StreamingReporter interface {
+	Reporter
+	Start(path string) error
+}
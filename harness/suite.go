@@ -16,6 +16,7 @@
 package harness
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -74,6 +75,10 @@ type Options struct {
 	// Limit number of tests to run in parallel (0 means GOMAXPROCS).
 	Parallel int
 
+	// Start tests in an order shuffled with this seed rather than
+	// sorted by name (0 means sorted).
+	ShuffleSeed int64
+
 	Reporters reporters.Reporters
 }
 
@@ -131,6 +136,8 @@ type Suite struct {
 	opts  Options
 	tests Tests
 	match *matcher
+	err   error           // invalid options, returned by Run
+	ctx   context.Context // parent of every test's Context
 
 	// mu protects the following fields which are used to manage
 	// parallel test execution.
@@ -175,16 +182,29 @@ func (c *Suite) release() {
 // All parameters in Options cannot be modified once given to Suite.
 func NewSuite(opts Options, tests Tests) *Suite {
 	opts.init()
+	match, err := newMatcher(opts.Match, "Match")
 	return &Suite{
 		opts:          opts,
 		tests:         tests,
-		match:         newMatcher(opts.Match, "Match"),
+		match:         match,
+		err:           err,
 		startParallel: make(chan bool),
 	}
 }
 
 // Run runs the tests. Returns SuiteFailed for any test failure.
-func (s *Suite) Run() (err error) {
+func (s *Suite) Run() error {
+	return s.RunContext(context.Background())
+}
+
+// RunContext is like Run but the Context of every test is derived from
+// ctx, so cancelling ctx asks the running tests to stop.
+func (s *Suite) RunContext(ctx context.Context) (err error) {
+	if s.err != nil {
+		return s.err
+	}
+	s.ctx = ctx
+
 	flushProfile := func(name string, f *os.File) {
 		err2 := pprof.Lookup(name).WriteTo(f, 0)
 		if err == nil && err2 != nil {
@@ -217,6 +237,9 @@ func (s *Suite) Run() (err error) {
 			err = reportErr
 		}
 	}()
+	if err := s.opts.Reporters.Start(reportDir); err != nil {
+		return err
+	}
 
 	if s.opts.MemProfile {
 		runtime.MemProfileRate = s.opts.MemProfileRate
@@ -284,8 +307,8 @@ func (s *Suite) runTests(out, tap io.Writer) error {
 		reporters: s.opts.Reporters,
 	}
 	tRunner(t, func(t *H) {
-		for name, test := range s.tests {
-			t.Run(name, test)
+		for _, name := range s.tests.Order(s.opts.ShuffleSeed) {
+			t.Run(name, s.tests[name])
 		}
 		// Run catching the signal rather than the tRunner as a separate
 		// goroutine to avoid adding a goroutine during the sequential
@@ -16,6 +16,7 @@ package harness
 
 import (
 	"fmt"
+	"math/rand"
 
 	"github.com/coreos/mantle/lang/maps"
 )
@@ -41,3 +42,16 @@ func (ts *Tests) Add(name string, test Test) {
 func (ts Tests) List() []string {
 	return maps.NaturalKeys(ts)
 }
+
+// Order returns the names of the tests in the order a Suite starts them:
+// sorted, or shuffled with seed if it is non-zero.
+func (ts Tests) Order(seed int64) []string {
+	names := ts.List()
+	if seed != 0 {
+		r := rand.New(rand.NewSource(seed))
+		r.Shuffle(len(names), func(i, j int) {
+			names[i], names[j] = names[j], names[i]
+		})
+	}
+	return names
+}
@@ -48,3 +48,17 @@ func TestTestsList(t *testing.T) {
 		t.Errorf("got %v wanted %v", list, expect)
 	}
 }
+
+func TestTestsOrder(t *testing.T) {
+	ts := Tests(map[string]Test{"a": nil, "b": nil, "c": nil, "d": nil, "e": nil})
+	if order, expect := ts.Order(0), ts.List(); !reflect.DeepEqual(order, expect) {
+		t.Errorf("unshuffled got %v wanted %v", order, expect)
+	}
+	shuffled := ts.Order(42)
+	if again := ts.Order(42); !reflect.DeepEqual(again, shuffled) {
+		t.Errorf("seed 42 gave %v then %v", shuffled, again)
+	}
+	if reflect.DeepEqual(shuffled, ts.List()) {
+		t.Errorf("seed 42 left %v sorted", shuffled)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// artifactLayout allocates the directories test artifacts are written
+// to, laid out as <root>/<run ID>/<test>/<platform>/<attempt>/. The root
+// may be shared by concurrent runs, e.g. sharded CI jobs on NFS, as long
+// as their run IDs differ; attempts of the same test within a run are
+// numbered in the order they start.
+type artifactLayout struct {
+	root  string
+	runID string
+}
+
+// newArtifactLayout returns the layout for a run writing to outputDir,
+// using ArtifactsDir and RunID if set.
+func newArtifactLayout(outputDir string) artifactLayout {
+	return artifactLayout{root: ArtifactsDir, runID: RunID}.withDefaults(outputDir)
+}
+
+// withDefaults fills in the root and run ID of l if they are unset.
+func (l artifactLayout) withDefaults(outputDir string) artifactLayout {
+	if l.root == "" {
+		l.root = outputDir
+	}
+	if l.runID == "" {
+		l.runID = defaultRunID()
+	}
+	return l
+}
+
+// defaultRunID names a run after the time it started and the process
+// running it, which is unique enough for runs sharing a root.
+func defaultRunID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%s-%d", time.Now().UTC().Format("20060102T150405Z"), host, os.Getpid())
+}
+
+// sharedClustersDir is the directory of a run holding the directories of
+// the clusters shared by its tests, as if it were a test's.
+const sharedClustersDir = "_shared"
+
+// runDir returns the directory of the run's artifacts.
+func (l artifactLayout) runDir() string {
+	return filepath.Join(l.root, url.PathEscape(l.runID))
+}
+
+// allocate creates and returns a new attempt directory for test on
+// pltfrm, with its attempt number. Each attempt is claimed with an
+// exclusive mkdir, retrying with the next number if another process or
+// goroutine got there first, so concurrent attempts never share a
+// directory.
+func (l artifactLayout) allocate(test, pltfrm string) (string, int, error) {
+	// test names are free-form; keep each one a single path component
+	base := filepath.Join(l.runDir(), url.PathEscape(test), url.PathEscape(pltfrm))
+	if err := os.MkdirAll(base, 0777); err != nil {
+		return "", 0, err
+	}
+	for attempt := 1; ; attempt++ {
+		dir := filepath.Join(base, strconv.Itoa(attempt))
+		err := os.Mkdir(dir, 0777)
+		if err == nil {
+			return dir, attempt, nil
+		} else if !os.IsExist(err) {
+			return "", 0, err
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestArtifactLayoutConcurrentAttempts(t *testing.T) {
+	root, err := ioutil.TempDir("", "kola-artifacts-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// two shards sharing a root, each retrying the same test
+	const attempts = 20
+	shards := []artifactLayout{
+		{root: root, runID: "shard-a"},
+		{root: root, runID: "shard-b"},
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	dirs := make(map[string]string) // dir -> writer
+	numbers := make(map[string][]int)
+	for _, l := range shards {
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func(l artifactLayout, i int) {
+				defer wg.Done()
+				dir, attempt, err := l.allocate("coreos.basic", "qemu")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				writer := fmt.Sprintf("%s/%d", l.runID, i)
+				if err := ioutil.WriteFile(filepath.Join(dir, "journal.txt"), []byte(writer), 0644); err != nil {
+					t.Error(err)
+					return
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				if other, ok := dirs[dir]; ok {
+					t.Errorf("%s allocated to both %s and %s", dir, other, writer)
+				}
+				dirs[dir] = writer
+				numbers[l.runID] = append(numbers[l.runID], attempt)
+			}(l, i)
+		}
+	}
+	wg.Wait()
+
+	for _, l := range shards {
+		seen := make(map[int]bool)
+		for _, n := range numbers[l.runID] {
+			if n < 1 || n > attempts || seen[n] {
+				t.Errorf("%s: bad or duplicate attempt number %d", l.runID, n)
+			}
+			seen[n] = true
+		}
+	}
+
+	for dir, writer := range dirs {
+		if filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(dir)))) != root {
+			t.Errorf("%s not laid out as <root>/<run>/<test>/<platform>/<attempt>", dir)
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			t.Errorf("%s: expected only journal.txt, found %d files", dir, len(files))
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, "journal.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != writer {
+			t.Errorf("%s: written by %s, expected %s", dir, b, writer)
+		}
+	}
+}
+
+func TestArtifactLayoutEscapesNames(t *testing.T) {
+	root, err := ioutil.TempDir("", "kola-artifacts-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	l := artifactLayout{root: root, runID: "run"}
+	dir, attempt, err := l.allocate("docker.network/sub", "qemu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "run", "docker.network%2Fsub", "qemu", "1"); dir != want || attempt != 1 {
+		t.Errorf("got %s attempt %d, expected %s attempt 1", dir, attempt, want)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// auditSettle is how long processes and links may take to go away
+	// after the run before the audit counts them as left behind.
+	auditSettle = 10 * time.Second
+
+	// auditPoll is how often the host is checked meanwhile.
+	auditPoll = time.Second
+
+	// netnsDir is where named network namespaces are mounted.
+	netnsDir = "/run/netns"
+)
+
+// kolaTempPrefixes are the prefixes of the files kola and its platforms
+// create in the temporary directory.
+var kolaTempPrefixes = []string{"kola-", "simple-etcd-"}
+
+// hostState is the host state kola changes during a run, as a set of
+// items "<kind> <name>", e.g. "link br0" or "netns kola-1".
+type hostState map[string]bool
+
+// snapshotHost records the network links, network namespaces, kola
+// processes, kola files in the temporary directory and iptables chains
+// of the host.
+func snapshotHost() (hostState, error) {
+	s := hostState{}
+	links, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("listing network links: %v", err)
+	}
+	for _, link := range links {
+		s["link "+link.Name] = true
+	}
+	if err := s.addDir("netns", netnsDir, nil); err != nil {
+		return nil, err
+	}
+	if err := s.addDir("file", os.TempDir(), kolaTempPrefixes); err != nil {
+		return nil, err
+	}
+	if err := s.addProcesses("/proc", os.Getpid()); err != nil {
+		return nil, err
+	}
+
+	// reading iptables needs root; without it the chains aren't audited
+	if out, err := exec.Command("iptables-save").Output(); err != nil {
+		plog.Debugf("Not auditing iptables chains: %v", err)
+	} else {
+		s.addChains(string(out))
+	}
+	return s, nil
+}
+
+// addDir adds the entries of dir whose names have one of prefixes, or
+// all of them without prefixes, as items of kind naming their paths. A
+// missing dir has no entries.
+func (s hostState) addDir(kind, dir string, prefixes []string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if len(prefixes) == 0 || hasPrefix(e.Name(), prefixes) {
+			s[kind+" "+filepath.Join(dir, e.Name())] = true
+		}
+	}
+	return nil
+}
+
+// addProcesses adds the kola processes other than self found in proc,
+// those named kola* or with an argument mentioning kola-, e.g. a qemu
+// running a kola machine.
+func (s hostState) addProcesses(proc string, self int) error {
+	entries, err := ioutil.ReadDir(proc)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		// processes may exit while they are read
+		comm, err := ioutil.ReadFile(filepath.Join(proc, e.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		cmdline, err := ioutil.ReadFile(filepath.Join(proc, e.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		args := strings.Fields(string(bytes.Replace(cmdline, []byte{0}, []byte{' '}, -1)))
+		if !strings.HasPrefix(string(comm), "kola") && !strings.Contains(strings.Join(args, " "), "kola-") {
+			continue
+		}
+		s[fmt.Sprintf("process %d %s", pid, strings.Join(args, " "))] = true
+	}
+	return nil
+}
+
+// addChains adds the chains of rules, the output of iptables-save, as
+// items "iptables <table>/<chain>".
+func (s hostState) addChains(rules string) {
+	table := ""
+	for _, line := range strings.Split(rules, "\n") {
+		switch {
+		case strings.HasPrefix(line, "*"):
+			table = line[1:]
+		case strings.HasPrefix(line, ":"):
+			if f := strings.Fields(line[1:]); len(f) > 0 {
+				s["iptables "+table+"/"+f[0]] = true
+			}
+		}
+	}
+}
+
+// leftovers returns the sorted items of after which aren't in before and
+// don't match any of the glob patterns allow.
+func leftovers(before, after hostState, allow []string) []string {
+	var left []string
+	for item := range after {
+		if before[item] || matchesAny(item, allow) {
+			continue
+		}
+		left = append(left, item)
+	}
+	sort.Strings(left)
+	return left
+}
+
+func matchesAny(item string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if match, _ := filepath.Match(pattern, item); match {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// persistentItems are the items the run keeps on purpose: its output,
+// artifacts and cache directories, if they are in the temporary
+// directory.
+func persistentItems(outputDir string) []string {
+	var items []string
+	for _, dir := range []string{outputDir, ArtifactsDir, CacheDir} {
+		if dir == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			items = append(items, "file "+abs)
+		}
+	}
+	return items
+}
+
+// auditCleanup compares the host with before once the run is over,
+// waiting up to auditSettle for teardown to finish, and counts the run
+// as leaking if anything is left. The leftovers are logged and written
+// to cleanup-audit.txt in outputDir.
+func auditCleanup(r *RunResult, before hostState, outputDir string) {
+	allow := append(persistentItems(outputDir), AuditAllow...)
+	deadline := time.Now().Add(auditSettle)
+	var left []string
+	for {
+		after, err := snapshotHost()
+		if err != nil {
+			r.Fail(fmt.Errorf("--audit-cleanup: %v", err))
+			return
+		}
+		left = leftovers(before, after, allow)
+		if len(left) == 0 {
+			plog.Info("--audit-cleanup: the run left nothing behind")
+			return
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(auditPoll)
+	}
+
+	r.leaked++
+	var b bytes.Buffer
+	for _, item := range left {
+		fmt.Fprintf(&b, "+ %s\n", item)
+	}
+	plog.Errorf("--audit-cleanup: %d items left behind by the run:\n%s", len(left), b.String())
+	if err := ioutil.WriteFile(filepath.Join(outputDir, "cleanup-audit.txt"), b.Bytes(), 0644); err != nil {
+		plog.Errorf("Saving cleanup audit: %v", err)
+	}
+}
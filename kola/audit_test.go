@@ -0,0 +1,98 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHostState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(path, contents string) {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("tmp/kola-qemu-1/disk", "")
+	write("tmp/simple-etcd-2/member", "")
+	write("tmp/unrelated", "")
+	write("proc/1/comm", "systemd\n")
+	write("proc/1/cmdline", "/sbin/init\x00")
+	write("proc/20/comm", "kola\n")
+	write("proc/20/cmdline", "kola\x00run\x00")
+	write("proc/30/comm", "qemu-system-x86\n")
+	write("proc/30/cmdline", "qemu-system-x86_64\x00-qmp\x00unix:/tmp/kola-qmp-1,server\x00")
+	write("proc/40/comm", "kolet\n")
+	write("proc/40/cmdline", "kolet\x00run\x00")
+	write("proc/self/comm", "kola\n")
+
+	s := hostState{}
+	if err := s.addDir("file", filepath.Join(dir, "tmp"), kolaTempPrefixes); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.addDir("netns", filepath.Join(dir, "missing"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.addProcesses(filepath.Join(dir, "proc"), 40); err != nil {
+		t.Fatal(err)
+	}
+	s.addChains("*filter\n:INPUT ACCEPT [0:0]\n:KOLA-1 - [0:0]\n-A INPUT -j KOLA-1\nCOMMIT\n*nat\n:POSTROUTING ACCEPT [0:0]\nCOMMIT\n")
+
+	want := hostState{
+		"file " + filepath.Join(dir, "tmp/kola-qemu-1"):                  true,
+		"file " + filepath.Join(dir, "tmp/simple-etcd-2"):                true,
+		"process 20 kola run":                                            true,
+		"process 30 qemu-system-x86_64 -qmp unix:/tmp/kola-qmp-1,server": true,
+		"iptables filter/INPUT":                                          true,
+		"iptables filter/KOLA-1":                                         true,
+		"iptables nat/POSTROUTING":                                       true,
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("got state %v, want %v", s, want)
+	}
+}
+
+func TestLeftovers(t *testing.T) {
+	before := hostState{"link eth0": true, "process 20 kola run": true}
+	after := hostState{
+		"link eth0":               true,
+		"link br0":                true,
+		"netns /run/netns/kola-1": true,
+		"file /tmp/kola-cache":    true,
+		"process 20 kola run":     true,
+		"process 31 qemu-system-x86_64 -name kola-1": true,
+	}
+	got := leftovers(before, after, []string{"file /tmp/kola-cache*"})
+	want := []string{"link br0", "netns /run/netns/kola-1", "process 31 qemu-system-x86_64 -name kola-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got leftovers %v, want %v", got, want)
+	}
+	if left := leftovers(after, after, nil); len(left) != 0 {
+		t.Errorf("unchanged state has leftovers %v", left)
+	}
+}
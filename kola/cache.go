@@ -0,0 +1,282 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+	gcloudapi "github.com/coreos/mantle/platform/api/gcloud"
+	"github.com/coreos/mantle/version"
+)
+
+// resultCache records tests which passed against a particular image so
+// that re-runs against the same image can skip them. Only passes are
+// recorded; failures are always re-run.
+type resultCache struct {
+	dir      string
+	platform string
+	image    string // platform-specific image digest
+	options  string // digest of the platform options
+}
+
+// cacheEntry is the on-disk record of a cached pass.
+type cacheEntry struct {
+	Test     string    `json:"test"`
+	Platform string    `json:"platform"`
+	Image    string    `json:"image"`
+	Version  string    `json:"version"`
+	Options  string    `json:"options"`
+	Passed   time.Time `json:"passed"`
+}
+
+func newResultCache(dir, pltfrm string) (*resultCache, error) {
+	image, err := imageDigest(pltfrm)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := digestJSON(platformOptions(pltfrm))
+	if err != nil {
+		return nil, err
+	}
+
+	dir = filepath.Join(dir, "results")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	return &resultCache{
+		dir:      dir,
+		platform: pltfrm,
+		image:    image,
+		options:  options,
+	}, nil
+}
+
+// imageDigest returns a string identifying the exact image under test on
+// the given platform.
+func imageDigest(pltfrm string) (string, error) {
+	switch pltfrm {
+	case "qemu":
+		f, err := os.Open(QEMUOptions.DiskImage)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", fmt.Errorf("hashing %s: %v", QEMUOptions.DiskImage, err)
+		}
+		return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+	case "gce":
+		api, err := gcloudapi.New(&GCEOptions)
+		if err != nil {
+			return "", err
+		}
+		image, err := api.GetImage(GCEOptions.Image)
+		if err != nil {
+			return "", err
+		}
+		// image names may be reused after deletion, ids may not
+		return fmt.Sprintf("gce:%s/%d", image.Name, image.Id), nil
+	default:
+		return "", fmt.Errorf("result caching is not supported on platform %q", pltfrm)
+	}
+}
+
+// platformOptions returns the options struct used to create clusters on
+// the given platform.
+func platformOptions(pltfrm string) interface{} {
+	switch pltfrm {
+	case "aws":
+		return AWSOptions
+	case "do":
+		return DOOptions
+	case "esx":
+		return ESXOptions
+	case "gce":
+		return GCEOptions
+	case "packet":
+		return PacketOptions
+	case "qemu":
+		return QEMUOptions
+	default:
+		return Options
+	}
+}
+
+func digestJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// bootStageKey is what identifies a boot stage in a cache entry: its
+// userdata by digest, since pointers differ between runs.
+type bootStageKey struct {
+	Name         string
+	Size         int
+	UserData     string
+	ReadyCheck   string
+	ReadyTimeout time.Duration
+}
+
+// clusterSpecKey is what identifies an additional cluster in a cache
+// entry, including the options of its platform.
+type clusterSpecKey struct {
+	Name     string
+	Platform string
+	Size     int
+	UserData string
+	Options  string
+}
+
+// userDataFileDigest identifies the contents and mode of the local file
+// a test adds to its userdata, which are only read when the test runs.
+// A file which can't be read has no digest; the test fails to set up.
+func userDataFileDigest(local string) string {
+	local = ConfigPath(local)
+	st, err := os.Stat(local)
+	if err != nil {
+		return ""
+	}
+	contents, err := ioutil.ReadFile(local)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(contents)
+	return fmt.Sprintf("%v:%s", st.Mode().Perm(), hex.EncodeToString(sum[:]))
+}
+
+// entry returns the cache entry for t and the path it is stored under.
+func (c *resultCache) entry(t *register.Test) (cacheEntry, string) {
+	var machineUserData []string
+	for _, userdata := range t.MachineUserData {
+		machineUserData = append(machineUserData, userdata.Digest())
+	}
+	var bootStages []bootStageKey
+	for _, s := range t.BootStages {
+		bootStages = append(bootStages, bootStageKey{s.Name, s.Size, s.UserData.Digest(), s.ReadyCheck, s.ReadyTimeout})
+	}
+	var userDataFiles map[string]string
+	for remote, local := range t.UserDataFiles {
+		if userDataFiles == nil {
+			userDataFiles = make(map[string]string)
+		}
+		userDataFiles[remote] = userDataFileDigest(local)
+	}
+	var additionalClusters []clusterSpecKey
+	for _, spec := range t.AdditionalClusters {
+		options, _ := digestJSON(platformOptions(spec.Platform))
+		additionalClusters = append(additionalClusters, clusterSpecKey{spec.Name, spec.Platform, spec.Size, spec.UserData.Digest(), options})
+	}
+	var machineOptions *platform.MachineOptions
+	if !t.MachineOptions.IsZero() {
+		machineOptions = &t.MachineOptions
+	}
+	testOpts, _ := digestJSON(struct {
+		Options            string
+		UserData           string
+		MachineUserData    []string `json:",omitempty"`
+		UserDataFiles      map[string]string
+		ClusterSize        int
+		BootStages         []bootStageKey   `json:",omitempty"`
+		AdditionalClusters []clusterSpecKey `json:",omitempty"`
+		EtcdVersion        string
+		Flags              []register.Flag
+		MachineOptions     *platform.MachineOptions `json:",omitempty"`
+		Env                map[string]string        `json:",omitempty"`
+	}{
+		Options:            c.options,
+		UserData:           t.UserData.Digest(),
+		MachineUserData:    machineUserData,
+		UserDataFiles:      userDataFiles,
+		ClusterSize:        t.ClusterSize,
+		BootStages:         bootStages,
+		AdditionalClusters: additionalClusters,
+		EtcdVersion:        etcdVersion(t),
+		Flags:              t.Flags,
+		MachineOptions:     machineOptions,
+		Env:                t.Env,
+	})
+
+	e := cacheEntry{
+		Test:     t.Name,
+		Platform: c.platform,
+		Image:    c.image,
+		Version:  version.Version,
+		Options:  testOpts,
+	}
+	key, _ := digestJSON(e)
+	return e, filepath.Join(c.dir, key+".json")
+}
+
+// Passed reports whether t has a cached pass, marking the entry as
+// recently used for PruneCache if so.
+func (c *resultCache) Passed(t *register.Test) bool {
+	_, path := c.entry(t)
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	touch(path)
+	return true
+}
+
+// Record updates the cache with the result of t.
+func (c *resultCache) Record(t *register.Test, passed bool) error {
+	e, path := c.entry(t)
+	if !passed {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	e.Passed = time.Now().UTC()
+	b, err := json.Marshal(&e)
+	if err != nil {
+		return err
+	}
+
+	// write atomically in case parallel runs share a cache
+	tmp, err := ioutil.TempFile(c.dir, ".entry")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
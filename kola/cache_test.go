@@ -0,0 +1,156 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+func testResultCache(t *testing.T) (*resultCache, func()) {
+	dir, err := ioutil.TempDir("", "kola-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &resultCache{dir: dir, platform: "qemu", image: "sha256:1", options: "opts"}
+	return c, func() { os.RemoveAll(dir) }
+}
+
+// cachedTest returns a test as defined in a fresh process: its userdata
+// are new pointers each time.
+func cachedTest() *register.Test {
+	return &register.Test{
+		Name:        "cache.test",
+		UserData:    conf.ContainerLinuxConfig("storage: {}"),
+		ClusterSize: 1,
+		BootStages: []register.BootStage{
+			{Name: "server", Size: 1, UserData: conf.ContainerLinuxConfig("systemd: {}")},
+		},
+		AdditionalClusters: []register.ClusterSpec{
+			{Name: "other", Platform: "gce", Size: 1, UserData: conf.ContainerLinuxConfig("passwd: {}")},
+		},
+	}
+}
+
+func TestResultCacheRecord(t *testing.T) {
+	c, cleanup := testResultCache(t)
+	defer cleanup()
+
+	test := cachedTest()
+	if c.Passed(test) {
+		t.Fatal("empty cache has a pass")
+	}
+	if err := c.Record(test, true); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Passed(test) {
+		t.Error("recorded pass not found")
+	}
+	if !c.Passed(cachedTest()) {
+		t.Error("pass not found for the same test defined again")
+	}
+	if err := c.Record(test, false); err != nil {
+		t.Fatal(err)
+	}
+	if c.Passed(test) {
+		t.Error("pass still found after a failure")
+	}
+	if err := c.Record(test, false); err != nil {
+		t.Errorf("recording a failure without a pass: %v", err)
+	}
+}
+
+func TestResultCacheInvalidation(t *testing.T) {
+	c, cleanup := testResultCache(t)
+	defer cleanup()
+
+	if err := c.Record(cachedTest(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		change func(c *resultCache, t *register.Test)
+	}{
+		{"image", func(c *resultCache, t *register.Test) { c.image = "sha256:2" }},
+		{"platform options", func(c *resultCache, t *register.Test) { c.options = "other" }},
+		{"platform", func(c *resultCache, t *register.Test) { c.platform = "gce" }},
+		{"userdata", func(c *resultCache, t *register.Test) { t.UserData = conf.ContainerLinuxConfig("storage: {files: []}") }},
+		{"boot stage userdata", func(c *resultCache, t *register.Test) {
+			t.BootStages[0].UserData = conf.ContainerLinuxConfig("networkd: {}")
+		}},
+		{"boot stage check", func(c *resultCache, t *register.Test) { t.BootStages[0].ReadyCheck = "true" }},
+		{"cluster size", func(c *resultCache, t *register.Test) { t.ClusterSize = 3 }},
+		{"flags", func(c *resultCache, t *register.Test) { t.Flags = []register.Flag{register.NoEmergencyShellCheck} }},
+		{"machine options", func(c *resultCache, t *register.Test) { t.MachineOptions = platform.MachineOptions{MemoryMiB: 4096} }},
+		{"env", func(c *resultCache, t *register.Test) { t.Env = map[string]string{"PATH": "/opt/bin"} }},
+		{"additional cluster size", func(c *resultCache, t *register.Test) { t.AdditionalClusters[0].Size = 2 }},
+		{"additional cluster userdata", func(c *resultCache, t *register.Test) {
+			t.AdditionalClusters[0].UserData = conf.ContainerLinuxConfig("networkd: {}")
+		}},
+	} {
+		changed := *c
+		test := cachedTest()
+		tt.change(&changed, test)
+		if changed.Passed(test) {
+			t.Errorf("pass found after changing the %s", tt.name)
+		}
+	}
+}
+
+func TestResultCacheUserDataFiles(t *testing.T) {
+	c, cleanup := testResultCache(t)
+	defer cleanup()
+	defer func(dir string) { ConfigDir = dir }(ConfigDir)
+	ConfigDir = c.dir
+
+	unit := filepath.Join(c.dir, "kola.service")
+	write := func(contents string, mode os.FileMode) {
+		if err := ioutil.WriteFile(unit, []byte(contents), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(unit, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	test := cachedTest()
+	test.UserDataFiles = map[string]string{"/etc/systemd/system/kola.service": "kola.service"}
+
+	write("[Service]\nExecStart=/bin/true\n", 0644)
+	if err := c.Record(test, true); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Passed(test) {
+		t.Fatal("recorded pass not found")
+	}
+	write("[Service]\nExecStart=/bin/false\n", 0644)
+	if c.Passed(test) {
+		t.Error("pass found after editing a userdata file")
+	}
+	write("[Service]\nExecStart=/bin/true\n", 0755)
+	if c.Passed(test) {
+		t.Error("pass found after changing the mode of a userdata file")
+	}
+	write("[Service]\nExecStart=/bin/true\n", 0644)
+	if !c.Passed(test) {
+		t.Error("pass not found with the file as it was")
+	}
+}
@@ -16,13 +16,24 @@ package cluster
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 
 	"github.com/coreos/mantle/harness"
 	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
 )
 
 // TestCluster embedds a Cluster to provide platform independant helper
@@ -31,19 +42,131 @@ type TestCluster struct {
 	*harness.H
 	platform.Cluster
 	NativeFuncs []string
+
+	// Stages holds the machines of each of the test's BootStages,
+	// keyed by stage name.
+	Stages map[string][]platform.Machine
+
+	// Ordered holds the machines of the test's ClusterSize in the
+	// order of its MachineUserData, if it has any.
+	Ordered []platform.Machine
+
+	// AdditionalClusters holds the clusters requested by the test's
+	// AdditionalClusters specs, keyed by name.
+	AdditionalClusters map[string]platform.Cluster
+
+	// ArtifactDir is the directory of this attempt at the test, which
+	// holds the logs collected from its clusters. Files the test wants
+	// kept for analysis belong here.
+	ArtifactDir string
+
+	// EtcdVersion is the image tag of the etcd release the machines
+	// run, if not the image's default.
+	EtcdVersion string
+
+	// Fetcher is used by Fetch and shared between tests so that
+	// each file is downloaded once.
+	Fetcher *Fetcher
+
+	// InfraFailure, if set, is called before the test is failed by
+	// an error outside the test's control, such as a download failure.
+	InfraFailure func(err error)
+
+	// Scratch, if set, is the run's GCS storage used by ScratchObject
+	// and ScratchURL.
+	Scratch *Scratch
+
+	// ResourceLeaked, if set, is called when something the test
+	// created outside its clusters could not be deleted.
+	ResourceLeaked func(id string, err error)
+
+	// ReusedMachines is set when the machines outlive the test, so
+	// helpers which could leave them unusable must not touch their root
+	// filesystem.
+	ReusedMachines bool
+
+	// Debugger, if set, pauses the test at breakpoints and failed
+	// subtests.
+	Debugger *Debugger
+
+	// Env holds environment variables, e.g. PATH, set for every command
+	// run on the machines through SSH, MustSSH and RunNative.
+	Env map[string]string
+
+	// Timeouts bounds the commands and native functions the test
+	// runs. Unset fields take DefaultTimeouts.
+	Timeouts Timeouts
+
+	// Random is the test's randomness, drawn from through Rand.
+	Random *Random
+
+	// DiscoveryURL is the etcd discovery URL given to the test's
+	// machines, if their configs asked for one.
+	DiscoveryURL string
+
+	// AddressFamily is the register.Test.AddressFamily, used for the
+	// configs of NewMachineWithConfig.
+	AddressFamily string
+
+	// Kolet is the path of the kolet binary dropped on the test's
+	// machines, if it needs one.
+	Kolet string
+
+	// retry is set by Breakpoint to ask debugRun to retry the subtest.
+	retry *int32
 }
 
-// Run runs f as a subtest and reports whether f succeeded.
+// Run runs f as a subtest and reports whether f succeeded. With a
+// Debugger, a failed subtest pauses the test.
 func (t *TestCluster) Run(name string, f func(c TestCluster)) bool {
+	if t.Debugger != nil {
+		return t.debugRun(name, f)
+	}
+	return t.runSubtest(name, nil, f)
+}
+
+func (t *TestCluster) runSubtest(name string, retry *int32, f func(c TestCluster)) bool {
 	return t.H.Run(name, func(h *harness.H) {
-		f(TestCluster{H: h, Cluster: t.Cluster})
+		f(TestCluster{
+			H:                  h,
+			Cluster:            t.Cluster,
+			Stages:             t.Stages,
+			Ordered:            t.Ordered,
+			AdditionalClusters: t.AdditionalClusters,
+			ArtifactDir:        t.ArtifactDir,
+			EtcdVersion:        t.EtcdVersion,
+			Fetcher:            t.Fetcher,
+			InfraFailure:       t.InfraFailure,
+			Scratch:            t.Scratch,
+			ResourceLeaked:     t.ResourceLeaked,
+			ReusedMachines:     t.ReusedMachines,
+			Debugger:           t.Debugger,
+			Env:                t.Env,
+			Timeouts:           t.Timeouts,
+			Random:             t.Random,
+			DiscoveryURL:       t.DiscoveryURL,
+			AddressFamily:      t.AddressFamily,
+			Kolet:              t.Kolet,
+			retry:              retry,
+		})
 	})
 }
 
 // RunNative runs a registered NativeFunc on a remote machine
 func (t *TestCluster) RunNative(funcName string, m platform.Machine) bool {
+	return t.RunNativeEnv(funcName, m, nil)
+}
+
+// RunNativeEnv is like RunNative, but adds env to the environment of
+// kolet on top of the test's Env.
+func (t *TestCluster) RunNativeEnv(funcName string, m platform.Machine, env map[string]string) bool {
 	command := fmt.Sprintf("./kolet run %q %q", t.Name(), funcName)
 	return t.Run(funcName, func(c TestCluster) {
+		cmd, err := EnvCommand(mergeEnv(t.Env, env), command)
+		if err != nil {
+			c.Fatalf("kolet: %v", err)
+		}
+
 		client, err := m.SSHClient()
 		if err != nil {
 			c.Fatalf("kolet SSH client: %v", err)
@@ -56,14 +179,128 @@ func (t *TestCluster) RunNative(funcName string, m platform.Machine) bool {
 		}
 		defer session.Close()
 
-		b, err := session.CombinedOutput(command)
+		// only read once kolet has returned; closing the client on
+		// timeout ends it
+		var b []byte
+		var runErr error
+		budget := Budget(c.H, "native function", t.Timeouts.WithDefaults().Native)
+		if err := runLimited(c.Context(), budget, func() {
+			b, runErr = session.CombinedOutput(cmd)
+		}); err != nil {
+			c.Fatalf("kolet: %v", err)
+		}
 		b = bytes.TrimSpace(b)
 		if len(b) > 0 {
 			t.Logf("kolet:\n%s", b)
 		}
-		if err != nil {
-			c.Errorf("kolet: %v", err)
+		if runErr != nil {
+			c.Errorf("kolet: %v", runErr)
+		}
+	})
+}
+
+// HasCapability reports whether the cluster's platform supports c.
+func (t *TestCluster) HasCapability(c platform.Capability) bool {
+	return t.Cluster.Capabilities().Has(c)
+}
+
+// DestroyMachine tears down m, e.g. to check that the rest of the
+// cluster recovers, and removes it from Machines so that the cluster
+// doesn't destroy it again. m is still listed in Ordered and Stages.
+// Destroying a machine which is already gone does nothing.
+func (t *TestCluster) DestroyMachine(m platform.Machine) {
+	if t.hasMachine(m) {
+		m.Destroy()
+	}
+}
+
+// KillMachine is DestroyMachine without letting m shut down, to
+// simulate a crash. It fails the test if the platform can't do that.
+func (t *TestCluster) KillMachine(m platform.Machine) {
+	if !t.hasMachine(m) {
+		return
+	}
+	if err := platform.KillMachine(m); err != nil {
+		t.Fatalf("killing machine %s: %v", m.ID(), err)
+	}
+}
+
+// SetDestroyOrder sets the order in which the cluster's machines are
+// destroyed when the test ends, overriding the test's DestroyOrder.
+// leader is the machine platform.DestroyLeaderLast destroys last, or nil
+// for the first machine created.
+func (t *TestCluster) SetDestroyOrder(order platform.DestroyOrder, leader platform.Machine) {
+	var id string
+	if leader != nil {
+		id = leader.ID()
+	}
+	if err := platform.SetDestroyOrder(t.Cluster, order, id); err != nil {
+		t.Fatalf("setting destroy order: %v", err)
+	}
+}
+
+func (t *TestCluster) hasMachine(m platform.Machine) bool {
+	for _, cm := range t.Machines() {
+		if cm.ID() == m.ID() {
+			return true
 		}
+	}
+	return false
+}
+
+// SetMetadata sets the cloud metadata the platform's simulated metadata
+// service serves to machines created afterwards, failing the test unless
+// the platform has platform.CapMetadataService.
+func (t *TestCluster) SetMetadata(md platform.Metadata) {
+	if err := platform.SetMetadata(t.Cluster, md); err != nil {
+		t.Fatalf("setting metadata: %v", err)
+	}
+}
+
+// UseDNSFixture makes machines created afterwards resolve names through
+// the platform's DNS fixture, failing the test unless the platform has
+// platform.CapDNSFixture.
+func (t *TestCluster) UseDNSFixture() {
+	if err := platform.UseDNSFixture(t.Cluster); err != nil {
+		t.Fatalf("using the DNS fixture: %v", err)
+	}
+}
+
+// SetDNSHost makes the DNS fixture answer the queries for name with
+// addrs, or stop answering them without addrs. Machines see the change
+// at once.
+func (t *TestCluster) SetDNSHost(name string, addrs ...net.IP) {
+	if err := platform.SetDNSHost(t.Cluster, name, addrs...); err != nil {
+		t.Fatalf("setting DNS host %q: %v", name, err)
+	}
+}
+
+// SetDNSFault changes how the DNS fixture answers the queries for name.
+// Machines see the change at once.
+func (t *TestCluster) SetDNSFault(name string, fault platform.DNSFault) {
+	if err := platform.SetDNSFault(t.Cluster, name, fault); err != nil {
+		t.Fatalf("setting DNS fault for %q: %v", name, err)
+	}
+}
+
+// Checkpoint saves the state of all of the cluster's machines as name,
+// failing the test unless the platform has platform.CapCheckpoint.
+func (t *TestCluster) Checkpoint(name string) {
+	if err := platform.Checkpoint(t.Cluster, name); err != nil {
+		t.Fatalf("checkpointing %q: %v", name, err)
+	}
+}
+
+// RunFromCheckpoint runs f as the subtest name after returning the
+// cluster to checkpoint, so that each of several destructive scenarios
+// starts from the state an expensive setup left. Machines created since
+// the checkpoint are destroyed. Such subtests must not run in parallel.
+func (t *TestCluster) RunFromCheckpoint(name, checkpoint string, f func(c TestCluster)) bool {
+	return t.Run(name, func(c TestCluster) {
+		if err := platform.RestoreCheckpoint(c.Cluster, checkpoint); err != nil {
+			c.Fatalf("restoring checkpoint %q: %v", checkpoint, err)
+		}
+		f(c)
 	})
 }
 
@@ -75,29 +312,227 @@ func (t *TestCluster) ListNativeFunctions() []string {
 
 // DropFile places file from localPath to ~/ on every machine in cluster
 func (t *TestCluster) DropFile(localPath string) error {
-	in, err := os.Open(localPath)
+	return dropFile(localPath, filepath.Base(localPath), 0755, t.Machines())
+}
+
+// DropFileTo copies the local file localPath to the path to on every
+// machine in the cluster, owned by root with mode. A relative path is
+// relative to the home directory.
+func (t *TestCluster) DropFileTo(localPath, to string, mode os.FileMode) error {
+	return dropFile(localPath, to, mode, t.Machines())
+}
+
+// dropFileWorkers is how many machines dropFile copies to at once.
+const dropFileWorkers = 8
+
+// dropFile copies localPath to to on machines in parallel, skipping
+// those which already have an identical file, e.g. kolet on a reused
+// machine. Its error lists every machine the copy failed on.
+func dropFile(localPath, to string, mode os.FileMode, machines []platform.Machine) error {
+	data, err := ioutil.ReadFile(localPath)
 	if err != nil {
 		return err
 	}
-	defer in.Close()
+	sum := sha256.Sum256(data)
 
-	for _, m := range t.Machines() {
-		if _, err := in.Seek(0, 0); err != nil {
-			return err
-		}
-		if err := platform.InstallFile(in, m, filepath.Base(localPath)); err != nil {
-			return err
+	errs := make([]error, len(machines))
+	slots := make(chan struct{}, dropFileWorkers)
+	var wg sync.WaitGroup
+	for i, m := range machines {
+		wg.Add(1)
+		go func(i int, m platform.Machine) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			errs[i] = dropFileOn(m, data, hex.EncodeToString(sum[:]), to, mode)
+		}(i, m)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", machines[i].ID(), err))
 		}
 	}
+	if len(failed) > 0 {
+		return fmt.Errorf("copying %s to %d of %d machines failed:\n%s", localPath, len(failed), len(machines), strings.Join(failed, "\n"))
+	}
 	return nil
 }
 
+// dropFileOn copies data to to on m unless the file there already has
+// the sha256 sum and mode.
+func dropFileOn(m platform.Machine, data []byte, sum, to string, mode os.FileMode) error {
+	quoted := ShellQuote(to)
+	out, _, err := m.SSH(fmt.Sprintf("sudo sha256sum -- %s && sudo stat -c %%a -- %s", quoted, quoted))
+	if err == nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if len(lines) == 2 && strings.HasPrefix(lines[0], sum+" ") && lines[1] == fmt.Sprintf("%o", mode.Perm()) {
+			return nil
+		}
+	}
+	return platform.InstallFileMode(bytes.NewReader(data), m, to, mode)
+}
+
+// NewMachineWithConfig adds a machine to the test's cluster, e.g. to
+// grow it mid-test. config may be any kind of config. Like the configs
+// of the test's own machines, it gets the cluster's DiscoveryURL for
+// $discovery or {{.DiscoveryURL}} and a fresh machine name for $name or
+// {{.Name}}. kolet is dropped on the machine if the test needs it.
+func (t *TestCluster) NewMachineWithConfig(config string) (platform.Machine, error) {
+	userdata := conf.Unknown(config)
+	if userdata.NeedsDiscovery() && t.DiscoveryURL == "" {
+		return nil, fmt.Errorf("config needs a discovery URL, but the test's machines have none")
+	}
+	index := len(t.Machines())
+	userdata, err := userdata.ForCluster(conf.TemplateVars{
+		DiscoveryURL:  t.DiscoveryURL,
+		Index:         index,
+		ClusterSize:   index + 1,
+		AddressFamily: t.AddressFamily,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := t.NewMachine(userdata)
+	if err != nil {
+		return nil, err
+	}
+	if t.Kolet != "" {
+		if err := dropFile(t.Kolet, filepath.Base(t.Kolet), 0755, []platform.Machine{m}); err != nil {
+			m.Destroy()
+			return nil, fmt.Errorf("dropping kolet binary on %s: %v", m.ID(), err)
+		}
+	}
+	return m, nil
+}
+
+// Fetch downloads url to the local file dest, verifying that its sha256
+// is sum, and fails the test if it cannot. Failures to download are
+// reported as infrastructure failures.
+func (t *TestCluster) Fetch(url, sum, dest string) {
+	f := t.Fetcher
+	if f == nil {
+		f = NewFetcher("")
+	}
+	err := f.Fetch(url, sum, dest, t.Logf)
+	if err == nil {
+		return
+	}
+	if _, ok := err.(*InfraError); ok && t.InfraFailure != nil {
+		t.InfraFailure(err)
+	}
+	t.Fatal(err)
+}
+
+// ScratchObject uploads media to GCS as name and returns a URL from
+// which the test's machines can download it without credentials until
+// the run ends. The object is deleted when the test finishes. The test
+// is skipped if kola has no GCS credentials, and GCS failures are
+// reported as infrastructure failures.
+func (t *TestCluster) ScratchObject(name string, media io.ReaderAt) string {
+	name = t.scratchName(name)
+	url, err := t.Scratch.Put(t.H.Context(), name, media)
+	if err != nil {
+		t.scratchFatal(err)
+	}
+	return url
+}
+
+// ScratchURL returns the gs:// URL of the object name in GCS, for tests
+// which write it with other tools. Like an object of ScratchObject, it
+// is deleted when the test finishes.
+func (t *TestCluster) ScratchURL(name string) string {
+	name = t.scratchName(name)
+	url, err := t.Scratch.URL(t.H.Context(), name)
+	if err != nil {
+		t.scratchFatal(err)
+	}
+	return url
+}
+
+// scratchName returns the name of the test's scratch object name, and
+// arranges for it to be deleted.
+func (t *TestCluster) scratchName(name string) string {
+	if t.Scratch == nil {
+		t.Skip("no GCS scratch storage; it needs --gce-json-key or --gce-service-auth")
+	}
+	name = t.H.Name() + "/" + name
+	t.H.Cleanup(func() {
+		if err := t.Scratch.Delete(context.Background(), name); err != nil {
+			if t.ResourceLeaked != nil {
+				t.ResourceLeaked(name, err)
+			} else {
+				t.Logf("warning: deleting scratch object %s: %v", name, err)
+			}
+		}
+	})
+	return name
+}
+
+func (t *TestCluster) scratchFatal(err error) {
+	if _, ok := err.(*InfraError); ok && t.InfraFailure != nil {
+		t.InfraFailure(err)
+	}
+	t.Fatal(err)
+}
+
+// pushProgressInterval is how often PushFile logs progress.
+const pushProgressInterval = 30 * time.Second
+
+// PushFile copies the local file path to to on m with platform.PushFile,
+// logging progress to the test's output periodically. opts.Progress is
+// ignored.
+func (t *TestCluster) PushFile(m platform.Machine, path, to string, opts platform.PushOptions) error {
+	start := time.Now()
+	last := start
+	opts.Progress = func(done, total int64) {
+		if now := time.Now(); now.Sub(last) >= pushProgressInterval || done == total {
+			last = now
+			t.Logf("pushed %d of %d bytes of %s to %s in %v", done, total, filepath.Base(path), m.ID(), now.Sub(start))
+		}
+	}
+	return platform.PushFile(m, path, to, opts)
+}
+
+// SSHError is the error of a command run through SSH, SSHEnv or
+// SSHOutput which failed or timed out.
+type SSHError struct {
+	Machine string
+	Cmd     string
+	Err     error
+}
+
+func (e *SSHError) Error() string {
+	return fmt.Sprintf("%q on %s: %v", e.Cmd, e.Machine, e.Err)
+}
+
+// ExitStatus returns the exit status of the command which failed with
+// err, if it ran and exited.
+func ExitStatus(err error) (int, bool) {
+	if e, ok := err.(*SSHError); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*ssh.ExitError); ok {
+		return e.ExitStatus(), true
+	}
+	return 0, false
+}
+
 // SSH runs a ssh command on the given machine in the cluster. It differs from
 // Machine.SSH in that stderr is written to the test's output as a 'Log' line.
 // This ensures the output will be correctly accumulated under the correct
 // test.
 func (t *TestCluster) SSH(m platform.Machine, cmd string) ([]byte, error) {
-	stdout, stderr, err := m.SSH(cmd)
+	return t.SSHEnv(m, cmd, nil)
+}
+
+// SSHEnv is like SSH, but adds env to the environment of cmd on top of
+// the test's Env.
+func (t *TestCluster) SSHEnv(m platform.Machine, cmd string, env map[string]string) ([]byte, error) {
+	stdout, stderr, err := t.sshOutput(m, cmd, env)
 
 	if len(stderr) > 0 {
 		for _, line := range strings.Split(string(stderr), "\n") {
@@ -108,13 +543,55 @@ func (t *TestCluster) SSH(m platform.Machine, cmd string) ([]byte, error) {
 	return stdout, err
 }
 
+// SSHOutput is like SSH, but returns the command's stderr instead of
+// logging it.
+func (t *TestCluster) SSHOutput(m platform.Machine, cmd string) ([]byte, []byte, error) {
+	return t.sshOutput(m, cmd, nil)
+}
+
+func (t *TestCluster) sshOutput(m platform.Machine, cmd string, env map[string]string) ([]byte, []byte, error) {
+	b := Budget(t.H, "SSH command", t.Timeouts.WithDefaults().SSH)
+	return sshCommand(t.Context(), m, cmd, mergeEnv(t.Env, env), b)
+}
+
+// sshCommand runs cmd on m with env, giving up once b runs out. A
+// command given up on is abandoned, not killed, since a wedged sshd
+// wouldn't deliver a signal anyway.
+func sshCommand(ctx context.Context, m platform.Machine, cmd string, env map[string]string, b *TimeoutError) ([]byte, []byte, error) {
+	envCmd, err := EnvCommand(env, cmd)
+	if err != nil {
+		return nil, nil, &SSHError{Machine: m.ID(), Cmd: cmd, Err: err}
+	}
+
+	// only read once the command has returned
+	var r struct {
+		stdout, stderr []byte
+		err            error
+	}
+	if err := runLimited(ctx, b, func() {
+		r.stdout, r.stderr, r.err = m.SSH(envCmd)
+	}); err != nil {
+		return nil, nil, &SSHError{Machine: m.ID(), Cmd: cmd, Err: err}
+	}
+	if r.err != nil {
+		return r.stdout, r.stderr, &SSHError{Machine: m.ID(), Cmd: cmd, Err: r.err}
+	}
+	return r.stdout, r.stderr, nil
+}
+
 // MustSSH runs a ssh command on the given machine in the cluster, writes
 // its stderr to the test's output as a 'Log' line, fails the test if the
 // command is unsuccessful, and returns the command's stdout.
 func (t *TestCluster) MustSSH(m platform.Machine, cmd string) []byte {
-	out, err := t.SSH(m, cmd)
+	return t.MustSSHEnv(m, cmd, nil)
+}
+
+// MustSSHEnv is like MustSSH, but adds env to the environment of cmd on
+// top of the test's Env.
+func (t *TestCluster) MustSSHEnv(m platform.Machine, cmd string, env map[string]string) []byte {
+	out, err := t.SSHEnv(m, cmd, env)
 	if err != nil {
-		t.Fatalf("%q failed: output %s, status %v", cmd, out, err)
+		t.Fatalf("%v: output %s", err, out)
 	}
 	return out
 }
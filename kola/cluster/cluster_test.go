@@ -0,0 +1,281 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/platform"
+)
+
+// sshMachine answers SSH commands after delay.
+type sshMachine struct {
+	platform.Machine
+	delay          time.Duration
+	stdout, stderr string
+	err            error
+
+	mu  sync.Mutex
+	cmd string // the last command run
+}
+
+func (m *sshMachine) ID() string {
+	return "fake"
+}
+
+func (m *sshMachine) SSH(cmd string) ([]byte, []byte, error) {
+	m.mu.Lock()
+	m.cmd = cmd
+	m.mu.Unlock()
+	time.Sleep(m.delay)
+	return []byte(m.stdout), []byte(m.stderr), m.err
+}
+
+func TestSSHCommand(t *testing.T) {
+	m := &sshMachine{stdout: "out", stderr: "err"}
+	limit := func(d time.Duration) *TimeoutError {
+		return &TimeoutError{Op: "SSH command", Limit: d}
+	}
+	stdout, stderr, err := sshCommand(context.Background(), m, "true", map[string]string{"A": "b"}, limit(time.Second))
+	if err != nil || string(stdout) != "out" || string(stderr) != "err" {
+		t.Errorf("got %q, %q, %v", stdout, stderr, err)
+	}
+	if want := "env A=b sh -c true"; m.cmd != want {
+		t.Errorf("ran %q, want %q", m.cmd, want)
+	}
+
+	m = &sshMachine{err: errors.New("boom")}
+	_, _, err = sshCommand(context.Background(), m, "false", nil, limit(time.Second))
+	if err == nil || err.Error() != `"false" on fake: boom` {
+		t.Errorf("error %v doesn't name the command and machine", err)
+	}
+	if _, ok := ExitStatus(err); ok {
+		t.Error("exit status of a command which didn't exit")
+	}
+
+	m = &sshMachine{delay: time.Second}
+	_, _, err = sshCommand(context.Background(), m, "sleep", nil, limit(10*time.Millisecond))
+	if err == nil || !strings.HasSuffix(err.Error(), "timed out: SSH command timeout of 10ms expired") {
+		t.Errorf("hung command returned %v", err)
+	}
+
+	// the test's deadline comes first
+	now := time.Now()
+	deadline := now.Add(10 * time.Millisecond)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	b := budget("SSH command", time.Minute, time.Hour, deadline, true, now)
+	_, _, err = sshCommand(ctx, m, "sleep", nil, b)
+	if e, ok := err.(*SSHError); !ok || e.Err != b {
+		t.Errorf("command outlasting the test returned %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, _, err = sshCommand(ctx, m, "sleep", nil, limit(time.Minute))
+	if e, ok := err.(*SSHError); !ok || e.Err != context.Canceled {
+		t.Errorf("command of a canceled test returned %v", err)
+	}
+}
+
+// machinesCluster holds machines which remove themselves when destroyed.
+type machinesCluster struct {
+	platform.Cluster
+	machines []platform.Machine
+}
+
+func (c *machinesCluster) Machines() []platform.Machine {
+	return c.machines
+}
+
+type doomedMachine struct {
+	platform.Machine
+	c         *machinesCluster
+	id        string
+	destroyed int
+	killed    bool
+}
+
+func (m *doomedMachine) ID() string {
+	return m.id
+}
+
+func (m *doomedMachine) Destroy() {
+	m.destroyed++
+	for i, cm := range m.c.machines {
+		if cm == platform.Machine(m) {
+			m.c.machines = append(m.c.machines[:i], m.c.machines[i+1:]...)
+		}
+	}
+}
+
+type killableMachine struct {
+	*doomedMachine
+}
+
+func (m killableMachine) Kill() {
+	m.killed = true
+	m.Destroy()
+}
+
+func TestDestroyMachine(t *testing.T) {
+	c := &machinesCluster{}
+	m1 := &doomedMachine{c: c, id: "m1"}
+	m2 := &doomedMachine{c: c, id: "m2"}
+	m3 := killableMachine{&doomedMachine{c: c, id: "m3"}}
+	c.machines = []platform.Machine{m1, m2, m3.doomedMachine}
+	tc := TestCluster{Cluster: c}
+
+	tc.DestroyMachine(m1)
+	tc.DestroyMachine(m1)
+	if m1.destroyed != 1 {
+		t.Errorf("destroyed a machine %d times", m1.destroyed)
+	}
+
+	tc.KillMachine(m3)
+	if !m3.killed || m3.destroyed != 1 {
+		t.Errorf("machine killed %v, destroyed %d times", m3.killed, m3.destroyed)
+	}
+
+	if ms := tc.Machines(); len(ms) != 1 || ms[0].ID() != "m2" {
+		t.Errorf("machines left: %v", ms)
+	}
+}
+
+// checkpointCluster records checkpoints and the order of restores.
+type checkpointCluster struct {
+	platform.Cluster
+	saved    map[string]bool
+	restored []string
+}
+
+func (c *checkpointCluster) Checkpoint(name string) error {
+	c.saved[name] = true
+	return nil
+}
+
+func (c *checkpointCluster) RestoreCheckpoint(name string) error {
+	if !c.saved[name] {
+		return fmt.Errorf("no checkpoint %q", name)
+	}
+	c.restored = append(c.restored, name)
+	return nil
+}
+
+func TestRunFromCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-checkpoint-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &checkpointCluster{saved: make(map[string]bool)}
+	var ran []string
+	var tests harness.Tests
+	tests.Add("test", func(h *harness.H) {
+		tc := TestCluster{H: h, Cluster: c}
+		tc.Checkpoint("formed")
+		for _, scenario := range []string{"a", "b"} {
+			scenario := scenario
+			tc.RunFromCheckpoint(scenario, "formed", func(c TestCluster) {
+				ran = append(ran, scenario)
+			})
+		}
+		if tc.RunFromCheckpoint("missing", "never-saved", func(c TestCluster) {
+			ran = append(ran, "missing")
+		}) {
+			h.Error("ran a scenario from a missing checkpoint")
+		}
+	})
+	suite := harness.NewSuite(harness.Options{OutputDir: filepath.Join(dir, "out")}, tests)
+	suite.Run()
+
+	if want := []string{"formed", "formed"}; !reflect.DeepEqual(c.restored, want) {
+		t.Errorf("restored %v, want %v", c.restored, want)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
+
+// fileMachine has a file with the given sha256sum and stat output, and
+// can't be copied to.
+type fileMachine struct {
+	platform.Machine
+	id, sum, mode string
+}
+
+func (m *fileMachine) ID() string {
+	return m.id
+}
+
+func (m *fileMachine) SSH(cmd string) ([]byte, []byte, error) {
+	if strings.HasPrefix(cmd, "sudo sha256sum") {
+		return []byte(m.sum + "  bin/kolet\n" + m.mode + "\n"), nil, nil
+	}
+	return nil, nil, nil
+}
+
+func (m *fileMachine) SSHClient() (*ssh.Client, error) {
+	return nil, errors.New("unreachable")
+}
+
+func TestDropFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "kolet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("kolet")
+	f.Close()
+	s := sha256.Sum256([]byte("kolet"))
+	sum := hex.EncodeToString(s[:])
+
+	var machines []platform.Machine
+	for i := 0; i < 2*dropFileWorkers; i++ {
+		machines = append(machines, &fileMachine{id: fmt.Sprintf("same%d", i), sum: sum, mode: "755"})
+	}
+	if err := dropFile(f.Name(), "bin/kolet", 0755, machines); err != nil {
+		t.Errorf("copied to machines with an identical file: %v", err)
+	}
+
+	machines = append(machines,
+		&fileMachine{id: "stale", sum: strings.Repeat("0", 64), mode: "755"},
+		&fileMachine{id: "mode", sum: sum, mode: "644"})
+	err = dropFile(f.Name(), "bin/kolet", 0755, machines)
+	if err == nil {
+		t.Fatal("copied to unreachable machines")
+	}
+	for _, want := range []string{"2 of 18 machines", "stale: ", "mode: "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+}
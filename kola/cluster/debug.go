@@ -0,0 +1,153 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// debugAction is what the user chose to do at a breakpoint.
+type debugAction int
+
+const (
+	debugContinue debugAction = iota
+	debugRetry
+	debugAbort
+)
+
+// Debugger pauses tests at breakpoints, with their machines still
+// running, and asks the user on its input whether to continue, retry the
+// failing subtest or abort the test.
+type Debugger struct {
+	mu  sync.Mutex // one prompt at a time
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewDebugger returns a Debugger prompting on out and reading answers
+// from in.
+func NewDebugger(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+// ask prints why t stopped and how to reach its machines, and waits for
+// the user's choice. End of input aborts.
+func (d *Debugger) ask(t *TestCluster, reason string, canRetry bool) debugAction {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprintf(d.out, "=== BREAKPOINT %s: %s\n", t.Name(), reason)
+	printSSHConfig(d.out, "", t.Cluster)
+	names := make([]string, 0, len(t.AdditionalClusters))
+	for name := range t.AdditionalClusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		printSSHConfig(d.out, name+" ", t.AdditionalClusters[name])
+	}
+
+	choices := "continue or abort"
+	if canRetry {
+		choices = "continue, retry or abort"
+	}
+	for {
+		fmt.Fprintf(d.out, "%s? ", choices)
+		line, err := d.in.ReadString('\n')
+		switch strings.TrimSpace(line) {
+		case "continue", "c":
+			return debugContinue
+		case "retry", "r":
+			if canRetry {
+				return debugRetry
+			}
+			fmt.Fprintln(d.out, "Only subtests can be retried.")
+		case "abort", "a":
+			return debugAbort
+		}
+		if err != nil {
+			fmt.Fprintln(d.out)
+			return debugAbort
+		}
+	}
+}
+
+func printSSHConfig(w io.Writer, prefix string, c platform.Cluster) {
+	sc, ok := c.(interface {
+		SSHConfigPath() string
+	})
+	if !ok {
+		return
+	}
+	fmt.Fprintf(w, "Connect to the %smachines with: ssh -F %s <machine>\n", prefix, sc.SSHConfigPath())
+	for _, m := range c.Machines() {
+		fmt.Fprintf(w, "\t%s\n", m.ID())
+	}
+}
+
+// Breakpoint returns err unchanged unless the test runs with a Debugger
+// and err is not nil. Then it pauses the test until the user chooses to
+// continue, which returns err, to retry the subtest it was called from,
+// or to abort the test.
+func (t *TestCluster) Breakpoint(err error) error {
+	if err == nil || t.Debugger == nil {
+		return err
+	}
+	switch t.Debugger.ask(t, err.Error(), t.retry != nil) {
+	case debugRetry:
+		atomic.StoreInt32(t.retry, 1)
+		t.Fatalf("retrying after breakpoint: %v", err)
+	case debugAbort:
+		t.Fatalf("aborted at breakpoint: %v", err)
+	}
+	return err
+}
+
+// debugRun runs f as a subtest like Run, but on failure asks the
+// Debugger whether to retry it. Subtests which call Parallel are not
+// waited for and so are not retried.
+func (t *TestCluster) debugRun(name string, f func(c TestCluster)) bool {
+	for attempt := 0; ; attempt++ {
+		subname := name
+		if attempt > 0 {
+			subname = fmt.Sprintf("%s#retry%d", name, attempt)
+		}
+		var retry int32
+		if t.runSubtest(subname, &retry, f) {
+			return true
+		}
+		if atomic.LoadInt32(&retry) == 1 {
+			continue
+		}
+		switch t.Debugger.ask(t, fmt.Sprintf("subtest %s failed", subname), true) {
+		case debugRetry:
+			continue
+		case debugAbort:
+			t.FailNow()
+		}
+		return false
+	}
+}
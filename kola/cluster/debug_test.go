@@ -0,0 +1,130 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/harness"
+)
+
+// runDebug runs body as a test with a Debugger answering with input,
+// and returns whether the test passed and the Debugger's output.
+func runDebug(t *testing.T, input string, body func(c TestCluster)) (bool, string) {
+	dir, err := ioutil.TempDir("", "kola-debug-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	debugger := NewDebugger(strings.NewReader(input), &out)
+	var tests harness.Tests
+	tests.Add("test", func(h *harness.H) {
+		body(TestCluster{H: h, Debugger: debugger})
+	})
+	suite := harness.NewSuite(harness.Options{
+		OutputDir: filepath.Join(dir, "out"),
+		Verbose:   true,
+	}, tests)
+	return suite.Run() == nil, out.String()
+}
+
+func TestBreakpointWithoutDebugger(t *testing.T) {
+	var c TestCluster
+	err := errors.New("boom")
+	if got := c.Breakpoint(err); got != err {
+		t.Errorf("got %v, want %v", got, err)
+	}
+	if got := c.Breakpoint(nil); got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+}
+
+func TestBreakpointContinue(t *testing.T) {
+	boom := errors.New("boom")
+	var got error
+	passed, out := runDebug(t, "huh\ncontinue\n", func(c TestCluster) {
+		got = c.Breakpoint(boom)
+	})
+	if got != boom {
+		t.Errorf("Breakpoint returned %v, want %v", got, boom)
+	}
+	if !passed {
+		t.Error("test failed after continue")
+	}
+	if !strings.Contains(out, "=== BREAKPOINT test: boom") {
+		t.Errorf("breakpoint not announced:\n%s", out)
+	}
+	if !strings.Contains(out, "continue or abort? continue or abort? ") {
+		t.Errorf("expected a second prompt after an unknown answer:\n%s", out)
+	}
+}
+
+func TestBreakpointRetry(t *testing.T) {
+	attempts := 0
+	passed, _ := runDebug(t, "retry\n", func(c TestCluster) {
+		c.Run("step", func(c TestCluster) {
+			attempts++
+			if attempts == 1 {
+				c.Breakpoint(errors.New("flake"))
+			}
+		})
+	})
+	if attempts != 2 {
+		t.Errorf("subtest ran %d times, want 2", attempts)
+	}
+	if passed {
+		t.Error("test passed despite the failed attempt")
+	}
+}
+
+func TestFailedSubtestRetryThenAbort(t *testing.T) {
+	attempts := 0
+	after := false
+	passed, out := runDebug(t, "retry\nabort\n", func(c TestCluster) {
+		c.Run("step", func(c TestCluster) {
+			attempts++
+			c.Fail()
+		})
+		after = true
+	})
+	if attempts != 2 {
+		t.Errorf("subtest ran %d times, want 2", attempts)
+	}
+	if after || passed {
+		t.Error("test went on after abort")
+	}
+	if !strings.Contains(out, "subtest step#retry1 failed") {
+		t.Errorf("retry not named in output:\n%s", out)
+	}
+}
+
+func TestBreakpointEOFAborts(t *testing.T) {
+	after := false
+	passed, _ := runDebug(t, "", func(c TestCluster) {
+		c.Breakpoint(errors.New("boom"))
+		after = true
+	})
+	if after || passed {
+		t.Error("test went on without an answer")
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/platform"
+)
+
+// The space and inodes used by FillDisk and FillInodes are kept at fixed
+// names in the root of the filled filesystem, so Release can find them
+// without any state from the fill, e.g. after the machine rebooted.
+const (
+	fillFile   = ".kola-fill"
+	fillInodes = ".kola-fill-inodes"
+)
+
+// fills records the machines each test has filled, so that their
+// release is registered once per machine however often they are filled.
+var fills = struct {
+	sync.Mutex
+	m map[*harness.H]map[platform.Machine]bool
+}{m: make(map[*harness.H]map[platform.Machine]bool)}
+
+// releaseAtCleanup arranges for Release to be called on m when the test
+// finishes, unless m has been destroyed by then.
+func (t *TestCluster) releaseAtCleanup(m platform.Machine) {
+	fills.Lock()
+	filled := fills.m[t.H][m]
+	if !filled {
+		if fills.m[t.H] == nil {
+			fills.m[t.H] = make(map[platform.Machine]bool)
+		}
+		fills.m[t.H][m] = true
+	}
+	fills.Unlock()
+	if filled {
+		return
+	}
+
+	t.Cleanup(func() {
+		fills.Lock()
+		delete(fills.m, t.H)
+		fills.Unlock()
+
+		// a machine the test destroyed, or of an additional cluster,
+		// won't be reused
+		if !t.hasMachine(m) {
+			return
+		}
+		if err := t.Release(m); err != nil {
+			t.Errorf("%v", err)
+		}
+	})
+}
+
+// fillTarget returns the mountpoint of the filesystem containing p on m.
+func (t *TestCluster) fillTarget(m platform.Machine, p string) (string, error) {
+	out, err := t.SSH(m, fmt.Sprintf("findmnt -n -o TARGET --target %q", p))
+	if err != nil {
+		return "", fmt.Errorf("finding filesystem of %s on %s: %v", p, m.ID(), err)
+	}
+	mnt := strings.TrimSpace(string(out))
+	if mnt == "/" && t.ReusedMachines {
+		return "", fmt.Errorf("refusing to fill the root filesystem of reused machine %s", m.ID())
+	}
+	return mnt, nil
+}
+
+// df returns the value of a df(1) output field for the filesystem
+// mounted at mnt.
+func (t *TestCluster) df(m platform.Machine, mnt, field string) (uint64, error) {
+	out, err := t.SSH(m, fmt.Sprintf("df -B1 --output=%s %q | tail -n1", field, mnt))
+	if err != nil {
+		return 0, fmt.Errorf("df %s on %s: %v", mnt, m.ID(), err)
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing df %s output on %s: %v", field, m.ID(), err)
+	}
+	return v, nil
+}
+
+// FillDisk allocates a file on the filesystem containing mountpoint on m
+// so that only leaveFree bytes remain available to unprivileged users.
+// Calling it again replaces the previous fill. The space is released by
+// Release, which is called automatically when the test finishes if the
+// machine still exists.
+func (t *TestCluster) FillDisk(m platform.Machine, mountpoint string, leaveFree uint64) error {
+	mnt, err := t.fillTarget(m, mountpoint)
+	if err != nil {
+		return err
+	}
+	file := path.Join(mnt, fillFile)
+
+	t.releaseAtCleanup(m)
+
+	if _, err := t.SSH(m, fmt.Sprintf("sudo rm -f %q", file)); err != nil {
+		return fmt.Errorf("removing previous fill on %s: %v", m.ID(), err)
+	}
+
+	avail, err := t.df(m, mnt, "avail")
+	if err != nil {
+		return err
+	}
+	if avail <= leaveFree {
+		t.Logf("%s on %s already has only %d bytes available", mnt, m.ID(), avail)
+		return nil
+	}
+
+	if _, err := t.SSH(m, fmt.Sprintf("sudo fallocate -l %d %q", avail-leaveFree, file)); err != nil {
+		return fmt.Errorf("filling %s on %s: %v", mnt, m.ID(), err)
+	}
+	return nil
+}
+
+// FillInodes creates empty files on the filesystem containing mountpoint
+// on m until only leaveFree inodes remain. Like FillDisk, the files are
+// removed by Release when the test finishes.
+func (t *TestCluster) FillInodes(m platform.Machine, mountpoint string, leaveFree uint64) error {
+	mnt, err := t.fillTarget(m, mountpoint)
+	if err != nil {
+		return err
+	}
+	dir := path.Join(mnt, fillInodes)
+
+	t.releaseAtCleanup(m)
+
+	total, err := t.df(m, mnt, "itotal")
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return fmt.Errorf("%s on %s does not have a fixed number of inodes", mnt, m.ID())
+	}
+
+	if _, err := t.SSH(m, fmt.Sprintf("sudo mkdir -p %q", dir)); err != nil {
+		return fmt.Errorf("creating %s on %s: %v", dir, m.ID(), err)
+	}
+	avail, err := t.df(m, mnt, "iavail")
+	if err != nil {
+		return err
+	}
+	if avail <= leaveFree {
+		t.Logf("%s on %s already has only %d inodes available", mnt, m.ID(), avail)
+		return nil
+	}
+
+	// Name the files after the current time so that repeated fills do
+	// not collide with each other.
+	cmd := fmt.Sprintf(`sudo sh -c 'cd %q && seq -f "f$(date +%%s%%N)-%%.0f" %d | xargs touch'`, dir, avail-leaveFree)
+	if _, err := t.SSH(m, cmd); err != nil {
+		return fmt.Errorf("filling inodes of %s on %s: %v", mnt, m.ID(), err)
+	}
+	return nil
+}
+
+// Release removes everything allocated by FillDisk and FillInodes on m,
+// including fills left over from before a reboot.
+func (t *TestCluster) Release(m platform.Machine) error {
+	cmd := fmt.Sprintf(`sudo sh -c 'for d in $(findmnt -rn -o TARGET); do rm -rf "$d/%s" "$d/%s"; done'`, fillFile, fillInodes)
+	if _, err := t.SSH(m, cmd); err != nil {
+		return fmt.Errorf("releasing filled space on %s: %v", m.ID(), err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/platform"
+)
+
+// fillableMachine has a /var with 1000 bytes and inodes available, and
+// counts the releases of its fills. It can't be reached once destroyed.
+type fillableMachine struct {
+	platform.Machine
+	c         *machinesCluster
+	id        string
+	released  int
+	destroyed bool
+}
+
+func (m *fillableMachine) ID() string {
+	return m.id
+}
+
+func (m *fillableMachine) SSH(cmd string) ([]byte, []byte, error) {
+	switch {
+	case m.destroyed:
+		return nil, nil, errors.New("connection refused")
+	case strings.Contains(cmd, "findmnt -n"):
+		return []byte("/var\n"), nil, nil
+	case strings.Contains(cmd, "df -B1"):
+		return []byte("1000\n"), nil, nil
+	case strings.Contains(cmd, "findmnt -rn"):
+		m.released++
+	}
+	return nil, nil, nil
+}
+
+func (m *fillableMachine) Destroy() {
+	m.destroyed = true
+	for i, cm := range m.c.machines {
+		if cm == platform.Machine(m) {
+			m.c.machines = append(m.c.machines[:i], m.c.machines[i+1:]...)
+		}
+	}
+}
+
+func TestFillRelease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-fill-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &machinesCluster{}
+	filled := &fillableMachine{c: c, id: "filled"}
+	destroyed := &fillableMachine{c: c, id: "destroyed"}
+	c.machines = []platform.Machine{filled, destroyed}
+
+	var tests harness.Tests
+	tests.Add("test", func(h *harness.H) {
+		tc := TestCluster{H: h, Cluster: c}
+		for _, fill := range []func(platform.Machine, string, uint64) error{tc.FillDisk, tc.FillDisk, tc.FillInodes} {
+			if err := fill(filled, "/var", 100); err != nil {
+				h.Fatal(err)
+			}
+		}
+		if err := tc.FillDisk(destroyed, "/var", 100); err != nil {
+			h.Fatal(err)
+		}
+		tc.DestroyMachine(destroyed)
+	})
+	suite := harness.NewSuite(harness.Options{OutputDir: filepath.Join(dir, "out")}, tests)
+	if err := suite.Run(); err != nil {
+		t.Errorf("test failed: %v", err)
+	}
+
+	if filled.released != 1 {
+		t.Errorf("released fills %d times, want once", filled.released)
+	}
+	if destroyed.released != 0 {
+		t.Errorf("released fills of a destroyed machine")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ShellQuote quotes s as a single word for a POSIX shell. Every byte,
+// including spaces, quotes and newlines, is taken literally.
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !shellSafe(r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	// nothing is special inside single quotes but the closing quote,
+	// so a single quote ends the quoting, is escaped and reopens it
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func shellSafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("%+,-./:=@_", r)
+}
+
+// EnvCommand wraps the shell command cmd so that it runs with the
+// variables of env added to its environment. Since cmd may be a
+// pipeline or list, it is run by sh rather than prefixed with the
+// assignments. cmd is returned unchanged if env is empty.
+func EnvCommand(env map[string]string, cmd string) (string, error) {
+	if len(env) == 0 {
+		return cmd, nil
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		if k == "" || strings.ContainsAny(k, "=\x00") {
+			return "", fmt.Errorf("invalid environment variable name %q", k)
+		}
+		if strings.ContainsRune(env[k], 0) {
+			return "", fmt.Errorf("environment variable %s contains a NUL byte", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	words := []string{"env"}
+	for _, k := range keys {
+		words = append(words, ShellQuote(k+"="+env[k]))
+	}
+	words = append(words, "sh", "-c", ShellQuote(cmd))
+	return strings.Join(words, " "), nil
+}
+
+// mergeEnv returns the variables of base overridden by those of env.
+func mergeEnv(base, env map[string]string) map[string]string {
+	if len(env) == 0 {
+		return base
+	}
+	if len(base) == 0 {
+		return env
+	}
+	merged := make(map[string]string, len(base)+len(env))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range env {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,159 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+// awkwardValues are hard to pass through a shell intact.
+var awkwardValues = []string{
+	"",
+	"plain",
+	"/usr/local/bin:/usr/bin",
+	"with space",
+	"  leading and trailing  ",
+	"tab\there",
+	"new\nline",
+	"trailing newline\n",
+	"'",
+	"''",
+	"it's",
+	`"double"`,
+	`back\slash`,
+	`\'`,
+	"$HOME",
+	"${PATH}",
+	"`id`",
+	"$(id)",
+	"a;b",
+	"a|b&c",
+	"<in >out",
+	"glob * ? [a]",
+	"~",
+	"#comment",
+	"!bang",
+	"-n",
+	"--",
+	"=",
+	"ünïcødé",
+	"mixed 'single' \"double\" `back` $var\n\\",
+}
+
+func TestShellQuote(t *testing.T) {
+	for _, tt := range []struct {
+		in, out string
+	}{
+		{"", "''"},
+		{"plain", "plain"},
+		{"/usr/bin:/bin", "/usr/bin:/bin"},
+		{"KEY=value", "KEY=value"},
+		{"with space", "'with space'"},
+		{"it's", `'it'\''s'`},
+		{"'", `''\'''`},
+		{"$HOME", "'$HOME'"},
+		{"new\nline", "'new\nline'"},
+		{`"`, `'"'`},
+	} {
+		if got := ShellQuote(tt.in); got != tt.out {
+			t.Errorf("ShellQuote(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestShellQuoteRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell to run quoted words")
+	}
+	for _, v := range awkwardValues {
+		out, err := exec.Command(sh, "-c", "printf %s "+ShellQuote(v)).Output()
+		if err != nil {
+			t.Errorf("printing %q: %v", v, err)
+			continue
+		}
+		if string(out) != v {
+			t.Errorf("ShellQuote(%q) was read by the shell as %q", v, out)
+		}
+	}
+}
+
+func TestEnvCommand(t *testing.T) {
+	cmd, err := EnvCommand(nil, "uptime")
+	if err != nil || cmd != "uptime" {
+		t.Errorf("EnvCommand without env = %q, %v; want the command unchanged", cmd, err)
+	}
+
+	cmd, err = EnvCommand(map[string]string{"B": "b b", "A": "a"}, "echo $A | cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `env A=a 'B=b b' sh -c 'echo $A | cat'`; cmd != want {
+		t.Errorf("EnvCommand = %q, want %q", cmd, want)
+	}
+
+	for _, k := range []string{"", "A=B", "A\x00"} {
+		if _, err := EnvCommand(map[string]string{k: "v"}, "true"); err == nil {
+			t.Errorf("EnvCommand accepted variable name %q", k)
+		}
+	}
+	if _, err := EnvCommand(map[string]string{"A": "\x00"}, "true"); err == nil {
+		t.Errorf("EnvCommand accepted a NUL byte in a value")
+	}
+}
+
+func TestEnvCommandRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell to run commands")
+	}
+	for _, v := range awkwardValues {
+		// the command itself needs quoting as well, and a list checks
+		// that the variable reaches all of it
+		cmd, err := EnvCommand(map[string]string{"KOLA_VALUE": v}, `true && printf '%s' "$KOLA_VALUE"`)
+		if err != nil {
+			t.Errorf("EnvCommand with %q: %v", v, err)
+			continue
+		}
+		out, err := exec.Command(sh, "-c", cmd).Output()
+		if err != nil {
+			t.Errorf("running %q: %v", cmd, err)
+			continue
+		}
+		if string(out) != v {
+			t.Errorf("KOLA_VALUE=%q reached the command as %q", v, out)
+		}
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	base := map[string]string{"A": "base", "B": "base"}
+	got := mergeEnv(base, map[string]string{"B": "call", "C": "call"})
+	want := map[string]string{"A": "base", "B": "call", "C": "call"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeEnv = %v, want %v", got, want)
+	}
+	if base["B"] != "base" {
+		t.Errorf("mergeEnv modified the test's Env")
+	}
+	if got := mergeEnv(base, nil); !reflect.DeepEqual(got, base) {
+		t.Errorf("mergeEnv without overrides = %v, want %v", got, base)
+	}
+	if got := mergeEnv(nil, nil); len(got) != 0 {
+		t.Errorf("mergeEnv of nothing = %v", got)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/system"
+)
+
+const (
+	fetchAttempts = 5
+	fetchBackoff  = 2 * time.Second
+)
+
+// InfraError is a failure of something outside the test, such as a
+// download server, which a CI system should retry rather than blame on
+// the test.
+type InfraError struct {
+	Err error
+}
+
+func (e *InfraError) Error() string {
+	return e.Err.Error()
+}
+
+// Fetcher downloads files needed by tests. Files are cached by checksum in
+// Dir, if set, so that tests running in parallel download each file once.
+// Proxies are taken from the environment as for any http.Client.
+type Fetcher struct {
+	Dir string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // per checksum
+}
+
+// NewFetcher returns a Fetcher caching in dir, which may be empty.
+func NewFetcher(dir string) *Fetcher {
+	return &Fetcher{
+		Dir:   dir,
+		locks: make(map[string]*sync.Mutex),
+	}
+}
+
+func (f *Fetcher) lock(sum string) func() {
+	f.mu.Lock()
+	l, ok := f.locks[sum]
+	if !ok {
+		l = &sync.Mutex{}
+		f.locks[sum] = l
+	}
+	f.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// Fetch downloads url to the local file dest, checking that its sha256 is
+// sum. Downloads are retried with backoff; an error which persists is
+// returned as an *InfraError. A checksum mismatch is not retried and is
+// returned as a plain error since it is more likely a stale sum in the
+// test than a transient failure. Retries are reported through logf.
+func (f *Fetcher) Fetch(url, sum, dest string, logf func(format string, args ...interface{})) error {
+	sum = strings.ToLower(sum)
+	if len(sum) != sha256.Size*2 {
+		return fmt.Errorf("fetching %s: invalid sha256 %q", url, sum)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return err
+	}
+	if f.Dir == "" {
+		return download(url, sum, dest, logf)
+	}
+
+	defer f.lock(sum)()
+
+	cached := filepath.Join(f.Dir, "sha256", sum)
+	if err := verifySum(cached, sum); err != nil {
+		if err := os.MkdirAll(filepath.Dir(cached), 0777); err != nil {
+			return err
+		}
+		if err := download(url, sum, cached, logf); err != nil {
+			return err
+		}
+	} else {
+		// the cache's pruning evicts the least recently used files
+		now := time.Now()
+		os.Chtimes(cached, now, now)
+	}
+
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(cached, dest); err == nil {
+		return nil
+	}
+	return system.CopyRegularFile(cached, dest)
+}
+
+// download fetches url into dest through a temporary file, so dest only
+// appears once verified.
+func download(url, sum, dest string, logf func(format string, args ...interface{})) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var got string
+	backoff := fetchBackoff
+	for attempt := 1; ; attempt++ {
+		got, err = downloadOnce(url, tmp)
+		if err == nil {
+			break
+		}
+		if _, ok := err.(retryable); !ok {
+			return fmt.Errorf("fetching %s: %v", url, err)
+		}
+		if attempt == fetchAttempts {
+			return &InfraError{fmt.Errorf("fetching %s failed after %d attempts: %v", url, attempt, err)}
+		}
+		logf("fetching %s failed, retrying in %v: %v", url, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	if got != sum {
+		return fmt.Errorf("fetching %s: sha256 is %s, expected %s", url, got, sum)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// retryable marks errors worth another attempt.
+type retryable struct {
+	error
+}
+
+// downloadOnce writes url to f, replacing its contents, and returns the
+// sha256 of what was written.
+func downloadOnce(url string, f *os.File) (string, error) {
+	if err := f.Truncate(0); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", retryable{err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return "", retryable{fmt.Errorf("%s", resp.Status)}
+	default:
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		return "", retryable{err}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func verifySum(path, sum string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("%s: sha256 is %s, expected %s", path, got, sum)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// fleetUnitDir holds unit files on the machine for fleetctl submit, which
+// names units after the file.
+const fleetUnitDir = "/tmp/kola-fleet"
+
+// fleetPollInterval is how often WaitUnitState polls fleetctl.
+const fleetPollInterval = time.Second
+
+// fleetctl runs fleetctl with args on m. The error includes fleetctl's
+// output since fleetctl reports problems on both stdout and stderr.
+func fleetctl(m platform.Machine, args ...string) ([]byte, error) {
+	cmd := "fleetctl " + strings.Join(args, " ")
+	stdout, stderr, err := m.SSH(cmd)
+	if err != nil {
+		return stdout, fmt.Errorf("%q on %s failed: %v\nstdout: %s\nstderr: %s", cmd, m.ID(), err, stdout, stderr)
+	}
+	return stdout, nil
+}
+
+// SubmitUnit copies a unit file with contents to m and submits it to
+// fleet as name. The unit is destroyed when the test finishes.
+func (t *TestCluster) SubmitUnit(m platform.Machine, name, contents string) {
+	file := path.Join(fleetUnitDir, name)
+	if err := platform.InstallFile(strings.NewReader(contents), m, file); err != nil {
+		t.Fatalf("copying unit %s to %s: %v", name, m.ID(), err)
+	}
+	if _, err := fleetctl(m, "submit", file); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// the machine may already be gone, which is fine
+		if _, err := fleetctl(m, "destroy", name); err != nil {
+			t.Logf("destroying unit %s: %v", name, err)
+		}
+	})
+}
+
+// StartUnit starts the submitted unit name through fleet on m. It does
+// not wait for the unit to become active; see WaitUnitState.
+func (t *TestCluster) StartUnit(m platform.Machine, name string) {
+	if _, err := fleetctl(m, "start", "-no-block", name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// WaitUnitState polls fleet through m until unit name is in the active
+// state, e.g. "active", failing the test if timeout passes first or the
+// unit fails.
+func (t *TestCluster) WaitUnitState(m platform.Machine, name, state string, timeout time.Duration) {
+	if err := waitUnitState(m, name, state, timeout, fleetPollInterval); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitUnitState polls until name reaches state. Errors from fleetctl are
+// retried since fleet takes a while to become ready after boot. Reaching
+// "failed" when waiting for another state ends the wait early.
+func waitUnitState(m platform.Machine, name, state string, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var last string
+	for {
+		out, err := fleetctl(m, "list-units", "-no-legend", "-full", "-fields", "unit,active")
+		if err != nil {
+			last = err.Error()
+		} else {
+			current, ok := unitState(out, name)
+			switch {
+			case !ok:
+				last = fmt.Sprintf("unit %s not listed by fleet:\n%s", name, out)
+			case current == state:
+				return nil
+			case current == "failed":
+				return fmt.Errorf("unit %s failed while waiting for %s:\n%s", name, state, out)
+			default:
+				last = fmt.Sprintf("unit %s is %s", name, current)
+			}
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for unit %s to be %s; last: %s", timeout, name, state, last)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// unitState finds name in the output of fleetctl list-units -fields
+// unit,active.
+func unitState(out []byte, name string) (string, bool) {
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == name {
+			return fields[1], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// fleetMachine answers SSH commands with canned responses in order.
+type fleetMachine struct {
+	platform.Machine
+	responses []fleetResponse
+	calls     int
+}
+
+type fleetResponse struct {
+	stdout string
+	err    error
+}
+
+func (m *fleetMachine) ID() string {
+	return "fake"
+}
+
+func (m *fleetMachine) SSH(cmd string) ([]byte, []byte, error) {
+	r := m.responses[len(m.responses)-1]
+	if m.calls < len(m.responses) {
+		r = m.responses[m.calls]
+	}
+	m.calls++
+	return []byte(r.stdout), nil, r.err
+}
+
+func TestWaitUnitState(t *testing.T) {
+	notReady := fleetResponse{err: errors.New("Error retrieving list of units from repository")}
+
+	for _, tc := range []struct {
+		desc      string
+		responses []fleetResponse
+		calls     int // expected polls, if not timing out
+		err       string
+		timesOut  bool
+	}{
+		{
+			desc:      "already active",
+			responses: []fleetResponse{{stdout: "hello.service\tactive\n"}},
+			calls:     1,
+		},
+		{
+			desc: "fleet not ready, then activating",
+			responses: []fleetResponse{
+				notReady,
+				{stdout: ""},
+				{stdout: "hello.service\tinactive\nother.service\tactive\n"},
+				{stdout: "hello.service\tactivating\n"},
+				{stdout: "hello.service\tactive\n"},
+			},
+			calls: 5,
+		},
+		{
+			desc: "failed",
+			responses: []fleetResponse{
+				{stdout: "hello.service\tactivating\n"},
+				{stdout: "hello.service\tfailed\n"},
+			},
+			calls: 2,
+			err:   "unit hello.service failed while waiting for active",
+		},
+		{
+			desc:      "timeout reports last fleetctl error",
+			responses: []fleetResponse{notReady},
+			err:       "Error retrieving list of units",
+			timesOut:  true,
+		},
+		{
+			desc:      "timeout reports last state",
+			responses: []fleetResponse{{stdout: "hello.service\tinactive\n"}},
+			err:       "last: unit hello.service is inactive",
+			timesOut:  true,
+		},
+	} {
+		// a loaded host mustn't time out the cases which shouldn't
+		timeout := time.Second
+		if tc.timesOut {
+			timeout = 10 * time.Millisecond
+		}
+		m := &fleetMachine{responses: tc.responses}
+		err := waitUnitState(m, "hello.service", "active", timeout, time.Millisecond)
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.desc, err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.desc, tc.err, err)
+		}
+		if tc.calls != 0 && m.calls != tc.calls {
+			t.Errorf("%s: expected %d polls, got %d", tc.desc, tc.calls, m.calls)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// Mount is an entry of /proc/mounts.
+type Mount struct {
+	Device     string
+	MountPoint string
+	FsType     string
+	Options    []string
+}
+
+// ReadOnly reports whether the filesystem is mounted read-only.
+func (m Mount) ReadOnly() bool {
+	for _, o := range m.Options {
+		if o == "ro" {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMounts parses a mount table in the format of /proc/mounts, in
+// which spaces, tabs, newlines and backslashes in the device and mount
+// point are octal escapes.
+func ParseMounts(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	lines := bufio.NewScanner(r)
+	for lines.Scan() {
+		fields := strings.Fields(lines.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("mount table line has %d fields, not 6: %q", len(fields), lines.Text())
+		}
+		device, err := unescapeMountField(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		mountPoint, err := unescapeMountField(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, Mount{
+			Device:     device,
+			MountPoint: mountPoint,
+			FsType:     fields[2],
+			Options:    strings.Split(fields[3], ","),
+		})
+	}
+	return mounts, lines.Err()
+}
+
+// unescapeMountField replaces the kernel's \ooo escapes in s.
+func unescapeMountField(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+4 > len(s) {
+			return "", fmt.Errorf("truncated escape in mount table field %q", s)
+		}
+		c, err := strconv.ParseUint(s[i+1:i+4], 8, 8)
+		if err != nil {
+			return "", fmt.Errorf("bad escape in mount table field %q", s)
+		}
+		b.WriteByte(byte(c))
+		i += 3
+	}
+	return b.String(), nil
+}
+
+// MountFor returns the mount holding the absolute, clean path p: the one
+// with the longest mount point containing p, mounted last if several
+// share it.
+func MountFor(mounts []Mount, p string) (Mount, bool) {
+	p = path.Clean(p)
+	var found Mount
+	ok := false
+	for _, m := range mounts {
+		mp := path.Clean(m.MountPoint)
+		if !(mp == "/" || p == mp || strings.HasPrefix(p, mp+"/")) {
+			continue
+		}
+		if !ok || len(mp) >= len(path.Clean(found.MountPoint)) {
+			found, ok = m, true
+		}
+	}
+	return found, ok
+}
+
+// VerityStatus is the state of a dm-verity device as reported by
+// veritysetup status.
+type VerityStatus struct {
+	Active bool
+	// Fields holds the "key: value" lines, e.g. "type" and "status",
+	// which not every version of veritysetup prints.
+	Fields map[string]string
+}
+
+// ParseVeritysetupStatus parses the output of veritysetup status. The
+// first line says whether the device is active, followed by indented
+// "key: value" lines if it is.
+func ParseVeritysetupStatus(out string) (VerityStatus, error) {
+	s := VerityStatus{Fields: make(map[string]string)}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	first := strings.TrimSpace(lines[0])
+	switch {
+	case strings.HasSuffix(first, " is inactive."):
+	case strings.Contains(first, " is active"):
+		s.Active = true
+	default:
+		return s, fmt.Errorf("unrecognized veritysetup status: %q", first)
+	}
+	for _, line := range lines[1:] {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		s.Fields[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	return s, nil
+}
+
+// ParseDmsetupVerity parses the output of dmsetup status --target verity
+// for a single device: start, length, target and "V" for a valid or "C"
+// for a corrupted device, possibly followed by newer fields.
+func ParseDmsetupVerity(out string) (valid bool, err error) {
+	fields := strings.Fields(out)
+	if len(fields) < 4 || fields[2] != "verity" {
+		return false, fmt.Errorf("unrecognized dmsetup verity status: %q", out)
+	}
+	switch fields[3] {
+	case "V":
+		return true, nil
+	case "C":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unrecognized dmsetup verity status: %q", out)
+	}
+}
+
+// AssertMountReadOnly fails the test unless the filesystem holding path
+// on m is mounted read-only.
+func (t *TestCluster) AssertMountReadOnly(m platform.Machine, p string) {
+	resolved, err := t.SSH(m, fmt.Sprintf("realpath -e %s", ShellQuote(p)))
+	if err != nil {
+		t.Fatalf("resolving %s: %v", p, err)
+	}
+	table, err := t.SSH(m, "cat /proc/self/mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mounts, err := ParseMounts(strings.NewReader(string(table)))
+	if err != nil {
+		t.Fatalf("parsing mounts of %s: %v", m.ID(), err)
+	}
+	mount, ok := MountFor(mounts, strings.TrimSpace(string(resolved)))
+	if !ok {
+		t.Fatalf("no filesystem holds %s on %s", p, m.ID())
+	}
+	if !mount.ReadOnly() {
+		t.Errorf("%s on %s is on %s mounted read-write from %s (%s)", p, m.ID(), mount.MountPoint, mount.Device, strings.Join(mount.Options, ","))
+	}
+}
+
+// AssertVerityActive fails the test unless the dm-verity device of /usr
+// on m is active and has not seen corrupted blocks, according to both
+// veritysetup and device mapper.
+func (t *TestCluster) AssertVerityActive(m platform.Machine) {
+	// veritysetup exits nonzero for an inactive device; parse the
+	// output regardless
+	out, _ := t.SSH(m, "sudo veritysetup status usr")
+	status, err := ParseVeritysetupStatus(string(out))
+	if err != nil {
+		t.Fatalf("checking verity on %s: %v", m.ID(), err)
+	}
+	if !status.Active {
+		t.Fatalf("verity is not active for /usr on %s", m.ID())
+	}
+	if typ, ok := status.Fields["type"]; ok && typ != "VERITY" {
+		t.Errorf("/usr on %s is a %s device, not VERITY", m.ID(), typ)
+	}
+	if s, ok := status.Fields["status"]; ok && s != "verified" {
+		t.Errorf("veritysetup reports /usr on %s as %s", m.ID(), s)
+	}
+
+	out, err = t.SSH(m, "sudo dmsetup status --target verity usr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := ParseDmsetupVerity(string(out))
+	if err != nil {
+		t.Fatalf("checking verity on %s: %v", m.ID(), err)
+	}
+	if !valid {
+		t.Errorf("device mapper reports corrupted blocks in /usr on %s", m.ID())
+	}
+}
+
+// maxListedWrites bounds how many modified files AssertNoWritesSinceBoot
+// lists.
+const maxListedWrites = 20
+
+// noWritesCommand lists the files under p modified since boot, staying
+// on p's filesystem. The marker is stamped with the boot time, from the
+// uptime, for find -newer.
+func noWritesCommand(p string) string {
+	return fmt.Sprintf(`marker=$(mktemp) && touch -d "@$(( $(date +%%s) - $(cut -d. -f1 /proc/uptime) ))" "$marker" && sudo find %s -xdev -newer "$marker" -print; status=$?; rm -f "$marker"; exit $status`, ShellQuote(p))
+}
+
+// AssertNoWritesSinceBoot fails the test if any file under path on m,
+// on the same filesystem, was modified since m booted.
+func (t *TestCluster) AssertNoWritesSinceBoot(m platform.Machine, p string) {
+	out, err := t.SSH(m, noWritesCommand(p))
+	if err != nil {
+		t.Fatalf("finding files written since boot: %v", err)
+	}
+	listed := strings.TrimSpace(string(out))
+	if listed == "" {
+		return
+	}
+	files := strings.Split(listed, "\n")
+	total := len(files)
+	more := ""
+	if total > maxListedWrites {
+		more = fmt.Sprintf("\n(and %d more)", total-maxListedWrites)
+		files = files[:maxListedWrites]
+	}
+	t.Errorf("%d files under %s on %s were written since boot:\n%s%s", total, p, m.ID(), strings.Join(files, "\n"), more)
+}
@@ -0,0 +1,256 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMounts = `sysfs /sys sysfs rw,nosuid,nodev,noexec,relatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/vda9 / ext4 rw,seclabel,relatime 0 0
+/dev/mapper/usr /usr ext4 ro,seclabel,relatime,block_validity,delalloc,barrier,user_xattr,acl 0 0
+tmpfs /media tmpfs rw,seclabel,nosuid,nodev,noexec,relatime 0 0
+/dev/vda6 /usr/share/oem ext4 rw,seclabel,nodev,relatime,commit=600 0 0
+/dev/vdb /mnt/with\040space ext4 rw,relatime 0 0
+/dev/vdc /usr/share/oem ext4 ro,relatime 0 0
+`
+
+func TestParseMounts(t *testing.T) {
+	mounts, err := ParseMounts(strings.NewReader(testMounts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 8 {
+		t.Fatalf("parsed %d mounts, want 8", len(mounts))
+	}
+	usr := Mount{
+		Device:     "/dev/mapper/usr",
+		MountPoint: "/usr",
+		FsType:     "ext4",
+		Options:    []string{"ro", "seclabel", "relatime", "block_validity", "delalloc", "barrier", "user_xattr", "acl"},
+	}
+	if !reflect.DeepEqual(mounts[3], usr) {
+		t.Errorf("parsed %+v, want %+v", mounts[3], usr)
+	}
+	if mp := mounts[6].MountPoint; mp != "/mnt/with space" {
+		t.Errorf("escaped mount point parsed as %q", mp)
+	}
+
+	for _, bad := range []string{
+		"/dev/vda9 / ext4 rw\n",
+		`/dev/vda9 /a\04 ext4 rw 0 0`,
+		`/dev/vda9 /a\9zz ext4 rw 0 0`,
+	} {
+		if _, err := ParseMounts(strings.NewReader(bad)); err == nil {
+			t.Errorf("parsed invalid mount table %q", bad)
+		}
+	}
+}
+
+func TestMountFor(t *testing.T) {
+	mounts, err := ParseMounts(strings.NewReader(testMounts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path     string
+		device   string
+		readOnly bool
+	}{
+		{"/", "/dev/vda9", false},
+		{"/etc/os-release", "/dev/vda9", false},
+		{"/usr", "/dev/mapper/usr", true},
+		{"/usr/lib/os-release", "/dev/mapper/usr", true},
+		{"/usrlocal", "/dev/vda9", false},
+		{"/usr/share/oem/", "/dev/vdc", true}, // mounted over
+		{"/usr/share/oem/grub.cfg", "/dev/vdc", true},
+		{"/mnt/with space/file", "/dev/vdb", false},
+	} {
+		m, ok := MountFor(mounts, tt.path)
+		if !ok {
+			t.Errorf("%s: no mount found", tt.path)
+			continue
+		}
+		if m.Device != tt.device || m.ReadOnly() != tt.readOnly {
+			t.Errorf("%s: found %s, read-only %v; want %s, %v", tt.path, m.Device, m.ReadOnly(), tt.device, tt.readOnly)
+		}
+	}
+	if _, ok := MountFor(nil, "/usr"); ok {
+		t.Error("found a mount in an empty table")
+	}
+}
+
+func TestParseVeritysetupStatus(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		out    string
+		active bool
+		fields map[string]string
+		err    bool
+	}{
+		{
+			desc: "cryptsetup 1.6",
+			out: `/dev/mapper/usr is active.
+  type:        VERITY
+  device:      /dev/vda3
+  hash type:   1
+`,
+			active: true,
+			fields: map[string]string{"type": "VERITY", "device": "/dev/vda3", "hash type": "1"},
+		},
+		{
+			desc: "cryptsetup 1.7",
+			out: `/dev/mapper/usr is active and is in use.
+  type:        VERITY
+  status:      verified
+  hash type:   1
+  data block:  4096
+  hash block:  4096
+  hash name:   sha256
+  salt:        -
+  data device: /dev/vda3
+  size:        2097152 sectors
+  mode:        readonly
+  hash device: /dev/vda3
+  hash offset: 2097160 sectors
+`,
+			active: true,
+			fields: map[string]string{"type": "VERITY", "status": "verified", "hash type": "1", "data block": "4096",
+				"hash block": "4096", "hash name": "sha256", "salt": "-", "data device": "/dev/vda3",
+				"size": "2097152 sectors", "mode": "readonly", "hash device": "/dev/vda3", "hash offset": "2097160 sectors"},
+		},
+		{
+			desc: "cryptsetup 2 corrupted",
+			out: `/dev/mapper/usr is active and is in use.
+  type:        VERITY
+  status:      corrupted
+  flags:       panic_on_corruption
+`,
+			active: true,
+			fields: map[string]string{"type": "VERITY", "status": "corrupted", "flags": "panic_on_corruption"},
+		},
+		{
+			desc:   "inactive",
+			out:    "/dev/mapper/usr is inactive.\n",
+			fields: map[string]string{},
+		},
+		{
+			desc: "unrecognized",
+			out:  "Device usr not found\n",
+			err:  true,
+		},
+		{
+			desc: "empty",
+			err:  true,
+		},
+	} {
+		s, err := ParseVeritysetupStatus(tt.out)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: parsed %+v", tt.desc, s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.desc, err)
+			continue
+		}
+		if s.Active != tt.active || !reflect.DeepEqual(s.Fields, tt.fields) {
+			t.Errorf("%s: parsed %+v, want active %v, fields %v", tt.desc, s, tt.active, tt.fields)
+		}
+	}
+}
+
+func TestParseDmsetupVerity(t *testing.T) {
+	for _, tt := range []struct {
+		out   string
+		valid bool
+		err   bool
+	}{
+		{"0 2097152 verity V\n", true, false},
+		{"0 2097152 verity C\n", false, false},
+		{"0 2097152 verity V -\n", true, false}, // newer kernels add fields
+		{"0 2097152 linear 253:0 0\n", false, true},
+		{"0 2097152 verity\n", false, true},
+		{"0 2097152 verity X\n", false, true},
+		{"", false, true},
+	} {
+		valid, err := ParseDmsetupVerity(tt.out)
+		if valid != tt.valid || (err != nil) != tt.err {
+			t.Errorf("%q: got %v, %v; want %v, error %v", tt.out, valid, err, tt.valid, tt.err)
+		}
+	}
+}
+
+func TestNoWritesCommand(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	if _, err := os.Stat("/proc/uptime"); err != nil {
+		t.Skip("no /proc/uptime")
+	}
+	dir, err := ioutil.TempDir("", "kola-writes-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a sudo which just runs the command
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "sudo"), []byte("#!/bin/sh\nexec \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := filepath.Join(dir, "with space")
+	if err := os.Mkdir(tree, 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := filepath.Join(tree, "old")
+	written := filepath.Join(tree, "written")
+	for _, f := range []string{old, written} {
+		if err := ioutil.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// older than any boot
+	epoch := time.Unix(0, 0)
+	for _, f := range []string{old, tree} {
+		if err := os.Chtimes(f, epoch, epoch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(sh, "-c", noWritesCommand(tree))
+	cmd.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != written {
+		t.Errorf("listed %q, want %q", got, written)
+	}
+}
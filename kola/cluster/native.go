@@ -0,0 +1,158 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/mantle/kola/native"
+	"github.com/coreos/mantle/platform"
+)
+
+// The wrappers here run the library functions of package native through
+// kolet, which is only on the machines of tests with NativeFuncs or
+// NativeLibrary set.
+
+// libResult is a native.Result whose value is decoded later.
+type libResult struct {
+	Value json.RawMessage `json:"value"`
+	Error string          `json:"error"`
+}
+
+// decodeLib decodes the output of kolet lib into v, or returns the error
+// of the library function. A value left out is the zero value.
+func decodeLib(out []byte, v interface{}) error {
+	var r libResult
+	if err := json.Unmarshal(out, &r); err != nil {
+		return fmt.Errorf("parsing kolet output %q: %v", out, err)
+	}
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+	if len(r.Value) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(r.Value, v); err != nil {
+		return fmt.Errorf("parsing kolet value %s: %v", r.Value, err)
+	}
+	return nil
+}
+
+// CallNative runs the test's NativeCalls function funcName on m with in,
+// encoded as JSON, as its argument and decodes its result into out,
+// which may be nil to discard it. It returns the function's error as is,
+// and errors running kolet prefixed with "kolet".
+func (t *TestCluster) CallNative(funcName string, m platform.Machine, in, out interface{}) error {
+	arg, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding argument of %s: %v", funcName, err)
+	}
+	cmd, err := EnvCommand(t.Env, fmt.Sprintf("./kolet call %q %q", t.Name(), funcName))
+	if err != nil {
+		return fmt.Errorf("kolet: %v", err)
+	}
+
+	client, err := m.SSHClient()
+	if err != nil {
+		return fmt.Errorf("kolet SSH client: %v", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("kolet SSH session: %v", err)
+	}
+	defer session.Close()
+
+	// the result alone is on stdout
+	var stderr bytes.Buffer
+	session.Stdin = bytes.NewReader(arg)
+	session.Stderr = &stderr
+
+	// only read once kolet has returned; closing the client on
+	// timeout ends it
+	var b []byte
+	var runErr error
+	budget := Budget(t.H, "native function", t.Timeouts.WithDefaults().Native)
+	if err := runLimited(t.Context(), budget, func() {
+		b, runErr = session.Output(cmd)
+	}); err != nil {
+		return fmt.Errorf("kolet %s: %v", funcName, err)
+	}
+	if stderr.Len() > 0 {
+		t.Logf("kolet %s:\n%s", funcName, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if runErr != nil {
+		return fmt.Errorf("kolet %s: %v", funcName, runErr)
+	}
+	if out == nil {
+		out = new(json.RawMessage)
+	}
+	return decodeLib(b, out)
+}
+
+// lib runs the library function fn with args on m and decodes its value
+// into v, failing the test if it cannot.
+func (t *TestCluster) lib(m platform.Machine, v interface{}, fn string, args ...string) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = ShellQuote(arg)
+	}
+	cmd := fmt.Sprintf("./kolet lib %s %s", fn, strings.Join(quoted, " "))
+	out, err := t.SSH(m, cmd)
+	if err != nil {
+		t.Fatalf("kolet %s on %s: %v", fn, m.ID(), err)
+	}
+	if err := decodeLib(out, v); err != nil {
+		t.Fatalf("kolet %s %s on %s: %v", fn, strings.Join(args, " "), m.ID(), err)
+	}
+}
+
+// Sysctl returns the value of the sysctl name on m, e.g.
+// "net.ipv4.ip_forward", with surrounding whitespace removed.
+func (t *TestCluster) Sysctl(m platform.Machine, name string) string {
+	var value string
+	t.lib(m, &value, "sysctl", name)
+	return value
+}
+
+// StatFile returns the mode, ownership, size and SELinux label of path
+// on m, following symlinks.
+func (t *TestCluster) StatFile(m platform.Machine, path string) native.FileInfo {
+	var fi native.FileInfo
+	t.lib(m, &fi, "stat", path)
+	return fi
+}
+
+// UnitProperty decodes the property of unit on m from systemd's D-Bus
+// API into v, which should be a pointer to the Go type of the property's
+// D-Bus type, e.g. a string for ActiveState or a uint32 for a service's
+// MainPID. Structs are decoded as arrays of their fields.
+func (t *TestCluster) UnitProperty(m platform.Machine, unit, property string, v interface{}) {
+	t.lib(m, v, "unit-property", unit, property)
+}
+
+// KernelConfig returns the value of option, with or without its CONFIG_
+// prefix, in the config of the kernel running on m: "y", "m", a string
+// or number as written, or "n" if it is not set.
+func (t *TestCluster) KernelConfig(m platform.Machine, option string) string {
+	var value string
+	t.lib(m, &value, "kernel-config", option)
+	return value
+}
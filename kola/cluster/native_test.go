@@ -0,0 +1,145 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/mantle/kola/native"
+)
+
+func TestDecodeLibSysctl(t *testing.T) {
+	var value string
+	if err := decodeLib([]byte(`{"value":"1"}`+"\n"), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value != "1" {
+		t.Errorf("got %q, want \"1\"", value)
+	}
+
+	// an empty value is left out
+	value = "stale"
+	if err := decodeLib([]byte(`{}`), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value != "stale" {
+		t.Errorf("got %q, want the value untouched", value)
+	}
+}
+
+func TestDecodeLibStat(t *testing.T) {
+	out := `{"value":{"path":"/etc/shadow","mode":416,"uid":0,"gid":0,"size":731,"label":"system_u:object_r:shadow_t:s0"}}`
+	var fi native.FileInfo
+	if err := decodeLib([]byte(out), &fi); err != nil {
+		t.Fatal(err)
+	}
+	want := native.FileInfo{
+		Path:  "/etc/shadow",
+		Mode:  0640,
+		Size:  731,
+		Label: "system_u:object_r:shadow_t:s0",
+	}
+	if fi != want {
+		t.Errorf("got %+v, want %+v", fi, want)
+	}
+
+	// what kolet prints decodes to what it encoded
+	want = native.FileInfo{Path: "/run/foo", Mode: os.ModeDir | os.ModeSticky | 0755, UID: 500, GID: 500}
+	b, err := json.Marshal(native.Result{Value: want})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi = native.FileInfo{}
+	if err := decodeLib(b, &fi); err != nil {
+		t.Fatal(err)
+	}
+	if fi != want {
+		t.Errorf("round trip: got %+v, want %+v", fi, want)
+	}
+}
+
+func TestDecodeLibUnitProperty(t *testing.T) {
+	var state string
+	if err := decodeLib([]byte(`{"value":"active"}`), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state != "active" {
+		t.Errorf("got %q, want \"active\"", state)
+	}
+
+	var pid uint32
+	if err := decodeLib([]byte(`{"value":812}`), &pid); err != nil {
+		t.Fatal(err)
+	}
+	if pid != 812 {
+		t.Errorf("got %d, want 812", pid)
+	}
+
+	// ExecStart is an array of structs
+	var execStart [][]interface{}
+	out := `{"value":[["/usr/bin/etcd",["/usr/bin/etcd","--name","a"],false,0,0,0,0,0,0,0]]}`
+	if err := decodeLib([]byte(out), &execStart); err != nil {
+		t.Fatal(err)
+	}
+	if len(execStart) != 1 || execStart[0][0] != "/usr/bin/etcd" {
+		t.Errorf("got %v", execStart)
+	}
+
+	if err := decodeLib([]byte(`{"value":"active"}`), &pid); err == nil {
+		t.Error("decoded a string into a uint32")
+	}
+}
+
+func TestDecodeLibKernelConfig(t *testing.T) {
+	for out, want := range map[string]string{
+		`{"value":"y"}`:           "y",
+		`{"value":"m"}`:           "m",
+		`{"value":"n"}`:           "n",
+		`{"value":"\"-coreos\""}`: `"-coreos"`,
+	} {
+		var value string
+		if err := decodeLib([]byte(out), &value); err != nil {
+			t.Fatal(err)
+		}
+		if value != want {
+			t.Errorf("%s: got %q, want %q", out, value, want)
+		}
+	}
+}
+
+func TestDecodeLibErrors(t *testing.T) {
+	var value string
+	err := decodeLib([]byte(`{"error":"open /proc/sys/net/ipv4/nope: no such file or directory"}`), &value)
+	if err == nil || err.Error() != "open /proc/sys/net/ipv4/nope: no such file or directory" {
+		t.Errorf("got %v, want the function's error", err)
+	}
+
+	for _, out := range []string{"", "Usage:\n  kolet lib sysctl name", `{"value":`} {
+		if err := decodeLib([]byte(out), &value); err == nil {
+			t.Errorf("%q: decoded", out)
+		}
+	}
+
+	var values []string
+	if err := decodeLib([]byte(`{"value":["a","b"]}`), &values); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []string{"a", "b"}) {
+		t.Errorf("got %v", values)
+	}
+}
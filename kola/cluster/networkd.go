@@ -0,0 +1,165 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// networkdUnitDir is where WriteNetworkUnit puts units, taking precedence
+// over those shipped in /usr/lib/systemd/network.
+const networkdUnitDir = "/etc/systemd/network"
+
+// networkdPollInterval is how often WaitNetworkState polls networkctl.
+const networkdPollInterval = time.Second
+
+// networkdUnitTypes are the extensions of the units systemd-networkd reads.
+var networkdUnitTypes = []string{".network", ".netdev", ".link"}
+
+// WriteNetworkUnit writes a systemd-networkd unit, e.g. "50-kola.network",
+// with contents to /etc/systemd/network on m. It takes effect when
+// networkd restarts; see RestartNetworkd. On reused machines the unit is
+// removed and networkd restarted when the test finishes, so later tests
+// see the network as configured at boot.
+func (t *TestCluster) WriteNetworkUnit(m platform.Machine, name, contents string) {
+	if err := checkNetworkUnitName(name); err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(networkdUnitDir, name)
+	if err := platform.InstallFileMode(strings.NewReader(contents), m, file, 0644); err != nil {
+		t.Fatalf("writing network unit %s to %s: %v", name, m.ID(), err)
+	}
+	if !t.ReusedMachines {
+		return
+	}
+	t.Cleanup(func() {
+		// a machine the test destroyed won't be reused
+		if !t.hasMachine(m) {
+			return
+		}
+		if err := removeNetworkUnit(m, file); err != nil {
+			t.Errorf("%v", err)
+		}
+	})
+}
+
+// checkNetworkUnitName returns an error unless name is the file name of a
+// unit systemd-networkd reads.
+func checkNetworkUnitName(name string) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("network unit name %q is not a file name", name)
+	}
+	for _, ext := range networkdUnitTypes {
+		if strings.HasSuffix(name, ext) && len(name) > len(ext) {
+			return nil
+		}
+	}
+	return fmt.Errorf("network unit name %q doesn't end with one of %s", name, strings.Join(networkdUnitTypes, ", "))
+}
+
+// removeNetworkUnit removes the unit file from m and restarts networkd to
+// drop its configuration.
+func removeNetworkUnit(m platform.Machine, file string) error {
+	if _, stderr, err := m.SSH(fmt.Sprintf("sudo rm -f %q", file)); err != nil {
+		return fmt.Errorf("removing network unit %s on %s: %v: %s", file, m.ID(), err, stderr)
+	}
+	return restartNetworkd(m)
+}
+
+// RestartNetworkd restarts systemd-networkd on m so that it applies the
+// current units. Links it created from removed .netdev units are left
+// in place; .link units are applied by udev when a link appears.
+func (t *TestCluster) RestartNetworkd(m platform.Machine) {
+	if err := restartNetworkd(m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func restartNetworkd(m platform.Machine) error {
+	if _, stderr, err := m.SSH("sudo systemctl restart systemd-networkd.service"); err != nil {
+		return fmt.Errorf("restarting systemd-networkd on %s: %v: %s", m.ID(), err, stderr)
+	}
+	return nil
+}
+
+// WaitNetworkState polls networkctl on m until the link iface is in the
+// operational state operState, e.g. "routable" or "degraded", failing
+// the test if timeout passes first or networkd fails to configure it.
+func (t *TestCluster) WaitNetworkState(m platform.Machine, iface, operState string, timeout time.Duration) {
+	if err := waitNetworkState(m, iface, operState, timeout, networkdPollInterval); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitNetworkState polls until iface reaches state. Errors from networkctl
+// are retried since networkd may be restarting, as are links which don't
+// exist yet, e.g. from a .netdev being created.
+func waitNetworkState(m platform.Machine, iface, state string, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var last string
+	for {
+		out, stderr, err := m.SSH("networkctl list --no-pager")
+		if err != nil {
+			last = fmt.Sprintf("networkctl failed: %v: %s", err, stderr)
+		} else {
+			link, ok := networkLinkState(out, iface)
+			switch {
+			case !ok:
+				last = fmt.Sprintf("link %s not listed by networkctl:\n%s", iface, out)
+			case link.Setup == "failed":
+				return fmt.Errorf("networkd failed to configure link %s on %s while waiting for %s:\n%s", iface, m.ID(), state, out)
+			case link.Operational == state:
+				return nil
+			default:
+				last = fmt.Sprintf("link %s is %s (%s)", iface, link.Operational, link.Setup)
+			}
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for link %s on %s to be %s; last: %s", timeout, iface, m.ID(), state, last)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// networkLink is a link as listed by networkctl.
+type networkLink struct {
+	Operational string // e.g. "routable", "degraded" or "off"
+	Setup       string // e.g. "configured", "configuring" or "unmanaged"
+}
+
+// networkLinkState finds iface in the output of networkctl list, whose
+// columns are IDX LINK TYPE OPERATIONAL SETUP. Lines other than links,
+// such as the header and the "N links listed." footer which some
+// versions print even without a terminal, are skipped.
+func networkLinkState(out []byte, iface string) (networkLink, bool) {
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[1] != iface {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[0]); err != nil {
+			continue
+		}
+		return networkLink{Operational: fields[3], Setup: fields[4]}, true
+	}
+	return networkLink{}, false
+}
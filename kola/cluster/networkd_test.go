@@ -0,0 +1,158 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// networkdMachine records the commands run on it and answers them like a
+// fleetMachine.
+type networkdMachine struct {
+	fleetMachine
+	cmds []string
+}
+
+func (m *networkdMachine) SSH(cmd string) ([]byte, []byte, error) {
+	m.cmds = append(m.cmds, cmd)
+	return m.fleetMachine.SSH(cmd)
+}
+
+const networkctlList = `IDX LINK             TYPE               OPERATIONAL SETUP
+  1 lo               loopback           carrier     unmanaged
+  2 eth0             ether              routable    configured
+  3 kola0            ether              no-carrier  configuring
+  4 bad0             ether              off         failed
+
+4 links listed.
+`
+
+func TestNetworkLinkState(t *testing.T) {
+	for _, tc := range []struct {
+		out   string
+		iface string
+		link  networkLink
+		ok    bool
+	}{
+		{networkctlList, "eth0", networkLink{"routable", "configured"}, true},
+		{networkctlList, "kola0", networkLink{"no-carrier", "configuring"}, true},
+		{networkctlList, "eth1", networkLink{}, false},
+		// the header's LINK column isn't a link
+		{networkctlList, "LINK", networkLink{}, false},
+		// without the header and footer, as with --no-legend
+		{"  7 dummy0 ether degraded configured\n", "dummy0", networkLink{"degraded", "configured"}, true},
+		{"", "eth0", networkLink{}, false},
+	} {
+		link, ok := networkLinkState([]byte(tc.out), tc.iface)
+		if link != tc.link || ok != tc.ok {
+			t.Errorf("%s: got %+v, %v; want %+v, %v", tc.iface, link, ok, tc.link, tc.ok)
+		}
+	}
+}
+
+func TestWaitNetworkState(t *testing.T) {
+	restarting := fleetResponse{err: errors.New("Failed to connect to bus")}
+	for _, tc := range []struct {
+		desc      string
+		iface     string
+		responses []fleetResponse
+		calls     int // expected polls, if not timing out
+		err       string
+	}{
+		{
+			desc:      "already routable",
+			iface:     "eth0",
+			responses: []fleetResponse{{stdout: networkctlList}},
+			calls:     1,
+		},
+		{
+			desc:  "networkd restarting, link created, then configured",
+			iface: "dummy0",
+			responses: []fleetResponse{
+				restarting,
+				{stdout: networkctlList},
+				{stdout: "5 dummy0 ether off configuring\n"},
+				{stdout: "5 dummy0 ether routable configured\n"},
+			},
+			calls: 4,
+		},
+		{
+			desc:      "failed",
+			iface:     "bad0",
+			responses: []fleetResponse{{stdout: networkctlList}},
+			calls:     1,
+			err:       "networkd failed to configure link bad0",
+		},
+		{
+			desc:      "timeout reports last error",
+			iface:     "eth0",
+			responses: []fleetResponse{restarting},
+			err:       "Failed to connect to bus",
+		},
+		{
+			desc:      "timeout reports last state",
+			iface:     "kola0",
+			responses: []fleetResponse{{stdout: networkctlList}},
+			err:       "last: link kola0 is no-carrier (configuring)",
+		},
+	} {
+		m := &fleetMachine{responses: tc.responses}
+		err := waitNetworkState(m, tc.iface, "routable", 10*time.Millisecond, time.Millisecond)
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.desc, err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.desc, tc.err, err)
+		}
+		if tc.calls != 0 && m.calls != tc.calls {
+			t.Errorf("%s: expected %d polls, got %d", tc.desc, tc.calls, m.calls)
+		}
+	}
+}
+
+func TestCheckNetworkUnitName(t *testing.T) {
+	for _, name := range []string{"50-kola.network", "kola0.netdev", "10-kola.link"} {
+		if err := checkNetworkUnitName(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".network", "kola.service", "../kola.network", "kola.network.bak"} {
+		if err := checkNetworkUnitName(name); err == nil {
+			t.Errorf("%q accepted", name)
+		}
+	}
+}
+
+func TestRemoveNetworkUnit(t *testing.T) {
+	m := &networkdMachine{fleetMachine: fleetMachine{responses: []fleetResponse{{}}}}
+	if err := removeNetworkUnit(m, "/etc/systemd/network/50-kola.network"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`sudo rm -f "/etc/systemd/network/50-kola.network"`,
+		"sudo systemctl restart systemd-networkd.service",
+	}
+	if !reflect.DeepEqual(m.cmds, want) {
+		t.Errorf("ran %q, want %q", m.cmds, want)
+	}
+
+	m = &networkdMachine{fleetMachine: fleetMachine{responses: []fleetResponse{{err: errors.New("connection refused")}}}}
+	if err := removeNetworkUnit(m, "/etc/systemd/network/50-kola.network"); err == nil || len(m.cmds) != 1 {
+		t.Errorf("failed removal ran %q and returned %v", m.cmds, err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Random is the randomness of a test, reproducible from its seed. It is
+// safe for concurrent use, though values drawn concurrently are only
+// reproduced if drawn in the same order.
+type Random struct {
+	seed int64
+	rand *rand.Rand
+}
+
+// NewRandom returns the randomness of a test with seed.
+func NewRandom(seed int64) *Random {
+	return &Random{
+		seed: seed,
+		rand: rand.New(&lockedSource{src: rand.NewSource(seed)}),
+	}
+}
+
+// lockedSource serializes a rand.Source, which isn't safe for
+// concurrent use on its own.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// zeroRandom is the randomness of a TestCluster made without one.
+var zeroRandom = NewRandom(0)
+
+func (t *TestCluster) random() *Random {
+	if t.Random == nil {
+		return zeroRandom
+	}
+	return t.Random
+}
+
+// RandomSeed returns the seed of the test's randomness, which kola
+// prints if the test fails.
+func (t *TestCluster) RandomSeed() int64 {
+	return t.random().seed
+}
+
+// Rand returns the test's source of random numbers, e.g. for keys,
+// ports and file names, so that a failure can be reproduced with kola's
+// --master-seed. It is shared by the test's subtests.
+func (t *TestCluster) Rand() *rand.Rand {
+	return t.random().rand
+}
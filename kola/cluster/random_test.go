@@ -0,0 +1,59 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRandomReproducible(t *testing.T) {
+	a := TestCluster{Random: NewRandom(7)}
+	b := TestCluster{Random: NewRandom(7)}
+	if a.RandomSeed() != 7 {
+		t.Errorf("seed %d, want 7", a.RandomSeed())
+	}
+	for i := 0; i < 100; i++ {
+		if x, y := a.Rand().Int63(), b.Rand().Int63(); x != y {
+			t.Fatalf("draw %d: %d != %d with the same seed", i, x, y)
+		}
+	}
+
+	// subtests share the sequence rather than restarting it
+	sub := a
+	if a.Rand().Int63() == sub.Rand().Int63() {
+		t.Error("a copy of the cluster restarted the sequence")
+	}
+
+	var zero TestCluster
+	if zero.RandomSeed() != 0 || zero.Rand() == nil {
+		t.Error("a cluster without randomness has none")
+	}
+}
+
+func TestRandomConcurrent(t *testing.T) {
+	c := TestCluster{Random: NewRandom(1)}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Rand().Intn(65536)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/util"
+)
+
+// RebootInfo describes a completed reboot.
+type RebootInfo struct {
+	Machine   string
+	OldBootID string
+	NewBootID string
+	Duration  time.Duration // from requesting the reboot until the machine passed its checks
+}
+
+func (t *TestCluster) bootID(m platform.Machine) (string, error) {
+	out, err := t.SSH(m, "cat /proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return "", fmt.Errorf("reading boot id of %s: %v", m.ID(), err)
+	}
+	return string(out), nil
+}
+
+// RebootAndWait reboots m and waits until it has come back with a new boot
+// ID, then checks it again as it was checked when created, since not every
+// platform's Machine.Reboot does. A successful return means the machine is
+// usable again.
+func (t *TestCluster) RebootAndWait(m platform.Machine) (RebootInfo, error) {
+	info := RebootInfo{Machine: m.ID()}
+
+	var err error
+	info.OldBootID, err = t.bootID(m)
+	if err != nil {
+		return info, err
+	}
+
+	start := time.Now()
+	if err := m.Reboot(); err != nil {
+		return info, err
+	}
+
+	info.NewBootID, err = t.bootID(m)
+	if err != nil {
+		return info, err
+	}
+	if info.NewBootID == info.OldBootID {
+		return info, fmt.Errorf("machine %s did not reboot: boot id is still %s", m.ID(), info.OldBootID)
+	}
+	if err := platform.CheckMachine(t.Context(), m); err != nil {
+		return info, fmt.Errorf("machine %s unhealthy after rebooting: %v", m.ID(), err)
+	}
+	info.Duration = time.Since(start)
+
+	return info, nil
+}
+
+// RebootAllSerially reboots every machine in the cluster in turn, in order
+// of their IDs, waiting for etcd to report a healthy cluster on each
+// machine before rebooting the next one. It stops at the first failure.
+func (t *TestCluster) RebootAllSerially() ([]RebootInfo, error) {
+	machines := append([]platform.Machine(nil), t.Machines()...)
+	sort.Slice(machines, func(i, j int) bool { return machines[i].ID() < machines[j].ID() })
+
+	var infos []RebootInfo
+	for _, m := range machines {
+		info, err := t.RebootAndWait(m)
+		if err != nil {
+			return infos, err
+		}
+		infos = append(infos, info)
+
+		if err := t.waitEtcdHealthy(m); err != nil {
+			return infos, err
+		}
+	}
+	return infos, nil
+}
+
+func (t *TestCluster) waitEtcdHealthy(m platform.Machine) error {
+	var out []byte
+	checker := func() error {
+		var err error
+		out, err = t.SSH(m, "etcdctl cluster-health")
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(out, []byte("cluster is healthy")) {
+			return fmt.Errorf("cluster is not healthy")
+		}
+		return nil
+	}
+
+	if err := util.Retry(15, 10*time.Second, checker); err != nil {
+		return fmt.Errorf("etcd unhealthy after rebooting %s: %v: %s", m.ID(), err, out)
+	}
+	return nil
+}
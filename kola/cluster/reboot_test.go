@@ -0,0 +1,151 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/platform"
+)
+
+// rebootMachine gets a new boot ID each time it reboots, unless stuck,
+// and logs its reboots and health checks to log.
+type rebootMachine struct {
+	platform.Machine
+	id         string
+	boots      int
+	stuck      bool
+	failedUnit string
+	log        *[]string
+}
+
+func (m *rebootMachine) ID() string {
+	return m.id
+}
+
+func (m *rebootMachine) RuntimeConf() platform.RuntimeConfig {
+	return platform.RuntimeConfig{NoUserDataWait: true}
+}
+
+func (m *rebootMachine) Reboot() error {
+	*m.log = append(*m.log, "reboot "+m.id)
+	if !m.stuck {
+		m.boots++
+	}
+	return nil
+}
+
+func (m *rebootMachine) SSH(cmd string) ([]byte, []byte, error) {
+	switch {
+	case strings.Contains(cmd, "boot_id"):
+		return []byte(fmt.Sprintf("%s-%d", m.id, m.boots)), nil, nil
+	case strings.Contains(cmd, "is-system-running"):
+		*m.log = append(*m.log, "check "+m.id)
+		return []byte("running"), nil, nil
+	case strings.Contains(cmd, "os-release"):
+		return []byte("ID=coreos"), nil, nil
+	case strings.Contains(cmd, "list-units"):
+		return []byte(m.failedUnit), nil, nil
+	case strings.Contains(cmd, "cluster-health"):
+		*m.log = append(*m.log, "etcd "+m.id)
+		return []byte("cluster is healthy"), nil, nil
+	}
+	return nil, nil, nil
+}
+
+// runCluster runs f as a test on a cluster of machines.
+func runCluster(t *testing.T, machines []platform.Machine, f func(tc TestCluster)) {
+	dir, err := ioutil.TempDir("", "kola-reboot-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tests harness.Tests
+	tests.Add("test", func(h *harness.H) {
+		f(TestCluster{H: h, Cluster: &machinesCluster{machines: machines}})
+	})
+	suite := harness.NewSuite(harness.Options{OutputDir: filepath.Join(dir, "out")}, tests)
+	if err := suite.Run(); err != nil {
+		t.Errorf("test failed: %v", err)
+	}
+}
+
+func TestRebootAndWait(t *testing.T) {
+	var log []string
+	ok := &rebootMachine{id: "ok", log: &log}
+	stuck := &rebootMachine{id: "stuck", stuck: true, log: &log}
+	failed := &rebootMachine{id: "failed", failedUnit: "etcd-member.service", log: &log}
+
+	runCluster(t, nil, func(tc TestCluster) {
+		info, err := tc.RebootAndWait(ok)
+		if err != nil {
+			tc.Errorf("reboot failed: %v", err)
+		}
+		if info.Machine != "ok" || info.OldBootID != "ok-0" || info.NewBootID != "ok-1" {
+			tc.Errorf("reboot info %+v", info)
+		}
+		if _, err := tc.RebootAndWait(stuck); err == nil || !strings.Contains(err.Error(), "did not reboot") {
+			tc.Errorf("reboot without a new boot ID: %v", err)
+		}
+		if _, err := tc.RebootAndWait(failed); err == nil || !strings.Contains(err.Error(), "etcd-member.service") {
+			tc.Errorf("reboot into a failed unit: %v", err)
+		}
+	})
+
+	if want := []string{"reboot ok", "check ok", "reboot stuck", "reboot failed", "check failed"}; !reflect.DeepEqual(log, want) {
+		t.Errorf("ran %v, want %v", log, want)
+	}
+}
+
+func TestRebootAllSerially(t *testing.T) {
+	var log []string
+	var machines []platform.Machine
+	for _, id := range []string{"c", "a", "b"} {
+		machines = append(machines, &rebootMachine{id: id, log: &log})
+	}
+
+	runCluster(t, machines, func(tc TestCluster) {
+		infos, err := tc.RebootAllSerially()
+		if err != nil {
+			tc.Fatal(err)
+		}
+		var rebooted []string
+		for _, info := range infos {
+			rebooted = append(rebooted, info.Machine)
+		}
+		if want := []string{"a", "b", "c"}; !reflect.DeepEqual(rebooted, want) {
+			tc.Errorf("rebooted %v, want %v", rebooted, want)
+		}
+	})
+
+	var want []string
+	for _, id := range []string{"a", "b", "c"} {
+		want = append(want, "reboot "+id, "check "+id, "etcd "+id)
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("ran %v, want %v", log, want)
+	}
+	if machines[0].ID() != "c" {
+		t.Error("the cluster's machines were reordered")
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	gstorage "google.golang.org/api/storage/v1"
+
+	"github.com/coreos/mantle/storage"
+)
+
+const (
+	// scratchMaxAge is how many days GCS keeps scratch objects which
+	// kola failed to delete.
+	scratchMaxAge = 2
+
+	// scratchURLLifetime is how long signed URLs of scratch objects
+	// are valid, which covers the longest runs.
+	scratchURLLifetime = 24 * time.Hour
+
+	// ScratchRunLabel labels scratch buckets with the ID of the run
+	// they belong to.
+	ScratchRunLabel = "kola-run-id"
+)
+
+// Scratch is GCS storage shared by the tests of a run, in a bucket of
+// its own which is created on first use and labelled with the run's ID.
+// Failures of GCS are returned as *InfraError.
+type Scratch struct {
+	client  *http.Client
+	signer  storage.Signer
+	project string
+	runID   string
+
+	mu     sync.Mutex
+	bucket *storage.Bucket
+}
+
+// NewScratch returns the Scratch of the run runID, creating its bucket
+// in project through client. signer signs the URLs of its objects.
+func NewScratch(client *http.Client, signer storage.Signer, project, runID string) *Scratch {
+	return &Scratch{
+		client:  client,
+		signer:  signer,
+		project: project,
+		runID:   runID,
+	}
+}
+
+// Bucket returns the run's bucket, creating it if needed.
+func (s *Scratch) Bucket(ctx context.Context) (*storage.Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bucket != nil {
+		return s.bucket, nil
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+	name := "kola-scratch-" + hex.EncodeToString(b)
+	labels := map[string]string{ScratchRunLabel: labelValue(s.runID)}
+	bucket, err := storage.CreateBucket(ctx, s.client, s.project, name, labels, scratchMaxAge)
+	if err != nil {
+		return nil, &InfraError{fmt.Errorf("creating scratch bucket: %v", err)}
+	}
+	s.bucket = bucket
+	return bucket, nil
+}
+
+// Put uploads media as the object name and returns a URL from which
+// machines can download it without credentials.
+func (s *Scratch) Put(ctx context.Context, name string, media io.ReaderAt) (string, error) {
+	bucket, err := s.Bucket(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := bucket.Upload(ctx, &gstorage.Object{Name: name}, media); err != nil {
+		return "", &InfraError{err}
+	}
+	url, err := storage.SignedURL(s.signer, bucket.Name(), name, time.Now().Add(scratchURLLifetime))
+	if err != nil {
+		return "", &InfraError{fmt.Errorf("signing URL of %s: %v", name, err)}
+	}
+	return url, nil
+}
+
+// URL returns the gs:// URL of the object name.
+func (s *Scratch) URL(ctx context.Context, name string) (string, error) {
+	bucket, err := s.Bucket(ctx)
+	if err != nil {
+		return "", err
+	}
+	return "gs://" + bucket.Name() + "/" + name, nil
+}
+
+// Delete deletes the object name, if it exists.
+func (s *Scratch) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	bucket := s.bucket
+	s.mu.Unlock()
+	if bucket == nil {
+		return nil
+	}
+	if err := bucket.Delete(ctx, name); err != nil && !storage.IsNotFound(err) {
+		return &InfraError{err}
+	}
+	return nil
+}
+
+// Destroy deletes the run's bucket and everything in it, if it was
+// created.
+func (s *Scratch) Destroy(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bucket == nil {
+		return nil
+	}
+	if err := s.bucket.DeleteBucket(ctx); err != nil {
+		return &InfraError{err}
+	}
+	s.bucket = nil
+	return nil
+}
+
+// labelValue makes s a valid GCS label value: at most 63 lowercase
+// letters, digits, dashes and underscores.
+func labelValue(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, s)
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
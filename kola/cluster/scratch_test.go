@@ -0,0 +1,67 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/harness"
+)
+
+func TestLabelValue(t *testing.T) {
+	for in, want := range map[string]string{
+		"20181016T120000Z-builder.example.com-42": "20181016t120000z-builder-example-com-42",
+		"shard_a":               "shard_a",
+		strings.Repeat("x", 70): strings.Repeat("x", 63),
+	} {
+		if got := labelValue(in); got != want {
+			t.Errorf("labelValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestScratchWithoutCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-scratch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var reached bool
+	var tests harness.Tests
+	tests.Add("test", func(h *harness.H) {
+		tc := TestCluster{H: h}
+		tc.ScratchObject("payload", bytes.NewReader([]byte("data")))
+		reached = true
+	})
+	suite := harness.NewSuite(harness.Options{OutputDir: filepath.Join(dir, "out")}, tests)
+	if err := suite.Run(); err != nil {
+		t.Errorf("skipped test failed the suite: %v", err)
+	}
+	if reached {
+		t.Error("test continued without scratch storage")
+	}
+
+	// nothing was created, so there is nothing to destroy
+	if err := NewScratch(nil, nil, "project", "run").Destroy(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/mantle/network"
+	"github.com/coreos/mantle/platform"
+)
+
+// InterMachineSSH describes the files installed on every machine by
+// EnableInterMachineSSH.
+type InterMachineSSH struct {
+	PrivateKey string // path to the private key, mode 0600
+	PublicKey  string // path to the public key
+	KnownHosts string // path to the known_hosts file
+}
+
+// interMachineKeyName names the public key EnableInterMachineSSH adds
+// to the core user's authorized keys with update-ssh-keys.
+const interMachineKeyName = "kola-intermachine"
+
+// EnableInterMachineSSH generates a keypair local to the cluster and
+// configures the core user on every machine so that it can ssh to every
+// other machine without prompting. The private key is installed as the
+// core user's default identity and each machine's host keys are added to
+// known_hosts under both its public and private IP. Calling it again
+// replaces the keypair without duplicating any entries.
+func (t *TestCluster) EnableInterMachineSSH() (InterMachineSSH, error) {
+	paths := InterMachineSSH{
+		PrivateKey: "/home/core/.ssh/id_rsa",
+		PublicKey:  "/home/core/.ssh/id_rsa.pub",
+		KnownHosts: "/home/core/.ssh/known_hosts",
+	}
+
+	machines := t.Machines()
+	if len(machines) == 0 {
+		return paths, nil
+	}
+
+	key, err := network.GenerateRSAKey(machines[0].RuntimeConf().StrictCrypto)
+	if err != nil {
+		return paths, err
+	}
+	private := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return paths, err
+	}
+	public := ssh.MarshalAuthorizedKey(pub)
+
+	var knownHosts bytes.Buffer
+	for _, m := range machines {
+		out, stderr, err := m.SSH("cat /etc/ssh/ssh_host_*_key.pub")
+		if err != nil {
+			return paths, fmt.Errorf("reading host keys on %s: %v: %s", m.ID(), err, stderr)
+		}
+		hosts := m.PrivateIP()
+		if m.IP() != m.PrivateIP() {
+			hosts += "," + m.IP()
+		}
+		knownHosts.Write(knownHostsLines(hosts, out))
+	}
+
+	for _, m := range machines {
+		if err := installUserFile(m, private, paths.PrivateKey, "0600"); err != nil {
+			return paths, err
+		}
+		if err := installUserFile(m, public, paths.PublicKey, "0644"); err != nil {
+			return paths, err
+		}
+		if err := userFileCmd(m, public, "update-ssh-keys -a "+interMachineKeyName); err != nil {
+			return paths, err
+		}
+		if err := mergeUserFile(m, knownHosts.Bytes(), paths.KnownHosts); err != nil {
+			return paths, err
+		}
+	}
+
+	return paths, nil
+}
+
+// installUserFile writes data to path on m as the SSH user with the given mode.
+func installUserFile(m platform.Machine, data []byte, path, mode string) error {
+	return userFileCmd(m, data, fmt.Sprintf("install -D -m %s /dev/stdin %s", mode, path))
+}
+
+// mergeUserFile adds the lines of data which path on m lacks to it, as
+// the SSH user.
+func mergeUserFile(m platform.Machine, data []byte, path string) error {
+	return userFileCmd(m, data, fmt.Sprintf(`new=$(mktemp) && cat > "$new" && touch %[1]s && { grep -vxFf "$new" %[1]s; cat "$new"; } > %[1]s.new; rm "$new"; mv %[1]s.new %[1]s`, path))
+}
+
+// knownHostsLines returns the known_hosts lines for a machine reachable
+// at hosts, a comma-separated list, whose host public keys are keys, the
+// contents of its ssh_host_*_key.pub files.
+func knownHostsLines(hosts string, keys []byte) []byte {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(string(keys), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s %s %s\n", hosts, fields[0], fields[1])
+	}
+	return buf.Bytes()
+}
+
+func userFileCmd(m platform.Machine, data []byte, cmd string) error {
+	client, err := m.SSHClient()
+	if err != nil {
+		return fmt.Errorf("failed creating SSH client: %v", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed creating SSH session: %v", err)
+	}
+	defer session.Close()
+
+	session.Stdin = bytes.NewReader(data)
+	if out, err := session.CombinedOutput(cmd); err != nil {
+		return fmt.Errorf("%q failed on %s: %q: %v", cmd, m.ID(), out, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/mantle/network/mockssh"
+	"github.com/coreos/mantle/platform"
+)
+
+// shellMachine runs the commands of its SSH sessions in a local shell.
+type shellMachine struct {
+	platform.Machine
+}
+
+func (m *shellMachine) ID() string {
+	return "shell"
+}
+
+func (m *shellMachine) SSHClient() (*ssh.Client, error) {
+	return mockssh.NewMockClient(func(s *mockssh.Session) {
+		cmd := exec.Command("sh", "-c", s.Exec)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = s.Stdin, s.Stdout, s.Stderr
+		if err := cmd.Run(); err != nil {
+			s.Exit(1)
+			return
+		}
+		s.Exit(0)
+	}), nil
+}
+
+func TestKnownHostsLines(t *testing.T) {
+	keys := "ssh-ed25519 AAAAC3 root@host\n\nssh-rsa AAAAB3\n"
+	want := "10.0.0.2,203.0.113.2 ssh-ed25519 AAAAC3\n10.0.0.2,203.0.113.2 ssh-rsa AAAAB3\n"
+	if got := string(knownHostsLines("10.0.0.2,203.0.113.2", []byte(keys))); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMergeUserFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(path, []byte("github.com ssh-rsa AAAA\n10.0.0.2 ssh-rsa BBBB\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second call, e.g. by a test reusing a cluster, adds nothing
+	m := &shellMachine{}
+	for i := 0; i < 2; i++ {
+		if err := mergeUserFile(m, []byte("10.0.0.2 ssh-rsa BBBB\n10.0.0.3 ssh-rsa CCCC\n"), path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "github.com ssh-rsa AAAA\n10.0.0.2 ssh-rsa BBBB\n10.0.0.3 ssh-rsa CCCC\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// a missing file is created
+	os.Remove(path)
+	if err := mergeUserFile(m, []byte("10.0.0.3 ssh-rsa CCCC\n"), path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(path); err != nil || string(got) != "10.0.0.3 ssh-rsa CCCC\n" {
+		t.Errorf("got %q: %v", got, err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// sshAllWorkers is how many machines SSHAll runs a command on at once.
+const sshAllWorkers = 8
+
+// MachineResult is the outcome of a command run on one machine by
+// SSHAll.
+type MachineResult struct {
+	Machine platform.Machine
+	Output  []byte // stdout followed by stderr
+	Err     error  // an *SSHError if the command failed
+}
+
+// SSHAllError is the error of SSHAll when the command failed on some
+// machines.
+type SSHAllError struct {
+	Cmd    string
+	Failed []MachineResult
+	Total  int // how many machines the command ran on
+}
+
+func (e *SSHAllError) Error() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%q failed on %d of %d machines:", e.Cmd, len(e.Failed), e.Total)
+	for _, r := range e.Failed {
+		fmt.Fprintf(&b, "\n%s: %v", r.Machine.ID(), r.Err)
+		if out := bytes.TrimSpace(r.Output); len(out) > 0 {
+			fmt.Fprintf(&b, "\n%s", out)
+		}
+	}
+	return b.String()
+}
+
+// SSHAll runs cmd on every machine of the cluster in parallel, each run
+// bounded like SSH by the test's SSH timeout. It returns a result for
+// each machine in the order of Machines, and an *SSHAllError listing
+// those on which the command failed, if any.
+func (t *TestCluster) SSHAll(cmd string) ([]MachineResult, error) {
+	return sshAll(t.Context(), t.Machines(), cmd, t.Env, func() *TimeoutError {
+		return Budget(t.H, "SSH command", t.Timeouts.WithDefaults().SSH)
+	})
+}
+
+// MustSSHAll is like SSHAll, but fails the test with the output of each
+// machine the command failed on.
+func (t *TestCluster) MustSSHAll(cmd string) []MachineResult {
+	results, err := t.SSHAll(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+// sshAll runs cmd with env on machines, at most sshAllWorkers at a time,
+// each within the budget returned by budget when its command starts.
+func sshAll(ctx context.Context, machines []platform.Machine, cmd string, env map[string]string, budget func() *TimeoutError) ([]MachineResult, error) {
+	results := make([]MachineResult, len(machines))
+	slots := make(chan struct{}, sshAllWorkers)
+	var wg sync.WaitGroup
+	for i, m := range machines {
+		wg.Add(1)
+		go func(i int, m platform.Machine) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			stdout, stderr, err := sshCommand(ctx, m, cmd, env, budget())
+			results[i] = MachineResult{
+				Machine: m,
+				Output:  append(append([]byte(nil), stdout...), stderr...),
+				Err:     err,
+			}
+		}(i, m)
+	}
+	wg.Wait()
+
+	var failed []MachineResult
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) > 0 {
+		return results, &SSHAllError{Cmd: cmd, Failed: failed, Total: len(machines)}
+	}
+	return results, nil
+}
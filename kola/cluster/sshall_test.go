@@ -0,0 +1,112 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// countingMachine is an sshMachine with an ID which counts the commands
+// running on machines sharing running.
+type countingMachine struct {
+	*sshMachine
+	id      string
+	running *int32
+	most    *int32
+}
+
+func (m *countingMachine) ID() string {
+	return m.id
+}
+
+func (m *countingMachine) SSH(cmd string) ([]byte, []byte, error) {
+	n := atomic.AddInt32(m.running, 1)
+	defer atomic.AddInt32(m.running, -1)
+	for {
+		most := atomic.LoadInt32(m.most)
+		if n <= most || atomic.CompareAndSwapInt32(m.most, most, n) {
+			break
+		}
+	}
+	return m.sshMachine.SSH(cmd)
+}
+
+func TestSSHAll(t *testing.T) {
+	var running, most int32
+	var machines []platform.Machine
+	for i := 0; i < 2*sshAllWorkers; i++ {
+		m := &sshMachine{delay: 10 * time.Millisecond, stdout: "active\n"}
+		if i == 3 {
+			m.stdout, m.stderr, m.err = "inactive\n", "etcd is dead\n", errors.New("exit 3")
+		}
+		machines = append(machines, &countingMachine{
+			sshMachine: m,
+			id:         fmt.Sprintf("m%d", i),
+			running:    &running,
+			most:       &most,
+		})
+	}
+	machines[5].(*countingMachine).delay = time.Second
+
+	budget := func() *TimeoutError {
+		return &TimeoutError{Op: "SSH command", Limit: 100 * time.Millisecond}
+	}
+	results, err := sshAll(context.Background(), machines, "systemctl is-active etcd", nil, budget)
+	if n := atomic.LoadInt32(&most); n > sshAllWorkers {
+		t.Errorf("ran %d commands at once", n)
+	}
+	if len(results) != len(machines) {
+		t.Fatalf("got %d results for %d machines", len(results), len(machines))
+	}
+	for i, r := range results {
+		if r.Machine != machines[i] {
+			t.Errorf("result %d is of %s", i, r.Machine.ID())
+		}
+		switch i {
+		case 3:
+			if r.Err == nil || string(r.Output) != "inactive\netcd is dead\n" {
+				t.Errorf("failed command returned %q, %v", r.Output, r.Err)
+			}
+		case 5:
+			if _, ok := r.Err.(*SSHError); !ok || !strings.Contains(r.Err.Error(), "timed out") {
+				t.Errorf("hung command returned %v", r.Err)
+			}
+		default:
+			if r.Err != nil || string(r.Output) != "active\n" {
+				t.Errorf("command on %s returned %q, %v", r.Machine.ID(), r.Output, r.Err)
+			}
+		}
+	}
+
+	e, ok := err.(*SSHAllError)
+	if !ok || len(e.Failed) != 2 || e.Total != len(machines) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if msg := e.Error(); !strings.HasPrefix(msg, `"systemctl is-active etcd" failed on 2 of 16 machines:`) || !strings.Contains(msg, "\nm3: ") || !strings.Contains(msg, "etcd is dead") {
+		t.Errorf("error doesn't describe the failures:\n%s", msg)
+	}
+
+	if _, err := sshAll(context.Background(), machines[:2], "true", nil, budget); err != nil {
+		t.Error(err)
+	}
+}
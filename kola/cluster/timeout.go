@@ -0,0 +1,153 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/coreos/mantle/harness"
+)
+
+// Timeouts bounds a test and the operations it runs. The test's timeout
+// bounds everything beneath it: each operation may take its own timeout
+// or what is left of the test's, whichever is less.
+type Timeouts struct {
+	Test   time.Duration // setting up the test's clusters and running it
+	SSH    time.Duration // each command run through SSH, SSHOutput or MustSSH
+	Native time.Duration // each native function run through RunNative
+	Ready  time.Duration // each BootStage's ReadyCheck without a ReadyTimeout
+}
+
+// DefaultTimeouts are the timeouts of tests which set none of their own.
+var DefaultTimeouts = Timeouts{
+	Test:   10 * time.Minute,
+	SSH:    5 * time.Minute,
+	Native: 10 * time.Minute,
+	Ready:  5 * time.Minute,
+}
+
+// WithDefaults returns t with its unset timeouts taken from
+// DefaultTimeouts.
+func (t Timeouts) WithDefaults() Timeouts {
+	if t.Test == 0 {
+		t.Test = DefaultTimeouts.Test
+	}
+	if t.SSH == 0 {
+		t.SSH = DefaultTimeouts.SSH
+	}
+	if t.Native == 0 {
+		t.Native = DefaultTimeouts.Native
+	}
+	if t.Ready == 0 {
+		t.Ready = DefaultTimeouts.Ready
+	}
+	return t
+}
+
+// TimeoutError is the error of an operation which ran out of time. It
+// tells whether the operation's own timeout or the test's expired.
+type TimeoutError struct {
+	Op    string        // the operation, e.g. "SSH command"
+	Limit time.Duration // the operation's own timeout
+
+	// HasDeadline is set if the test has a deadline, from its own
+	// timeout TestLimit or, if that is 0, from the run. TestLeft is
+	// what was left of it when the operation started.
+	HasDeadline bool
+	TestLimit   time.Duration
+	TestLeft    time.Duration
+}
+
+// budget returns the TimeoutError for an operation op limited to limit,
+// started at now in a test whose timeout testLimit expires at deadline.
+// hasDeadline is false if the test has no deadline.
+func budget(op string, limit, testLimit time.Duration, deadline time.Time, hasDeadline bool, now time.Time) *TimeoutError {
+	e := &TimeoutError{Op: op, Limit: limit, HasDeadline: hasDeadline}
+	if hasDeadline {
+		e.TestLimit = testLimit
+		e.TestLeft = deadline.Sub(now)
+		if e.TestLeft < 0 {
+			e.TestLeft = 0
+		}
+	}
+	return e
+}
+
+// Budget returns the TimeoutError for an operation op limited to limit,
+// started now in the test h. Its Timeout is how long the operation may
+// take.
+func Budget(h *harness.H, op string, limit time.Duration) *TimeoutError {
+	testLimit, deadline, ok := h.Limit()
+	return budget(op, limit, testLimit, deadline, ok, time.Now())
+}
+
+// Timeout returns how long the operation may take: its own timeout or
+// what is left of the test's, whichever is less.
+func (e *TimeoutError) Timeout() time.Duration {
+	if e.TestExpired() {
+		return e.TestLeft
+	}
+	return e.Limit
+}
+
+// TestExpired reports whether the test's timeout expired rather than
+// the operation's.
+func (e *TimeoutError) TestExpired() bool {
+	return e.HasDeadline && e.TestLeft < e.Limit
+}
+
+func (e *TimeoutError) Error() string {
+	test := "the run's deadline"
+	if e.TestLimit > 0 {
+		test = fmt.Sprintf("the test timeout of %v", e.TestLimit)
+	}
+	switch {
+	case e.TestExpired():
+		return fmt.Sprintf("timed out: %s expired %v into the %s, before its own timeout of %v", test, e.TestLeft, e.Op, e.Limit)
+	case e.HasDeadline:
+		return fmt.Sprintf("timed out: %s timeout of %v expired, with %v left of %s", e.Op, e.Limit, e.TestLeft-e.Limit, test)
+	default:
+		return fmt.Sprintf("timed out: %s timeout of %v expired", e.Op, e.Limit)
+	}
+}
+
+// runLimited runs f in a new goroutine and waits for it until b's
+// timeout passes or ctx is done, returning b if it runs out of time. f
+// is abandoned, not stopped, so it must not touch anything the caller
+// uses afterwards.
+func runLimited(ctx context.Context, b *TimeoutError, f func()) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	timer := time.NewTimer(b.Timeout())
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return b
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			// the test's deadline, which the timer races
+			return b
+		}
+		return ctx.Err()
+	}
+}
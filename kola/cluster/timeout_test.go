@@ -0,0 +1,109 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		desc        string
+		limit       time.Duration
+		testLimit   time.Duration
+		left        time.Duration
+		hasDeadline bool
+		timeout     time.Duration
+		testExpired bool
+		err         string
+	}{
+		{
+			desc:    "no test deadline",
+			limit:   5 * time.Minute,
+			timeout: 5 * time.Minute,
+			err:     "timed out: SSH command timeout of 5m0s expired",
+		},
+		{
+			desc:        "plenty of test budget left",
+			limit:       5 * time.Minute,
+			testLimit:   10 * time.Minute,
+			left:        8 * time.Minute,
+			hasDeadline: true,
+			timeout:     5 * time.Minute,
+			err:         "timed out: SSH command timeout of 5m0s expired, with 3m0s left of the test timeout of 10m0s",
+		},
+		{
+			desc:        "capped at the rest of the test",
+			limit:       5 * time.Minute,
+			testLimit:   10 * time.Minute,
+			left:        2 * time.Minute,
+			hasDeadline: true,
+			timeout:     2 * time.Minute,
+			testExpired: true,
+			err:         "timed out: the test timeout of 10m0s expired 2m0s into the SSH command, before its own timeout of 5m0s",
+		},
+		{
+			desc:        "longer than the whole test",
+			limit:       time.Hour,
+			testLimit:   10 * time.Minute,
+			left:        10 * time.Minute,
+			hasDeadline: true,
+			timeout:     10 * time.Minute,
+			testExpired: true,
+			err:         "timed out: the test timeout of 10m0s expired 10m0s into the SSH command, before its own timeout of 1h0m0s",
+		},
+		{
+			desc:        "deadline from the run",
+			limit:       5 * time.Minute,
+			left:        time.Minute,
+			hasDeadline: true,
+			timeout:     time.Minute,
+			testExpired: true,
+			err:         "timed out: the run's deadline expired 1m0s into the SSH command, before its own timeout of 5m0s",
+		},
+		{
+			desc:        "test already out of time",
+			limit:       5 * time.Minute,
+			testLimit:   10 * time.Minute,
+			left:        -time.Second,
+			hasDeadline: true,
+			timeout:     0,
+			testExpired: true,
+			err:         "timed out: the test timeout of 10m0s expired 0s into the SSH command, before its own timeout of 5m0s",
+		},
+	} {
+		b := budget("SSH command", tt.limit, tt.testLimit, now.Add(tt.left), tt.hasDeadline, now)
+		if d := b.Timeout(); d != tt.timeout {
+			t.Errorf("%s: timeout %v, want %v", tt.desc, d, tt.timeout)
+		}
+		if b.TestExpired() != tt.testExpired {
+			t.Errorf("%s: test expired %v, want %v", tt.desc, b.TestExpired(), tt.testExpired)
+		}
+		if b.Error() != tt.err {
+			t.Errorf("%s: error %q, want %q", tt.desc, b.Error(), tt.err)
+		}
+	}
+}
+
+func TestTimeoutsWithDefaults(t *testing.T) {
+	got := Timeouts{SSH: time.Minute}.WithDefaults()
+	want := DefaultTimeouts
+	want.SSH = time.Minute
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+// Report is the part of a run's report.json which DiffReports compares
+// and MergeReports combines.
+type Report struct {
+	Platform string                `json:"platform"` // comma-separated for multi-platform runs
+	Version  string                `json:"version"`  // comma-separated if platforms differ
+	Result   testresult.TestResult `json:"result,omitempty"`
+	Tests    []reportTest          `json:"tests"`
+}
+
+type reportTest struct {
+	Name     string                `json:"name"`
+	Result   testresult.TestResult `json:"result"`
+	Duration time.Duration         `json:"duration"`
+
+	// kept by MergeReports
+	Output      string                 `json:"output,omitempty"`
+	Annotations map[string]interface{} `json:"annotations,omitempty"`
+}
+
+// ReadReport reads a report.json written by RunTests, or the
+// report.jsonl streamed while it ran, which survives if the run did not
+// finish.
+func ReadReport(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(path, ".jsonl") {
+		return readReportLines(path, f)
+	}
+
+	var r Report
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return &r, nil
+}
+
+// readReportLines reads a report streamed as one JSON object per test,
+// each carrying the run's platform and version. A final line cut short
+// by the run dying is ignored.
+func readReportLines(path string, f io.Reader) (*Report, error) {
+	var r Report
+	dec := json.NewDecoder(f)
+	for {
+		var line struct {
+			reportTest
+			Platform string `json:"platform"`
+			Version  string `json:"version"`
+		}
+		switch err := dec.Decode(&line); err {
+		case nil:
+			r.Platform, r.Version = line.Platform, line.Version
+			r.Tests = append(r.Tests, line.reportTest)
+		case io.EOF, io.ErrUnexpectedEOF:
+			return &r, nil
+		default:
+			return nil, fmt.Errorf("parsing %s: %v", path, err)
+		}
+	}
+}
+
+// TestChange is a test whose result or duration differs between runs.
+type TestChange struct {
+	Name        string                `json:"name"`
+	Platform    string                `json:"platform"`
+	OldResult   testresult.TestResult `json:"old_result,omitempty"`
+	NewResult   testresult.TestResult `json:"new_result,omitempty"`
+	OldDuration time.Duration         `json:"old_duration,omitempty"`
+	NewDuration time.Duration         `json:"new_duration,omitempty"`
+}
+
+// ReportDiff lists the differences between two runs. Only platforms
+// tested by both runs are compared.
+type ReportDiff struct {
+	NewlyFailed     []TestChange `json:"newly_failed"`
+	Fixed           []TestChange `json:"fixed"`
+	NewlySkipped    []TestChange `json:"newly_skipped"`
+	DurationChanged []TestChange `json:"duration_changed"`
+	Added           []TestChange `json:"added"`
+	Removed         []TestChange `json:"removed"`
+
+	// Caveats explain why the runs may not be comparable, e.g.
+	// different image versions.
+	Caveats []string `json:"caveats,omitempty"`
+}
+
+type testKey struct {
+	name, platform string
+}
+
+type testRun struct {
+	result   testresult.TestResult
+	duration time.Duration
+}
+
+// byPlatform indexes the tests of r by name and platform.
+func (r *Report) byPlatform() map[testKey]testRun {
+	tests := make(map[testKey]testRun)
+	r.eachTest(func(k testKey, t reportTest) {
+		tests[k] = testRun{t.Result, t.Duration}
+	})
+	return tests
+}
+
+// eachTest calls f with each test of r in order and its name and
+// platform. Multi-platform runs name tests test/platform/subtest; their
+// top-level entries only aggregate the platforms and are skipped.
+func (r *Report) eachTest(f func(testKey, reportTest)) {
+	platforms := strings.Split(r.Platform, ",")
+	for _, t := range r.Tests {
+		k := testKey{name: t.Name, platform: r.Platform}
+		if len(platforms) > 1 {
+			parts := strings.SplitN(t.Name, "/", 3)
+			if len(parts) < 2 || !hasString(platforms, parts[1]) {
+				continue
+			}
+			k.platform = parts[1]
+			k.name = parts[0]
+			if len(parts) == 3 {
+				k.name += "/" + parts[2]
+			}
+		}
+		f(k, t)
+	}
+}
+
+// DiffReports compares old and new. A test's duration is reported as
+// changed if it differs by more than threshold, a fraction of the old
+// duration, and by more than minChange, to ignore noise in short tests.
+func DiffReports(old, new *Report, threshold float64, minChange time.Duration) *ReportDiff {
+	d := &ReportDiff{}
+
+	oldPlatforms := strings.Split(old.Platform, ",")
+	newPlatforms := strings.Split(new.Platform, ",")
+	for _, p := range oldPlatforms {
+		if !hasString(newPlatforms, p) {
+			d.Caveats = append(d.Caveats, fmt.Sprintf("platform %s only in old run; not compared", p))
+		}
+	}
+	for _, p := range newPlatforms {
+		if !hasString(oldPlatforms, p) {
+			d.Caveats = append(d.Caveats, fmt.Sprintf("platform %s only in new run; not compared", p))
+		}
+	}
+	if old.Version != new.Version {
+		d.Caveats = append(d.Caveats, fmt.Sprintf("image versions differ: old %q, new %q", old.Version, new.Version))
+	}
+
+	oldTests := old.byPlatform()
+	newTests := new.byPlatform()
+	for k, n := range newTests {
+		if !hasString(oldPlatforms, k.platform) {
+			continue
+		}
+		o, ok := oldTests[k]
+		c := TestChange{
+			Name:        k.name,
+			Platform:    k.platform,
+			OldResult:   o.result,
+			NewResult:   n.result,
+			OldDuration: o.duration,
+			NewDuration: n.duration,
+		}
+		switch {
+		case !ok:
+			d.Added = append(d.Added, c)
+		case c.NewResult == testresult.Fail && c.OldResult != testresult.Fail:
+			d.NewlyFailed = append(d.NewlyFailed, c)
+		case c.NewResult == testresult.Pass && c.OldResult == testresult.Fail:
+			d.Fixed = append(d.Fixed, c)
+		case c.NewResult == testresult.Skip && c.OldResult != testresult.Skip:
+			d.NewlySkipped = append(d.NewlySkipped, c)
+		case c.OldResult == testresult.Pass && c.NewResult == testresult.Pass:
+			delta := c.NewDuration - c.OldDuration
+			if delta < 0 {
+				delta = -delta
+			}
+			if delta > minChange && float64(delta) > threshold*float64(c.OldDuration) {
+				d.DurationChanged = append(d.DurationChanged, c)
+			}
+		}
+	}
+	for k, o := range oldTests {
+		if _, ok := newTests[k]; !ok && hasString(newPlatforms, k.platform) {
+			d.Removed = append(d.Removed, TestChange{
+				Name:        k.name,
+				Platform:    k.platform,
+				OldResult:   o.result,
+				OldDuration: o.duration,
+			})
+		}
+	}
+
+	for _, l := range [][]TestChange{d.NewlyFailed, d.Fixed, d.NewlySkipped, d.DurationChanged, d.Added, d.Removed} {
+		sortChanges(l)
+	}
+	return d
+}
+
+func sortChanges(l []TestChange) {
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Name != l[j].Name {
+			return l[i].Name < l[j].Name
+		}
+		return l[i].Platform < l[j].Platform
+	})
+}
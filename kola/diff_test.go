@@ -0,0 +1,152 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseReport(t *testing.T, s string) *Report {
+	var r Report
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		t.Fatal(err)
+	}
+	return &r
+}
+
+func names(l []TestChange) []string {
+	r := []string{}
+	for _, c := range l {
+		r = append(r, c.Name+"@"+c.Platform)
+	}
+	return r
+}
+
+func TestDiffReports(t *testing.T) {
+	// durations are in nanoseconds
+	old := parseReport(t, `{"platform": "qemu", "version": "1800.0.0", "tests": [
+		{"name": "a", "result": "PASS", "duration": 10000000000},
+		{"name": "b", "result": "FAIL"},
+		{"name": "c", "result": "PASS"},
+		{"name": "d", "result": "PASS"},
+		{"name": "e", "result": "PASS", "duration": 100000000000},
+		{"name": "gone", "result": "PASS"}
+	]}`)
+	new := parseReport(t, `{"platform": "qemu,aws", "version": "1801.0.0", "tests": [
+		{"name": "a", "result": "PASS"},
+		{"name": "a/qemu", "result": "PASS", "duration": 11000000000},
+		{"name": "a/aws", "result": "FAIL"},
+		{"name": "b/qemu", "result": "PASS"},
+		{"name": "c/qemu", "result": "FAIL"},
+		{"name": "d/qemu", "result": "SKIP"},
+		{"name": "e/qemu", "result": "PASS", "duration": 200000000000},
+		{"name": "new/qemu", "result": "PASS"},
+		{"name": "new/qemu/sub", "result": "PASS"}
+	]}`)
+
+	d := DiffReports(old, new, 0.5, 5*time.Second)
+	for _, tc := range []struct {
+		desc   string
+		got    []TestChange
+		expect []string
+	}{
+		{"newly failed", d.NewlyFailed, []string{"c@qemu"}},
+		{"fixed", d.Fixed, []string{"b@qemu"}},
+		{"newly skipped", d.NewlySkipped, []string{"d@qemu"}},
+		{"duration changed", d.DurationChanged, []string{"e@qemu"}},
+		{"added", d.Added, []string{"new@qemu", "new/sub@qemu"}},
+		{"removed", d.Removed, []string{"gone@qemu"}},
+	} {
+		if got := names(tc.got); !reflect.DeepEqual(got, tc.expect) {
+			t.Errorf("%s: expected %v, got %v", tc.desc, tc.expect, got)
+		}
+	}
+	expectCaveats := []string{
+		"platform aws only in new run; not compared",
+		`image versions differ: old "1800.0.0", new "1801.0.0"`,
+	}
+	if !reflect.DeepEqual(d.Caveats, expectCaveats) {
+		t.Errorf("expected caveats %q, got %q", expectCaveats, d.Caveats)
+	}
+}
+
+func TestReadReportLines(t *testing.T) {
+	r, err := readReportLines("report.jsonl", strings.NewReader(
+		`{"name": "a", "result": "PASS", "duration": 1, "platform": "qemu", "version": "1800.0.0"}
+{"name": "b", "result": "FAIL", "duration": 2, "platform": "qemu", "version": "1800.0.0"}
+{"name": "c", "result": "PA`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &Report{
+		Platform: "qemu",
+		Version:  "1800.0.0",
+		Tests: []reportTest{
+			{Name: "a", Result: "PASS", Duration: 1},
+			{Name: "b", Result: "FAIL", Duration: 2},
+		},
+	}
+	if !reflect.DeepEqual(r, expect) {
+		t.Errorf("expected %+v, got %+v", expect, r)
+	}
+
+	if _, err := readReportLines("report.jsonl", strings.NewReader("{\"name\": \"a\"}\nnot json\n")); err == nil {
+		t.Error("expected an error for a corrupt line")
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	qemu := parseReport(t, `{"platform": "qemu", "version": "1800.0.0", "tests": [
+		{"name": "a", "result": "PASS", "output": "ok"},
+		{"name": "b", "result": "FAIL", "annotations": {"failure_category": "test"}},
+		{"name": "b/sub", "result": "FAIL"}
+	]}`)
+	rerun := parseReport(t, `{"platform": "qemu", "version": "1800.0.0", "tests": [
+		{"name": "b", "result": "PASS"},
+		{"name": "b/sub", "result": "PASS"}
+	]}`)
+	aws := parseReport(t, `{"platform": "aws,gce", "version": "1800.0.0,1801.0.0", "tests": [
+		{"name": "a", "result": "FAIL"},
+		{"name": "a/aws", "result": "PASS"},
+		{"name": "a/gce", "result": "FAIL"}
+	]}`)
+
+	for _, tt := range []struct {
+		desc    string
+		reports []*Report
+		expect  string
+	}{
+		{"rerun", []*Report{qemu, rerun}, `{"platform": "qemu", "version": "1800.0.0", "result": "PASS", "tests": [
+			{"name": "a", "result": "PASS", "output": "ok"},
+			{"name": "b", "result": "PASS"},
+			{"name": "b/sub", "result": "PASS"}
+		]}`},
+		{"platforms", []*Report{qemu, aws}, `{"platform": "qemu,aws,gce", "version": "1800.0.0,1801.0.0", "result": "FAIL", "tests": [
+			{"name": "a/qemu", "result": "PASS", "output": "ok"},
+			{"name": "b/qemu", "result": "FAIL", "annotations": {"failure_category": "test"}},
+			{"name": "b/qemu/sub", "result": "FAIL"},
+			{"name": "a/aws", "result": "PASS"},
+			{"name": "a/gce", "result": "FAIL"}
+		]}`},
+	} {
+		if got, expect := MergeReports(tt.reports...), parseReport(t, tt.expect); !reflect.DeepEqual(got, expect) {
+			t.Errorf("%s: expected %+v, got %+v", tt.desc, expect, got)
+		}
+	}
+}
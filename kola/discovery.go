@@ -0,0 +1,125 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/platform"
+)
+
+// discoveryStateFile is written to a cluster's output directory if a test
+// which used an etcd discovery URL failed.
+const discoveryStateFile = "discovery.json"
+
+// discoveryDocument is the part of the etcd v2 keys response served for
+// a discovery URL that lists the registered members. The public discovery
+// service and the local cluster's etcd serve the same format.
+type discoveryDocument struct {
+	Node struct {
+		Nodes []struct {
+			Key           string `json:"key"`
+			Value         string `json:"value"`
+			CreatedIndex  uint64 `json:"createdIndex"`
+			ModifiedIndex uint64 `json:"modifiedIndex"`
+		} `json:"nodes"`
+	} `json:"node"`
+}
+
+// discoveryMembers returns the names of the members registered in the
+// discovery document doc, in the order they registered.
+func discoveryMembers(doc []byte) ([]string, error) {
+	var d discoveryDocument
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, fmt.Errorf("parsing discovery document: %v", err)
+	}
+	nodes := d.Node.Nodes
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].CreatedIndex < nodes[j].CreatedIndex
+	})
+	var members []string
+	for _, n := range nodes {
+		// the configuration is hidden by etcd, but be sure
+		if path.Base(n.Key) == "_config" {
+			continue
+		}
+		// members register "<name>=<peer URLs>" under their ID
+		name := path.Base(n.Key)
+		if i := strings.Index(n.Value, "="); i > 0 {
+			name = n.Value[:i]
+		}
+		members = append(members, name)
+	}
+	return members, nil
+}
+
+// discoverySummary returns a one-line digest of the members registered
+// in doc out of the size expected.
+func discoverySummary(doc []byte, size int) (string, error) {
+	members, err := discoveryMembers(doc)
+	if err != nil {
+		return "", err
+	}
+	summary := fmt.Sprintf("%d of %d members registered", len(members), size)
+	if len(members) > 0 {
+		summary += ": " + strings.Join(members, ", ")
+	}
+	return summary, nil
+}
+
+// captureDiscoveryOnFailure saves the state of discovery URL url of c to
+// dir if the test fails, and logs which of the size members registered.
+// It must be called after the cleanup destroying c is registered, so that
+// the discovery service, which may be part of c, is still there.
+func captureDiscoveryOnFailure(h *harness.H, c platform.Cluster, url string, size int, dir string) {
+	h.Cleanup(func() {
+		if !h.Failed() {
+			return
+		}
+		doc, err := c.GetDiscoveryState(url)
+		if err != nil {
+			h.Logf("Fetching discovery state of %s: %v", url, err)
+			return
+		}
+
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, doc, "", "  "); err != nil {
+			// save whatever the service returned
+			pretty.Reset()
+			pretty.Write(doc)
+		}
+		pretty.WriteString("\n")
+		statePath := filepath.Join(dir, discoveryStateFile)
+		if err := ioutil.WriteFile(statePath, pretty.Bytes(), 0666); err != nil {
+			h.Logf("Saving discovery state: %v", err)
+		}
+
+		summary, err := discoverySummary(doc, size)
+		if err != nil {
+			h.Logf("Discovery %s: %v; see %s", url, err, statePath)
+			return
+		}
+		h.Logf("Discovery %s: %s", url, summary)
+		h.Annotate("discovery", summary)
+	})
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"testing"
+)
+
+func TestDiscoverySummary(t *testing.T) {
+	for _, tt := range []struct {
+		doc  string
+		want string
+	}{
+		{
+			doc:  `{"action":"get","node":{"key":"/_etcd/registry/abc","dir":true}}`,
+			want: "0 of 3 members registered",
+		},
+		{
+			// listed out of registration order
+			doc: `{"action":"get","node":{"key":"/_etcd/registry/abc","dir":true,"nodes":[
+				{"key":"/_etcd/registry/abc/2b","value":"m2=http://10.0.0.3:2380","createdIndex":9},
+				{"key":"/_etcd/registry/abc/1a","value":"m1=http://10.0.0.2:2380","createdIndex":7}]}}`,
+			want: "2 of 3 members registered: m1, m2",
+		},
+		{
+			// local discovery URLs, and values without a name
+			doc: `{"action":"get","node":{"key":"/discovery/42","dir":true,"nodes":[
+				{"key":"/discovery/42/_config","dir":true,"createdIndex":3},
+				{"key":"/discovery/42/ffe1","value":"http://10.0.0.2:2380","createdIndex":5}]}}`,
+			want: "1 of 3 members registered: ffe1",
+		},
+	} {
+		got, err := discoverySummary([]byte(tt.doc), 3)
+		if err != nil {
+			t.Errorf("%s: %v", tt.doc, err)
+		} else if got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+
+	if _, err := discoverySummary([]byte("<html>"), 3); err == nil {
+		t.Errorf("parsing a non-JSON document succeeded")
+	}
+}
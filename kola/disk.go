@@ -0,0 +1,270 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/system"
+)
+
+// diskCheckInterval is how often free space is checked during a run, on
+// top of the checks as each test starts and finishes.
+const diskCheckInterval = 10 * time.Second
+
+// prunedNote replaces the artifacts of a passed test pruned to free space.
+const prunedNote = "PRUNED"
+
+// runDisk watches the free space of the current run, or is nil if
+// DiskLowWater is 0.
+var runDisk *diskMonitor
+
+// diskMonitor watches the free space on the filesystems a run writes
+// its disk images, captures and artifacts to. Below the low-water mark
+// the host is under disk pressure: tests which have yet to start are
+// skipped, failures are annotated as possibly caused by it, and the
+// artifacts of passed tests are pruned, largest first, to recover.
+// Failed tests' artifacts are never pruned.
+type diskMonitor struct {
+	paths     []string
+	lowWater  uint64
+	diskSpace func(path string) (free, size uint64, err error)
+	stop      chan struct{}
+	done      chan struct{}
+
+	mu       sync.Mutex
+	pressure bool   // below lowWater at the last check
+	hit      bool   // below lowWater at any check
+	minFree  uint64 // lowest free space seen, on minPath
+	minPath  string
+	checked  bool
+	passed   []string // artifact directories of passed tests
+	pruned   int
+	freed    uint64
+	skipped  int
+}
+
+// newDiskMonitor returns a monitor of the filesystems containing paths,
+// under pressure when one of them has less than lowWater bytes free.
+func newDiskMonitor(paths []string, lowWater uint64) *diskMonitor {
+	d := &diskMonitor{
+		lowWater:  lowWater,
+		diskSpace: system.DiskSpace,
+	}
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		d.paths = append(d.paths, p)
+	}
+	return d
+}
+
+// Start checks free space every interval until Stop.
+func (d *diskMonitor) Start(interval time.Duration) {
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.Check()
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the checks started by Start.
+func (d *diskMonitor) Stop() {
+	if d == nil || d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+}
+
+// Check measures free space, pruning passed tests' artifacts if it is
+// below the low-water mark, and reports whether it still is.
+func (d *diskMonitor) Check() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.measure() {
+		d.prune()
+	}
+	return d.pressure
+}
+
+// measure updates the pressure state from the free space of each path
+// and returns it. Paths which can't be measured, e.g. because they don't
+// exist yet, are left out.
+func (d *diskMonitor) measure() bool {
+	d.pressure = false
+	for _, p := range d.paths {
+		free, _, err := d.diskSpace(p)
+		if err != nil {
+			plog.Debugf("Measuring free space of %s: %v", p, err)
+			continue
+		}
+		if !d.checked || free < d.minFree {
+			d.checked = true
+			d.minFree = free
+			d.minPath = p
+		}
+		if free < d.lowWater {
+			if !d.hit {
+				plog.Errorf("Host disk nearly full: %s free on %s, below the low-water mark of %s; pruning passed tests' artifacts and not starting tests meanwhile",
+					formatBytes(free), p, formatBytes(d.lowWater))
+			}
+			d.pressure = true
+			d.hit = true
+		}
+	}
+	return d.pressure
+}
+
+// prune removes the artifacts of passed tests, largest first, until the
+// pressure is relieved or there are none left. Each pruned directory is
+// left with a note saying why it is empty.
+func (d *diskMonitor) prune() {
+	if len(d.passed) == 0 {
+		return
+	}
+	sizes := make(map[string]uint64, len(d.passed))
+	for _, dir := range d.passed {
+		sizes[dir] = dirSize(dir)
+	}
+	sort.SliceStable(d.passed, func(i, j int) bool {
+		return sizes[d.passed[i]] > sizes[d.passed[j]]
+	})
+	for d.pressure && len(d.passed) > 0 {
+		dir := d.passed[0]
+		d.passed = d.passed[1:]
+		if err := os.RemoveAll(dir); err != nil {
+			plog.Errorf("Pruning artifacts of passed test: %v", err)
+			continue
+		}
+		if err := os.Mkdir(dir, 0777); err == nil {
+			note := "The artifacts of this passed test were pruned to free space on the host.\n"
+			ioutil.WriteFile(filepath.Join(dir, prunedNote), []byte(note), 0666)
+		}
+		plog.Warningf("Pruned %s of artifacts of a passed test to free space: %s", formatBytes(sizes[dir]), dir)
+		d.pruned++
+		d.freed += sizes[dir]
+		d.measure()
+	}
+}
+
+// dirSize returns the bytes used by the regular files under dir.
+func dirSize(dir string) uint64 {
+	var size uint64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size
+}
+
+// Passed records the artifact directory of a passed test, which may be
+// pruned under pressure.
+func (d *diskMonitor) Passed(artifactDir string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.passed = append(d.passed, artifactDir)
+}
+
+// Skipped counts a test skipped because of the pressure.
+func (d *diskMonitor) Skipped() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.skipped++
+}
+
+// Warning returns a warning for failures if the host has been under
+// pressure during the run, or "".
+func (d *diskMonitor) Warning() string {
+	if d == nil {
+		return ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.hit {
+		return ""
+	}
+	return fmt.Sprintf("host disk nearly full: %s free on %s at the lowest; I/O errors and truncated logs may be caused by it",
+		formatBytes(d.minFree), d.minPath)
+}
+
+// Summary states whether the run was under pressure, and if so what it
+// skipped and pruned, or returns "" without a monitor.
+func (d *diskMonitor) Summary() string {
+	if d == nil {
+		return ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.checked {
+		return ""
+	}
+	var buf bytes.Buffer
+	if !d.hit {
+		fmt.Fprintf(&buf, "No disk pressure: at least %s free on %s, above the low-water mark of %s\n",
+			formatBytes(d.minFree), d.minPath, formatBytes(d.lowWater))
+		return buf.String()
+	}
+	fmt.Fprintf(&buf, "Host disk nearly full: %s free on %s at the lowest, below the low-water mark of %s\n",
+		formatBytes(d.minFree), d.minPath, formatBytes(d.lowWater))
+	fmt.Fprintf(&buf, "\t%d tests skipped, %d passed tests' artifacts pruned freeing %s\n",
+		d.skipped, d.pruned, formatBytes(d.freed))
+	return buf.String()
+}
+
+// formatBytes formats n bytes in the largest binary unit below it.
+func formatBytes(n uint64) string {
+	const units = "KMGTPE"
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(1<<10), 0
+	for m := n >> 10; m >= 1<<10 && exp < len(units)-1; m >>= 10 {
+		div <<= 10
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), units[exp])
+}
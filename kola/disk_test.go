@@ -0,0 +1,138 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeDisk is a filesystem whose free space is set by tests, and grows
+// by the size of every artifact directory pruned from it.
+type fakeDisk struct {
+	free uint64
+	dirs map[string]uint64 // artifact directory sizes
+}
+
+func (f *fakeDisk) diskSpace(path string) (uint64, uint64, error) {
+	free := f.free
+	for dir, size := range f.dirs {
+		if _, err := os.Stat(filepath.Join(dir, "artifact")); os.IsNotExist(err) {
+			free += size
+		}
+	}
+	return free, 100 << 30, nil
+}
+
+func TestDiskMonitor(t *testing.T) {
+	root, err := ioutil.TempDir("", "kola-disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	disk := &fakeDisk{free: 5 << 30, dirs: make(map[string]uint64)}
+	artifacts := func(name string, size uint64) string {
+		dir := filepath.Join(root, name)
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		// sized in KiB rather than GiB on the real disk
+		if err := ioutil.WriteFile(filepath.Join(dir, "artifact"), make([]byte, size>>20), 0666); err != nil {
+			t.Fatal(err)
+		}
+		disk.dirs[dir] = size
+		return dir
+	}
+
+	d := newDiskMonitor([]string{root, root, ""}, 2<<30)
+	d.diskSpace = disk.diskSpace
+	if len(d.paths) != 1 {
+		t.Errorf("monitoring %v", d.paths)
+	}
+	if d.Check() || d.Warning() != "" {
+		t.Fatal("pressure with 5 GiB free")
+	}
+	if s := d.Summary(); !strings.HasPrefix(s, "No disk pressure: at least 5.0 GiB free") {
+		t.Errorf("summary without pressure: %q", s)
+	}
+
+	small := artifacts("small", 1<<30)
+	large := artifacts("large", 2<<30)
+	failed := artifacts("failed", 4<<30)
+	d.Passed(small)
+	d.Passed(large)
+	disk.free = 1 << 30
+
+	// pruning the largest passed test's artifacts is enough
+	if d.Check() {
+		t.Error("pressure not relieved by pruning")
+	}
+	if _, err := os.Stat(filepath.Join(large, prunedNote)); err != nil {
+		t.Errorf("largest artifacts not pruned: %v", err)
+	}
+	for _, dir := range []string{small, failed} {
+		if _, err := os.Stat(filepath.Join(dir, "artifact")); err != nil {
+			t.Errorf("%s pruned: %v", dir, err)
+		}
+	}
+	if w := d.Warning(); !strings.Contains(w, "host disk nearly full: 1.0 GiB free") {
+		t.Errorf("warning %q", w)
+	}
+
+	// failed tests' artifacts are kept even if pressure remains, here
+	// because the space freed by pruning is taken by something else
+	disk.free = 0
+	disk.dirs = map[string]uint64{failed: 4 << 30}
+	if !d.Check() {
+		t.Error("no pressure with no space")
+	}
+	if _, err := os.Stat(filepath.Join(failed, "artifact")); err != nil {
+		t.Errorf("failed test's artifacts pruned: %v", err)
+	}
+	d.Skipped()
+	want := "Host disk nearly full: 0 B free on " + root + " at the lowest, below the low-water mark of 2.0 GiB\n" +
+		"\t1 tests skipped, 2 passed tests' artifacts pruned freeing 3.0 KiB\n"
+	if s := d.Summary(); s != want {
+		t.Errorf("got summary\n%s\nwant\n%s", s, want)
+	}
+}
+
+func TestDiskMonitorNil(t *testing.T) {
+	var d *diskMonitor
+	d.Passed("dir")
+	d.Skipped()
+	d.Stop()
+	if d.Check() || d.Warning() != "" || d.Summary() != "" {
+		t.Error("nil monitor reported something")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[uint64]string{
+		0:              "0 B",
+		1023:           "1023 B",
+		1536:           "1.5 KiB",
+		2 << 30:        "2.0 GiB",
+		5<<40 + 1<<39:  "5.5 TiB",
+		^uint64(0) - 1: "16.0 EiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
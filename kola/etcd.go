@@ -0,0 +1,62 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+// etcdVersionDropin selects the etcd release etcd-member.service runs.
+// etcd-member already runs etcd as a container with host networking, so
+// overriding the image tag keeps the unit's data directory, discovery
+// and client URLs, and etcdctl on the host, the same.
+const etcdVersionDropin = "/etc/systemd/system/etcd-member.service.d/50-kola-etcd-version.conf"
+
+// ParseEtcdVersion checks an etcd release such as "3.3.9" or "v3.3.9"
+// and returns its container image tag.
+func ParseEtcdVersion(v string) (string, error) {
+	if _, err := semver.NewVersion(strings.TrimPrefix(v, "v")); err != nil {
+		return "", fmt.Errorf("invalid etcd version %q: %v", v, err)
+	}
+	return "v" + strings.TrimPrefix(v, "v"), nil
+}
+
+// etcdVersion returns the image tag of the etcd release t runs against,
+// if not the image's default: the test's own EtcdVersion, or the
+// run-wide EtcdVersion if the test configures etcd-member.
+func etcdVersion(t *register.Test) string {
+	v := t.EtcdVersion
+	if v == "" && t.UserData != nil && t.UserData.Contains("etcd-member") {
+		v = EtcdVersion
+	}
+	if v == "" {
+		return ""
+	}
+	// both were validated when registered or parsed from flags
+	tag, _ := ParseEtcdVersion(v)
+	return tag
+}
+
+// addEtcdVersion returns userdata with etcd-member configured to run the
+// release with image tag.
+func addEtcdVersion(userdata *conf.UserData, tag string) *conf.UserData {
+	return userdata.AddFile(etcdVersionDropin, fmt.Sprintf("[Service]\nEnvironment=ETCD_IMAGE_TAG=%s\n", tag), 0644)
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+// experimentalRunner wraps the runner of a test on pltfrm so that, if
+// pltfrm is one of ExperimentalPlatforms, its failures are recorded but
+// do not fail the run.
+func experimentalRunner(pltfrm string, run func(*harness.H)) func(*harness.H) {
+	if !hasString(ExperimentalPlatforms, pltfrm) {
+		return run
+	}
+	return func(h *harness.H) {
+		h.NonFatal()
+		h.Annotate("experimental", true)
+		run(h)
+	}
+}
+
+// experimentalReporter collects the failed tests on experimental
+// platforms for the summary of a run.
+type experimentalReporter struct {
+	mu     sync.Mutex
+	failed []string
+	order  runOrder // of the summary; by name if nil
+}
+
+func (r *experimentalReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, annotations map[string]interface{}) {
+	if experimental, _ := annotations["experimental"].(bool); !experimental || result != testresult.Fail {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed = append(r.failed, name)
+}
+
+func (r *experimentalReporter) Output(path string) error               { return nil }
+func (r *experimentalReporter) SetResult(result testresult.TestResult) {}
+
+// Failed returns the names of the failed tests in the order they ran.
+func (r *experimentalReporter) Failed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	failed := append([]string(nil), r.failed...)
+	r.order.sort(failed)
+	return failed
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/harness/reporters"
+)
+
+func TestExperimentalPlatforms(t *testing.T) {
+	defer func(saved []string) { ExperimentalPlatforms = saved }(ExperimentalPlatforms)
+	ExperimentalPlatforms = []string{"packet"}
+
+	dir, err := ioutil.TempDir("", "kola-experimental-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fail := func(h *harness.H) { h.Fail() }
+	pass := func(h *harness.H) {}
+
+	run := func(name string, runners map[string]func(*harness.H)) (error, []string) {
+		var tests harness.Tests
+		tests.Add("test", func(h *harness.H) {
+			for _, pltfrm := range []string{"qemu", "packet"} {
+				h.Run(pltfrm, experimentalRunner(pltfrm, runners[pltfrm]))
+			}
+		})
+		experimental := &experimentalReporter{}
+		suite := harness.NewSuite(harness.Options{
+			OutputDir: filepath.Join(dir, name),
+			Verbose:   true,
+			Reporters: reporters.Reporters{experimental},
+		}, tests)
+		return suite.Run(), experimental.Failed()
+	}
+
+	err, failed := run("experimental", map[string]func(*harness.H){"qemu": pass, "packet": fail})
+	if err != nil {
+		t.Errorf("experimental failure failed the run: %v", err)
+	}
+	if want := []string{"test/packet"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("experimental failures %v, want %v", failed, want)
+	}
+
+	err, failed = run("supported", map[string]func(*harness.H){"qemu": fail, "packet": pass})
+	if err == nil {
+		t.Error("failure on a supported platform passed the run")
+	}
+	if len(failed) != 0 {
+		t.Errorf("unexpected experimental failures %v", failed)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/platform"
+)
+
+// failureLogs are the commands whose output is saved from each machine
+// of a failed test, beside the journal and console output recorded while
+// it ran.
+var failureLogs = []struct {
+	file string
+	cmd  string
+}{
+	{"journalctl.txt", "sudo journalctl --no-pager --boot=all"},
+	{"dmesg.txt", "sudo dmesg"},
+	{"networkd.txt", "networkctl --no-pager status --all; systemctl --no-pager status systemd-networkd"},
+}
+
+// failureLogTimeout bounds collecting the logs of each machine, so that
+// a wedged machine delays the teardown of a failed test only briefly.
+const failureLogTimeout = time.Minute
+
+// collectFailureLogs saves failureLogs from each machine of c, if h has
+// failed, to the machine's directory under dir. Machines which can't be
+// reached are only warned about.
+func collectFailureLogs(h *harness.H, c platform.Cluster, dir string) {
+	if !h.Failed() {
+		return
+	}
+	machines := c.Machines()
+	errs := make([][]error, len(machines))
+	var wg sync.WaitGroup
+	for i, m := range machines {
+		wg.Add(1)
+		go func(i int, m platform.Machine) {
+			defer wg.Done()
+			errs[i] = saveFailureLogs(m, filepath.Join(dir, m.ID()), failureLogTimeout)
+		}(i, m)
+	}
+	wg.Wait()
+	for i, m := range machines {
+		for _, err := range errs[i] {
+			h.Logf("warning: collecting logs of machine %s: %v", m.ID(), err)
+		}
+	}
+}
+
+// saveFailureLogs writes the output of each of failureLogs run on m to
+// dir, within timeout. Once a command times out the rest are skipped,
+// since the machine is unlikely to answer them either.
+func saveFailureLogs(m platform.Machine, dir string, timeout time.Duration) []error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return []error{err}
+	}
+	var errs []error
+	deadline := time.Now().Add(timeout)
+	for _, l := range failureLogs {
+		out, stderr, err := sshWithin(m, l.cmd, time.Until(deadline))
+		if err == errLogTimeout {
+			return append(errs, fmt.Errorf("%s: timed out after %v, skipping the rest", l.file, timeout))
+		}
+		if len(out) > 0 {
+			if werr := ioutil.WriteFile(filepath.Join(dir, l.file), out, 0666); werr != nil {
+				errs = append(errs, werr)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %q failed: %v: %s", l.file, l.cmd, err, bytes.TrimSpace(stderr)))
+		}
+	}
+	return errs
+}
+
+var errLogTimeout = errors.New("timed out")
+
+// sshWithin runs cmd on m, giving up after timeout. A command which
+// times out is abandoned rather than stopped.
+func sshWithin(m platform.Machine, cmd string, timeout time.Duration) ([]byte, []byte, error) {
+	if timeout <= 0 {
+		return nil, nil, errLogTimeout
+	}
+	type result struct {
+		out, stderr []byte
+		err         error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, stderr, err := m.SSH(cmd)
+		done <- result{out, stderr, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.out, r.stderr, r.err
+	case <-timer.C:
+		return nil, nil, errLogTimeout
+	}
+}
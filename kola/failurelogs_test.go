@@ -0,0 +1,78 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// logMachine answers SSH commands from its outputs, or never if hung.
+type logMachine struct {
+	platform.Machine
+	outputs map[string]string
+	hung    chan struct{}
+}
+
+func (m *logMachine) SSH(cmd string) ([]byte, []byte, error) {
+	if m.hung != nil {
+		<-m.hung
+	}
+	out, ok := m.outputs[cmd]
+	if !ok {
+		return nil, []byte("command not found"), errors.New("exit status 127")
+	}
+	return []byte(out), nil, nil
+}
+
+func TestSaveFailureLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-failure-logs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &logMachine{outputs: map[string]string{
+		failureLogs[0].cmd: "journal",
+		failureLogs[1].cmd: "dmesg",
+	}}
+	errs := saveFailureLogs(m, filepath.Join(dir, "up"), time.Minute)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "command not found") {
+		t.Errorf("expected the networkd failure only, got %v", errs)
+	}
+	for file, want := range map[string]string{"journalctl.txt": "journal", "dmesg.txt": "dmesg"} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, "up", file))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v; want %q", file, got, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "up", "networkd.txt")); !os.IsNotExist(err) {
+		t.Errorf("saved output of a failed command without any: %v", err)
+	}
+
+	hung := &logMachine{hung: make(chan struct{})}
+	defer close(hung.hung)
+	errs = saveFailureLogs(hung, filepath.Join(dir, "hung"), 10*time.Millisecond)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "skipping the rest") {
+		t.Errorf("expected one timeout, got %v", errs)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/system"
+)
+
+// Rough numbers of file descriptors held, erring high: kola's own logs,
+// reports and caches; a cluster's SSH agent and local services; and a
+// machine's SSH connections, journal, console and, on qemu, its disks,
+// tap device and sockets.
+const (
+	fdsPerRun     = 64
+	fdsPerCluster = 16
+	fdsPerMachine = 16
+)
+
+// fdSampleInterval is how often the open file descriptors of a run are
+// counted.
+const fdSampleInterval = 10 * time.Second
+
+// testFDs estimates the file descriptors a run of t holds at its peak.
+func testFDs(t *register.Test) int {
+	machines := t.ClusterSize
+	for _, s := range t.BootStages {
+		machines += s.Size
+	}
+	clusters := 1 + len(t.AdditionalClusters)
+	for _, spec := range t.AdditionalClusters {
+		machines += spec.Size
+	}
+	return clusters*fdsPerCluster + machines*fdsPerMachine
+}
+
+// estimateFDs estimates the file descriptors a run holds if the
+// parallel largest of its test runs, given with the number of platforms
+// each runs on, overlap.
+func estimateFDs(tests map[string]*register.Test, platforms map[string][]string, parallel int) int {
+	if parallel < 1 {
+		// the harness default
+		parallel = runtime.GOMAXPROCS(0)
+	}
+	var runs []int
+	for name, t := range tests {
+		for range platforms[name] {
+			runs = append(runs, testFDs(t))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(runs)))
+	if len(runs) > parallel {
+		runs = runs[:parallel]
+	}
+	fds := fdsPerRun
+	for _, n := range runs {
+		fds += n
+	}
+	return fds
+}
+
+// checkFileLimit warns if the limit on open files, which kola raises to
+// the hard limit at startup, is below the estimate for a run.
+func checkFileLimit(estimate int) {
+	limit, err := system.FileLimit()
+	if err != nil {
+		plog.Warningf("Reading the open file limit: %v", err)
+		return
+	}
+	if uint64(estimate) > limit {
+		plog.Warningf("This run may need about %d open files but is limited to %d; expect \"too many open files\" errors. Raise the hard limit (ulimit -Hn) or reduce --parallel.", estimate, limit)
+	}
+}
+
+// fdSampler periodically counts the open file descriptors of kola, so
+// that leaks show up as a trend within a run.
+type fdSampler struct {
+	start   time.Time
+	stop    chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+	samples []fdSample
+}
+
+type fdSample struct {
+	elapsed time.Duration
+	fds     int
+}
+
+// sampleFDs starts sampling every interval.
+func sampleFDs(interval time.Duration) *fdSampler {
+	s := &fdSampler{
+		start: time.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	s.sample()
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sample()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *fdSampler) sample() {
+	n, err := system.OpenFiles()
+	if err != nil {
+		plog.Debugf("Counting open files: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, fdSample{time.Since(s.start), n})
+}
+
+// Stop takes a last sample, stops sampling, writes the samples to path
+// as lines of elapsed seconds and open file descriptors, and returns a
+// one-line summary.
+func (s *fdSampler) Stop(path string) (string, error) {
+	close(s.stop)
+	<-s.done
+	s.sample()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var buf bytes.Buffer
+	peak := 0
+	for _, sample := range s.samples {
+		fmt.Fprintf(&buf, "%.0f\t%d\n", sample.elapsed.Seconds(), sample.fds)
+		if sample.fds > peak {
+			peak = sample.fds
+		}
+	}
+	var summary string
+	if len(s.samples) > 0 {
+		summary = fmt.Sprintf("Open files: %d at start, %d at peak, %d at end",
+			s.samples[0].fds, peak, s.samples[len(s.samples)-1].fds)
+	}
+	return summary, ioutil.WriteFile(path, buf.Bytes(), 0666)
+}
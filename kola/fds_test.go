@@ -0,0 +1,79 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/kola/register"
+)
+
+func TestEstimateFDs(t *testing.T) {
+	tests := map[string]*register.Test{
+		"small": {ClusterSize: 1},
+		"large": {
+			ClusterSize:        3,
+			AdditionalClusters: []register.ClusterSpec{{Size: 2}},
+		},
+		"staged": {BootStages: []register.BootStage{{Size: 1}, {Size: 2}}},
+	}
+	platforms := map[string][]string{
+		"small":  {"qemu"},
+		"large":  {"qemu", "gce"},
+		"staged": {"qemu"},
+	}
+	large := 2*fdsPerCluster + 5*fdsPerMachine
+	staged := fdsPerCluster + 3*fdsPerMachine
+
+	// the two runs of large overlap, then staged joins them
+	if got, want := estimateFDs(tests, platforms, 2), fdsPerRun+2*large; got != want {
+		t.Errorf("parallel 2: got %d, want %d", got, want)
+	}
+	if got, want := estimateFDs(tests, platforms, 3), fdsPerRun+2*large+staged; got != want {
+		t.Errorf("parallel 3: got %d, want %d", got, want)
+	}
+}
+
+func TestFDSampler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-fds-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := sampleFDs(time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	path := filepath.Join(dir, "fds.txt")
+	summary, err := s.Stop(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(summary, "Open files: ") {
+		t.Errorf("unexpected summary %q", summary)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first and last samples, at least
+	if lines := strings.Count(string(buf), "\n"); lines < 2 {
+		t.Errorf("%d samples written:\n%s", lines, buf)
+	}
+}
@@ -15,14 +15,20 @@
 package kola
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coreos/go-semver/semver"
@@ -33,12 +39,15 @@ import (
 	"github.com/coreos/mantle/kola/cluster"
 	"github.com/coreos/mantle/kola/register"
 	"github.com/coreos/mantle/kola/torcx"
+	"github.com/coreos/mantle/network"
 	"github.com/coreos/mantle/platform"
 	awsapi "github.com/coreos/mantle/platform/api/aws"
 	doapi "github.com/coreos/mantle/platform/api/do"
 	esxapi "github.com/coreos/mantle/platform/api/esx"
 	gcloudapi "github.com/coreos/mantle/platform/api/gcloud"
 	packetapi "github.com/coreos/mantle/platform/api/packet"
+	"github.com/coreos/mantle/platform/conf"
+	"github.com/coreos/mantle/platform/fault"
 	"github.com/coreos/mantle/platform/machine/aws"
 	"github.com/coreos/mantle/platform/machine/do"
 	"github.com/coreos/mantle/platform/machine/esx"
@@ -46,6 +55,7 @@ import (
 	"github.com/coreos/mantle/platform/machine/packet"
 	"github.com/coreos/mantle/platform/machine/qemu"
 	"github.com/coreos/mantle/system"
+	"github.com/coreos/mantle/util"
 )
 
 var (
@@ -69,6 +79,145 @@ var (
 
 	UpdatePayloadFile string
 
+	CacheDir string // if not "", record passing tests here
+	UseCache bool   // skip tests with a cached pass in CacheDir
+
+	StrictCrypto bool // only use FIPS 140-2 approved SSH keys and algorithms
+
+	IncludeHostDestructive bool // run tests marked register.Test.DestructiveHost
+
+	// Tags, if not empty, runs only the tests with at least one of
+	// these register.Test.Tags, and SkipTags leaves out those with any
+	// of its tags. Both apply on top of the test name patterns.
+	Tags     []string
+	SkipTags []string
+
+	// AuditCleanup snapshots the host state kola changes, such as
+	// network links and processes, before the run and counts the run as
+	// leaking if anything new is left once it is over. AuditAllow holds
+	// glob patterns of the items which may be left, e.g.
+	// "file /tmp/kola-cache*".
+	AuditCleanup bool
+	AuditAllow   []string
+
+	// ConfigFormat selects how register.Test.Intent is rendered: a
+	// conf.Format, "all" to run such tests once per format, or empty
+	// for the platform's default.
+	ConfigFormat string
+
+	// MaxMachineLifetime, if not zero, destroys machines which outlive
+	// it and flags the tests which created them.
+	MaxMachineLifetime time.Duration
+
+	// SharedMachineLifetime, if not zero, destroys machines of shared
+	// clusters which outlive it. Otherwise they are allowed
+	// sharedLifetimeFactor times MaxMachineLifetime, since a shared
+	// cluster serves several tests in turn.
+	SharedMachineLifetime time.Duration
+
+	// RunTimeout, if not zero, is the deadline of the whole run. Tests
+	// still running when it expires fail, and the run exits with
+	// ExitDeadline.
+	RunTimeout time.Duration
+
+	// ResourceSampleInterval, if not zero, is how often the host
+	// processes of tests' machines, such as qemu's, are measured. Each
+	// test's CPU time and peak memory are reported.
+	ResourceSampleInterval time.Duration
+
+	// MachineBackoff retries starting each machine of a test's
+	// clusters which fails to start, unless its config is invalid.
+	MachineBackoff util.Backoff
+
+	// Timeouts bounds tests which set no register.Test.Timeout, and the
+	// operations tests run. Unset fields take cluster.DefaultTimeouts.
+	Timeouts cluster.Timeouts
+
+	// JUnitFile, if not "", is where a JUnit XML report of the run is
+	// written, with a test case for each test and platform.
+	JUnitFile string
+
+	// ExperimentalPlatforms run tests normally, but their failures are
+	// reported separately and don't fail the run. Their results are
+	// annotated as experimental.
+	ExperimentalPlatforms []string
+
+	// FailFast skips the tests which have yet to start once a test
+	// has failed, other than on an ExperimentalPlatform.
+	FailFast bool
+
+	// PlatformParallelism caps how many tests may hold clusters on a
+	// platform at once, on top of TestParallelism, e.g. to stay within
+	// a cloud's instance quota.
+	PlatformParallelism map[string]int
+
+	// MasterSeed is the seed of the run's randomness, from which each
+	// attempt at a test gets its own seed for TestCluster.Rand. If 0,
+	// RunTests picks one; it is printed so a run can be replayed.
+	MasterSeed int64
+
+	// DiskLowWater is the free space below which the host is under disk
+	// pressure during a run: tests don't start while it lasts, failures
+	// are annotated and passed tests' artifacts are pruned. 0 disables
+	// the monitoring.
+	DiskLowWater int64
+
+	// Retention decides which artifacts of past runs and which cache
+	// entries Prune keeps.
+	Retention = RetentionPolicy{
+		KeepRuns:       10,
+		KeepFailedRuns: 30,
+		MaxCacheSize:   10 << 30,
+	}
+
+	// ShareClusters lets tests with the same platform, ClusterSize and
+	// config share a cluster, reset between them, instead of each
+	// creating its own. Tests with register.Test.ExclusiveCluster,
+	// BootStages, AdditionalClusters or an etcd discovery URL never
+	// share. It is ignored with FaultSeed.
+	ShareClusters bool
+
+	// Shuffle starts the tests in a random order rather than sorted by
+	// name, shuffled with ShuffleSeed. If ShuffleSeed is 0, RunTests
+	// picks one; it is printed so an ordering can be replayed.
+	Shuffle     bool
+	ShuffleSeed int64
+
+	// FaultSeed, if not 0, injects failures into the platform layer of
+	// test clusters as decided by the seed. See package fault.
+	FaultSeed int64
+
+	// DebugInteractive pauses a single test at its breakpoints and
+	// failed subtests, asking on stdin whether to continue, retry or
+	// abort. See cluster.TestCluster.Breakpoint.
+	DebugInteractive bool
+
+	// EtcdVersion, if not "", runs etcd-member from this etcd release
+	// in tests which configure it, unless the test sets its own.
+	EtcdVersion string
+
+	// ConfigDir, if not "", is the directory relative paths to local
+	// config files, such as register.Test.UserDataFile, are resolved
+	// against instead of the working directory.
+	ConfigDir string
+
+	// ArtifactsDir, if not "", is the root of the artifacts layout
+	// instead of the run's output directory. It may be shared by
+	// concurrent runs with different RunIDs.
+	ArtifactsDir string
+
+	// RunID names this run in the artifacts layout. If empty, one is
+	// generated from the time, host and process.
+	RunID string
+
+	// ArtifactLimits caps the size of logs collected by each test.
+	// Tests may override it via register.Test.ArtifactLimits.
+	ArtifactLimits platform.ArtifactLimits
+
+	// TriageRulesFile, if not "", is a YAML list of TriageRules which
+	// are applied to failures along with the built-in ones.
+	TriageRulesFile string
+
 	consoleChecks = []struct {
 		desc     string
 		match    *regexp.Regexp
@@ -160,51 +309,75 @@ func NewCluster(pltfrm string, rconf *platform.RuntimeConfig) (cluster platform.
 	return
 }
 
-func filterTests(tests map[string]*register.Test, pattern, platform string, version semver.Version) (map[string]*register.Test, error) {
+// newTestCluster is NewCluster for the clusters of tests, into which
+// faults are injected if FaultSeed is set. key identifies the cluster
+// within the run so that a seed injects the same faults into it each
+// time.
+func newTestCluster(pltfrm string, rconf *platform.RuntimeConfig, key string) (platform.Cluster, error) {
+	c, err := NewCluster(pltfrm, rconf)
+	if err != nil || FaultSeed == 0 {
+		return c, err
+	}
+	return fault.Wrap(c, FaultSeed, key), nil
+}
+
+// injectedFaults returns the faults injected into cluster name, if any.
+func injectedFaults(name string, c platform.Cluster) []string {
+	fc, ok := c.(interface {
+		InjectedFaults() []string
+	})
+	if !ok {
+		return nil
+	}
+	var faults []string
+	for _, f := range fc.InjectedFaults() {
+		faults = append(faults, name+": "+f)
+	}
+	return faults
+}
+
+// PlatformCapabilities returns the capabilities of the named platform
+// without creating a cluster.
+func PlatformCapabilities(pltfrm string) (platform.Capabilities, error) {
+	switch pltfrm {
+	case "aws":
+		return aws.Capabilities, nil
+	case "do":
+		return do.Capabilities, nil
+	case "esx":
+		return esx.Capabilities, nil
+	case "gce":
+		return gcloud.Capabilities, nil
+	case "packet":
+		return packet.Capabilities, nil
+	case "qemu":
+		return qemu.Capabilities, nil
+	default:
+		return nil, fmt.Errorf("invalid platform %q", pltfrm)
+	}
+}
+
+func filterTests(tests map[string]*register.Test, sel *Selection, platform string, version semver.Version) (map[string]*register.Test, error) {
 	r := make(map[string]*register.Test)
 
 	for name, t := range tests {
-		match, err := filepath.Match(pattern, t.Name)
-		if err != nil {
-			return nil, err
-		}
-		if !match {
+		if !sel.Match(t.Name) {
 			continue
 		}
 
-		// Check the test's min and end versions when running more then one test
-		if t.Name != pattern && versionOutsideRange(version, t.MinVersion, t.EndVersion) {
+		if t.DestructiveHost && !IncludeHostDestructive {
+			if sel.Names(t.Name) {
+				return nil, fmt.Errorf("test %v is destructive to the host and requires --include-host-destructive", t.Name)
+			}
 			continue
 		}
 
-		allowed := true
-		for _, p := range t.Platforms {
-			if p == platform {
-				allowed = true
-				break
-			} else {
-				allowed = false
-			}
-		}
-		for _, p := range t.ExcludePlatforms {
-			if p == platform {
-				allowed = false
-			}
-		}
-		if !allowed {
+		// Check the test's min and end versions when running more then one test
+		if !sel.Names(t.Name) && versionOutsideRange(version, t.MinVersion, t.EndVersion) {
 			continue
 		}
 
-		arch := architecture(platform)
-		for _, a := range t.Architectures {
-			if a == arch {
-				allowed = true
-				break
-			} else {
-				allowed = false
-			}
-		}
-		if !allowed {
+		if !platformAllowed(t, platform) || !tagsAllowed(t) {
 			continue
 		}
 
@@ -214,6 +387,39 @@ func filterTests(tests map[string]*register.Test, pattern, platform string, vers
 	return r, nil
 }
 
+// platformAllowed reports whether t may run on platform according to its
+// Platforms, ExcludePlatforms and Architectures.
+func platformAllowed(t *register.Test, platform string) bool {
+	allowed := true
+	for _, p := range t.Platforms {
+		if p == platform {
+			allowed = true
+			break
+		} else {
+			allowed = false
+		}
+	}
+	for _, p := range t.ExcludePlatforms {
+		if p == platform {
+			allowed = false
+		}
+	}
+	if !allowed {
+		return false
+	}
+
+	arch := architecture(platform)
+	for _, a := range t.Architectures {
+		if a == arch {
+			allowed = true
+			break
+		} else {
+			allowed = false
+		}
+	}
+	return allowed
+}
+
 // versionOutsideRange checks to see if version is outside [min, end). If end
 // is a zero value, it is ignored and there is no upper bound. If version is a
 // zero value, the bounds are ignored.
@@ -234,79 +440,222 @@ func versionOutsideRange(version, minVersion, endVersion semver.Version) bool {
 }
 
 // RunTests is a harness for running multiple tests in parallel. Filters
-// tests based on a glob pattern and by platform. Has access to all
+// tests based on their names, see Selection, and by platform. Has access to all
 // tests either registered in this package or by imported packages that
 // register tests in their init() function.
+// When more than one platform is given, each test runs as a group of
+// per-platform subtests which may execute concurrently, each with its own
+// cluster and output directory.
 // outputDir is where various test logs and data will be written for
 // analysis after the test run. If it already exists it will be erased!
-func RunTests(pattern, pltfrm, outputDir string) error {
-	var versionStr string
+// The caller should print the result with Finish and exit with the
+// status it returns.
+func RunTests(sel *Selection, pltfrms []string, outputDir string) *RunResult {
+	r := newRunResult()
+	var before hostState
+	if AuditCleanup {
+		var err error
+		if before, err = snapshotHost(); err != nil {
+			r.Err = fmt.Errorf("--audit-cleanup: %v", err)
+			return r
+		}
+	}
+	r.outputDir = outputDir
+	r.Err = runTests(r, sel, pltfrms, outputDir)
+	if AuditCleanup {
+		auditCleanup(r, before, outputDir)
+	}
+	return r
+}
 
-	// Avoid incurring cost of starting machine in getClusterSemver when
-	// either:
-	// 1) none of the selected tests care about the version
-	// 2) glob is an exact match which means minVersion will be ignored
-	//    either way
-	// 3) the provided torcx flag is wrong
-	tests, err := filterTests(register.Tests, pattern, pltfrm, semver.Version{})
-	if err != nil {
-		plog.Fatal(err)
+// runTests runs the tests for RunTests, counting them in r. It returns
+// an error if the tests couldn't be run, or if the run failed other than
+// by tests failing.
+func runTests(r *RunResult, sel *Selection, pltfrms []string, outputDir string) error {
+	if err := loadTorcxManifest(); err != nil {
+		return err
+	}
+	if err := loadTriageRules(); err != nil {
+		return err
+	}
+	if err := checkTags(sel); err != nil {
+		return err
 	}
 
-	skipGetVersion := true
-	for name, t := range tests {
-		if name != pattern && (t.MinVersion != semver.Version{} || t.EndVersion != semver.Version{}) {
-			skipGetVersion = false
-			break
+	tests, testPlatforms, versions, err := expandTests(sel, pltfrms, outputDir)
+	if err != nil {
+		return err
+	}
+	if CacheDir != "" {
+		// keep kola prune off the cache while the run uses it
+		l, err := lockCache(CacheDir)
+		if err != nil {
+			return fmt.Errorf("locking the cache directory: %v", err)
+		}
+		defer l.Unlock()
+	}
+	caches := make(map[string]*resultCache)
+	for _, pltfrm := range pltfrms {
+		// results with injected faults don't belong in the cache
+		if CacheDir != "" && FaultSeed == 0 {
+			cache, err := newResultCache(CacheDir, pltfrm)
+			if err != nil {
+				plog.Warningf("Result cache disabled on %s: %v", pltfrm, err)
+			} else {
+				caches[pltfrm] = cache
+			}
 		}
 	}
 
-	if TorcxManifestFile != "" {
-		TorcxManifest = &torcx.Manifest{}
-		torcxManifestFile, err := os.Open(TorcxManifestFile)
-		if err != nil {
-			return errors.New("Torcx manifest path provided could not be read")
+	if DebugInteractive {
+		runs := 0
+		for _, platforms := range testPlatforms {
+			runs += len(platforms)
 		}
-		if err := json.NewDecoder(torcxManifestFile).Decode(TorcxManifest); err != nil {
-			return fmt.Errorf("could not parse torcx manifest as valid json: %v", err)
+		if runs != 1 {
+			return fmt.Errorf("--debug-interactive needs a single test on a single platform; %d selected", runs)
 		}
-		torcxManifestFile.Close()
 	}
 
-	if !skipGetVersion {
-		version, err := getClusterSemver(pltfrm, outputDir)
-		if err != nil {
-			plog.Fatal(err)
+	var destructive []string
+	for name, t := range tests {
+		if t.DestructiveHost {
+			destructive = append(destructive, name)
 		}
+	}
+	if len(destructive) > 0 {
+		sort.Strings(destructive)
+		plog.Warningf("Running %d tests which are destructive to this host:\n\t%s",
+			len(destructive), strings.Join(destructive, "\n\t"))
+	}
 
-		versionStr = version.String()
+	checkFileLimit(estimateFDs(tests, testPlatforms, TestParallelism))
 
-		// one more filter pass now that we know real version
-		tests, err = filterTests(tests, pattern, pltfrm, *version)
-		if err != nil {
-			plog.Fatal(err)
+	if MasterSeed == 0 {
+		MasterSeed = time.Now().UnixNano()
+	}
+	plog.Noticef("Master seed %d", MasterSeed)
+	var shuffleSeed int64
+	if Shuffle {
+		if ShuffleSeed == 0 {
+			ShuffleSeed = time.Now().UnixNano()
 		}
+		shuffleSeed = ShuffleSeed
+		plog.Noticef("Shuffling tests with seed %d; replay the order with --shuffle=%d", shuffleSeed, shuffleSeed)
 	}
 
+	report := reporters.NewJSONReporter("report.json", strings.Join(pltfrms, ","), strings.Join(versions, ","))
+	report.Environment = runEnvironment()
+	experimental := &experimentalReporter{}
+	failures := &failureReporter{}
+	usage := &usageReporter{}
+	tally := &tallyReporter{result: r}
+	layout := newArtifactLayout(outputDir)
+	locker := &runLocker{dirs: []string{outputDir}}
+	if layout.root != outputDir {
+		locker.dirs = append(locker.dirs, layout.runDir())
+	}
 	opts := harness.Options{
-		OutputDir: outputDir,
-		Parallel:  TestParallelism,
-		Verbose:   true,
+		OutputDir:   outputDir,
+		Parallel:    TestParallelism,
+		ShuffleSeed: shuffleSeed,
+		Verbose:     true,
 		Reporters: reporters.Reporters{
-			reporters.NewJSONReporter("report.json", pltfrm, versionStr),
+			locker, // first, to lock before the others start
+			report,
+			experimental,
+			failures,
+			usage,
+			tally,
 		},
 	}
+	excluded := excludedTests(sel, pltfrms)
+	nexcluded := 0
+	for _, names := range excluded {
+		nexcluded += len(names)
+	}
+	r.Skipped += nexcluded
+	if JUnitFile != "" {
+		junit := newJUnitReporter(JUnitFile)
+		for _, pltfrm := range pltfrms {
+			for _, name := range excluded[pltfrm] {
+				junit.Skip(name, pltfrm, "not supported on "+pltfrm)
+			}
+		}
+		opts.Reporters = append(opts.Reporters, junit)
+	}
+
+	if DiskLowWater > 0 {
+		runDisk = newDiskMonitor([]string{outputDir, layout.root, os.TempDir()}, uint64(DiskLowWater))
+		runDisk.Check()
+		runDisk.Start(diskCheckInterval)
+		defer func() {
+			runDisk.Stop()
+			runDisk = nil
+		}()
+	}
+	if ShareClusters && FaultSeed == 0 {
+		runPool = newClusterPool(context.Background(), TestParallelism, PlatformParallelism)
+		defer func() {
+			runPool.DestroyAll()
+			runPool = nil
+		}()
+	}
 	var htests harness.Tests
-	for _, test := range tests {
-		test := test // for the closure
-		run := func(h *harness.H) {
-			runTest(h, test, pltfrm)
+	for name, test := range tests {
+		if len(pltfrms) == 1 {
+			htests.Add(name, experimentalRunner(pltfrms[0], platformRunner(test, pltfrms[0], caches[pltfrms[0]], layout)))
+			continue
 		}
-		htests.Add(test.Name, run)
+
+		test := test // for the closure
+		platforms := testPlatforms[name]
+		htests.Add(name, func(h *harness.H) {
+			// Only hold a parallelism slot while starting the
+			// subtests, not while waiting for them.
+			h.Parallel()
+			for _, pltfrm := range platforms {
+				h.Run(pltfrm, experimentalRunner(pltfrm, platformRunner(test, pltfrm, caches[pltfrm], layout)))
+			}
+		})
+	}
+
+	order := newRunOrder(htests.Order(shuffleSeed))
+	experimental.order = order
+	failures.order = order
+
+	ctx := context.Background()
+	if RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, RunTimeout)
+		defer cancel()
 	}
 
 	suite := harness.NewSuite(opts, htests)
-	err = suite.Run()
+	fds := sampleFDs(fdSampleInterval)
+	err = suite.RunContext(ctx)
+	if err == harness.SuiteEmpty && nexcluded > 0 {
+		// every matching test was excluded by the platforms
+		// requested, which is reported below
+		err = nil
+	}
+	if err != harness.SuiteEmpty {
+		r.ran = true
+	}
+	r.deadline = ctx.Err() == context.DeadlineExceeded
+	if err == harness.SuiteFailed && r.Failed > 0 {
+		// the failures are counted
+		err = nil
+	}
+	if err := destroyScratch(); err != nil {
+		plog.Errorf("Leaked GCS scratch storage: %v", err)
+		r.leaked++
+	}
+	if summary, err := fds.Stop(filepath.Join(outputDir, "fds.txt")); err != nil {
+		plog.Warningf("Saving open file samples: %v", err)
+	} else {
+		plog.Info(summary)
+	}
 
 	if TAPFile != "" {
 		src := filepath.Join(outputDir, "test.tap")
@@ -315,27 +664,251 @@ func RunTests(pattern, pltfrm, outputDir string) error {
 		}
 	}
 
+	if failed := experimental.Failed(); len(failed) > 0 {
+		fmt.Printf("Experimental failures, not failing the run:\n\t%s\n", strings.Join(failed, "\n\t"))
+	}
+	fmt.Print(failures.Summary())
+	fmt.Print(excludedSummary(excluded, pltfrms))
+	fmt.Print(usage.Summary())
+	fmt.Print(runDisk.Summary())
+	return err
+}
+
+// expandTests returns copies of the tests selected by sel which run on
+// any of pltfrms, the platforms each runs on in the order given, and the
+// OS versions determined to filter them.
+func expandTests(sel *Selection, pltfrms []string, outputDir string) (map[string]*register.Test, map[string][]string, []string, error) {
+	tests := make(map[string]*register.Test)
+	testPlatforms := make(map[string][]string)
+	var versions []string
+	for _, pltfrm := range pltfrms {
+		semverDir := "get_cluster_semver"
+		if len(pltfrms) > 1 {
+			semverDir += "-" + pltfrm
+		}
+		selected, versionStr, err := selectTests(sel, pltfrm, filepath.Join(outputDir, semverDir))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for name, t := range selected {
+			tests[name] = t
+			testPlatforms[name] = append(testPlatforms[name], pltfrm)
+		}
+		if versionStr != "" && !hasString(versions, versionStr) {
+			versions = append(versions, versionStr)
+		}
+	}
+	return tests, testPlatforms, versions, nil
+}
+
+// excludedTests returns, for each of pltfrms, the sorted names of the
+// tests selected by sel which are not run there because of their
+// platform or architecture lists.
+func excludedTests(sel *Selection, pltfrms []string) map[string][]string {
+	registered := register.All()
+	var names []string
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	excluded := make(map[string][]string)
+	for _, pltfrm := range pltfrms {
+		for _, name := range names {
+			t := registered[name]
+			if sel.Match(t.Name) && tagsAllowed(t) && !platformAllowed(t, pltfrm) {
+				excluded[pltfrm] = append(excluded[pltfrm], name)
+			}
+		}
+	}
+	return excluded
+}
+
+// loadTorcxManifest reads TorcxManifestFile, if set, into TorcxManifest.
+func loadTorcxManifest() error {
+	if TorcxManifestFile == "" {
+		return nil
+	}
+	TorcxManifest = &torcx.Manifest{}
+	torcxManifestFile, err := os.Open(TorcxManifestFile)
 	if err != nil {
-		fmt.Printf("FAIL, output in %v\n", outputDir)
-	} else {
-		fmt.Printf("PASS, output in %v\n", outputDir)
+		return errors.New("Torcx manifest path provided could not be read")
 	}
+	defer torcxManifestFile.Close()
+	if err := json.NewDecoder(torcxManifestFile).Decode(TorcxManifest); err != nil {
+		return fmt.Errorf("could not parse torcx manifest as valid json: %v", err)
+	}
+	return nil
+}
 
-	return err
+// selectTests returns the tests selected by sel which can run on pltfrm,
+// along with the OS version if one had to be determined to filter them.
+func selectTests(sel *Selection, pltfrm, semverDir string) (map[string]*register.Test, string, error) {
+	// Avoid incurring cost of starting machine in getClusterSemver when
+	// either:
+	// 1) none of the selected tests care about the version
+	// 2) every test is named exactly by a pattern, which means
+	//    minVersion will be ignored either way
+	tests, err := filterTests(register.All(), sel, pltfrm, semver.Version{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if tests, err = loadUserDataFiles(tests); err != nil {
+		return nil, "", err
+	}
+
+	if err := checkUserDataFiles(tests); err != nil {
+		return nil, "", err
+	}
+
+	if err := checkKoletSkew(tests, pltfrm); err != nil {
+		return nil, "", err
+	}
+
+	for name, t := range tests {
+		for _, spec := range t.AdditionalClusters {
+			if _, err := PlatformCapabilities(spec.Platform); err != nil {
+				return nil, "", fmt.Errorf("test %v: cluster %v: %v", name, spec.Name, err)
+			}
+		}
+	}
+
+	skipGetVersion := true
+	for name, t := range tests {
+		if !sel.Names(name) && (t.MinVersion != semver.Version{} || t.EndVersion != semver.Version{}) {
+			skipGetVersion = false
+			break
+		}
+	}
+	if skipGetVersion {
+		return tests, "", nil
+	}
+
+	version, err := getClusterSemver(pltfrm, semverDir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// one more filter pass now that we know real version
+	tests, err = filterTests(tests, sel, pltfrm, *version)
+	if err != nil {
+		return nil, "", err
+	}
+	return tests, version.String(), nil
+}
+
+// platformRunner returns the harness function running test on pltfrm,
+// consulting cache if it is not nil.
+func platformRunner(test *register.Test, pltfrm string, cache *resultCache, layout artifactLayout) func(*harness.H) {
+	if test.Intent == nil {
+		return formatRunner(test, pltfrm, cache, layout)
+	}
+
+	return func(h *harness.H) {
+		formats, err := configFormats(pltfrm)
+		if err != nil {
+			h.Fatal(err)
+		}
+		if len(formats) == 1 {
+			runner, err := intentRunner(test, formats[0], pltfrm, cache, layout)
+			if err != nil {
+				h.Fatal(err)
+			}
+			runner(h)
+			return
+		}
+
+		// Only hold a parallelism slot while starting the subtests.
+		h.Parallel()
+		for _, format := range formats {
+			runner, err := intentRunner(test, format, pltfrm, cache, layout)
+			if err != nil {
+				h.Error(err)
+				continue
+			}
+			h.Run(string(format), runner)
+		}
+	}
+}
+
+// intentRunner returns the harness function running test, which must
+// have an Intent, with its Intent rendered in format.
+func intentRunner(test *register.Test, format conf.Format, pltfrm string, cache *resultCache, layout artifactLayout) (func(*harness.H), error) {
+	userdata, err := test.Intent.UserData(format)
+	if err != nil {
+		return nil, fmt.Errorf("rendering %s: %v", format, err)
+	}
+	rendered := *test
+	rendered.UserData = userdata
+	run := formatRunner(&rendered, pltfrm, cache, layout)
+	return func(h *harness.H) {
+		h.Annotate("config_format", format)
+		run(h)
+	}, nil
+}
+
+// configFormats returns the formats Intents are rendered in on pltfrm.
+func configFormats(pltfrm string) ([]conf.Format, error) {
+	switch ConfigFormat {
+	case "":
+		// Every platform kola supports reads Ignition configs.
+		return []conf.Format{conf.FormatIgnition}, nil
+	case "all":
+		return conf.Formats, nil
+	default:
+		format, err := conf.ParseFormat(ConfigFormat)
+		if err != nil {
+			return nil, err
+		}
+		return []conf.Format{format}, nil
+	}
+}
+
+// formatRunner returns the harness function running test, with its
+// UserData in its final format, on pltfrm.
+func formatRunner(test *register.Test, pltfrm string, cache *resultCache, layout artifactLayout) func(*harness.H) {
+	return func(h *harness.H) {
+		h.Annotate("platform", pltfrm)
+		if cache != nil {
+			if UseCache && cache.Passed(test) {
+				h.Log("cached pass")
+				h.Annotate("cached", true)
+				return
+			}
+			defer func() {
+				if h.Skipped() {
+					return
+				}
+				if err := cache.Record(test, !h.Failed()); err != nil {
+					plog.Warningf("Recording %s in result cache: %v", test.Name, err)
+				}
+			}()
+		}
+		runTest(h, test, pltfrm, layout)
+	}
+}
+
+func hasString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
 }
 
 // getClusterSemVer returns the CoreOS semantic version via starting a
 // machine and checking
-func getClusterSemver(pltfrm, outputDir string) (*semver.Version, error) {
+func getClusterSemver(pltfrm, testDir string) (*semver.Version, error) {
 	var err error
 
-	testDir := filepath.Join(outputDir, "get_cluster_semver")
 	if err := os.MkdirAll(testDir, 0777); err != nil {
 		return nil, err
 	}
 
 	cluster, err := NewCluster(pltfrm, &platform.RuntimeConfig{
-		OutputDir: testDir,
+		OutputDir:    testDir,
+		StrictCrypto: StrictCrypto,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("creating cluster for semver check: %v", err)
@@ -360,12 +933,52 @@ func getClusterSemver(pltfrm, outputDir string) (*semver.Version, error) {
 	return version, nil
 }
 
-// runTest is a harness for running a single test.
-// outputDir is where various test logs and data will be written for
-// analysis after the test run. It should already exist.
-func runTest(h *harness.H, t *register.Test, pltfrm string) {
+// testSeed derives the seed of an attempt at a test on a platform from
+// the run's master seed.
+func testSeed(master int64, test, pltfrm string, attempt int) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s/%s/%d", master, test, pltfrm, attempt)
+	return int64(h.Sum64())
+}
+
+// runFailed is set once a test of the run has failed, for FailFast.
+var runFailed int32
+
+// runTest is a harness for running a single test. Logs and data from the
+// test's clusters are written to a new attempt directory allocated from
+// layout for analysis after the test run.
+func runTest(h *harness.H, t *register.Test, pltfrm string, layout artifactLayout) {
+	// a panic kills kola before runTests can destroy the shared clusters
+	defer func() {
+		if r := recover(); r != nil {
+			runPool.DestroyAll()
+			panic(r)
+		}
+	}()
 	h.Parallel()
 
+	t = runCopy(t)
+
+	if len(t.MachineUserData) > 0 && len(t.MachineUserData) != t.ClusterSize {
+		h.Fatalf("test has %d MachineUserData for a ClusterSize of %d", len(t.MachineUserData), t.ClusterSize)
+	}
+
+	if FailFast && atomic.LoadInt32(&runFailed) != 0 {
+		h.Skip("skipped after an earlier failure (--fail-fast)")
+	}
+	if runDisk.Check() {
+		runDisk.Skipped()
+		h.Skip("skipped because the host disk is nearly full (--disk-low-water)")
+	}
+
+	caps, err := PlatformCapabilities(pltfrm)
+	if err != nil {
+		h.Fatal(err)
+	}
+	if missing := caps.Missing(t.RequiredCapabilities); len(missing) > 0 {
+		h.Skipf("platform %s lacks required capabilities %v", pltfrm, missing)
+	}
+
 	// don't go too fast, in case we're talking to a rate limiting api like AWS EC2.
 	// FIXME(marineam): API requests must do their own
 	// backoff due to rate limiting, this is unreliable.
@@ -373,60 +986,367 @@ func runTest(h *harness.H, t *register.Test, pltfrm string) {
 	splay := time.Duration(rand.Int63n(max))
 	time.Sleep(splay)
 
+	acquirePlatformSlot(h, pltfrm)
+	runPool.hold(h, pltfrm)
+
+	// A test being debugged waits on the terminal as long as it takes.
+	if DebugInteractive {
+		setUpAndRunTest(h, t, pltfrm, layout)
+		return
+	}
+	h.Timeout(testTimeout(t), func() {
+		setUpAndRunTest(h, t, pltfrm, layout)
+	})
+}
+
+// runCopy returns a copy of t for a single run with the run's defaults
+// applied, leaving t and the registered test unchanged.
+func runCopy(t *register.Test) *register.Test {
+	c := t.Copy()
+	c.Timeout = testTimeout(t)
+	return c
+}
+
+// testTimeout returns how long t may take to set up its clusters and run.
+func testTimeout(t *register.Test) time.Duration {
+	switch {
+	case t.Timeout > 0:
+		return t.Timeout
+	default:
+		return Timeouts.WithDefaults().Test
+	}
+}
+
+// setUpAndRunTest creates the clusters of t on pltfrm and runs t. If it
+// times out the clusters are destroyed by its cleanups while it may
+// still be running.
+func setUpAndRunTest(h *harness.H, t *register.Test, pltfrm string, layout artifactLayout) {
+	// Registered first so that it runs after every other cleanup and
+	// sees failures from tearing down the clusters too.
+	setupStart := time.Now()
+	var setupDone int32
+	var infraFailed int32
+	h.Cleanup(func() {
+		if !h.Failed() {
+			return
+		}
+		runDisk.Check()
+		if warning := runDisk.Warning(); warning != "" {
+			h.Logf("Warning: %s", warning)
+			h.Annotate("disk_pressure", warning)
+		}
+		if !hasString(ExperimentalPlatforms, pltfrm) {
+			atomic.StoreInt32(&runFailed, 1)
+		}
+		if atomic.LoadInt32(&infraFailed) != 0 {
+			h.Annotate("failure_category", FailureInfra)
+		} else if atomic.LoadInt32(&setupDone) != 0 {
+			h.Annotate("failure_category", FailureTest)
+		} else {
+			h.Annotate("failure_category", FailureSetup)
+		}
+	})
+
+	var truncatedMu sync.Mutex
+	var truncated []string
+	limits := artifactLimits(t)
+	limits.Truncated = func(artifact string, dropped int64) {
+		truncatedMu.Lock()
+		defer truncatedMu.Unlock()
+		truncated = append(truncated, fmt.Sprintf("%s: %d bytes dropped", artifact, dropped))
+	}
+	h.Cleanup(func() {
+		truncatedMu.Lock()
+		defer truncatedMu.Unlock()
+		if len(truncated) > 0 {
+			h.Annotate("truncated", truncated)
+		}
+	})
+
+	artifactDir, attempt, err := layout.allocate(t.Name, pltfrm)
+	if err != nil {
+		h.Fatalf("Allocating artifact directory: %v", err)
+	}
+	h.Annotate("artifact_dir", artifactDir)
+	h.Annotate("attempt", attempt)
+	// registered before the clusters' cleanups so that their artifacts
+	// are complete by the time they may be pruned
+	h.Cleanup(func() {
+		result := "pass"
+		switch {
+		case h.Failed():
+			result = "fail"
+		case h.Skipped():
+			result = "skip"
+		default:
+			runDisk.Passed(artifactDir)
+		}
+		if err := writeAttemptResult(artifactDir, result); err != nil && !os.IsNotExist(err) {
+			plog.Warningf("Recording the result of %s: %v", h.Name(), err)
+		}
+	})
+
+	// registered before the clusters' cleanups so that it sees their
+	// complete logs
+	h.Cleanup(func() {
+		if !h.Failed() {
+			return
+		}
+		hints := triage(triageRules, readTriageLogs(h.Output(), artifactDir))
+		for _, hint := range hints {
+			h.Logf("Triage hint: %v", hint)
+		}
+		if len(hints) > 0 {
+			h.Annotate("triage_hints", hints)
+		}
+	})
+
+	seed := testSeed(MasterSeed, t.Name, pltfrm, attempt)
+	h.Annotate("random_seed", seed)
+	h.Cleanup(func() {
+		if h.Failed() {
+			h.Logf("Random seed %d; replay with --master-seed=%d", seed, MasterSeed)
+		}
+	})
+
 	rconf := &platform.RuntimeConfig{
-		OutputDir:          h.OutputDir(),
+		OutputDir:          artifactDir,
+		Limits:             limits,
+		RunID:              layout.runID,
 		NoSSHKeyInUserData: t.HasFlag(register.NoSSHKeyInUserData),
 		NoSSHKeyInMetadata: t.HasFlag(register.NoSSHKeyInMetadata),
 		NoEnableSelinux:    t.HasFlag(register.NoEnableSelinux),
+		NoUserDataWait:     t.HasFlag(register.NoUserDataWait),
+		StrictCrypto:       StrictCrypto,
+		MaxMachineLifetime: MaxMachineLifetime,
+		RequiredPorts:      t.RequiredPorts,
+		DestroyOrder:       t.DestroyOrder,
+		MachineOptions:     t.MachineOptions,
+		Context:            h.Context(),
 	}
-	c, err := NewCluster(pltfrm, rconf)
-	if err != nil {
-		h.Fatalf("Cluster failed: %v", err)
+	var leakedMu sync.Mutex
+	var leaked []string
+	rconf.MachineReaped = func(id string, age time.Duration) {
+		leakedMu.Lock()
+		defer leakedMu.Unlock()
+		leaked = append(leaked, id)
+		h.Logf("warning: leaked machine %v destroyed after %v", id, age)
+		h.Annotate("leaked_machines", append([]string(nil), leaked...))
 	}
-	defer func() {
-		c.Destroy()
-		for id, output := range c.ConsoleOutput() {
-			for _, badness := range CheckConsole([]byte(output), t) {
-				h.Errorf("Found %s on machine %s console", badness, id)
+	var leakedResources []string
+	rconf.MachineLeaked = func(id string, err error) {
+		leakedMu.Lock()
+		defer leakedMu.Unlock()
+		leakedResources = append(leakedResources, fmt.Sprintf("%s: %v", id, err))
+		h.Logf("warning: machine %v could not be deleted and may still exist: %v", id, err)
+		h.Annotate("leaked_resources", append([]string(nil), leakedResources...))
+	}
+	// cleanups run in turn, so faults needs no lock
+	var faults []string
+	noteFaults := func(name string, c platform.Cluster) {
+		if f := injectedFaults(name, c); len(f) > 0 {
+			faults = append(faults, f...)
+			h.Logf("Injected faults: %v", f)
+			h.Annotate("faults_injected", append([]string(nil), faults...))
+		}
+	}
+	etcdTag := etcdVersion(t)
+	if etcdTag != "" {
+		h.Annotate("etcd_version", etcdTag)
+	}
+	var userdata []*conf.UserData
+	if len(t.BootStages) == 0 && t.ClusterSize > 0 {
+		userdata = make([]*conf.UserData, t.ClusterSize)
+		for i := range userdata {
+			ud := t.UserData
+			if len(t.MachineUserData) > 0 {
+				ud = t.MachineUserData[i]
+			}
+			if userdata[i], err = prepareUserData(t, ud, etcdTag); err != nil {
+				h.Fatal(err)
 			}
 		}
-	}()
+	}
 
-	if t.ClusterSize > 0 {
-		userdata := t.UserData
-		if userdata != nil && userdata.Contains("$discovery") {
-			url, err := c.GetDiscoveryURL(t.ClusterSize)
-			if err != nil {
-				// Skip instead of failing since the harness not being able to
-				// get a discovery url is likely an outage (e.g
-				// 503 Service Unavailable: Back-end server is at capacity)
-				// not a problem with the OS
-				h.Skipf("Failed to create discovery endpoint: %v", err)
+	var c platform.Cluster
+	poolKey := runPool.key(t, pltfrm, userdata)
+	shared := runPool.take(h, poolKey, rconf.OutputDir)
+	if shared != nil {
+		c = shared.c
+		h.Logf("Sharing the cluster in %s with %d earlier tests", shared.dir, shared.tests-1)
+		h.Annotate("shared_cluster", shared.dir)
+	} else if poolKey != "" {
+		runPool.makeRoom(pltfrm)
+		// the cluster outlives the test, so it has a directory of
+		// its own, from which each test gets copies of its logs
+		dir, _, err := layout.allocate(sharedClustersDir, pltfrm)
+		if err != nil {
+			h.Fatal(err)
+		}
+		prconf := *rconf
+		prconf.OutputDir = dir
+		prconf.Context = runPool.ctx
+		prconf.MaxMachineLifetime = sharedMachineLifetime()
+		prconf.MachineReaped = func(id string, age time.Duration) {
+			plog.Warningf("Machine %v of a shared cluster destroyed after %v", id, age)
+		}
+		prconf.MachineLeaked = func(id string, err error) {
+			plog.Warningf("Machine %v of a shared cluster could not be deleted and may still exist: %v", id, err)
+		}
+		c, err = newTestCluster(pltfrm, &prconf, t.Name+"/"+pltfrm+"/primary")
+		if err != nil {
+			h.Fatalf("Cluster failed: %v", err)
+		}
+		shared = runPool.add(h, poolKey, pltfrm, dir, rconf.OutputDir, c, t)
+		h.Annotate("shared_cluster", dir)
+		fireHook(h, HookClusterCreated, pltfrm, "primary", dir, c)
+	} else {
+		runPool.makeRoom(pltfrm)
+		c, err = newTestCluster(pltfrm, rconf, t.Name+"/"+pltfrm+"/primary")
+		if err != nil {
+			h.Fatalf("Cluster failed: %v", err)
+		}
+		timeline := logTimeline(h.Logf, c, rconf.OutputDir)
+		fireHook(h, HookClusterCreated, pltfrm, "primary", rconf.OutputDir, c)
+		// Clusters are destroyed by cleanup functions rather than
+		// deferred calls so that cleanups registered by the test,
+		// which may need the machines, run first.
+		h.Cleanup(func() {
+			c.Destroy()
+			<-timeline
+			noteFaults("primary", c)
+			fireHook(h, HookClusterDestroyed, pltfrm, "primary", rconf.OutputDir, c)
+			for id, output := range c.ConsoleOutput() {
+				for _, badness := range CheckConsole([]byte(output), t) {
+					h.Errorf("Found %s on machine %s console", badness, id)
+				}
+			}
+		})
+	}
+	if shared != nil {
+		// handed back, or destroyed, after the test's own cleanups
+		// like an unshared cluster
+		h.Cleanup(func() { runPool.release(h, shared) })
+	}
+	fireTestHooks := func(event string) {
+		fireHook(h, event, pltfrm, "primary", rconf.OutputDir, c)
+	}
+	// these run before the cluster is destroyed
+	var usage *usageSampler
+	if ResourceSampleInterval > 0 {
+		usage = sampleUsage(ResourceSampleInterval)
+		usage.watch(c)
+		h.Cleanup(func() {
+			if u := usage.Stop(); len(u.Machines) > 0 {
+				h.Annotate("resources", u)
+			}
+		})
+	}
+	h.Cleanup(func() { collectFailureLogs(h, c, rconf.OutputDir) })
+
+	var stages map[string][]platform.Machine
+	var ordered []platform.Machine
+	var discoveryURL string
+	if len(t.BootStages) > 0 {
+		userdata, err := prepareUserData(t, t.UserData, etcdTag)
+		if err != nil {
+			h.Fatal(err)
+		}
+		stages, discoveryURL = startStages(h, c, userdata, t.BootStages, t.AddressFamily, rconf.OutputDir)
+	} else if shared != nil && shared.started {
+		ordered, discoveryURL = shared.machines, shared.discoveryURL
+	} else if t.ClusterSize > 0 {
+		ordered, discoveryURL = startMachines(h, c, userdata, t.AddressFamily, rconf.OutputDir)
+		if shared != nil {
+			shared.machines, shared.discoveryURL = ordered, discoveryURL
+			shared.started = true
+		}
+	}
+
+	additional := make(map[string]platform.Cluster)
+	clusterPlatforms := make(map[string]string)
+	for _, spec := range t.AdditionalClusters {
+		spec := spec // for the closure
+		arconf := *rconf
+		arconf.OutputDir = filepath.Join(artifactDir, spec.Name)
+		arconf.MachineOptions = platform.MachineOptions{} // the primary's
+		if err := os.MkdirAll(arconf.OutputDir, 0777); err != nil {
+			h.Fatal(err)
+		}
+		ac, err := newTestCluster(spec.Platform, &arconf, t.Name+"/"+pltfrm+"/"+spec.Name)
+		if err != nil {
+			h.Fatalf("Cluster %s failed: %v", spec.Name, err)
+		}
+		timeline := logTimeline(h.Logf, ac, arconf.OutputDir)
+		fireHook(h, HookClusterCreated, spec.Platform, spec.Name, arconf.OutputDir, ac)
+		fireOthers := fireTestHooks
+		fireTestHooks = func(event string) {
+			fireOthers(event)
+			fireHook(h, event, spec.Platform, spec.Name, arconf.OutputDir, ac)
+		}
+		h.Cleanup(func() {
+			ac.Destroy()
+			<-timeline
+			noteFaults(spec.Name, ac)
+			fireHook(h, HookClusterDestroyed, spec.Platform, spec.Name, arconf.OutputDir, ac)
+			for id, output := range ac.ConsoleOutput() {
+				for _, badness := range CheckConsole([]byte(output), t) {
+					h.Errorf("Found %s on machine %s console in cluster %s", badness, id, spec.Name)
+				}
 			}
-			userdata = userdata.Subst("$discovery", url)
+		})
+		h.Cleanup(func() { collectFailureLogs(h, ac, arconf.OutputDir) })
+		if usage != nil {
+			usage.watch(ac)
 		}
+		additional[spec.Name] = ac
+		clusterPlatforms[spec.Name] = spec.Platform
 
-		if _, err := platform.NewMachines(c, userdata, t.ClusterSize); err != nil {
-			h.Fatalf("Cluster failed starting machines: %v", err)
+		if spec.Size > 0 {
+			startMachines(h, ac, repeatUserData(spec.UserData, spec.Size), t.AddressFamily, arconf.OutputDir)
 		}
 	}
+	if len(clusterPlatforms) > 0 {
+		h.Annotate("additional_clusters", clusterPlatforms)
+	}
 
 	// pass along all registered native functions
 	var names []string
 	for k := range t.NativeFuncs {
 		names = append(names, k)
 	}
+	for k := range t.NativeCalls {
+		names = append(names, k)
+	}
 
 	// Cluster -> TestCluster
 	tcluster := cluster.TestCluster{
-		H:           h,
-		Cluster:     c,
-		NativeFuncs: names,
+		H:                  h,
+		Cluster:            c,
+		NativeFuncs:        names,
+		Stages:             stages,
+		Ordered:            ordered,
+		AdditionalClusters: additional,
+		ArtifactDir:        artifactDir,
+		EtcdVersion:        etcdTag,
+		Fetcher:            testFetcher(),
+		InfraFailure: func(err error) {
+			atomic.StoreInt32(&infraFailed, 1)
+		},
+		Scratch:        testScratch(layout.runID),
+		ResourceLeaked: rconf.MachineLeaked,
+		ReusedMachines: shared != nil,
+		Debugger:       testDebugger(),
+		Env:            t.Env,
+		Timeouts:       Timeouts,
+		Random:         cluster.NewRandom(seed),
+		DiscoveryURL:   discoveryURL,
+		AddressFamily:  t.AddressFamily,
 	}
 
 	// drop kolet binary on machines
-	if t.NativeFuncs != nil {
-		scpKolet(tcluster, architecture(pltfrm))
+	if t.NeedsKolet() {
+		tcluster.Kolet = scpKolet(tcluster, architecture(pltfrm))
 	}
 
 	defer func() {
@@ -435,10 +1355,308 @@ func runTest(h *harness.H, t *register.Test, pltfrm string) {
 		time.Sleep(2 * time.Second)
 	}()
 
+	atomic.StoreInt32(&setupDone, 1)
+	h.Annotate("setup_duration", time.Since(setupStart))
+
+	fireTestHooks(HookTestStarted)
+	defer fireTestHooks(HookTestFinished)
+
 	// run test
 	t.Run(tcluster)
 }
 
+// runEnvironment returns the settings of this host which may affect
+// results, for the report of a run.
+func runEnvironment() map[string]string {
+	env := make(map[string]string)
+	for k, v := range network.ProxySettings() {
+		env[k] = v
+	}
+	return env
+}
+
+var (
+	fetcher     *cluster.Fetcher
+	fetcherOnce sync.Once
+)
+
+var (
+	debugger     *cluster.Debugger
+	debuggerOnce sync.Once
+)
+
+// testDebugger returns the Debugger prompting on the terminal if
+// DebugInteractive is set, otherwise nil.
+func testDebugger() *cluster.Debugger {
+	if !DebugInteractive {
+		return nil
+	}
+	debuggerOnce.Do(func() {
+		debugger = cluster.NewDebugger(os.Stdin, os.Stdout)
+	})
+	return debugger
+}
+
+// testFetcher returns the Fetcher shared by all tests, caching in
+// CacheDir if it is set.
+func testFetcher() *cluster.Fetcher {
+	fetcherOnce.Do(func() {
+		var dir string
+		if CacheDir != "" {
+			dir = filepath.Join(CacheDir, "fetch")
+		}
+		fetcher = cluster.NewFetcher(dir)
+	})
+	return fetcher
+}
+
+// startMachines creates a machine in c for each of userdata, returned
+// in the same order, giving one etcd discovery URL to the configs which
+// ask for one. The URL is returned too, or "" if none asked. The state
+// of the discovery URL is saved to dir if the test fails. family is the
+// register.Test.AddressFamily.
+func startMachines(h *harness.H, c platform.Cluster, userdata []*conf.UserData, family, dir string) ([]platform.Machine, string) {
+	discovery := false
+	for _, ud := range userdata {
+		if ud != nil && ud.NeedsDiscovery() {
+			discovery = true
+		}
+	}
+	var url string
+	if discovery {
+		var err error
+		if url, err = c.GetDiscoveryURL(len(userdata)); err != nil {
+			// Skip instead of failing since the harness not being able to
+			// get a discovery url is likely an outage (e.g
+			// 503 Service Unavailable: Back-end server is at capacity)
+			// not a problem with the OS
+			h.Skipf("Failed to create discovery endpoint: %v", err)
+		}
+		captureDiscoveryOnFailure(h, c, url, len(userdata), dir)
+	}
+	substituted := make([]*conf.UserData, len(userdata))
+	for i, ud := range userdata {
+		substituted[i] = clusterUserData(h, ud, conf.TemplateVars{
+			DiscoveryURL:  url,
+			Index:         i,
+			ClusterSize:   len(userdata),
+			AddressFamily: family,
+		})
+	}
+	userdata = substituted
+
+	ms, err := platform.NewMachinesRetry(c, userdata, MachineBackoff)
+	if err != nil {
+		h.Fatalf("Cluster failed starting machines: %v", err)
+	}
+	return ms, url
+}
+
+// clusterUserData returns userdata with the variables of its cluster,
+// failing the test if userdata is a template which can't be executed,
+// before any machine is created with it.
+func clusterUserData(h *harness.H, userdata *conf.UserData, vars conf.TemplateVars) *conf.UserData {
+	if userdata == nil {
+		return nil
+	}
+	userdata, err := userdata.ForCluster(vars)
+	if err != nil {
+		h.Fatalf("Config of machine %d: %v", vars.Index, err)
+	}
+	return userdata
+}
+
+// repeatUserData returns n copies of userdata, one for each of n
+// machines.
+func repeatUserData(userdata *conf.UserData, n int) []*conf.UserData {
+	r := make([]*conf.UserData, n)
+	for i := range r {
+		r[i] = userdata
+	}
+	return r
+}
+
+// startStages boots each stage's machines in parallel, stage by stage,
+// waiting for a stage's ReadyCheck before starting the next. All stages
+// share one etcd discovery URL sized for every machine, which is
+// returned and whose state is saved to dir if the test fails.
+func startStages(h *harness.H, c platform.Cluster, userdata *conf.UserData, stages []register.BootStage, family, dir string) (map[string][]platform.Machine, string) {
+	total := 0
+	discovery := false
+	for _, s := range stages {
+		total += s.Size
+		if s.UserData != nil && s.UserData.NeedsDiscovery() {
+			discovery = true
+		}
+	}
+	if userdata != nil && userdata.NeedsDiscovery() {
+		discovery = true
+	}
+	var url string
+	if discovery {
+		var err error
+		if url, err = c.GetDiscoveryURL(total); err != nil {
+			// see startMachines
+			h.Skipf("Failed to create discovery endpoint: %v", err)
+		}
+		captureDiscoveryOnFailure(h, c, url, total, dir)
+	}
+
+	machines := make(map[string][]platform.Machine)
+	index := 0
+	for _, s := range stages {
+		ud := userdata
+		if s.UserData != nil {
+			ud = s.UserData
+		}
+		stageUserData := make([]*conf.UserData, s.Size)
+		for i := range stageUserData {
+			stageUserData[i] = clusterUserData(h, ud, conf.TemplateVars{
+				DiscoveryURL:  url,
+				Index:         index,
+				ClusterSize:   total,
+				AddressFamily: family,
+			})
+			index++
+		}
+
+		ms, err := platform.NewMachinesRetry(c, stageUserData, MachineBackoff)
+		if err != nil {
+			h.Fatalf("Cluster failed starting machines of stage %s: %v", s.Name, err)
+		}
+		machines[s.Name] = ms
+
+		if s.ReadyCheck == "" {
+			continue
+		}
+		timeout := s.ReadyTimeout
+		if timeout == 0 {
+			timeout = Timeouts.WithDefaults().Ready
+		}
+		for _, m := range ms {
+			var out, stderr []byte
+			var checkErr error
+			b := cluster.Budget(h, "ready check of stage "+s.Name, timeout)
+			err := util.WaitUntilReady(b.Timeout(), 5*time.Second, func() (bool, error) {
+				out, stderr, checkErr = m.SSH(s.ReadyCheck)
+				return checkErr == nil, nil
+			})
+			if err != nil {
+				h.Fatalf("Stage %s machine %s not ready: %v: %q: %v\nstdout: %s\nstderr: %s",
+					s.Name, m.ID(), b, s.ReadyCheck, checkErr, out, stderr)
+			}
+		}
+	}
+	return machines, url
+}
+
+// ConfigPath resolves the path to a local config file against ConfigDir.
+func ConfigPath(path string) string {
+	if ConfigDir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(ConfigDir, path)
+}
+
+// LoadUserData reads machine configuration from the local file path,
+// resolved by ConfigPath. The format is detected from the contents, and
+// the result is rendered and validated like any other UserData when a
+// machine is created.
+func LoadUserData(path string) (*conf.UserData, error) {
+	b, err := ioutil.ReadFile(ConfigPath(path))
+	if err != nil {
+		return nil, err
+	}
+	return conf.Unknown(string(b)), nil
+}
+
+// loadUserDataFiles returns tests with the UserDataFile of each test read
+// into its UserData. Tests with a UserDataFile are copied rather than
+// modified since the registered tests are shared.
+func loadUserDataFiles(tests map[string]*register.Test) (map[string]*register.Test, error) {
+	r := make(map[string]*register.Test, len(tests))
+	for name, t := range tests {
+		if t.UserDataFile != "" {
+			userdata, err := LoadUserData(t.UserDataFile)
+			if err != nil {
+				return nil, fmt.Errorf("test %v: %v", name, err)
+			}
+			loaded := *t
+			loaded.UserData = userdata
+			t = &loaded
+		}
+		r[name] = t
+	}
+	return r, nil
+}
+
+// checkUserDataFiles verifies that the local files referenced by tests
+// exist so that a missing file is reported before any cluster is created.
+func checkUserDataFiles(tests map[string]*register.Test) error {
+	for name, t := range tests {
+		if len(t.UserDataFiles) > 0 && t.UserData == nil && t.Intent == nil {
+			return fmt.Errorf("test %v has UserDataFiles but no UserData", name)
+		}
+		for _, local := range t.UserDataFiles {
+			if _, err := os.Stat(ConfigPath(local)); err != nil {
+				return fmt.Errorf("test %v: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// prepareUserData returns userdata, a config of t, with the contents of
+// t's UserDataFiles and, if etcdTag is set, its etcd version added.
+func prepareUserData(t *register.Test, userdata *conf.UserData, etcdTag string) (*conf.UserData, error) {
+	userdata, err := addUserDataFiles(t, userdata)
+	if err != nil {
+		return nil, err
+	}
+	if etcdTag != "" {
+		userdata = addEtcdVersion(userdata, etcdTag)
+	}
+	return userdata, nil
+}
+
+// addUserDataFiles returns userdata with the contents of t's
+// UserDataFiles added.
+func addUserDataFiles(t *register.Test, userdata *conf.UserData) (*conf.UserData, error) {
+	for remote, local := range t.UserDataFiles {
+		local = ConfigPath(local)
+		st, err := os.Stat(local)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := ioutil.ReadFile(local)
+		if err != nil {
+			return nil, err
+		}
+		userdata = userdata.AddFile(remote, string(contents), st.Mode().Perm())
+	}
+	return userdata, nil
+}
+
+// artifactLimits returns the run-wide artifact limits with the overrides
+// of t applied.
+func artifactLimits(t *register.Test) platform.ArtifactLimits {
+	limits := ArtifactLimits
+	if t.ArtifactLimits == nil {
+		return limits
+	}
+	override := func(limit *int64, v int64) {
+		if v < 0 {
+			*limit = 0
+		} else if v > 0 {
+			*limit = v
+		}
+	}
+	override(&limits.CommandOutput, t.ArtifactLimits.CommandOutput)
+	override(&limits.Journal, t.ArtifactLimits.Journal)
+	override(&limits.Console, t.ArtifactLimits.Console)
+	return limits
+}
+
 // architecture returns the machine architecture of the given platform.
 func architecture(pltfrm string) string {
 	nativeArch := "amd64"
@@ -456,23 +1674,17 @@ func boardToArch(board string) string {
 	return strings.SplitN(board, "-", 2)[0]
 }
 
-// scpKolet searches for a kolet binary and copies it to the machine.
-func scpKolet(c cluster.TestCluster, mArch string) {
-	for _, d := range []string{
-		".",
-		filepath.Dir(os.Args[0]),
-		filepath.Join(filepath.Dir(os.Args[0]), mArch),
-		filepath.Join("/usr/lib/kola", mArch),
-	} {
-		kolet := filepath.Join(d, "kolet")
-		if _, err := os.Stat(kolet); err == nil {
-			if err := c.DropFile(kolet); err != nil {
-				c.Fatalf("dropping kolet binary: %v", err)
-			}
-			return
-		}
+// scpKolet searches for a kolet binary and copies it to the machines,
+// returning its path.
+func scpKolet(c cluster.TestCluster, mArch string) string {
+	kolet, err := findKolet(mArch)
+	if err != nil {
+		c.Fatal(err)
+	}
+	if err := c.DropFile(kolet); err != nil {
+		c.Fatalf("dropping kolet binary: %v", err)
 	}
-	c.Fatalf("Unable to locate kolet binary for %s", mArch)
+	return kolet
 }
 
 // CheckConsole checks some console output for badness and returns short
@@ -497,9 +1709,13 @@ func CheckConsole(output []byte, t *register.Test) []string {
 	return ret
 }
 
+// DefaultOutputBaseDir is the directory SetupOutputDir creates the
+// output directory in if none is given.
+const DefaultOutputBaseDir = "_kola_temp"
+
 func SetupOutputDir(outputDir, platform string) (string, error) {
 	defaulted := outputDir == ""
-	defaultBaseDirName := "_kola_temp"
+	defaultBaseDirName := DefaultOutputBaseDir
 	defaultDirName := fmt.Sprintf("%s-%s-%d", platform, time.Now().Format("2006-01-02-1504"), os.Getpid())
 
 	if defaulted {
@@ -0,0 +1,206 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/register"
+)
+
+func TestFilterDestructiveHost(t *testing.T) {
+	tests := map[string]*register.Test{
+		"misc.safe":             {Name: "misc.safe"},
+		"misc.nested":           {Name: "misc.nested", DestructiveHost: true},
+		"other.nested":          {Name: "other.nested", DestructiveHost: true, Platforms: []string{"aws"}},
+		"misc.nested.namespace": {Name: "misc.nested.namespace", DestructiveHost: true},
+	}
+
+	for _, tc := range []struct {
+		pattern string
+		include bool
+		expect  []string
+		err     bool
+	}{
+		{"*", false, []string{"misc.safe"}, false},
+		{"misc.*", false, []string{"misc.safe"}, false},
+		{"*.nested", false, []string{}, false},
+		{"misc.nested", false, nil, true},
+		{"*", true, []string{"misc.nested", "misc.nested.namespace", "misc.safe"}, false},
+		{"*.nested", true, []string{"misc.nested"}, false},
+		{"misc.nested", true, []string{"misc.nested"}, false},
+		{"other.nested", true, []string{}, false},
+	} {
+		IncludeHostDestructive = tc.include
+		sel, err := NewSelection([]string{tc.pattern}, nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		selected, err := filterTests(tests, sel, "qemu", semver.Version{})
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q (include %v): expected error", tc.pattern, tc.include)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q (include %v): %v", tc.pattern, tc.include, err)
+			continue
+		}
+		names := []string{}
+		for name := range selected {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, tc.expect) {
+			t.Errorf("%q (include %v): expected %v, got %v", tc.pattern, tc.include, tc.expect, names)
+		}
+	}
+	IncludeHostDestructive = false
+}
+
+func TestLoadUserDataFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "cloud-config.yml"), []byte("#cloud-config\nhostname: kola\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(old string) { ConfigDir = old }(ConfigDir)
+	ConfigDir = dir
+
+	registered := &register.Test{Name: "misc.file", UserDataFile: "cloud-config.yml"}
+	tests, err := loadUserDataFiles(map[string]*register.Test{"misc.file": registered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded := tests["misc.file"]; loaded.UserData == nil || !loaded.UserData.Contains("hostname: kola") {
+		t.Errorf("UserData not loaded from file: %v", loaded.UserData)
+	}
+	if registered.UserData != nil {
+		t.Errorf("registered test was modified")
+	}
+
+	_, err = loadUserDataFiles(map[string]*register.Test{
+		"misc.missing": {Name: "misc.missing", UserDataFile: "missing.yml"},
+	})
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "missing.yml")) {
+		t.Errorf("expected error naming the resolved path, got %v", err)
+	}
+}
+
+func TestTestTimeout(t *testing.T) {
+	defer func(d time.Duration) { Timeouts.Test = d }(Timeouts.Test)
+
+	Timeouts.Test = 0
+	if d := testTimeout(&register.Test{}); d != cluster.DefaultTimeouts.Test {
+		t.Errorf("default timeout %v, want %v", d, cluster.DefaultTimeouts.Test)
+	}
+	Timeouts.Test = time.Hour
+	if d := testTimeout(&register.Test{}); d != time.Hour {
+		t.Errorf("timeout %v, want --test-timeout %v", d, time.Hour)
+	}
+	if d := testTimeout(&register.Test{Timeout: time.Minute}); d != time.Minute {
+		t.Errorf("timeout %v, want the test's %v", d, time.Minute)
+	}
+}
+
+// registerTests registers tests until t completes.
+func registerTests(t *testing.T, tests ...*register.Test) {
+	for _, test := range tests {
+		register.Register(test)
+		name := test.Name
+		t.Cleanup(func() { register.Unregister(name) })
+	}
+}
+
+func TestExpandTestsRepeatable(t *testing.T) {
+	registerTests(t,
+		&register.Test{Name: "kola.expand.qemu", Platforms: []string{"qemu"}},
+		&register.Test{Name: "kola.expand.any", ExcludePlatforms: []string{"gce"}})
+
+	dir, err := ioutil.TempDir("", "kola-expand-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := map[string][]string{
+		"kola.expand.qemu": {"qemu"},
+		"kola.expand.any":  {"qemu", "aws"},
+	}
+	sel, err := NewSelection([]string{"kola.expand.*"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		tests, platforms, _, err := expandTests(sel, []string{"qemu", "gce", "aws"}, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(platforms, want) {
+			t.Errorf("expansion %d: platforms %v, want %v", i+1, platforms, want)
+		}
+
+		// a run may change its tests without affecting the next
+		for _, test := range tests {
+			test.Platforms = append(test.Platforms, "gce")
+			test.ExcludePlatforms = nil
+			runCopy(test).Architectures = []string{"arm64"}
+		}
+	}
+}
+
+func TestRunCopy(t *testing.T) {
+	test := &register.Test{Name: "kola.copy", Platforms: []string{"qemu"}}
+	c := runCopy(test)
+	if c.Timeout != cluster.DefaultTimeouts.Test {
+		t.Errorf("run timeout %v, want the default %v", c.Timeout, cluster.DefaultTimeouts.Test)
+	}
+	c.Platforms[0] = "gce"
+	if test.Timeout != 0 || test.Platforms[0] != "qemu" {
+		t.Errorf("run copy shares state with the test: %+v", test)
+	}
+}
+
+func TestTestSeed(t *testing.T) {
+	seed := testSeed(42, "cl.basic", "qemu", 1)
+	if testSeed(42, "cl.basic", "qemu", 1) != seed {
+		t.Error("the same attempt got a different seed")
+	}
+	for _, other := range []int64{
+		testSeed(43, "cl.basic", "qemu", 1),
+		testSeed(42, "cl.basic2", "qemu", 1),
+		testSeed(42, "cl.basic", "gce", 1),
+		testSeed(42, "cl.basic", "qemu", 2),
+	} {
+		if other == seed {
+			t.Errorf("different attempts got the same seed %d", seed)
+		}
+	}
+}
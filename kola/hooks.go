@@ -0,0 +1,155 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/platform"
+)
+
+// HookSchemaVersion is the version of the HookEvent schema, which is
+// documented with the Reporter interface of harness/reporters. It is
+// incremented whenever a field changes meaning or is removed; new
+// fields may be added without changing it.
+const HookSchemaVersion = 1
+
+const hookExecTimeout = time.Minute
+
+// Hook events, in the order they occur for each test.
+const (
+	HookClusterCreated   = "cluster-created"   // a cluster exists but has no machines yet
+	HookTestStarted      = "test-started"      // machines are up and the test function is about to run
+	HookTestFinished     = "test-finished"     // the test function returned; Result is set
+	HookClusterDestroyed = "cluster-destroyed" // the cluster and its machines are gone
+)
+
+// HookEvent is passed to hooks. Hook executables receive it as JSON on
+// stdin.
+type HookEvent struct {
+	SchemaVersion int           `json:"schema_version"`
+	Event         string        `json:"event"`
+	Time          time.Time     `json:"time"`
+	Test          string        `json:"test"` // empty for a shared cluster destroyed after its tests
+	Platform      string        `json:"platform"`
+	Cluster       string        `json:"cluster"`    // "primary" or the name of an additional cluster
+	OutputDir     string        `json:"output_dir"` // unique per cluster
+	Machines      []HookMachine `json:"machines,omitempty"`
+	Result        string        `json:"result,omitempty"` // PASS, FAIL or SKIP for test-finished
+}
+
+// HookMachine describes a machine in a HookEvent.
+type HookMachine struct {
+	ID        string `json:"id"`
+	IP        string `json:"ip"`
+	PrivateIP string `json:"private_ip"`
+}
+
+// Hook is called for each event of each test. Hooks may be called
+// concurrently when tests run in parallel.
+type Hook func(HookEvent) error
+
+var (
+	// Hooks are called in order for every event.
+	Hooks []Hook
+
+	// HookExec, if set, is an executable run for every event after Hooks.
+	HookExec string
+
+	// StrictHooks fails the test when a hook fails instead of only
+	// logging the failure.
+	StrictHooks bool
+)
+
+// execHook runs HookExec with ev on stdin.
+func execHook(ev HookEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookExecTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, HookExec)
+	cmd.Stdin = bytes.NewReader(b)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", HookExec, err, out)
+	}
+	return nil
+}
+
+// fireHook delivers an event about cluster c, whose output is written to
+// outputDir, to every hook. h is the test the event belongs to, or nil for
+// a shared cluster destroyed after its tests completed.
+func fireHook(h *harness.H, event, pltfrm, name, outputDir string, c platform.Cluster) {
+	if len(Hooks) == 0 && HookExec == "" {
+		return
+	}
+
+	ev := HookEvent{
+		SchemaVersion: HookSchemaVersion,
+		Event:         event,
+		Time:          time.Now().UTC(),
+		Platform:      pltfrm,
+		Cluster:       name,
+		OutputDir:     outputDir,
+	}
+	if h != nil {
+		ev.Test = h.Name()
+	}
+	if event != HookClusterDestroyed {
+		for _, m := range c.Machines() {
+			ev.Machines = append(ev.Machines, HookMachine{
+				ID:        m.ID(),
+				IP:        m.IP(),
+				PrivateIP: m.PrivateIP(),
+			})
+		}
+	}
+	if event == HookTestFinished {
+		switch {
+		case h.Skipped():
+			ev.Result = "SKIP"
+		case h.Failed():
+			ev.Result = "FAIL"
+		default:
+			ev.Result = "PASS"
+		}
+	}
+
+	hooks := Hooks
+	if HookExec != "" {
+		hooks = append(hooks[:len(hooks):len(hooks)], execHook)
+	}
+	for _, hook := range hooks {
+		if err := hook(ev); err != nil {
+			switch {
+			case h == nil:
+				plog.Errorf("%s hook for the cluster in %s failed: %v", event, outputDir, err)
+			case StrictHooks:
+				h.Errorf("%s hook failed: %v", event, err)
+			default:
+				plog.Errorf("%s hook for %s failed: %v", event, ev.Test, err)
+			}
+		}
+	}
+}
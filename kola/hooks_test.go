@@ -0,0 +1,163 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/platform"
+)
+
+type hookMachine struct {
+	platform.Machine
+}
+
+func (hookMachine) ID() string        { return "m1" }
+func (hookMachine) IP() string        { return "10.0.0.2" }
+func (hookMachine) PrivateIP() string { return "10.1.0.2" }
+
+type hookCluster struct {
+	platform.Cluster
+}
+
+func (hookCluster) Machines() []platform.Machine { return []platform.Machine{hookMachine{}} }
+
+// runHooked runs f as a test named "hooked" with hooks and StrictHooks
+// set, returning the error of the run.
+func runHooked(t *testing.T, hooks []Hook, strict bool, f func(h *harness.H)) error {
+	defer func(hooks []Hook, strict bool) { Hooks, StrictHooks = hooks, strict }(Hooks, StrictHooks)
+	Hooks, StrictHooks = hooks, strict
+
+	dir, err := ioutil.TempDir("", "kola-hooks-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tests harness.Tests
+	tests.Add("hooked", f)
+	return harness.NewSuite(harness.Options{OutputDir: filepath.Join(dir, "out")}, tests).Run()
+}
+
+func TestFireHook(t *testing.T) {
+	var events []HookEvent
+	record := func(ev HookEvent) error {
+		events = append(events, ev)
+		return nil
+	}
+	err := runHooked(t, []Hook{record, record}, false, func(h *harness.H) {
+		fireHook(h, HookClusterCreated, "qemu", "primary", "/out", hookCluster{})
+		fireHook(h, HookTestFinished, "qemu", "primary", "/out", hookCluster{})
+		fireHook(h, HookClusterDestroyed, "qemu", "primary", "/out", hookCluster{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fireHook(nil, HookClusterDestroyed, "qemu", "primary", "/out", hookCluster{})
+
+	if len(events) != 6 {
+		t.Fatalf("%d events delivered to two hooks, want 6", len(events))
+	}
+	machines := []HookMachine{{ID: "m1", IP: "10.0.0.2", PrivateIP: "10.1.0.2"}}
+	for i, want := range []HookEvent{
+		{Event: HookClusterCreated, Test: "hooked", Machines: machines},
+		{Event: HookTestFinished, Test: "hooked", Machines: machines, Result: "PASS"},
+		{Event: HookClusterDestroyed, Test: "hooked"},
+	} {
+		want.SchemaVersion = HookSchemaVersion
+		want.Platform, want.Cluster, want.OutputDir = "qemu", "primary", "/out"
+		for _, got := range events[2*i : 2*i+2] {
+			if got.Time.IsZero() || got.Time.Location() != time.UTC {
+				t.Errorf("%s: time %v", got.Event, got.Time)
+			}
+			got.Time = time.Time{}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("delivered %+v, want %+v", got, want)
+			}
+		}
+	}
+}
+
+func TestFireHookFailure(t *testing.T) {
+	fail := func(HookEvent) error { return errors.New("boom") }
+	test := func(h *harness.H) {
+		fireHook(h, HookTestStarted, "qemu", "primary", "/out", hookCluster{})
+	}
+	if err := runHooked(t, []Hook{fail}, false, test); err != nil {
+		t.Errorf("failed hook failed the test without --strict-hooks: %v", err)
+	}
+	if err := runHooked(t, []Hook{fail}, true, test); err == nil {
+		t.Error("failed hook didn't fail the test with --strict-hooks")
+	}
+
+	// nor without a test to fail
+	defer func(hooks []Hook, strict bool) { Hooks, StrictHooks = hooks, strict }(Hooks, StrictHooks)
+	Hooks, StrictHooks = []Hook{fail}, true
+	fireHook(nil, HookClusterDestroyed, "qemu", "primary", "/out", hookCluster{})
+}
+
+func TestExecHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-hook-exec-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(exec string) { HookExec = exec }(HookExec)
+
+	events := filepath.Join(dir, "events")
+	HookExec = filepath.Join(dir, "hook")
+	script := "#!/bin/sh\ncat >>" + events + "\necho >>" + events + "\n"
+	if err := ioutil.WriteFile(HookExec, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ev := HookEvent{
+		SchemaVersion: HookSchemaVersion,
+		Event:         HookTestFinished,
+		Time:          time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC),
+		Test:          "hooked",
+		Result:        "FAIL",
+	}
+	if err := execHook(ev); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got HookEvent
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("hook received %q: %v", b, err)
+	}
+	if !reflect.DeepEqual(got, ev) {
+		t.Errorf("hook received %+v, want %+v", got, ev)
+	}
+
+	// with the executable's output in the error
+	if err := ioutil.WriteFile(HookExec, []byte("#!/bin/sh\necho rejected\nexit 3\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := execHook(ev); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("failing executable returned %v", err)
+	}
+}
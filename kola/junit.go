@@ -0,0 +1,212 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+// junitSuites is the document of a JUnit XML report, in the form
+// understood by Jenkins.
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+
+	duration time.Duration
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// junitReporter writes a JUnit XML report with a test case for each run
+// of a test on a platform, as named by the "platform" annotation which
+// formatRunner adds. The report is rewritten as each run finishes, so
+// that it holds the runs completed so far if kola is interrupted.
+type junitReporter struct {
+	path string
+
+	mu     sync.Mutex
+	cases  []junitTestCase
+	warned bool // about failing to write the report
+}
+
+func newJUnitReporter(path string) *junitReporter {
+	return &junitReporter{path: path}
+}
+
+// junitCase returns the test case of a run of a test.
+func junitCase(name string, result testresult.TestResult, duration time.Duration, b []byte, annotations map[string]interface{}) (junitTestCase, bool) {
+	pltfrm, _ := annotations["platform"].(string)
+	if pltfrm == "" {
+		return junitTestCase{}, false
+	}
+	// with several platforms, each is a subtest of the test
+	name = strings.Replace("/"+name+"/", "/"+pltfrm+"/", "/", 1)
+	name = strings.Trim(name, "/")
+
+	tc := junitTestCase{
+		Name:      name,
+		ClassName: "kola." + pltfrm,
+		Time:      junitSeconds(duration),
+		SystemOut: string(b),
+		duration:  duration,
+	}
+	experimental, _ := annotations["experimental"].(bool)
+	switch result {
+	case testresult.Fail:
+		if experimental {
+			// experimental failures don't fail the run
+			tc.Skipped = &junitMessage{Message: "failed on experimental platform " + pltfrm}
+			break
+		}
+		message := "Test failed"
+		category, _ := annotations["failure_category"].(string)
+		for _, c := range failureCategories {
+			if c.category == category {
+				message = c.desc
+			}
+		}
+		tc.Failure = &junitMessage{Message: message, Body: string(b)}
+		tc.SystemOut = ""
+	case testresult.Skip:
+		tc.Skipped = &junitMessage{Message: lastLine(b)}
+	}
+	return tc, true
+}
+
+// lastLine returns the last non-empty line of output, which for a
+// skipped test is the reason.
+func lastLine(b []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// Skip adds a test case for test, which is not run on pltfrm, skipped
+// for reason.
+func (r *junitReporter) Skip(test, pltfrm, reason string) {
+	r.add(junitTestCase{
+		Name:      test,
+		ClassName: "kola." + pltfrm,
+		Time:      junitSeconds(0),
+		Skipped:   &junitMessage{Message: reason},
+	})
+}
+
+func (r *junitReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, annotations map[string]interface{}) {
+	if tc, ok := junitCase(name, result, duration, b, annotations); ok {
+		r.add(tc)
+	}
+}
+
+func (r *junitReporter) add(tc junitTestCase) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cases = append(r.cases, tc)
+	if err := r.write(); err != nil && !r.warned {
+		plog.Warningf("Writing JUnit report: %v", err)
+		r.warned = true
+	}
+}
+
+// write replaces the report with one holding the test cases so far. The
+// new report is renamed into place so that the file is always complete.
+func (r *junitReporter) write() error {
+	suite := junitSuite{
+		Name:  "kola",
+		Tests: len(r.cases),
+		Cases: r.cases,
+	}
+	var total time.Duration
+	for _, tc := range r.cases {
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Skipped != nil {
+			suite.Skipped++
+		}
+		total += tc.duration
+	}
+	suite.Time = junitSeconds(total)
+
+	buf, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	buf = append([]byte(xml.Header), buf...)
+	buf = append(buf, '\n')
+
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), "."+filepath.Base(r.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// Output writes the final report, even if no test ran. The report is
+// written to its own path rather than to the output directory path.
+func (r *junitReporter) Output(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.write(); err != nil {
+		return fmt.Errorf("writing JUnit report: %v", err)
+	}
+	return nil
+}
+
+func (r *junitReporter) SetResult(result testresult.TestResult) {}
@@ -0,0 +1,131 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+func TestJUnitCase(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		result      testresult.TestResult
+		annotations map[string]interface{}
+		caseName    string
+		failure     string
+		skipped     string
+	}{
+		{"coreos.basic", testresult.Pass, map[string]interface{}{"platform": "qemu"}, "coreos.basic", "", ""},
+		{"coreos.basic/gce", testresult.Pass, map[string]interface{}{"platform": "gce"}, "coreos.basic", "", ""},
+		{"coreos.basic/gce/ignition", testresult.Pass, map[string]interface{}{"platform": "gce"}, "coreos.basic/ignition", "", ""},
+		{"coreos.basic", testresult.Fail, map[string]interface{}{"platform": "qemu", "failure_category": FailureSetup}, "coreos.basic", "Cluster setup failed", ""},
+		{"coreos.basic", testresult.Fail, map[string]interface{}{"platform": "qemu"}, "coreos.basic", "Test failed", ""},
+		{"coreos.basic/packet", testresult.Fail, map[string]interface{}{"platform": "packet", "experimental": true}, "coreos.basic", "", "failed on experimental platform packet"},
+		{"coreos.basic", testresult.Skip, map[string]interface{}{"platform": "qemu"}, "coreos.basic", "", "skipped for a reason"},
+	} {
+		tc, ok := junitCase(tt.name, tt.result, time.Second, []byte("log line\nskipped for a reason\n"), tt.annotations)
+		if !ok {
+			t.Errorf("%s: no test case", tt.name)
+			continue
+		}
+		if tc.Name != tt.caseName {
+			t.Errorf("%s: test case named %q, want %q", tt.name, tc.Name, tt.caseName)
+		}
+		if tc.ClassName != "kola."+tt.annotations["platform"].(string) {
+			t.Errorf("%s: class %q", tt.name, tc.ClassName)
+		}
+		if tc.Time != "1.000" {
+			t.Errorf("%s: time %q, want 1.000", tt.name, tc.Time)
+		}
+		switch {
+		case tt.failure != "" && (tc.Failure == nil || tc.Failure.Message != tt.failure):
+			t.Errorf("%s: failure %+v, want %q", tt.name, tc.Failure, tt.failure)
+		case tt.failure == "" && tc.Failure != nil:
+			t.Errorf("%s: unexpected failure %+v", tt.name, tc.Failure)
+		}
+		switch {
+		case tt.skipped != "" && (tc.Skipped == nil || tc.Skipped.Message != tt.skipped):
+			t.Errorf("%s: skipped %+v, want %q", tt.name, tc.Skipped, tt.skipped)
+		case tt.skipped == "" && tc.Skipped != nil:
+			t.Errorf("%s: unexpectedly skipped %+v", tt.name, tc.Skipped)
+		}
+	}
+
+	// subtests of a test and tests grouping platforms aren't runs
+	if _, ok := junitCase("coreos.basic/subtest", testresult.Pass, 0, nil, nil); ok {
+		t.Error("test case for a result without a platform")
+	}
+}
+
+func TestJUnitReporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-junit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "junit.xml")
+
+	read := func() junitSuite {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc junitSuites
+		if err := xml.Unmarshal(buf, &doc); err != nil {
+			t.Fatalf("invalid report: %v\n%s", err, buf)
+		}
+		if len(doc.Suites) != 1 {
+			t.Fatalf("%d test suites, want 1", len(doc.Suites))
+		}
+		return doc.Suites[0]
+	}
+
+	r := newJUnitReporter(path)
+	r.Skip("coreos.gce.only", "qemu", "not supported on qemu")
+	// the report is complete after each result
+	if s := read(); s.Tests != 1 || s.Skipped != 1 {
+		t.Errorf("after a skipped test: %+v", s)
+	}
+
+	r.ReportTest("coreos.basic", testresult.Pass, time.Second, []byte("passing"), map[string]interface{}{"platform": "qemu"})
+	r.ReportTest("coreos.basic/subtest", testresult.Fail, time.Second, []byte("failing"), nil)
+	r.ReportTest("coreos.broken", testresult.Fail, 2*time.Second, []byte("console: \x1b[0m<&>"), map[string]interface{}{"platform": "qemu"})
+	if s := read(); s.Tests != 3 || s.Failures != 1 || s.Skipped != 1 || s.Time != "3.000" {
+		t.Errorf("after the run: %+v", s)
+	}
+
+	if err := r.Output(dir); err != nil {
+		t.Fatal(err)
+	}
+	s := read()
+	if len(s.Cases) != 3 {
+		t.Fatalf("%d test cases, want 3", len(s.Cases))
+	}
+	if f := s.Cases[2].Failure; f == nil || f.Body != "console: �[0m<&>" {
+		t.Errorf("failure %+v not escaped", f)
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, ".junit.xml*"))
+	if err != nil || len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v %v", leftovers, err)
+	}
+}
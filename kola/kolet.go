@@ -0,0 +1,256 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/version"
+)
+
+var (
+	// AllowKoletSkew permits running native functions with a kolet
+	// built from a different commit than kola.
+	AllowKoletSkew bool
+
+	// KoletPath, if not "", is the kolet binary to copy to machines
+	// instead of one found next to kola or in $PATH.
+	KoletPath string
+
+	// KoletCommit is the commit of the kolet binary used by the run, once
+	// known. It is empty if no selected test needs kolet.
+	KoletCommit string
+)
+
+// koletMachines are the ELF machine types of kolet for each machine
+// architecture.
+var koletMachines = map[string]elf.Machine{
+	"amd64": elf.EM_X86_64,
+	"arm64": elf.EM_AARCH64,
+}
+
+// findKolet returns the path of the kolet binary for arch: KoletPath if
+// set, or else the first kolet built for arch in kola's directory, its
+// arch subdirectory as laid out by ./build, $PATH or /usr/lib/kola/arch.
+func findKolet(arch string) (string, error) {
+	if KoletPath != "" {
+		if err := checkKoletArch(KoletPath, arch); err != nil {
+			return "", fmt.Errorf("--kolet: %v", err)
+		}
+		return KoletPath, nil
+	}
+
+	var candidates []string
+	if exe, err := os.Executable(); err == nil {
+		if exe, err = filepath.EvalSymlinks(exe); err == nil {
+			dir := filepath.Dir(exe)
+			candidates = append(candidates,
+				filepath.Join(dir, "kolet"),
+				filepath.Join(dir, arch, "kolet"))
+		}
+	}
+	if kolet, err := exec.LookPath("kolet"); err == nil {
+		candidates = append(candidates, kolet)
+	}
+	candidates = append(candidates, filepath.Join("/usr/lib/kola", arch, "kolet"))
+
+	var skipped []string
+	for _, kolet := range candidates {
+		if _, err := os.Stat(kolet); err != nil {
+			continue
+		}
+		if err := checkKoletArch(kolet, arch); err != nil {
+			skipped = append(skipped, err.Error())
+			continue
+		}
+		return kolet, nil
+	}
+	msg := fmt.Sprintf("no kolet binary for linux/%s next to kola, in $PATH or in /usr/lib/kola/%s; build it with ./build kolet or pass --kolet", arch, arch)
+	if len(skipped) > 0 {
+		msg += fmt.Sprintf(" (skipped %s)", strings.Join(skipped, "; "))
+	}
+	return "", errors.New(msg)
+}
+
+// checkKoletArch returns an error unless kolet is a Linux binary for
+// arch, so that a kolet built for the host, e.g. on macOS, is caught
+// before it fails on the machines with an exec format error.
+func checkKoletArch(kolet, arch string) error {
+	want, ok := koletMachines[arch]
+	if !ok {
+		return fmt.Errorf("no kolet for architecture %s", arch)
+	}
+	f, err := elf.Open(kolet)
+	if err != nil {
+		return fmt.Errorf("%s is not a Linux binary: %v", kolet, err)
+	}
+	defer f.Close()
+	if f.OSABI != elf.ELFOSABI_NONE && f.OSABI != elf.ELFOSABI_LINUX {
+		return fmt.Errorf("%s is built for %v, not Linux", kolet, f.OSABI)
+	}
+	if f.Machine != want {
+		return fmt.Errorf("%s is built for %v, not %s", kolet, f.Machine, arch)
+	}
+	return nil
+}
+
+// commitSymbol is the variable ./build sets to the commit with -ldflags.
+const commitSymbol = "github.com/coreos/mantle/version.Commit"
+
+// koletCommit returns the commit kolet was built from according to
+// `kolet version`. If kolet cannot run on this host, e.g. because it is
+// built for another architecture, the commit is read from the binary.
+func koletCommit(kolet string) (string, error) {
+	out, err := exec.Command(kolet, "version").CombinedOutput()
+	if err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for scanner.Scan() {
+			if commit := strings.TrimPrefix(scanner.Text(), "commit "); commit != scanner.Text() {
+				return commit, nil
+			}
+		}
+		return "", nil
+	}
+
+	plog.Debugf("running %s version failed, reading binary: %v", kolet, err)
+	f, err := elf.Open(kolet)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	commit, err := elfString(f, commitSymbol)
+	if err != nil {
+		// e.g. a stripped binary
+		plog.Debugf("reading commit of %s: %v", kolet, err)
+		return "", nil
+	}
+	return commit, nil
+}
+
+// elfString returns the value of the Go string variable named symbol in
+// f, as set at link time.
+func elfString(f *elf.File, symbol string) (string, error) {
+	syms, err := f.Symbols()
+	if err != nil {
+		return "", err
+	}
+	var sym *elf.Symbol
+	for i := range syms {
+		if syms[i].Name == symbol {
+			sym = &syms[i]
+			break
+		}
+	}
+	if sym == nil {
+		return "", fmt.Errorf("no symbol %s", symbol)
+	}
+
+	// the variable is a string header: a pointer and a length
+	ptrSize := 8
+	if f.Class == elf.ELFCLASS32 {
+		ptrSize = 4
+	}
+	header, err := readELF(f, sym.Value, uint64(2*ptrSize))
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %v", symbol, err)
+	}
+	word := func(b []byte) uint64 {
+		if ptrSize == 4 {
+			return uint64(f.ByteOrder.Uint32(b))
+		}
+		return f.ByteOrder.Uint64(b)
+	}
+	ptr, length := word(header[:ptrSize]), word(header[ptrSize:])
+	if length == 0 {
+		return "", nil
+	}
+	s, err := readELF(f, ptr, length)
+	if err != nil {
+		return "", fmt.Errorf("reading value of %s: %v", symbol, err)
+	}
+	return string(s), nil
+}
+
+// readELF reads size bytes at the virtual address addr of f.
+func readELF(f *elf.File, addr, size uint64) ([]byte, error) {
+	for _, s := range f.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 || addr < s.Addr || addr+size > s.Addr+s.Size {
+			continue
+		}
+		b := make([]byte, size)
+		if s.Type == elf.SHT_NOBITS {
+			// zeroed at startup, as an unset variable is
+			return b, nil
+		}
+		if _, err := s.ReadAt(b, int64(addr-s.Addr)); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("address %#x not in any section", addr)
+}
+
+// checkKoletSkew verifies that the kolet used for tests with native
+// functions or the native library was built from the same commit as kola, since a stale kolet
+// fails in confusing ways.
+func checkKoletSkew(tests map[string]*register.Test, pltfrm string) error {
+	needed := false
+	for _, t := range tests {
+		if t.NeedsKolet() {
+			needed = true
+			break
+		}
+	}
+	if !needed {
+		return nil
+	}
+
+	kolet, err := findKolet(architecture(pltfrm))
+	if err != nil {
+		return err
+	}
+	commit, err := koletCommit(kolet)
+	if err != nil {
+		return fmt.Errorf("checking commit of %s: %v", kolet, err)
+	}
+	KoletCommit = commit
+
+	if version.Commit == "" {
+		plog.Warningf("kola was built without a commit, not checking %s", kolet)
+		return nil
+	}
+	if commit == version.Commit {
+		return nil
+	}
+
+	if commit == "" {
+		commit = "an unknown commit"
+	}
+	msg := fmt.Sprintf("%s was built from %s but kola from %s", kolet, commit, version.Commit)
+	if AllowKoletSkew {
+		plog.Warning(msg)
+		return nil
+	}
+	return fmt.Errorf("%s; rebuild it or pass --allow-kolet-skew", msg)
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFindKoletFlag(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the test binary isn't a Linux binary")
+	}
+	if _, ok := koletMachines[runtime.GOARCH]; !ok {
+		t.Skipf("kolet isn't built for %s", runtime.GOARCH)
+	}
+	defer func(path string) { KoletPath = path }(KoletPath)
+
+	// the test binary stands in for kolet
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	KoletPath = exe
+	if kolet, err := findKolet(runtime.GOARCH); err != nil || kolet != exe {
+		t.Errorf("got %q, %v; want %q", kolet, err, exe)
+	}
+
+	other := "arm64"
+	if runtime.GOARCH == "arm64" {
+		other = "amd64"
+	}
+	if _, err := findKolet(other); err == nil || !strings.Contains(err.Error(), "not "+other) {
+		t.Errorf("got %v, want an architecture mismatch", err)
+	}
+
+	f, err := ioutil.TempFile("", "kolet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("\xcf\xfa\xed\xfe") // a Mach-O header
+	f.Close()
+	KoletPath = f.Name()
+	if _, err := findKolet(runtime.GOARCH); err == nil || !strings.Contains(err.Error(), "not a Linux binary") {
+		t.Errorf("got %v, want a format error", err)
+	}
+}
+
+func TestELFCommit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a program")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command to build with")
+	}
+	dir, err := ioutil.TempDir("", "kolet-commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a program for another architecture, so that it can't run here
+	src := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(src, []byte(`package main
+
+import "github.com/coreos/mantle/version"
+
+func main() { println(version.Commit) }
+`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	build := func(name string, ldflags ...string) string {
+		out := filepath.Join(dir, name)
+		cmd := exec.Command("go", "build", "-o", out, "-ldflags", strings.Join(ldflags, " "), src)
+		cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=arm64", "CGO_ENABLED=0")
+		if b, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("building %s: %v: %s", name, err, b)
+		}
+		return out
+	}
+	for _, tt := range []struct {
+		name    string
+		ldflags []string
+		commit  string
+		err     bool
+	}{
+		{"committed", []string{"-X", commitSymbol + "=0123abc"}, "0123abc", false},
+		{"uncommitted", nil, "", false},
+		{"stripped", []string{"-s", "-w", "-X", commitSymbol + "=0123abc"}, "", true},
+	} {
+		f, err := elf.Open(build(tt.name, tt.ldflags...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit, err := elfString(f, commitSymbol)
+		f.Close()
+		if commit != tt.commit || (err != nil) != tt.err {
+			t.Errorf("%s: got %q, %v; want %q", tt.name, commit, err, tt.commit)
+		}
+	}
+}
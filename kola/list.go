@@ -0,0 +1,138 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/mantle/kola/register"
+)
+
+// ListEntry describes a registered test for kola list.
+type ListEntry struct {
+	Name             string            `json:"name"`
+	Platforms        []string          `json:"platforms,omitempty"`
+	ExcludePlatforms []string          `json:"exclude_platforms,omitempty"`
+	Architectures    []string          `json:"architectures,omitempty"`
+	UserDataFile     string            `json:"userdata_file,omitempty"`
+	UserDataFiles    map[string]string `json:"userdata_files,omitempty"`
+	DestructiveHost  bool              `json:"destructive_host,omitempty"`
+	Tags             []string          `json:"tags,omitempty"`
+
+	// RunsOn lists the platforms, of those given to List, the test
+	// runs on.
+	RunsOn []string `json:"runs_on"`
+	// ClusterSize is the number of machines the test boots in its
+	// primary cluster, over all of its BootStages.
+	ClusterSize int `json:"cluster_size"`
+	// NativeFuncs names the test's native functions, including its
+	// NativeCalls.
+	NativeFuncs []string `json:"native_funcs,omitempty"`
+	// RequiredPorts are the TCP ports the test connects to directly.
+	RequiredPorts []int `json:"required_ports,omitempty"`
+	// Firewall describes, by platform, what is done to let the test
+	// reach its RequiredPorts on the platforms it runs on, including
+	// those of its additional clusters.
+	Firewall map[string]string `json:"firewall,omitempty"`
+}
+
+// List returns the tests selected by sel which run on any of pltfrms,
+// sorted by name. They are selected like RunTests selects them, except
+// that OS version restrictions are not checked since that needs a
+// machine.
+func List(sel *Selection, pltfrms []string) ([]ListEntry, error) {
+	selected := make(map[string]*register.Test)
+	runsOn := make(map[string][]string)
+	for _, pltfrm := range pltfrms {
+		tests, err := filterTests(register.All(), sel, pltfrm, semver.Version{})
+		if err != nil {
+			return nil, err
+		}
+		for name, t := range tests {
+			selected[name] = t
+			runsOn[name] = append(runsOn[name], pltfrm)
+		}
+	}
+
+	var entries []ListEntry
+	for name, t := range selected {
+		entries = append(entries, listEntry(t, runsOn[name]))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+func listEntry(t *register.Test, runsOn []string) ListEntry {
+	e := ListEntry{
+		Name:             t.Name,
+		Platforms:        t.Platforms,
+		ExcludePlatforms: t.ExcludePlatforms,
+		Architectures:    t.Architectures,
+		UserDataFiles:    t.UserDataFiles,
+		DestructiveHost:  t.DestructiveHost,
+		Tags:             t.Tags,
+		RunsOn:           runsOn,
+		ClusterSize:      t.ClusterSize,
+		RequiredPorts:    t.RequiredPorts,
+	}
+	if t.UserDataFile != "" {
+		e.UserDataFile = ConfigPath(t.UserDataFile)
+	}
+	for _, s := range t.BootStages {
+		e.ClusterSize += s.Size
+	}
+	for name := range t.NativeFuncs {
+		e.NativeFuncs = append(e.NativeFuncs, name)
+	}
+	for name := range t.NativeCalls {
+		e.NativeFuncs = append(e.NativeFuncs, name)
+	}
+	sort.Strings(e.NativeFuncs)
+	if len(t.RequiredPorts) > 0 {
+		e.Firewall = make(map[string]string)
+		for _, pltfrm := range runsOn {
+			e.Firewall[pltfrm] = firewallPlan(pltfrm, t.RequiredPorts)
+		}
+		for _, spec := range t.AdditionalClusters {
+			e.Firewall[spec.Platform] = firewallPlan(spec.Platform, t.RequiredPorts)
+		}
+	}
+	return e
+}
+
+// firewallPlan describes how a cluster on pltfrm is made to let kola
+// connect to ports of its machines.
+func firewallPlan(pltfrm string, ports []int) string {
+	var list []string
+	for _, port := range ports {
+		list = append(list, strconv.Itoa(port))
+	}
+	tcp := "tcp:" + strings.Join(list, ",")
+	switch pltfrm {
+	case "gce":
+		return fmt.Sprintf("allow %s from kola's address to the cluster's instances", tcp)
+	case "qemu":
+		return fmt.Sprintf("check that netfilter doesn't drop %s", tcp)
+	default:
+		return fmt.Sprintf("none; %s must already be reachable", tcp)
+	}
+}
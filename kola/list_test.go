@@ -0,0 +1,80 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/mantle/kola/register"
+)
+
+func TestList(t *testing.T) {
+	registerTests(t, &register.Test{
+		Name:          "kola.list.native",
+		ClusterSize:   1,
+		Platforms:     []string{"qemu", "gce"},
+		NativeFuncs:   map[string]func() error{"b": nil, "a": nil},
+		RequiredPorts: []int{80, 443},
+	}, &register.Test{
+		Name:             "kola.list.stages",
+		ExcludePlatforms: []string{"gce"},
+		BootStages:       []register.BootStage{{Name: "server", Size: 1}, {Name: "clients", Size: 2}},
+	}, &register.Test{
+		Name:      "kola.list.aws",
+		Platforms: []string{"aws"},
+	})
+
+	sel, err := NewSelection([]string{"kola.list.*"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := List(sel, []string{"qemu", "gce"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if want := []string{"kola.list.native", "kola.list.stages"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("listed %v, want %v", names, want)
+	}
+
+	native := entries[0]
+	if !reflect.DeepEqual(native.RunsOn, []string{"qemu", "gce"}) {
+		t.Errorf("native test runs on %v", native.RunsOn)
+	}
+	if !reflect.DeepEqual(native.NativeFuncs, []string{"a", "b"}) {
+		t.Errorf("native functions %v", native.NativeFuncs)
+	}
+	if native.ClusterSize != 1 {
+		t.Errorf("native test boots %d machines, want 1", native.ClusterSize)
+	}
+	if want := "allow tcp:80,443 from kola's address to the cluster's instances"; native.Firewall["gce"] != want {
+		t.Errorf("firewall plan for gce %q, want %q", native.Firewall["gce"], want)
+	}
+
+	stages := entries[1]
+	if !reflect.DeepEqual(stages.RunsOn, []string{"qemu"}) {
+		t.Errorf("staged test runs on %v", stages.RunsOn)
+	}
+	if stages.ClusterSize != 3 {
+		t.Errorf("staged test boots %d machines, want 3", stages.ClusterSize)
+	}
+	if stages.Firewall != nil {
+		t.Errorf("staged test without required ports has firewall plan %v", stages.Firewall)
+	}
+}
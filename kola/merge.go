@@ -0,0 +1,77 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"strings"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+// MergeReports combines the reports of several runs, e.g. of shards of
+// a run or of a rerun of its failures, into one. A test run on the same
+// platform by several reports takes its result from the last of them.
+// If the reports cover more than one platform, tests are named
+// test/platform/subtest like in a multi-platform run, without the
+// top-level entries aggregating the platforms. The merged version lists
+// each distinct version once. The run fails if any merged test failed.
+func MergeReports(reports ...*Report) *Report {
+	var platforms, versions []string
+	for _, r := range reports {
+		platforms = appendMissing(platforms, strings.Split(r.Platform, ","))
+		versions = appendMissing(versions, strings.Split(r.Version, ","))
+	}
+	merged := &Report{
+		Platform: strings.Join(platforms, ","),
+		Version:  strings.Join(versions, ","),
+		Result:   testresult.Pass,
+		Tests:    []reportTest{},
+	}
+
+	index := make(map[testKey]int)
+	for _, r := range reports {
+		r.eachTest(func(k testKey, t reportTest) {
+			if len(platforms) > 1 {
+				parts := strings.SplitN(k.name, "/", 2)
+				t.Name = parts[0] + "/" + k.platform
+				if len(parts) == 2 {
+					t.Name += "/" + parts[1]
+				}
+			}
+			if i, ok := index[k]; ok {
+				merged.Tests[i] = t
+				return
+			}
+			index[k] = len(merged.Tests)
+			merged.Tests = append(merged.Tests, t)
+		})
+	}
+	for _, t := range merged.Tests {
+		if t.Result == testresult.Fail {
+			merged.Result = testresult.Fail
+		}
+	}
+	return merged
+}
+
+// appendMissing appends the non-empty strings of add which list lacks.
+func appendMissing(list, add []string) []string {
+	for _, s := range add {
+		if s != "" && !hasString(list, s) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
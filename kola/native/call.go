@@ -0,0 +1,57 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// CheckCall returns an error unless fn is a function kolet can call with
+// a JSON argument: a func(In) (Out, error) for any In and Out which
+// encoding/json handles.
+func CheckCall(fn interface{}) error {
+	ft := reflect.TypeOf(fn)
+	if ft == nil || ft.Kind() != reflect.Func {
+		return fmt.Errorf("%T is not a function", fn)
+	}
+	if ft.NumIn() != 1 || ft.IsVariadic() || ft.NumOut() != 2 || ft.Out(1) != errorType {
+		return fmt.Errorf("%v is not a func(In) (Out, error)", ft)
+	}
+	return nil
+}
+
+// Call decodes in, a JSON document, into the argument of fn, which must
+// pass CheckCall, and calls it. The Result holds the value fn returned
+// or, if fn failed or in doesn't decode, why. Empty input leaves the
+// argument zero.
+func Call(fn interface{}, in []byte) Result {
+	fv := reflect.ValueOf(fn)
+	arg := reflect.New(fv.Type().In(0))
+	if len(bytes.TrimSpace(in)) > 0 {
+		if err := json.Unmarshal(in, arg.Interface()); err != nil {
+			return Result{Error: fmt.Sprintf("decoding argument: %v", err)}
+		}
+	}
+	out := fv.Call([]reflect.Value{arg.Elem()})
+	if err, _ := out[1].Interface().(error); err != nil {
+		return Result{Error: err.Error()}
+	}
+	return Result{Value: out[0].Interface()}
+}
@@ -0,0 +1,214 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package native is a library of checks which kolet runs on machines
+// for any test, as "kolet lib <func> [args...]". Each prints a Result as
+// JSON on stdout; TestCluster has a typed wrapper for each.
+package native
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/godbus/dbus"
+)
+
+// Func is a library function run by kolet.
+type Func struct {
+	Args []string // names of the arguments, all required
+	Run  func(args ...string) (interface{}, error)
+}
+
+// Funcs are the library functions, by the name kolet runs them as.
+var Funcs = map[string]Func{
+	"sysctl":        {[]string{"name"}, sysctl},
+	"stat":          {[]string{"path"}, stat},
+	"unit-property": {[]string{"unit", "property"}, unitProperty},
+	"kernel-config": {[]string{"option"}, kernelConfig},
+}
+
+// Result is what kolet prints for a library function: its value, or
+// why it failed.
+type Result struct {
+	Value interface{} `json:"value,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// FileInfo is the result of stat. Symlinks are followed.
+type FileInfo struct {
+	Path  string      `json:"path"`
+	Mode  os.FileMode `json:"mode"`
+	UID   uint32      `json:"uid"`
+	GID   uint32      `json:"gid"`
+	Size  int64       `json:"size"`
+	Label string      `json:"label"` // the SELinux context; "" if unlabeled
+}
+
+// sysctlPath returns the file under /proc/sys of the sysctl name, which
+// like sysctl(8) separates components with dots, or with slashes if the
+// first separator is one, e.g. for interfaces named with dots.
+func sysctlPath(name string) string {
+	if i := strings.IndexAny(name, "./"); i >= 0 && name[i] == '.' {
+		name = strings.Replace(name, ".", "/", -1)
+	}
+	return filepath.Join("/proc/sys", filepath.Clean("/"+name))
+}
+
+func sysctl(args ...string) (interface{}, error) {
+	b, err := ioutil.ReadFile(sysctlPath(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func stat(args ...string) (interface{}, error) {
+	fi, err := os.Stat(args[0])
+	if err != nil {
+		return nil, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("stat %s: no ownership", args[0])
+	}
+	label, err := selinuxLabel(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return FileInfo{
+		Path:  args[0],
+		Mode:  fi.Mode(),
+		UID:   st.Uid,
+		GID:   st.Gid,
+		Size:  fi.Size(),
+		Label: label,
+	}, nil
+}
+
+// selinuxLabel returns the SELinux context of path, or "" if it has
+// none.
+func selinuxLabel(path string) (string, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := syscall.Getxattr(path, "security.selinux", buf)
+		switch err {
+		case nil:
+			return strings.TrimRight(string(buf[:n]), "\x00"), nil
+		case syscall.ENODATA, syscall.ENOTSUP:
+			return "", nil
+		case syscall.ERANGE:
+			buf = make([]byte, 2*len(buf))
+		default:
+			return "", &os.PathError{Op: "getxattr", Path: path, Err: err}
+		}
+	}
+}
+
+// unitInterface returns the D-Bus interface of systemd for the type of
+// unit, e.g. org.freedesktop.systemd1.Service for a .service.
+func unitInterface(unit string) string {
+	typ := unit[strings.LastIndexByte(unit, '.')+1:]
+	return "org.freedesktop.systemd1." + strings.Title(typ)
+}
+
+// unitProperty returns a property of a unit from systemd, whether of all
+// units, such as ActiveState, or of its type, such as a service's
+// MainPID.
+func unitProperty(args ...string) (interface{}, error) {
+	unit, property := args[0], args[1]
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, err
+	}
+	var path dbus.ObjectPath
+	manager := conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
+	if err := manager.Call("org.freedesktop.systemd1.Manager.LoadUnit", 0, unit).Store(&path); err != nil {
+		return nil, fmt.Errorf("loading %s: %v", unit, err)
+	}
+	obj := conn.Object("org.freedesktop.systemd1", path)
+	v, err := obj.GetProperty("org.freedesktop.systemd1.Unit." + property)
+	if e, ok := err.(dbus.Error); ok && e.Name == "org.freedesktop.DBus.Error.UnknownProperty" {
+		v, err = obj.GetProperty(unitInterface(unit) + "." + property)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting %s of %s: %v", property, unit, err)
+	}
+	return v.Value(), nil
+}
+
+// kernelConfigs are where the config of the running kernel may be, %s
+// being its release. /proc/config.gz is gzipped.
+var kernelConfigs = []string{
+	"/proc/config.gz",
+	"/usr/boot/config-%s",
+	"/boot/config-%s",
+	"/usr/boot/config",
+}
+
+func kernelConfig(args ...string) (interface{}, error) {
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range kernelConfigs {
+		if strings.Contains(p, "%s") {
+			p = fmt.Sprintf(p, strings.TrimSpace(string(release)))
+		}
+		f, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if strings.HasSuffix(p, ".gz") {
+			if r, err = gzip.NewReader(f); err != nil {
+				return nil, fmt.Errorf("reading %s: %v", p, err)
+			}
+		}
+		return ParseKernelConfig(r, args[0])
+	}
+	return nil, fmt.Errorf("no config found for kernel %s", strings.TrimSpace(string(release)))
+}
+
+// ParseKernelConfig returns the value of option, with or without its
+// CONFIG_ prefix, in the kernel config read from r: "y", "m", a string
+// or number as written, or "n" if it is not set.
+func ParseKernelConfig(r io.Reader, option string) (string, error) {
+	if !strings.HasPrefix(option, "CONFIG_") {
+		option = "CONFIG_" + option
+	}
+	lines := bufio.NewScanner(r)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if strings.HasPrefix(line, option+"=") {
+			return strings.TrimPrefix(line, option+"="), nil
+		}
+		if line == "# "+option+" is not set" {
+			return "n", nil
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return "", err
+	}
+	return "n", nil
+}
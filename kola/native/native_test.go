@@ -0,0 +1,118 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSysctlPath(t *testing.T) {
+	for name, want := range map[string]string{
+		"net.ipv4.ip_forward":              "/proc/sys/net/ipv4/ip_forward",
+		"net/ipv4/ip_forward":              "/proc/sys/net/ipv4/ip_forward",
+		"net/ipv4/conf/eth0.100/rp_filter": "/proc/sys/net/ipv4/conf/eth0.100/rp_filter",
+		"kernel.hostname":                  "/proc/sys/kernel/hostname",
+		"../../etc/passwd":                 "/proc/sys/etc/passwd",
+	} {
+		if got := sysctlPath(name); got != want {
+			t.Errorf("%s: got %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestUnitInterface(t *testing.T) {
+	for unit, want := range map[string]string{
+		"etcd-member.service": "org.freedesktop.systemd1.Service",
+		"docker.socket":       "org.freedesktop.systemd1.Socket",
+		"usr-share-oem.mount": "org.freedesktop.systemd1.Mount",
+	} {
+		if got := unitInterface(unit); got != want {
+			t.Errorf("%s: got %s, want %s", unit, got, want)
+		}
+	}
+}
+
+const testKernelConfig = `#
+# Automatically generated file; DO NOT EDIT.
+# Linux/x86 4.14.63 Kernel Configuration
+#
+CONFIG_64BIT=y
+CONFIG_LOCALVERSION="-coreos"
+CONFIG_NR_CPUS=128
+# CONFIG_KERNEL_BZIP2 is not set
+CONFIG_OVERLAY_FS=m
+CONFIG_OVERLAY_FS_REDIRECT_DIR=y
+`
+
+func TestParseKernelConfig(t *testing.T) {
+	for option, want := range map[string]string{
+		"CONFIG_64BIT":      "y",
+		"64BIT":             "y",
+		"LOCALVERSION":      `"-coreos"`,
+		"NR_CPUS":           "128",
+		"KERNEL_BZIP2":      "n",
+		"OVERLAY_FS":        "m",
+		"CONFIG_DEBUG_KMEM": "n",
+	} {
+		got, err := ParseKernelConfig(strings.NewReader(testKernelConfig), option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", option, got, want)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	type point struct{ X, Y int }
+	double := func(p point) (point, error) {
+		if p.X < 0 {
+			return point{}, fmt.Errorf("negative X")
+		}
+		return point{2 * p.X, 2 * p.Y}, nil
+	}
+	if err := CheckCall(double); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := Call(double, []byte(`{"X":1,"Y":2}`)); r.Error != "" || r.Value != (point{2, 4}) {
+		t.Errorf("got %+v, want {2 4}", r)
+	}
+	if r := Call(double, nil); r.Error != "" || r.Value != (point{}) {
+		t.Errorf("empty input: got %+v, want the zero point", r)
+	}
+	if r := Call(double, []byte(`{"X":-1}`)); r.Error != "negative X" || r.Value != nil {
+		t.Errorf("got %+v, want only the function's error", r)
+	}
+	if r := Call(double, []byte(`[1, 2]`)); !strings.HasPrefix(r.Error, "decoding argument") {
+		t.Errorf("got %+v, want a decoding error", r)
+	}
+
+	for _, fn := range []interface{}{
+		nil,
+		point{},
+		func() error { return nil },
+		func(point) point { return point{} },
+		func(point) (point, string) { return point{}, "" },
+		func(...point) (point, error) { return point{}, nil },
+	} {
+		if err := CheckCall(fn); err == nil {
+			t.Errorf("%T passed", fn)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"sort"
+	"strings"
+)
+
+// runOrder maps the names of a run's top-level tests to the position in
+// which the harness starts them, so that summaries list tests in the
+// order they ran.
+type runOrder map[string]int
+
+func newRunOrder(names []string) runOrder {
+	o := make(runOrder, len(names))
+	for i, name := range names {
+		o[name] = i
+	}
+	return o
+}
+
+// sort sorts descriptions of tests, which start with the test's name,
+// possibly followed by a subtest ("a.test/qemu") or remark ("a.test on
+// qemu"), by the position of their test and by description within one.
+// A nil runOrder sorts by description only.
+func (o runOrder) sort(descs []string) {
+	sort.SliceStable(descs, func(i, j int) bool {
+		pi, pj := o.position(descs[i]), o.position(descs[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return descs[i] < descs[j]
+	})
+}
+
+// position returns the position of the test desc describes, or len(o),
+// after every test, if it isn't one of the run's.
+func (o runOrder) position(desc string) int {
+	name := desc
+	if i := strings.IndexAny(desc, "/ "); i >= 0 {
+		name = desc[:i]
+	}
+	if p, ok := o[name]; ok {
+		return p
+	}
+	return len(o)
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/mantle/harness"
+)
+
+var (
+	platformSlotsMu sync.Mutex
+	platformSlots   = make(map[string]chan struct{})
+)
+
+// ParsePlatformParallelism parses limits given as <platform>=<n>.
+func ParsePlatformParallelism(specs []string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("platform parallelism %q is not <platform>=<n>", spec)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("platform parallelism %q: %q is not a positive number", spec, parts[1])
+		}
+		limits[parts[0]] = n
+	}
+	return limits, nil
+}
+
+// acquirePlatformSlot blocks until fewer than PlatformParallelism[pltfrm]
+// tests hold clusters on pltfrm, and releases the slot when h's
+// cleanups reach it. It must be called before the test's clusters are
+// created so that they are destroyed before the slot is released.
+func acquirePlatformSlot(h *harness.H, pltfrm string) {
+	limit := PlatformParallelism[pltfrm]
+	if limit < 1 {
+		return
+	}
+
+	platformSlotsMu.Lock()
+	slots, ok := platformSlots[pltfrm]
+	if !ok {
+		slots = make(chan struct{}, limit)
+		platformSlots[pltfrm] = slots
+	}
+	platformSlotsMu.Unlock()
+
+	slots <- struct{}{}
+	h.Cleanup(func() {
+		<-slots
+	})
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/harness"
+)
+
+func TestParsePlatformParallelism(t *testing.T) {
+	limits, err := ParsePlatformParallelism([]string{"gce=4", "qemu=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"gce": 4, "qemu": 1}; !reflect.DeepEqual(limits, want) {
+		t.Errorf("got %v, want %v", limits, want)
+	}
+
+	for _, bad := range []string{"gce", "=4", "gce=", "gce=0", "gce=-1", "gce=x"} {
+		if _, err := ParsePlatformParallelism([]string{bad}); err == nil {
+			t.Errorf("parsing %q succeeded", bad)
+		}
+	}
+}
+
+func TestPlatformSlots(t *testing.T) {
+	PlatformParallelism = map[string]int{"limited": 2}
+	defer func() { PlatformParallelism = nil }()
+
+	dir, err := ioutil.TempDir("", "kola-parallel-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var running, max int32
+	var tests harness.Tests
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		tests.Add(name, func(h *harness.H) {
+			h.Parallel()
+			acquirePlatformSlot(h, "limited")
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		})
+	}
+	suite := harness.NewSuite(harness.Options{
+		OutputDir: filepath.Join(dir, "out"),
+		Parallel:  5,
+	}, tests)
+	if err := suite.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if max > 2 {
+		t.Errorf("%d tests ran at once on a platform limited to 2", max)
+	}
+}
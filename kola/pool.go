@@ -0,0 +1,444 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+// sharedLifetimeFactor is how many times MaxMachineLifetime the machines
+// of shared clusters may live, unless SharedMachineLifetime is set.
+const sharedLifetimeFactor = 4
+
+// runPool holds the clusters tests share during a run with ShareClusters.
+var runPool *clusterPool
+
+// clusterPool holds the clusters shared by tests with the same platform,
+// ClusterSize and config. A cluster is used by one test at a time and
+// goes back to the pool, reset, when a test using it passes; it is
+// destroyed when a test using it fails, when its place is needed for a
+// new cluster, and at the end of the run. A nil pool shares nothing.
+//
+// Idle clusters count against the run's parallelism: no more clusters
+// than the tests allowed to run at once on a platform are kept alive
+// there, so that sharing doesn't raise a cloud's quota usage.
+type clusterPool struct {
+	// ctx is the context of the pooled clusters, which outlive the
+	// test creating them.
+	ctx context.Context
+
+	// parallel and platformParallel are the run's TestParallelism
+	// and PlatformParallelism.
+	parallel         int
+	platformParallel map[string]int
+
+	mu      sync.Mutex
+	idle    map[string][]*pooledCluster
+	all     []*pooledCluster
+	holding map[string]int // tests holding clusters, by platform
+}
+
+// pooledCluster is a cluster of a clusterPool.
+type pooledCluster struct {
+	key    string
+	c      platform.Cluster
+	pltfrm string
+	dir    string // its own output directory, with its logs of the run
+
+	// set once the machines have started
+	started      bool
+	machines     []platform.Machine
+	discoveryURL string
+
+	timeline <-chan struct{}
+	console  *register.Test // to check its consoles for when destroyed
+
+	mu        sync.Mutex
+	h         *harness.H // of the test using it, or the last to
+	testDir   string     // the output directory of that test
+	marks     map[string]int64
+	inUse     bool
+	idleSince time.Time
+	tests     int
+	destroyed bool
+}
+
+// sharedMachineLifetime is the MaxMachineLifetime of shared clusters,
+// which outlive the tests using them.
+func sharedMachineLifetime() time.Duration {
+	if SharedMachineLifetime != 0 {
+		return SharedMachineLifetime
+	}
+	return sharedLifetimeFactor * MaxMachineLifetime
+}
+
+func newClusterPool(ctx context.Context, parallel int, platformParallel map[string]int) *clusterPool {
+	return &clusterPool{
+		ctx:              ctx,
+		parallel:         parallel,
+		platformParallel: platformParallel,
+		idle:             make(map[string][]*pooledCluster),
+		holding:          make(map[string]int),
+	}
+}
+
+// key returns the key of the clusters t may share on pltfrm, booted with
+// userdata, or "" if t needs a cluster of its own.
+func (p *clusterPool) key(t *register.Test, pltfrm string, userdata []*conf.UserData) string {
+	if p == nil || t.ExclusiveCluster || t.ClusterSize == 0 || len(userdata) != t.ClusterSize ||
+		len(t.BootStages) > 0 || len(t.AdditionalClusters) > 0 {
+		return ""
+	}
+	var digests []string
+	for _, ud := range userdata {
+		// an etcd cluster would keep the state of earlier tests
+		if ud != nil && ud.NeedsDiscovery() {
+			return ""
+		}
+		digests = append(digests, ud.Digest())
+	}
+	key, err := digestJSON(struct {
+		Platform       string
+		UserData       []string
+		Flags          []register.Flag
+		RequiredPorts  []int
+		DestroyOrder   platform.DestroyOrder
+		AddressFamily  string
+		MachineOptions platform.MachineOptions
+	}{pltfrm, digests, t.Flags, t.RequiredPorts, t.DestroyOrder, t.AddressFamily, t.MachineOptions})
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+// hold counts h as holding clusters on pltfrm until its cleanups reach
+// the call, like acquirePlatformSlot. It is safe to call on a nil pool.
+func (p *clusterPool) hold(h *harness.H, pltfrm string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.holding[pltfrm]++
+	p.mu.Unlock()
+	h.Cleanup(func() {
+		p.mu.Lock()
+		p.holding[pltfrm]--
+		p.mu.Unlock()
+	})
+}
+
+// limit returns how many clusters may be alive on pltfrm at once.
+func (p *clusterPool) limit(pltfrm string) int {
+	limit := p.parallel
+	if limit < 1 {
+		// as the harness defaults it
+		limit = runtime.GOMAXPROCS(0)
+	}
+	if l := p.platformParallel[pltfrm]; l > 0 && l < limit {
+		limit = l
+	}
+	return limit
+}
+
+// makeRoom destroys the clusters idle longest on pltfrm until, counting
+// one for each test holding clusters there, the limit isn't exceeded. A
+// test calls it before creating a cluster. It is safe to call on a nil
+// pool.
+func (p *clusterPool) makeRoom(pltfrm string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	var evict []*pooledCluster
+	for {
+		var idle int
+		var oldest *pooledCluster
+		for _, pcs := range p.idle {
+			for _, pc := range pcs {
+				if pc.pltfrm != pltfrm {
+					continue
+				}
+				idle++
+				if oldest == nil || pc.idleSince.Before(oldest.idleSince) {
+					oldest = pc
+				}
+			}
+		}
+		if oldest == nil || p.holding[pltfrm]+idle <= p.limit(pltfrm) {
+			break
+		}
+		p.removeIdle(oldest)
+		evict = append(evict, oldest)
+	}
+	p.mu.Unlock()
+
+	for _, pc := range evict {
+		plog.Infof("Destroying the idle cluster in %s to make room for a new one", pc.dir)
+		pc.destroy()
+	}
+}
+
+// removeIdle removes pc from the idle clusters. p.mu must be held.
+func (p *clusterPool) removeIdle(pc *pooledCluster) {
+	idle := p.idle[pc.key]
+	for i := range idle {
+		if idle[i] == pc {
+			p.idle[pc.key] = append(idle[:i:i], idle[i+1:]...)
+			return
+		}
+	}
+}
+
+// take returns an idle cluster with key for h to use, logging to
+// testDir, or nil if there is none.
+func (p *clusterPool) take(h *harness.H, key, testDir string) *pooledCluster {
+	if p == nil || key == "" {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	idle := p.idle[key]
+	if len(idle) == 0 {
+		return nil
+	}
+	pc := idle[len(idle)-1]
+	p.idle[key] = idle[:len(idle)-1]
+	pc.use(h, testDir)
+	return pc
+}
+
+// add pools c, created with key and its own output directory dir by the
+// test h for its own use, logging to testDir.
+func (p *clusterPool) add(h *harness.H, key, pltfrm, dir, testDir string, c platform.Cluster, t *register.Test) *pooledCluster {
+	pc := &pooledCluster{
+		key:     key,
+		c:       c,
+		pltfrm:  pltfrm,
+		dir:     dir,
+		console: t,
+	}
+	pc.use(h, testDir)
+	pc.timeline = logTimeline(pc.logf, c, dir)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.all = append(p.all, pc)
+	return pc
+}
+
+// release takes pc back from the test h which used it. If the test
+// passed and resetting pc's machines succeeds, pc is idle for the next
+// test; otherwise it is destroyed.
+func (p *clusterPool) release(h *harness.H, pc *pooledCluster) {
+	if h.Failed() || !pc.started {
+		pc.destroy()
+		return
+	}
+	if err := pc.copyLogs(); err != nil {
+		h.Logf("warning: copying the logs of the shared cluster: %v", err)
+	}
+	if err := pc.reset(); err != nil {
+		h.Logf("warning: not sharing the cluster any longer: %v", err)
+		pc.destroy()
+		return
+	}
+	pc.mu.Lock()
+	pc.inUse = false
+	pc.idleSince = time.Now()
+	pc.mu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle[pc.key] = append(p.idle[pc.key], pc)
+}
+
+// DestroyAll destroys every cluster of the pool, idle or not. It is safe
+// to call on a nil pool.
+func (p *clusterPool) DestroyAll() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	all := p.all
+	p.all = nil
+	p.idle = make(map[string][]*pooledCluster)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, pc := range all {
+		wg.Add(1)
+		go func(pc *pooledCluster) {
+			defer wg.Done()
+			pc.destroy()
+		}(pc)
+	}
+	wg.Wait()
+}
+
+// use hands pc to the test h, whose logs of it go to testDir.
+func (pc *pooledCluster) use(h *harness.H, testDir string) {
+	marks, err := fileSizes(pc.dir)
+	if err != nil {
+		h.Logf("warning: the logs of the shared cluster in %s will be missing: %v", pc.dir, err)
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.h = h
+	pc.testDir = testDir
+	pc.marks = marks
+	pc.inUse = true
+	pc.tests++
+}
+
+// copyLogs copies what was logged to the files of pc's directory, such
+// as its machines' journals and consoles and its timeline, while the
+// current test used it to the same files in the test's own directory.
+// Compressed files can't be split up and stay in pc's directory alone.
+func (pc *pooledCluster) copyLogs() error {
+	pc.mu.Lock()
+	testDir, marks := pc.testDir, pc.marks
+	pc.mu.Unlock()
+	if marks == nil {
+		return nil
+	}
+	return filepath.Walk(pc.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() || strings.HasSuffix(path, ".gz") {
+			return err
+		}
+		rel, err := filepath.Rel(pc.dir, path)
+		if err != nil {
+			return err
+		}
+		return copyFrom(path, marks[rel], filepath.Join(testDir, rel))
+	})
+}
+
+// fileSizes returns the sizes of the regular files under dir, by path
+// relative to dir.
+func fileSizes(dir string) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sizes[rel] = info.Size()
+		return nil
+	})
+	return sizes, err
+}
+
+// copyFrom appends the contents of src from offset on to dst.
+func copyFrom(src string, offset int64, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err := in.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// logf logs to the test using pc, or to the run's log while it is idle.
+func (pc *pooledCluster) logf(format string, args ...interface{}) {
+	pc.mu.Lock()
+	h, inUse := pc.h, pc.inUse
+	pc.mu.Unlock()
+	if inUse {
+		h.Logf(format, args...)
+	} else {
+		plog.Warningf(format, args...)
+	}
+}
+
+// reset clears what a test may have left on the machines of pc: the
+// core user's files, other than its SSH keys, and, by rebooting, the
+// state of units and temporary files. The reboot also checks the
+// machines as they are checked when created.
+func (pc *pooledCluster) reset() error {
+	for _, m := range pc.machines {
+		if out, stderr, err := m.SSH(`find ~core -mindepth 1 -maxdepth 1 ! -name .ssh -exec sudo rm -rf {} +`); err != nil {
+			return fmt.Errorf("clearing the home directory of machine %s: %v: %s%s", m.ID(), err, out, stderr)
+		}
+		if err := m.Reboot(); err != nil {
+			return fmt.Errorf("rebooting machine %s: %v", m.ID(), err)
+		}
+	}
+	return nil
+}
+
+// destroy destroys pc the first time it is called, checking the consoles
+// of its machines.
+func (pc *pooledCluster) destroy() {
+	pc.mu.Lock()
+	if pc.destroyed {
+		pc.mu.Unlock()
+		return
+	}
+	pc.destroyed = true
+	h, inUse, tests := pc.h, pc.inUse, pc.tests
+	pc.mu.Unlock()
+
+	pc.c.Destroy()
+	<-pc.timeline
+	if inUse {
+		if err := pc.copyLogs(); err != nil {
+			h.Logf("warning: copying the logs of the shared cluster: %v", err)
+		}
+	}
+	if inUse {
+		fireHook(h, HookClusterDestroyed, pc.pltfrm, "primary", pc.dir, pc.c)
+	} else {
+		// the tests which used it are complete
+		fireHook(nil, HookClusterDestroyed, pc.pltfrm, "primary", pc.dir, pc.c)
+	}
+	for id, output := range pc.c.ConsoleOutput() {
+		for _, badness := range CheckConsole([]byte(output), pc.console) {
+			if inUse {
+				h.Errorf("Found %s on machine %s console", badness, id)
+			} else {
+				plog.Errorf("Found %s on the console of machine %s of a cluster shared by %d tests, output in %s", badness, id, tests, pc.dir)
+			}
+		}
+	}
+}
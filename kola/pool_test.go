@@ -0,0 +1,241 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+// resetMachine records how it is reset between tests.
+type resetMachine struct {
+	platform.Machine
+	cmds    []string
+	reboots int
+}
+
+func (m *resetMachine) ID() string { return "m" }
+
+func (m *resetMachine) SSH(cmd string) ([]byte, []byte, error) {
+	m.cmds = append(m.cmds, cmd)
+	return nil, nil, nil
+}
+
+func (m *resetMachine) Reboot() error {
+	m.reboots++
+	return nil
+}
+
+// poolCluster counts how often it is destroyed.
+type poolCluster struct {
+	platform.Cluster
+	events    chan platform.MachineEvent
+	destroyed int
+}
+
+func (c *poolCluster) Events() <-chan platform.MachineEvent { return c.events }
+func (c *poolCluster) ConsoleOutput() map[string]string     { return nil }
+func (c *poolCluster) Destroy() {
+	if c.destroyed == 0 {
+		close(c.events)
+	}
+	c.destroyed++
+}
+
+func TestClusterPoolKey(t *testing.T) {
+	test := &register.Test{Name: "t", ClusterSize: 1}
+	config := []*conf.UserData{conf.ContainerLinuxConfig("systemd: {}")}
+	p := newClusterPool(context.Background(), 1, nil)
+	key := p.key(test, "qemu", config)
+	if key == "" {
+		t.Fatal("no key for a shareable test")
+	}
+	if k := p.key(&register.Test{Name: "u", ClusterSize: 1}, "qemu", config); k != key {
+		t.Error("tests with the same config don't share")
+	}
+	if k := p.key(test, "gce", config); k == key {
+		t.Error("platforms share clusters")
+	}
+	if k := p.key(test, "qemu", []*conf.UserData{conf.ContainerLinuxConfig("")}); k == key {
+		t.Error("configs share clusters")
+	}
+	for _, c := range []struct {
+		name     string
+		test     *register.Test
+		userdata []*conf.UserData
+	}{
+		{"exclusive", &register.Test{ClusterSize: 1, ExclusiveCluster: true}, config},
+		{"no machines", &register.Test{}, nil},
+		{"stages", &register.Test{ClusterSize: 1, BootStages: []register.BootStage{{Name: "a", Size: 1}}}, config},
+		{"discovery", &register.Test{ClusterSize: 1}, []*conf.UserData{conf.CloudConfig("etcd2: {discovery: $discovery}")}},
+	} {
+		if k := p.key(c.test, "qemu", c.userdata); k != "" {
+			t.Errorf("%s: shares clusters", c.name)
+		}
+	}
+	var nilPool *clusterPool
+	if k := nilPool.key(test, "qemu", config); k != "" {
+		t.Error("nil pool shares clusters")
+	}
+}
+
+func TestClusterPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-pool-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := newClusterPool(context.Background(), 1, nil)
+	m := &resetMachine{}
+	first := &poolCluster{events: make(chan platform.MachineEvent)}
+	second := &poolCluster{events: make(chan platform.MachineEvent)}
+	test := &register.Test{Name: "t", ClusterSize: 1}
+
+	// each test uses an idle cluster or creates one, as runTest does,
+	// and logs its name to the cluster's journal
+	use := func(c *poolCluster, fail bool) func(h *harness.H) {
+		return func(h *harness.H) {
+			testDir := filepath.Join(dir, h.Name())
+			shared := p.take(h, "key", testDir)
+			if shared == nil {
+				clusterDir := filepath.Join(dir, "shared", h.Name())
+				if err := os.MkdirAll(filepath.Join(clusterDir, "m"), 0777); err != nil {
+					h.Fatal(err)
+				}
+				shared = p.add(h, "key", "qemu", clusterDir, testDir, c, test)
+				shared.machines = []platform.Machine{m}
+				shared.started = true
+			}
+			h.Cleanup(func() { p.release(h, shared) })
+			if shared.c != c {
+				h.Errorf("used the wrong cluster")
+			}
+			journal, err := os.OpenFile(filepath.Join(shared.dir, "m", "journal.txt"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+			if err != nil {
+				h.Fatal(err)
+			}
+			fmt.Fprintln(journal, h.Name())
+			journal.Close()
+			if fail {
+				h.Fail()
+			}
+		}
+	}
+	var tests harness.Tests
+	tests.Add("1-create", use(first, false))
+	tests.Add("2-reuse", use(first, false))
+	tests.Add("3-fail", use(first, true))
+	tests.Add("4-recreate", use(second, false))
+	suite := harness.NewSuite(harness.Options{
+		OutputDir: filepath.Join(dir, "out"),
+		Parallel:  1,
+	}, tests)
+	if err := suite.Run(); err != harness.SuiteFailed {
+		t.Fatalf("suite returned %v", err)
+	}
+
+	if first.destroyed != 1 {
+		t.Errorf("cluster of the failed test destroyed %d times", first.destroyed)
+	}
+	if second.destroyed != 0 {
+		t.Error("idle cluster destroyed before the end of the run")
+	}
+	// reset after each test which passed
+	if m.reboots != 3 || len(m.cmds) != 3 {
+		t.Errorf("machine reset %d times, rebooted %d times", len(m.cmds), m.reboots)
+	}
+	p.DestroyAll()
+	p.DestroyAll()
+	if first.destroyed != 1 || second.destroyed != 1 {
+		t.Errorf("clusters destroyed %d and %d times at the end of the run", first.destroyed, second.destroyed)
+	}
+
+	// each test has the journal of its own use of the cluster
+	for _, name := range []string{"1-create", "2-reuse", "3-fail", "4-recreate"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name, "m", "journal.txt"))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if string(b) != name+"\n" {
+			t.Errorf("%s has journal %q", name, b)
+		}
+	}
+}
+
+func TestClusterPoolMakeRoom(t *testing.T) {
+	p := newClusterPool(context.Background(), 3, map[string]int{"gce": 2})
+	done := make(chan struct{})
+	close(done)
+	idle := func(pltfrm string, age time.Duration) *poolCluster {
+		c := &poolCluster{events: make(chan platform.MachineEvent)}
+		pc := &pooledCluster{key: pltfrm, c: c, pltfrm: pltfrm, timeline: done, idleSince: time.Now().Add(-age)}
+		p.idle[pltfrm] = append(p.idle[pltfrm], pc)
+		p.all = append(p.all, pc)
+		return c
+	}
+	gceOld, gceNew := idle("gce", time.Hour), idle("gce", time.Minute)
+	qemuOld, qemuNew := idle("qemu", time.Hour), idle("qemu", time.Minute)
+
+	// one test holding clusters and two idle ones is within either limit
+	p.holding["qemu"] = 1
+	p.makeRoom("qemu")
+	if qemuOld.destroyed != 0 || qemuNew.destroyed != 0 {
+		t.Error("idle clusters destroyed within the limit")
+	}
+	p.holding["qemu"] = 2
+	p.makeRoom("qemu")
+	if qemuOld.destroyed != 1 || qemuNew.destroyed != 0 {
+		t.Error("the oldest idle cluster wasn't destroyed for a new one")
+	}
+
+	// the platform's own limit is lower
+	p.holding["gce"] = 1
+	p.makeRoom("gce")
+	if gceOld.destroyed != 1 || gceNew.destroyed != 0 {
+		t.Error("the platform's limit wasn't kept")
+	}
+	if len(p.idle["gce"]) != 1 || len(p.idle["qemu"]) != 1 {
+		t.Errorf("destroyed clusters still idle")
+	}
+}
+
+func TestSharedMachineLifetime(t *testing.T) {
+	defer func(max, shared time.Duration) {
+		MaxMachineLifetime, SharedMachineLifetime = max, shared
+	}(MaxMachineLifetime, SharedMachineLifetime)
+
+	for _, tt := range []struct {
+		max, shared, expected time.Duration
+	}{
+		{0, 0, 0},
+		{time.Hour, 0, sharedLifetimeFactor * time.Hour},
+		{time.Hour, 90 * time.Minute, 90 * time.Minute},
+		{0, time.Hour, time.Hour},
+	} {
+		MaxMachineLifetime, SharedMachineLifetime = tt.max, tt.shared
+		if got := sharedMachineLifetime(); got != tt.expected {
+			t.Errorf("max %v, shared %v: got %v, expected %v", tt.max, tt.shared, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+const (
+	// runLockFile marks a directory holding a run's artifacts. The run
+	// holds an exclusive lock on it, so pruning can tell it's running.
+	runLockFile = "kola-run.lock"
+
+	// cacheLockFile is share-locked by the runs using a cache directory.
+	cacheLockFile = "kola-cache.lock"
+
+	// attemptResultFile records whether an attempt at a test passed,
+	// as "pass", "fail" or "skip", in its artifact directory.
+	attemptResultFile = "result"
+)
+
+// RetentionPolicy decides what PruneRuns and PruneCache keep. Failed
+// tests' artifacts are kept longer than those of passed tests.
+type RetentionPolicy struct {
+	// KeepRuns is how many of the latest runs keep all their
+	// artifacts. 0 keeps every run.
+	KeepRuns int
+
+	// KeepFailedRuns is how many of the latest runs keep the artifacts
+	// of their failed tests. Older runs are removed entirely. It must
+	// not be less than KeepRuns.
+	KeepFailedRuns int
+
+	// MaxCacheSize caps the bytes of a cache directory. 0 is unlimited.
+	MaxCacheSize int64
+}
+
+// Check returns an error if the policy is inconsistent.
+func (p RetentionPolicy) Check() error {
+	if p.KeepRuns < 0 || p.KeepFailedRuns < 0 || p.MaxCacheSize < 0 {
+		return fmt.Errorf("--keep-runs, --keep-failed-runs and --max-cache-size must not be negative")
+	}
+	if p.KeepRuns > 0 && p.KeepFailedRuns < p.KeepRuns {
+		return fmt.Errorf("--keep-failed-runs (%d) must be at least --keep-runs (%d)", p.KeepFailedRuns, p.KeepRuns)
+	}
+	return nil
+}
+
+// fileLock is a flock(2) lock on a file, released by Unlock or when the
+// process exits.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile creates path if needed and locks it, shared or exclusive,
+// without waiting. It returns errLocked if another process holds a
+// conflicting lock.
+func lockFile(path string, how int) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+		return nil, fmt.Errorf("locking %s: %v", path, err)
+	}
+	return &fileLock{f}, nil
+}
+
+var errLocked = fmt.Errorf("locked by another process")
+
+// Unlock releases the lock. The file is left in place.
+func (l *fileLock) Unlock() {
+	if l != nil {
+		l.f.Close()
+	}
+}
+
+// lockRun marks dir as holding the artifacts of the running process,
+// until Unlock. The lock file's modification time orders runs for
+// pruning.
+func lockRun(dir string) (*fileLock, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, runLockFile)
+	l, err := lockFile(path, syscall.LOCK_EX)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.f.Truncate(0); err == nil {
+		fmt.Fprintf(l.f, "pid %d\n", os.Getpid())
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return l, nil
+}
+
+// runLocker holds the run locks of a run's directories while its suite
+// runs. It is a reporter because the suite empties its output directory
+// when it starts, which would take a lock file taken earlier with it.
+type runLocker struct {
+	dirs  []string
+	locks []*fileLock
+}
+
+func (r *runLocker) Start(path string) error {
+	for _, dir := range r.dirs {
+		l, err := lockRun(dir)
+		if err == errLocked {
+			return fmt.Errorf("%s is in use by another kola run", dir)
+		} else if err != nil {
+			return fmt.Errorf("locking %s: %v", dir, err)
+		}
+		r.locks = append(r.locks, l)
+	}
+	return nil
+}
+
+func (r *runLocker) Output(path string) error {
+	for _, l := range r.locks {
+		l.Unlock()
+	}
+	r.locks = nil
+	return nil
+}
+
+func (r *runLocker) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, annotations map[string]interface{}) {
+}
+func (r *runLocker) SetResult(result testresult.TestResult) {}
+
+// lockCache marks dir as a cache used by the running process, until
+// Unlock, so that PruneCache leaves it alone meanwhile.
+func lockCache(dir string) (*fileLock, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	return lockFile(filepath.Join(dir, cacheLockFile), syscall.LOCK_SH)
+}
+
+// writeAttemptResult records the result of an attempt in its artifact
+// directory for PruneRuns.
+func writeAttemptResult(dir, result string) error {
+	return ioutil.WriteFile(filepath.Join(dir, attemptResultFile), []byte(result+"\n"), 0666)
+}
+
+// Prune applies p to the runs in each of runDirs and to cacheDir, if not
+// "", printing what it removes to w.
+func Prune(runDirs []string, cacheDir string, p RetentionPolicy, w io.Writer) error {
+	for _, dir := range runDirs {
+		if err := PruneRuns(dir, p, w); err != nil {
+			return fmt.Errorf("pruning %s: %v", dir, err)
+		}
+	}
+	if cacheDir != "" {
+		if err := PruneCache(cacheDir, p, w); err != nil {
+			return fmt.Errorf("pruning %s: %v", cacheDir, err)
+		}
+	}
+	return nil
+}
+
+// pastRun is a run's directory under a runs directory.
+type pastRun struct {
+	dir     string
+	started time.Time
+}
+
+// PruneRuns applies p to the runs in dir, the artifacts directory of
+// several runs or the parent of their output directories, printing what
+// it removes to w. Only directories with a run lock file are runs; runs
+// which are still going, and hold their lock, are never touched.
+func PruneRuns(dir string, p RetentionPolicy, w io.Writer) error {
+	if p.KeepRuns == 0 {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var runs []pastRun
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		st, err := os.Stat(filepath.Join(dir, e.Name(), runLockFile))
+		if err != nil {
+			continue
+		}
+		runs = append(runs, pastRun{filepath.Join(dir, e.Name()), st.ModTime()})
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].started.After(runs[j].started)
+	})
+
+	for i, run := range runs {
+		if i < p.KeepRuns {
+			continue
+		}
+		if err := pruneRun(run.dir, i >= p.KeepFailedRuns, p, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneRun removes run, or only its passed tests' artifacts unless all,
+// if it isn't running.
+func pruneRun(run string, all bool, p RetentionPolicy, w io.Writer) error {
+	l, err := lockFile(filepath.Join(run, runLockFile), syscall.LOCK_EX)
+	if err == errLocked {
+		fmt.Fprintf(w, "Not pruning %s: kola is still running there\n", run)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer l.Unlock()
+
+	if all {
+		size := dirSize(run)
+		if err := os.RemoveAll(run); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Pruned %s (%s): older than the last %d runs\n", run, formatBytes(size), p.KeepFailedRuns)
+		return nil
+	}
+
+	passed, err := passedAttempts(run)
+	if err != nil {
+		return err
+	}
+	var size uint64
+	for _, dir := range passed {
+		size += dirSize(dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	if len(passed) > 0 {
+		fmt.Fprintf(w, "Pruned %d passed tests of %s (%s): older than the last %d runs\n", len(passed), run, formatBytes(size), p.KeepRuns)
+	}
+	return nil
+}
+
+// passedAttempts returns the artifact directories of the attempts under
+// run which passed.
+func passedAttempts(run string) ([]string, error) {
+	var passed []string
+	err := filepath.Walk(run, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && info.Name() == attemptResultFile {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(string(b)) == "pass" {
+				passed = append(passed, filepath.Dir(path))
+			}
+		}
+		return nil
+	})
+	return passed, err
+}
+
+// cacheFile is a file of a cache directory.
+type cacheFile struct {
+	path string
+	size int64
+	used time.Time // updated by the cache on every hit
+}
+
+// PruneCache removes the least recently used files of the cache
+// directory dir until it is within p.MaxCacheSize, printing what it
+// removes to w. A cache in use by a running kola is left alone.
+func PruneCache(dir string, p RetentionPolicy, w io.Writer) error {
+	if p.MaxCacheSize == 0 {
+		return nil
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	l, err := lockFile(filepath.Join(dir, cacheLockFile), syscall.LOCK_EX)
+	if err == errLocked {
+		fmt.Fprintf(w, "Not pruning %s: kola is still using it\n", dir)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer l.Unlock()
+
+	var files []cacheFile
+	var total int64
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// temporary files of downloads and entries being written
+		// are dot files
+		if !info.Mode().IsRegular() || info.Name() == cacheLockFile || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		files = append(files, cacheFile{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if total <= p.MaxCacheSize {
+		return nil
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].used.Before(files[j].used)
+	})
+	var removed int
+	var freed int64
+	for _, f := range files {
+		if total <= p.MaxCacheSize {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+		total -= f.size
+		freed += f.size
+		removed++
+	}
+	fmt.Fprintf(w, "Pruned %d least recently used files of %s (%s) to stay within %s\n",
+		removed, dir, formatBytes(uint64(freed)), formatBytes(uint64(p.MaxCacheSize)))
+	return nil
+}
+
+// touch marks a cache file as used now, for PruneCache.
+func touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
@@ -0,0 +1,197 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pastRuns creates the runs named in dir, oldest first, each with a
+// passed and a failed attempt.
+func pastRuns(t *testing.T, dir string, names ...string) {
+	start := time.Now().Add(-time.Hour)
+	for i, name := range names {
+		for _, attempt := range []struct{ test, result string }{{"passed", "pass"}, {"failed", "fail"}} {
+			adir := filepath.Join(dir, name, attempt.test, "qemu", "1")
+			if err := os.MkdirAll(adir, 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeAttemptResult(adir, attempt.result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		l, err := lockRun(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Unlock()
+		started := start.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(dir, name, runLockFile), started, started); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestPruneRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pastRuns(t, dir, "a", "b", "c", "d", "e")
+	// not a run
+	if err := os.Mkdir(filepath.Join(dir, "other"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	// still running
+	running, err := lockRun(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer running.Unlock()
+	// lockRun made it the newest; it's the oldest
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, "a", runLockFile), old, old)
+
+	var out bytes.Buffer
+	if err := PruneRuns(dir, RetentionPolicy{KeepRuns: 2, KeepFailedRuns: 3}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path string
+		kept bool
+	}{
+		{"e/passed/qemu/1", true},
+		{"d/passed/qemu/1", true},
+		{"c/passed/qemu/1", false},
+		{"c/failed/qemu/1", true},
+		{"b", false},
+		{"a/passed/qemu/1", true},
+		{"other", true},
+	} {
+		if kept := exists(filepath.Join(dir, c.path)); kept != c.kept {
+			t.Errorf("%s kept: %v, want %v", c.path, kept, c.kept)
+		}
+	}
+	if s := out.String(); !strings.Contains(s, "kola is still running") || !strings.Contains(s, filepath.Join(dir, "b")) {
+		t.Errorf("unexpected output:\n%s", s)
+	}
+
+	out.Reset()
+	if err := PruneRuns(dir, RetentionPolicy{}, &out); err != nil || out.Len() > 0 {
+		t.Errorf("KeepRuns 0 pruned %q: %v", out.String(), err)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	for i, name := range []string{"results/old.json", "fetch/sha256/used", "results/new.json", "fetch/sha256/.partial"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, make([]byte, 100), 0666); err != nil {
+			t.Fatal(err)
+		}
+		used := now.Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(path, used, used)
+	}
+	touch(filepath.Join(dir, "fetch/sha256/used"))
+
+	using, err := lockCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := PruneCache(dir, RetentionPolicy{MaxCacheSize: 150}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "still using it") || !exists(filepath.Join(dir, "results/old.json")) {
+		t.Errorf("pruned a cache in use:\n%s", out.String())
+	}
+	using.Unlock()
+
+	out.Reset()
+	if err := PruneCache(dir, RetentionPolicy{MaxCacheSize: 150}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for name, kept := range map[string]bool{
+		"results/old.json":      false,
+		"results/new.json":      false,
+		"fetch/sha256/used":     true,
+		"fetch/sha256/.partial": true,
+	} {
+		if exists(filepath.Join(dir, name)) != kept {
+			t.Errorf("%s kept: %v, want %v", name, !kept, kept)
+		}
+	}
+	if !strings.Contains(out.String(), "Pruned 2 ") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestRunLocker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := &runLocker{dirs: []string{dir}}
+	if err := first.Start(""); err != nil {
+		t.Fatal(err)
+	}
+	second := &runLocker{dirs: []string{dir}}
+	if err := second.Start(""); err == nil || !strings.Contains(err.Error(), "another kola run") {
+		t.Errorf("second run locked %s: %v", dir, err)
+	}
+	first.Output("")
+	if err := second.Start(""); err != nil {
+		t.Errorf("locking after the first run: %v", err)
+	}
+	second.Output("")
+}
+
+func TestRetentionPolicyCheck(t *testing.T) {
+	for _, c := range []struct {
+		p  RetentionPolicy
+		ok bool
+	}{
+		{RetentionPolicy{KeepRuns: 10, KeepFailedRuns: 30}, true},
+		{RetentionPolicy{KeepRuns: 10, KeepFailedRuns: 10}, true},
+		{RetentionPolicy{KeepRuns: 10, KeepFailedRuns: 5}, false},
+		{RetentionPolicy{}, true},
+		{RetentionPolicy{MaxCacheSize: -1}, false},
+	} {
+		if err := c.p.Check(); (err == nil) != c.ok {
+			t.Errorf("%+v: %v", c.p, err)
+		}
+	}
+}
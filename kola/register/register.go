@@ -16,10 +16,14 @@ package register
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/coreos/go-semver/semver"
 
 	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/native"
+	"github.com/coreos/mantle/platform"
 	"github.com/coreos/mantle/platform/conf"
 )
 
@@ -30,8 +34,33 @@ const (
 	NoSSHKeyInMetadata                // don't add SSH key to platform metadata
 	NoEmergencyShellCheck             // don't check console output for emergency shell invocation
 	NoEnableSelinux                   // don't enable selinux when starting or rebooting a machine
+	NoUserDataWait                    // don't wait for or check the application of user data, e.g. for tests of broken configs
 )
 
+// ClusterSpec describes an additional cluster created for a test, possibly
+// on a different platform than the one under test. The platform's options
+// are taken from the command line like those of the primary cluster.
+type ClusterSpec struct {
+	Name     string // unique within the test; names the cluster's output directory
+	Platform string
+	Size     int
+	UserData *conf.UserData
+}
+
+// BootStage is a group of machines booted together. A test's stages are
+// booted in order, each starting only once the previous stage is ready.
+type BootStage struct {
+	Name     string         // unique within the test, e.g. "server"
+	Size     int            // number of machines
+	UserData *conf.UserData // defaults to the test's UserData
+
+	// ReadyCheck, if set, is run over SSH on each machine of the
+	// stage until it succeeds on all of them, or ReadyTimeout passes,
+	// before the next stage starts booting.
+	ReadyCheck   string
+	ReadyTimeout time.Duration // defaults to kola.Timeouts.Ready, 5 minutes
+}
+
 // Test provides the main test abstraction for kola. The run function is
 // the actual testing function while the other fields provide ways to
 // statically declare state of the platform.TestCluster before the test
@@ -46,6 +75,100 @@ type Test struct {
 	ExcludePlatforms []string // blacklist of platforms to ignore -- defaults to none
 	Architectures    []string // whitelist of machine architectures supported -- defaults to all
 	Flags            []Flag   // special-case options for this test
+	Tags             []string // labels such as "smoke" or "slow" to select tests by with --tag
+
+	// NativeCalls are native functions which take an argument and
+	// return a result, each a func(In) (Out, error) where In and Out
+	// are encoded as JSON, run by TestCluster.CallNative. They copy
+	// kolet to the test's machines as NativeFuncs do.
+	NativeCalls map[string]interface{}
+
+	// NativeLibrary copies kolet to the test's machines, as NativeFuncs
+	// do, for the library checks of TestCluster such as Sysctl.
+	NativeLibrary bool
+
+	// Intent, instead of UserData, describes the machine configuration
+	// in a form the harness renders as cloud-config or Ignition
+	// according to --config-format, so one test covers both.
+	Intent *conf.Intent
+
+	// MachineUserData, if set, gives each of the ClusterSize machines
+	// its own configuration instead of UserData, for asymmetric
+	// clusters such as an etcd proxy and two members. Each may be a
+	// template, see conf.TemplateVars. Run gets the machines in the
+	// same order through TestCluster.Ordered.
+	MachineUserData []*conf.UserData
+
+	// UserDataFile, instead of UserData, names a local file holding
+	// the machine configuration in any format UserData accepts, for
+	// configs too long to keep as Go string literals. It is read when
+	// tests are selected to run.
+	UserDataFile string
+
+	// UserDataFiles maps paths on the machine to local files whose
+	// contents are added to UserData when the test runs, keeping large
+	// scripts and units out of Go string literals.
+	//
+	// Relative local paths here and in UserDataFile are resolved
+	// against kola's --config-dir, or its working directory.
+	UserDataFiles map[string]string
+
+	// EtcdVersion, if set, runs etcd-member.service from the etcd
+	// release with this version, e.g. "3.3.9", instead of the image's
+	// default. The test's UserData must configure etcd-member; the
+	// bundled etcd2 cannot be replaced.
+	EtcdVersion string
+
+	// AddressFamily is how the PeerURL and ClientURL helpers of config
+	// templates address machines: conf.FamilyIPv4, the default,
+	// conf.FamilyIPv6 or conf.FamilyHostname.
+	AddressFamily string
+
+	// BootStages, instead of ClusterSize, boots the test's machines in
+	// ordered groups, e.g. a server before its clients. Machines are
+	// available to Run by stage through TestCluster.Stages.
+	BootStages []BootStage
+
+	// AdditionalClusters are created alongside the primary cluster and
+	// made available to Run through TestCluster.AdditionalClusters.
+	// Connectivity between clusters is left to the test.
+	AdditionalClusters []ClusterSpec
+
+	// DestructiveHost marks tests which change the state of the host
+	// running kola, e.g. by loading kernel modules. They are only run
+	// with --include-host-destructive.
+	DestructiveHost bool
+
+	// ExclusiveCluster keeps the test from sharing a cluster with
+	// other tests when kola runs with --share-clusters, for tests which
+	// leave their machines unfit for others, e.g. by updating the OS or
+	// corrupting a disk.
+	ExclusiveCluster bool
+
+	// RequiredCapabilities lists platform features the test cannot run
+	// without; it is skipped on platforms lacking any of them.
+	RequiredCapabilities []platform.Capability
+
+	// RequiredPorts lists the TCP ports on the test's machines which
+	// the test connects to directly from kola, e.g. to reach a server
+	// it started. On GCE they are opened to kola's address by a
+	// firewall rule for the cluster, and on qemu kola checks that no
+	// netfilter rule of the cluster's network drops them. Ports used
+	// only between machines, or only by tests for qemu, need not be
+	// listed.
+	RequiredPorts []int
+
+	// MachineOptions sizes the machines of the test's primary cluster,
+	// e.g. with more memory or additional blank disks. Platforms which
+	// can't honor an option fail the test rather than ignore it.
+	MachineOptions platform.MachineOptions
+
+	// DestroyOrder is the order in which the machines of the test's
+	// clusters are destroyed when it ends, e.g.
+	// platform.DestroySimultaneous to kill them all at once for
+	// crash-consistency checks. Empty destroys them in the order they
+	// were created.
+	DestroyOrder platform.DestroyOrder
 
 	// MinVersion prevents the test from executing on CoreOS machines
 	// less than MinVersion. This will be ignored if the name fully
@@ -56,16 +179,62 @@ type Test struct {
 	// greater than or equal to EndVersion. This will be ignored if
 	// the name fully matches without globbing.
 	EndVersion semver.Version
+
+	// Timeout bounds creating the test's clusters and running it. A
+	// test still running after it fails, and its clusters are
+	// destroyed. Defaults to 10 minutes or kola's --test-timeout.
+	Timeout time.Duration
+
+	// ArtifactLimits overrides the run-wide caps on collected logs for
+	// legitimately verbose tests. Non-zero fields replace the run-wide
+	// value; a negative value removes the cap.
+	ArtifactLimits *platform.ArtifactLimits
+
+	// Env holds environment variables, e.g. PATH, set for every command
+	// the test runs on its machines through the TestCluster SSH helpers
+	// and RunNative. Values are quoted, so they may hold any text.
+	Env map[string]string
 }
 
-// Registered tests live here. Mapping of names to tests.
-var Tests = map[string]*Test{}
+// tests holds the registered tests by name.
+var tests = map[string]*Test{}
+
+// Tests holds the registered tests by name. Changes to them affect every
+// later run in the process.
+//
+// Deprecated: Tests will be unexported. Use Get and All, which return
+// copies.
+var Tests = tests
+
+// Get returns a copy of the test registered as name.
+func Get(name string) (*Test, bool) {
+	t, ok := tests[name]
+	if !ok {
+		return nil, false
+	}
+	return t.Copy(), true
+}
+
+// All returns copies of the registered tests by name.
+func All() map[string]*Test {
+	r := make(map[string]*Test, len(tests))
+	for name, t := range tests {
+		r[name] = t.Copy()
+	}
+	return r
+}
+
+// Unregister removes the test registered as name. It is for tests of the
+// harness, which register their own tests for as long as they run.
+func Unregister(name string) {
+	delete(tests, name)
+}
 
 // Register is usually called in init() functions and is how kola test
 // harnesses knows which tests it can choose from. Panics if existing
 // name is registered
 func Register(t *Test) {
-	_, ok := Tests[t.Name]
+	_, ok := tests[t.Name]
 	if ok {
 		panic(fmt.Sprintf("test %v already registered", t.Name))
 	}
@@ -74,7 +243,154 @@ func Register(t *Test) {
 		panic(fmt.Sprintf("test %v has an invalid version range", t.Name))
 	}
 
-	Tests[t.Name] = t
+	if t.UserData != nil && t.Intent != nil {
+		panic(fmt.Sprintf("test %v has both UserData and Intent", t.Name))
+	}
+	if t.UserDataFile != "" && (t.UserData != nil || t.Intent != nil) {
+		panic(fmt.Sprintf("test %v has UserDataFile and UserData or Intent", t.Name))
+	}
+
+	if t.EtcdVersion != "" {
+		if _, err := semver.NewVersion(strings.TrimPrefix(t.EtcdVersion, "v")); err != nil {
+			panic(fmt.Sprintf("test %v has an invalid EtcdVersion: %v", t.Name, err))
+		}
+		if t.UserData == nil && t.Intent == nil && t.UserDataFile == "" && len(t.MachineUserData) == 0 {
+			panic(fmt.Sprintf("test %v has EtcdVersion but no config", t.Name))
+		}
+		for _, userdata := range append([]*conf.UserData{t.UserData}, t.MachineUserData...) {
+			if userdata != nil && userdata.Contains("etcd2.service") {
+				panic(fmt.Sprintf("test %v has EtcdVersion but uses etcd2 instead of etcd-member", t.Name))
+			}
+		}
+	}
+
+	for name, fn := range t.NativeCalls {
+		if _, ok := t.NativeFuncs[name]; ok {
+			panic(fmt.Sprintf("test %v has native function %v in both NativeFuncs and NativeCalls", t.Name, name))
+		}
+		if err := native.CheckCall(fn); err != nil {
+			panic(fmt.Sprintf("test %v has invalid native call %v: %v", t.Name, name, err))
+		}
+	}
+
+	// unsupported options are up to the platforms
+	all := []string{platform.OptionMemory, platform.OptionCPUs, platform.OptionAdditionalDisks}
+	if err := t.MachineOptions.Check("", all...); err != nil {
+		panic(fmt.Sprintf("test %v has invalid MachineOptions: %v", t.Name, err))
+	}
+
+	for _, port := range t.RequiredPorts {
+		if port < 1 || port > 65535 {
+			panic(fmt.Sprintf("test %v requires invalid port %d", t.Name, port))
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, tag := range t.Tags {
+		if tag == "" {
+			panic(fmt.Sprintf("test %v has an empty tag", t.Name))
+		}
+		if seen[tag] {
+			panic(fmt.Sprintf("test %v has duplicate tag %v", t.Name, tag))
+		}
+		seen[tag] = true
+	}
+
+	if err := t.DestroyOrder.Valid(); err != nil {
+		panic(fmt.Sprintf("test %v: %v", t.Name, err))
+	}
+
+	switch t.AddressFamily {
+	case "", conf.FamilyIPv4, conf.FamilyIPv6, conf.FamilyHostname:
+	default:
+		panic(fmt.Sprintf("test %v has unknown AddressFamily %q", t.Name, t.AddressFamily))
+	}
+
+	if len(t.MachineUserData) > 0 && (t.Intent != nil || len(t.BootStages) > 0) {
+		panic(fmt.Sprintf("test %v has MachineUserData and Intent or BootStages", t.Name))
+	}
+
+	if t.ClusterSize > 0 && len(t.BootStages) > 0 {
+		panic(fmt.Sprintf("test %v has both ClusterSize and BootStages", t.Name))
+	}
+	stages := map[string]bool{}
+	for _, stage := range t.BootStages {
+		if stage.Name == "" || stage.Size <= 0 {
+			panic(fmt.Sprintf("test %v has a boot stage without a name or machines", t.Name))
+		}
+		if stages[stage.Name] {
+			panic(fmt.Sprintf("test %v has duplicate boot stage %v", t.Name, stage.Name))
+		}
+		stages[stage.Name] = true
+	}
+
+	names := map[string]bool{}
+	for _, spec := range t.AdditionalClusters {
+		if spec.Name == "" || spec.Platform == "" {
+			panic(fmt.Sprintf("test %v has an additional cluster without a name or platform", t.Name))
+		}
+		if names[spec.Name] {
+			panic(fmt.Sprintf("test %v has duplicate additional cluster %v", t.Name, spec.Name))
+		}
+		names[spec.Name] = true
+	}
+
+	tests[t.Name] = t
+}
+
+// Copy returns a deep copy of t which may be changed without affecting
+// t. UserData is shared since it is never changed in place.
+func (t *Test) Copy() *Test {
+	c := *t
+	c.Platforms = append([]string(nil), t.Platforms...)
+	c.ExcludePlatforms = append([]string(nil), t.ExcludePlatforms...)
+	c.Architectures = append([]string(nil), t.Architectures...)
+	c.Flags = append([]Flag(nil), t.Flags...)
+	c.Tags = append([]string(nil), t.Tags...)
+	c.MachineUserData = append([]*conf.UserData(nil), t.MachineUserData...)
+	c.BootStages = append([]BootStage(nil), t.BootStages...)
+	c.AdditionalClusters = append([]ClusterSpec(nil), t.AdditionalClusters...)
+	c.RequiredCapabilities = append([]platform.Capability(nil), t.RequiredCapabilities...)
+	c.RequiredPorts = append([]int(nil), t.RequiredPorts...)
+	c.MachineOptions = t.MachineOptions.Copy()
+	if t.NativeFuncs != nil {
+		c.NativeFuncs = make(map[string]func() error, len(t.NativeFuncs))
+		for k, v := range t.NativeFuncs {
+			c.NativeFuncs[k] = v
+		}
+	}
+	if t.NativeCalls != nil {
+		c.NativeCalls = make(map[string]interface{}, len(t.NativeCalls))
+		for k, v := range t.NativeCalls {
+			c.NativeCalls[k] = v
+		}
+	}
+	c.UserDataFiles = copyStringMap(t.UserDataFiles)
+	c.Env = copyStringMap(t.Env)
+	if t.Intent != nil {
+		c.Intent = t.Intent.Copy()
+	}
+	if t.ArtifactLimits != nil {
+		limits := *t.ArtifactLimits
+		c.ArtifactLimits = &limits
+	}
+	return &c
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// NeedsKolet reports whether the test's machines need kolet.
+func (t *Test) NeedsKolet() bool {
+	return len(t.NativeFuncs) > 0 || len(t.NativeCalls) > 0 || t.NativeLibrary
 }
 
 func (t *Test) HasFlag(flag Flag) bool {
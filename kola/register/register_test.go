@@ -0,0 +1,203 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package register
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+func copyTestTest() *Test {
+	return &Test{
+		Name:             "register.copy",
+		NativeFuncs:      map[string]func() error{"f": func() error { return nil }},
+		NativeCalls:      map[string]interface{}{"c": func(string) (int, error) { return 0, nil }},
+		Platforms:        []string{"qemu"},
+		ExcludePlatforms: []string{"gce"},
+		Architectures:    []string{"amd64"},
+		Flags:            []Flag{NoSSHKeyInUserData},
+		Intent: &conf.Intent{
+			Users: []conf.User{{Name: "core", Groups: []string{"docker"}, SSHAuthorizedKeys: []string{"key"}}},
+			Files: []conf.File{{Path: "/etc/motd", Contents: "hi", Mode: 0644}},
+			Units: []conf.Unit{{Name: "a.service", Enable: true}},
+		},
+		UserDataFiles:        map[string]string{"/etc/motd": "motd"},
+		MachineUserData:      []*conf.UserData{conf.CloudConfig("#cloud-config")},
+		BootStages:           []BootStage{{Name: "server", Size: 1}},
+		AdditionalClusters:   []ClusterSpec{{Name: "other", Platform: "gce", Size: 1}},
+		RequiredCapabilities: []platform.Capability{platform.CapReboot},
+		ArtifactLimits:       &platform.ArtifactLimits{Journal: 1},
+		Env:                  map[string]string{"PATH": "/bin"},
+		RequiredPorts:        []int{80},
+	}
+}
+
+func TestCopy(t *testing.T) {
+	orig := copyTestTest()
+	c := orig.Copy()
+
+	// functions can't be compared
+	if len(c.NativeFuncs) != 1 || c.NativeFuncs["f"] == nil {
+		t.Errorf("NativeFuncs not copied: %v", c.NativeFuncs)
+	}
+	if len(c.NativeCalls) != 1 || c.NativeCalls["c"] == nil {
+		t.Errorf("NativeCalls not copied: %v", c.NativeCalls)
+	}
+	origFuncs, copyFuncs := orig.NativeFuncs, c.NativeFuncs
+	origCalls, copyCalls := orig.NativeCalls, c.NativeCalls
+	orig.NativeFuncs, c.NativeFuncs = nil, nil
+	orig.NativeCalls, c.NativeCalls = nil, nil
+	if !reflect.DeepEqual(orig, c) {
+		t.Fatalf("copy %+v differs from %+v", c, orig)
+	}
+	orig.NativeFuncs, c.NativeFuncs = origFuncs, copyFuncs
+	orig.NativeCalls, c.NativeCalls = origCalls, copyCalls
+
+	c.NativeFuncs["g"] = nil
+	c.NativeCalls["d"] = nil
+	c.Platforms[0] = "aws"
+	c.ExcludePlatforms[0] = "aws"
+	c.Architectures[0] = "arm64"
+	c.Flags[0] = NoEnableSelinux
+	c.Intent.Users[0].Groups[0] = "wheel"
+	c.Intent.Users[0].SSHAuthorizedKeys[0] = "other"
+	c.Intent.Files[0].Contents = "changed"
+	c.Intent.Units[0].Enable = false
+	c.UserDataFiles["/etc/issue"] = "issue"
+	c.MachineUserData[0] = nil
+	c.BootStages[0].Size = 2
+	c.AdditionalClusters[0].Size = 2
+	c.RequiredCapabilities[0] = "other"
+	c.RequiredPorts[0] = 443
+	c.ArtifactLimits.Journal = 2
+	c.Env["PATH"] = "/usr/bin"
+
+	want := copyTestTest()
+	orig.NativeFuncs, want.NativeFuncs = nil, nil
+	orig.NativeCalls, want.NativeCalls = nil, nil
+	if !reflect.DeepEqual(orig, want) {
+		t.Errorf("changing the copy changed the original to %+v", orig)
+	}
+	if len(origFuncs) != 1 {
+		t.Errorf("changing the copy changed the original NativeFuncs to %v", origFuncs)
+	}
+	if len(origCalls) != 1 {
+		t.Errorf("changing the copy changed the original NativeCalls to %v", origCalls)
+	}
+}
+
+func TestRegisterNativeCalls(t *testing.T) {
+	for name, calls := range map[string]map[string]interface{}{
+		"register.calls.notfunc":  {"c": "call"},
+		"register.calls.noerror":  {"c": func(string) int { return 0 }},
+		"register.calls.twoargs":  {"c": func(string, string) (int, error) { return 0, nil }},
+		"register.calls.conflict": {"f": func(string) (int, error) { return 0, nil }},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s registered", name)
+					delete(tests, name)
+				}
+			}()
+			Register(&Test{
+				Name:        name,
+				NativeFuncs: map[string]func() error{"f": func() error { return nil }},
+				NativeCalls: calls,
+			})
+		}()
+	}
+}
+
+func TestRegisterRequiredPorts(t *testing.T) {
+	for _, port := range []int{0, -1, 65536} {
+		name := fmt.Sprintf("register.ports.%d", port)
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s registered", name)
+					delete(tests, name)
+				}
+			}()
+			Register(&Test{Name: name, RequiredPorts: []int{22, port}})
+		}()
+	}
+}
+
+func TestRegisterDestroyOrder(t *testing.T) {
+	Register(&Test{Name: "register.destroy", DestroyOrder: platform.DestroySimultaneous})
+	delete(tests, "register.destroy")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registered an unknown destroy order")
+			delete(tests, "register.destroy.unknown")
+		}
+	}()
+	Register(&Test{Name: "register.destroy.unknown", DestroyOrder: "sideways"})
+}
+
+func TestRegisterTags(t *testing.T) {
+	Register(&Test{Name: "register.tags", Tags: []string{"smoke", "etcd"}})
+	delete(tests, "register.tags")
+
+	for name, tags := range map[string][]string{
+		"register.tags.duplicate": {"smoke", "etcd", "smoke"},
+		"register.tags.empty":     {""},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s registered", name)
+					delete(tests, name)
+				}
+			}()
+			Register(&Test{Name: name, Tags: tags})
+		}()
+	}
+}
+
+func TestAccessorsCopy(t *testing.T) {
+	Register(&Test{Name: "register.accessors", Platforms: []string{"qemu"}})
+	defer delete(tests, "register.accessors")
+
+	got, ok := Get("register.accessors")
+	if !ok {
+		t.Fatal("registered test not found")
+	}
+	got.Platforms[0] = "gce"
+	All()["register.accessors"].Platforms[0] = "aws"
+
+	if p := tests["register.accessors"].Platforms; !reflect.DeepEqual(p, []string{"qemu"}) {
+		t.Errorf("registered test's platforms changed to %v", p)
+	}
+	if _, ok := Get("register.missing"); ok {
+		t.Error("found a test which was never registered")
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		Register(&Test{Name: "register.unregister"})
+		Unregister("register.unregister")
+	}
+	if _, ok := Get("register.unregister"); ok {
+		t.Error("unregistered test found")
+	}
+}
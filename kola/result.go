@@ -0,0 +1,171 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+// The exit statuses of kola run. Scripts depend on them, so they must
+// not be renumbered. If several apply, a usage error wins over the
+// deadline, which wins over failures, which win over leaks.
+const (
+	ExitPass     = 0 // every test passed or was skipped
+	ExitFailed   = 1 // a test failed, or the run itself did
+	ExitUsage    = 2 // the arguments or configuration are invalid; no test ran
+	ExitDeadline = 3 // --run-timeout expired before the tests finished
+	ExitInfra    = 4 // tests failed only because infrastructure did
+	ExitLeaked   = 5 // the tests passed but resources may have leaked
+)
+
+// exitResults names the exit statuses in the result line.
+var exitResults = map[int]string{
+	ExitPass:     "pass",
+	ExitFailed:   "fail",
+	ExitUsage:    "usage",
+	ExitDeadline: "deadline",
+	ExitInfra:    "infra",
+	ExitLeaked:   "leaked",
+}
+
+// RunResult is the outcome of a run. Its counts are of test runs on a
+// platform; failures on an ExperimentalPlatform aren't counted.
+type RunResult struct {
+	Passed  int
+	Failed  int
+	Skipped int
+
+	// Err is why the run failed other than by tests failing.
+	Err error
+
+	start    time.Time
+	ran      bool // the tests ran; errors before are usage errors
+	deadline bool // RunTimeout expired
+	infra    int  // failures only of the infrastructure
+	leaked   int  // runs which may have leaked resources
+
+	outputDir string // where the output of RunTests is
+
+	once   sync.Once
+	status int
+}
+
+// newRunResult returns the result of a run starting now.
+func newRunResult() *RunResult {
+	return &RunResult{start: time.Now()}
+}
+
+// UsageResult returns the result of a run which never started because
+// of err, an invalid argument or configuration.
+func UsageResult(err error) *RunResult {
+	r := newRunResult()
+	r.Err = err
+	return r
+}
+
+// Fail records err, found after the tests ran, as failing the run.
+func (r *RunResult) Fail(err error) {
+	if r.Err == nil {
+		r.Err = err
+	}
+}
+
+// exitStatus returns the status of the run.
+func (r *RunResult) exitStatus() int {
+	switch {
+	case !r.ran && r.Err != nil:
+		return ExitUsage
+	case r.deadline:
+		return ExitDeadline
+	case r.Failed > 0 && r.Failed == r.infra && r.Err == nil:
+		return ExitInfra
+	case r.Failed > 0 || r.Err != nil:
+		return ExitFailed
+	case r.leaked > 0:
+		return ExitLeaked
+	default:
+		return ExitPass
+	}
+}
+
+// Finish prints the result line of the run to w and returns the status
+// kola should exit with. The line is printed only by the first call;
+// later ones return the same status. A run which started is first
+// summed up with PASS or FAIL and its output directory, PASS only if the
+// status is ExitPass, so that the summary agrees with the status even
+// if the run failed after its tests, e.g. because of --strict.
+//
+// The line is the last kola prints to stdout and its format is stable:
+//
+//	kola: result=pass passed=12 failed=0 skipped=3 duration=812s
+//
+// result is one of pass, fail, usage, deadline, infra and leaked, after
+// the exit status.
+func (r *RunResult) Finish(w io.Writer) int {
+	r.once.Do(func() {
+		r.status = r.exitStatus()
+		if r.outputDir != "" {
+			verdict := "FAIL"
+			if r.status == ExitPass {
+				verdict = "PASS"
+			}
+			fmt.Fprintf(w, "%s, output in %v\n", verdict, r.outputDir)
+		}
+		fmt.Fprintf(w, "kola: result=%s passed=%d failed=%d skipped=%d duration=%.0fs\n",
+			exitResults[r.status], r.Passed, r.Failed, r.Skipped, time.Since(r.start).Seconds())
+	})
+	return r.status
+}
+
+// tallyReporter counts the results of a run's tests on each platform,
+// which are the tests annotated with their platform, into a RunResult.
+type tallyReporter struct {
+	mu     sync.Mutex
+	result *RunResult
+}
+
+func (r *tallyReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, annotations map[string]interface{}) {
+	if _, ok := annotations["platform"].(string); !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := annotations["leaked_resources"]; ok {
+		r.result.leaked++
+	}
+	switch result {
+	case testresult.Pass:
+		r.result.Passed++
+	case testresult.Skip:
+		r.result.Skipped++
+	case testresult.Fail:
+		if experimental, _ := annotations["experimental"].(bool); experimental {
+			return
+		}
+		r.result.Failed++
+		if category, _ := annotations["failure_category"].(string); category == FailureInfra {
+			r.result.infra++
+		}
+	}
+}
+
+func (r *tallyReporter) Output(path string) error               { return nil }
+func (r *tallyReporter) SetResult(result testresult.TestResult) {}
@@ -0,0 +1,173 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+// reported is a test result as a reporter sees it.
+type reported struct {
+	result      testresult.TestResult
+	annotations map[string]interface{}
+}
+
+var (
+	passed  = reported{testresult.Pass, map[string]interface{}{"platform": "qemu"}}
+	skipped = reported{testresult.Skip, map[string]interface{}{"platform": "qemu"}}
+	failed  = reported{testresult.Fail, map[string]interface{}{"platform": "qemu", "failure_category": FailureTest}}
+	infra   = reported{testresult.Fail, map[string]interface{}{"platform": "qemu", "failure_category": FailureInfra}}
+	leaked  = reported{testresult.Pass, map[string]interface{}{"platform": "gce", "leaked_resources": []string{"m: 503"}}}
+	// failures on experimental platforms and of parent tests aren't counted
+	experimentalFailed = reported{testresult.Fail, map[string]interface{}{"platform": "esx", "experimental": true}}
+	parentFailed       = reported{testresult.Fail, nil}
+)
+
+func TestRunResult(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		tests    []reported
+		notRun   bool
+		deadline bool
+		err      error
+		status   int
+		line     string
+	}{
+		{
+			desc:   "pass",
+			tests:  []reported{passed, passed, skipped, experimentalFailed, parentFailed},
+			status: ExitPass,
+			line:   "result=pass passed=2 failed=0 skipped=1",
+		},
+		{
+			desc:   "test failure",
+			tests:  []reported{passed, failed, infra},
+			status: ExitFailed,
+			line:   "result=fail passed=1 failed=2 skipped=0",
+		},
+		{
+			desc:   "run failure",
+			tests:  []reported{passed},
+			err:    errors.New("copying TAP file"),
+			status: ExitFailed,
+			line:   "result=fail passed=1 failed=0 skipped=0",
+		},
+		{
+			desc:   "usage",
+			notRun: true,
+			err:    errors.New("no such platform"),
+			status: ExitUsage,
+			line:   "result=usage passed=0 failed=0 skipped=0",
+		},
+		{
+			desc:     "deadline",
+			tests:    []reported{passed, failed},
+			deadline: true,
+			status:   ExitDeadline,
+			line:     "result=deadline passed=1 failed=1 skipped=0",
+		},
+		{
+			desc:   "infrastructure",
+			tests:  []reported{passed, infra, leaked},
+			status: ExitInfra,
+			line:   "result=infra passed=2 failed=1 skipped=0",
+		},
+		{
+			desc:   "infrastructure and run failure",
+			tests:  []reported{infra},
+			err:    errors.New("copying TAP file"),
+			status: ExitFailed,
+			line:   "result=fail passed=0 failed=1 skipped=0",
+		},
+		{
+			desc:   "leaked",
+			tests:  []reported{passed, leaked},
+			status: ExitLeaked,
+			line:   "result=leaked passed=2 failed=0 skipped=0",
+		},
+	} {
+		r := newRunResult()
+		r.ran = !tt.notRun
+		r.deadline = tt.deadline
+		r.Err = tt.err
+		tally := &tallyReporter{result: r}
+		for _, rep := range tt.tests {
+			tally.ReportTest("test", rep.result, 0, nil, rep.annotations)
+		}
+
+		var out bytes.Buffer
+		if status := r.Finish(&out); status != tt.status {
+			t.Errorf("%s: exit status %d, want %d", tt.desc, status, tt.status)
+		}
+		want := regexp.MustCompile("^kola: " + tt.line + ` duration=[0-9]+s\n$`)
+		if !want.Match(out.Bytes()) {
+			t.Errorf("%s: result line %q, want %q", tt.desc, out.String(), want)
+		}
+
+		// the line is printed once, and the status doesn't change
+		printed := out.Len()
+		r.Fail(errors.New("later"))
+		if status := r.Finish(&out); status != tt.status || out.Len() != printed {
+			t.Errorf("%s: second Finish returned %d and printed %q", tt.desc, status, out.String())
+		}
+	}
+}
+
+func TestRunResultVerdict(t *testing.T) {
+	for _, tt := range []struct {
+		tests   []reported
+		err     error
+		verdict string
+	}{
+		{[]reported{passed}, nil, "PASS"},
+		{[]reported{passed, failed}, nil, "FAIL"},
+		// tests which passed don't make a PASS of a run which failed
+		// or leaked after them
+		{[]reported{passed}, errors.New("--strict: 1 warnings or errors logged"), "FAIL"},
+		{[]reported{passed, leaked}, nil, "FAIL"},
+	} {
+		r := newRunResult()
+		r.ran = true
+		r.outputDir = "_kola_temp/qemu-latest"
+		r.Err = tt.err
+		tally := &tallyReporter{result: r}
+		for _, rep := range tt.tests {
+			tally.ReportTest("test", rep.result, 0, nil, rep.annotations)
+		}
+
+		var out bytes.Buffer
+		status := r.Finish(&out)
+		want := tt.verdict + ", output in _kola_temp/qemu-latest\nkola: result=" + exitResults[status] + " "
+		if !strings.HasPrefix(out.String(), want) {
+			t.Errorf("exit status %d printed %q, want %q...", status, out.String(), want)
+		}
+	}
+}
+
+func TestUsageResult(t *testing.T) {
+	var out bytes.Buffer
+	if status := UsageResult(errors.New("extra arguments")).Finish(&out); status != ExitUsage {
+		t.Errorf("exit status %d, want %d", status, ExitUsage)
+	}
+	if !bytes.HasPrefix(out.Bytes(), []byte("kola: result=usage ")) {
+		t.Errorf("result line %q", out.String())
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"google.golang.org/cloud/compute/metadata"
+
+	"github.com/coreos/mantle/auth"
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/storage"
+)
+
+var (
+	scratch     *cluster.Scratch
+	scratchOnce sync.Once
+)
+
+// testScratch returns the GCS scratch storage shared by the tests of
+// the run runID, in the GCE project, or nil if kola has no GCE
+// credentials it can use without prompting.
+func testScratch(runID string) *cluster.Scratch {
+	scratchOnce.Do(func() {
+		client, signer, err := scratchCredentials()
+		if err != nil {
+			plog.Warningf("GCS scratch storage disabled: %v", err)
+			return
+		}
+		if client != nil {
+			scratch = cluster.NewScratch(client, signer, GCEOptions.Project, runID)
+		}
+	})
+	return scratch
+}
+
+// scratchCredentials returns a client and a URL signer authorized by
+// the service account of --gce-json-key or, with --gce-service-auth,
+// that of the GCE instance kola runs on.
+func scratchCredentials() (*http.Client, storage.Signer, error) {
+	switch {
+	case GCEOptions.JSONKeyFile != "":
+		key, err := ioutil.ReadFile(GCEOptions.JSONKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		client, err := auth.GoogleClientFromJSONKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, err := storage.NewKeySigner(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, signer, nil
+	case GCEOptions.ServiceAuth:
+		email, err := metadata.Get("instance/service-accounts/default/email")
+		if err != nil {
+			return nil, nil, err
+		}
+		client := auth.GoogleServiceClient()
+		return client, storage.NewIAMSigner(client, email), nil
+	}
+	return nil, nil, nil
+}
+
+// destroyScratch deletes the run's scratch storage, if any was used.
+func destroyScratch() error {
+	if scratch == nil {
+		return nil
+	}
+	return scratch.Destroy(context.Background())
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// RegexPrefix marks a pattern as a regular expression rather than a glob.
+const RegexPrefix = "re:"
+
+// Selection selects tests by name: those matching any of its patterns
+// and none of its skip patterns. Patterns are globs as filepath.Match
+// takes them, or RE2 regular expressions if prefixed with RegexPrefix.
+// Both are case-sensitive and must match the whole name.
+type Selection struct {
+	patterns []namePattern
+	skips    []namePattern
+}
+
+// namePattern is a glob or regular expression matching test names.
+type namePattern struct {
+	text string
+	re   *regexp.Regexp // nil for globs
+}
+
+// NewSelection parses patterns, which select every test if empty, and
+// skips, returning an error naming the first invalid one. With regex,
+// every pattern is a regular expression, prefixed or not.
+func NewSelection(patterns, skips []string, regex bool) (*Selection, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
+		if regex {
+			patterns = []string{".*"}
+		}
+	}
+	s := &Selection{}
+	for _, text := range patterns {
+		p, err := parseNamePattern(text, regex)
+		if err != nil {
+			return nil, err
+		}
+		s.patterns = append(s.patterns, p)
+	}
+	for _, text := range skips {
+		p, err := parseNamePattern(text, regex)
+		if err != nil {
+			return nil, fmt.Errorf("--skip: %v", err)
+		}
+		s.skips = append(s.skips, p)
+	}
+	return s, nil
+}
+
+func parseNamePattern(text string, regex bool) (namePattern, error) {
+	if strings.HasPrefix(text, RegexPrefix) || regex {
+		expr := strings.TrimPrefix(text, RegexPrefix)
+		re, err := regexp.Compile(`^(?:` + expr + `)$`)
+		if err != nil {
+			return namePattern{}, fmt.Errorf("invalid regular expression %q: %v", expr, err)
+		}
+		return namePattern{text: text, re: re}, nil
+	}
+	if err := checkGlob(text); err != nil {
+		return namePattern{}, fmt.Errorf("invalid pattern %q: %v", text, err)
+	}
+	return namePattern{text: text}, nil
+}
+
+func (p namePattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	match, _ := filepath.Match(p.text, name)
+	return match
+}
+
+// Match reports whether the test named name is selected.
+func (s *Selection) Match(name string) bool {
+	for _, p := range s.skips {
+		if p.match(name) {
+			return false
+		}
+	}
+	for _, p := range s.patterns {
+		if p.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Names reports whether one of the patterns is exactly name, so that the
+// test was asked for by name rather than by a wildcard.
+func (s *Selection) Names(name string) bool {
+	for _, p := range s.patterns {
+		if p.re == nil && p.text == name {
+			return s.Match(name)
+		}
+	}
+	return false
+}
+
+// String returns the patterns as they were given, for messages.
+func (s *Selection) String() string {
+	var texts []string
+	for _, p := range s.patterns {
+		texts = append(texts, p.text)
+	}
+	str := strings.Join(texts, " ")
+	if len(s.skips) > 0 {
+		texts = nil
+		for _, p := range s.skips {
+			texts = append(texts, p.text)
+		}
+		str += " skipping " + strings.Join(texts, " ")
+	}
+	return str
+}
+
+// checkGlob returns filepath.ErrBadPattern if pattern is malformed, which
+// filepath.Match only reports when a name gets it as far as the error.
+//
+//	pattern:         { term }
+//	term:            '*' | '?' | '[' [ '^' ] { character-range } ']' | c | '\\' c
+//	character-range: c [ '-' c ]
+func checkGlob(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+			if i == len(pattern) {
+				return filepath.ErrBadPattern
+			}
+		case '[':
+			i++
+			if i < len(pattern) && pattern[i] == '^' {
+				i++
+			}
+			ranges := 0
+			for ; i < len(pattern) && pattern[i] != ']' || ranges == 0; ranges++ {
+				var err error
+				if i, err = checkClassChar(pattern, i); err != nil {
+					return err
+				}
+				if i < len(pattern) && pattern[i] == '-' {
+					if i, err = checkClassChar(pattern, i+1); err != nil {
+						return err
+					}
+				}
+			}
+			if i == len(pattern) {
+				return filepath.ErrBadPattern
+			}
+		}
+	}
+	return nil
+}
+
+// checkClassChar checks the character of a character range at i and
+// returns the index after it.
+func checkClassChar(pattern string, i int) (int, error) {
+	if i >= len(pattern) || pattern[i] == '-' || pattern[i] == ']' {
+		return 0, filepath.ErrBadPattern
+	}
+	if pattern[i] == '\\' {
+		i++
+		if i == len(pattern) {
+			return 0, filepath.ErrBadPattern
+		}
+	}
+	return i + 1, nil
+}
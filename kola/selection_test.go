@@ -0,0 +1,101 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelection(t *testing.T) {
+	names := []string{"coreos.basic", "coreos.cluster", "coreos.fleet.basic", "docker.network", "fleet.basic"}
+	for _, tc := range []struct {
+		patterns, skips []string
+		regex           bool
+		want            []string
+	}{
+		{nil, nil, false, names},
+		{nil, nil, true, names},
+		{[]string{"coreos.basic", "docker.*"}, nil, false, []string{"coreos.basic", "docker.network"}},
+		{nil, []string{"*fleet*"}, false, []string{"coreos.basic", "coreos.cluster", "docker.network"}},
+		{[]string{"coreos.*"}, []string{"re:.*\\.fleet\\..*"}, false, []string{"coreos.basic", "coreos.cluster"}},
+		{[]string{`re:coreos\.(basic|cluster)`}, nil, false, []string{"coreos.basic", "coreos.cluster"}},
+		{[]string{"basic"}, nil, true, nil},
+		{[]string{".*basic"}, nil, true, []string{"coreos.basic", "coreos.fleet.basic", "fleet.basic"}},
+		{[]string{"Coreos.*"}, nil, false, nil},
+		{[]string{"coreos"}, nil, false, nil},
+	} {
+		sel, err := NewSelection(tc.patterns, tc.skips, tc.regex)
+		if err != nil {
+			t.Errorf("%v skipping %v: %v", tc.patterns, tc.skips, err)
+			continue
+		}
+		var got []string
+		for _, name := range names {
+			if sel.Match(name) {
+				got = append(got, name)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v skipping %v (regex %v): selected %v, want %v", tc.patterns, tc.skips, tc.regex, got, tc.want)
+		}
+	}
+
+	sel, err := NewSelection([]string{"coreos.basic", "coreos.*", "re:docker.network"}, []string{"coreos.cluster"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sel.Names("coreos.basic") || sel.Names("coreos.fleet.basic") || sel.Names("docker.network") {
+		t.Error("Names reports the wrong tests as named")
+	}
+	if s := sel.String(); s != "coreos.basic coreos.* re:docker.network skipping coreos.cluster" {
+		t.Errorf("String() = %q", s)
+	}
+
+	for _, bad := range [][]string{
+		{"coreos.*", "["},
+		{"re:coreos.(basic"},
+	} {
+		if _, err := NewSelection(bad, nil, false); err == nil {
+			t.Errorf("%v accepted", bad)
+		}
+	}
+	if _, err := NewSelection(nil, []string{"[a-"}, false); err == nil {
+		t.Error("invalid skip pattern accepted")
+	}
+}
+
+func TestCheckGlob(t *testing.T) {
+	for pattern, valid := range map[string]bool{
+		"*":      true,
+		"a?c":    true,
+		`a\*`:    true,
+		"[abc]":  true,
+		"[^a-z]": true,
+		`[\]]`:   true,
+		"[":      false,
+		"[]":     false,
+		"[^]":    false,
+		"[]a]":   false,
+		"[-a]":   false,
+		"[a-]":   false,
+		"[a":     false,
+		`a\`:     false,
+	} {
+		if err := checkGlob(pattern); (err == nil) != valid {
+			t.Errorf("checkGlob(%q) = %v", pattern, err)
+		}
+	}
+}
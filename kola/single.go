@@ -0,0 +1,170 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/harness/reporters"
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+// Failure categories reported in Result.FailureCategory and in the
+// failure_category annotation of report.json.
+const (
+	FailureSetup = "setup" // creating the clusters or their machines failed
+	FailureTest  = "test"  // the test, or checks after it, failed
+	FailureInfra = "infra" // something the test depends on, such as a download, failed
+)
+
+// RunConfig configures RunSingle. Platform options are still taken from
+// the package variables such as QEMUOptions, as for RunTests.
+type RunConfig struct {
+	// OutputDir receives the same files as a `kola run` output
+	// directory. It is emptied first if it was created by a previous
+	// run.
+	OutputDir string
+
+	// ArtifactsDir and RunID place the test's artifacts as the
+	// package variables of the same names do for RunTests. If
+	// ArtifactsDir is empty, artifacts are written under OutputDir.
+	ArtifactsDir string
+	RunID        string
+
+	// Verbose prints the test's log to stdout as it finishes.
+	Verbose bool
+}
+
+// Result is the outcome of a test run by RunSingle.
+type Result struct {
+	Name            string
+	Platform        string
+	Status          testresult.TestResult
+	Duration        time.Duration // of the whole test, including setup
+	SetupDuration   time.Duration // creating clusters and machines
+	FailureCategory string        // one of the Failure constants if Status is Fail
+	Output          string        // the test's log
+	OutputDir       string        // the directory of this attempt at the test
+	Artifacts       []string      // files written to OutputDir by the test
+	Annotations     map[string]interface{}
+}
+
+// resultReporter records the harness result of a single test.
+type resultReporter struct {
+	mu     sync.Mutex
+	name   string
+	result *Result
+}
+
+func (r *resultReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, annotations map[string]interface{}) {
+	if name != r.name {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result = &Result{
+		Status:      result,
+		Duration:    duration,
+		Output:      string(b),
+		Annotations: annotations,
+	}
+	if d, ok := annotations["setup_duration"].(time.Duration); ok {
+		r.result.SetupDuration = d
+	}
+	if c, ok := annotations["failure_category"].(string); ok {
+		r.result.FailureCategory = c
+	}
+}
+
+func (r *resultReporter) Output(path string) error               { return nil }
+func (r *resultReporter) SetResult(result testresult.TestResult) {}
+
+// RunSingle runs the registered test name on pltfrm with the same cluster
+// lifecycle as RunTests and returns its result. A failing test is not an
+// error; the error is only set if the test could not be run at all.
+// Cancelling ctx cancels the test's Context but does not interrupt
+// platform operations in progress.
+func RunSingle(ctx context.Context, name, pltfrm string, cfg RunConfig) (Result, error) {
+	if err := loadTorcxManifest(); err != nil {
+		return Result{}, err
+	}
+
+	sel, err := NewSelection([]string{name}, nil, false)
+	if err != nil {
+		return Result{}, err
+	}
+	tests, versionStr, err := selectTests(sel, pltfrm, filepath.Join(cfg.OutputDir, "get_cluster_semver"))
+	if err != nil {
+		return Result{}, err
+	}
+	test, ok := tests[name]
+	if !ok {
+		return Result{}, fmt.Errorf("test %v is not registered or cannot run on %v", name, pltfrm)
+	}
+
+	rep := &resultReporter{name: name}
+	report := reporters.NewJSONReporter("report.json", pltfrm, versionStr)
+	report.Environment = runEnvironment()
+	opts := harness.Options{
+		OutputDir: cfg.OutputDir,
+		Parallel:  1,
+		Verbose:   cfg.Verbose,
+		Reporters: reporters.Reporters{
+			report,
+			rep,
+		},
+	}
+	suite := harness.NewSuite(opts, harness.Tests{
+		name: platformRunner(test, pltfrm, nil, artifactLayout{
+			root:  cfg.ArtifactsDir,
+			runID: cfg.RunID,
+		}.withDefaults(cfg.OutputDir)),
+	})
+	if err := suite.RunContext(ctx); err != nil && err != harness.SuiteFailed {
+		return Result{}, err
+	}
+	if rep.result == nil {
+		return Result{}, fmt.Errorf("test %v did not report a result", name)
+	}
+
+	result := *rep.result
+	result.Name = name
+	result.Platform = pltfrm
+	result.OutputDir, _ = result.Annotations["artifact_dir"].(string)
+	if result.OutputDir == "" {
+		// the test failed before it had one
+		return result, nil
+	}
+	err = filepath.Walk(result.OutputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			result.Artifacts = append(result.Artifacts, path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return result, fmt.Errorf("listing artifacts: %v", err)
+	}
+
+	return result, nil
+}
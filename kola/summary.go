@@ -0,0 +1,113 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+// failureCategories orders and describes the categories of the failure
+// summary; setup and test failures are debugged very differently.
+var failureCategories = []struct {
+	category string
+	desc     string
+}{
+	{FailureSetup, "Cluster setup failed"},
+	{FailureTest, "Test failed"},
+	{FailureInfra, "Infrastructure failed"},
+}
+
+// failureReporter collects the failed tests of a run, by failure
+// category, for the summary at its end. Failures on experimental
+// platforms are left to experimentalReporter.
+type failureReporter struct {
+	mu     sync.Mutex
+	failed map[string][]string // test descriptions by category
+	order  runOrder            // of the summary; by name if nil
+}
+
+func (r *failureReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, annotations map[string]interface{}) {
+	if result != testresult.Fail {
+		return
+	}
+	if experimental, _ := annotations["experimental"].(bool); experimental {
+		return
+	}
+	// only tests which ran on a cluster are categorized; their parents
+	// merely inherit the failure
+	category, ok := annotations["failure_category"].(string)
+	if !ok {
+		return
+	}
+	desc := name
+	if pltfrm, _ := annotations["platform"].(string); pltfrm != "" && !strings.Contains("/"+name+"/", "/"+pltfrm+"/") {
+		desc += " on " + pltfrm
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed == nil {
+		r.failed = make(map[string][]string)
+	}
+	r.failed[category] = append(r.failed[category], desc)
+}
+
+func (r *failureReporter) Output(path string) error               { return nil }
+func (r *failureReporter) SetResult(result testresult.TestResult) {}
+
+// excludedSummary lists the tests of excludedTests by platform, or
+// returns "" if there are none, so that tests a run left out because of
+// the platforms requested are not silently dropped.
+func excludedSummary(excluded map[string][]string, pltfrms []string) string {
+	var buf bytes.Buffer
+	for _, pltfrm := range pltfrms {
+		names := excluded[pltfrm]
+		if len(names) == 0 {
+			continue
+		}
+		if buf.Len() == 0 {
+			buf.WriteString("Skipped tests not supported on the platform:\n")
+		}
+		fmt.Fprintf(&buf, "  %s:\n\t%s\n", pltfrm, strings.Join(names, "\n\t"))
+	}
+	return buf.String()
+}
+
+// Summary returns the failed tests grouped by category, in the order
+// they ran, or "" if none failed.
+func (r *failureReporter) Summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.failed) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteString("Failed tests:\n")
+	for _, c := range failureCategories {
+		failed := append([]string(nil), r.failed[c.category]...)
+		if len(failed) == 0 {
+			continue
+		}
+		r.order.sort(failed)
+		fmt.Fprintf(&buf, "  %s:\n\t%s\n", c.desc, strings.Join(failed, "\n\t"))
+	}
+	return buf.String()
+}
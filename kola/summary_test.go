@@ -0,0 +1,86 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"testing"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+func TestFailureSummary(t *testing.T) {
+	r := &failureReporter{}
+	if s := r.Summary(); s != "" {
+		t.Errorf("summary without failures: %q", s)
+	}
+
+	report := func(name string, result testresult.TestResult, annotations map[string]interface{}) {
+		r.ReportTest(name, result, 0, nil, annotations)
+	}
+	report("b.test", testresult.Fail, map[string]interface{}{"platform": "qemu", "failure_category": FailureTest})
+	report("a.test/gce", testresult.Fail, map[string]interface{}{"platform": "gce", "failure_category": FailureTest})
+	report("c.test", testresult.Fail, map[string]interface{}{"platform": "qemu", "failure_category": FailureSetup})
+	// parents of failed tests, passes and experimental failures are left out
+	report("a.test", testresult.Fail, nil)
+	report("d.test", testresult.Pass, map[string]interface{}{"platform": "qemu"})
+	report("e.test", testresult.Fail, map[string]interface{}{"platform": "packet", "failure_category": FailureTest, "experimental": true})
+
+	want := `Failed tests:
+  Cluster setup failed:
+	c.test on qemu
+  Test failed:
+	a.test/gce
+	b.test on qemu
+`
+	if got := r.Summary(); got != want {
+		t.Errorf("got summary\n%s\nwant\n%s", got, want)
+	}
+
+	// shuffled runs list the failures in the order the tests ran
+	r.order = newRunOrder([]string{"b.test", "c.test", "a.test"})
+	report("a.test/aws", testresult.Fail, map[string]interface{}{"platform": "aws", "failure_category": FailureTest})
+	want = `Failed tests:
+  Cluster setup failed:
+	c.test on qemu
+  Test failed:
+	b.test on qemu
+	a.test/aws
+	a.test/gce
+`
+	if got := r.Summary(); got != want {
+		t.Errorf("got ordered summary\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestExcludedSummary(t *testing.T) {
+	if s := excludedSummary(map[string][]string{}, []string{"qemu"}); s != "" {
+		t.Errorf("summary without excluded tests: %q", s)
+	}
+
+	excluded := map[string][]string{
+		"gce":  {"coreos.ignition.qemu", "coreos.install"},
+		"qemu": {"coreos.gce.metadata"},
+	}
+	want := `Skipped tests not supported on the platform:
+  qemu:
+	coreos.gce.metadata
+  gce:
+	coreos.ignition.qemu
+	coreos.install
+`
+	if got := excludedSummary(excluded, []string{"qemu", "aws", "gce"}); got != want {
+		t.Errorf("got summary\n%s\nwant\n%s", got, want)
+	}
+}
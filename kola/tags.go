@@ -0,0 +1,75 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coreos/mantle/kola/register"
+)
+
+// tagsAllowed reports whether t is selected by Tags and SkipTags.
+func tagsAllowed(t *register.Test) bool {
+	for _, tag := range SkipTags {
+		if hasString(t.Tags, tag) {
+			return false
+		}
+	}
+	if len(Tags) == 0 {
+		return true
+	}
+	for _, tag := range Tags {
+		if hasString(t.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTags returns an error naming the first of Tags and SkipTags which
+// no test selected by sel has, since that is almost always a typo.
+func checkTags(sel *Selection) error {
+	if len(Tags) == 0 && len(SkipTags) == 0 {
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for _, t := range register.All() {
+		if !sel.Match(t.Name) {
+			continue
+		}
+		for _, tag := range t.Tags {
+			counts[tag]++
+		}
+	}
+
+	for _, tag := range append(append([]string(nil), Tags...), SkipTags...) {
+		if counts[tag] > 0 {
+			continue
+		}
+		var known []string
+		for tag := range counts {
+			known = append(known, tag)
+		}
+		sort.Strings(known)
+		if len(known) == 0 {
+			return fmt.Errorf("tag %q matches no tests; no tests matching %q have tags", tag, sel)
+		}
+		return fmt.Errorf("tag %q matches no tests matching %q; their tags are: %s", tag, sel, strings.Join(known, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/kola/register"
+)
+
+func TestTags(t *testing.T) {
+	registerTests(t,
+		&register.Test{Name: "kola.tags.smoke", Tags: []string{"smoke"}},
+		&register.Test{Name: "kola.tags.slow-etcd", Tags: []string{"slow", "etcd"}},
+		&register.Test{Name: "kola.tags.none"})
+	defer func() { Tags, SkipTags = nil, nil }()
+	sel, err := NewSelection([]string{"kola.tags.*"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		tags, skip []string
+		want       []string
+	}{
+		{nil, nil, []string{"kola.tags.none", "kola.tags.slow-etcd", "kola.tags.smoke"}},
+		{[]string{"smoke", "etcd"}, nil, []string{"kola.tags.slow-etcd", "kola.tags.smoke"}},
+		{nil, []string{"slow"}, []string{"kola.tags.none", "kola.tags.smoke"}},
+		{[]string{"etcd"}, []string{"slow"}, nil},
+	} {
+		Tags, SkipTags = tt.tags, tt.skip
+		if err := checkTags(sel); err != nil {
+			t.Errorf("tags %v skipping %v: %v", tt.tags, tt.skip, err)
+		}
+		entries, err := List(sel, []string{"qemu"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Name)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("tags %v skipping %v selected %v, want %v", tt.tags, tt.skip, got, tt.want)
+		}
+	}
+
+	Tags, SkipTags = []string{"smoke"}, []string{"slwo"}
+	if err := checkTags(sel); err == nil || !strings.Contains(err.Error(), `"slwo"`) || !strings.Contains(err.Error(), "etcd, slow, smoke") {
+		t.Errorf("misspelled tag returned %v", err)
+	}
+	Tags, SkipTags = []string{"etcd"}, nil
+	sel, err = NewSelection([]string{"kola.tags.smoke"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkTags(sel); err == nil {
+		t.Error("tag of no test matching the pattern accepted")
+	}
+}
@@ -30,6 +30,7 @@ func init() {
 		Name:        "coreos.basic",
 		Run:         LocalTests,
 		ClusterSize: 1,
+		Tags:        []string{"smoke"},
 		NativeFuncs: map[string]func() error{
 			"CloudConfig":      TestCloudinitCloudConfig,
 			"Script":           TestCloudinitScript,
@@ -24,7 +24,6 @@ import (
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/ssh"
 	"golang.org/x/net/context"
 
 	"github.com/coreos/mantle/kola/cluster"
@@ -318,8 +317,7 @@ func dockerNetwork(c cluster.TestCluster) {
 				break // socket is ready
 			}
 
-			exit, ok := err.(*ssh.ExitError)
-			if !ok || exit.Waitmsg.ExitStatus() != 1 { // 1 is the expected exit of grep -q
+			if status, ok := cluster.ExitStatus(err); !ok || status != 1 { // 1 is the expected exit of grep -q
 				return err
 			}
 
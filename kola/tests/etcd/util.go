@@ -18,7 +18,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -69,8 +68,8 @@ func setKeys(c cluster.TestCluster, n int) (map[string]string, error) {
 		for i := 0; i < n; i++ {
 			// random key and value, may overwrwite previous sets if
 			// collision which is fine
-			key := strconv.Itoa(rand.Int())[0:3]
-			value := strconv.Itoa(rand.Int())[0:3]
+			key := strconv.Itoa(c.Rand().Int())[0:3]
+			value := strconv.Itoa(c.Rand().Int())[0:3]
 
 			b, err := c.SSH(m, fmt.Sprintf("curl -s -w %%{http_code} -s http://127.0.0.1:2379/v2/keys/%v -XPUT -d value=%v", key, value))
 			if err != nil {
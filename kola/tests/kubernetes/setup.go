@@ -271,38 +271,10 @@ func runInstallScript(c cluster.TestCluster, m platform.Machine, script string,
 		c.Fatal(err)
 	}
 
-	// use client to collect stderr
-	client, err := m.SSHClient()
-	if err != nil {
-		c.Fatal(err)
-	}
-	defer client.Close()
-	session, err := client.NewSession()
-	if err != nil {
-		c.Fatal(err)
-	}
-	defer session.Close()
-
-	stderr := bytes.NewBuffer(nil)
-	session.Stderr = stderr
-
-	err = session.Start("sudo /home/core/install.sh")
-	if err != nil {
-		c.Fatal(err)
-	}
-
-	// timeout script to prevent it looping forever
-	errc := make(chan error)
-	go func() {
-		errc <- session.Wait()
-	}()
-	select {
-	case err := <-errc:
-		if err != nil {
-			c.Fatal(err)
-		}
-	case <-time.After(time.Minute * 7):
-		c.Fatal("Timed out waiting for install script to finish.")
+	// time out the script to prevent it looping forever
+	c.Timeouts.SSH = 7 * time.Minute
+	if _, stderr, err := c.SSHOutput(m, "sudo /home/core/install.sh"); err != nil {
+		c.Fatalf("%v: %s", err, stderr)
 	}
 }
 
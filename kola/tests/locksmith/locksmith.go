@@ -38,6 +38,8 @@ func init() {
 		Name:        "coreos.locksmith.cluster",
 		Run:         locksmithCluster,
 		ClusterSize: 3,
+		// rebooting the cluster alone may take 10 minutes
+		Timeout: 20 * time.Minute,
 		UserData: conf.Ignition(`{
   "ignition": { "version": "2.0.0" },
   "systemd": {
@@ -67,6 +69,8 @@ func init() {
 		Name:        "coreos.locksmith.cluster.etcd2",
 		Run:         locksmithCluster,
 		ClusterSize: 3,
+		// rebooting the cluster alone may take 10 minutes
+		Timeout: 20 * time.Minute,
 		UserData: conf.Ignition(`{
   "ignition": { "version": "2.0.0" },
   "systemd": {
@@ -0,0 +1,92 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+)
+
+func init() {
+	register.Register(&register.Test{
+		Run:                  MemoryBalloon,
+		ClusterSize:          1,
+		Name:                 "coreos.memory.balloon",
+		RequiredCapabilities: []platform.Capability{platform.CapMemoryBalloon},
+	})
+}
+
+// memTotalMiB returns MemTotal from /proc/meminfo.
+func memTotalMiB(c cluster.TestCluster, m platform.Machine) int {
+	out := c.MustSSH(m, "grep MemTotal /proc/meminfo")
+	fields := strings.Fields(string(out))
+	if len(fields) != 3 || fields[2] != "kB" {
+		c.Fatalf("unexpected meminfo line %q", out)
+	}
+	kB, err := strconv.Atoi(fields[1])
+	if err != nil {
+		c.Fatal(err)
+	}
+	return kB >> 10
+}
+
+// MemoryBalloon shrinks a running machine and checks that the kernel sees
+// less memory and the OOM killer acts on an allocation which would have
+// fit before.
+func MemoryBalloon(c cluster.TestCluster) {
+	m := c.Machines()[0]
+
+	before, err := platform.Memory(m)
+	if err != nil {
+		c.Fatal(err)
+	}
+	totalBefore := memTotalMiB(c, m)
+
+	target := before / 2
+	if target < platform.MinMemoryMiB {
+		target = platform.MinMemoryMiB
+	}
+	if err := platform.SetMemory(m, target); err != nil {
+		c.Fatal(err)
+	}
+	if after, err := platform.Memory(m); err != nil {
+		c.Fatal(err)
+	} else if after != target {
+		c.Fatalf("balloon reports %d MiB, expected %d MiB", after, target)
+	}
+
+	// the balloon driver takes its pages out of MemTotal
+	shrunk := totalBefore - memTotalMiB(c, m)
+	if want := before - target; shrunk < want*9/10 {
+		c.Fatalf("MemTotal shrank by %d MiB after ballooning out %d MiB", shrunk, want)
+	}
+
+	// tail buffers its whole input when it has no newlines; this fits
+	// in the original memory but not in the target
+	size := (before + target) / 2
+	cmd := "head -c " + strconv.Itoa(size) + "M /dev/zero | tail -n 1 >/dev/null"
+	if _, err := c.SSH(m, cmd); err == nil {
+		c.Fatalf("allocating %d MiB succeeded with %d MiB", size, target)
+	}
+	c.MustSSH(m, `dmesg | grep -qE 'Out of memory: Kill(ed)? process [0-9]+ \(tail\)'`)
+
+	if err := platform.SetMemory(m, platform.MinMemoryMiB-1); err == nil {
+		c.Fatalf("ballooning below %d MiB was allowed", platform.MinMemoryMiB)
+	}
+}
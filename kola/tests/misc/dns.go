@@ -0,0 +1,89 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/util"
+)
+
+func init() {
+	register.Register(&register.Test{
+		Run:                  DNSFailures,
+		ClusterSize:          0,
+		Name:                 "coreos.network.dns-failures",
+		RequiredCapabilities: []platform.Capability{platform.CapDNSFixture},
+	})
+}
+
+// DNSFailures checks that name resolution recovers once the DNS server
+// answers again after failing in each of the ways it can.
+func DNSFailures(c cluster.TestCluster) {
+	const name = "service.kola.test"
+
+	c.UseDNSFixture()
+	c.SetDNSHost(name, net.ParseIP("192.0.2.1"))
+	m, err := c.NewMachine(nil)
+	if err != nil {
+		c.Fatalf("Cluster.NewMachine: %s", err)
+	}
+
+	resolve := func() (string, error) {
+		out, err := c.SSH(m, "getent ahostsv4 "+name)
+		if err != nil {
+			return "", err
+		}
+		return strings.Fields(string(out))[0], nil
+	}
+	// the resolver may hold on to a failure for a moment
+	expect := func(addr string) {
+		err := util.Retry(10, time.Second, func() error {
+			got, err := resolve()
+			if err != nil {
+				return err
+			}
+			if got != addr {
+				return fmt.Errorf("%s resolved to %s, want %s", name, got, addr)
+			}
+			return nil
+		})
+		if err != nil {
+			c.Fatal(err)
+		}
+	}
+
+	expect("192.0.2.1")
+	c.SetDNSHost(name, net.ParseIP("192.0.2.2"))
+	expect("192.0.2.2")
+
+	for _, failure := range []platform.DNSFailure{platform.DNSNXDomain, platform.DNSServFail, platform.DNSTimeout} {
+		c.SetDNSFault(name, platform.DNSFault{Failure: failure})
+		if addr, err := resolve(); err == nil {
+			c.Fatalf("%s resolved to %s while the server answers %s", name, addr, failure)
+		}
+		c.SetDNSFault(name, platform.DNSFault{})
+		expect("192.0.2.2")
+	}
+
+	c.SetDNSFault(name, platform.DNSFault{Delay: 2 * time.Second})
+	expect("192.0.2.2")
+}
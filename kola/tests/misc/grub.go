@@ -148,21 +148,23 @@ coreos:
 
 func init() {
 	register.Register(&register.Test{
-		Run:           UpdateGrub,
-		ClusterSize:   1,
-		Name:          "coreos.update.grub",
-		UserData:      grubUpdaterConf,
-		MinVersion:    semver.Version{Major: 926},
-		EndVersion:    semver.Version{Major: 1745},
-		Architectures: []string{"amd64"},
+		Run:              UpdateGrub,
+		ClusterSize:      1,
+		Name:             "coreos.update.grub",
+		ExclusiveCluster: true,
+		UserData:         grubUpdaterConf,
+		MinVersion:       semver.Version{Major: 926},
+		EndVersion:       semver.Version{Major: 1745},
+		Architectures:    []string{"amd64"},
 	})
 	register.Register(&register.Test{
-		Run:           UpdateGrubNop,
-		ClusterSize:   1,
-		Name:          "coreos.update.grubnop",
-		UserData:      grubUpdaterConf,
-		MinVersion:    semver.Version{Major: 1745},
-		Architectures: []string{"amd64"},
+		Run:              UpdateGrubNop,
+		ClusterSize:      1,
+		Name:             "coreos.update.grubnop",
+		ExclusiveCluster: true,
+		UserData:         grubUpdaterConf,
+		MinVersion:       semver.Version{Major: 1745},
+		Architectures:    []string{"amd64"},
 	})
 }
 
@@ -17,6 +17,7 @@ package misc
 import (
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/coreos/mantle/kola/cluster"
 	"github.com/coreos/mantle/kola/register"
@@ -34,6 +35,11 @@ func init() {
 		Name:             "coreos.network.initramfs.second-boot",
 		ExcludePlatforms: []string{"do"},
 	})
+	register.Register(&register.Test{
+		Run:         NetworkdUnits,
+		ClusterSize: 1,
+		Name:        "coreos.network.networkd-units",
+	})
 }
 
 type listener struct {
@@ -136,3 +142,28 @@ func NetworkInitramfsSecondBoot(c cluster.TestCluster) {
 		c.Fatal("networkd started in initramfs")
 	}
 }
+
+// NetworkdUnits checks that units written after boot take effect when
+// networkd restarts, creating and configuring a dummy link.
+func NetworkdUnits(c cluster.TestCluster) {
+	m := c.Machines()[0]
+
+	c.WriteNetworkUnit(m, "50-kola0.netdev", `[NetDev]
+Name=kola0
+Kind=dummy
+`)
+	c.WriteNetworkUnit(m, "50-kola0.network", `[Match]
+Name=kola0
+
+[Network]
+Address=10.250.0.1/24
+`)
+	c.RestartNetworkd(m)
+	c.WaitNetworkState(m, "kola0", "routable", time.Minute)
+
+	// routable only needs some global address; check it is the unit's
+	out := c.MustSSH(m, "ip -4 -o addr show dev kola0")
+	if !strings.Contains(string(out), "10.250.0.1/24") {
+		c.Fatalf("kola0 doesn't have its address: %s", out)
+	}
+}
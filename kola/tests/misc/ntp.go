@@ -21,6 +21,7 @@ import (
 
 	"github.com/coreos/mantle/kola/cluster"
 	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform/machine/qemu"
 	"github.com/coreos/mantle/util"
 )
 
@@ -35,6 +36,13 @@ func init() {
 
 // Test that timesyncd starts using the local NTP server
 func NTP(c cluster.TestCluster) {
+	qc, ok := c.Cluster.(*qemu.Cluster)
+	if !ok {
+		c.Fatal("test only works in qemu")
+	}
+	// the cluster's subnet moves off 10.0.0.0/16 if the host uses it
+	server := qc.GatewayIP().String()
+
 	m, err := c.NewMachine(nil)
 	if err != nil {
 		c.Fatalf("Cluster.NewMachine: %s", err)
@@ -42,7 +50,7 @@ func NTP(c cluster.TestCluster) {
 	defer m.Destroy()
 
 	out := c.MustSSH(m, "networkctl status eth0")
-	if !bytes.Contains(out, []byte("NTP: 10.0.0.1")) {
+	if !bytes.Contains(out, []byte("NTP: "+server)) {
 		c.Fatalf("Bad network config:\n%s", out)
 	}
 
@@ -52,7 +60,8 @@ func NTP(c cluster.TestCluster) {
 			return fmt.Errorf("systemctl: %v", err)
 		}
 
-		if !bytes.Contains(out, []byte(`Status: "Synchronized to time server 10.0.0.1:123 (10.0.0.1)."`)) {
+		status := fmt.Sprintf(`Status: "Synchronized to time server %s:123 (%s)."`, server, server)
+		if !bytes.Contains(out, []byte(status)) {
 			return fmt.Errorf("unexpected systemd-timesyncd status: %v", out)
 		}
 
@@ -15,6 +15,7 @@
 package misc
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/coreos/go-omaha/omaha"
@@ -28,15 +29,18 @@ import (
 func init() {
 	register.Register(&register.Test{
 		Run:         OmahaPing,
-		ClusterSize: 1,
+		ClusterSize: 0,
 		Name:        "coreos.omaha.ping",
 		Platforms:   []string{"qemu"},
-		UserData: conf.ContainerLinuxConfig(`update:
-  server: "http://10.0.0.1:34567/v1/update/"
-`),
 	})
 }
 
+// omahaConfig points update_engine at the omaha fixture of the cluster,
+// listening on its gateway.
+const omahaConfig = `update:
+  server: "http://%s:34567/v1/update/"
+`
+
 type pingServer struct {
 	omaha.UpdaterStub
 
@@ -61,11 +65,14 @@ func OmahaPing(c cluster.TestCluster) {
 
 	omahaserver.Updater = svc
 
-	m := c.Machines()[0]
+	m, err := c.NewMachine(conf.ContainerLinuxConfig(fmt.Sprintf(omahaConfig, qc.GatewayIP())))
+	if err != nil {
+		c.Fatalf("Cluster.NewMachine: %s", err)
+	}
 
-	out, stderr, err := m.SSH("update_engine_client -check_for_update")
+	out, stderr, err := c.SSHOutput(m, "update_engine_client -check_for_update")
 	if err != nil {
-		c.Fatalf("couldn't check for update: %s, %s, %v", out, stderr, err)
+		c.Fatalf("couldn't check for update: %v: %s, %s", err, out, stderr)
 	}
 
 	tc := time.After(30 * time.Second)
@@ -21,7 +21,6 @@ import (
 	"github.com/coreos/mantle/kola/register"
 	"github.com/coreos/mantle/platform"
 	"github.com/coreos/mantle/platform/conf"
-	"github.com/coreos/mantle/platform/machine/qemu"
 )
 
 var (
@@ -65,10 +64,17 @@ func init() {
 		// disk doesn't have room for new partitions.
 		// TODO(ajeddeloh): change this to delete partition 9 and replace it with 9 and 10
 		// once Ignition supports it.
-		Run:         RootOnRaid,
-		ClusterSize: 0,
-		Platforms:   []string{"qemu"},
-		Name:        "coreos.disk.raid.root",
+		Run:                  RootOnRaid,
+		ClusterSize:          1,
+		Platforms:            []string{"qemu"},
+		RequiredCapabilities: []platform.Capability{platform.CapExtraDisks},
+		Name:                 "coreos.disk.raid.root",
+		UserData:             raidRootUserData,
+		MachineOptions: platform.MachineOptions{
+			AdditionalDisks: []platform.Disk{
+				{Size: "520M", Serial: "secondary"},
+			},
+		},
 	})
 	register.Register(&register.Test{
 		Run:         DataOnRaid,
@@ -103,20 +109,12 @@ systemd:
 }
 
 func RootOnRaid(c cluster.TestCluster) {
-	options := qemu.MachineOptions{
-		AdditionalDisks: []qemu.Disk{
-			{Size: "520M", Serial: "secondary"},
-		},
-	}
-	m, err := c.Cluster.(*qemu.Cluster).NewMachineWithOptions(raidRootUserData, options)
-	if err != nil {
-		c.Fatal(err)
-	}
+	m := c.Machines()[0]
 
 	checkIfMountpointIsRaid(c, m, "/")
 
 	// reboot it to make sure it comes up again
-	err = m.Reboot()
+	err := m.Reboot()
 	if err != nil {
 		c.Fatalf("could not reboot machine: %v", err)
 	}
@@ -21,10 +21,11 @@ import (
 
 func init() {
 	register.Register(&register.Test{
-		Run:         SelinuxEnforce,
-		ClusterSize: 1,
-		Name:        "coreos.selinux.enforce",
-		Flags:       []register.Flag{register.NoEnableSelinux},
+		Run:              SelinuxEnforce,
+		ClusterSize:      1,
+		Name:             "coreos.selinux.enforce",
+		ExclusiveCluster: true,
+		Flags:            []register.Flag{register.NoEnableSelinux},
 	})
 }
 
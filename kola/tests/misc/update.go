@@ -41,24 +41,27 @@ var (
 
 func init() {
 	register.Register(&register.Test{
-		Run:         RebootIntoUSRB,
-		ClusterSize: 1,
-		Name:        "coreos.update.reboot",
-		UserData:    disableUpdateEngine,
+		Run:              RebootIntoUSRB,
+		ClusterSize:      1,
+		Name:             "coreos.update.reboot",
+		ExclusiveCluster: true,
+		UserData:         disableUpdateEngine,
 	})
 	register.Register(&register.Test{
-		Run:         RecoverBadVerity,
-		ClusterSize: 1,
-		Name:        "coreos.update.badverity",
-		Flags:       []register.Flag{register.NoEmergencyShellCheck},
-		UserData:    disableUpdateEngine,
+		Run:              RecoverBadVerity,
+		ClusterSize:      1,
+		Name:             "coreos.update.badverity",
+		ExclusiveCluster: true,
+		Flags:            []register.Flag{register.NoEmergencyShellCheck},
+		UserData:         disableUpdateEngine,
 	})
 	register.Register(&register.Test{
-		Run:         RecoverBadUsr,
-		ClusterSize: 1,
-		Name:        "coreos.update.badusr",
-		Flags:       []register.Flag{register.NoEmergencyShellCheck},
-		UserData:    disableUpdateEngine,
+		Run:              RecoverBadUsr,
+		ClusterSize:      1,
+		Name:             "coreos.update.badusr",
+		ExclusiveCluster: true,
+		Flags:            []register.Flag{register.NoEmergencyShellCheck},
+		UserData:         disableUpdateEngine,
 	})
 }
 
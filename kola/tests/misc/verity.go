@@ -29,9 +29,10 @@ import (
 
 func init() {
 	register.Register(&register.Test{
-		Run:         Verity,
-		ClusterSize: 1,
-		Name:        "coreos.verity",
+		Run:              Verity,
+		ClusterSize:      1,
+		Name:             "coreos.verity",
+		ExclusiveCluster: true,
 	})
 }
 
@@ -73,16 +74,12 @@ func VerityCorruption(c cluster.TestCluster) {
 	// skip unless we are actually using verity
 	skipUnlessVerity(c, m)
 
-	// assert that dm shows verity is in use and the device is valid (V)
-	out := c.MustSSH(m, "sudo dmsetup --target verity status usr")
-
-	fields := strings.Fields(string(out))
-	if len(fields) != 4 {
-		c.Fatalf("failed checking dmsetup status of usr: not enough fields in output (got %d)", len(fields))
-	}
-
-	if fields[3] != "V" {
-		c.Fatalf("dmsetup status usr reports verity is not valid!")
+	// assert that verity is in use, the device is valid and /usr can't
+	// be written
+	c.AssertVerityActive(m)
+	c.AssertMountReadOnly(m, "/usr")
+	if c.Failed() {
+		c.FailNow()
 	}
 
 	// corrupt a file on disk and flush disk caches.
@@ -98,7 +95,7 @@ func VerityCorruption(c cluster.TestCluster) {
 	c.MustSSH(m, "sudo /bin/sh -c 'sync; echo -n 3 >/proc/sys/vm/drop_caches'")
 
 	// read the file back. if we can read it successfully, verity did not do its job.
-	out, stderr, err := m.SSH("cat /usr/lib/os-release")
+	_, stderr, err := c.SSHOutput(m, "cat /usr/lib/os-release")
 	if err == nil {
 		c.Fatalf("verity did not prevent reading a corrupted file!")
 	}
@@ -110,14 +107,12 @@ func VerityCorruption(c cluster.TestCluster) {
 	}
 
 	// assert that dm shows verity device is now corrupted (C)
-	out = c.MustSSH(m, "sudo dmsetup --target verity status usr")
-
-	fields = strings.Fields(string(out))
-	if len(fields) != 4 {
-		c.Fatalf("failed checking dmsetup status of usr: not enough fields in output (got %d)", len(fields))
+	out := c.MustSSH(m, "sudo dmsetup --target verity status usr")
+	valid, err := cluster.ParseDmsetupVerity(string(out))
+	if err != nil {
+		c.Fatalf("failed checking dmsetup status of usr: %v", err)
 	}
-
-	if fields[3] != "C" {
+	if valid {
 		c.Fatalf("dmsetup status usr reports verity is valid after corruption!")
 	}
 }
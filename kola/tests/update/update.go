@@ -34,9 +34,10 @@ import (
 
 func init() {
 	register.Register(&register.Test{
-		Name:        "coreos.update.payload",
-		Run:         payload,
-		ClusterSize: 1,
+		Name:             "coreos.update.payload",
+		ExclusiveCluster: true,
+		Run:              payload,
+		ClusterSize:      1,
 		NativeFuncs: map[string]func() error{
 			"Omaha": Serve,
 		},
@@ -147,15 +148,15 @@ EOF"`)
 func updateMachine(c cluster.TestCluster, m platform.Machine) {
 	c.Logf("Triggering update_engine")
 
-	out, stderr, err := m.SSH("update_engine_client -check_for_update")
+	out, stderr, err := c.SSHOutput(m, "update_engine_client -check_for_update")
 	if err != nil {
-		c.Fatalf("Executing update_engine_client failed: %v: %v: %s", out, err, stderr)
+		c.Fatalf("%v: %s: %s", err, out, stderr)
 	}
 
 	err = util.WaitUntilReady(120*time.Second, 10*time.Second, func() (bool, error) {
-		envs, stderr, err := m.SSH("update_engine_client -status 2>/dev/null")
+		envs, stderr, err := c.SSHOutput(m, "update_engine_client -status 2>/dev/null")
 		if err != nil {
-			return false, fmt.Errorf("checking status failed: %v: %s", err, stderr)
+			return false, fmt.Errorf("%v: %s", err, stderr)
 		}
 
 		return splitNewlineEnv(string(envs))["CURRENT_OP"] == "UPDATE_STATUS_UPDATED_NEED_REBOOT", nil
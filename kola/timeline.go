@@ -0,0 +1,72 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// timelineFile is written to a cluster's output directory with the
+// events of its machines.
+const timelineFile = "timeline.txt"
+
+// logTimeline writes the machine events of c to timeline.txt in dir
+// until c is destroyed, and logs machine deaths with logf, e.g. to the
+// test. The returned channel is closed once the last event is written;
+// the test must wait for it after destroying c.
+func logTimeline(logf func(format string, args ...interface{}), c platform.Cluster, dir string) <-chan struct{} {
+	events := c.Events()
+	done := make(chan struct{})
+
+	f, err := os.Create(filepath.Join(dir, timelineFile))
+	if err != nil {
+		logf("warning: no machine timeline: %v", err)
+		go func() {
+			defer close(done)
+			for range events {
+			}
+		}()
+		return done
+	}
+
+	go func() {
+		defer close(done)
+		defer f.Close()
+		for ev := range events {
+			if ev.Type == platform.MachineDied {
+				logf("warning: machine %v died: %s", ev.MachineID, ev.Detail)
+			}
+			writeTimelineEvent(f, ev)
+		}
+	}()
+	return done
+}
+
+func writeTimelineEvent(w io.Writer, ev platform.MachineEvent) {
+	if ev.Dropped > 0 {
+		fmt.Fprintf(w, "%s (%d events dropped)\n", ev.Time.UTC().Format(time.RFC3339Nano), ev.Dropped)
+	}
+	fmt.Fprintf(w, "%s %s %s", ev.Time.UTC().Format(time.RFC3339Nano), ev.MachineID, ev.Type)
+	if ev.Detail != "" {
+		fmt.Fprintf(w, ": %s", ev.Detail)
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,247 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/coreos/yaml"
+)
+
+// TriageRule recognizes a known cause of failures by its signatures in
+// a failed test's output, its machines' journals and their consoles.
+// It matches when each of its patterns, regular expressions of which at
+// least one must be set, matches.
+type TriageRule struct {
+	Name    string `yaml:"name"`
+	Error   string `yaml:"error"`   // matched against the test's output
+	Journal string `yaml:"journal"` // matched against any machine's journal
+	Console string `yaml:"console"` // matched against any machine's console
+	Hint    string `yaml:"hint"`    // what the match means to a triager
+	Link    string `yaml:"link"`    // a known issue, if any
+}
+
+// TriageHint is the hint of a TriageRule which matched a failure.
+type TriageHint struct {
+	Rule string `json:"rule"`
+	Hint string `json:"hint"`
+	Link string `json:"link,omitempty"`
+}
+
+func (h TriageHint) String() string {
+	if h.Link == "" {
+		return fmt.Sprintf("%s: %s", h.Rule, h.Hint)
+	}
+	return fmt.Sprintf("%s: %s (%s)", h.Rule, h.Hint, h.Link)
+}
+
+// triageTail is how much of the end of each log rules are matched
+// against. Go's regexps run in time linear in their input, so this
+// bounds the cost of triaging a failure however much was logged.
+const triageTail = 1 << 20
+
+// builtinTriageRules are the known causes of failures which aren't bugs
+// in the test or the OS.
+var builtinTriageRules = []TriageRule{
+	{
+		Name:    "clock-jump",
+		Error:   `connection reset|handshake failed`,
+		Journal: `[Cc]lock skew|Time has been changed`,
+		Hint:    "the machine's clock jumped, as after a test set the RTC; SSH failures follow from it",
+	},
+	{
+		Name:  "discovery-outage",
+		Error: `[Dd]iscovery (endpoint|service)|discovery\.etcd\.io`,
+		Hint:  "the etcd discovery service failed; retry before looking further",
+	},
+	{
+		Name:    "ssh-keys-missing",
+		Error:   `unable to authenticate`,
+		Journal: `ignition.*(failed|error)|coreos-cloudinit.*[Ff]ailed`,
+		Hint:    "SSH keys from the config weren't installed because provisioning failed; see the journal",
+	},
+	{
+		Name:    "disk-full",
+		Journal: `No space left on device`,
+		Hint:    "a filesystem of the machine filled up",
+	},
+	{
+		Name:    "oom",
+		Console: `Out of memory: Kill(ed)? process`,
+		Hint:    "the kernel killed a process for lack of memory; the machine may be too small for the test",
+	},
+}
+
+type triageRule struct {
+	TriageRule
+	error, journal, console *regexp.Regexp
+}
+
+// triageRules are the rules applied to failures, set by
+// loadTriageRules.
+var triageRules = mustCompileTriageRules(builtinTriageRules)
+
+// compileTriageRules compiles the patterns of rules.
+func compileTriageRules(rules []TriageRule) ([]triageRule, error) {
+	var compiled []triageRule
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("triage rule %d has no name", i)
+		}
+		if r.Hint == "" {
+			return nil, fmt.Errorf("triage rule %s has no hint", r.Name)
+		}
+		if r.Error == "" && r.Journal == "" && r.Console == "" {
+			return nil, fmt.Errorf("triage rule %s has no patterns", r.Name)
+		}
+		c := triageRule{TriageRule: r}
+		for _, p := range []struct {
+			re      **regexp.Regexp
+			pattern string
+		}{
+			{&c.error, r.Error},
+			{&c.journal, r.Journal},
+			{&c.console, r.Console},
+		} {
+			if p.pattern == "" {
+				continue
+			}
+			re, err := regexp.Compile(p.pattern)
+			if err != nil {
+				return nil, fmt.Errorf("triage rule %s: %v", r.Name, err)
+			}
+			*p.re = re
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func mustCompileTriageRules(rules []TriageRule) []triageRule {
+	compiled, err := compileTriageRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// loadTriageRules adds the rules in the YAML file TriageRulesFile, if
+// set, to the built-in ones.
+func loadTriageRules() error {
+	if TriageRulesFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(TriageRulesFile)
+	if err != nil {
+		return fmt.Errorf("reading triage rules: %v", err)
+	}
+	var rules []TriageRule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("parsing triage rules %s: %v", TriageRulesFile, err)
+	}
+	compiled, err := compileTriageRules(append(append([]TriageRule(nil), builtinTriageRules...), rules...))
+	if err != nil {
+		return fmt.Errorf("%s: %v", TriageRulesFile, err)
+	}
+	triageRules = compiled
+	return nil
+}
+
+// triageLogs are the logs of a failed test which rules are matched
+// against, each no longer than triageTail.
+type triageLogs struct {
+	output   []byte
+	journals [][]byte
+	consoles [][]byte
+}
+
+// readTriageLogs reads the journals and consoles of the machines whose
+// artifacts are under dir.
+func readTriageLogs(output []byte, dir string) triageLogs {
+	logs := triageLogs{output: tail(output, triageTail)}
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		var dest *[][]byte
+		switch info.Name() {
+		case "journal.txt", "journalctl.txt":
+			dest = &logs.journals
+		case "console.txt":
+			dest = &logs.consoles
+		default:
+			return nil
+		}
+		if b, err := readTail(path, triageTail); err == nil {
+			*dest = append(*dest, b)
+		}
+		return nil
+	})
+	return logs
+}
+
+// triage returns the hints of the rules which match logs.
+func triage(rules []triageRule, logs triageLogs) []TriageHint {
+	var hints []TriageHint
+	for _, r := range rules {
+		if r.error != nil && !r.error.Match(logs.output) {
+			continue
+		}
+		if r.journal != nil && !anyMatch(r.journal, logs.journals) {
+			continue
+		}
+		if r.console != nil && !anyMatch(r.console, logs.consoles) {
+			continue
+		}
+		hints = append(hints, TriageHint{Rule: r.Name, Hint: r.Hint, Link: r.Link})
+	}
+	return hints
+}
+
+func anyMatch(re *regexp.Regexp, logs [][]byte) bool {
+	for _, l := range logs {
+		if re.Match(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func tail(b []byte, n int) []byte {
+	if len(b) > n {
+		return b[len(b)-n:]
+	}
+	return b
+}
+
+// readTail reads at most the last n bytes of the file at path.
+func readTail(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() > n {
+		if _, err := f.Seek(-n, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+	return ioutil.ReadAll(io.LimitReader(f, n))
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTriage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-triage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for path, data := range map[string]string{
+		"m1/journal.txt":       "systemd-timesyncd: System clock wrong, clock skew detected\n",
+		"m1/console.txt":       "login:\n",
+		"extra/m2/console.txt": "Out of memory: Killed process 1234 (stress)\n",
+	} {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := []byte("ssh: handshake failed: read tcp: connection reset by peer\n")
+	hints := triage(triageRules, readTriageLogs(output, dir))
+	var got []string
+	for _, h := range hints {
+		got = append(got, h.Rule)
+	}
+	if want := []string{"clock-jump", "oom"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got hints %v, want %v", got, want)
+	}
+
+	// the journal alone isn't enough
+	if hints := triage(triageRules, readTriageLogs([]byte("timed out"), dir)); len(hints) != 1 {
+		t.Errorf("got hints %v, want only oom", hints)
+	}
+}
+
+func TestTriageTail(t *testing.T) {
+	rules, err := compileTriageRules([]TriageRule{{Name: "early", Error: "^start", Hint: "h"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := append([]byte("start\n"), bytes.Repeat([]byte("x"), triageTail)...)
+	if hints := triage(rules, readTriageLogs(output, "")); len(hints) != 0 {
+		t.Errorf("matched output beyond the tail: %v", hints)
+	}
+}
+
+func TestLoadTriageRules(t *testing.T) {
+	defer func(file string, rules []triageRule) {
+		TriageRulesFile, triageRules = file, rules
+	}(TriageRulesFile, triageRules)
+
+	f, err := ioutil.TempFile("", "kola-triage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	TriageRulesFile = f.Name()
+
+	if _, err := f.WriteString(`
+- name: rtc
+  error: connection reset
+  journal: rtc_cmos.*setting system clock
+  hint: the RTC test polluted the machine
+  link: https://example.com/issues/1
+`); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTriageRules(); err != nil {
+		t.Fatal(err)
+	}
+	if len(triageRules) != len(builtinTriageRules)+1 {
+		t.Fatalf("got %d rules, want %d", len(triageRules), len(builtinTriageRules)+1)
+	}
+	if r := triageRules[len(triageRules)-1]; r.Link != "https://example.com/issues/1" || r.journal == nil {
+		t.Errorf("loaded %+v", r)
+	}
+
+	for _, bad := range []string{
+		"- name: nohint\n  error: x\n",
+		"- name: nopattern\n  hint: h\n",
+		"- name: badre\n  error: '('\n  hint: h\n",
+		"- [not, a, rule\n",
+	} {
+		if err := ioutil.WriteFile(f.Name(), []byte(bad), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadTriageRules(); err == nil {
+			t.Errorf("loaded %q", bad)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+	"github.com/coreos/mantle/platform"
+)
+
+// clockTicks is USER_HZ, the unit of the CPU times in /proc/<pid>/stat,
+// which is 100 on every architecture kola runs on.
+const clockTicks = 100
+
+// topUsers is how many tests the summary of resource usage lists.
+const topUsers = 5
+
+// machineUsage is the host resources used by the process of a machine.
+type machineUsage struct {
+	CPUSeconds float64 `json:"cpu_seconds"`
+	PeakRSS    int64   `json:"peak_rss_bytes"`
+}
+
+// testUsage is the host resources used by the machines of a test's
+// clusters which run as host processes.
+type testUsage struct {
+	CPUSeconds float64                 `json:"cpu_seconds"`    // of all machines
+	PeakRSS    int64                   `json:"peak_rss_bytes"` // of the machines together
+	Machines   map[string]machineUsage `json:"machines"`
+}
+
+// parseProcStat returns the CPU time and resident set size recorded in
+// the contents of /proc/<pid>/stat.
+func parseProcStat(stat []byte) (time.Duration, int64, error) {
+	// pid (comm) state ...; comm may contain anything
+	var fields []string
+	if i := bytes.LastIndexByte(stat, ')'); i >= 0 {
+		fields = strings.Fields(string(stat[i+1:]))
+	}
+	// utime and stime are the 14th and 15th fields, rss the 24th; the
+	// state is the 3rd
+	if len(fields) < 22 {
+		return 0, 0, fmt.Errorf("parsing stat: %q", stat)
+	}
+	var ticks [2]int64
+	for i, f := range fields[11:13] {
+		var err error
+		if ticks[i], err = strconv.ParseInt(f, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("parsing stat: %v", err)
+		}
+	}
+	pages, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parsing stat: %v", err)
+	}
+	cpu := time.Duration(ticks[0]+ticks[1]) * time.Second / clockTicks
+	return cpu, pages * int64(os.Getpagesize()), nil
+}
+
+// usageSampler periodically measures the host processes of the machines
+// of a test's clusters. The last sample of a machine whose process has
+// exited is kept.
+type usageSampler struct {
+	stop chan struct{}
+	done chan struct{}
+
+	mu       sync.Mutex
+	clusters []platform.Cluster
+	usage    testUsage
+}
+
+// sampleUsage starts sampling every interval.
+func sampleUsage(interval time.Duration) *usageSampler {
+	s := &usageSampler{
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		usage: testUsage{Machines: make(map[string]machineUsage)},
+	}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sample()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// watch adds the machines of c to those sampled.
+func (s *usageSampler) watch(c platform.Cluster) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clusters = append(s.clusters, c)
+}
+
+func (s *usageSampler) sample() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rss int64
+	for _, c := range s.clusters {
+		for _, m := range c.Machines() {
+			p, ok := m.(platform.HostProcess)
+			if !ok {
+				continue
+			}
+			stat, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(p.Pid()), "stat"))
+			if err != nil {
+				// exited since Machines
+				continue
+			}
+			cpu, mrss, err := parseProcStat(stat)
+			if err != nil {
+				plog.Debugf("Sampling machine %s: %v", m.ID(), err)
+				continue
+			}
+			u := s.usage.Machines[m.ID()]
+			u.CPUSeconds = cpu.Seconds()
+			if mrss > u.PeakRSS {
+				u.PeakRSS = mrss
+			}
+			s.usage.Machines[m.ID()] = u
+			rss += mrss
+		}
+	}
+	if rss > s.usage.PeakRSS {
+		s.usage.PeakRSS = rss
+	}
+	s.usage.CPUSeconds = 0
+	for _, u := range s.usage.Machines {
+		s.usage.CPUSeconds += u.CPUSeconds
+	}
+}
+
+// Stop takes a last sample, stops sampling and returns the usage of the
+// machines, which is empty if none ran as host processes.
+func (s *usageSampler) Stop() testUsage {
+	close(s.stop)
+	<-s.done
+	s.sample()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage
+}
+
+// usageReporter collects the resource usage of the tests of a run, for
+// the summary at its end.
+type usageReporter struct {
+	mu    sync.Mutex
+	tests []usedBy
+}
+
+type usedBy struct {
+	desc  string
+	usage testUsage
+}
+
+func (r *usageReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte, annotations map[string]interface{}) {
+	usage, ok := annotations["resources"].(testUsage)
+	if !ok {
+		return
+	}
+	desc := name
+	if pltfrm, _ := annotations["platform"].(string); pltfrm != "" && !strings.Contains("/"+name+"/", "/"+pltfrm+"/") {
+		desc += " on " + pltfrm
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tests = append(r.tests, usedBy{desc, usage})
+}
+
+func (r *usageReporter) Output(path string) error               { return nil }
+func (r *usageReporter) SetResult(result testresult.TestResult) {}
+
+// Summary returns the tests whose machines used the most CPU time, or ""
+// if no test's usage was sampled.
+func (r *usageReporter) Summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.tests) == 0 {
+		return ""
+	}
+	tests := append([]usedBy(nil), r.tests...)
+	sort.SliceStable(tests, func(i, j int) bool {
+		return tests[i].usage.CPUSeconds > tests[j].usage.CPUSeconds
+	})
+	if len(tests) > topUsers {
+		tests = tests[:topUsers]
+	}
+	var buf bytes.Buffer
+	buf.WriteString("Most host CPU used by machines:\n")
+	for _, t := range tests {
+		fmt.Fprintf(&buf, "\t%s: %.0fs CPU, %.0f MiB peak RSS\n",
+			t.desc, t.usage.CPUSeconds, float64(t.usage.PeakRSS)/(1<<20))
+	}
+	return buf.String()
+}
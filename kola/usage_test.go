@@ -0,0 +1,105 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+	"github.com/coreos/mantle/platform"
+)
+
+func TestParseProcStat(t *testing.T) {
+	stat := "4242 (qemu (x) 1) S 1 4242 4242 0 -1 4194560 81729 0 0 0 1234 766 0 0 20 0 5 0 1000 2147483648 3000 18446744073709551615 1 1 0 0 0 0 0 4096 0 0 0 0 17 3 0 0 0 0 0\n"
+	cpu, rss, err := parseProcStat([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu != 20*time.Second {
+		t.Errorf("CPU time %v, want 20s", cpu)
+	}
+	if want := int64(3000 * os.Getpagesize()); rss != want {
+		t.Errorf("RSS %d, want %d", rss, want)
+	}
+
+	for _, bad := range []string{"", "4242 (qemu) S 1 2 3", "4242 (qemu) S 1 4242 4242 0 -1 4194560 81729 0 0 0 x 766 0 0 20 0 5 0 1000 2147483648 3000"} {
+		if _, _, err := parseProcStat([]byte(bad)); err == nil {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
+
+type processMachine struct {
+	platform.Machine
+	id  string
+	pid int
+}
+
+func (m *processMachine) ID() string { return m.id }
+func (m *processMachine) Pid() int   { return m.pid }
+
+type processCluster struct {
+	platform.Cluster
+	machines []platform.Machine
+}
+
+func (c *processCluster) Machines() []platform.Machine { return c.machines }
+
+func TestUsageSampler(t *testing.T) {
+	s := sampleUsage(time.Hour)
+	s.watch(&processCluster{machines: []platform.Machine{
+		&processMachine{id: "self", pid: os.Getpid()},
+		&processMachine{id: "gone", pid: -1},
+	}})
+	u := s.Stop()
+	if _, ok := u.Machines["gone"]; ok {
+		t.Error("sampled a machine without a process")
+	}
+	self, ok := u.Machines["self"]
+	if !ok || self.PeakRSS == 0 {
+		t.Fatalf("did not sample a running process: %+v", u)
+	}
+	if u.PeakRSS != self.PeakRSS || u.CPUSeconds != self.CPUSeconds {
+		t.Errorf("test usage %+v differs from its only machine's %+v", u, self)
+	}
+}
+
+func TestUsageSummary(t *testing.T) {
+	var r usageReporter
+	if s := r.Summary(); s != "" {
+		t.Errorf("summary without samples: %q", s)
+	}
+	for i, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		r.ReportTest(name, testresult.Pass, 0, nil, map[string]interface{}{
+			"platform":  "qemu",
+			"resources": testUsage{CPUSeconds: float64(i), PeakRSS: 1 << 20, Machines: map[string]machineUsage{"m": {}}},
+		})
+	}
+	r.ReportTest("unsampled", testresult.Pass, 0, nil, nil)
+
+	lines := strings.Split(strings.TrimSpace(r.Summary()), "\n")
+	if len(lines) != 1+topUsers {
+		t.Fatalf("summary lists %d lines:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	if want := "\tf on qemu: 5s CPU, 1 MiB peak RSS"; lines[1] != want {
+		t.Errorf("top user %q, want %q", lines[1], want)
+	}
+	if !strings.HasPrefix(lines[topUsers], "\tb on qemu") {
+		t.Errorf("last listed %q, want b", lines[topUsers])
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"net/http"
+	"time"
+)
+
+// LogRequests returns a copy of client, or of http.DefaultClient if nil,
+// which logs every request with logf: its method and URL, without the
+// query which may hold credentials, and the response status or error
+// and how long it took.
+func LogRequests(client *http.Client, logf func(format string, args ...interface{})) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	logged := *client
+	logged.Transport = &loggingTransport{base: client.Transport, logf: logf}
+	return &logged
+}
+
+type loggingTransport struct {
+	base http.RoundTripper
+	logf func(format string, args ...interface{})
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	u := *req.URL
+	u.RawQuery = ""
+	u.User = nil
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		t.logf("%s %s: %v (%v)", req.Method, u.String(), err, elapsed)
+	} else {
+		t.logf("%s %s: %s (%v)", req.Method, u.String(), resp.Status, elapsed)
+	}
+	return resp, err
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	var lines []string
+	client := LogRequests(nil, func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	})
+	if client == http.DefaultClient || http.DefaultClient.Transport != nil {
+		t.Fatal("http.DefaultClient modified")
+	}
+	resp, err := client.Get(srv.URL + "/v1/instances?key=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, err := client.Get("http://127.0.0.1:0/"); err == nil {
+		t.Fatal("request to port 0 succeeded")
+	}
+
+	if len(lines) != 2 {
+		t.Fatalf("logged %q", lines)
+	}
+	if want := "GET " + srv.URL + "/v1/instances: 404 Not Found ("; !strings.HasPrefix(lines[0], want) {
+		t.Errorf("logged %q, want %q...", lines[0], want)
+	}
+	if strings.Contains(strings.Join(lines, "\n"), "secret") {
+		t.Errorf("query logged: %q", lines)
+	}
+	if !strings.HasPrefix(lines[1], "GET http://127.0.0.1:0/: ") {
+		t.Errorf("failed request logged as %q", lines[1])
+	}
+}
@@ -29,6 +29,7 @@ type Recorder struct {
 	formatter Formatter
 	cursor    string
 	status    chan error
+	done      chan struct{} // closed when the current recording ends
 	rawFile   io.WriteCloser
 }
 
@@ -97,6 +98,8 @@ func (r *Recorder) StartSSH(ctx context.Context, client *ssh.Client) error {
 		return err
 	}
 
+	done := make(chan struct{})
+	r.done = done
 	go func() {
 		err := r.record(export)
 		cancel()
@@ -108,6 +111,7 @@ func (r *Recorder) StartSSH(ctx context.Context, client *ssh.Client) error {
 		if err == nil && err2 != nil {
 			err = err2
 		}
+		close(done)
 		r.status <- err
 	}()
 
@@ -128,18 +132,32 @@ func (r *Recorder) StartLocal(ctx context.Context) error {
 		return err
 	}
 
+	done := make(chan struct{})
+	r.done = done
 	go func() {
 		err := r.record(export)
 		err2 := journal.Wait()
 		if err == nil && err2 != nil {
 			err = err2
 		}
+		close(done)
 		r.status <- err
 	}()
 
 	return nil
 }
 
+// Stopped reports whether the recording last started has ended, e.g.
+// because its SSH connection was lost, without waiting for it.
+func (r *Recorder) Stopped() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
 func (r *Recorder) Wait() error {
 	return <-r.status
 }
@@ -139,3 +139,38 @@ func TestRecorderSSHCancel(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRecorderStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	hang := mockssh.NewMockClient(func(s *mockssh.Session) {})
+
+	recorder := NewRecorder(nullFormatter{}, discardCloser{})
+	if recorder.Stopped() {
+		t.Error("stopped before starting")
+	}
+	if err := recorder.StartSSH(ctx, hang); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Stopped() {
+		t.Error("stopped while recording")
+	}
+	cancel()
+	if err := recorder.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !recorder.Stopped() {
+		t.Error("not stopped once canceled")
+	}
+
+	// a new recording, whose connection is lost
+	lost := mockssh.NewMockClient(func(s *mockssh.Session) {
+		s.Close()
+	})
+	if err := recorder.StartSSH(context.Background(), lost); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Wait()
+	if !recorder.Stopped() {
+		t.Error("not stopped once the connection was lost")
+	}
+}
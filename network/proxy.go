@@ -0,0 +1,97 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// proxyVars are the environment variables selecting proxies, upper case
+// first. net/http prefers the upper case ones and many other programs
+// the lower case ones, so both are set alike.
+var proxyVars = [][2]string{
+	{"HTTP_PROXY", "http_proxy"},
+	{"HTTPS_PROXY", "https_proxy"},
+	{"NO_PROXY", "no_proxy"},
+}
+
+// SetProxy overrides the proxy environment of this process, and so of
+// every HTTP client using http.ProxyFromEnvironment and of the commands
+// it runs. proxy, if not "", is the proxy for both HTTP and HTTPS;
+// noProxy, if not "", replaces the hosts which are not proxied. net/http
+// reads the environment once, so SetProxy must be called before any
+// request is made.
+//
+// Traffic to machines and to the fixtures of local clusters does not go
+// through HTTP clients using the environment and is never proxied.
+func SetProxy(proxy, noProxy string) error {
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", redactProxy(proxy))
+		}
+		for _, vars := range proxyVars[:2] {
+			for _, v := range vars {
+				os.Setenv(v, proxy)
+			}
+		}
+	}
+	if noProxy != "" {
+		for _, v := range proxyVars[2] {
+			os.Setenv(v, noProxy)
+		}
+	}
+	return nil
+}
+
+// ProxySettings returns the effective proxy environment, keyed by the
+// lower case variable names, with any credentials in proxy URLs
+// redacted. Unset variables are omitted.
+func ProxySettings() map[string]string {
+	settings := make(map[string]string)
+	for _, vars := range proxyVars {
+		value := os.Getenv(vars[0])
+		if value == "" {
+			value = os.Getenv(vars[1])
+		}
+		if value == "" {
+			continue
+		}
+		if vars[1] != "no_proxy" {
+			value = redactProxy(value)
+		}
+		settings[vars[1]] = value
+	}
+	return settings
+}
+
+// redactProxy replaces the user information of a proxy URL.
+func redactProxy(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		// may be host:port, which url.Parse rejects
+		u, err = url.Parse("http://" + proxy)
+		if err != nil {
+			return "<unparsable>"
+		}
+	}
+	if u.User == nil {
+		return proxy
+	}
+	u.User = url.User("REDACTED")
+	return u.String()
+}
@@ -28,9 +28,31 @@ import (
 )
 
 const (
-	defaultPort = 22
-	defaultUser = "core"
-	rsaKeySize  = 2048
+	defaultPort      = 22
+	defaultUser      = "core"
+	rsaKeySize       = 2048
+	strictRSAKeySize = 3072
+)
+
+// The algorithms an SSHAgent in strict crypto mode allows. They are
+// limited to those approved under FIPS 140-2, which notably excludes
+// curve25519, ed25519, and arcfour.
+var (
+	strictKeyExchanges = []string{
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha1",
+	}
+	strictCiphers = []string{
+		"aes128-gcm@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+	}
+	strictMACs = []string{
+		"hmac-sha2-256", "hmac-sha1",
+	}
+	strictHostKeyAlgorithms = []string{
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSA,
+	}
 )
 
 // Dialer is an interface for anything compatible with net.Dialer
@@ -47,12 +69,33 @@ type SSHAgent struct {
 	Socket   string
 	sockDir  string
 	listener *net.UnixListener
+	strict   bool
+	key      *rsa.PrivateKey
+}
+
+// GenerateRSAKey generates a private key for use with SSH. In strict
+// crypto mode the key is large enough to be approved under FIPS 140-2.
+func GenerateRSAKey(strict bool) (*rsa.PrivateKey, error) {
+	if strict {
+		return rsa.GenerateKey(rand.Reader, strictRSAKeySize)
+	}
+	return rsa.GenerateKey(rand.Reader, rsaKeySize)
 }
 
 // NewSSHAgent constructs a new SSHAgent using dialer to create ssh
 // connections.
 func NewSSHAgent(dialer Dialer) (*SSHAgent, error) {
-	key, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+	return newSSHAgent(dialer, false)
+}
+
+// NewStrictSSHAgent constructs a new SSHAgent which only generates keys
+// and negotiates algorithms approved under FIPS 140-2.
+func NewStrictSSHAgent(dialer Dialer) (*SSHAgent, error) {
+	return newSSHAgent(dialer, true)
+}
+
+func newSSHAgent(dialer Dialer, strict bool) (*SSHAgent, error) {
+	key, err := GenerateRSAKey(strict)
 	if err != nil {
 		return nil, err
 	}
@@ -89,6 +132,8 @@ func NewSSHAgent(dialer Dialer) (*SSHAgent, error) {
 		Socket:   sockPath,
 		sockDir:  sockDir,
 		listener: listener,
+		strict:   strict,
+		key:      key,
 	}
 
 	go func() {
@@ -104,6 +149,11 @@ func NewSSHAgent(dialer Dialer) (*SSHAgent, error) {
 	return a, nil
 }
 
+// PrivateKey returns the key the agent generated.
+func (a *SSHAgent) PrivateKey() *rsa.PrivateKey {
+	return a.key
+}
+
 // Close closes the unix socket of the agent.
 func (a *SSHAgent) Close() error {
 	a.listener.Close()
@@ -131,6 +181,12 @@ func (a *SSHAgent) newClient(host string, user string, auth []ssh.AuthMethod) (*
 		User: user,
 		Auth: auth,
 	}
+	if a.strict {
+		sshcfg.KeyExchanges = strictKeyExchanges
+		sshcfg.Ciphers = strictCiphers
+		sshcfg.MACs = strictMACs
+		sshcfg.HostKeyAlgorithms = strictHostKeyAlgorithms
+	}
 	addr := ensurePortSuffix(host, defaultPort)
 	tcpconn, err := a.Dial("tcp", addr)
 	if err != nil {
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 
 	"golang.org/x/crypto/ssh"
@@ -93,3 +94,82 @@ func TestSSHNewClient(t *testing.T) {
 	// Oh god... I give up for now.
 	t.Skip("Implementation incomplete")
 }
+
+func TestStrictSSHAgent(t *testing.T) {
+	m, err := NewStrictSSHAgent(&net.Dialer{})
+	if err != nil {
+		t.Fatalf("NewStrictSSHAgent failed: %v", err)
+	}
+	defer m.Close()
+
+	keys, err := m.List()
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	for _, key := range keys {
+		// the blob holds the exponent and the modulus
+		if key.Format != ssh.KeyAlgoRSA || len(key.Blob) < strictRSAKeySize/8 {
+			t.Errorf("agent key %s is not a %d bit RSA key", key.Format, strictRSAKeySize)
+		}
+	}
+
+	key, err := GenerateRSAKey(true)
+	if err != nil {
+		t.Fatalf("GenerateRSAKey failed: %v", err)
+	}
+	if key.N.BitLen() != strictRSAKeySize {
+		t.Errorf("generated %d bit key, expected %d", key.N.BitLen(), strictRSAKeySize)
+	}
+
+	forbidden := []string{"curve25519", "ed25519", "arcfour", "dss", "md5", "group1-"}
+	for _, list := range [][]string{strictKeyExchanges, strictCiphers, strictMACs, strictHostKeyAlgorithms} {
+		for _, algo := range list {
+			for _, f := range forbidden {
+				if strings.Contains(algo, f) {
+					t.Errorf("strict algorithm %q is not approved", algo)
+				}
+			}
+		}
+	}
+
+	// a strict client must still be able to talk to a stock server
+	cfg := ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "core" && bytes.Equal(key.Marshal(), keys[0].Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("pubkey rejected")
+		},
+	}
+	hostKey, err := ssh.ParsePrivateKey(testHostKeyBytes)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey failed: %v", err)
+	}
+	cfg.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, chans, reqs, err := ssh.NewServerConn(conn, &cfg); err == nil {
+			go ssh.DiscardRequests(reqs)
+			for ch := range chans {
+				ch.Reject(ssh.Prohibited, "no channels")
+			}
+		}
+	}()
+
+	client, err := m.NewClient(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	client.Close()
+}
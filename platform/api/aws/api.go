@@ -15,6 +15,7 @@
 package aws
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -27,6 +28,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/coreos/pkg/capnslog"
 
+	"github.com/coreos/mantle/network"
 	"github.com/coreos/mantle/platform"
 )
 
@@ -77,6 +79,9 @@ func New(opts *Options) (*API, error) {
 	} else if opts.CredentialsFile != "" {
 		awsCfg.Credentials = credentials.NewSharedCredentials(opts.CredentialsFile, opts.Profile)
 	}
+	if plog.LevelAt(capnslog.DEBUG) {
+		awsCfg.HTTPClient = network.LogRequests(&http.Client{}, plog.Debugf)
+	}
 
 	sess, err := session.NewSessionWithOptions(session.Options{
 		Profile: opts.Profile,
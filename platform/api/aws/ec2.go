@@ -21,6 +21,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
 
 	"github.com/coreos/mantle/util"
@@ -196,6 +197,21 @@ func (a *API) TerminateInstances(ids []string) error {
 	return nil
 }
 
+// WaitForTermination waits up to 10 minutes for EC2 instances to be
+// terminated, so that none is left running and billed after a failed
+// terminate goes unnoticed.
+func (a *API) WaitForTermination(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return a.ec2.WaitUntilInstanceTerminatedWithContext(aws.BackgroundContext(), &ec2.DescribeInstancesInput{
+		InstanceIds: aws.StringSlice(ids),
+	}, func(w *request.Waiter) {
+		w.MaxAttempts = 40
+		w.Delay = request.ConstantWaiterDelay(15 * time.Second)
+	})
+}
+
 func (a *API) CreateTags(resources []string, tags map[string]string) error {
 	tagObjs := make([]*ec2.Tag, 0, len(tags))
 	for key, value := range tags {
@@ -28,6 +28,7 @@ import (
 	"golang.org/x/oauth2"
 
 	"github.com/coreos/mantle/auth"
+	"github.com/coreos/mantle/network"
 	"github.com/coreos/mantle/platform"
 	"github.com/coreos/mantle/util"
 )
@@ -80,7 +81,11 @@ func New(opts *Options) (*API, error) {
 	}
 
 	ctx := context.TODO()
-	client := godo.NewClient(oauth2.NewClient(ctx, &tokenSource{opts.AccessToken}))
+	httpClient := oauth2.NewClient(ctx, &tokenSource{opts.AccessToken})
+	if plog.LevelAt(capnslog.DEBUG) {
+		httpClient = network.LogRequests(httpClient, plog.Debugf)
+	}
+	client := godo.NewClient(httpClient)
 
 	a := &API{
 		c:    client,
@@ -130,7 +135,9 @@ func (a *API) PreflightCheck(ctx context.Context) error {
 	return nil
 }
 
-func (a *API) CreateDroplet(ctx context.Context, name string, sshKeyID int, userdata string) (*godo.Droplet, error) {
+// CreateDroplet creates a droplet tagged "mantle" and with tags, which
+// DigitalOcean creates if they don't exist, and waits for it to run.
+func (a *API) CreateDroplet(ctx context.Context, name string, sshKeyID int, userdata string, tags ...string) (*godo.Droplet, error) {
 	var droplet *godo.Droplet
 	var err error
 	// DO frequently gives us 422 errors saying "Please try again". Retry every 10 seconds
@@ -145,7 +152,7 @@ func (a *API) CreateDroplet(ctx context.Context, name string, sshKeyID int, user
 			IPv6:              true,
 			PrivateNetworking: true,
 			UserData:          userdata,
-			Tags:              []string{"mantle"},
+			Tags:              append([]string{"mantle"}, tags...),
 		})
 		if err != nil {
 			plog.Errorf("Error creating droplet: %v. Retrying...", err)
@@ -174,6 +181,19 @@ func (a *API) CreateDroplet(ctx context.Context, name string, sshKeyID int, user
 	return droplet, nil
 }
 
+// ListDropletsWithTag returns the droplets tagged with tag.
+func (a *API) ListDropletsWithTag(ctx context.Context, tag string) ([]godo.Droplet, error) {
+	return a.listDropletsWithTag(ctx, tag)
+}
+
+// DeleteTag deletes tag, removing it from any droplets which have it.
+func (a *API) DeleteTag(ctx context.Context, tag string) error {
+	if _, err := a.c.Tags.Delete(ctx, tag); err != nil {
+		return fmt.Errorf("deleting tag %s: %v", tag, err)
+	}
+	return nil
+}
+
 func (a *API) listDropletsWithTag(ctx context.Context, tag string) ([]godo.Droplet, error) {
 	page := godo.ListOptions{
 		Page:    1,
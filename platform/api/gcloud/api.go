@@ -26,6 +26,7 @@ import (
 	"google.golang.org/api/compute/v1"
 
 	"github.com/coreos/mantle/auth"
+	"github.com/coreos/mantle/network"
 	"github.com/coreos/mantle/platform"
 )
 
@@ -49,6 +50,15 @@ type API struct {
 	client  *http.Client
 	compute *compute.Service
 	options *Options
+
+	// OperationDone, if set, is called as each operation the API
+	// waits for finishes, e.g. to record how long creating an instance
+	// took.
+	OperationDone func(op *compute.Operation, elapsed time.Duration)
+
+	// Tags are added to the network tags of instances created
+	// afterwards, e.g. to apply a firewall rule to them.
+	Tags []string
 }
 
 func New(opts *Options) (*API, error) {
@@ -85,6 +95,9 @@ func New(opts *Options) (*API, error) {
 	if err != nil {
 		return nil, err
 	}
+	if plog.LevelAt(capnslog.DEBUG) {
+		client = network.LogRequests(client, plog.Debugf)
+	}
 
 	capi, err := compute.New(client)
 	if err != nil {
@@ -105,5 +118,8 @@ func (a *API) Client() *http.Client {
 }
 
 func (a *API) GC(gracePeriod time.Duration) error {
-	return a.gcInstances(gracePeriod)
+	if err := a.gcInstances(gracePeriod); err != nil {
+		return err
+	}
+	return a.gcFirewallRules(gracePeriod)
 }
@@ -15,6 +15,7 @@
 package gcloud
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"strings"
@@ -30,6 +31,16 @@ func (a *API) vmname() string {
 	return fmt.Sprintf("%s-%x", a.options.BaseName, b)
 }
 
+// GetInstanceStatus returns the status of an instance, e.g. "RUNNING",
+// and the message explaining it, if any.
+func (a *API) GetInstanceStatus(ctx context.Context, name string) (string, string, error) {
+	inst, err := a.compute.Instances.Get(a.options.Project, a.options.Zone, name).Context(ctx).Do()
+	if err != nil {
+		return "", "", fmt.Errorf("failed getting status of %q: %v", name, err)
+	}
+	return inst.Status, inst.StatusMessage, nil
+}
+
 // Taken from: https://github.com/golang/build/blob/master/buildlet/gce.go
 func (a *API) mkinstance(userdata, name string, keys []*agent.Key) *compute.Instance {
 	mantle := "mantle"
@@ -65,7 +76,7 @@ func (a *API) mkinstance(userdata, name string, keys []*agent.Key) *compute.Inst
 			// Apparently you need this tag in addition to the
 			// firewall rules to open the port because these ports
 			// are special?
-			Items: []string{"https-server", "http-server"},
+			Items: append([]string{"https-server", "http-server"}, a.Tags...),
 		},
 		Disks: []*compute.AttachedDisk{
 			{
@@ -104,9 +115,18 @@ func (a *API) mkinstance(userdata, name string, keys []*agent.Key) *compute.Inst
 
 }
 
-// CreateInstance creates a Google Compute Engine instance.
-func (a *API) CreateInstance(userdata string, keys []*agent.Key) (*compute.Instance, error) {
-	name := a.vmname()
+// CreateInstance creates a Google Compute Engine instance. If name is
+// empty, a random name is generated.
+func (a *API) CreateInstance(name, userdata string, keys []*agent.Key) (*compute.Instance, error) {
+	return a.CreateInstanceContext(context.Background(), name, userdata, keys)
+}
+
+// CreateInstanceContext is CreateInstance, but stops waiting for the
+// instance once ctx is done and deletes it rather than leak it.
+func (a *API) CreateInstanceContext(ctx context.Context, name, userdata string, keys []*agent.Key) (*compute.Instance, error) {
+	if name == "" {
+		name = a.vmname()
+	}
 	inst := a.mkinstance(userdata, name, keys)
 
 	plog.Debugf("Creating instance %q", name)
@@ -117,11 +137,16 @@ func (a *API) CreateInstance(userdata string, keys []*agent.Key) (*compute.Insta
 	}
 
 	doable := a.compute.ZoneOperations.Get(a.options.Project, a.options.Zone, op.Name)
-	if err := a.NewPending(op.Name, doable).Wait(); err != nil {
-		return nil, err
+	if err := a.NewPending(op.Name, doable).WaitContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			if err := a.TerminateInstance(name); err != nil {
+				plog.Errorf("Deleting instance %q after giving up on it: %v", name, err)
+			}
+		}
+		return nil, fmt.Errorf("creating instance %q: %v", name, err)
 	}
 
-	inst, err = a.compute.Instances.Get(a.options.Project, a.options.Zone, name).Do()
+	inst, err = a.compute.Instances.Get(a.options.Project, a.options.Zone, name).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("failed getting instance %s details after creation: %v", name, err)
 	}
@@ -131,11 +156,26 @@ func (a *API) CreateInstance(userdata string, keys []*agent.Key) (*compute.Insta
 	return inst, nil
 }
 
+// TerminateInstance deletes an instance and waits until it is gone.
+// Deletions are throttled and retried across all API clients in the
+// process, so an error means the instance was probably leaked.
 func (a *API) TerminateInstance(name string) error {
 	plog.Debugf("Terminating instance %q", name)
 
-	_, err := a.compute.Instances.Delete(a.options.Project, a.options.Zone, name).Do()
-	return err
+	return deletions.delete(a, name)
+}
+
+// ResetInstance hard resets an instance, without letting the OS shut
+// down.
+func (a *API) ResetInstance(name string) error {
+	plog.Debugf("Resetting instance %q", name)
+
+	op, err := a.compute.Instances.Reset(a.options.Project, a.options.Zone, name).Do()
+	if err != nil {
+		return fmt.Errorf("failed to reset instance %s: %v", name, err)
+	}
+	doable := a.compute.ZoneOperations.Get(a.options.Project, a.options.Zone, op.Name)
+	return a.NewPending(op.Name, doable).Wait()
 }
 
 func (a *API) ListInstances(prefix string) ([]*compute.Instance, error) {
@@ -158,7 +198,13 @@ func (a *API) ListInstances(prefix string) ([]*compute.Instance, error) {
 }
 
 func (a *API) GetConsoleOutput(name string) (string, error) {
-	out, err := a.compute.Instances.GetSerialPortOutput(a.options.Project, a.options.Zone, name).Do()
+	return a.GetConsoleOutputContext(context.Background(), name)
+}
+
+// GetConsoleOutputContext is GetConsoleOutput with a context bounding
+// the request.
+func (a *API) GetConsoleOutputContext(ctx context.Context, name string) (string, error) {
+	out, err := a.compute.Instances.GetSerialPortOutput(a.options.Project, a.options.Zone, name).Context(ctx).Do()
 	if err != nil {
 		return "", fmt.Errorf("failed to retrieve console output for %q: %v", name, err)
 	}
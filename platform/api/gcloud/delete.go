@@ -0,0 +1,120 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	deleteWorkers  = 8
+	deleteRate     = 5 // requests per second, across all clusters
+	deleteAttempts = 6
+	deleteBackoff  = 2 * time.Second
+)
+
+// deletions queues instance deletions from every API in the process so
+// that tearing down many clusters at once stays within GCE's rate
+// limits, which apply per project rather than per client.
+var deletions deletePool
+
+type deleteRequest struct {
+	api  *API
+	name string
+	done chan error
+}
+
+type deletePool struct {
+	once  sync.Once
+	queue chan *deleteRequest
+	tick  <-chan time.Time
+}
+
+func (p *deletePool) start() {
+	p.once.Do(func() {
+		p.queue = make(chan *deleteRequest)
+		p.tick = time.Tick(time.Second / deleteRate)
+		for i := 0; i < deleteWorkers; i++ {
+			go p.work()
+		}
+	})
+}
+
+// delete queues the deletion of instance name and waits for it to
+// finish.
+func (p *deletePool) delete(a *API, name string) error {
+	p.start()
+	req := &deleteRequest{api: a, name: name, done: make(chan error, 1)}
+	p.queue <- req
+	return <-req.done
+}
+
+func (p *deletePool) work() {
+	for req := range p.queue {
+		req.done <- p.deleteInstance(req.api, req.name)
+	}
+}
+
+// deleteInstance requests the deletion of an instance, retrying errors
+// which may be transient, and waits for the operation to be done.
+func (p *deletePool) deleteInstance(a *API, name string) error {
+	backoff := deleteBackoff
+	for attempt := 1; ; attempt++ {
+		<-p.tick
+		op, err := a.compute.Instances.Delete(a.options.Project, a.options.Zone, name).Do()
+		if err == nil {
+			doable := a.compute.ZoneOperations.Get(a.options.Project, a.options.Zone, op.Name)
+			return a.NewPending(op.Name, doable).Wait()
+		}
+		if isNotFound(err) {
+			// deleted by an earlier attempt whose response was lost
+			return nil
+		}
+		if !isRetryable(err) || attempt == deleteAttempts {
+			return fmt.Errorf("deleting instance %s: %v", name, err)
+		}
+		plog.Warningf("Deleting instance %s failed, retrying in %v: %v", name, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func isNotFound(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	return ok && gerr.Code == http.StatusNotFound
+}
+
+// isRetryable reports whether err may succeed if retried: rate limiting,
+// server errors and errors without an HTTP status, e.g. network errors.
+func isRetryable(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	if !ok {
+		return true
+	}
+	if gerr.Code == http.StatusTooManyRequests || gerr.Code >= 500 {
+		return true
+	}
+	for _, e := range gerr.Errors {
+		if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// firewallDescription marks the firewall rules mantle creates, which
+// have no metadata to hold a created-by item as instances do.
+const firewallDescription = "created-by mantle"
+
+// NewFirewallName returns a random name for a firewall rule, which is
+// also valid as a network tag.
+func (a *API) NewFirewallName() string {
+	return a.vmname()
+}
+
+// CreateFirewallRule creates a rule in the network of a's instances
+// allowing TCP connections from sources, CIDR ranges, to ports of the
+// instances tagged tag. Instances are tagged when created by setting
+// Tags.
+func (a *API) CreateFirewallRule(name, tag string, ports []int, sources []string) error {
+	allowed := &compute.FirewallAllowed{IPProtocol: "tcp"}
+	for _, port := range ports {
+		allowed.Ports = append(allowed.Ports, strconv.Itoa(port))
+	}
+	rule := &compute.Firewall{
+		Name:         name,
+		Description:  firewallDescription,
+		Network:      "https://www.googleapis.com/compute/v1/projects/" + a.options.Project + "/global/networks/" + a.options.Network,
+		Allowed:      []*compute.FirewallAllowed{allowed},
+		SourceRanges: sources,
+		TargetTags:   []string{tag},
+	}
+
+	plog.Debugf("Creating firewall rule %q allowing tcp:%v from %v", name, allowed.Ports, sources)
+
+	op, err := a.compute.Firewalls.Insert(a.options.Project, rule).Do()
+	if err != nil {
+		return fmt.Errorf("creating firewall rule %q: %v", name, err)
+	}
+	doable := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
+	if err := a.NewPending(op.Name, doable).Wait(); err != nil {
+		return fmt.Errorf("creating firewall rule %q: %v", name, err)
+	}
+	return nil
+}
+
+// DeleteFirewallRule deletes a firewall rule and waits until it is
+// gone. A rule which doesn't exist is not an error.
+func (a *API) DeleteFirewallRule(name string) error {
+	plog.Debugf("Deleting firewall rule %q", name)
+
+	op, err := a.compute.Firewalls.Delete(a.options.Project, name).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("deleting firewall rule %q: %v", name, err)
+	}
+	doable := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
+	return a.NewPending(op.Name, doable).Wait()
+}
+
+func (a *API) gcFirewallRules(gracePeriod time.Duration) error {
+	threshold := time.Now().Add(-gracePeriod)
+
+	list, err := a.compute.Firewalls.List(a.options.Project).Do()
+	if err != nil {
+		return err
+	}
+	for _, rule := range list.Items {
+		if rule.Description != firewallDescription {
+			continue
+		}
+
+		created, err := time.Parse(time.RFC3339, rule.CreationTimestamp)
+		if err != nil {
+			return fmt.Errorf("couldn't parse %q: %v", rule.CreationTimestamp, err)
+		}
+		if created.After(threshold) {
+			continue
+		}
+
+		if err := a.DeleteFirewallRule(rule.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
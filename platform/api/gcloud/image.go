@@ -148,3 +148,29 @@ func (a *API) DeleteImage(name string) (*Pending, error) {
 	opReq := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
 	return a.NewPending(op.Name, opReq), nil
 }
+
+// GetImage looks up an image by name, family, or full API endpoint in the
+// form accepted by Options.Image.
+func (a *API) GetImage(image string) (*compute.Image, error) {
+	project := a.options.Project
+	if i := strings.Index(image, "projects/"); i >= 0 {
+		parts := strings.Split(image[i:], "/")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("malformed image %q", image)
+		}
+		project = parts[1]
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+
+	var img *compute.Image
+	var err error
+	if strings.Contains(image, "/family/") {
+		img, err = a.compute.Images.GetFromFamily(project, name).Do()
+	} else {
+		img, err = a.compute.Images.Get(project, name).Do()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Getting image %s failed: %v", image, err)
+	}
+	return img, nil
+}
@@ -15,7 +15,9 @@
 package gcloud
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"google.golang.org/api/compute/v1"
@@ -31,30 +33,57 @@ type Pending struct {
 	Timeout  time.Duration // for default progress function
 	Progress func(desc string, elapsed time.Duration, op *compute.Operation) error
 
+	// LogInterval is how often an operation which is still pending or
+	// running is logged, so that one which hangs doesn't go unnoticed.
+	LogInterval time.Duration
+
+	// Done, if set, is called with the final state of the operation
+	// and how long it took, whether or not it succeeded.
+	Done func(op *compute.Operation, elapsed time.Duration)
+
 	desc string
 	do   doable
 }
 
 func (a *API) NewPending(desc string, do doable) *Pending {
 	pending := &Pending{
-		Interval: 10 * time.Second,
-		Timeout:  5 * time.Minute,
-		desc:     desc,
-		do:       do,
+		Interval:    10 * time.Second,
+		Timeout:     5 * time.Minute,
+		LogInterval: time.Minute,
+		Done:        a.OperationDone,
+		desc:        desc,
+		do:          do,
 	}
 	pending.Progress = pending.defaultProgress
 	return pending
 }
 
 func (p *Pending) Wait() error {
+	return p.WaitContext(context.Background())
+}
+
+// WaitContext is like Wait, but stops waiting once ctx is done. The
+// operation itself carries on.
+func (p *Pending) WaitContext(ctx context.Context) error {
 	var op *compute.Operation
 	var err error
 	failures := 0
 	start := time.Now()
+	status := ""
+	logged := start
 	for {
 		op, err = p.do.Do()
 		if err == nil {
-			err := p.Progress(p.desc, time.Now().Sub(start), op)
+			elapsed := time.Now().Sub(start)
+			if op.Status != status {
+				status = op.Status
+				logged = time.Now()
+				plog.Debugf("Operation %q is %s after %v", p.desc, status, elapsed.Round(time.Second))
+			} else if p.LogInterval > 0 && time.Since(logged) >= p.LogInterval {
+				logged = time.Now()
+				plog.Infof("Operation %q is still %s after %v%s", p.desc, status, elapsed.Round(time.Second), progressDetail(op))
+			}
+			err := p.Progress(p.desc, elapsed, op)
 			if err != nil {
 				return err
 			}
@@ -67,17 +96,57 @@ func (p *Pending) Wait() error {
 		if op != nil && op.Status == "DONE" {
 			break
 		}
-		time.Sleep(p.Interval)
+		select {
+		case <-time.After(p.Interval):
+		case <-ctx.Done():
+			return fmt.Errorf("Stopped waiting for operation %q after %v: %v", p.desc, time.Since(start).Round(time.Second), ctx.Err())
+		}
+	}
+	if p.Done != nil {
+		p.Done(op, time.Since(start))
 	}
 	if op.Error != nil {
-		if len(op.Error.Errors) > 0 {
-			return fmt.Errorf("Operation %q failed: %+v", p.desc, op.Error.Errors)
-		}
-		return fmt.Errorf("Operation %q failed to start", p.desc)
+		return operationError(p.desc, op)
 	}
 	return nil
 }
 
+// operationError describes each of the errors op failed with, calling
+// out an exhausted quota since retrying won't help.
+func operationError(desc string, op *compute.Operation) error {
+	if len(op.Error.Errors) == 0 {
+		return fmt.Errorf("Operation %q failed to start", desc)
+	}
+	var details []string
+	quota := false
+	for _, e := range op.Error.Errors {
+		detail := e.Code
+		if e.Message != "" {
+			detail += ": " + e.Message
+		}
+		details = append(details, detail)
+		if e.Code == "QUOTA_EXCEEDED" {
+			quota = true
+		}
+	}
+	if quota {
+		return fmt.Errorf("Operation %q failed: %s (the project is out of quota, perhaps because of leaked instances)", desc, strings.Join(details, "; "))
+	}
+	return fmt.Errorf("Operation %q failed: %s", desc, strings.Join(details, "; "))
+}
+
+// progressDetail is what op says of its progress, if anything.
+func progressDetail(op *compute.Operation) string {
+	var detail string
+	if op.Progress != 0 {
+		detail = fmt.Sprintf(", %d%% done", op.Progress)
+	}
+	if op.StatusMessage != "" {
+		detail += ": " + op.StatusMessage
+	}
+	return detail
+}
+
 func (p *Pending) defaultProgress(desc string, elapsed time.Duration, op *compute.Operation) error {
 	var err error
 	switch op.Status {
@@ -0,0 +1,89 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// fakeOperation reports its statuses in turn, then the last forever.
+type fakeOperation struct {
+	statuses []string
+	err      *compute.OperationError
+}
+
+func (f *fakeOperation) Do(opts ...googleapi.CallOption) (*compute.Operation, error) {
+	op := &compute.Operation{Name: "operation-1", OperationType: "insert", Status: f.statuses[0]}
+	if len(f.statuses) > 1 {
+		f.statuses = f.statuses[1:]
+	}
+	if op.Status == "DONE" {
+		op.Error = f.err
+	}
+	return op, nil
+}
+
+func TestPendingQuotaExceeded(t *testing.T) {
+	var done *compute.Operation
+	a := &API{OperationDone: func(op *compute.Operation, elapsed time.Duration) {
+		done = op
+	}}
+	p := a.NewPending("operation-1", &fakeOperation{
+		statuses: []string{"PENDING", "RUNNING", "DONE"},
+		err: &compute.OperationError{Errors: []*compute.OperationErrorErrors{{
+			Code:    "QUOTA_EXCEEDED",
+			Message: "Quota 'CPUS' exceeded. Limit: 24.0 in region us-central1.",
+		}}},
+	})
+	p.Interval = time.Millisecond
+
+	err := p.Wait()
+	if err == nil {
+		t.Fatal("operation succeeded")
+	}
+	for _, want := range []string{"operation-1", "QUOTA_EXCEEDED", "Limit: 24.0", "out of quota"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+	if done == nil || done.Status != "DONE" {
+		t.Errorf("OperationDone got %+v", done)
+	}
+}
+
+func TestPendingContext(t *testing.T) {
+	a := &API{}
+	p := a.NewPending("operation-1", &fakeOperation{statuses: []string{"RUNNING"}})
+	p.Interval = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- p.WaitContext(ctx) }()
+	select {
+	case err := <-errc:
+		if err == nil || !strings.Contains(err.Error(), "operation-1") {
+			t.Errorf("got %v, want an error naming the operation", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("still waiting after the context was done")
+	}
+}
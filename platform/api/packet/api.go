@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"strings"
 	"time"
 
@@ -33,6 +34,7 @@ import (
 	gs "google.golang.org/api/storage/v1"
 
 	"github.com/coreos/mantle/auth"
+	"github.com/coreos/mantle/network"
 	"github.com/coreos/mantle/platform"
 	"github.com/coreos/mantle/platform/api/gcloud"
 	"github.com/coreos/mantle/platform/conf"
@@ -158,7 +160,11 @@ func New(opts *Options) (*API, error) {
 		return nil, fmt.Errorf("connecting to Google Storage bucket: %v", err)
 	}
 
-	client := packngo.NewClient("github.com/coreos/mantle", opts.ApiKey, nil)
+	var httpClient *http.Client
+	if plog.LevelAt(capnslog.DEBUG) {
+		httpClient = network.LogRequests(&http.Client{}, plog.Debugf)
+	}
+	client := packngo.NewClient("github.com/coreos/mantle", opts.ApiKey, httpClient)
 
 	return &API{
 		c:      client,
@@ -0,0 +1,66 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MinMemoryMiB is the least memory a machine may be ballooned down to.
+// Below this Container Linux cannot be relied on to keep sshd and the
+// journal running, so tests would fail for uninteresting reasons.
+const MinMemoryMiB = 256
+
+// ErrNotSupported is returned by helpers for optional machine features
+// when the machine's platform does not provide them.
+var ErrNotSupported = errors.New("not supported on this platform")
+
+// MemoryBalloon is implemented by machines on platforms with
+// CapMemoryBalloon. It changes the memory available to a running machine
+// by inflating or deflating a balloon device in the guest.
+type MemoryBalloon interface {
+	// SetMemory asks the guest to give up memory until targetMiB
+	// remains and waits for it to do so. The target cannot exceed the
+	// memory the machine booted with.
+	SetMemory(targetMiB int) error
+
+	// Memory returns the memory currently available to the guest.
+	Memory() (int, error)
+}
+
+// SetMemory resizes m's memory to targetMiB, refusing targets below
+// MinMemoryMiB. It returns ErrNotSupported unless m implements
+// MemoryBalloon.
+func SetMemory(m Machine, targetMiB int) error {
+	b, ok := m.(MemoryBalloon)
+	if !ok {
+		return ErrNotSupported
+	}
+	if targetMiB < MinMemoryMiB {
+		return fmt.Errorf("refusing to balloon %s to %d MiB, below the minimum of %d MiB", m.ID(), targetMiB, MinMemoryMiB)
+	}
+	return b.SetMemory(targetMiB)
+}
+
+// Memory returns the memory currently available to m. It returns
+// ErrNotSupported unless m implements MemoryBalloon.
+func Memory(m Machine) (int, error) {
+	b, ok := m.(MemoryBalloon)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return b.Memory()
+}
@@ -16,9 +16,11 @@ package platform
 
 import (
 	"bytes"
+	"crypto/rsa"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -42,12 +44,24 @@ type BaseCluster struct {
 	machlock   sync.Mutex
 	machmap    map[string]Machine
 	consolemap map[string]string
+	created    map[string]time.Time
+
+	stopReaper     chan struct{}
+	stopReaperOnce sync.Once
+	reaperDone     chan struct{} // nil if there is no reaper
 
 	name       string
+	runID      string
 	rconf      *RuntimeConfig
 	platform   Name
 	ctPlatform string
 	baseopts   *Options
+
+	sshProxyCommand string       // for ssh_config; protected by machlock
+	destroyOrder    DestroyOrder // protected by machlock
+	leader          string       // protected by machlock
+
+	events *eventBus
 }
 
 func NewBaseCluster(opts *Options, rconf *RuntimeConfig, platform Name, ctPlatform string) (*BaseCluster, error) {
@@ -55,25 +69,94 @@ func NewBaseCluster(opts *Options, rconf *RuntimeConfig, platform Name, ctPlatfo
 }
 
 func NewBaseClusterWithDialer(opts *Options, rconf *RuntimeConfig, platform Name, ctPlatform string, dialer network.Dialer) (*BaseCluster, error) {
-	agent, err := network.NewSSHAgent(dialer)
+	if _, ok := UserDataLimit(platform); !ok {
+		return nil, fmt.Errorf("platform %v has not declared its user-data size limit", platform)
+	}
+
+	newAgent := network.NewSSHAgent
+	if rconf.StrictCrypto {
+		newAgent = network.NewStrictSSHAgent
+	}
+	agent, err := newAgent(dialer)
 	if err != nil {
 		return nil, err
 	}
 
+	runID := uuid.NewV4().String()
 	bc := &BaseCluster{
-		agent:      agent,
-		machmap:    make(map[string]Machine),
-		consolemap: make(map[string]string),
-		name:       fmt.Sprintf("%s-%s", opts.BaseName, uuid.NewV4()),
-		rconf:      rconf,
-		platform:   platform,
-		ctPlatform: ctPlatform,
-		baseopts:   opts,
+		agent:        agent,
+		machmap:      make(map[string]Machine),
+		consolemap:   make(map[string]string),
+		created:      make(map[string]time.Time),
+		stopReaper:   make(chan struct{}),
+		name:         fmt.Sprintf("%s-%s", opts.BaseName, runID),
+		runID:        runID,
+		rconf:        rconf,
+		platform:     platform,
+		ctPlatform:   ctPlatform,
+		baseopts:     opts,
+		events:       &eventBus{},
+		destroyOrder: rconf.DestroyOrder,
+	}
+
+	if rconf.MaxMachineLifetime > 0 {
+		bc.reaperDone = make(chan struct{})
+		go bc.reaper()
 	}
 
 	return bc, nil
 }
 
+// reaper destroys machines which have been part of the cluster for
+// longer than MaxMachineLifetime until the cluster is destroyed.
+func (bc *BaseCluster) reaper() {
+	defer close(bc.reaperDone)
+
+	interval := bc.rconf.MaxMachineLifetime / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bc.stopReaper:
+			return
+		case <-ticker.C:
+		}
+
+		var expired []Machine
+		var ages []time.Duration
+		bc.machlock.Lock()
+		for id, m := range bc.machmap {
+			if age := time.Since(bc.created[id]); age > bc.rconf.MaxMachineLifetime {
+				expired = append(expired, m)
+				ages = append(ages, age)
+			}
+		}
+		bc.machlock.Unlock()
+
+		for i, m := range expired {
+			plog.Warningf("Destroying machine %v of %v after %v, exceeding the maximum lifetime of %v",
+				m.ID(), bc.rconf.OutputDir, ages[i], bc.rconf.MaxMachineLifetime)
+			m.Destroy()
+			if bc.rconf.MachineReaped != nil {
+				bc.rconf.MachineReaped(m.ID(), ages[i])
+			}
+		}
+	}
+}
+
+// MachineLeaked reports that the platform resources of machine id could
+// not be released when it was destroyed.
+func (bc *BaseCluster) MachineLeaked(id string, err error) {
+	plog.Errorf("Machine %v may have been leaked: %v", id, err)
+	if bc.rconf.MachineLeaked != nil {
+		bc.rconf.MachineLeaked(id, err)
+	}
+}
+
 func (bc *BaseCluster) SSHClient(ip string) (*ssh.Client, error) {
 	sshClient, err := bc.agent.NewClient(ip)
 	if err != nil {
@@ -103,11 +186,15 @@ func (bc *BaseCluster) PasswordSSHClient(ip string, user string, password string
 
 // SSH executes the given command, cmd, on the given Machine, m. It returns the
 // stdout and stderr of the command and an error.
-// Leading and trailing whitespace is trimmed from each.
+// Leading and trailing whitespace is trimmed from each. Each stream is
+// capped at the CommandOutput limit of the runtime configuration.
 func (bc *BaseCluster) SSH(m Machine, cmd string) ([]byte, []byte, error) {
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
-	client, err := bc.SSHClient(m.IP())
+	limit := bc.rconf.Limits.CommandOutput
+	stdoutw := &util.HeadWriter{W: &stdout, Limit: limit}
+	stderrw := &util.HeadWriter{W: &stderr, Limit: limit}
+	client, err := m.SSHClient()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -119,11 +206,28 @@ func (bc *BaseCluster) SSH(m Machine, cmd string) ([]byte, []byte, error) {
 	}
 	defer session.Close()
 
-	session.Stdout = &stdout
-	session.Stderr = &stderr
+	session.Stdout = stdoutw
+	session.Stderr = stderrw
 	err = session.Run(cmd)
+	for _, w := range []struct {
+		name string
+		hw   *util.HeadWriter
+		buf  *bytes.Buffer
+	}{{"stdout", stdoutw, &stdout}, {"stderr", stderrw, &stderr}} {
+		if dropped := w.hw.Dropped(); dropped > 0 {
+			w.buf.WriteString(util.TruncationMarker(dropped, "end"))
+			bc.rconf.Limits.ReportTruncated(fmt.Sprintf("%s of %q on %s", w.name, cmd, m.ID()), dropped)
+		}
+	}
 	outBytes := bytes.TrimSpace(stdout.Bytes())
 	errBytes := bytes.TrimSpace(stderr.Bytes())
+	if plog.LevelAt(capnslog.DEBUG) {
+		status := "succeeded"
+		if err != nil {
+			status = err.Error()
+		}
+		plog.Debugf("SSH on %s: %s: %s\nstdout: %s\nstderr: %s", m.ID(), cmd, status, outBytes, errBytes)
+	}
 	return outBytes, errBytes, err
 }
 
@@ -141,34 +245,81 @@ func (bc *BaseCluster) AddMach(m Machine) {
 	bc.machlock.Lock()
 	defer bc.machlock.Unlock()
 	bc.machmap[m.ID()] = m
+	bc.created[m.ID()] = time.Now()
+	bc.writeSSHConfig()
 }
 
 func (bc *BaseCluster) DelMach(m Machine) {
 	bc.machlock.Lock()
 	defer bc.machlock.Unlock()
 	delete(bc.machmap, m.ID())
-	bc.consolemap[m.ID()] = m.ConsoleOutput()
+	delete(bc.created, m.ID())
+	bc.writeSSHConfig()
+	bc.events.emit(m.ID(), MachineDestroyed, "")
+	console, dropped := util.TruncateString(m.ConsoleOutput(), bc.rconf.Limits.Console)
+	if dropped > 0 {
+		bc.rconf.Limits.ReportTruncated("console of "+m.ID(), dropped)
+	}
+	bc.consolemap[m.ID()] = console
 }
 
 func (bc *BaseCluster) Keys() ([]*agent.Key, error) {
 	return bc.agent.List()
 }
 
+// SSHPrivateKey returns the private key the cluster's machines are
+// accessed with.
+func (bc *BaseCluster) SSHPrivateKey() *rsa.PrivateKey {
+	return bc.agent.PrivateKey()
+}
+
+// ConfigError is the error of a machine config which can't be rendered
+// for the platform, which no retry of NewMachine will fix.
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Err.Error()
+}
+
+// RenderUserData renders userdata for the cluster's platform, executing
+// it if it is a template, failing with a ConfigError if it is invalid or
+// too large.
 func (bc *BaseCluster) RenderUserData(userdata *conf.UserData, ignitionVars map[string]string) (*conf.Conf, error) {
 	if userdata == nil {
 		userdata = conf.Ignition(`{"ignition": {"version": "2.0.0"}}`)
 	}
 
+	// templates have their own $name
+	template, err := userdata.IsTemplate()
+	if err != nil {
+		return nil, &ConfigError{err}
+	}
+	if template {
+		userdata, err = userdata.ExecuteTemplate(conf.MachineVars{
+			Name:        ignitionVars["$name"],
+			PrivateIPv4: ignitionVars["$private_ipv4"],
+			PrivateIPv6: ignitionVars["$private_ipv6"],
+		})
+		if err != nil {
+			return nil, &ConfigError{err}
+		}
+	}
+
 	// hacky solution for unified ignition metadata variables
 	if userdata.IsIgnitionCompatible() {
 		for k, v := range ignitionVars {
+			if template && k == "$name" {
+				continue
+			}
 			userdata = userdata.Subst(k, v)
 		}
 	}
 
 	conf, err := userdata.Render(bc.ctPlatform)
 	if err != nil {
-		return nil, err
+		return nil, &ConfigError{err}
 	}
 
 	for _, dropin := range bc.baseopts.SystemdDropins {
@@ -184,16 +335,26 @@ func (bc *BaseCluster) RenderUserData(userdata *conf.UserData, ignitionVars map[
 		conf.CopyKeys(keys)
 	}
 
+	if err := checkUserDataSize(bc.platform, conf.String()); err != nil {
+		return nil, &ConfigError{err}
+	}
+
 	return conf, nil
 }
 
-// Destroy destroys each machine in the cluster and closes the SSH agent.
+// Destroy destroys each machine in the cluster, in the cluster's
+// DestroyOrder, and closes the SSH agent.
 func (bc *BaseCluster) Destroy() {
-
-	for _, m := range bc.Machines() {
-		m.Destroy()
+	// wait for the reaper so that no machine is destroyed twice
+	bc.stopReaperOnce.Do(func() { close(bc.stopReaper) })
+	if bc.reaperDone != nil {
+		<-bc.reaperDone
 	}
 
+	bc.destroyMachines()
+	bc.removeSSHConfig()
+	bc.events.close()
+
 	if err := bc.agent.Close(); err != nil {
 		plog.Errorf("Error closing agent: %v", err)
 	}
@@ -201,10 +362,17 @@ func (bc *BaseCluster) Destroy() {
 
 // XXX(mischief): i don't really think this belongs here, but it completes the
 // interface we've established.
+//
+// GetDiscoveryURL asks the discovery service of Options.DiscoveryURL for
+// a new token, which the service makes unique.
 func (bc *BaseCluster) GetDiscoveryURL(size int) (string, error) {
+	service := DefaultDiscoveryURL
+	if bc.baseopts.DiscoveryURL != "" {
+		service = strings.TrimSuffix(bc.baseopts.DiscoveryURL, "/")
+	}
 	var result string
 	err := util.Retry(3, 5*time.Second, func() error {
-		resp, err := http.Get(fmt.Sprintf("https://discovery.etcd.io/new?size=%d", size))
+		resp, err := http.Get(fmt.Sprintf("%s/new?size=%d", service, size))
 		if err != nil {
 			return err
 		}
@@ -217,12 +385,24 @@ func (bc *BaseCluster) GetDiscoveryURL(size int) (string, error) {
 		if err != nil {
 			return err
 		}
-		result = string(body)
+		result = strings.TrimSpace(string(body))
 		return nil
 	})
 	return result, err
 }
 
+func (bc *BaseCluster) GetDiscoveryState(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Discovery service returned %q", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
 func (bc *BaseCluster) Platform() Name {
 	return bc.platform
 }
@@ -231,8 +411,70 @@ func (bc *BaseCluster) Name() string {
 	return bc.name
 }
 
+// ID returns the cluster's unique id, which its name and the names of
+// its machines include.
+func (bc *BaseCluster) ID() string {
+	return bc.runID
+}
+
+// machineSlots numbers the machines of each name prefix, which is
+// shared by the clusters of a run.
+var machineSlots = struct {
+	sync.Mutex
+	next map[string]int
+}{next: make(map[string]int)}
+
+// maxMachineName is the length limit of GCE instance names, the
+// strictest of the platforms.
+const maxMachineName = 63
+
+// NextMachineName allocates the canonical name of the next machine of
+// the cluster's run: the base name, RuntimeConfig.RunID and the slot
+// index in the run, e.g. "kola-20181016t125700z-builder-4242-0". A
+// cluster outside of a named run uses the first component of its own id
+// instead. Names are valid GCE instance names: lowercase letters, digits
+// and dashes, at most 63 characters. Platforms substitute the name for
+// $name in user data and use it wherever they can name a machine
+// themselves, so that a machine is identifiable by the same name across
+// logs, consoles and the cloud provider.
+func (bc *BaseCluster) NextMachineName() string {
+	run := bc.runID[:8]
+	if bc.rconf.RunID != "" {
+		run = bc.rconf.RunID
+	}
+	// leave room for the slot
+	prefix := machineNamePrefix(bc.baseopts.BaseName+"-"+run, maxMachineName-len("-999999"))
+
+	machineSlots.Lock()
+	slot := machineSlots.next[prefix]
+	machineSlots.next[prefix]++
+	machineSlots.Unlock()
+
+	return fmt.Sprintf("%s-%d", prefix, slot)
+}
+
+// machineNamePrefix makes s a valid start of a machine name of at most
+// max characters.
+func machineNamePrefix(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, s)
+	if len(s) > max {
+		s = s[:max]
+	}
+	return strings.TrimRight(s, "-")
+}
+
 func (bc *BaseCluster) RuntimeConf() RuntimeConfig {
-	return *bc.rconf
+	rconf := *bc.rconf
+	rconf.events = bc.events
+	return rconf
 }
 
 func (bc *BaseCluster) ConsoleOutput() map[string]string {
@@ -0,0 +1,78 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNextMachineName(t *testing.T) {
+	machineSlots.Lock()
+	machineSlots.next = make(map[string]int)
+	machineSlots.Unlock()
+
+	newCluster := func(runID string) *BaseCluster {
+		return &BaseCluster{
+			baseopts: &Options{BaseName: "kola"},
+			rconf:    &RuntimeConfig{RunID: runID},
+			runID:    "1b4e28ba-2fa1-11d2-883f-0016d3cca427",
+		}
+	}
+
+	// clusters of a run share its slots
+	first, second := newCluster("20181016T125700Z-Builder.example-4242"), newCluster("20181016T125700Z-Builder.example-4242")
+	for _, tt := range []struct {
+		bc   *BaseCluster
+		want string
+	}{
+		{first, "kola-20181016t125700z-builder-example-4242-0"},
+		{second, "kola-20181016t125700z-builder-example-4242-1"},
+		{first, "kola-20181016t125700z-builder-example-4242-2"},
+		{newCluster(""), "kola-1b4e28ba-0"},
+		{newCluster(""), "kola-1b4e28ba-1"},
+		{newCluster(strings.Repeat("run_", 20)), "kola-run-run-run-run-run-run-run-run-run-run-run-run-run-0"},
+	} {
+		if got := tt.bc.NextMachineName(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		} else if len(got) > maxMachineName {
+			t.Errorf("%q is longer than %d characters", got, maxMachineName)
+		}
+	}
+}
+
+func TestGetDiscoveryURL(t *testing.T) {
+	var tokens int
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/new" || r.URL.Query().Get("size") != "3" {
+			http.NotFound(w, r)
+			return
+		}
+		tokens++
+		fmt.Fprintf(w, "%s/token%d\n", srv.URL, tokens)
+	}))
+	defer srv.Close()
+
+	bc := &BaseCluster{baseopts: &Options{DiscoveryURL: srv.URL + "/"}}
+	for _, want := range []string{srv.URL + "/token1", srv.URL + "/token2"} {
+		if got, err := bc.GetDiscoveryURL(3); err != nil || got != want {
+			t.Errorf("got %q, %v; want %q", got, err, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Capability is an optional feature which only some platforms support.
+type Capability string
+
+const (
+	CapReboot           Capability = "reboot"            // Machine.Reboot works
+	CapNetworkPartition Capability = "network-partition" // machines can be cut off from each other
+	CapExtraDisks       Capability = "extra-disks"       // machines can have additional blank disks
+	CapReverseForward   Capability = "reverse-forward"   // machines can reach the harness via SSH remote forwards
+	CapConsole          Capability = "console"           // machines implement Console
+	CapMemoryBalloon    Capability = "memory-balloon"    // machines implement MemoryBalloon
+	CapMetadataService  Capability = "metadata-service"  // clusters implement MetadataService
+	CapCheckpoint       Capability = "checkpoint"        // clusters implement Checkpointer
+	CapDNSFixture       Capability = "dns-fixture"       // clusters implement DNSFixture
+)
+
+// AllCapabilities lists every known capability. Each platform must decide
+// on every entry; see NewCapabilities.
+var AllCapabilities = []Capability{
+	CapReboot,
+	CapNetworkPartition,
+	CapExtraDisks,
+	CapReverseForward,
+	CapConsole,
+	CapMemoryBalloon,
+	CapMetadataService,
+	CapCheckpoint,
+	CapDNSFixture,
+}
+
+// Capabilities is the set of capabilities a platform supports.
+type Capabilities map[Capability]bool
+
+// NewCapabilities creates the capability set for a platform. It panics
+// unless decisions contains an entry for each of AllCapabilities, so that
+// adding a capability forces every platform to be revisited.
+func NewCapabilities(decisions map[Capability]bool) Capabilities {
+	for _, c := range AllCapabilities {
+		if _, ok := decisions[c]; !ok {
+			panic(fmt.Sprintf("platform: no decision for capability %q", c))
+		}
+	}
+	if len(decisions) != len(AllCapabilities) {
+		panic("platform: decision for unknown capability")
+	}
+	return Capabilities(decisions)
+}
+
+// Has reports whether c is supported.
+func (cs Capabilities) Has(c Capability) bool {
+	return cs[c]
+}
+
+// Missing returns the sorted subset of want which is not supported.
+func (cs Capabilities) Missing(want []Capability) []Capability {
+	var missing []Capability
+	for _, c := range want {
+		if !cs.Has(c) {
+			missing = append(missing, c)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+	return missing
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// checkpointNameRE matches the names checkpoints may have, which are
+// passed to platform tools as is.
+var checkpointNameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Checkpointer is implemented by clusters on platforms with
+// CapCheckpoint. A checkpoint holds the disks and memory of all of the
+// cluster's machines at one moment, so that a test can set up a cluster
+// once and run several destructive scenarios from the same state.
+type Checkpointer interface {
+	// Checkpoint pauses all of the cluster's machines, saves their
+	// state as name, replacing any earlier checkpoint of that name,
+	// and resumes them.
+	Checkpoint(name string) error
+
+	// RestoreCheckpoint returns the cluster's machines to their state
+	// at the checkpoint name and waits for them to pass their checks.
+	// Machines created since are destroyed. It fails if a machine of
+	// the checkpoint has been destroyed.
+	RestoreCheckpoint(name string) error
+}
+
+// Checkpoint saves the state of c's machines as name. It returns
+// ErrNotSupported unless c implements Checkpointer.
+func Checkpoint(c Cluster, name string) error {
+	cp, ok := c.(Checkpointer)
+	if !ok {
+		return ErrNotSupported
+	}
+	if !checkpointNameRE.MatchString(name) {
+		return fmt.Errorf("invalid checkpoint name %q", name)
+	}
+	return cp.Checkpoint(name)
+}
+
+// RestoreCheckpoint returns c's machines to the checkpoint name. It
+// returns ErrNotSupported unless c implements Checkpointer.
+func RestoreCheckpoint(c Cluster, name string) error {
+	cp, ok := c.(Checkpointer)
+	if !ok {
+		return ErrNotSupported
+	}
+	return cp.RestoreCheckpoint(name)
+}
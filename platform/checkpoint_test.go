@@ -0,0 +1,58 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"testing"
+)
+
+type checkpointCluster struct {
+	Cluster
+	saved []string
+}
+
+func (c *checkpointCluster) Checkpoint(name string) error {
+	c.saved = append(c.saved, name)
+	return nil
+}
+
+func (c *checkpointCluster) RestoreCheckpoint(name string) error {
+	return nil
+}
+
+func TestCheckpoint(t *testing.T) {
+	c := &checkpointCluster{}
+	for _, name := range []string{"formed", "etcd-3.3_loaded"} {
+		if err := Checkpoint(c, name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-f", "two words", "a;quit"} {
+		if err := Checkpoint(c, name); err == nil {
+			t.Errorf("accepted %q", name)
+		}
+	}
+	if len(c.saved) != 2 {
+		t.Errorf("saved %v", c.saved)
+	}
+
+	var plain struct{ Cluster }
+	if err := Checkpoint(plain, "formed"); err != ErrNotSupported {
+		t.Errorf("got %v, want ErrNotSupported", err)
+	}
+	if err := RestoreCheckpoint(plain, "formed"); err != ErrNotSupported {
+		t.Errorf("got %v, want ErrNotSupported", err)
+	}
+}
@@ -15,9 +15,13 @@
 package conf
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/url"
+	"os"
 	"strings"
 
 	ct "github.com/coreos/container-linux-config-transpiler/config"
@@ -50,9 +54,17 @@ var plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "platform/conf"
 // UserData is an immutable, unvalidated configuration for a Container Linux
 // machine.
 type UserData struct {
-	kind      kind
-	data      string
-	extraKeys []*agent.Key // SSH keys to be injected during rendering
+	kind       kind
+	data       string
+	extraKeys  []*agent.Key // SSH keys to be injected during rendering
+	extraFiles []file       // files to be injected during rendering
+	vars       TemplateVars // variables of a templated config
+}
+
+type file struct {
+	path     string
+	contents string
+	mode     os.FileMode
 }
 
 // Conf is a configuration for a Container Linux machine. It may be either a
@@ -147,6 +159,35 @@ func (u *UserData) AddKey(key agent.Key) *UserData {
 	return &ret
 }
 
+// Adds a file to be written to the root filesystem and returns a new
+// UserData. Files are supported in cloud-configs, Container Linux Configs
+// and Ignition configs of version 2.0.0 or later.
+func (u *UserData) AddFile(path, contents string, mode os.FileMode) *UserData {
+	ret := *u
+	ret.extraFiles = append(append([]file{}, u.extraFiles...), file{path, contents, mode})
+	return &ret
+}
+
+// Digest returns a hash of the userdata, including any keys and files
+// added to it, so that equal userdata can be recognized later.
+func (u *UserData) Digest() string {
+	if u == nil {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00", u.kind, u.data)
+	for _, k := range u.extraKeys {
+		fmt.Fprintf(h, "key\x00%s\x00%x\x00", k.Format, k.Blob)
+	}
+	for _, f := range u.extraFiles {
+		fmt.Fprintf(h, "file\x00%s\x00%o\x00%s\x00", f.path, f.mode, f.contents)
+	}
+	if u.vars != (TemplateVars{}) {
+		fmt.Fprintf(h, "vars\x00%+v\x00", u.vars)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func (u *UserData) IsIgnitionCompatible() bool {
 	return u.kind == kindIgnition || u.kind == kindContainerLinuxConfig
 }
@@ -241,6 +282,17 @@ func (u *UserData) Render(ctPlatform string) (*Conf, error) {
 		c.CopyKeys(u.extraKeys)
 	}
 
+	if len(u.extraFiles) > 0 {
+		if c.ignitionV1 != nil || c.script != "" || c.IsEmpty() {
+			return nil, fmt.Errorf("cannot add files to this kind of userdata")
+		}
+		for _, f := range u.extraFiles {
+			if err := c.AddFile(f.path, f.contents, f.mode); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return c, nil
 }
 
@@ -331,6 +383,59 @@ func (c *Conf) AddSystemdUnit(name, contents string, enable bool) {
 	}
 }
 
+func (c *Conf) addNetworkdUnitV1(name, contents string) {
+	c.ignitionV1.Networkd.Units = append(c.ignitionV1.Networkd.Units, v1types.NetworkdUnit{
+		Name:     v1types.NetworkdUnitName(name),
+		Contents: contents,
+	})
+}
+
+func (c *Conf) addNetworkdUnitV2(name, contents string) {
+	c.ignitionV2.Networkd.Units = append(c.ignitionV2.Networkd.Units, v2types.NetworkdUnit{
+		Name:     v2types.NetworkdUnitName(name),
+		Contents: contents,
+	})
+}
+
+func (c *Conf) addNetworkdUnitV21(name, contents string) {
+	c.ignitionV21.Networkd.Units = append(c.ignitionV21.Networkd.Units, v21types.Networkdunit{
+		Name:     name,
+		Contents: contents,
+	})
+}
+
+func (c *Conf) addNetworkdUnitV22(name, contents string) {
+	c.ignitionV22.Networkd.Units = append(c.ignitionV22.Networkd.Units, v22types.Networkdunit{
+		Name:     name,
+		Contents: contents,
+	})
+}
+
+// coreos-cloudinit writes units named like networkd's configs to
+// /etc/systemd/network and restarts networkd to apply them.
+func (c *Conf) addNetworkdUnitCloudConfig(name, contents string) {
+	c.cloudconfig.CoreOS.Units = append(c.cloudconfig.CoreOS.Units, cci.Unit{
+		Name:    name,
+		Content: contents,
+	})
+}
+
+// AddNetworkdUnit adds a systemd-networkd config such as a .network file.
+// A unit named like one of the OS's own configs replaces it.
+func (c *Conf) AddNetworkdUnit(name, contents string) {
+	if c.ignitionV1 != nil {
+		c.addNetworkdUnitV1(name, contents)
+	} else if c.ignitionV2 != nil {
+		c.addNetworkdUnitV2(name, contents)
+	} else if c.ignitionV21 != nil {
+		c.addNetworkdUnitV21(name, contents)
+	} else if c.ignitionV22 != nil {
+		c.addNetworkdUnitV22(name, contents)
+	} else if c.cloudconfig != nil {
+		c.addNetworkdUnitCloudConfig(name, contents)
+	}
+}
+
 func (c *Conf) addSystemdDropinV1(service, name, contents string) {
 	for i, unit := range c.ignitionV1.Systemd.Units {
 		if unit.Name == v1types.SystemdUnitName(service) {
@@ -455,6 +560,82 @@ func (c *Conf) AddSystemdUnitDropin(service, name, contents string) {
 	}
 }
 
+func (c *Conf) addFileV2(path, contents string, mode os.FileMode) error {
+	u, err := url.Parse(dataURL(contents))
+	if err != nil {
+		return err
+	}
+	c.ignitionV2.Storage.Files = append(c.ignitionV2.Storage.Files, v2types.File{
+		Filesystem: "root",
+		Path:       v2types.Path(path),
+		Contents: v2types.FileContents{
+			Source: v2types.Url(*u),
+		},
+		Mode: v2types.FileMode(mode),
+	})
+	return nil
+}
+
+func (c *Conf) addFileV21(path, contents string, mode os.FileMode) {
+	c.ignitionV21.Storage.Files = append(c.ignitionV21.Storage.Files, v21types.File{
+		Node: v21types.Node{
+			Filesystem: "root",
+			Path:       path,
+		},
+		FileEmbedded1: v21types.FileEmbedded1{
+			Contents: v21types.FileContents{
+				Source: dataURL(contents),
+			},
+			Mode: int(mode),
+		},
+	})
+}
+
+func (c *Conf) addFileV22(path, contents string, mode os.FileMode) {
+	m := int(mode)
+	c.ignitionV22.Storage.Files = append(c.ignitionV22.Storage.Files, v22types.File{
+		Node: v22types.Node{
+			Filesystem: "root",
+			Path:       path,
+		},
+		FileEmbedded1: v22types.FileEmbedded1{
+			Contents: v22types.FileContents{
+				Source: dataURL(contents),
+			},
+			Mode: &m,
+		},
+	})
+}
+
+func (c *Conf) addFileCloudConfig(path, contents string, mode os.FileMode) {
+	c.cloudconfig.WriteFiles = append(c.cloudconfig.WriteFiles, cci.File{
+		Content:            contents,
+		Path:               path,
+		RawFilePermissions: fmt.Sprintf("%#o", mode),
+	})
+}
+
+// AddFile adds a file to the root filesystem. It returns an error if the
+// configuration cannot carry files.
+func (c *Conf) AddFile(path, contents string, mode os.FileMode) error {
+	if c.ignitionV2 != nil {
+		return c.addFileV2(path, contents, mode)
+	} else if c.ignitionV21 != nil {
+		c.addFileV21(path, contents, mode)
+	} else if c.ignitionV22 != nil {
+		c.addFileV22(path, contents, mode)
+	} else if c.cloudconfig != nil {
+		c.addFileCloudConfig(path, contents, mode)
+	} else {
+		return fmt.Errorf("cannot add file %s to this kind of config", path)
+	}
+	return nil
+}
+
+func dataURL(contents string) string {
+	return "data:," + url.PathEscape(contents)
+}
+
 func (c *Conf) copyKeysIgnitionV1(keys []*agent.Key) {
 	keyStrs := keysToStrings(keys)
 	for i := range c.ignitionV1.Passwd.Users {
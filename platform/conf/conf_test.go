@@ -59,3 +59,125 @@ func TestConfCopyKey(t *testing.T) {
 		}
 	}
 }
+
+func TestConfAddFile(t *testing.T) {
+	tests := []*UserData{
+		ContainerLinuxConfig(""),
+		Ignition(`{ "ignition": { "version": "2.2.0" } }`),
+		Ignition(`{ "ignition": { "version": "2.1.0" } }`),
+		Ignition(`{ "ignition": { "version": "2.0.0" } }`),
+		CloudConfig("#cloud-config"),
+	}
+
+	for i, tt := range tests {
+		conf, err := tt.AddFile("/opt/kola/test.sh", "#!/bin/bash\necho hi\n", 0755).Render("")
+		if err != nil {
+			t.Errorf("failed to render config %d: %v", i, err)
+			continue
+		}
+
+		str := conf.String()
+		if !strings.Contains(str, "/opt/kola/test.sh") {
+			t.Errorf("file not found in config %d: %s", i, str)
+		}
+	}
+
+	unsupported := []*UserData{
+		Ignition(`{ "ignitionVersion": 1 }`),
+		Script("#!/bin/bash"),
+		Empty(),
+	}
+
+	for i, tt := range unsupported {
+		if _, err := tt.AddFile("/opt/kola/test.sh", "", 0644).Render(""); err == nil {
+			t.Errorf("adding file to unsupported config %d succeeded", i)
+		}
+	}
+}
+
+func TestConfAddNetworkdUnit(t *testing.T) {
+	tests := []*UserData{
+		ContainerLinuxConfig(""),
+		Ignition(`{ "ignition": { "version": "2.2.0" } }`),
+		Ignition(`{ "ignition": { "version": "2.1.0" } }`),
+		Ignition(`{ "ignition": { "version": "2.0.0" } }`),
+		Ignition(`{ "ignitionVersion": 1 }`),
+		CloudConfig("#cloud-config"),
+	}
+
+	for i, tt := range tests {
+		conf, err := tt.Render("")
+		if err != nil {
+			t.Errorf("failed to render config %d: %v", i, err)
+			continue
+		}
+		conf.AddNetworkdUnit("zz-default.network", "[Network]\nDNS=169.254.169.253\n")
+
+		str := conf.String()
+		if !strings.Contains(str, "zz-default.network") || !strings.Contains(str, "169.254.169.253") {
+			t.Errorf("networkd unit not found in config %d: %s", i, str)
+		}
+	}
+}
+
+func TestUserDataDigest(t *testing.T) {
+	ign := Ignition(`{ "ignition": { "version": "2.2.0" } }`)
+	if ign.Digest() != Ignition(`{ "ignition": { "version": "2.2.0" } }`).Digest() {
+		t.Errorf("equal userdata has different digests")
+	}
+
+	differing := []*UserData{
+		nil,
+		CloudConfig(`{ "ignition": { "version": "2.2.0" } }`),
+		ign.Subst("2.2.0", "2.1.0"),
+		ign.AddFile("/opt/kola/test.sh", "", 0644),
+		ign.AddFile("/opt/kola/test.sh", "", 0755),
+	}
+	seen := map[string]int{ign.Digest(): -1}
+	for i, u := range differing {
+		d := u.Digest()
+		if j, ok := seen[d]; ok {
+			t.Errorf("userdata %d has the same digest as %d", i, j)
+		}
+		seen[d] = i
+	}
+}
+
+func TestIntentUserData(t *testing.T) {
+	intent := &Intent{
+		Users: []User{{
+			Name:              "kola",
+			Groups:            []string{"sudo"},
+			SSHAuthorizedKeys: []string{"ssh-rsa AAAA kola"},
+		}},
+		Files: []File{{Path: "/opt/kola/test.sh", Contents: "#!/bin/bash\n", Mode: 0755}},
+		Units: []Unit{{Name: "kola.service", Contents: "[Service]\nExecStart=/opt/kola/test.sh\n", Enable: true}},
+	}
+
+	for _, format := range Formats {
+		userdata, err := intent.UserData(format)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if userdata.IsIgnitionCompatible() != (format == FormatIgnition) {
+			t.Errorf("%s: rendered as the wrong kind of userdata", format)
+		}
+
+		conf, err := userdata.Render("")
+		if err != nil {
+			t.Errorf("%s: failed to render: %v", format, err)
+			continue
+		}
+		str := conf.String()
+		for _, want := range []string{"kola", "ssh-rsa AAAA kola", "/opt/kola/test.sh", "kola.service"} {
+			if !strings.Contains(str, want) {
+				t.Errorf("%s: %q not found in config: %s", format, want, str)
+			}
+		}
+	}
+
+	if _, err := intent.UserData("yaml"); err == nil {
+		t.Errorf("rendering unknown format succeeded")
+	}
+}
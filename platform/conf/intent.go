@@ -0,0 +1,150 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"os"
+
+	cci "github.com/coreos/coreos-cloudinit/config"
+	v22types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+// Format is a config system an Intent can be rendered for.
+type Format string
+
+const (
+	FormatCloudConfig Format = "cloud-config"
+	FormatIgnition    Format = "ignition"
+)
+
+// Formats lists every Format.
+var Formats = []Format{FormatCloudConfig, FormatIgnition}
+
+// ParseFormat returns the Format named s.
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown config format %q", s)
+}
+
+// Intent is a minimal machine configuration which can be rendered as
+// either a cloud-config or an Ignition config, so that a test written
+// against it covers both config systems.
+type Intent struct {
+	Users []User
+	Files []File
+	Units []Unit
+}
+
+// User is a user to create, or to add keys and groups to if it exists.
+type User struct {
+	Name              string
+	Groups            []string
+	SSHAuthorizedKeys []string
+}
+
+// File is written to the root filesystem.
+type File struct {
+	Path     string
+	Contents string
+	Mode     os.FileMode
+}
+
+// Unit is a systemd unit.
+type Unit struct {
+	Name     string
+	Contents string
+	Enable   bool
+}
+
+// Copy returns a deep copy of i.
+func (i *Intent) Copy() *Intent {
+	c := &Intent{
+		Files: append([]File(nil), i.Files...),
+		Units: append([]Unit(nil), i.Units...),
+	}
+	for _, u := range i.Users {
+		u.Groups = append([]string(nil), u.Groups...)
+		u.SSHAuthorizedKeys = append([]string(nil), u.SSHAuthorizedKeys...)
+		c.Users = append(c.Users, u)
+	}
+	return c
+}
+
+// UserData renders the intent in format. The result is an ordinary
+// cloud-config or Ignition UserData, validated like any other when the
+// machine is created.
+func (i *Intent) UserData(format Format) (*UserData, error) {
+	c := &Conf{}
+	switch format {
+	case FormatCloudConfig:
+		c.cloudconfig = &cci.CloudConfig{}
+	case FormatIgnition:
+		c.ignitionV22 = &v22types.Config{
+			Ignition: v22types.Ignition{Version: "2.2.0"},
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+
+	for _, u := range i.Users {
+		c.addUser(u)
+	}
+	for _, f := range i.Files {
+		if err := c.AddFile(f.Path, f.Contents, f.Mode); err != nil {
+			return nil, err
+		}
+	}
+	for _, u := range i.Units {
+		c.AddSystemdUnit(u.Name, u.Contents, u.Enable)
+	}
+
+	if format == FormatCloudConfig {
+		return CloudConfig(c.String()), nil
+	}
+	return Ignition(c.String()), nil
+}
+
+func (c *Conf) addUserIgnitionV22(u User) {
+	user := v22types.PasswdUser{Name: u.Name}
+	for _, g := range u.Groups {
+		user.Groups = append(user.Groups, v22types.Group(g))
+	}
+	for _, k := range u.SSHAuthorizedKeys {
+		user.SSHAuthorizedKeys = append(user.SSHAuthorizedKeys, v22types.SSHAuthorizedKey(k))
+	}
+	c.ignitionV22.Passwd.Users = append(c.ignitionV22.Passwd.Users, user)
+}
+
+func (c *Conf) addUserCloudConfig(u User) {
+	c.cloudconfig.Users = append(c.cloudconfig.Users, cci.User{
+		Name:              u.Name,
+		Groups:            u.Groups,
+		SSHAuthorizedKeys: u.SSHAuthorizedKeys,
+	})
+}
+
+// addUser is only needed for the configs an Intent renders to.
+func (c *Conf) addUser(u User) {
+	if c.ignitionV22 != nil {
+		c.addUserIgnitionV22(u)
+	} else if c.cloudconfig != nil {
+		c.addUserCloudConfig(u)
+	}
+}
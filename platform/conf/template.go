@@ -0,0 +1,220 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// TemplateVars are the variables of a config written as a Go
+// text/template, e.g. {{.DiscoveryURL}}. Configs without template
+// actions instead have $discovery and $name replaced.
+type TemplateVars struct {
+	MachineVars
+
+	DiscoveryURL string // the etcd discovery URL of the machine's cluster
+	Index        int    // the machine's index among those started together
+	ClusterSize  int    // the number of machines started together
+
+	// AddressFamily is how PeerURL and ClientURL address the machine:
+	// FamilyIPv4, the default, FamilyIPv6 or FamilyHostname.
+	AddressFamily string
+}
+
+// MachineVars are the template variables of the machine a config is
+// rendered for, set by its platform. Addresses are empty where the
+// platform doesn't know them, and may be placeholders such as
+// ${COREOS_GCE_IP_LOCAL_0} which coreos-metadata fills in.
+type MachineVars struct {
+	Name        string // the machine's name
+	PrivateIPv4 string
+	PrivateIPv6 string
+}
+
+// The address families of TemplateVars.AddressFamily.
+const (
+	FamilyIPv4     = "ipv4"
+	FamilyIPv6     = "ipv6"
+	FamilyHostname = "hostname"
+)
+
+// exampleMachine stands in for the machine when a template is checked
+// before any machine exists, with addresses reserved for documentation.
+var exampleMachine = MachineVars{
+	Name:        "example",
+	PrivateIPv4: "192.0.2.1",
+	PrivateIPv6: "2001:db8::1",
+}
+
+// PeerURL returns the URL of the machine's etcd peer port, e.g.
+// {{.PeerURL "http" 2380}}, in the preferred AddressFamily.
+func (v TemplateVars) PeerURL(scheme string, port int) (string, error) {
+	return v.url(scheme, port)
+}
+
+// ClientURL returns the URL of the machine's etcd client port, e.g.
+// {{.ClientURL "https" 2379}}, in the preferred AddressFamily.
+func (v TemplateVars) ClientURL(scheme string, port int) (string, error) {
+	return v.url(scheme, port)
+}
+
+func (v TemplateVars) url(scheme string, port int) (string, error) {
+	var host string
+	switch v.AddressFamily {
+	case "", FamilyIPv4:
+		host = v.PrivateIPv4
+	case FamilyIPv6:
+		host = v.PrivateIPv6
+	case FamilyHostname:
+		host = v.Name
+	default:
+		return "", fmt.Errorf("unknown address family %q", v.AddressFamily)
+	}
+	if host == "" {
+		family := v.AddressFamily
+		if family == "" {
+			family = FamilyIPv4
+		}
+		return "", fmt.Errorf("machine has no %s address on this platform", family)
+	}
+	// JoinHostPort brackets IPv6 literals
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(port))), nil
+}
+
+// WithVars returns a new UserData whose template is executed with vars.
+// The platform sets the MachineVars when it renders the config.
+func (u *UserData) WithVars(vars TemplateVars) *UserData {
+	ret := *u
+	ret.vars = vars
+	return &ret
+}
+
+// NeedsDiscovery reports whether the config refers to the etcd
+// discovery URL, as $discovery or {{.DiscoveryURL}}.
+func (u *UserData) NeedsDiscovery() bool {
+	return u.Contains("$discovery") || u.Contains(".DiscoveryURL")
+}
+
+// parseTemplate parses the config as a template, returning nil if it has
+// no template actions.
+func (u *UserData) parseTemplate() (*template.Template, error) {
+	if !u.Contains("{{") {
+		return nil, nil
+	}
+	tmpl, err := template.New("config").Option("missingkey=error").Parse(u.data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config template: %v", err)
+	}
+	if tmpl.Tree == nil {
+		return nil, nil
+	}
+	for _, n := range tmpl.Tree.Root.Nodes {
+		if n.Type() != parse.NodeText {
+			return tmpl, nil
+		}
+	}
+	return nil, nil
+}
+
+// IsTemplate reports whether the config has template actions, failing if
+// it can't be parsed as a template.
+func (u *UserData) IsTemplate() (bool, error) {
+	tmpl, err := u.parseTemplate()
+	return tmpl != nil, err
+}
+
+// ExecuteTemplate returns a new UserData with the template executed for
+// the machine, or u itself if it has no template actions. The URLs in the
+// result must be valid.
+func (u *UserData) ExecuteTemplate(machine MachineVars) (*UserData, error) {
+	tmpl, err := u.parseTemplate()
+	if err != nil || tmpl == nil {
+		return u, err
+	}
+	vars := u.vars
+	vars.MachineVars = machine
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		// drop the template's name, which is always "config"
+		return nil, fmt.Errorf("executing config template: %v", strings.TrimPrefix(err.Error(), "template: "))
+	}
+	if err := checkURLs(buf.String()); err != nil {
+		return nil, fmt.Errorf("executing config template: %v", err)
+	}
+	ret := *u
+	ret.data = buf.String()
+	return &ret, nil
+}
+
+// ForCluster returns the config with the variables of its cluster: set
+// for the platform to execute it with if it has template actions, or
+// with vars.DiscoveryURL replacing $discovery if not. It fails if the
+// config is a template which can't be executed, so that no machine is
+// created with it.
+func (u *UserData) ForCluster(vars TemplateVars) (*UserData, error) {
+	template, err := u.IsTemplate()
+	if err != nil {
+		return nil, err
+	}
+	if template {
+		ret := u.WithVars(vars)
+		if _, err := ret.ExecuteTemplate(exampleMachine); err != nil {
+			return nil, err
+		}
+		return ret, nil
+	}
+	if vars.DiscoveryURL != "" {
+		return u.Subst("$discovery", vars.DiscoveryURL), nil
+	}
+	return u, nil
+}
+
+var (
+	// urlRE finds URLs in a config, which may be in JSON or YAML
+	// strings or command lines with comma-separated lists of URLs.
+	urlRE = regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^\s"',\\]+`)
+
+	// placeholderRE finds variables coreos-metadata fills in, which
+	// aren't valid in a host name until then.
+	placeholderRE = regexp.MustCompile(`\$\{[A-Za-z0-9_]+\}`)
+)
+
+// checkURLs fails if a URL in data doesn't parse or has an IPv6 literal
+// without brackets, which would leave a cluster unable to form.
+func checkURLs(data string) error {
+	for _, raw := range urlRE.FindAllString(data, -1) {
+		u, err := url.Parse(placeholderRE.ReplaceAllString(raw, "placeholder"))
+		if err != nil {
+			return fmt.Errorf("invalid URL %q: %v", raw, err)
+		}
+		if strings.Count(u.Host, ":") > 1 && !strings.HasPrefix(u.Host, "[") {
+			return fmt.Errorf("invalid URL %q: IPv6 address must be in brackets", raw)
+		}
+		if port := u.Port(); port != "" {
+			if n, err := strconv.Atoi(port); err != nil || n > 65535 {
+				return fmt.Errorf("invalid URL %q: bad port %q", raw, port)
+			}
+		}
+	}
+	return nil
+}
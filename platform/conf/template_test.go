@@ -0,0 +1,176 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsTemplate(t *testing.T) {
+	for data, want := range map[string]bool{
+		"#cloud-config\n":                    false,
+		"discovery: $discovery":              false,
+		"discovery: {{.DiscoveryURL}}":       true,
+		"{{if .Index}}proxy: on{{end}}":      true,
+		`{"ignition": {"version": "2.0.0"}}`: false,
+		"{{/* just a comment */}}":           false,
+	} {
+		got, err := ContainerLinuxConfig(data).IsTemplate()
+		if err != nil {
+			t.Errorf("%q: %v", data, err)
+		}
+		if got != want {
+			t.Errorf("%q: got %v, want %v", data, got, want)
+		}
+	}
+}
+
+func TestExecuteTemplate(t *testing.T) {
+	u := ContainerLinuxConfig(`etcd:
+  name: {{.Name}}
+  discovery: {{.DiscoveryURL}}
+{{- if gt .Index 0}}
+  proxy: on
+{{- end}}
+# {{.Index}} of {{.ClusterSize}}, not $name
+`).WithVars(TemplateVars{DiscoveryURL: "https://discovery.etcd.io/abc", Index: 1, ClusterSize: 3})
+
+	got, err := u.ExecuteTemplate(MachineVars{Name: "kola-1b4e28ba-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `etcd:
+  name: kola-1b4e28ba-1
+  discovery: https://discovery.etcd.io/abc
+  proxy: on
+# 1 of 3, not $name
+`
+	if got.data != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got.data, want)
+	}
+	if got.kind != kindContainerLinuxConfig {
+		t.Errorf("lost the kind of config")
+	}
+	if u.Digest() == u.WithVars(TemplateVars{Index: 2}).Digest() {
+		t.Errorf("configs with different variables have the same digest")
+	}
+}
+
+func TestExecuteTemplateLegacy(t *testing.T) {
+	// configs without template actions are left alone
+	u := CloudConfig("#cloud-config\ncoreos:\n  etcd2:\n    discovery: $discovery\n")
+	got, err := u.WithVars(TemplateVars{DiscoveryURL: "x"}).ExecuteTemplate(MachineVars{Name: "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.data != u.data {
+		t.Errorf("changed config without template actions:\n%s", got.data)
+	}
+	if !u.NeedsDiscovery() || !Ignition(`{"a": "{{.DiscoveryURL}}"}`).NeedsDiscovery() {
+		t.Errorf("didn't notice a discovery URL")
+	}
+	if CloudConfig("#cloud-config").NeedsDiscovery() {
+		t.Errorf("noticed a discovery URL which isn't there")
+	}
+}
+
+func TestExecuteTemplateErrors(t *testing.T) {
+	for _, data := range []string{
+		"discovery: {{.DiscoveryUrl}}",
+		"discovery: {{.DiscoveryURL",
+		"{{template \"missing\"}}",
+		"{{range .Index}}",
+	} {
+		if _, err := CloudConfig(data).ExecuteTemplate(MachineVars{Name: "name"}); err == nil {
+			t.Errorf("%q: executed", data)
+		} else if !strings.Contains(err.Error(), "config template") {
+			t.Errorf("%q: unclear error %v", data, err)
+		}
+	}
+}
+
+func TestForCluster(t *testing.T) {
+	vars := TemplateVars{DiscoveryURL: "https://discovery.etcd.io/abc", Index: 3, ClusterSize: 4}
+
+	got, err := CloudConfig("discovery: $discovery\nname: $name\n").ForCluster(vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "discovery: https://discovery.etcd.io/abc\nname: $name\n"; got.data != want {
+		t.Errorf("legacy config: got %q, want %q", got.data, want)
+	}
+
+	got, err = CloudConfig("index: {{.Index}}").ForCluster(vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.vars != vars {
+		t.Errorf("template config: got vars %+v, want %+v", got.vars, vars)
+	}
+
+	if _, err := CloudConfig("index: {{.Idx}}").ForCluster(vars); err == nil {
+		t.Errorf("accepted a template which can't be executed")
+	}
+}
+
+func TestURLHelpers(t *testing.T) {
+	machine := MachineVars{Name: "kola-1b4e28ba-0", PrivateIPv4: "10.0.0.2", PrivateIPv6: "fd00::2"}
+	for _, tt := range []struct {
+		family  string
+		machine MachineVars
+		want    string
+	}{
+		{"", machine, "http://10.0.0.2:2380 https://10.0.0.2:2379"},
+		{FamilyIPv4, machine, "http://10.0.0.2:2380 https://10.0.0.2:2379"},
+		{FamilyIPv6, machine, "http://[fd00::2]:2380 https://[fd00::2]:2379"},
+		{FamilyHostname, machine, "http://kola-1b4e28ba-0:2380 https://kola-1b4e28ba-0:2379"},
+		{FamilyIPv4, MachineVars{PrivateIPv4: "${COREOS_GCE_IP_LOCAL_0}"}, "http://${COREOS_GCE_IP_LOCAL_0}:2380 https://${COREOS_GCE_IP_LOCAL_0}:2379"},
+		{FamilyIPv6, MachineVars{PrivateIPv4: "10.0.0.2"}, ""},
+		{"ipx", machine, ""},
+	} {
+		u := CloudConfig(`{{.PeerURL "http" 2380}} {{.ClientURL "https" 2379}}`).WithVars(TemplateVars{AddressFamily: tt.family})
+		got, err := u.ExecuteTemplate(tt.machine)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s %+v: got %q, want an error", tt.family, tt.machine, got.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %+v: %v", tt.family, tt.machine, err)
+		} else if got.data != tt.want {
+			t.Errorf("%s %+v: got %q, want %q", tt.family, tt.machine, got.data, tt.want)
+		}
+	}
+}
+
+func TestCheckURLs(t *testing.T) {
+	for data, valid := range map[string]bool{
+		"--listen-peer-urls=http://10.0.0.2:2380,http://10.0.0.2:7001":            true,
+		`{"contents": "ExecStart=/usr/bin/etcd2 --peer=http://[fd00::2]:2380\n"}`: true,
+		"initial-cluster: a=http://kola-1b4e28ba-0:2380":                          true,
+		"advertise: http://${COREOS_EC2_IPV4_LOCAL}:2379":                         true,
+		"advertise: http://$private_ipv4:2379":                                    true,
+		"peer: http://fd00::2:2380":                                               false,
+		"peer: http://[fd00::2:2380":                                              false,
+		"peer: http://10.0.0.2:99999":                                             false,
+		"peer: http://10.0.0.2:port":                                              false,
+	} {
+		if err := checkURLs(data); (err == nil) != valid {
+			t.Errorf("%q: got error %v, want valid %v", data, err, valid)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrConsoleInUse is returned by Console.Console while another stream to
+// the same console is open.
+var ErrConsoleInUse = errors.New("console already in use")
+
+// Console is implemented by machines on platforms with CapConsole. It
+// allows tests to interact with the machine before or without networking,
+// e.g. in the emergency shell.
+type Console interface {
+	// Console returns a stream attached to the machine's serial
+	// console. Only one stream may be open at a time. The console
+	// output is still saved with the test's artifacts.
+	Console() (io.ReadWriteCloser, error)
+}
+
+// ConsoleSession provides expect-style helpers for a console stream.
+type ConsoleSession struct {
+	rw io.ReadWriteCloser
+
+	mu      sync.Mutex
+	buf     []byte        // output not yet consumed by WaitForPrompt
+	err     error         // set once reading fails
+	changed chan struct{} // closed and replaced whenever buf or err changes
+}
+
+// NewConsoleSession starts reading from rw. The session owns rw and
+// closes it in Close.
+func NewConsoleSession(rw io.ReadWriteCloser) *ConsoleSession {
+	s := &ConsoleSession{
+		rw:      rw,
+		changed: make(chan struct{}),
+	}
+	go s.read()
+	return s
+}
+
+func (s *ConsoleSession) read() {
+	b := make([]byte, 4096)
+	for {
+		n, err := s.rw.Read(b)
+		s.mu.Lock()
+		s.buf = append(s.buf, b[:n]...)
+		if err != nil {
+			s.err = err
+		}
+		close(s.changed)
+		s.changed = make(chan struct{})
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// WaitForPrompt waits until the console output matches re and returns the
+// output up to the end of the match. Output is consumed, so a later call
+// only sees what was printed after the match.
+func (s *ConsoleSession) WaitForPrompt(re *regexp.Regexp, timeout time.Duration) (string, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		if loc := re.FindIndex(s.buf); loc != nil {
+			out := string(s.buf[:loc[1]])
+			s.buf = s.buf[loc[1]:]
+			s.mu.Unlock()
+			return out, nil
+		}
+		err, changed, seen := s.err, s.changed, string(s.buf)
+		s.mu.Unlock()
+
+		if err != nil {
+			return seen, fmt.Errorf("console closed before %q appeared: %v", re, err)
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return seen, fmt.Errorf("timed out after %v waiting for %q", timeout, re)
+		}
+	}
+}
+
+// SendLine types line followed by Enter.
+func (s *ConsoleSession) SendLine(line string) error {
+	_, err := io.WriteString(s.rw, line+"\r")
+	return err
+}
+
+// Close closes the console stream.
+func (s *ConsoleSession) Close() error {
+	return s.rw.Close()
+}
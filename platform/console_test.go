@@ -0,0 +1,70 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestConsoleSession(t *testing.T) {
+	guest, host := net.Pipe()
+	s := NewConsoleSession(host)
+	defer s.Close()
+
+	// a fake shell echoing commands back
+	go func() {
+		io.WriteString(guest, "boot messages\r\ncore@localhost ~ $ ")
+		r := bufio.NewReader(guest)
+		line, err := r.ReadString('\r')
+		if err != nil {
+			return
+		}
+		io.WriteString(guest, line+"\nactive\r\ncore@localhost ~ $ ")
+	}()
+
+	prompt := regexp.MustCompile(`\$ $`)
+	out, err := s.WaitForPrompt(prompt, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "boot messages\r\ncore@localhost ~ $ " {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	if err := s.SendLine("systemctl is-active foo"); err != nil {
+		t.Fatal(err)
+	}
+	out, err = s.WaitForPrompt(prompt, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`\nactive\r\n`).MatchString(out) {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	if _, err := s.WaitForPrompt(prompt, 10*time.Millisecond); err == nil {
+		t.Errorf("expected timeout")
+	}
+
+	guest.Close()
+	if _, err := s.WaitForPrompt(prompt, time.Second); err == nil {
+		t.Errorf("expected error after console closed")
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DestroyOrder is the order in which destroying a cluster tears down its
+// machines. Each machine's destroying and destroyed events record when
+// it happened.
+type DestroyOrder string
+
+const (
+	// DestroyCreation destroys the machines one at a time in the order
+	// they were created. It is the default.
+	DestroyCreation DestroyOrder = "creation"
+	// DestroyReverseCreation destroys the machines one at a time,
+	// newest first.
+	DestroyReverseCreation DestroyOrder = "reverse-creation"
+	// DestroyLeaderLast destroys the machines in the order they were
+	// created, except for the leader, which is destroyed last. Unless
+	// set with SetDestroyOrder, the leader is the first machine
+	// created.
+	DestroyLeaderLast DestroyOrder = "leader-last"
+	// DestroySimultaneous stops all machines at once, as a power
+	// failure of the whole cluster would, by killing those which are
+	// Killers without letting them shut down.
+	DestroySimultaneous DestroyOrder = "simultaneous"
+)
+
+// Valid returns an error unless o is one of the DestroyOrders, or empty
+// for the default.
+func (o DestroyOrder) Valid() error {
+	switch o {
+	case "", DestroyCreation, DestroyReverseCreation, DestroyLeaderLast, DestroySimultaneous:
+		return nil
+	}
+	return fmt.Errorf("unknown destroy order %q", o)
+}
+
+// DestroyOrderer is implemented by clusters whose machines can be
+// destroyed in a chosen order, which every cluster built on BaseCluster
+// can.
+type DestroyOrderer interface {
+	// SetDestroyOrder sets the order in which Destroy tears down the
+	// cluster's machines. leader is the ID of the machine
+	// DestroyLeaderLast destroys last, or empty for the first machine
+	// created.
+	SetDestroyOrder(order DestroyOrder, leader string) error
+}
+
+// SetDestroyOrder sets the order in which c's machines are destroyed. It
+// returns ErrNotSupported unless c implements DestroyOrderer.
+func SetDestroyOrder(c Cluster, order DestroyOrder, leader string) error {
+	d, ok := c.(DestroyOrderer)
+	if !ok {
+		return ErrNotSupported
+	}
+	return d.SetDestroyOrder(order, leader)
+}
+
+func (bc *BaseCluster) SetDestroyOrder(order DestroyOrder, leader string) error {
+	if err := order.Valid(); err != nil {
+		return err
+	}
+	bc.machlock.Lock()
+	defer bc.machlock.Unlock()
+	bc.destroyOrder = order
+	bc.leader = leader
+	return nil
+}
+
+// destroyMachines destroys the cluster's machines in its DestroyOrder.
+func (bc *BaseCluster) destroyMachines() {
+	bc.machlock.Lock()
+	order, leader := bc.destroyOrder, bc.leader
+	machines := make([]Machine, 0, len(bc.machmap))
+	for _, m := range bc.machmap {
+		machines = append(machines, m)
+	}
+	sort.Slice(machines, func(i, j int) bool {
+		ci, cj := bc.created[machines[i].ID()], bc.created[machines[j].ID()]
+		if !ci.Equal(cj) {
+			return ci.Before(cj)
+		}
+		return machines[i].ID() < machines[j].ID()
+	})
+	bc.machlock.Unlock()
+
+	if order == "" {
+		order = DestroyCreation
+	}
+	switch order {
+	case DestroyReverseCreation:
+		for i, j := 0, len(machines)-1; i < j; i, j = i+1, j-1 {
+			machines[i], machines[j] = machines[j], machines[i]
+		}
+	case DestroyLeaderLast:
+		if leader == "" && len(machines) > 0 {
+			leader = machines[0].ID()
+		}
+		found := false
+		for i, m := range machines {
+			if m.ID() == leader {
+				machines = append(append(machines[:i:i], machines[i+1:]...), m)
+				found = true
+				break
+			}
+		}
+		if !found && len(machines) > 0 {
+			plog.Warningf("Leader %v of cluster %v is gone; destroying its machines in creation order", leader, bc.name)
+		}
+	case DestroySimultaneous:
+		destroySimultaneously(bc, machines)
+		return
+	}
+
+	for i, m := range machines {
+		bc.events.emit(m.ID(), MachineDestroying, fmt.Sprintf("%s, %d of %d", order, i+1, len(machines)))
+		m.Destroy()
+	}
+}
+
+// destroySimultaneously stops machines in parallel, releasing them
+// together once each has its own goroutine so that they stop as close to
+// the same moment as possible.
+func destroySimultaneously(bc *BaseCluster, machines []Machine) {
+	var ready, done sync.WaitGroup
+	start := make(chan struct{})
+	for _, m := range machines {
+		ready.Add(1)
+		done.Add(1)
+		go func(m Machine) {
+			defer done.Done()
+			ready.Done()
+			<-start
+			if k, ok := m.(Killer); ok {
+				bc.events.emit(m.ID(), MachineDestroying, string(DestroySimultaneous)+", killed")
+				k.Kill()
+			} else {
+				bc.events.emit(m.ID(), MachineDestroying, string(DestroySimultaneous))
+				m.Destroy()
+			}
+		}(m)
+	}
+	ready.Wait()
+	close(start)
+	done.Wait()
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderMachine records how it was stopped in log.
+type orderMachine struct {
+	Machine
+	id  string
+	mu  *sync.Mutex
+	log *[]string
+}
+
+func (m *orderMachine) ID() string {
+	return m.id
+}
+
+func (m *orderMachine) Destroy() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*m.log = append(*m.log, "destroy "+m.id)
+}
+
+// killMachine is an orderMachine which is a Killer.
+type killMachine struct {
+	orderMachine
+}
+
+func (m *killMachine) Kill() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*m.log = append(*m.log, "kill "+m.id)
+}
+
+func TestDestroyOrder(t *testing.T) {
+	for _, tc := range []struct {
+		order  DestroyOrder
+		leader string
+		kill   bool
+		want   []string
+	}{
+		{"", "", false, []string{"destroy a", "destroy b", "destroy c"}},
+		{DestroyReverseCreation, "", false, []string{"destroy c", "destroy b", "destroy a"}},
+		{DestroyLeaderLast, "b", false, []string{"destroy a", "destroy c", "destroy b"}},
+		{DestroyLeaderLast, "", false, []string{"destroy b", "destroy c", "destroy a"}},
+		{DestroyLeaderLast, "gone", false, []string{"destroy a", "destroy b", "destroy c"}},
+		{DestroySimultaneous, "", false, []string{"destroy a", "destroy b", "destroy c"}},
+		{DestroySimultaneous, "", true, []string{"kill a", "kill b", "kill c"}},
+	} {
+		var mu sync.Mutex
+		var log []string
+		bc := &BaseCluster{
+			machmap: make(map[string]Machine),
+			created: make(map[string]time.Time),
+			events:  &eventBus{},
+		}
+		events := bc.Events()
+		now := time.Now()
+		for i, id := range []string{"a", "b", "c"} {
+			om := orderMachine{id: id, mu: &mu, log: &log}
+			if tc.kill {
+				bc.machmap[id] = &killMachine{om}
+			} else {
+				bc.machmap[id] = &om
+			}
+			bc.created[id] = now.Add(time.Duration(i) * time.Second)
+		}
+		if err := bc.SetDestroyOrder(tc.order, tc.leader); err != nil {
+			t.Fatal(err)
+		}
+
+		bc.destroyMachines()
+		bc.events.close()
+		var destroying int
+		for ev := range events {
+			if ev.Type != MachineDestroying {
+				t.Errorf("%s: unexpected event %+v", tc.order, ev)
+			}
+			destroying++
+		}
+		if destroying != 3 {
+			t.Errorf("%s: got %d destroying events, want 3", tc.order, destroying)
+		}
+
+		if tc.order == DestroySimultaneous {
+			// the order is up to the scheduler
+			mu.Lock()
+			sort.Strings(log)
+			mu.Unlock()
+		}
+		if !reflect.DeepEqual(log, tc.want) {
+			t.Errorf("%s with leader %q: got %v, want %v", tc.order, tc.leader, log, tc.want)
+		}
+	}
+
+	bc := &BaseCluster{}
+	if err := bc.SetDestroyOrder("sideways", ""); err == nil {
+		t.Error("set an unknown destroy order")
+	}
+}
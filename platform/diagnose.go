@@ -0,0 +1,125 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ProbeTimeout bounds each probe run to diagnose a machine, so that
+// diagnosis adds seconds to a failure rather than minutes.
+const ProbeTimeout = 5 * time.Second
+
+// BootDiagnoser is implemented by machines which can investigate why
+// they never became reachable over SSH, e.g. by asking the platform
+// about the instance. Each finding is a short sentence.
+type BootDiagnoser interface {
+	DiagnoseBoot() []string
+}
+
+// diagnoseBoot returns the findings of m, if it is a BootDiagnoser,
+// formatted for appending to an error.
+func diagnoseBoot(m Machine) string {
+	d, ok := m.(BootDiagnoser)
+	if !ok {
+		return ""
+	}
+	findings := d.DiagnoseBoot()
+	if len(findings) == 0 {
+		return ""
+	}
+	return "\nboot diagnosis:\n\t- " + strings.Join(findings, "\n\t- ")
+}
+
+var consoleProblems = []struct {
+	desc  string
+	match *regexp.Regexp
+}{
+	{"sshd failed", regexp.MustCompile(`Failed to start .*(OpenSSH|sshd).*`)},
+	{"cloudinit failed", regexp.MustCompile(`Failed to start .*cloud-config.*|coreos-cloudinit.*(error|failed).*`)},
+	{"Ignition failed", regexp.MustCompile(`Ignition failed.*|ignition\[[0-9]+\]: CRITICAL.*`)},
+	{"networking failed", regexp.MustCompile(`Failed to start .*Network.*`)},
+	{"the machine entered emergency mode", regexp.MustCompile(`You are in emergency mode|Entering emergency mode`)},
+}
+
+// ConsoleFindings looks for evidence in console output of problems
+// which keep a machine from accepting SSH connections.
+func ConsoleFindings(console string) []string {
+	if strings.TrimSpace(console) == "" {
+		return []string{"console output is empty; the machine may not have booted"}
+	}
+	var findings []string
+	for _, p := range consoleProblems {
+		if line := p.match.FindString(console); line != "" {
+			findings = append(findings, fmt.Sprintf("console shows %s: %q", p.desc, strings.TrimSpace(line)))
+		}
+	}
+	return findings
+}
+
+// ProbeSSH tries once to log in to ip with the cluster's key and
+// describes how far it got, distinguishing an unreachable host, a closed
+// port, an unresponsive sshd and rejected keys. dial is used to connect,
+// e.g. from inside a network namespace.
+func (bc *BaseCluster) ProbeSSH(ip string, dial func(network, address string, timeout time.Duration) (net.Conn, error)) string {
+	addr := net.JoinHostPort(ip, "22")
+	conn, err := dial("tcp", addr, ProbeTimeout)
+	if err != nil {
+		switch {
+		case isTimeout(err):
+			return fmt.Sprintf("port 22 did not answer within %v; packets may be dropped or the machine has no network", ProbeTimeout)
+		case strings.Contains(err.Error(), "connection refused"):
+			return "port 22 refused the connection; the machine is up but sshd is not listening"
+		case strings.Contains(err.Error(), "no route to host"), strings.Contains(err.Error(), "host is unreachable"):
+			return "no route to the machine; it did not answer ARP or the network is down"
+		default:
+			return fmt.Sprintf("connecting to port 22 failed: %v", err)
+		}
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ProbeTimeout))
+
+	sshconn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User: bc.agent.User,
+		Auth: []ssh.AuthMethod{ssh.PublicKeysCallback(bc.agent.Signers)},
+	})
+	switch {
+	case err == nil:
+		ssh.NewClient(sshconn, chans, reqs).Close()
+		return "SSH login works now; the machine may just have been slow to boot"
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		return "sshd is up but rejected kola's key; the key was probably not installed from the config or metadata"
+	case isTimeout(err):
+		return fmt.Sprintf("port 22 accepted the connection but sshd did not complete the handshake within %v", ProbeTimeout)
+	case strings.Contains(err.Error(), "EOF"), strings.Contains(err.Error(), "connection reset"):
+		return "port 22 accepted the connection but closed it during the handshake; sshd may still be starting"
+	default:
+		return fmt.Sprintf("SSH handshake failed: %v", err)
+	}
+}
+
+// isTimeout also matches timeouts wrapped by the ssh package.
+func isTimeout(err error) bool {
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return true
+	}
+	return strings.Contains(err.Error(), "i/o timeout")
+}
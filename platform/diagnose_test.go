@@ -0,0 +1,54 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConsoleFindings(t *testing.T) {
+	for _, tc := range []struct {
+		console string
+		expect  []string
+	}{
+		{
+			console: "",
+			expect:  []string{"console output is empty"},
+		},
+		{
+			console: "[  OK  ] Started OpenSSH server daemon.\nlogin: ",
+		},
+		{
+			console: "[FAILED] Failed to start OpenSSH Key Generation.\n[FAILED] Failed to start Load cloud-config from /usr/share/oem/cloud-config.yml.\n",
+			expect:  []string{"sshd failed", "cloudinit failed"},
+		},
+		{
+			console: "ignition[412]: CRITICAL : files: op(1): failed to fetch\nYou are in emergency mode.",
+			expect:  []string{"Ignition failed", "emergency mode"},
+		},
+	} {
+		findings := ConsoleFindings(tc.console)
+		if len(findings) != len(tc.expect) {
+			t.Errorf("%q: expected %d findings, got %q", tc.console, len(tc.expect), findings)
+			continue
+		}
+		for i, e := range tc.expect {
+			if !strings.Contains(findings[i], e) {
+				t.Errorf("%q: finding %q does not mention %q", tc.console, findings[i], e)
+			}
+		}
+	}
+}
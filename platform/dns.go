@@ -0,0 +1,89 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"net"
+	"time"
+)
+
+// DNSFailure is how the DNS fixture fails the queries for a name.
+type DNSFailure string
+
+const (
+	DNSAnswer   DNSFailure = ""         // answer normally
+	DNSNXDomain DNSFailure = "nxdomain" // answer that the name doesn't exist
+	DNSServFail DNSFailure = "servfail" // answer that the server failed
+	DNSTimeout  DNSFailure = "timeout"  // don't answer at all
+)
+
+// DNSFault is how the DNS fixture answers the queries for a name. The
+// zero DNSFault answers normally and at once.
+type DNSFault struct {
+	Failure DNSFailure
+	Delay   time.Duration // how long to hold back each answer
+}
+
+// DNSFixture is implemented by clusters on platforms with CapDNSFixture.
+// It runs a DNS server whose answers the test controls, so that tests can
+// check how services cope with names which resolve differently, slowly
+// or not at all. Changes apply to all machines at once, including those
+// already running. Names the fixture doesn't know are resolved as usual.
+type DNSFixture interface {
+	// UseDNSFixture makes machines created afterwards resolve names
+	// through the fixture.
+	UseDNSFixture()
+
+	// SetDNSHost answers the A and AAAA queries for name with addrs.
+	// Without addrs, name is no longer answered by the fixture.
+	SetDNSHost(name string, addrs ...net.IP)
+
+	// SetDNSFault changes how the queries for name are answered.
+	SetDNSFault(name string, fault DNSFault)
+}
+
+// UseDNSFixture makes the machines c creates afterwards resolve names
+// through its DNS fixture. It returns ErrNotSupported unless c implements
+// DNSFixture.
+func UseDNSFixture(c Cluster) error {
+	f, ok := c.(DNSFixture)
+	if !ok {
+		return ErrNotSupported
+	}
+	f.UseDNSFixture()
+	return nil
+}
+
+// SetDNSHost makes the DNS fixture of c answer the queries for name with
+// addrs. It returns ErrNotSupported unless c implements DNSFixture.
+func SetDNSHost(c Cluster, name string, addrs ...net.IP) error {
+	f, ok := c.(DNSFixture)
+	if !ok {
+		return ErrNotSupported
+	}
+	f.SetDNSHost(name, addrs...)
+	return nil
+}
+
+// SetDNSFault makes the DNS fixture of c answer the queries for name as
+// fault says. It returns ErrNotSupported unless c implements DNSFixture.
+func SetDNSFault(c Cluster, name string, fault DNSFault) error {
+	f, ok := c.(DNSFixture)
+	if !ok {
+		return ErrNotSupported
+	}
+	f.SetDNSFault(name, fault)
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"sync"
+	"time"
+)
+
+// MachineEventType is a step in the life of a machine.
+type MachineEventType string
+
+const (
+	// MachineCreated is sent when the platform has created a machine,
+	// before it is checked. Detail is its addresses, preceded by its
+	// name if its ID is something else.
+	MachineCreated MachineEventType = "created"
+	// MachineSSHReady is sent when a machine passed its checks after
+	// booting or rebooting.
+	MachineSSHReady MachineEventType = "ssh-ready"
+	// MachineOperation is sent when a cloud operation on a machine,
+	// e.g. creating its instance, has finished. Detail names the
+	// operation and how long it took.
+	MachineOperation MachineEventType = "operation"
+	// MachineRebooting is sent when a machine is asked to reboot.
+	MachineRebooting MachineEventType = "rebooting"
+	// MachineDied is sent when the platform observes a machine stop
+	// without having been destroyed, e.g. its qemu process exited.
+	MachineDied MachineEventType = "died"
+	// MachineDestroying is sent when destroying a cluster starts
+	// destroying one of its machines. Detail is the cluster's
+	// DestroyOrder and the machine's place in it.
+	MachineDestroying MachineEventType = "destroying"
+	// MachineDestroyed is sent when a machine has been destroyed.
+	MachineDestroyed MachineEventType = "destroyed"
+)
+
+// EventBuffer is how many events a subscriber to Cluster.Events may fall
+// behind before events are dropped for it.
+const EventBuffer = 64
+
+// MachineEvent is an event in the life of a machine of a cluster.
+type MachineEvent struct {
+	Type      MachineEventType
+	MachineID string
+	Time      time.Time
+	Detail    string // e.g. why the machine died; may be empty
+
+	// Dropped counts the events this subscriber missed right before
+	// this one because it fell behind.
+	Dropped int
+}
+
+// eventBus delivers machine events to subscribers without ever blocking
+// on them: a subscriber whose buffer is full misses the event.
+type eventBus struct {
+	mu     sync.Mutex
+	subs   []*eventSub
+	closed bool
+}
+
+type eventSub struct {
+	ch      chan MachineEvent
+	dropped int
+}
+
+// subscribe returns a new subscription, which is closed with the bus.
+func (b *eventBus) subscribe() <-chan MachineEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &eventSub{ch: make(chan MachineEvent, EventBuffer)}
+	if b.closed {
+		close(sub.ch)
+	} else {
+		b.subs = append(b.subs, sub)
+	}
+	return sub.ch
+}
+
+// emit sends an event to every subscriber. It does nothing on a nil bus,
+// e.g. for machines outside of a BaseCluster.
+func (b *eventBus) emit(id string, typ MachineEventType, detail string) {
+	if detail != "" {
+		plog.Debugf("Machine %s %s: %s", id, typ, detail)
+	} else {
+		plog.Debugf("Machine %s %s", id, typ)
+	}
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	ev := MachineEvent{
+		Type:      typ,
+		MachineID: id,
+		Time:      time.Now(),
+		Detail:    detail,
+	}
+	for _, sub := range b.subs {
+		ev.Dropped = sub.dropped
+		select {
+		case sub.ch <- ev:
+			sub.dropped = 0
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// close ends every subscription.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for _, sub := range b.subs {
+		if sub.dropped > 0 {
+			plog.Warningf("An event subscriber missed the last %d machine events", sub.dropped)
+		}
+		close(sub.ch)
+	}
+}
+
+// Events subscribes to the events of the cluster's machines. The channel
+// is closed when the cluster is destroyed. Events are dropped rather
+// than wait for a subscriber which falls more than EventBuffer behind;
+// MachineEvent.Dropped counts them.
+func (bc *BaseCluster) Events() <-chan MachineEvent {
+	return bc.events.subscribe()
+}
+
+// EmitEvent sends an event for machine id to the cluster's subscribers.
+// It is for platforms to report what they observe of their machines.
+func (bc *BaseCluster) EmitEvent(id string, typ MachineEventType, detail string) {
+	bc.events.emit(id, typ, detail)
+}
+
+// emitEvent sends an event for m to the subscribers of its cluster.
+func emitEvent(m Machine, typ MachineEventType, detail string) {
+	m.RuntimeConf().events.emit(m.ID(), typ, detail)
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"testing"
+)
+
+// eventMachine is a Machine of a cluster with an event bus.
+type eventMachine struct {
+	Machine
+	bc *BaseCluster
+}
+
+func (m *eventMachine) ID() string {
+	return "m1"
+}
+
+func (m *eventMachine) RuntimeConf() RuntimeConfig {
+	return m.bc.RuntimeConf()
+}
+
+func TestEvents(t *testing.T) {
+	bc := &BaseCluster{rconf: &RuntimeConfig{}, events: &eventBus{}}
+	slow := bc.Events()
+	fast := bc.Events()
+
+	m := &eventMachine{bc: bc}
+	emitEvent(m, MachineCreated, "")
+	if ev := <-fast; ev.Type != MachineCreated || ev.MachineID != "m1" || ev.Time.IsZero() {
+		t.Errorf("unexpected event %+v", ev)
+	}
+
+	// the slow subscriber doesn't read, so it must not hold up others
+	for i := 0; i < EventBuffer+2; i++ {
+		bc.EmitEvent("m1", MachineRebooting, "")
+		<-fast
+	}
+	for i := 0; i < EventBuffer; i++ {
+		<-slow
+	}
+	bc.EmitEvent("m1", MachineDied, "qemu exited")
+	if ev := <-slow; ev.Type != MachineDied || ev.Detail != "qemu exited" || ev.Dropped != 3 {
+		t.Errorf("expected a died event after 3 dropped, got %+v", ev)
+	}
+	if ev := <-fast; ev.Dropped != 0 {
+		t.Errorf("fast subscriber missed %d events", ev.Dropped)
+	}
+
+	bc.events.close()
+	bc.EmitEvent("m1", MachineDestroyed, "")
+	if ev, ok := <-fast; ok {
+		t.Errorf("event %+v after close", ev)
+	}
+	if _, ok := <-bc.Events(); ok {
+		t.Error("subscription after close is open")
+	}
+
+	// machines outside a BaseCluster have no bus
+	emitEvent(&eventMachine{bc: &BaseCluster{rconf: &RuntimeConfig{}}}, MachineCreated, "")
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fault wraps clusters of any platform to inject failures of the
+// platform layer, so that the harness's handling of them can be tested
+// without polluting the platforms with test-only code.
+//
+// Which faults are injected is decided by a seed, deterministically for
+// each cluster and operation: running the same tests with the same seed
+// injects the same faults, though among machines created in parallel
+// not necessarily into the same one. Optional interfaces of the wrapped
+// clusters and machines are hidden.
+package fault
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/coreos/pkg/capnslog"
+
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+// Faults which may be injected.
+const (
+	NewMachineFailed = "new-machine-failed" // NewMachine returns an error
+	SSHReadyDelayed  = "ssh-ready-delayed"  // NewMachine returns late
+	SSHDropped       = "ssh-dropped"        // an SSH command loses its connection
+	DestroyFailed    = "destroy-failed"     // Machine.Destroy does nothing
+)
+
+const (
+	// Rate is the probability of each fault at each opportunity.
+	Rate = 0.1
+
+	// MaxReadyDelay bounds the delay of an SSHReadyDelayed fault.
+	MaxReadyDelay = 30 * time.Second
+)
+
+var plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "platform/fault")
+
+// Error is the error of an injected fault.
+type Error struct {
+	Fault string
+}
+
+func (e *Error) Error() string {
+	return "injected fault: " + e.Fault
+}
+
+// injector decides which faults to inject into a cluster.
+type injector struct {
+	seed  int64
+	key   string
+	sleep func(time.Duration)
+
+	mu       sync.Mutex
+	counts   map[string]int
+	injected []string
+}
+
+// roll decides whether to inject fault at its next opportunity. If so,
+// it records the fault and returns a random number in [0,1) to size it.
+func (in *injector) roll(fault string) (bool, float64) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	n := in.counts[fault]
+	in.counts[fault]++
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s/%s/%d", in.seed, in.key, fault, n)
+	r := rand.New(rand.NewSource(int64(h.Sum64())))
+	if r.Float64() >= Rate {
+		return false, 0
+	}
+	in.injected = append(in.injected, fault)
+	plog.Noticef("Injecting %s into %s", fault, in.key)
+	return true, r.Float64()
+}
+
+type cluster struct {
+	platform.Cluster
+	in *injector
+
+	mu       sync.Mutex
+	machines map[platform.Machine]*machine // by the wrapped machine
+}
+
+// Wrap returns c with faults injected as decided by seed. key identifies
+// c within a run, e.g. by the test and platform it is for.
+func Wrap(c platform.Cluster, seed int64, key string) platform.Cluster {
+	return &cluster{
+		Cluster: c,
+		in: &injector{
+			seed:   seed,
+			key:    key,
+			sleep:  time.Sleep,
+			counts: make(map[string]int),
+		},
+		machines: make(map[platform.Machine]*machine),
+	}
+}
+
+// InjectedFaults returns the faults injected so far, in order.
+func (c *cluster) InjectedFaults() []string {
+	c.in.mu.Lock()
+	defer c.in.mu.Unlock()
+	return append([]string(nil), c.in.injected...)
+}
+
+func (c *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
+	if ok, _ := c.in.roll(NewMachineFailed); ok {
+		return nil, &Error{Fault: NewMachineFailed}
+	}
+	m, err := c.Cluster.NewMachine(userdata)
+	if err != nil {
+		return nil, err
+	}
+	if ok, x := c.in.roll(SSHReadyDelayed); ok {
+		c.in.sleep(time.Duration(x * float64(MaxReadyDelay)))
+	}
+	return c.wrap(m), nil
+}
+
+func (c *cluster) Machines() []platform.Machine {
+	var machines []platform.Machine
+	for _, m := range c.Cluster.Machines() {
+		machines = append(machines, c.wrap(m))
+	}
+	return machines
+}
+
+func (c *cluster) wrap(m platform.Machine) platform.Machine {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fm, ok := c.machines[m]
+	if !ok {
+		fm = &machine{Machine: m, in: c.in}
+		c.machines[m] = fm
+	}
+	return fm
+}
+
+type machine struct {
+	platform.Machine
+	in *injector
+}
+
+// SSH runs cmd, but may then report the connection lost, returning
+// only part of its output.
+func (m *machine) SSH(cmd string) ([]byte, []byte, error) {
+	stdout, stderr, err := m.Machine.SSH(cmd)
+	if ok, x := m.in.roll(SSHDropped); ok {
+		return stdout[:int(x*float64(len(stdout)))], nil, &Error{Fault: SSHDropped}
+	}
+	return stdout, stderr, err
+}
+
+// Destroy may leave the machine running, for the cluster's Destroy to
+// clean up.
+func (m *machine) Destroy() {
+	if ok, _ := m.in.roll(DestroyFailed); ok {
+		return
+	}
+	m.Machine.Destroy()
+}
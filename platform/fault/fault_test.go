@@ -0,0 +1,124 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fault
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+// fakeCluster creates machines which echo SSH commands and remembers
+// which are still running.
+type fakeCluster struct {
+	platform.Cluster
+	machines []platform.Machine
+}
+
+func (c *fakeCluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
+	m := &fakeMachine{c: c, id: fmt.Sprintf("m%d", len(c.machines))}
+	c.machines = append(c.machines, m)
+	return m, nil
+}
+
+func (c *fakeCluster) Machines() []platform.Machine {
+	return c.machines
+}
+
+func (c *fakeCluster) Destroy() {
+	for _, m := range append([]platform.Machine(nil), c.machines...) {
+		m.Destroy()
+	}
+}
+
+type fakeMachine struct {
+	platform.Machine
+	c  *fakeCluster
+	id string
+}
+
+func (m *fakeMachine) ID() string {
+	return m.id
+}
+
+func (m *fakeMachine) SSH(cmd string) ([]byte, []byte, error) {
+	return []byte(cmd), nil, nil
+}
+
+func (m *fakeMachine) Destroy() {
+	for i, o := range m.c.machines {
+		if o == m {
+			m.c.machines = append(m.c.machines[:i], m.c.machines[i+1:]...)
+			return
+		}
+	}
+}
+
+// exercise runs operations on a wrapped cluster, returning the faults
+// injected and whether any machine leaked.
+func exercise(t *testing.T, seed int64) ([]string, bool) {
+	fc := &fakeCluster{}
+	c := Wrap(fc, seed, "test/qemu/primary").(*cluster)
+	c.in.sleep = func(d time.Duration) {
+		if d < 0 || d >= MaxReadyDelay {
+			t.Errorf("delay %v out of range", d)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		m, err := c.NewMachine(nil)
+		if err != nil {
+			if _, ok := err.(*Error); !ok {
+				t.Fatalf("unexpected error %v", err)
+			}
+			continue
+		}
+		const cmd = "echo hello"
+		out, _, err := m.SSH(cmd)
+		if err == nil && string(out) != cmd {
+			t.Errorf("output %q without a fault", out)
+		} else if err != nil && len(out) >= len(cmd) {
+			t.Errorf("dropped connection returned all output")
+		}
+		m.Destroy()
+	}
+	c.Destroy()
+	return c.InjectedFaults(), len(fc.machines) != 0
+}
+
+func TestWrap(t *testing.T) {
+	seen := make(map[string]bool)
+	for seed := int64(1); seed <= 5; seed++ {
+		faults, leaked := exercise(t, seed)
+		if leaked {
+			t.Errorf("seed %d: machines leaked", seed)
+		}
+		if again, _ := exercise(t, seed); !reflect.DeepEqual(faults, again) {
+			t.Errorf("seed %d: faults %v, then %v", seed, faults, again)
+		}
+		for _, f := range faults {
+			seen[f] = true
+		}
+	}
+	for _, f := range []string{NewMachineFailed, SSHReadyDelayed, SSHDropped, DestroyFailed} {
+		if !seen[f] {
+			t.Errorf("%s never injected", f)
+		}
+	}
+}
@@ -55,14 +55,36 @@ func (g gzWriteCloser) Close() error {
 	return err.AsError()
 }
 
+// cappedWriteCloser writes the first Limit bytes through to c, dropping
+// the rest, and reports what it dropped when closed.
+type cappedWriteCloser struct {
+	util.HeadWriter
+	c         io.Closer
+	truncated func(dropped int64)
+}
+
+func (w *cappedWriteCloser) Close() error {
+	if dropped := w.Dropped(); dropped > 0 {
+		w.truncated(dropped)
+	}
+	return w.c.Close()
+}
+
 // NewJournal creates a Journal recorder that will log to "journal.txt"
-// and "journal-raw.txt.gz" inside the given output directory.
-func NewJournal(dir string) (*Journal, error) {
+// and "journal-raw.txt.gz" inside the given output directory. The size of
+// journal.txt is capped by limits.Journal, keeping its head and tail, and
+// so is the uncompressed size of journal-raw.txt.gz, keeping its head,
+// since entries can't be cut out of the middle of the export format.
+func NewJournal(dir string, limits ArtifactLimits) (*Journal, error) {
 	p := filepath.Join(dir, "journal.txt")
-	j, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
 	if err != nil {
 		return nil, err
 	}
+	j := util.NewHeadTailWriter(f, limits.Journal)
+	j.Truncated = func(dropped int64) {
+		limits.ReportTruncated(p, dropped)
+	}
 
 	pr := filepath.Join(dir, "journal-raw.txt.gz")
 	jr, err := os.OpenFile(pr, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
@@ -74,9 +96,18 @@ func NewJournal(dir string) (*Journal, error) {
 	if err != nil {
 		return nil, err
 	}
-	jrzc := gzWriteCloser{
-		underlying: jr,
-		Writer:     jrz,
+	jrzc := &cappedWriteCloser{
+		HeadWriter: util.HeadWriter{
+			W:     jrz,
+			Limit: limits.Journal,
+		},
+		c: gzWriteCloser{
+			underlying: jr,
+			Writer:     jrz,
+		},
+		truncated: func(dropped int64) {
+			limits.ReportTruncated(pr, dropped)
+		},
 	}
 
 	return &Journal{
@@ -115,6 +146,12 @@ func (j *Journal) Start(ctx context.Context, m Machine) error {
 	return nil
 }
 
+// Stopped reports whether the journal isn't being streamed, because it was
+// never started or its stream ended before Destroy.
+func (j *Journal) Stopped() bool {
+	return j.cancel == nil || j.recorder.Stopped()
+}
+
 // There is no guarantee that anything is returned if called before Destroy
 func (j *Journal) Read() ([]byte, error) {
 	f, err := os.Open(j.journalPath)
@@ -0,0 +1,68 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalRawLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	truncated := map[string]int64{}
+	limits := ArtifactLimits{
+		Journal:   100,
+		Truncated: func(artifact string, dropped int64) { truncated[artifact] += dropped },
+	}
+	j, err := NewJournal(dir, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := bytes.Repeat([]byte("MESSAGE=x\n"), 30)
+	if _, err := j.journalRaw.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	j.Destroy()
+
+	raw := filepath.Join(dir, "journal-raw.txt.gz")
+	f, err := os.Open(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, stream[:100]) {
+		t.Errorf("raw journal holds %q, want the first 100 bytes", got)
+	}
+	if truncated[raw] != 200 {
+		t.Errorf("reported %v truncated, want 200 bytes of %s", truncated, raw)
+	}
+}
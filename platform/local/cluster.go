@@ -15,10 +15,13 @@
 package local
 
 import (
+	"crypto/rand"
 	"fmt"
-	"math/rand"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 
@@ -38,16 +41,32 @@ type LocalCluster struct {
 	destructor.MultiDestructor
 	*platform.BaseCluster
 	Dnsmasq     *Dnsmasq
+	Metadata    *MetadataServer
+	DNS         *DNSServer
 	NTPServer   *ntp.Server
 	OmahaServer OmahaWrapper
 	SimpleEtcd  *SimpleEtcd
 	nshandle    netns.NsHandle
 }
 
-func NewLocalCluster(opts *platform.Options, rconf *platform.RuntimeConfig, platformName platform.Name) (*LocalCluster, error) {
+// NewLocalCluster creates a cluster in a new network namespace. subnet is
+// the IPv4 /16 to number machines from; if empty, one which does not
+// conflict with the host's networks is chosen.
+func NewLocalCluster(opts *platform.Options, rconf *platform.RuntimeConfig, platformName platform.Name, subnet string) (*LocalCluster, error) {
 	lc := &LocalCluster{}
 
-	var err error
+	// the cluster is isolated in its own namespace, but addresses which
+	// shadow host networks still confuse anyone debugging it
+	hostNets, err := hostNetworks()
+	if err != nil {
+		return nil, err
+	}
+	clusterNet, err := chooseSubnet(subnet, hostNets)
+	if err != nil {
+		return nil, err
+	}
+	plog.Infof("Using subnet %s for local cluster", &clusterNet)
+
 	lc.nshandle, err = ns.Create()
 	if err != nil {
 		return nil, err
@@ -62,6 +81,10 @@ func NewLocalCluster(opts *platform.Options, rconf *platform.RuntimeConfig, plat
 	}
 	lc.AddDestructor(lc.BaseCluster)
 
+	// machines are only reachable from inside the namespace, which
+	// is held open by this process
+	lc.SetSSHProxyCommand(fmt.Sprintf("nsenter --net=/proc/%d/fd/%d nc %%h %%p", os.Getpid(), int(lc.nshandle)))
+
 	// dnsmasq and etcd much be launched in the new namespace
 	nsExit, err := ns.Enter(lc.nshandle)
 	if err != nil {
@@ -70,13 +93,29 @@ func NewLocalCluster(opts *platform.Options, rconf *platform.RuntimeConfig, plat
 	}
 	defer nsExit()
 
-	lc.Dnsmasq, err = NewDnsmasq()
+	lc.Dnsmasq, err = NewDnsmasq(clusterNet, lc.NewScope("dnsmasq"))
 	if err != nil {
 		lc.Destroy()
 		return nil, err
 	}
 	lc.AddDestructor(lc.Dnsmasq)
 
+	lc.Metadata, err = NewMetadataServer("br0")
+	if err != nil {
+		lc.Destroy()
+		return nil, err
+	}
+	lc.AddDestructor(lc.Metadata)
+
+	// the fixture relays the names it doesn't know to dnsmasq
+	upstream := &net.UDPAddr{IP: lc.GatewayIP(), Port: 53}
+	lc.DNS, err = NewDNSServer("br0", upstream)
+	if err != nil {
+		lc.Destroy()
+		return nil, err
+	}
+	lc.AddDestructor(lc.DNS)
+
 	lc.SimpleEtcd, err = NewSimpleEtcd()
 	if err != nil {
 		lc.Destroy()
@@ -101,6 +140,13 @@ func NewLocalCluster(opts *platform.Options, rconf *platform.RuntimeConfig, plat
 	lc.AddDestructor(lc.OmahaServer)
 	go lc.OmahaServer.Serve()
 
+	if ports := rconf.RequiredPorts; len(ports) > 0 {
+		if err := lc.checkRequiredPorts(ports); err != nil {
+			lc.Destroy()
+			return nil, err
+		}
+	}
+
 	return lc, nil
 }
 
@@ -109,25 +155,39 @@ func (lc *LocalCluster) NewCommand(name string, arg ...string) exec.Cmd {
 	return cmd
 }
 
-func (lc *LocalCluster) etcdEndpoint() string {
-	// hackydoo
-	bridge := "br0"
-	for _, seg := range lc.Dnsmasq.Segments {
-		if bridge == seg.BridgeName {
-			return fmt.Sprintf("http://%s:%d", seg.BridgeIf.DHCPv4[0].IP, lc.SimpleEtcd.Port)
-		}
-	}
-	panic("Not a valid bridge!")
+// GatewayIP returns the IPv4 address of the cluster's br0 bridge, the
+// machines' gateway, at which they reach the NTP, omaha and etcd
+// fixtures. It depends on the subnet the cluster was given.
+func (lc *LocalCluster) GatewayIP() net.IP {
+	return lc.Dnsmasq.BridgeIP("br0")
 }
 
-func (lc *LocalCluster) GetDiscoveryURL(size int) (string, error) {
-	baseURL := fmt.Sprintf("%v/v2/keys/discovery/%v", lc.etcdEndpoint(), rand.Int())
+func (lc *LocalCluster) etcdEndpoint() string {
+	return fmt.Sprintf("http://%s:%d", lc.GatewayIP(), lc.SimpleEtcd.Port)
+}
 
+// fixtureClient returns an HTTP client for the fixtures in the namespace.
+func (lc *LocalCluster) fixtureClient() *http.Client {
 	nsDialer := network.NewNsDialer(lc.nshandle)
 	tr := &http.Transport{
 		Dial: nsDialer.Dial,
+		// the fixtures are in the namespace, out of reach of any
+		// proxy from the environment
+		Proxy: nil,
+	}
+	return &http.Client{Transport: tr}
+}
+
+// GetDiscoveryURL allocates a token in the cluster's own etcd, which
+// machines reach inside the network namespace. Options.DiscoveryURL is
+// ignored since the namespace has no route to other services.
+func (lc *LocalCluster) GetDiscoveryURL(size int) (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
 	}
-	client := &http.Client{Transport: tr}
+	baseURL := fmt.Sprintf("%v/v2/keys/discovery/%x", lc.etcdEndpoint(), token)
+	client := lc.fixtureClient()
 
 	body := strings.NewReader(url.Values{"value": {strconv.Itoa(size)}}.Encode())
 	req, err := http.NewRequest("PUT", baseURL+"/_config/size", body)
@@ -145,6 +205,18 @@ func (lc *LocalCluster) GetDiscoveryURL(size int) (string, error) {
 	return baseURL, nil
 }
 
+func (lc *LocalCluster) GetDiscoveryState(url string) ([]byte, error) {
+	resp, err := lc.fixtureClient().Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Discovery service returned %q", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
 func (lc *LocalCluster) NewTap(bridge string) (*TunTap, error) {
 	nsExit, err := ns.Enter(lc.nshandle)
 	if err != nil {
@@ -175,6 +247,29 @@ func (lc *LocalCluster) NewTap(bridge string) (*TunTap, error) {
 	return tap, nil
 }
 
+// SetMetadata sets the cloud metadata served to machines created
+// afterwards.
+func (lc *LocalCluster) SetMetadata(md platform.Metadata) {
+	lc.Metadata.SetMetadata(md)
+}
+
+// UseDNSFixture makes machines created afterwards resolve names through
+// the DNS fixture.
+func (lc *LocalCluster) UseDNSFixture() {
+	lc.DNS.Enable()
+}
+
+// SetDNSHost makes the DNS fixture answer the queries for name with
+// addrs.
+func (lc *LocalCluster) SetDNSHost(name string, addrs ...net.IP) {
+	lc.DNS.SetHost(name, addrs...)
+}
+
+// SetDNSFault changes how the DNS fixture answers the queries for name.
+func (lc *LocalCluster) SetDNSFault(name string, fault platform.DNSFault) {
+	lc.DNS.SetFault(name, fault)
+}
+
 func (lc *LocalCluster) GetNsHandle() netns.NsHandle {
 	return lc.nshandle
 }
@@ -0,0 +1,63 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/system/ns"
+)
+
+// DiagnoseNetwork checks, from inside the cluster's network namespace,
+// how far a machine with the given MAC and IPv4 address got in joining
+// the network: whether dnsmasq granted it a lease, whether it answers
+// ping and what happens when logging in over SSH.
+func (lc *LocalCluster) DiagnoseNetwork(mac net.HardwareAddr, ip string) []string {
+	var findings []string
+
+	if leased, ok := lc.Dnsmasq.Leased(mac); !ok {
+		findings = append(findings, fmt.Sprintf("dnsmasq granted no DHCP lease to %s; the machine did not boot far enough to configure its network", mac))
+	} else if leased != ip {
+		findings = append(findings, fmt.Sprintf("dnsmasq leased %s to %s, not the expected %s", leased, mac, ip))
+	} else {
+		findings = append(findings, fmt.Sprintf("dnsmasq leased %s to %s", ip, mac))
+	}
+
+	wait := strconv.Itoa(int(platform.ProbeTimeout / time.Second))
+	if out, err := ns.Command(lc.nshandle, "ping", "-n", "-c", "1", "-W", wait, ip).CombinedOutput(); err != nil {
+		findings = append(findings, fmt.Sprintf("%s does not answer ping: %v: %s", ip, err, out))
+	} else {
+		findings = append(findings, fmt.Sprintf("%s answers ping", ip))
+	}
+
+	findings = append(findings, lc.ProbeSSH(ip, lc.dialTimeout))
+	return findings
+}
+
+// dialTimeout connects from inside the cluster's network namespace
+// without retrying.
+func (lc *LocalCluster) dialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	nsExit, err := ns.Enter(lc.nshandle)
+	if err != nil {
+		return nil, err
+	}
+	defer nsExit()
+
+	return net.DialTimeout(network, address, timeout)
+}
@@ -0,0 +1,378 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+// DNSAddr is the link-local address the DNS fixture listens on.
+const DNSAddr = "169.254.169.253"
+
+// dnsNetworkUnit replaces Container Linux's default network config,
+// keeping everything but the DHCP server's resolver.
+const dnsNetworkUnit = `[Network]
+DHCP=yes
+DNS=` + DNSAddr + `
+
+[DHCP]
+UseDNS=false
+UseMTU=true
+UseDomains=true
+`
+
+const (
+	dnsHeaderLen = 12
+
+	dnsFlagResponse      = 1 << 15
+	dnsFlagAuthoritative = 1 << 10
+	dnsFlagRecursionDes  = 1 << 8
+	dnsFlagRecursionAvl  = 1 << 7
+	dnsOpcodeMask        = 0xf << 11
+
+	dnsRcodeSuccess  = 0
+	dnsRcodeServFail = 2
+	dnsRcodeNXDomain = 3
+
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsClassIN  = 1
+)
+
+// dnsQuery is the part of a DNS query the fixture answers from.
+type dnsQuery struct {
+	id       uint16
+	flags    uint16
+	name     string // lower case, without the trailing dot
+	qtype    uint16
+	question []byte // as sent, for the answer
+}
+
+// dnsForward is a query relayed to the upstream server.
+type dnsForward struct {
+	client *net.UDPAddr
+	id     uint16 // the client's ID
+}
+
+// DNSServer is the DNS fixture of a cluster. It answers the names the
+// test configured and relays every other query to dnsmasq, so machines
+// using it still resolve each other. Only UDP is served.
+type DNSServer struct {
+	conn     *net.UDPConn
+	upstream *net.UDPAddr
+
+	mu       sync.Mutex
+	enabled  bool
+	closed   bool
+	hosts    map[string][]net.IP
+	faults   map[string]platform.DNSFault
+	nextID   uint16
+	forwards map[uint16]dnsForward // by the ID sent upstream
+}
+
+// NewDNSServer adds DNSAddr to bridge and serves DNS on it, relaying to
+// upstream. It must be called in the cluster's network namespace.
+func NewDNSServer(bridge string, upstream *net.UDPAddr) (*DNSServer, error) {
+	br, err := netlink.LinkByName(bridge)
+	if err != nil {
+		return nil, fmt.Errorf("DNS bridge failed: %v", err)
+	}
+	addr, err := netlink.ParseAddr(DNSAddr + "/32")
+	if err != nil {
+		return nil, err
+	}
+	if err := netlink.AddrAdd(br, addr); err != nil {
+		return nil, fmt.Errorf("DNS AddrAdd() failed: %v", err)
+	}
+
+	conn, err := listenUDPShared(net.ParseIP(DNSAddr), 53)
+	if err != nil {
+		return nil, fmt.Errorf("DNS listen failed: %v", err)
+	}
+	return newDNSServer(conn, upstream), nil
+}
+
+func newDNSServer(conn *net.UDPConn, upstream *net.UDPAddr) *DNSServer {
+	ds := &DNSServer{
+		conn:     conn,
+		upstream: upstream,
+		hosts:    make(map[string][]net.IP),
+		faults:   make(map[string]platform.DNSFault),
+		forwards: make(map[uint16]dnsForward),
+	}
+	go ds.serve()
+	return ds
+}
+
+// listenUDPShared listens on ip and port alongside dnsmasq, which holds
+// the port on every address. Both sockets need SO_REUSEADDR for that,
+// and queries to ip then go to the more specific one.
+func listenUDPShared(ip net.IP, port int) (*net.UDPConn, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	sa := &syscall.SockaddrInet4{Port: port}
+	copy(sa.Addr[:], ip.To4())
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), "dns")
+	defer f.Close()
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// Enable makes machines added afterwards resolve through the fixture.
+func (ds *DNSServer) Enable() {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.enabled = true
+}
+
+// Configure points c at the fixture if Enable has been called.
+func (ds *DNSServer) Configure(c *conf.Conf) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.enabled {
+		c.AddNetworkdUnit("zz-default.network", dnsNetworkUnit)
+	}
+}
+
+// SetHost answers the A and AAAA queries for name with addrs, or stops
+// answering them without addrs.
+func (ds *DNSServer) SetHost(name string, addrs ...net.IP) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if len(addrs) == 0 {
+		delete(ds.hosts, dnsName(name))
+		return
+	}
+	ds.hosts[dnsName(name)] = append([]net.IP(nil), addrs...)
+}
+
+// SetFault changes how the queries for name are answered.
+func (ds *DNSServer) SetFault(name string, fault platform.DNSFault) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if fault == (platform.DNSFault{}) {
+		delete(ds.faults, dnsName(name))
+		return
+	}
+	ds.faults[dnsName(name)] = fault
+}
+
+func (ds *DNSServer) Destroy() {
+	ds.mu.Lock()
+	ds.closed = true
+	ds.mu.Unlock()
+	if err := ds.conn.Close(); err != nil {
+		plog.Errorf("Error stopping DNS server: %v", err)
+	}
+}
+
+func (ds *DNSServer) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := ds.conn.ReadFromUDP(buf)
+		if err != nil {
+			ds.mu.Lock()
+			closed := ds.closed
+			ds.mu.Unlock()
+			if !closed {
+				plog.Errorf("Serving DNS failed: %v", err)
+			}
+			return
+		}
+		msg := append([]byte(nil), buf[:n]...)
+		if addr.IP.Equal(ds.upstream.IP) && addr.Port == ds.upstream.Port {
+			ds.relay(msg)
+			continue
+		}
+		go ds.handle(msg, addr)
+	}
+}
+
+// handle answers a query from client, after the configured delay.
+func (ds *DNSServer) handle(msg []byte, client *net.UDPAddr) {
+	q, err := parseDNSQuery(msg)
+	if err != nil {
+		plog.Debugf("Ignoring DNS message from %v: %v", client, err)
+		return
+	}
+
+	ds.mu.Lock()
+	fault := ds.faults[q.name]
+	addrs, known := ds.hosts[q.name]
+	ds.mu.Unlock()
+
+	time.Sleep(fault.Delay)
+	switch fault.Failure {
+	case platform.DNSTimeout:
+		return
+	case platform.DNSNXDomain:
+		ds.send(q.answer(dnsRcodeNXDomain, nil), client)
+	case platform.DNSServFail:
+		ds.send(q.answer(dnsRcodeServFail, nil), client)
+	default:
+		if known {
+			ds.send(q.answer(dnsRcodeSuccess, addrs), client)
+		} else {
+			ds.forward(msg, q.id, client)
+		}
+	}
+}
+
+// forward relays msg upstream under an ID of its own, since queries
+// from different clients may share theirs.
+func (ds *DNSServer) forward(msg []byte, id uint16, client *net.UDPAddr) {
+	ds.mu.Lock()
+	upID := ds.nextID
+	ds.nextID++
+	ds.forwards[upID] = dnsForward{client: client, id: id}
+	ds.mu.Unlock()
+
+	binary.BigEndian.PutUint16(msg, upID)
+	ds.send(msg, ds.upstream)
+}
+
+// relay returns an upstream answer to the client which asked.
+func (ds *DNSServer) relay(msg []byte) {
+	if len(msg) < dnsHeaderLen {
+		return
+	}
+	upID := binary.BigEndian.Uint16(msg)
+	ds.mu.Lock()
+	fwd, ok := ds.forwards[upID]
+	delete(ds.forwards, upID)
+	ds.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	binary.BigEndian.PutUint16(msg, fwd.id)
+	ds.send(msg, fwd.client)
+}
+
+func (ds *DNSServer) send(msg []byte, to *net.UDPAddr) {
+	if _, err := ds.conn.WriteToUDP(msg, to); err != nil {
+		plog.Debugf("Sending DNS message to %v failed: %v", to, err)
+	}
+}
+
+// dnsName normalizes name for lookups.
+func dnsName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// parseDNSQuery parses a standard query for a single name.
+func parseDNSQuery(msg []byte) (*dnsQuery, error) {
+	if len(msg) < dnsHeaderLen {
+		return nil, errors.New("short header")
+	}
+	q := &dnsQuery{
+		id:    binary.BigEndian.Uint16(msg[0:]),
+		flags: binary.BigEndian.Uint16(msg[2:]),
+	}
+	if q.flags&dnsFlagResponse != 0 {
+		return nil, errors.New("not a query")
+	}
+	if n := binary.BigEndian.Uint16(msg[4:]); n != 1 {
+		return nil, fmt.Errorf("%d questions", n)
+	}
+
+	var labels []string
+	off := dnsHeaderLen
+	for {
+		if off >= len(msg) {
+			return nil, errors.New("truncated name")
+		}
+		l := int(msg[off])
+		off++
+		if l == 0 {
+			break
+		}
+		if l&0xc0 != 0 {
+			return nil, errors.New("compressed name")
+		}
+		if off+l > len(msg) {
+			return nil, errors.New("truncated name")
+		}
+		labels = append(labels, string(msg[off:off+l]))
+		off += l
+	}
+	if off+4 > len(msg) {
+		return nil, errors.New("truncated question")
+	}
+	q.name = dnsName(strings.Join(labels, "."))
+	q.qtype = binary.BigEndian.Uint16(msg[off:])
+	q.question = msg[dnsHeaderLen : off+4]
+	return q, nil
+}
+
+// answer builds the answer to q with rcode and the addresses of addrs
+// of the queried type. Answers aren't cached, so that a test's changes
+// apply at once.
+func (q *dnsQuery) answer(rcode uint16, addrs []net.IP) []byte {
+	var rdatas [][]byte
+	for _, ip := range addrs {
+		if ip4 := ip.To4(); ip4 != nil && q.qtype == dnsTypeA {
+			rdatas = append(rdatas, ip4)
+		} else if ip4 == nil && ip.To16() != nil && q.qtype == dnsTypeAAAA {
+			rdatas = append(rdatas, ip.To16())
+		}
+	}
+
+	flags := dnsFlagResponse | dnsFlagAuthoritative | dnsFlagRecursionAvl |
+		q.flags&(dnsOpcodeMask|dnsFlagRecursionDes) | rcode
+	msg := make([]byte, dnsHeaderLen, dnsHeaderLen+len(q.question)+len(rdatas)*28)
+	binary.BigEndian.PutUint16(msg[0:], q.id)
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(rdatas)))
+	msg = append(msg, q.question...)
+	for _, rdata := range rdatas {
+		var rr [12]byte
+		binary.BigEndian.PutUint16(rr[0:], 0xc000|dnsHeaderLen) // the question's name
+		binary.BigEndian.PutUint16(rr[2:], q.qtype)
+		binary.BigEndian.PutUint16(rr[4:], dnsClassIN)
+		binary.BigEndian.PutUint32(rr[6:], 0) // TTL
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(rdata)))
+		msg = append(append(msg, rr[:]...), rdata...)
+	}
+	return msg
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// dnsQueryMsg builds a recursive query for name.
+func dnsQueryMsg(id uint16, name string, qtype uint16) []byte {
+	msg := []byte{byte(id >> 8), byte(id), 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range bytes.Split([]byte(name), []byte(".")) {
+		msg = append(append(msg, byte(len(label))), label...)
+	}
+	return append(msg, 0, byte(qtype>>8), byte(qtype), 0, dnsClassIN)
+}
+
+// dnsAnswerAddrs returns the rcode and addresses of an answer built by
+// dnsQuery.answer.
+func dnsAnswerAddrs(t *testing.T, msg []byte, question int) (uint16, []net.IP) {
+	rcode := binary.BigEndian.Uint16(msg[2:]) & 0xf
+	var addrs []net.IP
+	off := dnsHeaderLen + question
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[6:])); i++ {
+		n := int(binary.BigEndian.Uint16(msg[off+10:]))
+		addrs = append(addrs, net.IP(msg[off+12:off+12+n]))
+		off += 12 + n
+	}
+	if off != len(msg) {
+		t.Errorf("answer has %d bytes, want %d", len(msg), off)
+	}
+	return rcode, addrs
+}
+
+func TestDNSQuery(t *testing.T) {
+	msg := dnsQueryMsg(0x1234, "Etcd.Example.COM", dnsTypeA)
+	q, err := parseDNSQuery(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.id != 0x1234 || q.name != "etcd.example.com" || q.qtype != dnsTypeA {
+		t.Errorf("parsed %+v", q)
+	}
+
+	ans := q.answer(dnsRcodeSuccess, []net.IP{net.ParseIP("10.0.0.9"), net.ParseIP("fd00::9")})
+	if id := binary.BigEndian.Uint16(ans); id != 0x1234 {
+		t.Errorf("answer has ID %#x", id)
+	}
+	if flags := binary.BigEndian.Uint16(ans[2:]); flags&dnsFlagResponse == 0 || flags&dnsFlagRecursionDes == 0 {
+		t.Errorf("answer has flags %#x", flags)
+	}
+	rcode, addrs := dnsAnswerAddrs(t, ans, len(q.question))
+	if rcode != dnsRcodeSuccess || len(addrs) != 1 || !addrs[0].Equal(net.ParseIP("10.0.0.9")) {
+		t.Errorf("answered %d %v", rcode, addrs)
+	}
+
+	for _, bad := range [][]byte{
+		msg[:8],
+		msg[:len(msg)-2],
+		append([]byte{0, 0, 0x81, 0x80}, msg[4:]...),
+	} {
+		if _, err := parseDNSQuery(bad); err == nil {
+			t.Errorf("parsed %x", bad)
+		}
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	upstream, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		// answer everything with NXDOMAIN, as dnsmasq does for names
+		// it doesn't know
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			q, err := parseDNSQuery(buf[:n])
+			if err != nil {
+				continue
+			}
+			upstream.WriteToUDP(q.answer(dnsRcodeNXDomain, nil), addr)
+		}
+	}()
+
+	conn, err := listenUDPShared(net.IPv4(127, 0, 0, 1), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := newDNSServer(conn, upstream.LocalAddr().(*net.UDPAddr))
+	defer ds.Destroy()
+
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var id uint16
+	query := func(name string) (uint16, []net.IP, bool) {
+		id++
+		msg := dnsQueryMsg(id, name, dnsTypeA)
+		if _, err := client.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 512)
+		n, err := client.Read(buf)
+		if err != nil {
+			return 0, nil, false
+		}
+		if got := binary.BigEndian.Uint16(buf); got != id {
+			t.Errorf("%s: answer has ID %d, want %d", name, got, id)
+		}
+		rcode, addrs := dnsAnswerAddrs(t, buf[:n], len(msg)-dnsHeaderLen)
+		return rcode, addrs, true
+	}
+
+	ip := net.ParseIP("10.0.0.9")
+	ds.SetHost("etcd.example.com.", ip)
+	if rcode, addrs, ok := query("etcd.example.com"); !ok || rcode != dnsRcodeSuccess || len(addrs) != 1 || !addrs[0].Equal(ip) {
+		t.Errorf("host answered %d %v %v", rcode, addrs, ok)
+	}
+	if rcode, _, ok := query("other.example.com"); !ok || rcode != dnsRcodeNXDomain {
+		t.Errorf("relayed query answered %d %v", rcode, ok)
+	}
+
+	// flip the answers
+	ds.SetFault("etcd.example.com", platform.DNSFault{Failure: platform.DNSServFail})
+	if rcode, _, ok := query("etcd.example.com"); !ok || rcode != dnsRcodeServFail {
+		t.Errorf("failing host answered %d %v", rcode, ok)
+	}
+	ds.SetFault("etcd.example.com", platform.DNSFault{Failure: platform.DNSTimeout})
+	if _, _, ok := query("etcd.example.com"); ok {
+		t.Error("timing out host answered")
+	}
+	ds.SetFault("etcd.example.com", platform.DNSFault{Delay: 200 * time.Millisecond})
+	start := time.Now()
+	if rcode, _, ok := query("etcd.example.com"); !ok || rcode != dnsRcodeSuccess || time.Since(start) < 200*time.Millisecond {
+		t.Errorf("delayed host answered %d %v after %v", rcode, ok, time.Since(start))
+	}
+	ds.SetFault("etcd.example.com", platform.DNSFault{})
+	ds.SetHost("etcd.example.com")
+	if rcode, _, ok := query("etcd.example.com"); !ok || rcode != dnsRcodeNXDomain {
+		t.Errorf("removed host answered %d %v", rcode, ok)
+	}
+}
@@ -15,15 +15,19 @@
 package local
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"net"
+	"regexp"
+	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/coreos/pkg/capnslog"
 	"github.com/vishvananda/netlink"
 
 	"github.com/coreos/mantle/system/exec"
-	"github.com/coreos/mantle/util"
 )
 
 type Interface struct {
@@ -43,6 +47,10 @@ type Segment struct {
 type Dnsmasq struct {
 	Segments []*Segment
 	dnsmasq  *exec.ExecCmd
+	scope    Scope
+
+	leaseMu sync.Mutex
+	leases  map[string]string // MAC to IPv4 address acknowledged
 }
 
 const (
@@ -54,8 +62,9 @@ log-queries
 log-dhcp
 `
 
+	// DHCPv4 is still logged so that leases can be tracked; the
+	// messages are logged at debug level.
 	quietConfig = `
-quiet-dhcp
 quiet-dhcp6
 quiet-ra
 `
@@ -94,13 +103,19 @@ dhcp-host={{.HardwareAddr}}{{template "ips" .DHCPv4}}{{template "ips" .DHCPv6}}
 `
 )
 
-var plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "platform/local")
+var (
+	plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "platform/local")
+
+	// e.g. dnsmasq-dhcp: DHCPACK(br0) 10.0.0.2 02:00:00:00:00:02 hostname
+	dhcpAckRE = regexp.MustCompile(`DHCPACK\([^)]*\) ([0-9.]+) ([0-9a-fA-F:]{17})`)
+)
 
-func newInterface(s, i byte) *Interface {
+func newInterface(subnet net.IPNet, s, i byte) *Interface {
+	prefix := subnet.IP.To4()
 	return &Interface{
 		HardwareAddr: net.HardwareAddr{0x02, s, 0, 0, 0, i},
 		DHCPv4: []net.IPNet{{
-			IP:   net.IP{10, s, 0, i},
+			IP:   net.IP{prefix[0], prefix[1], s, i},
 			Mask: net.CIDRMask(24, 32)}},
 		DHCPv6: []net.IPNet{{
 			IP:   net.IP{0xfd, s, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, i},
@@ -108,14 +123,14 @@ func newInterface(s, i byte) *Interface {
 	}
 }
 
-func newSegment(s byte) (*Segment, error) {
+func newSegment(subnet net.IPNet, s byte) (*Segment, error) {
 	seg := &Segment{
 		BridgeName: fmt.Sprintf("br%d", s),
-		BridgeIf:   newInterface(s, 1),
+		BridgeIf:   newInterface(subnet, s, 1),
 	}
 
 	for i := byte(2); i < 2+numInterfaces; i++ {
-		seg.Interfaces = append(seg.Interfaces, newInterface(s, i))
+		seg.Interfaces = append(seg.Interfaces, newInterface(subnet, s, i))
 	}
 
 	br := netlink.Bridge{
@@ -150,10 +165,13 @@ func newSegment(s byte) (*Segment, error) {
 	return seg, nil
 }
 
-func NewDnsmasq() (*Dnsmasq, error) {
-	dm := &Dnsmasq{}
+// NewDnsmasq creates the network segments, numbering each segment's
+// IPv4 addresses from its own /24 of subnet, and starts dnsmasq to serve
+// them in scope.
+func NewDnsmasq(subnet net.IPNet, scope Scope) (*Dnsmasq, error) {
+	dm := &Dnsmasq{leases: make(map[string]string)}
 	for s := byte(0); s < numSegments; s++ {
-		seg, err := newSegment(s)
+		seg, err := newSegment(subnet, s)
 		if err != nil {
 			return nil, fmt.Errorf("Network setup failed: %v", err)
 		}
@@ -170,7 +188,9 @@ func NewDnsmasq() (*Dnsmasq, error) {
 		return nil, fmt.Errorf("Network loopback setup failed: %v", err)
 	}
 
-	dm.dnsmasq = exec.Command("dnsmasq", "--conf-file=-")
+	dm.scope = scope
+	argv := scope.Wrap([]string{"dnsmasq", "--conf-file=-"})
+	dm.dnsmasq = exec.Command(argv[0], argv[1:]...)
 	cfg, err := dm.dnsmasq.StdinPipe()
 	if err != nil {
 		return nil, err
@@ -180,7 +200,7 @@ func NewDnsmasq() (*Dnsmasq, error) {
 		return nil, err
 	}
 	dm.dnsmasq.Stderr = dm.dnsmasq.Stdout
-	go util.LogFrom(capnslog.INFO, out)
+	go dm.logFrom(out)
 
 	if err = dm.dnsmasq.Start(); err != nil {
 		cfg.Close()
@@ -221,7 +241,51 @@ func (dm *Dnsmasq) GetInterface(bridge string) (in *Interface) {
 	panic("Not a valid bridge!")
 }
 
+// BridgeIP returns the IPv4 address of bridge, which the machines on its
+// segment use as their gateway and NTP server.
+func (dm *Dnsmasq) BridgeIP(bridge string) net.IP {
+	for _, seg := range dm.Segments {
+		if bridge == seg.BridgeName {
+			return seg.BridgeIf.DHCPv4[0].IP
+		}
+	}
+	panic("Not a valid bridge!")
+}
+
+// logFrom logs dnsmasq's output, recording the DHCP leases it grants.
+func (dm *Dnsmasq) logFrom(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, "dnsmasq-dhcp") {
+			plog.Info(line)
+			continue
+		}
+		plog.Debug(line)
+		if m := dhcpAckRE.FindStringSubmatch(line); m != nil {
+			dm.leaseMu.Lock()
+			dm.leases[strings.ToLower(m[2])] = m[1]
+			dm.leaseMu.Unlock()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		plog.Errorf("Reading dnsmasq output failed: %v", err)
+	}
+}
+
+// Leased returns the IPv4 address dnsmasq last acknowledged for mac, if
+// any.
+func (dm *Dnsmasq) Leased(mac net.HardwareAddr) (string, bool) {
+	dm.leaseMu.Lock()
+	defer dm.leaseMu.Unlock()
+	ip, ok := dm.leases[mac.String()]
+	return ip, ok
+}
+
 func (dm *Dnsmasq) Destroy() {
+	if err := dm.scope.Kill(); err != nil {
+		plog.Errorf("Error killing dnsmasq's scope: %v", err)
+	}
 	if err := dm.dnsmasq.Kill(); err != nil {
 		plog.Errorf("Error killing dnsmasq: %v", err)
 	}
@@ -0,0 +1,220 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// MetadataAddr is the link-local address cloud metadata services listen
+// on.
+const MetadataAddr = "169.254.169.254"
+
+// gceRoot is the root of the GCE metadata tree.
+const gceRoot = "/computeMetadata/v1"
+
+// MetadataServer simulates the EC2 and GCE metadata services for the
+// machines on a bridge. Machines reach it through their default route,
+// the bridge, or directly if they route link-local addresses on-link.
+type MetadataServer struct {
+	server *http.Server
+
+	mu       sync.Mutex
+	set      bool
+	next     platform.Metadata
+	machines map[string]platform.Metadata // by IPv4 address
+}
+
+// NewMetadataServer adds MetadataAddr to bridge and serves metadata on
+// it. It must be called in the cluster's network namespace.
+func NewMetadataServer(bridge string) (*MetadataServer, error) {
+	br, err := netlink.LinkByName(bridge)
+	if err != nil {
+		return nil, fmt.Errorf("metadata bridge failed: %v", err)
+	}
+	addr, err := netlink.ParseAddr(MetadataAddr + "/32")
+	if err != nil {
+		return nil, err
+	}
+	if err := netlink.AddrAdd(br, addr); err != nil {
+		return nil, fmt.Errorf("metadata AddrAdd() failed: %v", err)
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(MetadataAddr, "80"))
+	if err != nil {
+		return nil, err
+	}
+
+	ms := &MetadataServer{machines: make(map[string]platform.Metadata)}
+	ms.server = &http.Server{Handler: ms}
+	go func() {
+		if err := ms.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			plog.Errorf("Serving metadata failed: %v", err)
+		}
+	}()
+	return ms, nil
+}
+
+// SetMetadata sets the metadata served to machines added afterwards.
+func (ms *MetadataServer) SetMetadata(md platform.Metadata) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.set = true
+	ms.next = platform.Metadata{EC2: copyTree(md.EC2), GCE: copyTree(md.GCE)}
+}
+
+// IsSet reports whether SetMetadata has been called.
+func (ms *MetadataServer) IsSet() bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.set
+}
+
+// AddMachine serves the current metadata to the machine at ip, filling
+// in its name and addresses where the test didn't set them.
+func (ms *MetadataServer) AddMachine(ip net.IP, mac net.HardwareAddr, name string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	md := platform.Metadata{EC2: copyTree(ms.next.EC2), GCE: copyTree(ms.next.GCE)}
+	for k, v := range map[string]string{
+		"meta-data/instance-id":    name,
+		"meta-data/hostname":       name,
+		"meta-data/local-hostname": name,
+		"meta-data/local-ipv4":     ip.String(),
+		"meta-data/public-ipv4":    ip.String(),
+		"meta-data/mac":            mac.String(),
+	} {
+		if _, ok := md.EC2[k]; !ok {
+			md.EC2[k] = v
+		}
+	}
+	for k, v := range map[string]string{
+		"instance/name":                                              name,
+		"instance/hostname":                                          name,
+		"instance/network-interfaces/0/ip":                           ip.String(),
+		"instance/network-interfaces/0/mac":                          mac.String(),
+		"instance/network-interfaces/0/access-configs/0/external-ip": ip.String(),
+	} {
+		if _, ok := md.GCE[k]; !ok {
+			md.GCE[k] = v
+		}
+	}
+	ms.machines[ip.String()] = md
+}
+
+func (ms *MetadataServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ms.mu.Lock()
+	md, ok := ms.machines[host]
+	ms.mu.Unlock()
+	if !ok {
+		plog.Warningf("Metadata requested by unknown machine %s", host)
+		http.NotFound(w, r)
+		return
+	}
+
+	var value string
+	switch p := r.URL.Path; {
+	case p == gceRoot || strings.HasPrefix(p, gceRoot+"/"):
+		if r.Header.Get("Metadata-Flavor") != "Google" && r.Header.Get("X-Google-Metadata-Request") != "True" {
+			http.Error(w, "missing Metadata-Flavor: Google header", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Metadata-Flavor", "Google")
+		value, ok = lookupMetadata(md.GCE, strings.TrimPrefix(strings.TrimPrefix(p, gceRoot), "/"))
+	case p == "/":
+		value, ok = "latest", true
+	default:
+		// the EC2 tree is the same under every version
+		parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+		value, ok = lookupMetadata(md.EC2, parts[1])
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, value)
+}
+
+// lookupMetadata returns the value at p in tree or, if p is a directory,
+// its entries one per line with subdirectories marked by a trailing
+// slash.
+func lookupMetadata(tree map[string]string, p string) (string, bool) {
+	if v, ok := tree[p]; ok {
+		return v, true
+	}
+
+	prefix := strings.TrimSuffix(p, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	seen := make(map[string]bool)
+	var entries []string
+	for k := range tree {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		entry := k[len(prefix):]
+		if i := strings.Index(entry, "/"); i >= 0 {
+			entry = entry[:i+1]
+		}
+		if !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return "", false
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, "\n"), true
+}
+
+func copyTree(tree map[string]string) map[string]string {
+	ret := make(map[string]string, len(tree))
+	for k, v := range tree {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (ms *MetadataServer) Destroy() {
+	if err := ms.server.Close(); err != nil {
+		plog.Errorf("Error stopping metadata server: %v", err)
+	}
+}
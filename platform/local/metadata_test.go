@@ -0,0 +1,74 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coreos/mantle/platform"
+)
+
+func TestMetadataServer(t *testing.T) {
+	ms := &MetadataServer{machines: make(map[string]platform.Metadata)}
+	ms.SetMetadata(platform.Metadata{
+		EC2: map[string]string{
+			"meta-data/hostname":                  "custom.ec2.internal",
+			"meta-data/public-keys/0/openssh-key": "ssh-rsa AAAA",
+		},
+		GCE: map[string]string{"project/project-id": "kola"},
+	})
+	mac, _ := net.ParseMAC("02:00:00:00:00:02")
+	ms.AddMachine(net.IP{10, 0, 0, 2}, mac, "kola-1b4e28ba-0")
+	ms.SetMetadata(platform.Metadata{})
+	ms.AddMachine(net.IP{10, 0, 0, 3}, mac, "kola-1b4e28ba-1")
+
+	for _, tt := range []struct {
+		remote string
+		path   string
+		google bool
+		code   int
+		body   string
+	}{
+		{"10.0.0.2", "/latest/meta-data/hostname", false, 200, "custom.ec2.internal"},
+		{"10.0.0.2", "/2009-04-04/meta-data/local-ipv4", false, 200, "10.0.0.2"},
+		{"10.0.0.2", "/latest/meta-data/public-keys/", false, 200, "0/"},
+		{"10.0.0.2", "/latest/meta-data", false, 200, "hostname\ninstance-id\nlocal-hostname\nlocal-ipv4\nmac\npublic-ipv4\npublic-keys/"},
+		{"10.0.0.2", "/latest/user-data", false, 404, ""},
+		{"10.0.0.2", "/computeMetadata/v1/project/project-id", true, 200, "kola"},
+		{"10.0.0.2", "/computeMetadata/v1/project/project-id", false, 403, ""},
+		{"10.0.0.2", "/computeMetadata/v1/instance/network-interfaces/0/ip", true, 200, "10.0.0.2"},
+		{"10.0.0.3", "/latest/meta-data/hostname", false, 200, "kola-1b4e28ba-1"},
+		{"10.0.0.3", "/computeMetadata/v1/project/project-id", true, 404, ""},
+		{"10.0.0.4", "/latest/meta-data/hostname", false, 404, ""},
+	} {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		r.RemoteAddr = tt.remote + ":34567"
+		if tt.google {
+			r.Header.Set("Metadata-Flavor", "Google")
+		}
+		w := httptest.NewRecorder()
+		ms.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s %s: got status %d, want %d", tt.remote, tt.path, w.Code, tt.code)
+			continue
+		}
+		if tt.code == http.StatusOK && w.Body.String() != tt.body {
+			t.Errorf("%s %s: got %q, want %q", tt.remote, tt.path, w.Body.String(), tt.body)
+		}
+	}
+}
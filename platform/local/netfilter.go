@@ -0,0 +1,167 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// portChains are the filter chains kola's connections to machines
+// traverse, with the option naming the machine's port in each: new
+// connections leave through OUTPUT and replies come back through INPUT.
+var portChains = []struct {
+	chain, port, ports string
+}{
+	{"OUTPUT", "--dport", "--dports"},
+	{"INPUT", "--sport", "--sports"},
+}
+
+// checkRequiredPorts returns an error if the netfilter rules of the
+// cluster's namespace drop TCP connections from this process to ports
+// of its machines. The namespace starts without rules, so this only
+// fails if something added them. Without iptables-save there is nothing
+// to check.
+func (lc *LocalCluster) checkRequiredPorts(ports []int) error {
+	if _, err := exec.LookPath("iptables-save"); err != nil {
+		plog.Debugf("Not checking netfilter rules for required ports: %v", err)
+		return nil
+	}
+	out, err := lc.NewCommand("iptables-save", "-t", "filter").Output()
+	if err != nil {
+		return fmt.Errorf("reading netfilter rules: %v", err)
+	}
+	if blocked := blockedPorts(string(out), ports); len(blocked) > 0 {
+		return fmt.Errorf("required ports are blocked:\n%s", strings.Join(blocked, "\n"))
+	}
+	return nil
+}
+
+// blockedPorts returns why each of ports is blocked by the filter table
+// in rules, the output of iptables-save. In each chain of portChains,
+// the first rule matching every TCP connection to a port, or the
+// chain's policy if none does, decides whether it passes. Rules with
+// other matches, such as on addresses or connection state, and jumps
+// to other chains are skipped, as they may not apply.
+func blockedPorts(rules string, ports []int) []string {
+	policies := map[string]string{}
+	chains := map[string][][]string{}
+	inFilter := false
+	for _, line := range strings.Split(rules, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "*"):
+			inFilter = line == "*filter"
+		case !inFilter:
+		case strings.HasPrefix(line, ":"):
+			if f := strings.Fields(line[1:]); len(f) >= 2 {
+				policies[f[0]] = f[1]
+			}
+		case strings.HasPrefix(line, "-A "):
+			f := strings.Fields(line)
+			if len(f) >= 2 {
+				chains[f[1]] = append(chains[f[1]], f[2:])
+			}
+		}
+	}
+
+	var blocked []string
+	for _, port := range ports {
+		for _, pc := range portChains {
+			verdict, why := policies[pc.chain], "policy"
+			for _, rule := range chains[pc.chain] {
+				if target, ok := ruleVerdict(rule, port, pc.port, pc.ports); ok {
+					verdict, why = target, fmt.Sprintf("rule %q", strings.Join(rule, " "))
+					break
+				}
+			}
+			if verdict == "DROP" || verdict == "REJECT" {
+				blocked = append(blocked, fmt.Sprintf("tcp port %d: %s by %s %s", port, verdict, pc.chain, why))
+			}
+		}
+	}
+	return blocked
+}
+
+// ruleVerdict returns the target of rule, the options of an
+// iptables-save -A line after the chain, if it is ACCEPT, DROP or REJECT
+// and the rule matches every TCP packet with port as the option
+// portOpt, or one of the list portsOpt.
+func ruleVerdict(rule []string, port int, portOpt, portsOpt string) (string, bool) {
+	var target string
+	for i := 0; i < len(rule); i++ {
+		opt := rule[i]
+		if opt == "!" || i+1 == len(rule) {
+			return "", false
+		}
+		i++
+		arg := rule[i]
+		switch opt {
+		case "-p", "--protocol":
+			if arg != "tcp" && arg != "all" {
+				return "", false
+			}
+		case "-m", "--match":
+			if arg != "tcp" && arg != "multiport" {
+				return "", false
+			}
+		case portOpt, portsOpt:
+			if !portListHas(arg, port) {
+				return "", false
+			}
+		case "-j", "--jump":
+			target = arg
+		case "--reject-with":
+		default:
+			return "", false
+		}
+	}
+	switch target {
+	case "ACCEPT", "DROP", "REJECT":
+		return target, true
+	}
+	return "", false
+}
+
+// portListHas reports whether list, ports and first:last ranges
+// separated by commas, includes port.
+func portListHas(list string, port int) bool {
+	for _, p := range strings.Split(list, ",") {
+		first, last := p, p
+		if i := strings.Index(p, ":"); i >= 0 {
+			first, last = p[:i], p[i+1:]
+		}
+		lo, err := strconv.Atoi(first)
+		if first == "" {
+			lo, err = 0, nil
+		}
+		if err != nil {
+			continue
+		}
+		hi, err := strconv.Atoi(last)
+		if last == "" {
+			hi, err = 65535, nil
+		}
+		if err != nil {
+			continue
+		}
+		if lo <= port && port <= hi {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBlockedPorts(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		rules string
+		want  []string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name: "accepting",
+			rules: `*filter
+:INPUT ACCEPT [0:0]
+:FORWARD DROP [0:0]
+:OUTPUT ACCEPT [0:0]
+-A OUTPUT -d 10.0.0.1/32 -p tcp -j DROP
+-A OUTPUT -p udp -m udp --dport 80 -j DROP
+-A OUTPUT -p tcp -m tcp --dport 22 -j REJECT --reject-with tcp-reset
+COMMIT
+`,
+		},
+		{
+			name: "dropped",
+			rules: `*nat
+:OUTPUT ACCEPT [0:0]
+-A OUTPUT -p tcp -m tcp --dport 8080 -j DROP
+COMMIT
+*filter
+:INPUT ACCEPT [0:0]
+:OUTPUT ACCEPT [0:0]
+-A OUTPUT -p tcp -m multiport --dports 22,8000:8100 -j DROP
+COMMIT
+`,
+			want: []string{`tcp port 8080: DROP by OUTPUT rule "-p tcp -m multiport --dports 22,8000:8100 -j DROP"`},
+		},
+		{
+			name: "policy",
+			rules: `*filter
+:INPUT DROP [0:0]
+:OUTPUT ACCEPT [0:0]
+-A INPUT -m state --state RELATED,ESTABLISHED -j ACCEPT
+-A INPUT -p tcp -m tcp --sport 80 -j ACCEPT
+COMMIT
+`,
+			want: []string{"tcp port 8080: DROP by INPUT policy"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blockedPorts(tt.rules, []int{80, 8080}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// scopePrefix starts the unit name of every scope kola creates.
+	scopePrefix = "kola-"
+	// scopeDescription starts the description of every scope kola
+	// creates and is followed by the pid of the kola process, so that
+	// cleanup can tell which scopes outlived it.
+	scopeDescription = "kola pid "
+)
+
+var (
+	scopesOnce      sync.Once
+	scopesAvailable bool
+)
+
+// SystemdScopes reports whether the host runs systemd, so that a
+// cluster's processes can be run in transient scopes. Otherwise they are
+// only put in process groups of their own. The first call logs which.
+func SystemdScopes() bool {
+	scopesOnce.Do(func() {
+		_, err := os.Stat("/run/systemd/system")
+		if err == nil {
+			_, err = exec.LookPath("systemd-run")
+		}
+		if err == nil {
+			_, err = exec.LookPath("systemctl")
+		}
+		scopesAvailable = err == nil
+		if scopesAvailable {
+			plog.Infof("Running cluster processes in transient systemd scopes")
+		} else {
+			plog.Infof("Not using systemd scopes, killing cluster processes by process group: %v", err)
+		}
+	})
+	return scopesAvailable
+}
+
+// Scope is a transient systemd scope a process of a cluster runs in.
+// Killing the scope kills everything the process forked, even helpers
+// which left its process group. The zero Scope is no scope.
+type Scope struct {
+	Unit        string
+	Description string
+}
+
+// NewScope returns the scope for the cluster's process called name, or
+// the zero Scope if systemd scopes aren't available. Its unit is named
+// kola-<run id>-<cluster>-<name>.scope.
+func (lc *LocalCluster) NewScope(name string) Scope {
+	if !SystemdScopes() {
+		return Scope{}
+	}
+	unit := scopePrefix
+	if runID := lc.RuntimeConf().RunID; runID != "" {
+		unit += runID + "-"
+	}
+	unit += lc.ID()[:8] + "-" + name
+	return Scope{
+		Unit:        unitName(unit) + ".scope",
+		Description: fmt.Sprintf("%s%d: %s %s", scopeDescription, os.Getpid(), lc.Name(), name),
+	}
+}
+
+// unitName replaces the characters systemd doesn't allow in unit names.
+func unitName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == ':', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Wrap returns argv prefixed to run it in the scope. systemd-run execs
+// argv in place, so its pid is the started process's.
+func (s Scope) Wrap(argv []string) []string {
+	if s.Unit == "" {
+		return argv
+	}
+	return append([]string{
+		"systemd-run", "--scope", "--quiet",
+		"--unit", s.Unit,
+		"--description", s.Description,
+	}, argv...)
+}
+
+// Kill kills every process in the scope. A scope which is already gone
+// is not an error.
+func (s Scope) Kill() error {
+	if s.Unit == "" {
+		return nil
+	}
+	out, err := exec.Command("systemctl", "kill", "--signal=SIGKILL", s.Unit).CombinedOutput()
+	if err != nil && scopeLoaded(s.Unit) {
+		return fmt.Errorf("killing scope %s: %v: %s", s.Unit, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// scopeLoaded reports whether systemd still knows unit.
+func scopeLoaded(unit string) bool {
+	out, err := exec.Command("systemctl", "show", "--property=LoadState", unit).Output()
+	return err == nil && strings.TrimSpace(string(out)) == "LoadState=loaded"
+}
+
+// FindLeftoverScopes returns the scopes of kola processes which are no
+// longer running. systemd removes a scope once its processes have
+// exited, so each holds something kola left behind.
+func FindLeftoverScopes() ([]Scope, error) {
+	if !SystemdScopes() {
+		return nil, nil
+	}
+	out, err := exec.Command("systemctl", "list-units", "--type=scope", "--all",
+		"--plain", "--no-legend", "--no-pager", scopePrefix+"*").Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing scopes: %v", err)
+	}
+	var leftovers []Scope
+	lines := bufio.NewScanner(bytes.NewReader(out))
+	for lines.Scan() {
+		// UNIT LOAD ACTIVE SUB DESCRIPTION
+		fields := strings.Fields(lines.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		s := Scope{
+			Unit:        fields[0],
+			Description: strings.Join(fields[4:], " "),
+		}
+		if !strings.HasPrefix(s.Description, scopeDescription) {
+			continue
+		}
+		pid := strings.TrimPrefix(s.Description, scopeDescription)
+		if i := strings.IndexByte(pid, ':'); i >= 0 {
+			pid = pid[:i]
+		}
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		if _, err := os.Stat("/proc/" + pid); err == nil {
+			continue
+		}
+		leftovers = append(leftovers, s)
+	}
+	return leftovers, lines.Err()
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnitName(t *testing.T) {
+	for in, want := range map[string]string{
+		"kola-1b4e28ba-dnsmasq":           "kola-1b4e28ba-dnsmasq",
+		"kola-2018-10-16 host/12:x.y_z-a": "kola-2018-10-16_host_12:x.y_z-a",
+		"kola-ünïcode":                    "kola-_n_code",
+	} {
+		if got := unitName(in); got != want {
+			t.Errorf("unitName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestScopeWrap(t *testing.T) {
+	argv := []string{"dnsmasq", "--conf-file=-"}
+	if got := (Scope{}).Wrap(argv); !reflect.DeepEqual(got, argv) {
+		t.Errorf("zero scope wrapped %q as %q", argv, got)
+	}
+	if err := (Scope{}).Kill(); err != nil {
+		t.Errorf("killing the zero scope: %v", err)
+	}
+
+	s := Scope{Unit: "kola-1b4e28ba-dnsmasq.scope", Description: "kola pid 1: dnsmasq"}
+	want := []string{"systemd-run", "--scope", "--quiet",
+		"--unit", "kola-1b4e28ba-dnsmasq.scope",
+		"--description", "kola pid 1: dnsmasq",
+		"dnsmasq", "--conf-file=-"}
+	if got := s.Wrap(argv); !reflect.DeepEqual(got, want) {
+		t.Errorf("wrapped %q as %q, want %q", argv, got, want)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// The IPv4 addresses of a local cluster come from a single /16, with one
+// /24 per segment. By default the first private /16 which does not
+// overlap a network known to the host is used, so that the cluster does
+// not shadow e.g. docker0 or virbr0.
+var subnetMask = net.CIDRMask(16, 32)
+
+// subnetCandidates returns the /16s chooseSubnet picks from, in order:
+// those of 10.0.0.0/8, then of 172.16.0.0/12 for hosts routing all of
+// 10/8, e.g. through a VPN, and last 192.168.0.0/16.
+func subnetCandidates() []net.IPNet {
+	var candidates []net.IPNet
+	add := func(a, b byte) {
+		candidates = append(candidates, net.IPNet{IP: net.IPv4(a, b, 0, 0).To4(), Mask: subnetMask})
+	}
+	for i := 0; i < 256; i++ {
+		add(10, byte(i))
+	}
+	for i := 16; i < 32; i++ {
+		add(172, byte(i))
+	}
+	add(192, 168)
+	return candidates
+}
+
+// hostNetworks returns the IPv4 networks the current network namespace
+// has routes or addresses for, excluding default routes.
+func hostNetworks() ([]net.IPNet, error) {
+	var nets []net.IPNet
+
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("listing routes: %v", err)
+	}
+	for _, r := range routes {
+		if r.Dst != nil {
+			nets = append(nets, *r.Dst)
+		}
+	}
+
+	addrs, err := netlink.AddrList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("listing addresses: %v", err)
+	}
+	for _, a := range addrs {
+		nets = append(nets, net.IPNet{
+			IP:   a.IP.Mask(a.Mask),
+			Mask: a.Mask,
+		})
+	}
+
+	return nets, nil
+}
+
+// overlaps reports whether a and b share any address.
+func overlaps(a, b net.IPNet) bool {
+	return a.Contains(b.IP.Mask(b.Mask)) || b.Contains(a.IP.Mask(a.Mask))
+}
+
+// subnetConflicts returns the networks in host which overlap subnet.
+func subnetConflicts(subnet net.IPNet, host []net.IPNet) []net.IPNet {
+	var conflicts []net.IPNet
+	for _, n := range host {
+		if overlaps(subnet, n) {
+			conflicts = append(conflicts, n)
+		}
+	}
+	return conflicts
+}
+
+// chooseSubnet returns the cluster subnet to use. If requested is not
+// empty it must be a /16 which does not conflict with host; otherwise
+// the first conflict-free one of subnetCandidates is returned.
+func chooseSubnet(requested string, host []net.IPNet) (net.IPNet, error) {
+	if requested != "" {
+		_, subnet, err := net.ParseCIDR(requested)
+		if err != nil {
+			return net.IPNet{}, err
+		}
+		if subnet.IP.To4() == nil || subnet.Mask.String() != subnetMask.String() {
+			return net.IPNet{}, fmt.Errorf("subnet %s is not an IPv4 /16", requested)
+		}
+		if conflicts := subnetConflicts(*subnet, host); len(conflicts) > 0 {
+			return net.IPNet{}, fmt.Errorf("subnet %s conflicts with host networks %s", subnet, formatNetworks(conflicts))
+		}
+		return *subnet, nil
+	}
+
+	for _, subnet := range subnetCandidates() {
+		if len(subnetConflicts(subnet, host)) == 0 {
+			return subnet, nil
+		}
+	}
+	return net.IPNet{}, fmt.Errorf("no private /16 subnet is free of host networks %s", formatNetworks(host))
+}
+
+func formatNetworks(nets []net.IPNet) string {
+	var strs []string
+	for _, n := range nets {
+		strs = append(strs, n.String())
+	}
+	return strings.Join(strs, ", ")
+}
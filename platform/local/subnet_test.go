@@ -0,0 +1,128 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"net"
+	"testing"
+)
+
+func mustParseNetworks(t *testing.T, cidrs ...string) []net.IPNet {
+	var nets []net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("parsing %s: %v", cidr, err)
+		}
+		nets = append(nets, *n)
+	}
+	return nets
+}
+
+func TestChooseSubnet(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		requested string
+		host      []string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name: "no conflicts",
+			host: []string{"127.0.0.0/8", "192.168.1.0/24"},
+			want: "10.0.0.0/16",
+		},
+		{
+			name: "docker in 10.0",
+			host: []string{"192.168.1.0/24", "10.0.3.0/24"},
+			want: "10.1.0.0/16",
+		},
+		{
+			name: "wide route",
+			host: []string{"10.0.0.0/15", "10.2.128.0/17"},
+			want: "10.3.0.0/16",
+		},
+		{
+			name: "VPN routing 10/8",
+			host: []string{"127.0.0.0/8", "10.0.0.0/8", "172.17.0.0/16"},
+			want: "172.16.0.0/16",
+		},
+		{
+			name: "VPN routing 10/8 and 172.16/12",
+			host: []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12"},
+			want: "192.168.0.0/16",
+		},
+		{
+			name:    "all private networks",
+			host:    []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"},
+			wantErr: true,
+		},
+		{
+			name:      "requested free",
+			requested: "10.42.0.0/16",
+			host:      []string{"10.0.0.0/24"},
+			want:      "10.42.0.0/16",
+		},
+		{
+			name:      "requested conflicts",
+			requested: "10.0.0.0/16",
+			host:      []string{"192.168.1.0/24", "10.0.200.0/24"},
+			wantErr:   true,
+		},
+		{
+			name:      "requested not a /16",
+			requested: "10.0.0.0/24",
+			wantErr:   true,
+		},
+		{
+			name:      "requested IPv6",
+			requested: "fd00::/16",
+			wantErr:   true,
+		},
+	} {
+		got, err := chooseSubnet(tt.requested, mustParseNetworks(t, tt.host...))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %s", tt.name, &got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, &got, tt.want)
+		}
+	}
+}
+
+func TestSubnetConflicts(t *testing.T) {
+	subnet := mustParseNetworks(t, "10.0.0.0/16")[0]
+	host := mustParseNetworks(t,
+		"172.17.0.0/16",    // docker0
+		"192.168.122.0/24", // virbr0
+		"10.0.5.0/24",      // inside
+		"10.0.0.0/8",       // around
+		"10.1.0.0/16",      // adjacent
+	)
+	conflicts := subnetConflicts(subnet, host)
+	if len(conflicts) != 2 {
+		t.Fatalf("expected 2 conflicts, got %s", formatNetworks(conflicts))
+	}
+	if conflicts[0].String() != "10.0.5.0/24" || conflicts[1].String() != "10.0.0.0/8" {
+		t.Errorf("unexpected conflicts %s", formatNetworks(conflicts))
+	}
+}
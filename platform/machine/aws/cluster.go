@@ -39,6 +39,23 @@ type cluster struct {
 	api *aws.API
 }
 
+// UserDataLimit is the largest config EC2 accepts as user-data, before
+// base64 encoding.
+var UserDataLimit = platform.DeclareUserDataLimit(Platform, 16<<10)
+
+// Capabilities lists the optional features supported by this platform.
+var Capabilities = platform.NewCapabilities(map[platform.Capability]bool{
+	platform.CapReboot:           true,
+	platform.CapNetworkPartition: false,
+	platform.CapExtraDisks:       false,
+	platform.CapReverseForward:   true,
+	platform.CapConsole:          false,
+	platform.CapMemoryBalloon:    false,
+	platform.CapMetadataService:  false,
+	platform.CapCheckpoint:       false,
+	platform.CapDNSFixture:       false,
+})
+
 // NewCluster creates an instance of a Cluster suitable for spawning
 // instances on Amazon Web Services' Elastic Compute platform.
 //
@@ -46,6 +63,10 @@ type cluster struct {
 // $AWS_ACCESS_KEY_ID, and $AWS_SECRET_ACCESS_KEY to determine the region to
 // spawn instances in and the credentials to use to authenticate.
 func NewCluster(opts *aws.Options, rconf *platform.RuntimeConfig) (platform.Cluster, error) {
+	if err := rconf.MachineOptions.Check(Platform); err != nil {
+		return nil, err
+	}
+
 	api, err := aws.New(opts)
 	if err != nil {
 		return nil, err
@@ -75,8 +96,14 @@ func NewCluster(opts *aws.Options, rconf *platform.RuntimeConfig) (platform.Clus
 	return ac, nil
 }
 
+func (ac *cluster) Capabilities() platform.Capabilities {
+	return Capabilities
+}
+
 func (ac *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
+	name := ac.NextMachineName()
 	conf, err := ac.RenderUserData(userdata, map[string]string{
+		"$name":         name,
 		"$public_ipv4":  "${COREOS_EC2_IPV4_PUBLIC}",
 		"$private_ipv4": "${COREOS_EC2_IPV4_LOCAL}",
 	})
@@ -88,7 +115,9 @@ func (ac *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 	if !ac.RuntimeConf().NoSSHKeyInMetadata {
 		keyname = ac.Name()
 	}
-	instances, err := ac.api.CreateInstances(ac.Name(), keyname, conf.String(), 1)
+	// the Name tag identifies the run of an instance left behind by a
+	// crashed kola; ore aws gc terminates it by its CreatedBy tag
+	instances, err := ac.api.CreateInstances(name, keyname, conf.String(), 1)
 	if err != nil {
 		return nil, err
 	}
@@ -110,7 +139,7 @@ func (ac *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		return nil, err
 	}
 
-	if mach.journal, err = platform.NewJournal(mach.dir); err != nil {
+	if mach.journal, err = platform.NewJournal(mach.dir, ac.RuntimeConf().Limits); err != nil {
 		mach.Destroy()
 		return nil, err
 	}
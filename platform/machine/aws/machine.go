@@ -86,6 +86,12 @@ func (am *machine) Destroy() {
 		plog.Errorf("Error saving console for instance %v: %v", am.ID(), err)
 	}
 
+	// the console is saved once the instance shuts down; wait for the
+	// rest so a terminate which didn't take isn't left running
+	if err := am.cluster.api.WaitForTermination([]string{am.ID()}); err != nil {
+		plog.Errorf("Error waiting for instance %v to terminate: %v", am.ID(), err)
+	}
+
 	am.cluster.DelMach(am)
 }
 
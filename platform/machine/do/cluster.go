@@ -16,10 +16,11 @@ package do
 
 import (
 	"context"
-	"crypto/rand"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 
 	"github.com/coreos/pkg/capnslog"
 
@@ -41,9 +42,32 @@ type cluster struct {
 	*platform.BaseCluster
 	api      *do.API
 	sshKeyID int
+
+	mu      sync.Mutex
+	deleted map[int]bool // droplets deleted by their machines
 }
 
+// UserDataLimit is the largest user data DigitalOcean accepts.
+var UserDataLimit = platform.DeclareUserDataLimit(Platform, 64<<10)
+
+// Capabilities lists the optional features supported by this platform.
+var Capabilities = platform.NewCapabilities(map[platform.Capability]bool{
+	platform.CapReboot:           true,
+	platform.CapNetworkPartition: false,
+	platform.CapExtraDisks:       false,
+	platform.CapReverseForward:   true,
+	platform.CapConsole:          false,
+	platform.CapMemoryBalloon:    false,
+	platform.CapMetadataService:  false,
+	platform.CapCheckpoint:       false,
+	platform.CapDNSFixture:       false,
+})
+
 func NewCluster(opts *do.Options, rconf *platform.RuntimeConfig) (platform.Cluster, error) {
+	if err := rconf.MachineOptions.Check(Platform); err != nil {
+		return nil, err
+	}
+
 	api, err := do.New(opts)
 	if err != nil {
 		return nil, err
@@ -79,11 +103,18 @@ func NewCluster(opts *do.Options, rconf *platform.RuntimeConfig) (platform.Clust
 		BaseCluster: bc,
 		api:         api,
 		sshKeyID:    keyID,
+		deleted:     make(map[int]bool),
 	}, nil
 }
 
+func (dc *cluster) Capabilities() platform.Capabilities {
+	return Capabilities
+}
+
 func (dc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
+	name := dc.NextMachineName()
 	conf, err := dc.RenderUserData(userdata, map[string]string{
+		"$name":         name,
 		"$public_ipv4":  "${COREOS_DIGITALOCEAN_IPV4_PUBLIC_0}",
 		"$private_ipv4": "${COREOS_DIGITALOCEAN_IPV4_PRIVATE_0}",
 	})
@@ -91,7 +122,7 @@ func (dc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		return nil, err
 	}
 
-	droplet, err := dc.api.CreateDroplet(context.TODO(), dc.vmname(), dc.sshKeyID, conf.String())
+	droplet, err := dc.api.CreateDroplet(context.TODO(), name, dc.sshKeyID, conf.String(), dc.Name())
 	if err != nil {
 		return nil, err
 	}
@@ -123,7 +154,7 @@ func (dc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		return nil, err
 	}
 
-	if mach.journal, err = platform.NewJournal(dir); err != nil {
+	if mach.journal, err = platform.NewJournal(dir, dc.RuntimeConf().Limits); err != nil {
 		mach.Destroy()
 		return nil, err
 	}
@@ -138,16 +169,43 @@ func (dc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 	return mach, nil
 }
 
-func (dc *cluster) vmname() string {
-	b := make([]byte, 5)
-	rand.Read(b)
-	return fmt.Sprintf("%s-%x", dc.Name()[0:13], b)
+// dropletDeleted records that a machine deleted its droplet.
+func (dc *cluster) dropletDeleted(id int) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.deleted[id] = true
 }
 
+// Destroy deletes the cluster's machines, then any droplets tagged with
+// the cluster's name which no machine deleted, such as those of machines
+// which failed to start after their droplet was created.
 func (dc *cluster) Destroy() {
-	if err := dc.api.DeleteKey(context.TODO(), dc.sshKeyID); err != nil {
-		plog.Errorf("Error deleting key %v: %v", dc.sshKeyID, err)
+	dc.BaseCluster.Destroy()
+
+	ctx := context.TODO()
+	droplets, err := dc.api.ListDropletsWithTag(ctx, dc.Name())
+	if err != nil {
+		plog.Errorf("Error listing droplets of %v: %v", dc.Name(), err)
+	}
+	for _, droplet := range droplets {
+		dc.mu.Lock()
+		deleted := dc.deleted[droplet.ID]
+		dc.mu.Unlock()
+		if deleted {
+			continue
+		}
+		plog.Warningf("Deleting droplet %v, which no machine owns", droplet.ID)
+		if err := dc.api.DeleteDroplet(ctx, droplet.ID); err != nil {
+			dc.MachineLeaked(strconv.Itoa(droplet.ID), err)
+		}
+	}
+	if err == nil {
+		if err := dc.api.DeleteTag(ctx, dc.Name()); err != nil {
+			plog.Errorf("Error deleting tag: %v", err)
+		}
 	}
 
-	dc.BaseCluster.Destroy()
+	if err := dc.api.DeleteKey(ctx, dc.sshKeyID); err != nil {
+		plog.Errorf("Error deleting key %v: %v", dc.sshKeyID, err)
+	}
 }
@@ -66,7 +66,9 @@ func (dm *machine) Reboot() error {
 
 func (dm *machine) Destroy() {
 	if err := dm.cluster.api.DeleteDroplet(context.TODO(), dm.droplet.ID); err != nil {
-		plog.Errorf("Error deleting droplet %v: %v", dm.droplet.ID, err)
+		dm.cluster.MachineLeaked(dm.ID(), err)
+	} else {
+		dm.cluster.dropletDeleted(dm.droplet.ID)
 	}
 
 	if dm.journal != nil {
@@ -40,9 +40,29 @@ type cluster struct {
 	api *esx.API
 }
 
+// UserDataLimit declares that ESX has no documented limit on config size.
+var UserDataLimit = platform.DeclareUserDataLimit(Platform, platform.NoUserDataLimit)
+
+// Capabilities lists the optional features supported by this platform.
+var Capabilities = platform.NewCapabilities(map[platform.Capability]bool{
+	platform.CapReboot:           true,
+	platform.CapNetworkPartition: false,
+	platform.CapExtraDisks:       false,
+	platform.CapReverseForward:   true,
+	platform.CapConsole:          false,
+	platform.CapMemoryBalloon:    false,
+	platform.CapMetadataService:  false,
+	platform.CapCheckpoint:       false,
+	platform.CapDNSFixture:       false,
+})
+
 // NewCluster creates an instance of a Cluster suitable for spawning
 // instances on VMware ESXi vSphere platform.
 func NewCluster(opts *esx.Options, rconf *platform.RuntimeConfig) (platform.Cluster, error) {
+	if err := rconf.MachineOptions.Check(Platform); err != nil {
+		return nil, err
+	}
+
 	api, err := esx.New(opts)
 	if err != nil {
 		return nil, err
@@ -67,6 +87,10 @@ func (ec *cluster) vmname() string {
 	return fmt.Sprintf("%s-%x", ec.Name(), b)
 }
 
+func (ec *cluster) Capabilities() platform.Capabilities {
+	return Capabilities
+}
+
 func (ec *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
 	conf, err := ec.RenderUserData(userdata, map[string]string{
 		"$public_ipv4":  "${COREOS_ESX_IPV4_PUBLIC_0}",
@@ -107,7 +131,7 @@ ExecStart=/usr/bin/bash -c 'echo "COREOS_ESX_IPV4_PRIVATE_0=$(ip addr show ens19
 		return nil, err
 	}
 
-	if mach.journal, err = platform.NewJournal(mach.dir); err != nil {
+	if mach.journal, err = platform.NewJournal(mach.dir, ec.RuntimeConf().Limits); err != nil {
 		mach.Destroy()
 		return nil, err
 	}
@@ -16,12 +16,19 @@
 package gcloud
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh/agent"
 
 	"github.com/coreos/pkg/capnslog"
+	"google.golang.org/api/compute/v1"
 
 	ctplatform "github.com/coreos/container-linux-config-transpiler/config/platform"
 	"github.com/coreos/mantle/platform"
@@ -32,6 +39,14 @@ import (
 type cluster struct {
 	*platform.BaseCluster
 	api *gcloud.API
+
+	// firewall names the rule opening the RequiredPorts of the
+	// runtime config, and is the network tag of the cluster's
+	// instances which it applies to. It is created once the first
+	// machine shows which address this process connects from.
+	firewall       string
+	firewallMu     sync.Mutex
+	firewallOpened bool
 }
 
 const (
@@ -42,7 +57,34 @@ var (
 	plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "platform/machine/gcloud")
 )
 
+// UserDataLimit is the largest config GCE accepts, as it is passed in
+// a single metadata value.
+var UserDataLimit = platform.DeclareUserDataLimit(Platform, 256<<10)
+
+// Capabilities lists the optional features supported by this platform.
+var Capabilities = platform.NewCapabilities(map[platform.Capability]bool{
+	platform.CapReboot:           true,
+	platform.CapNetworkPartition: false,
+	platform.CapExtraDisks:       false,
+	platform.CapReverseForward:   true,
+	platform.CapConsole:          false,
+	platform.CapMemoryBalloon:    false,
+	platform.CapMetadataService:  false,
+	platform.CapCheckpoint:       false,
+	platform.CapDNSFixture:       false,
+})
+
 func NewCluster(opts *gcloud.Options, rconf *platform.RuntimeConfig) (platform.Cluster, error) {
+	mt, err := machineType(opts.MachineType, rconf.MachineOptions)
+	if err != nil {
+		return nil, err
+	}
+	if mt != opts.MachineType {
+		sized := *opts
+		sized.MachineType = mt
+		opts = &sized
+	}
+
 	api, err := gcloud.New(opts)
 	if err != nil {
 		return nil, err
@@ -57,13 +99,64 @@ func NewCluster(opts *gcloud.Options, rconf *platform.RuntimeConfig) (platform.C
 		BaseCluster: bc,
 		api:         api,
 	}
+	api.OperationDone = gc.operationDone
+	if len(rconf.RequiredPorts) > 0 {
+		gc.firewall = api.NewFirewallName()
+		api.Tags = []string{gc.firewall}
+	}
 
 	return gc, nil
 }
 
+// openPorts creates the cluster's firewall rule, if it needs one and
+// it doesn't exist yet, allowing connections to the RequiredPorts from
+// the address m sees this process's SSH connection come from, which
+// accounts for any NAT in between.
+func (gc *cluster) openPorts(m platform.Machine) error {
+	gc.firewallMu.Lock()
+	defer gc.firewallMu.Unlock()
+	if gc.firewall == "" || gc.firewallOpened {
+		return nil
+	}
+
+	out, stderr, err := m.SSH(`echo "${SSH_CLIENT%% *}"`)
+	if err != nil {
+		return fmt.Errorf("finding the address to open ports to: %v: %s", err, stderr)
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(out)))
+	if ip == nil || ip.To4() == nil {
+		return fmt.Errorf("finding the address to open ports to: unexpected SSH client address %q", out)
+	}
+
+	ports := gc.RuntimeConf().RequiredPorts
+	gc.firewallOpened = true
+	if err := gc.api.CreateFirewallRule(gc.firewall, gc.firewall, ports, []string{ip.String() + "/32"}); err != nil {
+		return err
+	}
+	plog.Infof("Opened tcp ports %v of cluster %v to %v", ports, gc.Name(), ip)
+	return nil
+}
+
+// operationDone adds how long an operation on an instance took to the
+// instance's events, naming the operation so it can be looked up later.
+func (gc *cluster) operationDone(op *compute.Operation, elapsed time.Duration) {
+	result := "done"
+	if op.Error != nil {
+		result = "failed"
+	}
+	gc.EmitEvent(path.Base(op.TargetLink), platform.MachineOperation,
+		fmt.Sprintf("%s %s %s after %v", op.OperationType, op.Name, result, elapsed.Round(time.Second)))
+}
+
+func (gc *cluster) Capabilities() platform.Capabilities {
+	return Capabilities
+}
+
 // Calling in parallel is ok
 func (gc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
+	name := gc.NextMachineName()
 	conf, err := gc.RenderUserData(userdata, map[string]string{
+		"$name":         name,
 		"$public_ipv4":  "${COREOS_GCE_IP_EXTERNAL_0}",
 		"$private_ipv4": "${COREOS_GCE_IP_LOCAL_0}",
 	})
@@ -79,7 +172,7 @@ func (gc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		}
 	}
 
-	instance, err := gc.api.CreateInstance(conf.String(), keys)
+	instance, err := gc.api.CreateInstanceContext(gc.RuntimeConf().TestContext(), name, conf.String(), keys)
 	if err != nil {
 		return nil, err
 	}
@@ -105,7 +198,7 @@ func (gc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		return nil, err
 	}
 
-	if gm.journal, err = platform.NewJournal(gm.dir); err != nil {
+	if gm.journal, err = platform.NewJournal(gm.dir, gc.RuntimeConf().Limits); err != nil {
 		gm.Destroy()
 		return nil, err
 	}
@@ -115,7 +208,24 @@ func (gc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		return nil, err
 	}
 
+	if err := gc.openPorts(gm); err != nil {
+		gm.Destroy()
+		return nil, err
+	}
+
 	gc.AddMach(gm)
 
 	return gm, nil
 }
+
+func (gc *cluster) Destroy() {
+	gc.firewallMu.Lock()
+	if gc.firewallOpened {
+		if err := gc.api.DeleteFirewallRule(gc.firewall); err != nil {
+			plog.Errorf("Error deleting firewall rule %v: %v", gc.firewall, err)
+		}
+	}
+	gc.firewallMu.Unlock()
+
+	gc.BaseCluster.Destroy()
+}
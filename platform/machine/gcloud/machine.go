@@ -15,6 +15,9 @@
 package gcloud
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 
@@ -37,6 +40,11 @@ func (gm *machine) ID() string {
 	return gm.name
 }
 
+// Hostname returns the instance name, which GCE assigns as the hostname.
+func (gm *machine) Hostname() string {
+	return gm.name
+}
+
 func (gm *machine) IP() string {
 	return gm.extIP
 }
@@ -70,8 +78,10 @@ func (gm *machine) Destroy() {
 		plog.Errorf("Error saving console for instance %v: %v", gm.ID(), err)
 	}
 
+	gm.checkAlive()
+
 	if err := gm.gc.api.TerminateInstance(gm.name); err != nil {
-		plog.Errorf("Error terminating instance %v: %v", gm.ID(), err)
+		gm.gc.MachineLeaked(gm.ID(), err)
 	}
 
 	if gm.journal != nil {
@@ -81,6 +91,59 @@ func (gm *machine) Destroy() {
 	gm.gc.DelMach(gm)
 }
 
+// Kill hard resets the instance, so that the OS doesn't shut down as it
+// would when the instance is deleted, then destroys it.
+func (gm *machine) Kill() {
+	if err := gm.gc.api.ResetInstance(gm.name); err != nil {
+		plog.Errorf("Error resetting instance %v: %v", gm.ID(), err)
+	}
+	gm.Destroy()
+}
+
+// checkAlive asks GCE whether the instance is still running before it
+// is destroyed, and reports that the machine died if it stopped on its
+// own, e.g. by shutting down or being preempted.
+func (gm *machine) checkAlive() {
+	ctx, cancel := context.WithTimeout(context.Background(), platform.ProbeTimeout)
+	defer cancel()
+	status, msg, err := gm.gc.api.GetInstanceStatus(ctx, gm.name)
+	if err != nil {
+		plog.Debugf("Checking instance %v before destroying it: %v", gm.ID(), err)
+		return
+	}
+	if status == "RUNNING" {
+		return
+	}
+	detail := "instance is " + status
+	if msg != "" {
+		detail += ": " + msg
+	}
+	gm.gc.EmitEvent(gm.ID(), platform.MachineDied, detail)
+}
+
+// DiagnoseBoot asks GCE about the instance and probes it over SSH.
+func (gm *machine) DiagnoseBoot() []string {
+	var findings []string
+
+	ctx, cancel := context.WithTimeout(context.Background(), platform.ProbeTimeout)
+	defer cancel()
+	if status, msg, err := gm.gc.api.GetInstanceStatus(ctx, gm.name); err != nil {
+		findings = append(findings, err.Error())
+	} else if status != "RUNNING" {
+		findings = append(findings, fmt.Sprintf("instance is %s, not RUNNING: %s", status, msg))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), platform.ProbeTimeout)
+	defer cancel()
+	if console, err := gm.gc.api.GetConsoleOutputContext(ctx, gm.name); err != nil {
+		findings = append(findings, err.Error())
+	} else {
+		findings = append(findings, platform.ConsoleFindings(console)...)
+	}
+
+	return append(findings, gm.gc.ProbeSSH(gm.IP(), net.DialTimeout))
+}
+
 func (gm *machine) ConsoleOutput() string {
 	return gm.console
 }
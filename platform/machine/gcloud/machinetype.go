@@ -0,0 +1,71 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"fmt"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// The limits of GCE custom machine types.
+const (
+	customMaxCPUs         = 96
+	customMemoryStep      = 256  // MiB
+	customMinMemoryPerCPU = 922  // MiB, 0.9 GiB rounded up
+	customMaxMemoryPerCPU = 6656 // MiB, 6.5 GiB
+
+	// standardMemoryPerCPU is the memory of n1-standard machine types,
+	// given to machines for which only CPUs are set.
+	standardMemoryPerCPU = 3840 // MiB
+)
+
+// machineType returns the GCE machine type for machines with o, the
+// default if o doesn't size them and otherwise a custom machine type
+// with the CPUs and memory set, the other picked to suit. Sizes no
+// custom machine type has are an *platform.UnsupportedOptionError.
+func machineType(def string, o platform.MachineOptions) (string, error) {
+	if err := o.Check(Platform, platform.OptionMemory, platform.OptionCPUs); err != nil {
+		return "", err
+	}
+	if o.CPUs == 0 && o.MemoryMiB == 0 {
+		return def, nil
+	}
+
+	cpus, memory := o.CPUs, o.MemoryMiB
+	if memory != 0 {
+		memory = (memory + customMemoryStep - 1) / customMemoryStep * customMemoryStep
+	}
+	switch {
+	case cpus == 0:
+		cpus = (memory + customMaxMemoryPerCPU - 1) / customMaxMemoryPerCPU
+		if cpus > 1 && cpus%2 != 0 {
+			cpus++
+		}
+	case memory == 0:
+		memory = cpus * standardMemoryPerCPU
+	}
+
+	unsupported := func(option, format string, args ...interface{}) error {
+		return &platform.UnsupportedOptionError{Platform: Platform, Option: option, Reason: fmt.Sprintf(format, args...)}
+	}
+	if cpus > customMaxCPUs || (cpus > 1 && cpus%2 != 0) {
+		return "", unsupported(platform.OptionCPUs, "custom machine types have 1 or an even number of CPUs up to %d, not %d", customMaxCPUs, cpus)
+	}
+	if memory < cpus*customMinMemoryPerCPU || memory > cpus*customMaxMemoryPerCPU {
+		return "", unsupported(platform.OptionMemory, "custom machine types have %d to %d MiB per CPU, not %d MiB for %d CPUs", customMinMemoryPerCPU, customMaxMemoryPerCPU, memory, cpus)
+	}
+	return fmt.Sprintf("custom-%d-%d", cpus, memory), nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"testing"
+
+	"github.com/coreos/mantle/platform"
+)
+
+func TestMachineType(t *testing.T) {
+	for _, c := range []struct {
+		o           platform.MachineOptions
+		want        string
+		unsupported string
+	}{
+		{platform.MachineOptions{}, "n1-standard-1", ""},
+		{platform.MachineOptions{CPUs: 2, MemoryMiB: 4096}, "custom-2-4096", ""},
+		{platform.MachineOptions{CPUs: 4}, "custom-4-15360", ""},
+		{platform.MachineOptions{MemoryMiB: 1000}, "custom-1-1024", ""},
+		{platform.MachineOptions{MemoryMiB: 8192}, "custom-2-8192", ""},
+		{platform.MachineOptions{MemoryMiB: 14000}, "custom-4-14080", ""},
+		{platform.MachineOptions{CPUs: 3}, "", platform.OptionCPUs},
+		{platform.MachineOptions{CPUs: 128}, "", platform.OptionCPUs},
+		{platform.MachineOptions{CPUs: 1, MemoryMiB: 8192}, "", platform.OptionMemory},
+		{platform.MachineOptions{CPUs: 8, MemoryMiB: 1024}, "", platform.OptionMemory},
+		{platform.MachineOptions{AdditionalDisks: []platform.Disk{{Size: "1G"}}}, "", platform.OptionAdditionalDisks},
+	} {
+		got, err := machineType("n1-standard-1", c.o)
+		if c.unsupported != "" {
+			if uerr, ok := err.(*platform.UnsupportedOptionError); !ok || uerr.Option != c.unsupported {
+				t.Errorf("%v: got %q, %v, want %s unsupported", c.o, got, err, c.unsupported)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%v: got %q, %v, want %q", c.o, got, err, c.want)
+		}
+	}
+}
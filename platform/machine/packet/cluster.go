@@ -42,7 +42,28 @@ type cluster struct {
 	sshKeyID string
 }
 
+// UserDataLimit declares that Packet has no documented limit on config
+// size.
+var UserDataLimit = platform.DeclareUserDataLimit(Platform, platform.NoUserDataLimit)
+
+// Capabilities lists the optional features supported by this platform.
+var Capabilities = platform.NewCapabilities(map[platform.Capability]bool{
+	platform.CapReboot:           true,
+	platform.CapNetworkPartition: false,
+	platform.CapExtraDisks:       false,
+	platform.CapReverseForward:   true,
+	platform.CapConsole:          false,
+	platform.CapMemoryBalloon:    false,
+	platform.CapMetadataService:  false,
+	platform.CapCheckpoint:       false,
+	platform.CapDNSFixture:       false,
+})
+
 func NewCluster(opts *packet.Options, rconf *platform.RuntimeConfig) (platform.Cluster, error) {
+	if err := rconf.MachineOptions.Check(Platform); err != nil {
+		return nil, err
+	}
+
 	api, err := packet.New(opts)
 	if err != nil {
 		return nil, err
@@ -75,6 +96,10 @@ func NewCluster(opts *packet.Options, rconf *platform.RuntimeConfig) (platform.C
 	return pc, nil
 }
 
+func (pc *cluster) Capabilities() platform.Capabilities {
+	return Capabilities
+}
+
 func (pc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
 	conf, err := pc.RenderUserData(userdata, map[string]string{
 		"$public_ipv4":  "${COREOS_PACKET_IPV4_PUBLIC_0}",
@@ -140,7 +165,7 @@ func (pc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		return nil, err
 	}
 
-	if mach.journal, err = platform.NewJournal(dir); err != nil {
+	if mach.journal, err = platform.NewJournal(dir, pc.RuntimeConf().Limits); err != nil {
 		mach.Destroy()
 		return nil, err
 	}
@@ -0,0 +1,341 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/vishvananda/netns"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/coreos/mantle/network"
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+	"github.com/coreos/mantle/platform/local"
+)
+
+// adoptRecordName is the file in a machine's directory which describes
+// the machine to a later run adopting it.
+const adoptRecordName = "adopt.json"
+
+// adoptKeyPath is where the SSH key of a machine kept for adoption is
+// saved: outside its directory, which may be uploaded as an artifact.
+func adoptKeyPath(id string) string {
+	return filepath.Join(os.TempDir(), "kola-adopt-"+id+".pem")
+}
+
+var (
+	// claimed holds the IDs of the machines adopted so far; clusters of
+	// the same run find the same ones.
+	claimedMu sync.Mutex
+	claimed   = make(map[string]bool)
+)
+
+// consoleLogRe matches the console log argument of a machine's qemu,
+// which lies in the machine's directory.
+var consoleLogRe = regexp.MustCompile(`,logfile=(\S+)`)
+
+// adoptRecord describes a running machine so that a later run can take
+// it over once the run which booted it is gone.
+type adoptRecord struct {
+	RunID    string        `json:"run_id"`
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	PID      int           `json:"pid"`
+	MAC      string        `json:"mac"`
+	IP       string        `json:"ip"`
+	Image    imageIdentity `json:"image"`
+	UserData string        `json:"userdata_digest"`
+	// SSHKeyPath is the PEM-encoded key of the cluster which booted
+	// the machine, the only one the machine accepts.
+	SSHKeyPath string `json:"ssh_key_path"`
+
+	dir string // the machine's directory
+}
+
+// imageIdentity identifies the disk image a machine booted from.
+type imageIdentity struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func identifyImage(path string) (imageIdentity, error) {
+	// resolved like the backing file of the primary disk
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return imageIdentity{}, err
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return imageIdentity{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return imageIdentity{}, err
+	}
+	return imageIdentity{Path: path, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (i imageIdentity) equal(o imageIdentity) bool {
+	return i.Path == o.Path && i.Size == o.Size && i.ModTime.Equal(o.ModTime)
+}
+
+// adoption holds what an adopted machine is reached through: the
+// network namespace of the run which booted it, held open by its qemu,
+// and an agent with that run's key.
+type adoption struct {
+	ns    netns.NsHandle
+	agent *network.SSHAgent
+}
+
+func (a *adoption) close() {
+	a.agent.Close()
+	a.ns.Close()
+}
+
+// plainOptions reports whether options are the defaults, the only ones
+// machines are adopted with.
+func plainOptions(options MachineOptions) bool {
+	return options.MemoryMiB == 0 && options.CPUs == 0 && len(options.AdditionalDisks) == 0 &&
+		options.CPUSet == "" && options.NUMANode == nil
+}
+
+// writeAdoptRecord records qm in dir for a later run to adopt, if the
+// cluster keeps its machines for adoption and belongs to a named run.
+func (qc *Cluster) writeAdoptRecord(qm *machine, dir string, userdata *conf.UserData) error {
+	rconf := qc.RuntimeConf()
+	if !qc.opts.KeepForAdoption || rconf.RunID == "" {
+		return nil
+	}
+	image, err := identifyImage(qc.opts.DiskImage)
+	if err != nil {
+		return err
+	}
+	keyPath := adoptKeyPath(qm.id)
+	key := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(qc.SSHPrivateKey()),
+	})
+	if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(adoptRecord{
+		RunID:      rconf.RunID,
+		ID:         qm.id,
+		Name:       qm.name,
+		PID:        qm.pid,
+		MAC:        qm.netif.HardwareAddr.String(),
+		IP:         qm.IP(),
+		Image:      image,
+		UserData:   userdata.Digest(),
+		SSHKeyPath: keyPath,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, adoptRecordName), buf, 0666)
+}
+
+// findAdoptable returns the records of the surviving machines of run
+// runID, whose kola process is gone. It refuses to adopt any if one of
+// them runs a different image.
+func findAdoptable(runID string, image imageIdentity) ([]adoptRecord, error) {
+	strays, err := FindStrays()
+	if err != nil {
+		return nil, err
+	}
+	return adoptableRecords(strays, runID, image)
+}
+
+// adoptableRecords returns the records of those strays which are machines
+// of run runID, as findAdoptable does.
+func adoptableRecords(strays []Stray, runID string, image imageIdentity) ([]adoptRecord, error) {
+	var records []adoptRecord
+	for _, s := range strays {
+		// a machine whose QMP socket is gone was being destroyed
+		if _, err := os.Stat(qmpSocketPath(s.MachineID)); err != nil {
+			continue
+		}
+		m := consoleLogRe.FindStringSubmatch(s.Cmdline)
+		if m == nil {
+			continue
+		}
+		dir := filepath.Dir(m[1])
+		buf, err := ioutil.ReadFile(filepath.Join(dir, adoptRecordName))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			plog.Warningf("Reading adoption record of machine %v: %v", s.MachineID, err)
+			continue
+		}
+		var rec adoptRecord
+		if err := json.Unmarshal(buf, &rec); err != nil {
+			plog.Warningf("Parsing adoption record of machine %v: %v", s.MachineID, err)
+			continue
+		}
+		if rec.RunID != runID || rec.ID != s.MachineID || rec.PID != s.PID {
+			continue
+		}
+		if !rec.Image.equal(image) {
+			return nil, fmt.Errorf("machine %v of run %v booted image %s (%d bytes, modified %v), not %s (%d bytes, modified %v); refusing to adopt",
+				rec.ID, runID, rec.Image.Path, rec.Image.Size, rec.Image.ModTime, image.Path, image.Size, image.ModTime)
+		}
+		rec.dir = dir
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// adoptMachine takes over a machine of the adopted run which booted
+// with the same userdata, if there is one.
+func (qc *Cluster) adoptMachine(userdata *conf.UserData, options MachineOptions) *machine {
+	// adopted machines query the metadata service of their own run
+	if !plainOptions(options) || qc.Metadata.IsSet() {
+		return nil
+	}
+	digest := userdata.Digest()
+	for {
+		qc.mu.Lock()
+		var rec *adoptRecord
+		rec, qc.adoptable = claimAdoptable(qc.adoptable, digest)
+		qc.mu.Unlock()
+		if rec == nil {
+			return nil
+		}
+
+		qm, err := qc.adopt(*rec)
+		if err != nil {
+			plog.Warningf("Adopting machine %v failed: %v", rec.ID, err)
+			continue
+		}
+		plog.Infof("Adopted machine %v of run %v", rec.ID, rec.RunID)
+		return qm
+	}
+}
+
+// claimAdoptable claims the first of records with the userdata digest
+// which no cluster claimed yet, returning it and the records left to
+// claim.
+func claimAdoptable(records []adoptRecord, digest string) (*adoptRecord, []adoptRecord) {
+	claimedMu.Lock()
+	defer claimedMu.Unlock()
+	for i := 0; i < len(records); i++ {
+		r := records[i]
+		if claimed[r.ID] {
+			records = append(records[:i], records[i+1:]...)
+			i--
+			continue
+		}
+		if r.UserData == digest {
+			claimed[r.ID] = true
+			return &r, append(records[:i], records[i+1:]...)
+		}
+	}
+	return nil, records
+}
+
+func (qc *Cluster) adopt(rec adoptRecord) (*machine, error) {
+	pemKey, err := ioutil.ReadFile(rec.SSHKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading SSH key: %v", err)
+	}
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("no SSH key in " + rec.SSHKeyPath)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SSH key: %v", err)
+	}
+	mac, err := net.ParseMAC(rec.MAC)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(rec.IP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", rec.IP)
+	}
+
+	nsHandle, err := netns.GetFromPid(rec.PID)
+	if err != nil {
+		return nil, fmt.Errorf("getting network namespace: %v", err)
+	}
+	newAgent := network.NewSSHAgent
+	if qc.RuntimeConf().StrictCrypto {
+		newAgent = network.NewStrictSSHAgent
+	}
+	sshAgent, err := newAgent(network.NewNsDialer(nsHandle))
+	if err != nil {
+		nsHandle.Close()
+		return nil, err
+	}
+	adoption := &adoption{ns: nsHandle, agent: sshAgent}
+	if err := sshAgent.Add(agent.AddedKey{PrivateKey: key, Comment: "core@adopted"}); err != nil {
+		adoption.close()
+		return nil, err
+	}
+
+	dir := filepath.Join(qc.RuntimeConf().OutputDir, rec.ID)
+	if err := os.Mkdir(dir, 0777); err != nil {
+		adoption.close()
+		return nil, err
+	}
+	journal, err := platform.NewJournal(dir, qc.RuntimeConf().Limits)
+	if err != nil {
+		adoption.close()
+		return nil, err
+	}
+
+	qm := &machine{
+		qc:     qc,
+		id:     rec.ID,
+		name:   rec.Name,
+		pid:    rec.PID,
+		exited: make(chan struct{}),
+		netif: &local.Interface{
+			HardwareAddr: mac,
+			DHCPv4:       []net.IPNet{{IP: ip, Mask: net.CIDRMask(24, 32)}},
+		},
+		journal: journal,
+		// qemu keeps writing to the console log of the run which
+		// booted the machine
+		consolePath:   filepath.Join(rec.dir, "console.txt"),
+		consoleSocket: filepath.Join(os.TempDir(), "kola-console-"+rec.ID),
+		agent:         &guestAgent{path: filepath.Join(os.TempDir(), "kola-qga-"+rec.ID)},
+		qmp:           &monitor{path: qmpSocketPath(rec.ID)},
+		adoption:      adoption,
+	}
+	go qm.watch()
+
+	// an adopted machine which doesn't pass the checks is useless to
+	// this run and, with its run gone, to anyone else
+	if err := platform.StartMachine(qm, journal); err != nil {
+		qm.Destroy()
+		return nil, err
+	}
+	qc.AddMach(qm)
+	return qm, nil
+}
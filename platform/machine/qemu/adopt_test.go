@@ -0,0 +1,141 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stray creates the directory, adoption record and QMP socket of a
+// machine left running by a killed run, returning its process.
+func stray(t *testing.T, dir string, rec adoptRecord) Stray {
+	mdir := filepath.Join(dir, rec.ID)
+	if err := os.Mkdir(mdir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(mdir, adoptRecordName), buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(qmpSocketPath(rec.ID), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return Stray{
+		PID:       rec.PID,
+		MachineID: rec.ID,
+		Cmdline:   "qemu-system-x86_64 -chardev socket,id=log,path=/tmp/c,logfile=" + filepath.Join(mdir, "console.txt") + " -m 1024",
+	}
+}
+
+func TestAdoptableRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qemu-adopt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	image := imageIdentity{Path: "/images/coreos.img", Size: 1 << 30, ModTime: time.Unix(1500000000, 0).UTC()}
+	prefix := filepath.Base(dir) + "-"
+	record := func(id, run string, pid int) adoptRecord {
+		return adoptRecord{RunID: run, ID: prefix + id, PID: pid, Image: image, UserData: "digest"}
+	}
+	adopted := record("adopted", "run1", 100)
+	strays := []Stray{
+		stray(t, dir, adopted),
+		stray(t, dir, record("other-run", "run2", 101)),
+		stray(t, dir, record("reused-pid", "run1", 999)),
+		{PID: 103, MachineID: prefix + "no-record", Cmdline: "qemu-system-x86_64 -m 1024"},
+	}
+	destroyed := stray(t, dir, record("destroyed", "run1", 104))
+	strays = append(strays, destroyed)
+	strays[2].PID = 102
+	for _, s := range strays {
+		defer os.Remove(qmpSocketPath(s.MachineID))
+	}
+	os.Remove(qmpSocketPath(destroyed.MachineID))
+
+	records, err := adoptableRecords(strays, "run1", image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adopted.dir = filepath.Join(dir, adopted.ID)
+	if want := []adoptRecord{adopted}; !reflect.DeepEqual(records, want) {
+		t.Errorf("adoptable records %+v, want %+v", records, want)
+	}
+
+	// the image was rebuilt since
+	rebuilt := image
+	rebuilt.ModTime = rebuilt.ModTime.Add(time.Hour)
+	if _, err := adoptableRecords(strays, "run1", rebuilt); err == nil || !strings.Contains(err.Error(), "refusing to adopt") {
+		t.Errorf("adopted machines of another image: %v", err)
+	}
+}
+
+func TestClaimAdoptable(t *testing.T) {
+	prefix := t.Name() + "-"
+	records := []adoptRecord{
+		{ID: prefix + "a", UserData: "x"},
+		{ID: prefix + "b", UserData: "y"},
+		{ID: prefix + "c", UserData: "x"},
+	}
+	defer func() {
+		claimedMu.Lock()
+		defer claimedMu.Unlock()
+		for _, id := range []string{"a", "b", "c"} {
+			delete(claimed, prefix+id)
+		}
+	}()
+	ids := func(records []adoptRecord) []string {
+		var ids []string
+		for _, r := range records {
+			ids = append(ids, strings.TrimPrefix(r.ID, prefix))
+		}
+		return ids
+	}
+
+	// another cluster of the run claimed the same machines
+	other := append([]adoptRecord(nil), records...)
+	rec, other := claimAdoptable(other, "x")
+	if rec == nil || rec.ID != prefix+"a" {
+		t.Fatalf("claimed %+v, want a", rec)
+	}
+
+	rec, records = claimAdoptable(records, "x")
+	if rec == nil || rec.ID != prefix+"c" {
+		t.Errorf("claimed %+v, want c as a was claimed", rec)
+	}
+	if left := ids(records); !reflect.DeepEqual(left, []string{"b"}) {
+		t.Errorf("left %v, want [b]", left)
+	}
+	if rec, _ = claimAdoptable(records, "z"); rec != nil {
+		t.Errorf("claimed %+v for other userdata", rec)
+	}
+	if rec, records = claimAdoptable(records, "y"); rec == nil || len(records) != 0 {
+		t.Errorf("claimed %+v, leaving %v", rec, ids(records))
+	}
+	if rec, other = claimAdoptable(other, "x"); rec != nil || len(other) != 0 {
+		t.Errorf("claimed %+v again, leaving %v", rec, ids(other))
+	}
+}
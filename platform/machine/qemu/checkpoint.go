@@ -0,0 +1,171 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// checkpointTimeout bounds saving or loading the state of one machine,
+// which writes or reads all of its memory.
+const checkpointTimeout = 5 * time.Minute
+
+// hmp runs a human monitor command, which only reports failure in its
+// output.
+func (q *monitor) hmp(command string, timeout time.Duration) error {
+	var out string
+	if err := q.callTimeout("human-monitor-command", map[string]string{"command-line": command}, &out, timeout); err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("%s: %s", command, out)
+	}
+	return nil
+}
+
+// Checkpoint saves the memory and disks of every machine as internal
+// snapshots of its qcow2 disks. All machines are paused while any is
+// saved, so that the checkpoint holds the cluster at one moment and the
+// guests need not be quiesced.
+func (qc *Cluster) Checkpoint(name string) error {
+	machines, err := qc.qemuMachines()
+	if err != nil {
+		return err
+	}
+	if err := pauseMachines(machines); err != nil {
+		return err
+	}
+	defer resumeMachines(machines)
+
+	ids := make([]string, 0, len(machines))
+	for i, m := range machines {
+		if err := m.qmp.hmp("savevm "+name, checkpointTimeout); err != nil {
+			for _, saved := range machines[:i] {
+				if err := saved.qmp.hmp("delvm "+name, checkpointTimeout); err != nil {
+					plog.Errorf("Error deleting partial checkpoint %q of %v: %v", name, saved.ID(), err)
+				}
+			}
+			return fmt.Errorf("checkpointing %v: %v", m.ID(), err)
+		}
+		ids = append(ids, m.ID())
+	}
+
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	if qc.checkpoints == nil {
+		qc.checkpoints = make(map[string][]string)
+	}
+	qc.checkpoints[name] = ids
+	return nil
+}
+
+// RestoreCheckpoint destroys the machines created since the checkpoint
+// name and loads the state of the others. Afterwards each machine's
+// journal is followed anew, and its clock, which the checkpoint set
+// back, is set from its hardware clock through the guest agent if the
+// image provides one.
+func (qc *Cluster) RestoreCheckpoint(name string) error {
+	qc.mu.Lock()
+	ids, ok := qc.checkpoints[name]
+	qc.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no checkpoint %q", name)
+	}
+
+	current, err := qc.qemuMachines()
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*machine, len(current))
+	for _, m := range current {
+		byID[m.ID()] = m
+	}
+	machines := make([]*machine, 0, len(ids))
+	for _, id := range ids {
+		m, ok := byID[id]
+		if !ok {
+			return fmt.Errorf("machine %v of checkpoint %q has been destroyed", id, name)
+		}
+		machines = append(machines, m)
+		delete(byID, id)
+	}
+	for _, m := range byID {
+		plog.Infof("Destroying %v, created after checkpoint %q", m.ID(), name)
+		m.Destroy()
+	}
+
+	if err := pauseMachines(machines); err != nil {
+		return err
+	}
+	for _, m := range machines {
+		if err := m.qmp.hmp("loadvm "+name, checkpointTimeout); err != nil {
+			resumeMachines(machines)
+			return fmt.Errorf("restoring %v: %v", m.ID(), err)
+		}
+	}
+	resumeMachines(machines)
+
+	for _, m := range machines {
+		if err := m.agent.call("guest-set-time", nil, nil); err != nil && err != platform.ErrGuestAgentNotAvailable {
+			plog.Warningf("Setting the clock of %v: %v", m.ID(), err)
+		}
+		if err := m.journal.Start(context.TODO(), m); err != nil {
+			return fmt.Errorf("machine %q failed to restore: %v", m.ID(), err)
+		}
+		if err := platform.CheckMachine(context.TODO(), m); err != nil {
+			return fmt.Errorf("machine %q failed basic checks: %v", m.ID(), err)
+		}
+	}
+	return nil
+}
+
+// qemuMachines returns the cluster's machines, which are all qemu's.
+func (qc *Cluster) qemuMachines() ([]*machine, error) {
+	var machines []*machine
+	for _, m := range qc.Machines() {
+		qm, ok := m.(*machine)
+		if !ok {
+			return nil, fmt.Errorf("machine %v is not a qemu machine", m.ID())
+		}
+		machines = append(machines, qm)
+	}
+	return machines, nil
+}
+
+// pauseMachines stops the CPUs of machines, resuming those already
+// paused if any fails.
+func pauseMachines(machines []*machine) error {
+	for i, m := range machines {
+		if err := m.qmp.call("stop", nil, nil); err != nil {
+			resumeMachines(machines[:i])
+			return fmt.Errorf("pausing %v: %v", m.ID(), err)
+		}
+	}
+	return nil
+}
+
+// resumeMachines restarts the CPUs of machines.
+func resumeMachines(machines []*machine) {
+	for _, m := range machines {
+		if err := m.qmp.call("cont", nil, nil); err != nil {
+			plog.Errorf("Error resuming %v: %v", m.ID(), err)
+		}
+	}
+}
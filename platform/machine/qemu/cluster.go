@@ -16,12 +16,15 @@ package qemu
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/coreos/pkg/capnslog"
 	"github.com/satori/go.uuid"
@@ -31,6 +34,7 @@ import (
 	"github.com/coreos/mantle/platform/local"
 	"github.com/coreos/mantle/system/exec"
 	"github.com/coreos/mantle/system/ns"
+	"github.com/coreos/mantle/util"
 )
 
 const (
@@ -48,6 +52,23 @@ type Options struct {
 	// It can be a plain name, or a full path.
 	BIOSImage string
 
+	// Subnet is the IPv4 /16 to number machines from. If empty, a
+	// private /16 which does not conflict with the host's networks is
+	// chosen.
+	Subnet string
+
+	// AdoptRun, if set, is the ID of an earlier run whose machines
+	// survived it, e.g. because kola was killed. NewMachine hands out
+	// those which booted the same userdata instead of booting fresh
+	// ones. This is meant for iterating on tests during development.
+	AdoptRun string
+
+	// KeepForAdoption records each machine so that a later run can
+	// adopt it with AdoptRun should this run be killed. The record's
+	// SSH key is kept in the temporary directory, outside the
+	// machine's output.
+	KeepForAdoption bool
+
 	*platform.Options
 }
 
@@ -57,45 +78,126 @@ type Options struct {
 // through type assertions.
 type Cluster struct {
 	opts *Options
+	base baseImage
 
-	mu sync.Mutex
+	mu          sync.Mutex
+	adoptable   []adoptRecord       // protected by mu
+	checkpoints map[string][]string // IDs of the machines saved in each, protected by mu
 	*local.LocalCluster
 }
 
+// MachineOptions are the hardware of a machine. The cluster's
+// platform.MachineOptions are used by NewMachine.
 type MachineOptions struct {
+	// MemoryMiB, if not 0, replaces the default memory of 1024 MiB,
+	// or 2048 MiB on arm64.
+	MemoryMiB int
+	// CPUs, if not 0, replaces the default of one virtual CPU.
+	CPUs int
+
 	AdditionalDisks []Disk
-}
 
-type Disk struct {
-	Size   string // disk image size in bytes, optional suffixes "K", "M", "G", "T" allowed
-	Serial string // serial number to be passed to qemu via `serial=`. Disks show up under /dev/disk/by-id/virtio-<serial>
+	// CPUSet pins the machine to host CPUs, in the list format used
+	// by taskset -c, e.g. "0-3,8".
+	CPUSet string
+	// NUMANode, if set, binds the machine's memory, and its CPUs unless
+	// CPUSet is given, to a host NUMA node.
+	NUMANode *int
 }
 
+type Disk = platform.Disk
+
 var (
 	plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "kola/platform/machine/qemu")
 )
 
+// UserDataLimit declares that qemu has no limit on config size since the
+// config is passed to the machine as a file.
+var UserDataLimit = platform.DeclareUserDataLimit(Platform, platform.NoUserDataLimit)
+
+// Capabilities lists the optional features supported by this platform.
+var Capabilities = platform.NewCapabilities(map[platform.Capability]bool{
+	platform.CapReboot:           true,
+	platform.CapNetworkPartition: true,
+	platform.CapExtraDisks:       true,
+	platform.CapReverseForward:   true,
+	platform.CapConsole:          true,
+	platform.CapMemoryBalloon:    true,
+	platform.CapMetadataService:  true,
+	platform.CapCheckpoint:       true,
+	platform.CapDNSFixture:       true,
+})
+
 // NewCluster creates a Cluster instance, suitable for running virtual
 // machines in QEMU.
 func NewCluster(opts *Options, rconf *platform.RuntimeConfig) (platform.Cluster, error) {
-	lc, err := local.NewLocalCluster(opts.Options, rconf, Platform)
+	if err := rconf.MachineOptions.Check(Platform, platform.OptionMemory, platform.OptionCPUs, platform.OptionAdditionalDisks); err != nil {
+		return nil, err
+	}
+	if _, err := exec.LookPath("qemu-img"); err != nil {
+		return nil, fmt.Errorf("qemu-img, which creates the disks of qemu machines, is missing: %v", err)
+	}
+	base, err := resolveBaseImage(opts.DiskImage)
+	if err != nil {
+		return nil, err
+	}
+
+	lc, err := local.NewLocalCluster(opts.Options, rconf, Platform, opts.Subnet)
 	if err != nil {
 		return nil, err
 	}
 
 	qc := &Cluster{
 		opts:         opts,
+		base:         base,
 		LocalCluster: lc,
 	}
 
+	if opts.AdoptRun != "" {
+		image, err := identifyImage(opts.DiskImage)
+		if err != nil {
+			qc.Destroy()
+			return nil, err
+		}
+		qc.adoptable, err = findAdoptable(opts.AdoptRun, image)
+		if err != nil {
+			qc.Destroy()
+			return nil, err
+		}
+		plog.Infof("Found %d machines of run %v to adopt", len(qc.adoptable), opts.AdoptRun)
+	}
+
 	return qc, nil
 }
 
+func (qc *Cluster) Capabilities() platform.Capabilities {
+	return Capabilities
+}
+
 func (qc *Cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
-	return qc.NewMachineWithOptions(userdata, MachineOptions{})
+	o := qc.RuntimeConf().MachineOptions
+	return qc.NewMachineWithOptions(userdata, MachineOptions{
+		MemoryMiB:       o.MemoryMiB,
+		CPUs:            o.CPUs,
+		AdditionalDisks: o.AdditionalDisks,
+	})
 }
 
 func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options MachineOptions) (platform.Machine, error) {
+	if options.MemoryMiB < 0 || options.CPUs < 0 {
+		return nil, fmt.Errorf("negative memory or CPUs in machine options")
+	}
+	if qm := qc.adoptMachine(userdata, options); qm != nil {
+		return qm, nil
+	}
+
+	placement := Placement{CPUSet: options.CPUSet, NUMANode: options.NUMANode}
+	if placement.pinned() {
+		if err := placement.validate(sysfsSystem); err != nil {
+			return nil, err
+		}
+	}
+
 	id := uuid.NewV4()
 
 	dir := filepath.Join(qc.RuntimeConf().OutputDir, id.String())
@@ -108,16 +210,21 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 	qc.mu.Lock()
 	netif := qc.Dnsmasq.GetInterface("br0")
 	ip := strings.Split(netif.DHCPv4[0].String(), "/")[0]
+	name := qc.NextMachineName()
+	qc.Metadata.AddMachine(netif.DHCPv4[0].IP, netif.HardwareAddr, name)
 
 	conf, err := qc.RenderUserData(userdata, map[string]string{
+		"$name":         name,
 		"$public_ipv4":  ip,
 		"$private_ipv4": ip,
+		"$private_ipv6": netif.DHCPv6[0].IP.String(),
 	})
 	if err != nil {
 		qc.mu.Unlock()
 		return nil, err
 	}
 	qc.mu.Unlock()
+	qc.DNS.Configure(conf)
 
 	var confPath string
 	if conf.IsIgnition() {
@@ -132,7 +239,7 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 		}
 	}
 
-	journal, err := platform.NewJournal(dir)
+	journal, err := platform.NewJournal(dir, qc.RuntimeConf().Limits)
 	if err != nil {
 		return nil, err
 	}
@@ -140,12 +247,20 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 	qm := &machine{
 		qc:          qc,
 		id:          id.String(),
+		name:        name,
 		netif:       netif,
 		journal:     journal,
 		consolePath: filepath.Join(dir, "console.txt"),
+		// unix socket paths are limited to 108 bytes, which a path
+		// under the output directory may exceed
+		consoleSocket: filepath.Join(os.TempDir(), "kola-console-"+id.String()),
+		agent:         &guestAgent{path: filepath.Join(os.TempDir(), "kola-qga-"+id.String())},
+		qmp:           &monitor{path: qmpSocketPath(id.String())},
+		placement:     placement,
 	}
 
 	var qmCmd []string
+	var memory int // MiB
 	combo := runtime.GOARCH + "--" + qc.opts.Board
 	switch combo {
 	case "amd64--amd64-usr":
@@ -153,41 +268,66 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 			"qemu-system-x86_64",
 			"-machine", "accel=kvm",
 			"-cpu", "host",
-			"-m", "1024",
 		}
+		memory = 1024
 	case "amd64--arm64-usr":
 		qmCmd = []string{
 			"qemu-system-aarch64",
 			"-machine", "virt",
 			"-cpu", "cortex-a57",
-			"-m", "2048",
 		}
+		memory = 2048
 	case "arm64--amd64-usr":
 		qmCmd = []string{
 			"qemu-system-x86_64",
 			"-machine", "pc-q35-2.8",
 			"-cpu", "kvm64",
-			"-m", "1024",
 		}
+		memory = 1024
 	case "arm64--arm64-usr":
 		qmCmd = []string{
 			"qemu-system-aarch64",
 			"-machine", "virt,accel=kvm,gic-version=3",
 			"-cpu", "host",
-			"-m", "2048",
 		}
+		memory = 2048
 	default:
 		panic("host-guest combo not supported: " + combo)
 	}
+	if options.MemoryMiB != 0 {
+		memory = options.MemoryMiB
+	}
+	cpus := 1
+	if options.CPUs != 0 {
+		cpus = options.CPUs
+	}
+
+	qm.memory = memory
 
 	qmMac := qm.netif.HardwareAddr.String()
+	// The console goes through a FIFO so that console.txt is kept
+	// within the limit as it is written. Machines kept for adoption
+	// outlive this process, which would leave qemu blocked on a full
+	// FIFO, so they write console.txt directly.
+	consoleLogPath := qm.consolePath
+	if !qc.opts.KeepForAdoption {
+		consoleLogPath = filepath.Join(dir, "console.fifo")
+	}
 	qmCmd = append(qmCmd,
+		"-m", strconv.Itoa(memory),
 		"-bios", qc.opts.BIOSImage,
-		"-smp", "1",
+		"-smp", strconv.Itoa(cpus),
 		"-uuid", qm.id,
 		"-display", "none",
-		"-chardev", "file,id=log,path="+qm.consolePath,
+		// qemu writes everything to the log whether or not a client
+		// is attached to the socket through Console
+		"-chardev", "socket,id=log,server,nowait,path="+qm.consoleSocket+",logfile="+consoleLogPath,
 		"-serial", "chardev:log",
+		"-chardev", "socket,id=qga,server,nowait,path="+qm.agent.path,
+		"-device", qc.virtio("serial", "id=vserial"),
+		"-device", "virtserialport,bus=vserial.0,chardev=qga,name="+guestAgentName,
+		"-qmp", "unix:"+qm.qmp.path+",server,nowait",
+		"-device", qc.virtio("balloon", "id=balloon"),
 	)
 
 	if conf.IsIgnition() {
@@ -213,7 +353,7 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 		extraFiles = append(extraFiles, file)
 	}
 
-	diskFile, err := setupPrimaryDisk(qc.opts.DiskImage)
+	diskFile, err := setupPrimaryDisk(qc.base)
 	if err != nil {
 		return nil, err
 	}
@@ -242,26 +382,68 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 	fdnum += 1
 	extraFiles = append(extraFiles, tap.File)
 
+	if placement.pinned() {
+		qmCmd = placement.wrap(qmCmd)
+		if err := placement.write(dir); err != nil {
+			qc.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	qm.scope = qc.NewScope("qemu-" + id.String()[:8])
+	qmCmd = qm.scope.Wrap(qmCmd)
+
 	plog.Debugf("NewMachine: (%s) %q", combo, qmCmd)
 
 	qm.qemu = qm.qc.NewCommand(qmCmd[0], qmCmd[1:]...)
 
 	qc.mu.Unlock()
 
+	// qemu reports problems on stderr; log them as warnings
+	stderr, stderrWriter := io.Pipe()
+	qm.stderr = stderrWriter
+	go util.LogFrom(capnslog.WARNING, stderr)
+
 	cmd := qm.qemu.(*ns.Cmd)
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = stderrWriter
 
 	cmd.ExtraFiles = append(cmd.ExtraFiles, extraFiles...)
 
+	// Put qemu and anything it forks in a process group of their own
+	// so Destroy can kill all of them, if there is no scope to kill or
+	// killing it fails.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if consoleLogPath != qm.consolePath {
+		qm.consoleLog, err = openConsoleLog(consoleLogPath, qm.consolePath, qc.RuntimeConf().Limits)
+		if err != nil {
+			stderrWriter.Close()
+			return nil, err
+		}
+	}
+
 	if err = qm.qemu.Start(); err != nil {
+		stderrWriter.Close()
+		if qm.consoleLog != nil {
+			qm.consoleLog.Close()
+		}
 		return nil, err
 	}
+	qm.pid = cmd.Process.Pid
+	qm.exited = make(chan struct{})
+	go qm.watch()
 
 	if err := platform.StartMachine(qm, qm.journal); err != nil {
 		qm.Destroy()
 		return nil, err
 	}
 
+	if plainOptions(options) {
+		if err := qc.writeAdoptRecord(qm, dir, userdata); err != nil {
+			plog.Warningf("Recording machine %v for adoption: %v", qm.ID(), err)
+		}
+	}
+
 	qc.AddMach(qm)
 
 	return qm, nil
@@ -282,23 +464,19 @@ func (qc *Cluster) virtio(device, args string) string {
 	return fmt.Sprintf("virtio-%s-%s,%s", device, suffix, args)
 }
 
-// Create a nameless temporary qcow2 image file backed by a raw image.
-func setupPrimaryDisk(imageFile string) (*os.File, error) {
-	// a relative path would be interpreted relative to /tmp
-	backingFile, err := filepath.Abs(imageFile)
-	if err != nil {
-		return nil, err
-	}
-	// keep the COW image from breaking if the "latest" symlink changes
-	backingFile, err = filepath.EvalSymlinks(backingFile)
-	if err != nil {
-		return nil, err
-	}
-
-	qcowOpts := fmt.Sprintf("backing_file=%s,backing_fmt=raw,lazy_refcounts=on", backingFile)
+// setupPrimaryDisk creates the disk a machine boots from, a qcow2
+// overlay of base, so machines share the image without being able to
+// change it or each other's disks.
+func setupPrimaryDisk(base baseImage) (*os.File, error) {
+	// the backing file is never written, only the overlay
+	qcowOpts := fmt.Sprintf("backing_file=%s,backing_fmt=%s,lazy_refcounts=on", base.path, base.format)
 	return setupDisk("-o", qcowOpts)
 }
 
+// setupDisk creates a qcow2 image with qemu-img and returns it open. The
+// file is nameless: it is removed as soon as it is open, and its space
+// is freed when qemu, which gets the open file, exits, however the
+// machine or kola ends.
 func setupDisk(additionalOptions ...string) (*os.File, error) {
 	dstFile, err := ioutil.TempFile("", "mantle-qemu")
 	if err != nil {
@@ -312,10 +490,8 @@ func setupDisk(additionalOptions ...string) (*os.File, error) {
 	opts = append(opts, additionalOptions...)
 
 	qemuImg := exec.Command("qemu-img", opts...)
-	qemuImg.Stderr = os.Stderr
-
-	if err := qemuImg.Run(); err != nil {
-		return nil, err
+	if out, err := qemuImg.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("qemu-img: %v: %s", err, out)
 	}
 
 	return os.OpenFile(dstFileName, os.O_RDWR, 0)
@@ -0,0 +1,84 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/util"
+)
+
+// consoleDrainTimeout is how long Close waits for more output once the
+// FIFO is empty.
+const consoleDrainTimeout = 100 * time.Millisecond
+
+// consoleLog copies the console output qemu writes to a FIFO into a file,
+// keeping the file within the console limit while the machine runs
+// rather than trimming it once the machine is gone.
+type consoleLog struct {
+	path string
+	fifo *os.File
+	w    *util.HeadTailWriter
+	done chan struct{}
+}
+
+// openConsoleLog starts copying the FIFO at fifoPath, created if missing,
+// to path. qemu is given fifoPath as its console log.
+func openConsoleLog(fifoPath, path string, limits platform.ArtifactLimits) (*consoleLog, error) {
+	if err := syscall.Mkfifo(fifoPath, 0600); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	// opened for writing too, so that opening doesn't wait for qemu
+	// and reading doesn't end when a qemu exits
+	fifo, err := os.OpenFile(fifoPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		fifo.Close()
+		return nil, err
+	}
+	w := util.NewHeadTailWriter(f, limits.Console)
+	w.Truncated = func(dropped int64) {
+		limits.ReportTruncated(path, dropped)
+	}
+
+	c := &consoleLog{path: fifoPath, fifo: fifo, w: w, done: make(chan struct{})}
+	go func() {
+		io.Copy(w, fifo)
+		close(c.done)
+	}()
+	return c, nil
+}
+
+// Close completes the file with what is left in the FIFO, once qemu is
+// gone, stops copying and removes the FIFO.
+func (c *consoleLog) Close() error {
+	if err := c.fifo.SetReadDeadline(time.Now().Add(consoleDrainTimeout)); err != nil {
+		c.fifo.Close()
+	}
+	<-c.done
+	c.fifo.Close()
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		c.w.Close()
+		return err
+	}
+	return c.w.Close()
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/platform"
+)
+
+func TestConsoleLogLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fifoPath := filepath.Join(dir, "console.fifo")
+	path := filepath.Join(dir, "console.txt")
+	var reported string
+	var dropped int64
+	limits := platform.ArtifactLimits{
+		Console: 8,
+		Truncated: func(artifact string, n int64) {
+			reported, dropped = artifact, n
+		},
+	}
+	c, err := openConsoleLog(fifoPath, path, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// written the way qemu writes its log, through a separate open
+	qemu, err := os.OpenFile(fifoPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qemu.WriteString("boot" + strings.Repeat("x", 100) + "done"); err != nil {
+		t.Fatal(err)
+	}
+	qemu.Close()
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(buf), "boot") || !strings.HasSuffix(string(buf), "done") {
+		t.Errorf("console %q does not keep its head and tail", buf)
+	}
+	if reported != path || dropped != 100 {
+		t.Errorf("reported %d bytes dropped from %q, expected 100 from %q", dropped, reported, path)
+	}
+	if _, err := os.Stat(fifoPath); !os.IsNotExist(err) {
+		t.Errorf("FIFO was not removed: %v", err)
+	}
+}
@@ -40,10 +40,8 @@ func MakeDiskTemplate(inputPath, outputPath string) (result error) {
 	cp := exec.Command("cp", "--force",
 		"--sparse=always", "--reflink=auto",
 		inputPath, outputPath)
-	cp.Stdout = os.Stdout
-	cp.Stderr = os.Stderr
-	if err := cp.Run(); err != nil {
-		return fmt.Errorf("copying file: %v", err)
+	if out, err := cp.CombinedOutput(); err != nil {
+		return fmt.Errorf("copying file: %v: %s", err, out)
 	}
 	defer func() {
 		if result != nil {
@@ -119,5 +117,11 @@ func MakeDiskTemplate(inputPath, outputPath string) (result error) {
 		return fmt.Errorf("writing grub.cfg: %v", err)
 	}
 
+	// log in automatically on the serial console for tests using
+	// platform.Console
+	if _, err = f.WriteString("set linux_append=\"$linux_append coreos.autologin=ttyS0\"\n"); err != nil {
+		return fmt.Errorf("writing grub.cfg: %v", err)
+	}
+
 	return
 }
@@ -0,0 +1,220 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+)
+
+const (
+	guestAgentName     = "org.qemu.guest_agent.0"
+	guestAgentTimeout  = 10 * time.Second
+	guestExecTimeout   = 5 * time.Minute
+	guestExecPoll      = 500 * time.Millisecond
+	guestFileReadChunk = 1 << 20
+)
+
+// guestAgent talks to qemu-guest-agent over the virtio-serial channel
+// qemu exposes as a unix socket. The agent speaks the QMP wire protocol.
+type guestAgent struct {
+	path string
+	mu   sync.Mutex // the channel carries one conversation at a time
+}
+
+type qgaRequest struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type qgaResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+// call runs a single agent command, decoding its return value into ret.
+// It returns platform.ErrGuestAgentNotAvailable if the agent does not
+// answer.
+func (g *guestAgent) call(execute string, args, ret interface{}) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	conn, err := net.DialTimeout("unix", g.path, guestAgentTimeout)
+	if err != nil {
+		plog.Debugf("connecting to guest agent: %v", err)
+		return platform.ErrGuestAgentNotAvailable
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(r)
+
+	// Discard anything left in the channel by an earlier conversation.
+	// A 0xff byte resets the agent's parser, and the agent answers
+	// guest-sync-delimited with a 0xff byte before its response.
+	conn.SetDeadline(time.Now().Add(guestAgentTimeout))
+	id := rand.Int63()
+	if _, err := conn.Write([]byte{0xff}); err != nil {
+		return err
+	}
+	if err := enc.Encode(qgaRequest{
+		Execute:   "guest-sync-delimited",
+		Arguments: map[string]int64{"id": id},
+	}); err != nil {
+		return err
+	}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			plog.Debugf("synchronizing with guest agent: %v", err)
+			return platform.ErrGuestAgentNotAvailable
+		}
+		if b == 0xff {
+			break
+		}
+	}
+	var synced int64
+	if err := decodeResponse(dec, &synced); err != nil {
+		return err
+	}
+	if synced != id {
+		return fmt.Errorf("guest agent returned sync id %d, expected %d", synced, id)
+	}
+
+	conn.SetDeadline(time.Now().Add(guestAgentTimeout))
+	if err := enc.Encode(qgaRequest{Execute: execute, Arguments: args}); err != nil {
+		return err
+	}
+	return decodeResponse(dec, ret)
+}
+
+func decodeResponse(dec *json.Decoder, ret interface{}) error {
+	var resp qgaResponse
+	if err := dec.Decode(&resp); err != nil {
+		return fmt.Errorf("decoding guest agent response: %v", err)
+	}
+	if resp.Error != nil {
+		if resp.Error.Class == "CommandNotFound" {
+			return platform.ErrGuestAgentNotAvailable
+		}
+		return fmt.Errorf("guest agent: %s: %s", resp.Error.Class, resp.Error.Desc)
+	}
+	if ret == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Return, ret)
+}
+
+// Exec runs cmd with /bin/sh and waits for it to exit.
+func (g *guestAgent) Exec(cmd string) ([]byte, []byte, error) {
+	var started struct {
+		PID int `json:"pid"`
+	}
+	if err := g.call("guest-exec", map[string]interface{}{
+		"path":           "/bin/sh",
+		"arg":            []string{"-c", cmd},
+		"capture-output": true,
+	}, &started); err != nil {
+		return nil, nil, err
+	}
+
+	deadline := time.Now().Add(guestExecTimeout)
+	for {
+		var status struct {
+			Exited   bool   `json:"exited"`
+			ExitCode int    `json:"exitcode"`
+			Signal   int    `json:"signal"`
+			OutData  string `json:"out-data"`
+			ErrData  string `json:"err-data"`
+		}
+		if err := g.call("guest-exec-status", map[string]int{"pid": started.PID}, &status); err != nil {
+			return nil, nil, err
+		}
+		if !status.Exited {
+			if time.Now().After(deadline) {
+				return nil, nil, fmt.Errorf("%q did not exit within %v", cmd, guestExecTimeout)
+			}
+			time.Sleep(guestExecPoll)
+			continue
+		}
+
+		stdout, err := base64.StdEncoding.DecodeString(status.OutData)
+		if err != nil {
+			return nil, nil, err
+		}
+		stderr, err := base64.StdEncoding.DecodeString(status.ErrData)
+		if err != nil {
+			return nil, nil, err
+		}
+		if status.Signal != 0 {
+			return stdout, stderr, fmt.Errorf("%q killed by signal %d", cmd, status.Signal)
+		}
+		if status.ExitCode != 0 {
+			return stdout, stderr, fmt.Errorf("%q exited with status %d", cmd, status.ExitCode)
+		}
+		return stdout, stderr, nil
+	}
+}
+
+// ReadFile returns the contents of path.
+func (g *guestAgent) ReadFile(path string) ([]byte, error) {
+	var handle int
+	if err := g.call("guest-file-open", map[string]string{
+		"path": path,
+		"mode": "r",
+	}, &handle); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := g.call("guest-file-close", map[string]int{"handle": handle}, nil); err != nil {
+			plog.Errorf("closing %s through guest agent: %v", path, err)
+		}
+	}()
+
+	var data []byte
+	for {
+		var chunk struct {
+			Count  int    `json:"count"`
+			BufB64 string `json:"buf-b64"`
+			EOF    bool   `json:"eof"`
+		}
+		if err := g.call("guest-file-read", map[string]int{
+			"handle": handle,
+			"count":  guestFileReadChunk,
+		}, &chunk); err != nil {
+			return nil, err
+		}
+		buf, err := base64.StdEncoding.DecodeString(chunk.BufB64)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, buf...)
+		if chunk.EOF || chunk.Count == 0 {
+			return data, nil
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// fakeAgent answers the guest agent protocol on a unix socket. Each
+// connection must synchronize before its command, which is answered by
+// commands.
+type fakeAgent struct {
+	t        *testing.T
+	l        net.Listener
+	commands map[string]func(args json.RawMessage) interface{}
+}
+
+func newFakeAgent(t *testing.T, dir string) *fakeAgent {
+	l, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &fakeAgent{t: t, l: l, commands: map[string]func(json.RawMessage) interface{}{}}
+	go a.serve()
+	return a
+}
+
+func (a *fakeAgent) serve() {
+	for {
+		conn, err := a.l.Accept()
+		if err != nil {
+			return
+		}
+		a.converse(conn)
+		conn.Close()
+	}
+}
+
+func (a *fakeAgent) converse(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	if b, err := r.ReadByte(); err != nil || b != 0xff {
+		a.t.Errorf("conversation began with %x, %v; want the 0xff reset", b, err)
+		return
+	}
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(conn)
+
+	var sync struct {
+		Execute   string
+		Arguments struct{ ID int64 }
+	}
+	if err := dec.Decode(&sync); err != nil || sync.Execute != "guest-sync-delimited" {
+		a.t.Errorf("conversation began with %q, %v; want a sync", sync.Execute, err)
+		return
+	}
+	// stale output of an earlier conversation precedes the delimiter
+	conn.Write([]byte(`{"return": {}}` + "\xff"))
+	enc.Encode(map[string]interface{}{"return": sync.Arguments.ID})
+
+	var req struct {
+		Execute   string
+		Arguments json.RawMessage
+	}
+	if err := dec.Decode(&req); err != nil {
+		a.t.Errorf("reading command: %v", err)
+		return
+	}
+	f, ok := a.commands[req.Execute]
+	if !ok {
+		enc.Encode(map[string]interface{}{"error": map[string]string{"class": "CommandNotFound", "desc": req.Execute}})
+		return
+	}
+	ret := f(req.Arguments)
+	if err, ok := ret.(error); ok {
+		enc.Encode(map[string]interface{}{"error": map[string]string{"class": "GenericError", "desc": err.Error()}})
+		return
+	}
+	enc.Encode(map[string]interface{}{"return": ret})
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestGuestAgentExec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qemu-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := newFakeAgent(t, dir)
+	defer a.l.Close()
+
+	a.commands["guest-exec"] = func(args json.RawMessage) interface{} {
+		var exec struct {
+			Path          string
+			Arg           []string
+			CaptureOutput bool `json:"capture-output"`
+		}
+		json.Unmarshal(args, &exec)
+		if exec.Path != "/bin/sh" || len(exec.Arg) != 2 || exec.Arg[0] != "-c" || !exec.CaptureOutput {
+			t.Errorf("guest-exec of %+v", exec)
+		}
+		return map[string]int{"pid": map[string]int{"true": 1, "false": 2}[exec.Arg[1]]}
+	}
+	a.commands["guest-exec-status"] = func(args json.RawMessage) interface{} {
+		var status struct{ PID int }
+		json.Unmarshal(args, &status)
+		return map[string]interface{}{
+			"exited":   true,
+			"exitcode": status.PID - 1,
+			"out-data": b64("out"),
+			"err-data": b64("err"),
+		}
+	}
+	g := &guestAgent{path: a.l.Addr().String()}
+
+	stdout, stderr, err := g.Exec("true")
+	if err != nil || string(stdout) != "out" || string(stderr) != "err" {
+		t.Errorf("Exec returned %q, %q, %v", stdout, stderr, err)
+	}
+	if _, _, err := g.Exec("false"); err == nil || !strings.Contains(err.Error(), "exited with status 1") {
+		t.Errorf("failed command returned %v", err)
+	}
+
+	// an agent without the command, or none at all, is not available
+	delete(a.commands, "guest-exec")
+	if _, _, err := g.Exec("true"); err != platform.ErrGuestAgentNotAvailable {
+		t.Errorf("agent without guest-exec returned %v", err)
+	}
+	g = &guestAgent{path: filepath.Join(dir, "missing.sock")}
+	if _, _, err := g.Exec("true"); err != platform.ErrGuestAgentNotAvailable {
+		t.Errorf("missing agent returned %v", err)
+	}
+}
+
+func TestGuestAgentReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qemu-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := newFakeAgent(t, dir)
+	defer a.l.Close()
+
+	chunks := []string{"hello, ", "world"}
+	var closed bool
+	a.commands["guest-file-open"] = func(args json.RawMessage) interface{} {
+		var open struct{ Path, Mode string }
+		json.Unmarshal(args, &open)
+		if open.Path != "/etc/motd" || open.Mode != "r" {
+			t.Errorf("opened %+v", open)
+		}
+		return 7
+	}
+	a.commands["guest-file-read"] = func(args json.RawMessage) interface{} {
+		var read struct{ Handle, Count int }
+		json.Unmarshal(args, &read)
+		if read.Handle != 7 || read.Count != guestFileReadChunk {
+			t.Errorf("read %+v", read)
+		}
+		chunk := chunks[0]
+		chunks = chunks[1:]
+		return map[string]interface{}{"count": len(chunk), "buf-b64": b64(chunk), "eof": len(chunks) == 0}
+	}
+	a.commands["guest-file-close"] = func(args json.RawMessage) interface{} {
+		closed = true
+		return struct{}{}
+	}
+	g := &guestAgent{path: a.l.Addr().String()}
+
+	data, err := g.ReadFile("/etc/motd")
+	if err != nil || string(data) != "hello, world" {
+		t.Errorf("ReadFile returned %q, %v", data, err)
+	}
+	if !closed {
+		t.Error("file not closed")
+	}
+
+	a.commands["guest-file-open"] = func(json.RawMessage) interface{} {
+		return os.ErrNotExist
+	}
+	if _, err := g.ReadFile("/missing"); err == nil || !strings.Contains(err.Error(), "GenericError") {
+		t.Errorf("reading a missing file returned %v", err)
+	}
+}
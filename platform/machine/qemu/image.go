@@ -0,0 +1,103 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// buildImages are the disk images looked for in a build directory, in
+// order of preference: the raw images image_to_vm.sh starts from, then
+// the packaged qemu image.
+var buildImages = []string{
+	"coreos_production_image.bin",
+	"coreos_developer_image.bin",
+	"coreos_production_qemu_image.img",
+}
+
+// qcow2Magic starts every qcow2 image.
+var qcow2Magic = []byte("QFI\xfb")
+
+// ResolveDiskImage returns the disk image to boot for path, which may be
+// an image or an unpacked build directory of the SDK, such as
+// images/amd64-usr/latest, holding one. Images are only ever read, as
+// the backing files of the machines' disks, so runs may share a build
+// directory. A path which doesn't exist is returned as is.
+func ResolveDiskImage(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.IsDir() {
+		return path, nil
+	}
+	for _, name := range buildImages {
+		image := filepath.Join(path, name)
+		if fi, err := os.Stat(image); err == nil && fi.Mode().IsRegular() {
+			return image, nil
+		}
+	}
+	return "", fmt.Errorf("no disk image in build directory %s; looked for %s", path, strings.Join(buildImages, ", "))
+}
+
+// baseImage is the disk image backing the primary disks of a cluster's
+// machines.
+type baseImage struct {
+	path   string // absolute, without symlinks
+	format string // as imageFormat returns it
+}
+
+// resolveBaseImage resolves the disk image at path once for a cluster,
+// so that its machines keep booting the same image if a symlink such as
+// a build directory's "latest" changes during the run.
+func resolveBaseImage(path string) (baseImage, error) {
+	if path == "" {
+		return baseImage{}, fmt.Errorf("no disk image given to boot")
+	}
+	// a relative path would be interpreted relative to the overlay
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return baseImage{}, err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return baseImage{}, fmt.Errorf("disk image %s: %v", path, err)
+	}
+	format, err := imageFormat(abs)
+	if err != nil {
+		return baseImage{}, fmt.Errorf("disk image %s: %v", path, err)
+	}
+	return baseImage{path: abs, format: format}, nil
+}
+
+// imageFormat returns the qemu format of the disk image at path, raw or
+// qcow2.
+func imageFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(qcow2Magic))
+	if _, err := io.ReadFull(f, magic); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("reading %s: %v", path, err)
+	}
+	if bytes.Equal(magic, qcow2Magic) {
+		return "qcow2", nil
+	}
+	return "raw", nil
+}
@@ -15,29 +15,69 @@
 package qemu
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
 	"github.com/coreos/mantle/platform"
 	"github.com/coreos/mantle/platform/local"
 	"github.com/coreos/mantle/system/exec"
+	"github.com/coreos/mantle/util"
 )
 
 type machine struct {
-	qc          *Cluster
-	id          string
-	qemu        exec.Cmd
-	netif       *local.Interface
-	journal     *platform.Journal
-	consolePath string
-	console     string
+	qc            *Cluster
+	id            string
+	name          string // canonical, from NextMachineName
+	qemu          exec.Cmd
+	pid           int           // of qemu, which leads its process group
+	exited        chan struct{} // closed by watch when qemu has exited
+	destroying    int32         // set by Destroy so watch expects the exit
+	stderr        io.Closer
+	netif         *local.Interface
+	journal       *platform.Journal
+	consolePath   string
+	consoleLog    *consoleLog // nil if qemu writes consolePath itself
+	consoleSocket string
+	console       string
+	agent         *guestAgent
+	qmp           *monitor
+	memory        int // MiB at boot
+	placement     Placement
+	scope         local.Scope // zero if adopted or without systemd
+	adoption      *adoption   // set if adopted from an earlier run
+
+	consoleMu   sync.Mutex
+	consoleOpen bool
 }
 
 func (m *machine) ID() string {
 	return m.id
 }
 
+// Name returns the machine's canonical name; its ID is the UUID qemu
+// knows it by.
+func (m *machine) Name() string {
+	return m.name
+}
+
+func (m *machine) Pid() int {
+	return m.pid
+}
+
+// Placement returns where the machine is pinned on the host.
+func (m *machine) Placement() Placement {
+	return m.placement
+}
+
 func (m *machine) IP() string {
 	return m.netif.DHCPv4[0].IP.String()
 }
@@ -51,10 +91,16 @@ func (m *machine) RuntimeConf() platform.RuntimeConfig {
 }
 
 func (m *machine) SSHClient() (*ssh.Client, error) {
+	if m.adoption != nil {
+		return m.adoption.agent.NewClient(m.IP())
+	}
 	return m.qc.SSHClient(m.IP())
 }
 
 func (m *machine) PasswordSSHClient(user string, password string) (*ssh.Client, error) {
+	if m.adoption != nil {
+		return m.adoption.agent.NewPasswordClient(m.IP(), user, password)
+	}
 	return m.qc.PasswordSSHClient(m.IP(), user, password)
 }
 
@@ -66,12 +112,148 @@ func (m *machine) Reboot() error {
 	return platform.RebootMachine(m, m.journal)
 }
 
+// GuestExec runs cmd through qemu-guest-agent, if the image provides it.
+func (m *machine) GuestExec(cmd string) ([]byte, []byte, error) {
+	return m.agent.Exec(cmd)
+}
+
+// GuestFileRead reads path through qemu-guest-agent, if the image
+// provides it.
+func (m *machine) GuestFileRead(path string) ([]byte, error) {
+	return m.agent.ReadFile(path)
+}
+
+// DiagnoseBoot checks the machine's network from inside the cluster and
+// looks for failures on the console so far.
+func (m *machine) DiagnoseBoot() []string {
+	findings := m.qc.DiagnoseNetwork(m.netif.HardwareAddr, m.IP())
+	console, err := ioutil.ReadFile(m.consolePath)
+	if err != nil {
+		return append(findings, fmt.Sprintf("reading console: %v", err))
+	}
+	return append(findings, platform.ConsoleFindings(string(console))...)
+}
+
+// consoleConn releases the machine's console when closed.
+type consoleConn struct {
+	net.Conn
+	m    *machine
+	once sync.Once
+}
+
+func (c *consoleConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.m.consoleMu.Lock()
+		c.m.consoleOpen = false
+		c.m.consoleMu.Unlock()
+	})
+	return err
+}
+
+// Console attaches to the serial console. qemu keeps writing the output
+// to console.txt while the stream is open.
+func (m *machine) Console() (io.ReadWriteCloser, error) {
+	m.consoleMu.Lock()
+	defer m.consoleMu.Unlock()
+	if m.consoleOpen {
+		return nil, platform.ErrConsoleInUse
+	}
+	conn, err := net.Dial("unix", m.consoleSocket)
+	if err != nil {
+		return nil, err
+	}
+	m.consoleOpen = true
+	return &consoleConn{Conn: conn, m: m}, nil
+}
+
+// collectJournalFromAgent saves the journal through the guest agent if the
+// streamed journal stopped, as it does when the machine's network does.
+func (m *machine) collectJournalFromAgent() {
+	if !m.journal.Stopped() {
+		return
+	}
+
+	out, _, err := m.GuestExec("journalctl --no-pager --boot")
+	if err == platform.ErrGuestAgentNotAvailable {
+		plog.Debugf("Journal stream of %v stopped and no guest agent is available", m.ID())
+		return
+	} else if err != nil {
+		plog.Errorf("Error reading journal of %v through guest agent: %v", m.ID(), err)
+		return
+	}
+
+	limits := m.qc.RuntimeConf().Limits
+	journal, dropped := util.TruncateString(string(out), limits.Journal)
+	if dropped > 0 {
+		limits.ReportTruncated("journal-agent.txt", dropped)
+	}
+	path := filepath.Join(filepath.Dir(m.consolePath), "journal-agent.txt")
+	if err := ioutil.WriteFile(path, []byte(journal), 0666); err != nil {
+		plog.Errorf("Error saving journal of %v: %v", m.ID(), err)
+	}
+}
+
 func (m *machine) Destroy() {
-	if err := m.qemu.Kill(); err != nil {
+	m.collectJournalFromAgent()
+	m.kill()
+}
+
+// Kill stops qemu at once, without first saving the journal through the
+// guest agent. qemu is always killed, so the guest never shuts down.
+func (m *machine) Kill() {
+	m.kill()
+}
+
+func (m *machine) kill() {
+	atomic.StoreInt32(&m.destroying, 1)
+	if err := m.scope.Kill(); err != nil {
+		plog.Errorf("Error killing scope of instance %v: %v", m.ID(), err)
+	}
+	if err := killGroup(m.pid); err != nil {
 		plog.Errorf("Error killing instance %v: %v", m.ID(), err)
 	}
+	select {
+	case <-m.exited:
+	case <-time.After(ProcessExitTimeout):
+	}
+	if m.stderr != nil {
+		m.stderr.Close()
+	}
+	if err := waitGone(m.pid, m.qmp.path, ProcessExitTimeout); err != nil {
+		m.qc.MachineLeaked(m.ID(), err)
+	}
 
 	m.journal.Destroy()
+	if m.adoption != nil {
+		m.adoption.close()
+	}
+
+	if err := os.Remove(m.agent.path); err != nil && !os.IsNotExist(err) {
+		plog.Errorf("Error removing guest agent socket of %v: %v", m.ID(), err)
+	}
+	if err := os.Remove(m.qmp.path); err != nil && !os.IsNotExist(err) {
+		plog.Errorf("Error removing QMP socket of %v: %v", m.ID(), err)
+	}
+	if err := os.Remove(m.consoleSocket); err != nil && !os.IsNotExist(err) {
+		plog.Errorf("Error removing console socket of %v: %v", m.ID(), err)
+	}
+	if err := os.Remove(adoptKeyPath(m.ID())); err != nil && !os.IsNotExist(err) {
+		plog.Errorf("Error removing adoption key of %v: %v", m.ID(), err)
+	}
+
+	if m.consoleLog != nil {
+		if err := m.consoleLog.Close(); err != nil {
+			plog.Errorf("Error completing console for instance %v: %v", m.ID(), err)
+		}
+	} else {
+		limits := m.qc.RuntimeConf().Limits
+		if dropped, err := util.TruncateFile(m.consolePath, limits.Console); err != nil {
+			plog.Errorf("Error truncating console for instance %v: %v", m.ID(), err)
+		} else if dropped > 0 {
+			limits.ReportTruncated(m.consolePath, dropped)
+		}
+	}
 
 	if buf, err := ioutil.ReadFile(m.consolePath); err == nil {
 		m.console = string(buf)
@@ -82,6 +264,33 @@ func (m *machine) Destroy() {
 	m.qc.DelMach(m)
 }
 
+// watch waits for qemu to exit. An exit which Destroy did not cause
+// means the machine died.
+func (m *machine) watch() {
+	defer close(m.exited)
+	var err error
+	if m.qemu != nil {
+		err = m.qemu.Wait()
+	} else {
+		// an adopted machine's qemu is not our child
+		for {
+			if _, err := readProcess(m.pid); err != nil {
+				break
+			}
+			time.Sleep(processPollInterval)
+		}
+	}
+	if atomic.LoadInt32(&m.destroying) == 1 {
+		return
+	}
+	detail := "qemu exited"
+	if err != nil {
+		detail = fmt.Sprintf("qemu exited: %v", err)
+	}
+	plog.Warningf("Machine %v died: %s", m.ID(), detail)
+	m.qc.EmitEvent(m.ID(), platform.MachineDied, detail)
+}
+
 func (m *machine) ConsoleOutput() string {
 	return m.console
 }
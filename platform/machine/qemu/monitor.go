@@ -0,0 +1,150 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	monitorTimeout = 10 * time.Second
+	balloonTimeout = time.Minute
+	balloonPoll    = 500 * time.Millisecond
+)
+
+// monitor talks to qemu itself over its QMP socket.
+type monitor struct {
+	path string
+	mu   sync.Mutex // qemu serves one QMP client at a time
+}
+
+// qmpResponse is any message from qemu; asynchronous events are
+// interleaved with command responses.
+type qmpResponse struct {
+	qgaResponse
+	Event string `json:"event"`
+}
+
+// call runs a single QMP command, decoding its return value into ret.
+func (q *monitor) call(execute string, args, ret interface{}) error {
+	return q.callTimeout(execute, args, ret, monitorTimeout)
+}
+
+// callTimeout is like call, but allows the command to run for timeout
+// rather than monitorTimeout.
+func (q *monitor) callTimeout(execute string, args, ret interface{}, timeout time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	conn, err := net.DialTimeout("unix", q.path, monitorTimeout)
+	if err != nil {
+		return fmt.Errorf("connecting to QMP: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+
+	// qemu greets each client and refuses commands until capabilities
+	// are negotiated.
+	var greeting json.RawMessage
+	if err := dec.Decode(&greeting); err != nil {
+		return fmt.Errorf("reading QMP greeting: %v", err)
+	}
+	if err := enc.Encode(qgaRequest{Execute: "qmp_capabilities"}); err != nil {
+		return err
+	}
+	if err := readQMP(dec, nil); err != nil {
+		return err
+	}
+
+	if err := enc.Encode(qgaRequest{Execute: execute, Arguments: args}); err != nil {
+		return err
+	}
+	return readQMP(dec, ret)
+}
+
+// readQMP decodes the next command response, skipping events.
+func readQMP(dec *json.Decoder, ret interface{}) error {
+	for {
+		var resp qmpResponse
+		if err := dec.Decode(&resp); err != nil {
+			return fmt.Errorf("decoding QMP response: %v", err)
+		}
+		if resp.Event != "" {
+			continue
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("QMP: %s: %s", resp.Error.Class, resp.Error.Desc)
+		}
+		if ret == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Return, ret)
+	}
+}
+
+// balloonActual returns the memory currently available to the guest in
+// bytes.
+func (q *monitor) balloonActual() (int64, error) {
+	var info struct {
+		Actual int64 `json:"actual"`
+	}
+	if err := q.call("query-balloon", nil, &info); err != nil {
+		return 0, err
+	}
+	return info.Actual, nil
+}
+
+// SetMemory inflates or deflates the balloon so that the guest has
+// targetMiB, waiting for the guest's balloon driver to comply.
+func (m *machine) SetMemory(targetMiB int) error {
+	if targetMiB > m.memory {
+		return fmt.Errorf("cannot balloon %s to %d MiB, above the %d MiB it booted with", m.ID(), targetMiB, m.memory)
+	}
+	target := int64(targetMiB) << 20
+	if err := m.qmp.call("balloon", map[string]int64{"value": target}, nil); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(balloonTimeout)
+	for {
+		actual, err := m.qmp.balloonActual()
+		if err != nil {
+			return err
+		}
+		if actual == target {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s still has %d MiB after %v, expected %d MiB", m.ID(), actual>>20, balloonTimeout, targetMiB)
+		}
+		time.Sleep(balloonPoll)
+	}
+}
+
+// Memory returns the MiB currently available to the guest.
+func (m *machine) Memory() (int, error) {
+	actual, err := m.qmp.balloonActual()
+	if err != nil {
+		return 0, err
+	}
+	return int(actual >> 20), nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const sysfsSystem = "/sys/devices/system"
+
+// Placement is where on the host a machine's CPUs and memory are pinned.
+// It is written to placement.json in the machine's output directory so
+// that benchmark results can be grouped by placement.
+type Placement struct {
+	CPUSet   string `json:"cpuset,omitempty"`
+	NUMANode *int   `json:"numa_node,omitempty"`
+}
+
+// pinned reports whether any pinning was requested.
+func (p Placement) pinned() bool {
+	return p.CPUSet != "" || p.NUMANode != nil
+}
+
+// validate checks the placement against the host topology in sysfs, read
+// from the system directory at root, normally sysfsSystem.
+func (p Placement) validate(root string) error {
+	online, err := readCPUList(filepath.Join(root, "cpu", "online"))
+	if err != nil {
+		return err
+	}
+
+	var cpus []int
+	if p.CPUSet != "" {
+		if cpus, err = parseCPUList(p.CPUSet); err != nil {
+			return fmt.Errorf("invalid cpuset %q: %v", p.CPUSet, err)
+		}
+		if cpu, ok := subset(cpus, online); !ok {
+			return fmt.Errorf("cpuset %q: CPU %d is not online", p.CPUSet, cpu)
+		}
+	}
+
+	if p.NUMANode != nil {
+		nodeCPUs, err := readCPUList(filepath.Join(root, "node", fmt.Sprintf("node%d", *p.NUMANode), "cpulist"))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("NUMA node %d does not exist", *p.NUMANode)
+		} else if err != nil {
+			return err
+		}
+		if cpu, ok := subset(cpus, nodeCPUs); !ok {
+			return fmt.Errorf("cpuset %q: CPU %d is not on NUMA node %d", p.CPUSet, cpu, *p.NUMANode)
+		}
+	}
+	return nil
+}
+
+// wrap prefixes the qemu command line with taskset or numactl.
+// Both exec qemu, so the machine's process is still qemu itself.
+func (p Placement) wrap(cmd []string) []string {
+	var wrapper []string
+	switch {
+	case p.NUMANode != nil && p.CPUSet != "":
+		wrapper = []string{"numactl", fmt.Sprintf("--membind=%d", *p.NUMANode), "--physcpubind=" + p.CPUSet}
+	case p.NUMANode != nil:
+		wrapper = []string{"numactl", fmt.Sprintf("--membind=%d", *p.NUMANode), fmt.Sprintf("--cpunodebind=%d", *p.NUMANode)}
+	case p.CPUSet != "":
+		wrapper = []string{"taskset", "-c", p.CPUSet}
+	}
+	return append(wrapper, cmd...)
+}
+
+func (p Placement) write(dir string) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "placement.json"), b, 0666)
+}
+
+func readCPUList(path string) ([]int, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cpus, err := parseCPUList(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return cpus, nil
+}
+
+// parseCPUList parses the kernel's list format, e.g. "0-3,8,10-11".
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	if s == "" {
+		return cpus, nil
+	}
+	for _, r := range strings.Split(s, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		if first < 0 || last < first {
+			return nil, fmt.Errorf("invalid range %q", r)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// subset returns the first of cpus not in set, if any.
+func subset(cpus, set []int) (int, bool) {
+	have := make(map[int]bool, len(set))
+	for _, cpu := range set {
+		have[cpu] = true
+	}
+	for _, cpu := range cpus {
+		if !have[cpu] {
+			return cpu, false
+		}
+	}
+	return 0, true
+}
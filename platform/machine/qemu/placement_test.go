@@ -0,0 +1,141 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		cpus []int
+		err  bool
+	}{
+		{"", nil, false},
+		{"0", []int{0}, false},
+		{"0-3", []int{0, 1, 2, 3}, false},
+		{"0-1,8,10-11", []int{0, 1, 8, 10, 11}, false},
+		{"2-2", []int{2}, false},
+		{"3-1", nil, true},
+		{"-1", nil, true},
+		{"a", nil, true},
+		{"0-b", nil, true},
+		{"0,", nil, true},
+		{"0-1-2", nil, true},
+	} {
+		cpus, err := parseCPUList(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.in, err)
+		} else if !tt.err && !reflect.DeepEqual(cpus, tt.cpus) {
+			t.Errorf("%q: parsed %v, want %v", tt.in, cpus, tt.cpus)
+		}
+	}
+}
+
+func TestSubset(t *testing.T) {
+	for _, tt := range []struct {
+		cpus, set []int
+		missing   int
+		ok        bool
+	}{
+		{nil, nil, 0, true},
+		{[]int{1, 2}, []int{0, 1, 2, 3}, 0, true},
+		{[]int{1, 4, 5}, []int{0, 1, 2, 3}, 4, false},
+		{[]int{0}, nil, 0, false},
+	} {
+		missing, ok := subset(tt.cpus, tt.set)
+		if missing != tt.missing || ok != tt.ok {
+			t.Errorf("subset(%v, %v) = %d, %v; want %d, %v", tt.cpus, tt.set, missing, ok, tt.missing, tt.ok)
+		}
+	}
+}
+
+func TestPlacementValidate(t *testing.T) {
+	root, err := ioutil.TempDir("", "qemu-sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// four CPUs online, two on each of two NUMA nodes
+	for path, contents := range map[string]string{
+		"cpu/online":         "0-3\n",
+		"node/node0/cpulist": "0-1\n",
+		"node/node1/cpulist": "2-3\n",
+		"node/node8/.keep":   "",
+		"node/node9/cpulist": "x\n",
+	} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node := func(n int) *int { return &n }
+	for _, tt := range []struct {
+		p   Placement
+		err string
+	}{
+		{Placement{}, ""},
+		{Placement{CPUSet: "0-3"}, ""},
+		{Placement{CPUSet: "1,3"}, ""},
+		{Placement{CPUSet: "2-5"}, "CPU 4 is not online"},
+		{Placement{CPUSet: "1-"}, "invalid cpuset"},
+		{Placement{NUMANode: node(1)}, ""},
+		{Placement{CPUSet: "2-3", NUMANode: node(1)}, ""},
+		{Placement{CPUSet: "1-2", NUMANode: node(1)}, "CPU 1 is not on NUMA node 1"},
+		{Placement{NUMANode: node(2)}, "NUMA node 2 does not exist"},
+		{Placement{NUMANode: node(8)}, "NUMA node 8 does not exist"},
+		{Placement{NUMANode: node(9)}, "parsing"},
+	} {
+		err := tt.p.validate(root)
+		if tt.err == "" && err != nil {
+			t.Errorf("%+v: %v", tt.p, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%+v: error %v, want one with %q", tt.p, err, tt.err)
+		}
+	}
+
+	if err := (Placement{}).validate(filepath.Join(root, "missing")); !os.IsNotExist(err) {
+		t.Errorf("missing sysfs: %v", err)
+	}
+}
+
+func TestPlacementWrap(t *testing.T) {
+	node := func(n int) *int { return &n }
+	qemu := []string{"qemu-system-x86_64", "-m", "1024"}
+	for _, tt := range []struct {
+		p    Placement
+		argv []string
+	}{
+		{Placement{}, qemu},
+		{Placement{CPUSet: "0-3"}, append([]string{"taskset", "-c", "0-3"}, qemu...)},
+		{Placement{NUMANode: node(1)}, append([]string{"numactl", "--membind=1", "--cpunodebind=1"}, qemu...)},
+		{Placement{CPUSet: "2,3", NUMANode: node(1)}, append([]string{"numactl", "--membind=1", "--physcpubind=2,3"}, qemu...)},
+	} {
+		if argv := tt.p.wrap(qemu); !reflect.DeepEqual(argv, tt.argv) {
+			t.Errorf("%+v: argv %v, want %v", tt.p, argv, tt.argv)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// qmpSocketPrefix starts the name of every machine's QMP socket.
+	// The socket is passed on qemu's command line, so it fingerprints
+	// the qemu of a machine even if the machine is gone.
+	qmpSocketPrefix = "kola-qmp-"
+
+	processPollInterval = 100 * time.Millisecond
+
+	// ProcessExitTimeout is how long Destroy waits for a machine's
+	// processes to exit after killing them.
+	ProcessExitTimeout = 10 * time.Second
+)
+
+// qmpSocketRe matches the QMP socket argument of a machine's qemu.
+var qmpSocketRe = regexp.MustCompile(`unix:(\S*/` + qmpSocketPrefix + `([0-9a-f-]+)),`)
+
+// qmpSocketPath returns the path of machine id's QMP socket. Unix
+// socket paths are limited to 108 bytes, which a path under the output
+// directory may exceed.
+func qmpSocketPath(id string) string {
+	return filepath.Join(os.TempDir(), qmpSocketPrefix+id)
+}
+
+// process is a process found in /proc.
+type process struct {
+	pid, ppid int
+	cmdline   string // arguments joined by spaces
+}
+
+// readProcess reads process pid from /proc. Zombies are reported as
+// not existing, since they hold nothing but their pid.
+func readProcess(pid int) (process, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	stat, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return process{}, err
+	}
+	// pid (comm) state ppid ...; comm may contain anything
+	var fields []string
+	if i := strings.LastIndexByte(string(stat), ')'); i >= 0 {
+		fields = strings.Fields(string(stat[i+1:]))
+	}
+	if len(fields) < 2 {
+		return process{}, fmt.Errorf("parsing %s/stat: %q", dir, stat)
+	}
+	if fields[0] == "Z" {
+		return process{}, os.ErrNotExist
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return process{}, fmt.Errorf("parsing %s/stat: %v", dir, err)
+	}
+	cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return process{}, err
+	}
+	return process{
+		pid:     pid,
+		ppid:    ppid,
+		cmdline: strings.TrimSpace(strings.Replace(string(cmdline), "\x00", " ", -1)),
+	}, nil
+}
+
+// findProcesses returns the processes whose command line contains s.
+func findProcesses(s string) ([]process, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var found []process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		p, err := readProcess(pid)
+		if err != nil {
+			continue // exited meanwhile, or not ours to read
+		}
+		if strings.Contains(p.cmdline, s) {
+			found = append(found, p)
+		}
+	}
+	return found, nil
+}
+
+// killGroup kills process group pgid. A group which is already gone is
+// not an error.
+func killGroup(pgid int) error {
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("killing process group %d: %v", pgid, err)
+	}
+	return nil
+}
+
+// waitGone waits until pid has exited and no process's command line
+// contains fingerprint, and otherwise returns an error naming the
+// processes left behind.
+func waitGone(pid int, fingerprint string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var left []string
+		if _, err := readProcess(pid); err == nil {
+			left = append(left, strconv.Itoa(pid))
+		}
+		procs, err := findProcesses(fingerprint)
+		if err != nil {
+			return err
+		}
+		for _, p := range procs {
+			if p.pid != pid {
+				left = append(left, strconv.Itoa(p.pid))
+			}
+		}
+		if len(left) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("qemu processes %s still running %v after being killed", strings.Join(left, ", "), timeout)
+		}
+		time.Sleep(processPollInterval)
+	}
+}
+
+// Stray is the qemu process of a kola machine which outlived the
+// machine.
+type Stray struct {
+	PID       int
+	MachineID string
+	Cmdline   string
+}
+
+// FindStrays returns the qemu processes of kola machines which were
+// destroyed, i.e. whose QMP socket is gone, or whose kola process died,
+// leaving them orphaned.
+func FindStrays() ([]Stray, error) {
+	procs, err := findProcesses(qmpSocketPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var strays []Stray
+	for _, p := range procs {
+		m := qmpSocketRe.FindStringSubmatch(p.cmdline)
+		if m == nil {
+			continue
+		}
+		if _, err := os.Stat(m[1]); err == nil && p.ppid != 1 {
+			continue
+		}
+		strays = append(strays, Stray{
+			PID:       p.pid,
+			MachineID: m[2],
+			Cmdline:   p.cmdline,
+		})
+	}
+	return strays, nil
+}
+
+// Kill kills the stray along with the rest of its process group, if it
+// leads one, and waits for them to exit.
+func (s Stray) Kill() error {
+	if pgid, err := syscall.Getpgid(s.PID); err == nil && pgid == s.PID {
+		if err := killGroup(pgid); err != nil {
+			return err
+		}
+	} else if err := syscall.Kill(s.PID, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("killing %d: %v", s.PID, err)
+	}
+	return waitGone(s.PID, qmpSocketPath(s.MachineID), ProcessExitTimeout)
+}
@@ -0,0 +1,261 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/coreos/mantle/sdk"
+	"github.com/coreos/mantle/system"
+)
+
+// Container Linux PXE artifacts. There is no separate squashfs: the
+// initrd carries the /usr squashfs as pxeSquashfs, which is checked when
+// the artifacts are first used.
+const (
+	pxeKernel   = "coreos_production_pxe.vmlinuz"
+	pxeInitrd   = "coreos_production_pxe_image.cpio.gz"
+	pxeSquashfs = "usr.squashfs"
+)
+
+// pxeMirrorTimeout bounds each request looking for the mirror of a
+// version.
+const pxeMirrorTimeout = 30 * time.Second
+
+// Release mirrors are per channel; a version is only published on the
+// channels it was released to.
+var pxeChannels = []string{"alpha", "beta", "stable"}
+
+// pxeMirrorURL is the release directory of a version on a channel.
+var pxeMirrorURL = func(channel, board, version string) string {
+	return fmt.Sprintf("https://%s.release.core-os.net/%s/%s", channel, board, version)
+}
+
+// PXEArtifacts are the files needed to netboot the image under test.
+type PXEArtifacts struct {
+	Version string // empty if unknown for local artifacts
+	Kernel  string
+	Initrd  string
+}
+
+// FetchPXEArtifacts returns the PXE artifacts matching opts.DiskImage,
+// downloaded from the release mirror into cacheDir, keyed by board and
+// version, unless a verified copy is already there. Signatures are
+// checked against the image signing key.
+func FetchPXEArtifacts(opts *Options, cacheDir string) (*PXEArtifacts, error) {
+	// The SDK writes version.txt next to every image it builds.
+	ver, err := sdk.VersionsFromDir(filepath.Dir(opts.DiskImage))
+	if err != nil {
+		return nil, fmt.Errorf("reading version of %s: %v", opts.DiskImage, err)
+	}
+
+	dir := filepath.Join(cacheDir, "pxe", opts.Board, ver.Version)
+	a := &PXEArtifacts{
+		Version: ver.Version,
+		Kernel:  filepath.Join(dir, pxeKernel),
+		Initrd:  filepath.Join(dir, pxeInitrd),
+	}
+
+	// Skip looking for a mirror if everything is cached. Cached
+	// initrds were checked for the squashfs when downloaded.
+	if sdk.VerifyFile(a.Kernel, "") == nil && sdk.VerifyFile(a.Initrd, "") == nil {
+		return a, nil
+	}
+
+	base, err := pxeMirror(opts.Board, ver.Version)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range []string{a.Kernel, a.Initrd} {
+		url := base + "/" + filepath.Base(file)
+		if err := sdk.DownloadSignedFile(file, url, nil, ""); err != nil {
+			return nil, fmt.Errorf("fetching %s: %v", url, err)
+		}
+	}
+	if err := checkInitrd(a.Initrd); err != nil {
+		// don't keep it as a verified cached copy
+		os.Remove(a.Initrd + ".sig")
+		return nil, err
+	}
+	return a, nil
+}
+
+// pxeMirror returns the URL of the release directory holding version.
+func pxeMirror(board, version string) (string, error) {
+	client := &http.Client{Timeout: pxeMirrorTimeout}
+	for _, channel := range pxeChannels {
+		base := pxeMirrorURL(channel, board, version)
+		resp, err := client.Head(base + "/" + pxeKernel)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return base, nil
+		}
+	}
+	return "", fmt.Errorf("no release mirror has %s version %s", board, version)
+}
+
+// LocalPXEArtifacts returns the PXE artifacts in dir, e.g. as built by
+// the SDK, verifying those which have a .sig file.
+func LocalPXEArtifacts(dir string) (*PXEArtifacts, error) {
+	a := &PXEArtifacts{
+		Kernel: filepath.Join(dir, pxeKernel),
+		Initrd: filepath.Join(dir, pxeInitrd),
+	}
+	if ver, err := sdk.VersionsFromDir(dir); err == nil {
+		a.Version = ver.Version
+	}
+
+	for _, file := range []string{a.Kernel, a.Initrd} {
+		if _, err := os.Stat(file); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(file + ".sig"); os.IsNotExist(err) {
+			plog.Warningf("Not verifying %s: no signature", file)
+			continue
+		}
+		if err := sdk.VerifyFile(file, ""); err != nil {
+			return nil, fmt.Errorf("verifying %s: %v", file, err)
+		}
+	}
+	if err := checkInitrd(a.Initrd); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// checkInitrd checks that the gzipped initrd at path carries the /usr
+// squashfs, without which the PXE image cannot boot. The initrd may be
+// several cpio archives in the newc format, concatenated.
+func checkInitrd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", path, err)
+	}
+	r := bufio.NewReader(zr)
+
+	for {
+		name, err := nextCpioEntry(r)
+		if err == io.EOF {
+			return fmt.Errorf("%s does not carry %s", path, pxeSquashfs)
+		} else if err != nil {
+			return fmt.Errorf("reading %s: %v", path, err)
+		}
+		if name == pxeSquashfs {
+			return nil
+		}
+	}
+}
+
+// nextCpioEntry reads the header of the next newc cpio entry from r,
+// skipping the padding between archives, and skips its data. It returns
+// io.EOF at the end of the last archive.
+func nextCpioEntry(r *bufio.Reader) (string, error) {
+	// archives are padded with NULs
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return "", err
+		}
+		if b[0] != 0 {
+			break
+		}
+		r.ReadByte()
+	}
+
+	var hdr [110]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if magic := string(hdr[:6]); magic != "070701" && magic != "070702" {
+		return "", fmt.Errorf("bad cpio magic %q", magic)
+	}
+	field := func(i int) (int64, error) {
+		return strconv.ParseInt(string(hdr[6+8*i:14+8*i]), 16, 64)
+	}
+	size, err := field(6)
+	if err != nil {
+		return "", err
+	}
+	nameSize, err := field(11)
+	if err != nil {
+		return "", err
+	}
+
+	// the header and name, and the data, are each padded to 4 bytes
+	name := make([]byte, nameSize+pad4(110+nameSize))
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, size+pad4(size)); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if nameSize < 1 {
+		return "", fmt.Errorf("bad cpio name size %d", nameSize)
+	}
+	return string(name[:nameSize-1]), nil
+}
+
+func pad4(n int64) int64 {
+	return (4 - n%4) % 4
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Stage places the artifacts in dir, the root of the TFTP and HTTP
+// fixture servers, as vmlinuz and initrd.cpio.gz. Files are hard linked
+// where possible since the initrd is large.
+func (a *PXEArtifacts) Stage(dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	for src, name := range map[string]string{
+		a.Kernel: "vmlinuz",
+		a.Initrd: "initrd.cpio.gz",
+	} {
+		dest := filepath.Join(dir, name)
+		if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Link(src, dest); err == nil {
+			continue
+		}
+		if err := system.CopyRegularFile(src, dest); err != nil {
+			return fmt.Errorf("staging %s: %v", src, err)
+		}
+	}
+	return nil
+}
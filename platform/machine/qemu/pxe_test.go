@@ -0,0 +1,254 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// signedTxt is signed by the image signing key, so it stands in for
+// artifacts which verify; it is also a valid version.txt.
+const (
+	signedTxt = `COREOS_BUILD=723
+COREOS_BRANCH=1
+COREOS_PATCH=0
+COREOS_VERSION=723.1.0
+COREOS_VERSION_ID=723.1.0
+COREOS_BUILD_ID=""
+COREOS_SDK_VERSION=717.0.0
+`
+	signedSig = `
+iQIcBAABAgAGBQJVjgCWAAoJEKWpZjXlZ278G/kQAKSqkurFrKywkPhCe3VejSUp
+GSS2MmHT4UAhHzopof33eV1mwI7NxPP7oDOeg5ovLxiHbawo/fHYUI9Wt2r9ZUCB
+QxXt1fk9yBbUlVd6vdsrLmUZpVNfFmnxUL8iurRJczgSKmqyxbk+HcD+fidkgSAU
+5xpLYEfCp1VSZ61a+3NZO8NHval4x3+AYZXOBNqfWz1s7Sewmvm/YbIs0BlwZxrY
+CUiYzuNCgDl0qZLRx8C2EmnHk675XvN4Nr0xAHRsARIXfgFR1AVSqVdvzW2ZW3Bq
+KBNiF0zfhZ2cdG6Rj9Dp39+skazUYW8bzn1fr374prSALef/WZIAUkLWvUpPdEli
+ZnQr9Ufm+ZW2XM+Nm/Ks5Zf5f+0axHESF1ANSKNM7gp7a6+cbXLniXQKUxMLlTGL
+TCz29ZdK1M8Wx2V8bisOk24yneOJyVzn5jSO4zCr6xWxBH8yf0B6UQnXWK8fhVDR
+V/mehjhms7/8xCfRlTo42h69UqCzp/ZMlJZOTikw4Q7yZwhAu1bERlOWUVSHik8W
+UjVp1b0FyuBYEJA3ht2QuIdf54M3bKGsFGMUB0/ro6sm00UF3pjVkG+a7WEU4zpp
+nhqSIf7YIqso/oohdpmc338F7G3RgfYoZ2+THXGTxpIvMvkEqxCaKPsprXLZQd94
+ULECDto3pYB5cT5A/blA
+=/zkZ
+`
+)
+
+// writeInitrd writes a gzipped newc cpio archive of files named names,
+// each holding its name, followed by a second archive of more.
+func writeInitrd(t *testing.T, path string, names []string, more ...string) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	archive := func(names []string) {
+		var n int
+		write := func(s string) {
+			fmt.Fprint(zw, s)
+			n += len(s)
+			for n%4 != 0 {
+				zw.Write([]byte{0})
+				n++
+			}
+		}
+		for _, name := range append(names, "TRAILER!!!") {
+			fmt.Fprintf(zw, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+				1, 0100644, 0, 0, 1, 0, len(name), 0, 0, 0, 0, len(name)+1, 0)
+			n += 110
+			write(name + "\x00")
+			write(name)
+		}
+		// padded to a block like the kernel expects
+		for n%512 != 0 {
+			zw.Write([]byte{0})
+			n++
+		}
+	}
+	archive(names)
+	if len(more) > 0 {
+		archive(more)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeKernel(t *testing.T, dir string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, pxeKernel), []byte("kernel"), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeSigned(t *testing.T, path string) {
+	if err := ioutil.WriteFile(path, []byte(signedTxt), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// without the armor checksum
+	b64 := signedSig[:strings.LastIndex(signedSig, "\n=")]
+	sig, err := base64.StdEncoding.DecodeString(strings.Replace(b64, "\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".sig", sig, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalPXEArtifacts(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		setup  func(t *testing.T, dir string)
+		errors bool
+	}{
+		{
+			name: "unsigned",
+			setup: func(t *testing.T, dir string) {
+				writeKernel(t, dir)
+				writeInitrd(t, filepath.Join(dir, pxeInitrd), []string{"etc", pxeSquashfs})
+			},
+		},
+		{
+			name: "squashfs in a later archive",
+			setup: func(t *testing.T, dir string) {
+				writeKernel(t, dir)
+				writeInitrd(t, filepath.Join(dir, pxeInitrd), []string{"etc"}, pxeSquashfs)
+			},
+		},
+		{
+			name: "no squashfs",
+			setup: func(t *testing.T, dir string) {
+				writeKernel(t, dir)
+				writeInitrd(t, filepath.Join(dir, pxeInitrd), []string{"etc"})
+			},
+			errors: true,
+		},
+		{
+			name: "signed kernel",
+			setup: func(t *testing.T, dir string) {
+				writeSigned(t, filepath.Join(dir, pxeKernel))
+				writeInitrd(t, filepath.Join(dir, pxeInitrd), []string{pxeSquashfs})
+			},
+		},
+		{
+			name: "bad signature",
+			setup: func(t *testing.T, dir string) {
+				writeSigned(t, filepath.Join(dir, pxeKernel))
+				ioutil.WriteFile(filepath.Join(dir, pxeKernel), []byte("tampered"), 0666)
+				writeInitrd(t, filepath.Join(dir, pxeInitrd), []string{pxeSquashfs})
+			},
+			errors: true,
+		},
+		{
+			name: "no kernel",
+			setup: func(t *testing.T, dir string) {
+				writeInitrd(t, filepath.Join(dir, pxeInitrd), []string{pxeSquashfs})
+			},
+			errors: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "kola-pxe")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := ioutil.WriteFile(filepath.Join(dir, "version.txt"), []byte(signedTxt), 0666); err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(t, dir)
+
+			a, err := LocalPXEArtifacts(dir)
+			if tt.errors {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Version != "723.1.0" || a.Kernel != filepath.Join(dir, pxeKernel) || a.Initrd != filepath.Join(dir, pxeInitrd) {
+				t.Errorf("unexpected artifacts %+v", a)
+			}
+		})
+	}
+}
+
+func TestFetchPXEArtifactsCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-pxe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(u func(string, string, string) string) { pxeMirrorURL = u }(pxeMirrorURL)
+	pxeMirrorURL = func(channel, board, version string) string {
+		t.Errorf("looked for a mirror of %s %s despite the cache", board, version)
+		return "http://127.0.0.1:0"
+	}
+
+	image := filepath.Join(dir, "image", "coreos_production_qemu_image.img")
+	os.MkdirAll(filepath.Dir(image), 0777)
+	if err := ioutil.WriteFile(filepath.Join(dir, "image", "version.txt"), []byte(signedTxt), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cached := filepath.Join(dir, "cache", "pxe", "amd64-usr", "723.1.0")
+	os.MkdirAll(cached, 0777)
+	writeSigned(t, filepath.Join(cached, pxeKernel))
+	writeSigned(t, filepath.Join(cached, pxeInitrd))
+
+	a, err := FetchPXEArtifacts(&Options{DiskImage: image, Board: "amd64-usr"}, filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Version != "723.1.0" || a.Kernel != filepath.Join(cached, pxeKernel) || a.Initrd != filepath.Join(cached, pxeInitrd) {
+		t.Errorf("unexpected artifacts %+v", a)
+	}
+}
+
+func TestPXEMirror(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" || r.URL.Path != "/beta/amd64-usr/723.1.0/"+pxeKernel {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u func(string, string, string) string) { pxeMirrorURL = u }(pxeMirrorURL)
+	pxeMirrorURL = func(channel, board, version string) string {
+		return srv.URL + "/" + channel + "/" + board + "/" + version
+	}
+
+	base, err := pxeMirror("amd64-usr", "723.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base != srv.URL+"/beta/amd64-usr/723.1.0" {
+		t.Errorf("got mirror %s", base)
+	}
+	if _, err := pxeMirror("amd64-usr", "1.0.0"); err == nil {
+		t.Error("expected no mirror for an unreleased version")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the MachineOptions, for UnsupportedOptionError.
+const (
+	OptionMemory          = "memory"
+	OptionCPUs            = "cpus"
+	OptionAdditionalDisks = "additional disks"
+)
+
+// MachineOptions sizes the machines of a cluster differently from the
+// platform's defaults, e.g. for tests of multi-disk setups or which need
+// more memory. Zero fields keep the defaults.
+type MachineOptions struct {
+	// MemoryMiB is the memory of each machine in MiB.
+	MemoryMiB int `json:",omitempty"`
+
+	// CPUs is the number of virtual CPUs of each machine.
+	CPUs int `json:",omitempty"`
+
+	// AdditionalDisks are blank disks attached to each machine
+	// besides its boot disk.
+	AdditionalDisks []Disk `json:",omitempty"`
+}
+
+// Disk is a blank disk attached to a machine.
+type Disk struct {
+	Size   string // disk image size in bytes, optional suffixes "K", "M", "G", "T" allowed
+	Serial string // serial number to be passed to qemu via `serial=`. Disks show up under /dev/disk/by-id/virtio-<serial>
+}
+
+// IsZero reports whether o keeps every default.
+func (o MachineOptions) IsZero() bool {
+	return o.MemoryMiB == 0 && o.CPUs == 0 && len(o.AdditionalDisks) == 0
+}
+
+// Copy returns a copy of o which shares nothing with it.
+func (o MachineOptions) Copy() MachineOptions {
+	o.AdditionalDisks = append([]Disk(nil), o.AdditionalDisks...)
+	return o
+}
+
+// Check returns an error if o is invalid, or an *UnsupportedOptionError
+// if it sets an option pltfrm, which supports the options named in
+// supported, can't honor. Platforms check the options of their clusters
+// when creating them rather than ignore any.
+func (o MachineOptions) Check(pltfrm Name, supported ...string) error {
+	if o.MemoryMiB < 0 || o.CPUs < 0 {
+		return fmt.Errorf("machine options: negative memory or CPUs")
+	}
+	for i, d := range o.AdditionalDisks {
+		if d.Size == "" {
+			return fmt.Errorf("machine options: additional disk %d has no size", i)
+		}
+	}
+	set := map[string]bool{
+		OptionMemory:          o.MemoryMiB != 0,
+		OptionCPUs:            o.CPUs != 0,
+		OptionAdditionalDisks: len(o.AdditionalDisks) != 0,
+	}
+	for _, option := range []string{OptionMemory, OptionCPUs, OptionAdditionalDisks} {
+		if set[option] && !hasOption(supported, option) {
+			return &UnsupportedOptionError{Platform: pltfrm, Option: option}
+		}
+	}
+	return nil
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// UnsupportedOptionError is returned for MachineOptions a platform can't
+// honor.
+type UnsupportedOptionError struct {
+	Platform Name
+	Option   string
+	Reason   string // why, if it depends on the value
+}
+
+func (e *UnsupportedOptionError) Error() string {
+	msg := fmt.Sprintf("unsupported option: %s machines can't have %s set", e.Platform, e.Option)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// String describes the options set in o, for logs.
+func (o MachineOptions) String() string {
+	var parts []string
+	if o.MemoryMiB != 0 {
+		parts = append(parts, fmt.Sprintf("%d MiB memory", o.MemoryMiB))
+	}
+	if o.CPUs != 0 {
+		parts = append(parts, fmt.Sprintf("%d CPUs", o.CPUs))
+	}
+	for _, d := range o.AdditionalDisks {
+		parts = append(parts, fmt.Sprintf("%s disk %q", d.Size, d.Serial))
+	}
+	if len(parts) == 0 {
+		return "defaults"
+	}
+	return strings.Join(parts, ", ")
+}
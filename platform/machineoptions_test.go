@@ -0,0 +1,60 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"testing"
+)
+
+func TestMachineOptionsCheck(t *testing.T) {
+	for _, c := range []struct {
+		o           MachineOptions
+		supported   []string
+		unsupported string // the option rejected, or "" if o is accepted
+		invalid     bool
+	}{
+		{MachineOptions{}, nil, "", false},
+		{MachineOptions{MemoryMiB: 4096, CPUs: 2}, []string{OptionMemory, OptionCPUs}, "", false},
+		{MachineOptions{MemoryMiB: 4096}, nil, OptionMemory, false},
+		{MachineOptions{CPUs: 2}, []string{OptionMemory}, OptionCPUs, false},
+		{MachineOptions{AdditionalDisks: []Disk{{Size: "1G"}}}, []string{OptionMemory, OptionCPUs}, OptionAdditionalDisks, false},
+		{MachineOptions{AdditionalDisks: []Disk{{Serial: "data"}}}, []string{OptionAdditionalDisks}, "", true},
+		{MachineOptions{CPUs: -1}, []string{OptionCPUs}, "", true},
+	} {
+		err := c.o.Check("test", c.supported...)
+		uerr, unsupported := err.(*UnsupportedOptionError)
+		switch {
+		case c.unsupported != "":
+			if !unsupported || uerr.Option != c.unsupported || uerr.Platform != "test" {
+				t.Errorf("%v: got %v, want %s unsupported", c.o, err, c.unsupported)
+			}
+		case c.invalid:
+			if err == nil || unsupported {
+				t.Errorf("%v: got %v, want invalid", c.o, err)
+			}
+		case err != nil:
+			t.Errorf("%v: %v", c.o, err)
+		}
+	}
+}
+
+func TestMachineOptionsCopy(t *testing.T) {
+	o := MachineOptions{AdditionalDisks: []Disk{{Size: "1G", Serial: "a"}}}
+	c := o.Copy()
+	c.AdditionalDisks[0].Serial = "b"
+	if o.AdditionalDisks[0].Serial != "a" {
+		t.Error("copy shares its disks")
+	}
+}
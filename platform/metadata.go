@@ -0,0 +1,50 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+// Metadata is the cloud metadata served to a machine at 169.254.169.254,
+// as trees of paths to values. Paths are relative to the root of each
+// cloud's tree, and directory listings are derived from them.
+type Metadata struct {
+	// EC2 is served under /latest/ and every other version prefix,
+	// e.g. "meta-data/local-ipv4" or "user-data".
+	EC2 map[string]string
+
+	// GCE is served under /computeMetadata/v1/ to requests with the
+	// Metadata-Flavor: Google header, e.g. "instance/hostname".
+	GCE map[string]string
+}
+
+// MetadataService is implemented by clusters on platforms with
+// CapMetadataService. It simulates a cloud metadata service so that
+// cloud agents such as coreos-metadata can be tested on machines which
+// aren't in that cloud.
+type MetadataService interface {
+	// SetMetadata sets the metadata served to machines created
+	// afterwards. Values the test leaves unset, such as the machine's
+	// address and hostname, are filled in for each machine.
+	SetMetadata(md Metadata)
+}
+
+// SetMetadata sets the metadata served to machines c creates afterwards.
+// It returns ErrNotSupported unless c implements MetadataService.
+func SetMetadata(c Cluster, md Metadata) error {
+	s, ok := c.(MetadataService)
+	if !ok {
+		return ErrNotSupported
+	}
+	s.SetMetadata(md)
+	return nil
+}
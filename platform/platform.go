@@ -16,8 +16,10 @@ package platform
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
 	"sync"
 	"time"
@@ -60,7 +62,10 @@ type Machine interface {
 	// SSH runs a single command over a new SSH connection.
 	SSH(cmd string) ([]byte, []byte, error)
 
-	// Reboot restarts the machine and waits for it to come back.
+	// Reboot restarts the machine and waits for it to come back. sshd is
+	// stopped before rebooting so that the wait only ends once the new
+	// boot accepts SSH and passes the checks run at creation. The
+	// machine keeps its address, so it stays usable afterwards.
 	Reboot() error
 
 	// Destroy terminates the machine and frees associated resources. It should log
@@ -72,11 +77,58 @@ type Machine interface {
 	ConsoleOutput() string
 }
 
+// ErrGuestAgentNotAvailable is returned by GuestAgent methods when no agent
+// answers inside the machine, e.g. because the image does not ship one.
+var ErrGuestAgentNotAvailable = errors.New("guest agent not available")
+
+// GuestAgent is implemented by machines which can run commands and read
+// files through an agent in the guest rather than over the network. It
+// remains usable when a test has broken the machine's networking.
+type GuestAgent interface {
+	// GuestExec runs cmd with /bin/sh and returns its stdout and stderr.
+	GuestExec(cmd string) ([]byte, []byte, error)
+
+	// GuestFileRead returns the contents of a file on the machine.
+	GuestFileRead(path string) ([]byte, error)
+}
+
+// Hostnamer is implemented by machines whose platform assigns the
+// machine's hostname from its canonical name.
+type Hostnamer interface {
+	// Hostname returns the hostname the machine is expected to have.
+	Hostname() string
+}
+
+// Namer is implemented by machines whose ID is not the canonical name
+// from NextMachineName, so that logs can map one to the other.
+type Namer interface {
+	// Name returns the machine's canonical name.
+	Name() string
+}
+
+// HostProcess is implemented by machines run as a process on the host
+// running kola, such as qemu, whose use of the host can be measured.
+type HostProcess interface {
+	// Pid returns the pid of the machine's process.
+	Pid() int
+}
+
+// Killer is implemented by machines which can be stopped abruptly, as if
+// they crashed, rather than shut down.
+type Killer interface {
+	// Kill stops the machine without letting the OS shut down, then
+	// frees it like Destroy.
+	Kill()
+}
+
 // Cluster represents a cluster of Container Linux machines within a single platform.
 type Cluster interface {
 	// Platform returns the name of the platform.
 	Platform() Name
 
+	// Capabilities returns the optional features the platform supports.
+	Capabilities() Capabilities
+
 	// NewMachine creates a new Container Linux machine.
 	NewMachine(userdata *conf.UserData) (Machine, error)
 
@@ -86,6 +138,11 @@ type Cluster interface {
 	// GetDiscoveryURL returns a new etcd discovery URL.
 	GetDiscoveryURL(size int) (string, error)
 
+	// GetDiscoveryState returns the JSON document the discovery
+	// service serves for url, a URL returned by GetDiscoveryURL, which
+	// lists the members registered so far.
+	GetDiscoveryState(url string) ([]byte, error)
+
 	// Destroy terminates each machine in the cluster and frees any other
 	// associated resources. It should log any failures; since they are not
 	// actionable, it does not return an error
@@ -94,6 +151,10 @@ type Cluster interface {
 	// ConsoleOutput returns a map of console output from destroyed
 	// cluster machines.
 	ConsoleOutput() map[string]string
+
+	// Events subscribes to the lifecycle events of the cluster's
+	// machines. The channel is closed when the cluster is destroyed.
+	Events() <-chan MachineEvent
 }
 
 // SystemdDropin is a userdata type agnostic struct representing a systemd dropin
@@ -107,16 +168,99 @@ type SystemdDropin struct {
 type Options struct {
 	BaseName       string
 	SystemdDropins []SystemdDropin
+
+	// DiscoveryURL is the base URL of the etcd discovery service
+	// GetDiscoveryURL asks for new tokens, such as a private instance
+	// of github.com/coreos/discovery.etcd.io. Empty means
+	// DefaultDiscoveryURL. The qemu platform always uses its own.
+	DiscoveryURL string
+}
+
+// DefaultDiscoveryURL is the public etcd discovery service.
+const DefaultDiscoveryURL = "https://discovery.etcd.io"
+
+// ArtifactLimits caps the size of data collected from machines so that a
+// runaway test cannot fill the disk. A zero limit means unlimited.
+type ArtifactLimits struct {
+	CommandOutput int64 // bytes of stdout and of stderr kept per SSH command
+	Journal       int64 // bytes of journal.txt kept, its head and tail, and of the raw journal, its head
+	Console       int64 // bytes of console output kept; the head and tail are kept
+
+	// Truncated, if set, is called whenever an artifact is truncated.
+	Truncated func(artifact string, dropped int64) `json:"-"`
+}
+
+// ReportTruncated logs that an artifact was truncated and calls the
+// Truncated hook, if any.
+func (l ArtifactLimits) ReportTruncated(artifact string, dropped int64) {
+	plog.Warningf("Truncated %s: dropped %d bytes", artifact, dropped)
+	if l.Truncated != nil {
+		l.Truncated(artifact, dropped)
+	}
 }
 
 // RuntimeConfig contains cluster-specific configuration.
 type RuntimeConfig struct {
 	OutputDir string
+	Limits    ArtifactLimits
+
+	// RunID, if set, names the run the cluster belongs to, so that a
+	// later run can recognize its machines.
+	RunID string
 
 	NoSSHKeyInUserData bool // don't inject SSH key into Ignition/cloud-config
 	NoSSHKeyInMetadata bool // don't add SSH key to platform metadata
 	NoEnableSelinux    bool // don't enable selinux when starting or rebooting a machine
 	AllowFailedUnits   bool // don't fail CheckMachine if a systemd unit has failed
+	NoUserDataWait     bool // don't wait for or check cloudinit and Ignition in CheckMachine
+	StrictCrypto       bool // only use FIPS 140-2 approved SSH keys and algorithms
+
+	// MaxMachineLifetime, if not zero, is how long a machine may exist
+	// before the cluster destroys it, e.g. because a goroutine leaked
+	// by a finished test keeps it alive. Clusters whose machines are
+	// meant to outlive a test should use a correspondingly longer
+	// lifetime.
+	MaxMachineLifetime time.Duration
+
+	// RequiredPorts are the TCP ports on the cluster's machines which
+	// must be reachable from this process. Platforms with a firewall
+	// open them; see register.Test.
+	RequiredPorts []int
+
+	// DestroyOrder is the order in which destroying the cluster tears
+	// down its machines; empty is DestroyCreation.
+	DestroyOrder DestroyOrder
+
+	// MachineOptions sizes the cluster's machines. Platforms fail to
+	// create the cluster if they can't honor them.
+	MachineOptions MachineOptions
+
+	// Context, if set, is that of the test the cluster belongs to.
+	// Platforms stop waiting for slow cloud operations, such as
+	// creating an instance, once it is done.
+	Context context.Context `json:"-"`
+
+	// MachineReaped, if set, is called after a machine was destroyed
+	// for exceeding MaxMachineLifetime.
+	MachineReaped func(id string, age time.Duration) `json:"-"`
+
+	// MachineLeaked, if set, is called when a machine's platform
+	// resources could not be released, e.g. because deleting a cloud
+	// instance kept failing, and may outlive the run.
+	MachineLeaked func(id string, err error) `json:"-"`
+
+	// events is the event bus of the cluster a machine belongs to, set
+	// in the copy returned by BaseCluster.RuntimeConf so that helpers
+	// given only a Machine can report its events.
+	events *eventBus
+}
+
+// TestContext returns Context, or context.Background if it isn't set.
+func (rc RuntimeConfig) TestContext() context.Context {
+	if rc.Context == nil {
+		return context.Background()
+	}
+	return rc.Context
 }
 
 // Wrap a StdoutPipe as a io.ReadCloser
@@ -131,7 +275,8 @@ func (p *sshPipe) Close() error {
 	if err := p.s.Wait(); err != nil {
 		return fmt.Errorf("%s: %s", err, p.err)
 	}
-	if err := p.s.Close(); err != nil {
+	// the session is already closed once the command has exited
+	if err := p.s.Close(); err != nil && err != io.EOF {
 		return err
 	}
 	return p.c.Close()
@@ -154,6 +299,12 @@ func TransferFile(src Machine, srcPath string, dst Machine, dstPath string) erro
 // ReadFile returns a io.ReadCloser that streams the requested file. The
 // caller should close the reader when finished.
 func ReadFile(m Machine, path string) (io.ReadCloser, error) {
+	return readCommand(m, fmt.Sprintf("sudo cat %s", path))
+}
+
+// readCommand starts cmd on m and returns a io.ReadCloser streaming its
+// stdout. Closing it waits for cmd, returning its error with stderr.
+func readCommand(m Machine, cmd string) (io.ReadCloser, error) {
 	client, err := m.SSHClient()
 	if err != nil {
 		return nil, fmt.Errorf("failed creating SSH client: %v", err)
@@ -177,8 +328,7 @@ func ReadFile(m Machine, path string) (io.ReadCloser, error) {
 	errBuf := bytes.NewBuffer(nil)
 	session.Stderr = errBuf
 
-	// stream file to stdout
-	err = session.Start(fmt.Sprintf("sudo cat %s", path))
+	err = session.Start(cmd)
 	if err != nil {
 		session.Close()
 		client.Close()
@@ -192,73 +342,108 @@ func ReadFile(m Machine, path string) (io.ReadCloser, error) {
 
 // InstallFile copies data from in to the path to on m.
 func InstallFile(in io.Reader, m Machine, to string) error {
+	return InstallFileMode(in, m, to, 0755)
+}
+
+// InstallFileMode copies data from in to the path to on m, owned by root
+// with mode. A relative path is relative to the home directory.
+func InstallFileMode(in io.Reader, m Machine, to string, mode os.FileMode) error {
 	dir := filepath.Dir(to)
-	out, stderr, err := m.SSH(fmt.Sprintf("sudo mkdir -p %s", dir))
+	out, stderr, err := m.SSH(fmt.Sprintf("sudo mkdir -p -- %s", shellQuote(dir)))
 	if err != nil {
 		return fmt.Errorf("failed creating directory %s: %s: %s", dir, stderr, err)
 	}
 
+	// write file to fs from stdin
+	out, err = writeCommand(m, fmt.Sprintf("sudo install -m %04o /dev/stdin %s", mode.Perm(), shellQuote(to)), in)
+	if err != nil {
+		return fmt.Errorf("failed executing install: %q: %v", out, err)
+	}
+
+	return nil
+}
+
+// writeCommand runs cmd on m with in as its stdin, streaming it, and
+// returns its combined output.
+func writeCommand(m Machine, cmd string, in io.Reader) ([]byte, error) {
 	client, err := m.SSHClient()
 	if err != nil {
-		return fmt.Errorf("failed creating SSH client: %v", err)
+		return nil, fmt.Errorf("failed creating SSH client: %v", err)
 	}
 
 	defer client.Close()
 
 	session, err := client.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed creating SSH session: %v", err)
+		return nil, fmt.Errorf("failed creating SSH session: %v", err)
 	}
 
 	defer session.Close()
 
-	// write file to fs from stdin
 	session.Stdin = in
-	out, err = session.CombinedOutput(fmt.Sprintf("sudo install -m 0755 /dev/stdin %s", to))
-	if err != nil {
-		return fmt.Errorf("failed executing install: %q: %v", out, err)
-	}
-
-	return nil
+	return session.CombinedOutput(cmd)
 }
 
 // NewMachines spawns n instances in cluster c, with
 // each instance passed the same userdata.
 func NewMachines(c Cluster, userdata *conf.UserData, n int) ([]Machine, error) {
+	return newMachines(n, func(int) (Machine, error) {
+		return c.NewMachine(userdata)
+	})
+}
+
+// NewMachinesRetry starts a machine in cluster c for each of userdata,
+// returning them in the same order. A machine which fails to start is
+// retried as b says, unless its error is a ConfigError. A machine left
+// half-created by a failed attempt is destroyed before the next.
+func NewMachinesRetry(c Cluster, userdata []*conf.UserData, b util.Backoff) ([]Machine, error) {
+	return newMachines(len(userdata), func(i int) (Machine, error) {
+		var m Machine
+		err := util.RetryBackoff(b, func(err error) bool {
+			_, permanent := err.(*ConfigError)
+			return !permanent
+		}, func() error {
+			var err error
+			m, err = c.NewMachine(userdata[i])
+			if err != nil && m != nil {
+				m.Destroy()
+				m = nil
+			}
+			return err
+		})
+		return m, err
+	})
+}
+
+// newMachines calls newMachine for each index up to n in parallel,
+// returning the machines in index order. If any call fails the machines
+// started are destroyed.
+func newMachines(n int, newMachine func(i int) (Machine, error)) ([]Machine, error) {
 	var wg sync.WaitGroup
 
-	mchan := make(chan Machine, n)
-	errchan := make(chan error, n)
+	machs := make([]Machine, n)
+	errs := make([]error, n)
 
 	for i := 0; i < n; i++ {
 		wg.Add(1)
-		go func() {
+		go func(i int) {
 			defer wg.Done()
-			m, err := c.NewMachine(userdata)
-			if err != nil {
-				errchan <- err
-			}
-			if m != nil {
-				mchan <- m
-			}
-		}()
+			machs[i], errs[i] = newMachine(i)
+		}(i)
 	}
 
 	wg.Wait()
-	close(mchan)
-	close(errchan)
-
-	machs := []Machine{}
-
-	for m := range mchan {
-		machs = append(machs, m)
-	}
 
-	if firsterr, ok := <-errchan; ok {
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
 		for _, m := range machs {
-			m.Destroy()
+			if m != nil {
+				m.Destroy()
+			}
 		}
-		return nil, firsterr
+		return nil, err
 	}
 
 	return machs, nil
@@ -286,7 +471,7 @@ func CheckMachine(ctx context.Context, m Machine) error {
 	}
 
 	if err := util.Retry(sshRetries, sshTimeout, sshChecker); err != nil {
-		return fmt.Errorf("ssh unreachable: %v", err)
+		return fmt.Errorf("ssh unreachable: %v%s", err, diagnoseBoot(m))
 	}
 
 	// ensure we're talking to a Container Linux system
@@ -299,6 +484,13 @@ func CheckMachine(ctx context.Context, m Machine) error {
 		return fmt.Errorf("not a Container Linux instance")
 	}
 
+	if !m.RuntimeConf().NoUserDataWait {
+		// ensure the user data was applied before the test looks
+		if err := waitUserData(ctx, m); err != nil {
+			return err
+		}
+	}
+
 	if !m.RuntimeConf().AllowFailedUnits {
 		// ensure no systemd units failed during boot
 		out, stderr, err = m.SSH("systemctl --no-legend --state failed list-units")
@@ -0,0 +1,126 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/platform/conf"
+	"github.com/coreos/mantle/util"
+)
+
+// flakyCluster fails to start its first failures machines, leaving
+// each failed one half-created.
+type flakyCluster struct {
+	Cluster
+	failures int
+	err      error
+
+	mu        sync.Mutex
+	attempts  int
+	destroyed int
+}
+
+type flakyMachine struct {
+	Machine
+	c        *flakyCluster
+	userdata *conf.UserData
+}
+
+func (m *flakyMachine) Destroy() {
+	m.c.mu.Lock()
+	defer m.c.mu.Unlock()
+	m.c.destroyed++
+}
+
+func (c *flakyCluster) NewMachine(userdata *conf.UserData) (Machine, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts++
+	m := &flakyMachine{c: c, userdata: userdata}
+	if c.attempts <= c.failures {
+		return m, c.err
+	}
+	return m, nil
+}
+
+func TestNewMachinesRetry(t *testing.T) {
+	b := util.Backoff{Attempts: 3, Base: time.Millisecond}
+
+	c := &flakyCluster{failures: 2, err: errors.New("rate limited")}
+	ms, err := NewMachinesRetry(c, []*conf.UserData{nil}, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 1 || c.attempts != 3 || c.destroyed != 2 {
+		t.Errorf("started %d machines in %d attempts, destroying %d; want 1, 3, 2", len(ms), c.attempts, c.destroyed)
+	}
+
+	c = &flakyCluster{failures: 3, err: errors.New("rate limited")}
+	_, err = NewMachinesRetry(c, []*conf.UserData{nil}, b)
+	if err == nil || !strings.Contains(err.Error(), "rate limited (after 3 attempts)") {
+		t.Errorf("expected error after 3 attempts, got %v", err)
+	}
+	if c.destroyed != 3 {
+		t.Errorf("destroyed %d half-created machines, want 3", c.destroyed)
+	}
+
+	c = &flakyCluster{failures: 3, err: &ConfigError{errors.New("invalid config")}}
+	_, err = NewMachinesRetry(c, []*conf.UserData{nil}, b)
+	if err == nil || !strings.Contains(err.Error(), "invalid config (after 1 attempt)") {
+		t.Errorf("expected config error without retries, got %v", err)
+	}
+	if c.attempts != 1 {
+		t.Errorf("retried a config error %d times", c.attempts-1)
+	}
+}
+
+func TestNewMachinesRetryOrder(t *testing.T) {
+	userdata := []*conf.UserData{conf.CloudConfig("a"), conf.CloudConfig("b"), conf.CloudConfig("c")}
+	c := &flakyCluster{failures: 2, err: errors.New("rate limited")}
+	ms, err := NewMachinesRetry(c, userdata, util.Backoff{Attempts: 3, Base: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range ms {
+		if got := m.(*flakyMachine).userdata; got != userdata[i] {
+			t.Errorf("machine %d got config %v, want %v", i, got, userdata[i])
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := util.Backoff{Base: 10 * time.Second, Max: time.Minute}
+	for _, tt := range []struct {
+		failed int
+		jitter float64
+		want   time.Duration
+	}{
+		{1, 0, 5 * time.Second},
+		{1, 0.5, 7500 * time.Millisecond},
+		{2, 0, 10 * time.Second},
+		{3, 0.99, 40*time.Second - 200*time.Millisecond},
+		{4, 0, 30 * time.Second}, // capped
+		{50, 0, 30 * time.Second},
+	} {
+		if got := b.Delay(tt.failed, tt.jitter); got != tt.want {
+			t.Errorf("Delay(%d, %v) = %v, want %v", tt.failed, tt.jitter, got, tt.want)
+		}
+	}
+}
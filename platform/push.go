@@ -0,0 +1,226 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultPushChunkSize = 16 << 20
+	defaultPushRetries   = 5
+)
+
+// pushRetryDelay is how much longer PushFile waits before each retry of
+// a chunk.
+var pushRetryDelay = time.Second
+
+// PushOptions configures PushFile. The zero value is usable.
+type PushOptions struct {
+	ChunkSize      int64       // bytes per verified chunk; defaults to 16 MiB
+	BytesPerSecond int64       // bandwidth limit; 0 for unlimited
+	Retries        int         // attempts per chunk before giving up; defaults to 5
+	Mode           os.FileMode // of the file on the machine; defaults to the local file's
+
+	// Progress, if set, is called after every chunk with the number of
+	// bytes on the machine so far.
+	Progress func(done, total int64)
+}
+
+// PushFile copies the local file path to to on m in chunks, verifying the
+// sha256 of each chunk as written on the machine. A failed chunk is
+// retried over a new connection. An interrupted push resumes from the
+// last good chunk when PushFile is called again with the same paths and
+// chunk size, since chunks are kept in to+".partial" until the file is
+// complete.
+func PushFile(m Machine, path, to string, opts PushOptions) error {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultPushChunkSize
+	}
+	if opts.Retries <= 0 {
+		opts.Retries = defaultPushRetries
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	total := st.Size()
+	if opts.Mode == 0 {
+		opts.Mode = st.Mode().Perm()
+	}
+
+	sums, err := chunkSums(f, opts.ChunkSize)
+	if err != nil {
+		return fmt.Errorf("hashing %s: %v", path, err)
+	}
+
+	partial := to + ".partial"
+	if out, stderr, err := m.SSH("sudo mkdir -p -- " + shellQuote(filepath.Dir(to))); err != nil {
+		return fmt.Errorf("failed creating directory %s: %s%s: %v", filepath.Dir(to), out, stderr, err)
+	}
+
+	// Find where to resume by comparing the chunks already on the machine.
+	script := fmt.Sprintf(`f=%s; [ -f "$f" ] || exit 0; size=$(stat -c %%s "$f"); i=0; while [ $((i*%d)) -lt $size ]; do dd if="$f" bs=%d skip=$i count=1 status=none | sha256sum | cut -d" " -f1; i=$((i+1)); done`,
+		shellQuote(partial), opts.ChunkSize, opts.ChunkSize)
+	out, stderr, err := m.SSH("sudo sh -c " + shellQuote(script))
+	if err != nil {
+		return fmt.Errorf("checking %s: %s: %v", partial, stderr, err)
+	}
+	start := resumeChunk(strings.Fields(string(out)), sums)
+	if out, stderr, err := m.SSH(fmt.Sprintf("sudo truncate -s %d -- %s", int64(start)*opts.ChunkSize, shellQuote(partial))); err != nil {
+		return fmt.Errorf("truncating %s: %s%s: %v", partial, out, stderr, err)
+	}
+	if start > 0 {
+		plog.Infof("Resuming push of %s to %s after %d chunks", path, m.ID(), start)
+	}
+
+	var client *ssh.Client
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	for i := start; i < len(sums); i++ {
+		offset := int64(i) * opts.ChunkSize
+		var err error
+		for attempt := 0; attempt < opts.Retries; attempt++ {
+			if attempt > 0 {
+				plog.Warningf("Retrying chunk %d of %s to %s: %v", i, path, m.ID(), err)
+				time.Sleep(time.Duration(attempt) * pushRetryDelay)
+			}
+			if client == nil {
+				if client, err = m.SSHClient(); err != nil {
+					continue
+				}
+			}
+			chunk := io.NewSectionReader(f, offset, opts.ChunkSize)
+			if err = pushChunk(client, chunk, partial, i, sums[i], opts); err == nil {
+				break
+			}
+			client.Close()
+			client = nil
+		}
+		if err != nil {
+			return fmt.Errorf("pushing chunk %d of %s: %v", i, path, err)
+		}
+
+		if opts.Progress != nil {
+			done := offset + opts.ChunkSize
+			if done > total {
+				done = total
+			}
+			opts.Progress(done, total)
+		}
+	}
+
+	if out, stderr, err := m.SSH(fmt.Sprintf("sudo chmod %04o -- %s && sudo mv -- %s %s", opts.Mode.Perm(), shellQuote(partial), shellQuote(partial), shellQuote(to))); err != nil {
+		return fmt.Errorf("installing %s: %s%s: %v", to, out, stderr, err)
+	}
+	return nil
+}
+
+// pushChunk writes chunk number i to partial and checks that what was
+// written has the expected sum.
+func pushChunk(client *ssh.Client, chunk io.Reader, partial string, i int, sum string, opts PushOptions) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if opts.BytesPerSecond > 0 {
+		chunk = &throttledReader{r: chunk, rate: opts.BytesPerSecond, start: time.Now()}
+	}
+	session.Stdin = chunk
+
+	script := fmt.Sprintf(`dd of=%s bs=%d seek=%d count=1 iflag=fullblock conv=notrunc status=none && dd if=%s bs=%d skip=%d count=1 status=none | sha256sum | cut -d" " -f1`,
+		shellQuote(partial), opts.ChunkSize, i, shellQuote(partial), opts.ChunkSize, i)
+	out, err := session.CombinedOutput("sudo sh -c " + shellQuote(script))
+	if err != nil {
+		return fmt.Errorf("%q: %v", out, err)
+	}
+	if got := strings.TrimSpace(string(out)); got != sum {
+		return fmt.Errorf("sha256 mismatch: wrote %s, expected %s", got, sum)
+	}
+	return nil
+}
+
+// resumeChunk returns the number of chunks to keep of a partial file on
+// the machine, whose chunks have the sums remote: those matching sums up
+// to the first which doesn't.
+func resumeChunk(remote, sums []string) int {
+	i := 0
+	for i < len(remote) && i < len(sums) && remote[i] == sums[i] {
+		i++
+	}
+	return i
+}
+
+// chunkSums returns the hex sha256 of each chunk of f.
+func chunkSums(f *os.File, chunkSize int64) ([]string, error) {
+	var sums []string
+	for offset := int64(0); ; offset += chunkSize {
+		h := sha256.New()
+		n, err := io.Copy(h, io.NewSectionReader(f, offset, chunkSize))
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 && offset > 0 {
+			return sums, nil
+		}
+		sums = append(sums, hex.EncodeToString(h.Sum(nil)))
+		if n < chunkSize {
+			return sums, nil
+		}
+	}
+}
+
+// throttledReader limits reads from r to rate bytes per second on average.
+type throttledReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// keep individual reads small so the rate is smooth
+	if max := t.rate / 10; max > 0 && int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	due := t.start.Add(time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/mantle/network/mockssh"
+)
+
+// pushMachine is a shellMachine whose connections for chunks can fail
+// and whose chunk writes can be corrupted. It records which chunks are
+// written.
+type pushMachine struct {
+	*shellMachine
+	failClients int // SSHClient fails this many more times
+	corrupt     int // this many more chunk writes are corrupted
+	written     []int
+}
+
+var chunkSeekRe = regexp.MustCompile(`seek=(\d+)`)
+
+func (m *pushMachine) ID() string { return "m" }
+
+func (m *pushMachine) SSHClient() (*ssh.Client, error) {
+	if m.failClients > 0 {
+		m.failClients--
+		return nil, errors.New("connection refused")
+	}
+	return mockssh.NewMockClient(func(s *mockssh.Session) {
+		if match := chunkSeekRe.FindStringSubmatch(s.Exec); match != nil {
+			i, _ := strconv.Atoi(match[1])
+			m.written = append(m.written, i)
+			if m.corrupt > 0 {
+				m.corrupt--
+				s.Stdin = io.MultiReader(strings.NewReader("X"), s.Stdin)
+			}
+		}
+		m.run(s)
+	}), nil
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestChunkSums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "platform-push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		contents string
+		expected []string
+	}{
+		{"", []string{sha256Hex("")}},
+		{"abc", []string{sha256Hex("abc")}},
+		{"abcd", []string{sha256Hex("abcd")}},
+		{"abcdefgh", []string{sha256Hex("abcd"), sha256Hex("efgh")}},
+		{"abcdefghi", []string{sha256Hex("abcd"), sha256Hex("efgh"), sha256Hex("i")}},
+	} {
+		path := filepath.Join(dir, "file")
+		if err := ioutil.WriteFile(path, []byte(tt.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums, err := chunkSums(f, 4)
+		f.Close()
+		if err != nil {
+			t.Errorf("%q: %v", tt.contents, err)
+		} else if !reflect.DeepEqual(sums, tt.expected) {
+			t.Errorf("%q: got %d sums %v, expected %v", tt.contents, len(sums), sums, tt.expected)
+		}
+	}
+}
+
+func TestResumeChunk(t *testing.T) {
+	sums := []string{"a", "b", "c"}
+	for _, tt := range []struct {
+		remote   []string
+		expected int
+	}{
+		{nil, 0},
+		{[]string{"a"}, 1},
+		{[]string{"a", "b"}, 2},
+		{[]string{"a", "x", "c"}, 1},
+		{[]string{"x", "b"}, 0},
+		{[]string{"a", "b", "c"}, 3},
+		// a longer file from another push
+		{[]string{"a", "b", "c", "d"}, 3},
+	} {
+		if got := resumeChunk(tt.remote, sums); got != tt.expected {
+			t.Errorf("%v: got %d, expected %d", tt.remote, got, tt.expected)
+		}
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	r := &throttledReader{r: strings.NewReader(strings.Repeat("x", 300)), rate: 1000, start: time.Now()}
+	buf := make([]byte, 300)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 100 {
+		t.Errorf("read %d bytes at once, expected a tenth of the rate", n)
+	}
+
+	start := time.Now()
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	// 300 bytes at 1000 bytes per second take 300ms, of which the
+	// first read waited 100ms
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("read the last 200 bytes in %v, expected 200ms", elapsed)
+	}
+}
+
+func TestPushFile(t *testing.T) {
+	defer func(d time.Duration) { pushRetryDelay = d }(pushRetryDelay)
+	pushRetryDelay = time.Millisecond
+
+	contents := strings.Repeat("0123456789", 100)
+	const chunkSize = 64
+	to := "bin/it's a file"
+
+	for _, tt := range []struct {
+		name    string
+		machine pushMachine
+		partial string
+		written []int
+	}{
+		{
+			name:    "fresh",
+			written: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		},
+		{
+			name:    "retried",
+			machine: pushMachine{failClients: 1, corrupt: 1},
+			written: []int{0, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		},
+		{
+			name:    "resumed",
+			partial: contents[:2*chunkSize] + "garbage",
+			written: []int{2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "platform-push")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			local := filepath.Join(dir, "local")
+			writeTestFile(t, local, contents, 0644)
+			m := &tt.machine
+			m.shellMachine = &shellMachine{home: filepath.Join(dir, "home")}
+			if tt.partial != "" {
+				writeTestFile(t, filepath.Join(m.home, to+".partial"), tt.partial, 0644)
+			} else if err := os.Mkdir(m.home, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := PushFile(m, local, to, PushOptions{ChunkSize: chunkSize, Mode: 0600}); err != nil {
+				t.Fatal(err)
+			}
+			checkTestFile(t, filepath.Join(m.home, to), contents, 0600)
+			if _, err := os.Stat(filepath.Join(m.home, to+".partial")); !os.IsNotExist(err) {
+				t.Errorf("partial file left behind: %v", err)
+			}
+			if !reflect.DeepEqual(m.written, tt.written) {
+				t.Errorf("wrote chunks %v, expected %v", m.written, tt.written)
+			}
+		})
+	}
+}
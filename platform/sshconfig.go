@@ -0,0 +1,90 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SetSSHProxyCommand sets the ProxyCommand written to the cluster's
+// ssh_config, for platforms whose machines are not directly reachable.
+// ssh expands %h and %p to the machine's address and port.
+func (bc *BaseCluster) SetSSHProxyCommand(cmd string) {
+	bc.machlock.Lock()
+	defer bc.machlock.Unlock()
+	bc.sshProxyCommand = cmd
+}
+
+// SSHConfigPath returns the path of an ssh_config file with a Host entry
+// for each machine of the cluster, named by machine ID, for use with
+// `ssh -F`. It is kept up to date while the cluster exists and removed
+// when the cluster is destroyed. Connections go through the cluster's SSH
+// agent, so they only work while kola is running.
+func (bc *BaseCluster) SSHConfigPath() string {
+	return filepath.Join(bc.rconf.OutputDir, "ssh_config")
+}
+
+// writeSSHConfig rewrites the ssh_config. machlock must be held.
+func (bc *BaseCluster) writeSSHConfig() {
+	if bc.rconf.OutputDir == "" {
+		return
+	}
+
+	ids := make([]string, 0, len(bc.machmap))
+	for id := range bc.machmap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Machines of cluster %s; use with ssh -F %s <machine>\n", bc.name, bc.SSHConfigPath())
+	for _, id := range ids {
+		fmt.Fprintf(&buf, "\nHost %s\n", id)
+		fmt.Fprintf(&buf, "\tHostName %s\n", bc.machmap[id].IP())
+		fmt.Fprintf(&buf, "\tUser %s\n", bc.agent.User)
+		fmt.Fprintf(&buf, "\tIdentityAgent %s\n", bc.agent.Socket)
+		fmt.Fprintf(&buf, "\tIdentitiesOnly no\n")
+		fmt.Fprintf(&buf, "\tStrictHostKeyChecking no\n")
+		fmt.Fprintf(&buf, "\tUserKnownHostsFile /dev/null\n")
+		if bc.sshProxyCommand != "" {
+			fmt.Fprintf(&buf, "\tProxyCommand %s\n", bc.sshProxyCommand)
+		}
+	}
+
+	// write and rename so readers never see a partial file
+	path := bc.SSHConfigPath()
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		plog.Warningf("Writing %s: %v", path, err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		plog.Warningf("Writing %s: %v", path, err)
+	}
+}
+
+func (bc *BaseCluster) removeSSHConfig() {
+	if bc.rconf.OutputDir == "" {
+		return
+	}
+	if err := os.Remove(bc.SSHConfigPath()); err != nil && !os.IsNotExist(err) {
+		plog.Warningf("Removing %s: %v", bc.SSHConfigPath(), err)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Upload copies the local file local to the path remote on m, owned by
+// root with the local file's permissions. A relative remote path is
+// relative to the home directory. The file is streamed, so it may be of
+// any size.
+func Upload(m Machine, local, remote string) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("uploading %s: not a regular file", local)
+	}
+	if err := InstallFileMode(f, m, remote, fi.Mode()); err != nil {
+		return fmt.Errorf("uploading %s to %s: %v", local, remote, err)
+	}
+	return nil
+}
+
+// Download copies the file remote on m to the local path local, with
+// the remote file's permissions. The file is streamed to a temporary
+// file next to local, which replaces local only once complete, so a
+// failed download leaves nothing behind.
+func Download(m Machine, remote, local string) error {
+	out, stderr, err := m.SSH("sudo stat -c %a -- " + shellQuote(remote))
+	if err != nil {
+		return fmt.Errorf("downloading %s: %v: %s", remote, err, stderr)
+	}
+	mode, err := strconv.ParseUint(strings.TrimSpace(string(out)), 8, 32)
+	if err != nil {
+		return fmt.Errorf("downloading %s: parsing mode %q: %v", remote, out, err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(local), "."+filepath.Base(local)+".")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	in, err := readCommand(m, "sudo cat -- "+shellQuote(remote))
+	if err != nil {
+		return fmt.Errorf("downloading %s: %v", remote, err)
+	}
+	_, err = io.Copy(f, in)
+	if cerr := in.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("downloading %s: %v", remote, err)
+	}
+
+	if err := f.Chmod(os.FileMode(mode).Perm()); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), local); err != nil {
+		return err
+	}
+	f = nil
+	return nil
+}
+
+// UploadDir copies the contents of the local directory localDir into
+// remoteDir on m, creating it if needed. The tree is streamed to tar on
+// the machine, which keeps the modes and modification times of files,
+// directories and symlinks; everything is owned by root.
+func UploadDir(m Machine, localDir, remoteDir string) error {
+	fi, err := os.Stat(localDir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("uploading %s: not a directory", localDir)
+	}
+
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := writeTar(pw, localDir)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+
+	dir := shellQuote(remoteDir)
+	out, err := writeCommand(m, fmt.Sprintf("sudo mkdir -p -- %s && sudo tar -C %s --no-same-owner -xpf -", dir, dir), pr)
+	// stop the writer if tar exited before reading everything
+	pr.Close()
+	if werr := <-written; werr != nil && werr != io.ErrClosedPipe {
+		return fmt.Errorf("uploading %s: %v", localDir, werr)
+	}
+	if err != nil {
+		return fmt.Errorf("uploading %s to %s: %q: %v", localDir, remoteDir, out, err)
+	}
+	return nil
+}
+
+// writeTar writes the tree under dir, without dir itself, to w as a tar
+// archive.
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// shellQuote quotes s as a single word for the machine's shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/mantle/network/mockssh"
+)
+
+// shellMachine is a Machine whose commands run in a local shell, in its
+// home directory, without sudo.
+type shellMachine struct {
+	Machine
+	home string
+}
+
+func (m *shellMachine) SSHClient() (*ssh.Client, error) {
+	return mockssh.NewMockClient(m.run), nil
+}
+
+// run runs the command of s in the local shell.
+func (m *shellMachine) run(s *mockssh.Session) {
+	cmd := exec.Command("sh", "-c", strings.Replace(s.Exec, "sudo ", "", -1))
+	cmd.Dir = m.home
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = s.Stdin, s.Stdout, s.Stderr
+	code := 0
+	if err := cmd.Run(); err != nil {
+		code = 1
+		if exit, ok := err.(*exec.ExitError); ok {
+			code = exit.Sys().(interface{ ExitStatus() int }).ExitStatus()
+		}
+	}
+	s.Exit(code)
+}
+
+func (m *shellMachine) SSH(cmd string) ([]byte, []byte, error) {
+	client, _ := m.SSHClient()
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer session.Close()
+	var stdout, stderr bytes.Buffer
+	session.Stdout, session.Stderr = &stdout, &stderr
+	err = session.Run(cmd)
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+func writeTestFile(t *testing.T, path, contents string, mode os.FileMode) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), mode); err != nil {
+		t.Fatal(err)
+	}
+	// not subject to the umask
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkTestFile(t *testing.T, path, contents string, mode os.FileMode) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if fi.Mode() != mode {
+		t.Errorf("%s has mode %v, want %v", path, fi.Mode(), mode)
+	}
+	if !fi.Mode().IsRegular() {
+		return
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != contents {
+		t.Errorf("%s holds %q (%v), want %q", path, b, err, contents)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "platform-transfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "local")
+	m := &shellMachine{home: filepath.Join(dir, "home")}
+	if err := os.Mkdir(m.home, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	big := strings.Repeat("data\n", 100000)
+	writeTestFile(t, filepath.Join(local, "script"), big, 0750)
+	if err := Upload(m, filepath.Join(local, "script"), "bin/it's"); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(m.home, "bin/it's"), big, 0750)
+
+	if err := Download(m, "bin/it's", filepath.Join(local, "back")); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(local, "back"), big, 0750)
+
+	if err := Download(m, "missing", filepath.Join(local, "missing")); err == nil {
+		t.Error("downloaded a missing file")
+	}
+	if names, _ := filepath.Glob(filepath.Join(local, ".missing*")); len(names) > 0 {
+		t.Errorf("failed download left %v", names)
+	}
+
+	tree := filepath.Join(dir, "tree")
+	writeTestFile(t, filepath.Join(tree, "a"), "a", 0600)
+	writeTestFile(t, filepath.Join(tree, "sub/b"), "b", 0755)
+	if err := os.Symlink("sub/b", filepath.Join(tree, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := UploadDir(m, tree, "fixtures"); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(m.home, "fixtures/a"), "a", 0600)
+	checkTestFile(t, filepath.Join(m.home, "fixtures/sub/b"), "b", 0755)
+	if link, err := os.Readlink(filepath.Join(m.home, "fixtures/link")); err != nil || link != "sub/b" {
+		t.Errorf("symlink points to %q (%v)", link, err)
+	}
+
+	if err := UploadDir(m, filepath.Join(tree, "a"), "fixtures"); err == nil {
+		t.Error("uploaded a file as a directory")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+)
+
+// NoUserDataLimit declares that a platform documents no limit on the size
+// of machine configs.
+const NoUserDataLimit = -1
+
+var userDataLimits = make(map[Name]int)
+
+// DeclareUserDataLimit records the size in bytes of the largest rendered
+// config machines of platform accept, or NoUserDataLimit. It returns
+// limit, for use in the platform's package variables. Every platform must
+// declare its limit; NewBaseCluster fails for platforms which have not.
+func DeclareUserDataLimit(platform Name, limit int) int {
+	if _, ok := userDataLimits[platform]; ok {
+		panic(fmt.Sprintf("platform: user-data limit of %v declared twice", platform))
+	}
+	userDataLimits[platform] = limit
+	return limit
+}
+
+// UserDataLimit returns the limit declared by platform.
+func UserDataLimit(platform Name) (int, bool) {
+	limit, ok := userDataLimits[platform]
+	return limit, ok
+}
+
+// checkUserDataSize fails if rendered, a config for platform, is too
+// large for the platform to accept, rather than leaving the platform to
+// reject the machine after its other resources are set up.
+func checkUserDataSize(platform Name, rendered string) error {
+	limit, ok := UserDataLimit(platform)
+	if !ok || limit == NoUserDataLimit {
+		return nil
+	}
+	if size := len(rendered); size > limit {
+		return fmt.Errorf("rendered config is %d bytes, exceeding the %d byte limit of %v", size, limit, platform)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckUserDataSize(t *testing.T) {
+	DeclareUserDataLimit("test-limited", 10)
+	DeclareUserDataLimit("test-unlimited", NoUserDataLimit)
+	defer func() {
+		delete(userDataLimits, "test-limited")
+		delete(userDataLimits, "test-unlimited")
+	}()
+
+	if err := checkUserDataSize("test-limited", "0123456789"); err != nil {
+		t.Errorf("config at the limit rejected: %v", err)
+	}
+	err := checkUserDataSize("test-limited", "0123456789a")
+	if err == nil || !strings.Contains(err.Error(), "11 bytes, exceeding the 10 byte limit") {
+		t.Errorf("expected error with size and limit, got %v", err)
+	}
+	if err := checkUserDataSize("test-unlimited", strings.Repeat("a", 1<<20)); err != nil {
+		t.Errorf("unlimited platform rejected config: %v", err)
+	}
+}
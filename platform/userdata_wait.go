@@ -0,0 +1,131 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// userDataTimeout is how long CheckMachine waits for coreos-cloudinit
+	// to finish applying the user data.
+	userDataTimeout = 2 * time.Minute
+
+	// userDataPollInterval is how often it checks.
+	userDataPollInterval = time.Second
+
+	// userDataJournalLines is how much of the journal of a failed unit
+	// the error includes.
+	userDataJournalLines = 30
+)
+
+// cloudinitUnits match the units in which coreos-cloudinit applies user
+// data, from the OEM's and the user's cloud-configs alike.
+var cloudinitUnits = []string{
+	"user-cloudinit*",
+	"user-configdrive*",
+	"oem-cloudinit*",
+	"coreos-cloudinit*",
+}
+
+// waitUserData waits until the user data of m has been applied, so that
+// tests don't see a half-configured machine, and fails if applying it
+// failed. Ignition is done before the machine leaves the initramfs, so
+// its journal only needs checking for failures; coreos-cloudinit runs
+// alongside the rest of the boot and is waited for.
+func waitUserData(ctx context.Context, m Machine) error {
+	out, _, err := m.SSH("sudo journalctl -b --no-pager -o cat -t ignition")
+	if err != nil {
+		return fmt.Errorf("reading Ignition's journal: %v", err)
+	}
+	if excerpt := ignitionFailure(out); excerpt != "" {
+		return fmt.Errorf("Ignition failed to apply the config:\n%s", excerpt)
+	}
+
+	list := "systemctl list-units --all --no-legend --plain " + strings.Join(cloudinitUnits, " ")
+	deadline := time.Now().Add(userDataTimeout)
+	for {
+		out, stderr, err := m.SSH(list)
+		if err != nil {
+			return fmt.Errorf("listing cloudinit units: %v: %s", err, stderr)
+		}
+		pending, failed := cloudinitState(out)
+		if len(failed) > 0 {
+			return fmt.Errorf("coreos-cloudinit failed to apply the config:\n%s", unitJournals(m, failed))
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("coreos-cloudinit still running after %v:\n%s", userDataTimeout, unitJournals(m, pending))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(userDataPollInterval):
+		}
+	}
+}
+
+// cloudinitState parses systemctl list-units --plain output into the
+// units which are still starting and those which failed.
+func cloudinitState(out []byte) (pending, failed []string) {
+	for _, line := range strings.Split(string(out), "\n") {
+		// UNIT LOAD ACTIVE SUB DESCRIPTION...
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		switch fields[2] {
+		case "activating", "reloading":
+			pending = append(pending, fields[0])
+		case "failed":
+			failed = append(failed, fields[0])
+		}
+	}
+	return pending, failed
+}
+
+// ignitionFailure returns the end of Ignition's journal if it reports a
+// failure, or "" if Ignition succeeded or didn't run this boot.
+func ignitionFailure(out []byte) string {
+	if !bytes.Contains(out, []byte("Ignition failed")) && !bytes.Contains(out, []byte("CRITICAL")) {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) > userDataJournalLines {
+		lines = lines[len(lines)-userDataJournalLines:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// unitJournals returns the end of the journal of each of units on m, for
+// error messages.
+func unitJournals(m Machine, units []string) string {
+	var b bytes.Buffer
+	for _, unit := range units {
+		out, _, err := m.SSH(fmt.Sprintf("sudo journalctl -b --no-pager -n %d -u %s", userDataJournalLines, shellQuote(unit)))
+		if err != nil {
+			fmt.Fprintf(&b, "%s: reading journal: %v\n", unit, err)
+			continue
+		}
+		fmt.Fprintf(&b, "%s:\n%s\n", unit, bytes.TrimSpace(out))
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bootingMachine answers the commands of waitUserData, with cloudinit
+// listing units in states, one per poll.
+type bootingMachine struct {
+	Machine
+	ignition string
+	states   []string
+	polls    int
+}
+
+func (m *bootingMachine) SSH(cmd string) ([]byte, []byte, error) {
+	switch {
+	case strings.Contains(cmd, "-t ignition"):
+		return []byte(m.ignition), nil, nil
+	case strings.HasPrefix(cmd, "systemctl list-units"):
+		state := m.states[m.polls]
+		if m.polls < len(m.states)-1 {
+			m.polls++
+		}
+		return []byte("user-cloudinit@var-lib-coreos\\x2dinstall-user_data.service loaded " + state + " Load cloud-config\n"), nil, nil
+	case strings.Contains(cmd, "journalctl"):
+		return []byte("coreos-cloudinit[712]: error: invalid cloud-config\n"), nil, nil
+	}
+	return nil, nil, nil
+}
+
+func TestCloudinitState(t *testing.T) {
+	out := []byte(`oem-cloudinit.service loaded active exited Run cloudinit from the OEM
+user-cloudinit-proc-cmdline.service loaded inactive dead Load cloud-config from url defined in /proc/cmdline
+user-cloudinit@var-lib-coreos\x2dinstall-user_data.service loaded activating start Load cloud-config from /var/lib/coreos-install/user_data
+user-configdrive.service loaded failed failed Load cloud-config from config drive
+`)
+	pending, failed := cloudinitState(out)
+	if want := []string{`user-cloudinit@var-lib-coreos\x2dinstall-user_data.service`}; !reflect.DeepEqual(pending, want) {
+		t.Errorf("pending %v, want %v", pending, want)
+	}
+	if want := []string{"user-configdrive.service"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed %v, want %v", failed, want)
+	}
+}
+
+func TestIgnitionFailure(t *testing.T) {
+	if s := ignitionFailure([]byte("files: op(1): [finished] writing file\nIgnition finished successfully\n")); s != "" {
+		t.Errorf("successful run reported as %q", s)
+	}
+	if s := ignitionFailure(nil); s != "" {
+		t.Errorf("no run reported as %q", s)
+	}
+	out := strings.Repeat("files: op(1): [started]\n", 2*userDataJournalLines) + "CRITICAL : files: op(2): failed to fetch\n"
+	s := ignitionFailure([]byte(out))
+	if lines := strings.Split(s, "\n"); len(lines) != userDataJournalLines || !strings.HasPrefix(lines[len(lines)-1], "CRITICAL") {
+		t.Errorf("excerpt doesn't end with the failure:\n%s", s)
+	}
+}
+
+func TestWaitUserData(t *testing.T) {
+	m := &bootingMachine{states: []string{"activating start", "activating start", "inactive dead"}}
+	if err := waitUserData(context.Background(), m); err != nil || m.polls != 2 {
+		t.Errorf("waited %d polls: %v", m.polls, err)
+	}
+
+	m = &bootingMachine{states: []string{"failed failed"}}
+	if err := waitUserData(context.Background(), m); err == nil || !strings.Contains(err.Error(), "invalid cloud-config") {
+		t.Errorf("failed cloudinit returned %v", err)
+	}
+
+	m = &bootingMachine{ignition: "Ignition failed: bad config", states: []string{"inactive dead"}}
+	if err := waitUserData(context.Background(), m); err == nil || !strings.Contains(err.Error(), "bad config") {
+		t.Errorf("failed Ignition returned %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m = &bootingMachine{states: []string{"activating start"}}
+	if err := waitUserData(ctx, m); err != context.Canceled {
+		t.Errorf("canceled wait returned %v", err)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/terminal"
@@ -106,20 +107,45 @@ func StartReboot(m Machine) error {
 
 // RebootMachine will reboot a given machine, provided the machine's journal.
 func RebootMachine(m Machine, j *Journal) error {
+	emitEvent(m, MachineRebooting, "")
 	if err := StartReboot(m); err != nil {
 		return fmt.Errorf("machine %q failed to begin rebooting: %v", m.ID(), err)
 	}
-	return StartMachine(m, j)
+	return checkStartedMachine(m, j)
+}
+
+// KillMachine stops m as if it crashed and frees it. It returns
+// ErrNotSupported unless m implements Killer.
+func KillMachine(m Machine) error {
+	k, ok := m.(Killer)
+	if !ok {
+		return ErrNotSupported
+	}
+	k.Kill()
+	return nil
 }
 
 // StartMachine will start a given machine, provided the machine's journal.
 func StartMachine(m Machine, j *Journal) error {
+	detail := fmt.Sprintf("IP %s, private IP %s", m.IP(), m.PrivateIP())
+	if n, ok := m.(Namer); ok {
+		detail = fmt.Sprintf("name %s, %s", n.Name(), detail)
+	}
+	emitEvent(m, MachineCreated, detail)
+	return checkStartedMachine(m, j)
+}
+
+// checkStartedMachine follows the journal of a machine which is booting
+// and waits for it to be usable.
+func checkStartedMachine(m Machine, j *Journal) error {
 	if err := j.Start(context.TODO(), m); err != nil {
 		return fmt.Errorf("machine %q failed to start: %v", m.ID(), err)
 	}
 	if err := CheckMachine(context.TODO(), m); err != nil {
 		return fmt.Errorf("machine %q failed basic checks: %v", m.ID(), err)
 	}
+	emitEvent(m, MachineSSHReady, "")
+	checkHostname(m)
 	if !m.RuntimeConf().NoEnableSelinux {
 		if err := EnableSelinux(m); err != nil {
 			return fmt.Errorf("machine %q failed to enable selinux: %v", m.ID(), err)
@@ -127,3 +153,23 @@ func StartMachine(m Machine, j *Journal) error {
 	}
 	return nil
 }
+
+// checkHostname warns if a machine whose platform assigns its hostname
+// came up with a different one. User data may legitimately set its own
+// hostname, so a mismatch is not an error.
+func checkHostname(m Machine) {
+	h, ok := m.(Hostnamer)
+	if !ok {
+		return
+	}
+	out, _, err := m.SSH("hostname")
+	if err != nil {
+		plog.Warningf("machine %q: reading hostname: %v", m.ID(), err)
+		return
+	}
+	// the platform may append its domain
+	got := strings.SplitN(strings.TrimSpace(string(out)), ".", 2)[0]
+	if got != h.Hostname() {
+		plog.Warningf("machine %q has hostname %q, expected %q", m.ID(), got, h.Hostname())
+	}
+}
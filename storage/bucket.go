@@ -373,6 +373,79 @@ func (b *Bucket) Delete(ctx context.Context, objName string) error {
 	return nil
 }
 
+// CreateBucket creates the bucket name in project with labels. If maxAge
+// is positive, GCS deletes objects once they are that many days old, in
+// case whoever created them fails to.
+func CreateBucket(ctx context.Context, client *http.Client, project, name string, labels map[string]string, maxAge int64) (*Bucket, error) {
+	b, err := NewBucket(client, "gs://"+name)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := &storage.Bucket{Name: name, Labels: labels}
+	if maxAge > 0 {
+		bucket.Lifecycle = &storage.BucketLifecycle{
+			Rule: []*storage.BucketLifecycleRule{{
+				Action:    &storage.BucketLifecycleRuleAction{Type: "Delete"},
+				Condition: &storage.BucketLifecycleRuleCondition{Age: maxAge},
+			}},
+		}
+	}
+
+	plog.Noticef("Creating %s", b.URL())
+
+	req := b.service.Buckets.Insert(project, bucket)
+	req.Context(ctx)
+	if _, err := req.Do(); err != nil {
+		return nil, b.apiErr("storage.buckets.insert", nil, err)
+	}
+	return b, nil
+}
+
+// DeleteBucket deletes every object in the bucket, including those not
+// fetched, and then the bucket itself.
+func (b *Bucket) DeleteBucket(ctx context.Context) error {
+	if b.writeDryRun {
+		plog.Noticef("Would delete %s", b.URL())
+		return nil
+	}
+
+	var names []string
+	req := b.service.Objects.List(b.name)
+	if err := req.Pages(ctx, func(objs *storage.Objects) error {
+		for _, obj := range objs.Items {
+			names = append(names, obj.Name)
+		}
+		return nil
+	}); err != nil {
+		return b.apiErr("storage.objects.list", nil, err)
+	}
+	for _, name := range names {
+		if err := b.Delete(ctx, name); err != nil && !IsNotFound(err) {
+			return err
+		}
+	}
+
+	plog.Noticef("Deleting %s", b.URL())
+
+	req2 := b.service.Buckets.Delete(b.name)
+	req2.Context(ctx)
+	if err := req2.Do(); err != nil {
+		return b.apiErr("storage.buckets.delete", nil, err)
+	}
+	return nil
+}
+
+// IsNotFound reports whether err is GCS reporting that a bucket or
+// object doesn't exist.
+func IsNotFound(err error) bool {
+	if e, ok := err.(*Error); ok {
+		err = e.Err
+	}
+	e, ok := err.(*googleapi.Error)
+	return ok && e.Code == http.StatusNotFound
+}
+
 // FixPrefix ensures non-empty paths end in a slash but never start with one.
 func FixPrefix(p string) string {
 	if p != "" && !strings.HasSuffix(p, "/") {
@@ -0,0 +1,139 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// Signer signs data with the private key of a service account, as
+// signed URLs need.
+type Signer interface {
+	// Email is the service account's address.
+	Email() string
+
+	// Sign returns the RSA SHA-256 signature of data.
+	Sign(data []byte) ([]byte, error)
+}
+
+type keySigner struct {
+	email string
+	key   *rsa.PrivateKey
+}
+
+// NewKeySigner returns a Signer using the private key in a service
+// account's JSON key file.
+func NewKeySigner(jsonKey []byte) (Signer, error) {
+	conf, err := google.JWTConfigFromJSON(jsonKey)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(conf.PrivateKey)
+	if block == nil {
+		return nil, errors.New("no PEM private key in JSON key")
+	}
+	var key *rsa.PrivateKey
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("private key is a %T, not RSA", parsed)
+		}
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return nil, fmt.Errorf("parsing private key: %v", err)
+	}
+	return &keySigner{email: conf.Email, key: key}, nil
+}
+
+func (s *keySigner) Email() string {
+	return s.email
+}
+
+func (s *keySigner) Sign(data []byte) ([]byte, error) {
+	sum := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+}
+
+type iamSigner struct {
+	client *http.Client
+	email  string
+}
+
+// NewIAMSigner returns a Signer which has the IAM API sign as the
+// service account email, for credentials without a private key such as
+// those of a GCE instance. client must be authorized with a scope
+// allowing it.
+func NewIAMSigner(client *http.Client, email string) Signer {
+	return &iamSigner{client: client, email: email}
+}
+
+func (s *iamSigner) Email() string {
+	return s.email
+}
+
+func (s *iamSigner) Sign(data []byte) ([]byte, error) {
+	body, err := json.Marshal(map[string][]byte{"bytesToSign": data})
+	if err != nil {
+		return nil, err
+	}
+	u := "https://iam.googleapis.com/v1/projects/-/serviceAccounts/" + url.PathEscape(s.email) + ":signBlob"
+	resp, err := s.client.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("signing as %s: %v", s.email, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signing as %s: %s", s.email, resp.Status)
+	}
+	var signed struct {
+		Signature []byte `json:"signature"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		return nil, fmt.Errorf("signing as %s: %v", s.email, err)
+	}
+	return signed.Signature, nil
+}
+
+// SignedURL returns an HTTPS URL from which anyone may GET the object
+// objName of bucket until expires, without credentials.
+func SignedURL(s Signer, bucket, objName string, expires time.Time) (string, error) {
+	path := "/" + bucket + "/" + (&url.URL{Path: objName}).EscapedPath()
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	sig, err := s.Sign([]byte("GET\n\n\n" + exp + "\n" + path))
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"GoogleAccessId": {s.Email()},
+		"Expires":        {exp},
+		"Signature":      {base64.StdEncoding.EncodeToString(sig)},
+	}
+	return "https://storage.googleapis.com" + path + "?" + q.Encode(), nil
+}
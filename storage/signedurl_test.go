@@ -0,0 +1,76 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignedURL(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonKey, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "kola@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewKeySigner(jsonKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := SignedURL(signer, "bucket", "test/a b", time.Unix(1500000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "storage.googleapis.com" || u.EscapedPath() != "/bucket/test/a%20b" {
+		t.Errorf("unexpected URL %s", signed)
+	}
+	q := u.Query()
+	if q.Get("GoogleAccessId") != "kola@project.iam.gserviceaccount.com" || q.Get("Expires") != "1500000000" {
+		t.Errorf("unexpected query %v", q)
+	}
+	sig, err := base64.StdEncoding.DecodeString(q.Get("Signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("GET\n\n\n1500000000\n/bucket/test/a%20b"))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Errorf("signature doesn't verify: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDiskSpace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	free, size, err := DiskSpace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size == 0 || free > size {
+		t.Errorf("%d bytes free of %d", free, size)
+	}
+	if _, _, err := DiskSpace(dir + "/missing"); !os.IsNotExist(err) {
+		t.Errorf("missing path returned %v", err)
+	}
+}
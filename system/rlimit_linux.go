@@ -0,0 +1,55 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"io/ioutil"
+	"syscall"
+)
+
+// RaiseFileLimit raises the soft limit on open files to the hard limit
+// and returns the new limit.
+func RaiseFileLimit() (uint64, error) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0, err
+	}
+	if rlim.Cur < rlim.Max {
+		rlim.Cur = rlim.Max
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+			return 0, err
+		}
+	}
+	return rlim.Cur, nil
+}
+
+// FileLimit returns the soft limit on open files.
+func FileLimit() (uint64, error) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0, err
+	}
+	return rlim.Cur, nil
+}
+
+// OpenFiles returns the number of files the process has open.
+func OpenFiles() (int, error) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+	// ReadDir's own descriptor was closed before it returned
+	return len(fds), nil
+}
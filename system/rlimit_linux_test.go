@@ -0,0 +1,57 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestRaiseFileLimit(t *testing.T) {
+	limit, err := RaiseFileLimit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		t.Fatal(err)
+	}
+	if rlim.Cur != rlim.Max || limit != rlim.Cur {
+		t.Errorf("limit %d, rlimit %d/%d", limit, rlim.Cur, rlim.Max)
+	}
+	if cur, err := FileLimit(); err != nil || cur != limit {
+		t.Errorf("FileLimit() = %d, %v; want %d", cur, err, limit)
+	}
+}
+
+func TestOpenFiles(t *testing.T) {
+	before, err := OpenFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("/dev/null")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	after, err := OpenFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after != before+1 {
+		t.Errorf("opening a file went from %d to %d open files", before, after)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// TruncationMarker returns the line inserted in place of dropped data.
+// where describes which part was dropped, e.g. "end" or "middle".
+func TruncationMarker(dropped int64, where string) string {
+	return fmt.Sprintf("\n[kola: %d bytes truncated from the %s]\n", dropped, where)
+}
+
+// HeadWriter passes through at most Limit bytes to the underlying writer
+// and silently discards the rest. Writes never fail because of the limit
+// so producers are not disturbed. A Limit of zero means unlimited.
+type HeadWriter struct {
+	W       io.Writer
+	Limit   int64
+	written int64
+	dropped int64
+}
+
+func (h *HeadWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if h.Limit > 0 {
+		remain := h.Limit - h.written
+		if remain <= 0 {
+			h.dropped += int64(n)
+			return n, nil
+		}
+		if int64(n) > remain {
+			h.dropped += int64(n) - remain
+			p = p[:remain]
+		}
+	}
+	w, err := h.W.Write(p)
+	h.written += int64(w)
+	if err != nil {
+		return w, err
+	}
+	return n, nil
+}
+
+// Dropped returns the number of bytes discarded so far.
+func (h *HeadWriter) Dropped() int64 {
+	return h.dropped
+}
+
+// HeadTailWriter keeps the first and last Limit/2 bytes written to it.
+// The head is written through immediately while the tail is buffered in
+// memory and written, preceded by a truncation marker, on Close. The tail
+// buffer grows as needed, so small outputs don't cost the whole limit.
+type HeadTailWriter struct {
+	w         io.WriteCloser
+	head      HeadWriter
+	tail      []byte // ring buffer once full
+	tailLimit int
+	pos       int
+	full      bool
+	dropped   int64
+
+	// Truncated, if set, is called by Close if any data was dropped.
+	Truncated func(dropped int64)
+}
+
+// NewHeadTailWriter wraps w. A limit of zero means unlimited.
+func NewHeadTailWriter(w io.WriteCloser, limit int64) *HeadTailWriter {
+	return &HeadTailWriter{
+		w:         w,
+		head:      HeadWriter{W: w, Limit: limit - limit/2},
+		tailLimit: int(limit / 2),
+	}
+}
+
+func (h *HeadTailWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if h.head.Limit == 0 || h.head.written < h.head.Limit {
+		if _, err := h.head.Write(p); err != nil {
+			return 0, err
+		}
+		if h.head.dropped == 0 {
+			return n, nil
+		}
+		// continue with whatever overflowed the head
+		p = p[len(p)-int(h.head.dropped):]
+		h.head.dropped = 0
+	}
+	if h.tailLimit == 0 {
+		h.dropped += int64(len(p))
+		return n, nil
+	}
+	if len(p) >= h.tailLimit {
+		// only the end of p is kept
+		h.dropped += int64(len(h.tail) + len(p) - h.tailLimit)
+		h.tail = append(h.tail[:0], p[len(p)-h.tailLimit:]...)
+		h.pos = 0
+		h.full = true
+		return n, nil
+	}
+	if !h.full {
+		c := h.tailLimit - len(h.tail)
+		if c > len(p) {
+			c = len(p)
+		}
+		h.tail = append(h.tail, p[:c]...)
+		p = p[c:]
+		h.pos = len(h.tail)
+		if h.pos == h.tailLimit {
+			h.pos = 0
+			h.full = true
+		}
+	}
+	for len(p) > 0 {
+		// the bytes about to be overwritten are lost
+		c := copy(h.tail[h.pos:], p)
+		h.dropped += int64(c)
+		p = p[c:]
+		h.pos += c
+		if h.pos == len(h.tail) {
+			h.pos = 0
+		}
+	}
+	return n, nil
+}
+
+// Dropped returns the number of bytes which will not be written.
+func (h *HeadTailWriter) Dropped() int64 {
+	return h.dropped
+}
+
+// Close flushes the buffered tail and closes the underlying writer.
+func (h *HeadTailWriter) Close() error {
+	var err error
+	if h.dropped > 0 {
+		_, err = io.WriteString(h.w, TruncationMarker(h.dropped, "middle"))
+		if h.Truncated != nil {
+			h.Truncated(h.dropped)
+		}
+	}
+	if err == nil && h.full {
+		if _, err = h.w.Write(h.tail[h.pos:]); err == nil {
+			_, err = h.w.Write(h.tail[:h.pos])
+		}
+	} else if err == nil {
+		_, err = h.w.Write(h.tail[:h.pos])
+	}
+	if cerr := h.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// keepRange returns how much of the head and tail of size bytes to keep so
+// that the result, including the truncation marker, fits within limit. If
+// the marker alone doesn't fit, only the head is kept and there's no marker.
+func keepRange(size, limit int64) (head, tailStart int64, marker bool) {
+	keep := limit - int64(len(TruncationMarker(size, "middle")))
+	if keep < 0 {
+		return limit, size, false
+	}
+	head = keep - keep/2
+	tailStart = size - keep/2
+	return head, tailStart, true
+}
+
+// TruncateString keeps the head and tail of s, replacing the middle with a
+// truncation marker, so that the result is at most limit bytes. A limit of
+// zero means unlimited.
+func TruncateString(s string, limit int64) (string, int64) {
+	if limit <= 0 || int64(len(s)) <= limit {
+		return s, 0
+	}
+	head, tail, marker := keepRange(int64(len(s)), limit)
+	dropped := tail - head
+	if !marker {
+		return s[:head], dropped
+	}
+	return s[:head] + TruncationMarker(dropped, "middle") + s[tail:], dropped
+}
+
+// TruncateFile rewrites the file at path in place as TruncateString would,
+// returning the number of bytes dropped. A limit of zero means unlimited.
+func TruncateFile(path string, limit int64) (int64, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if limit <= 0 || st.Size() <= limit {
+		return 0, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	head, tailStart, marker := keepRange(st.Size(), limit)
+	dropped := tailStart - head
+	if !marker {
+		return dropped, f.Truncate(head)
+	}
+
+	tail := make([]byte, st.Size()-tailStart)
+	if _, err := f.ReadAt(tail, tailStart); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	if _, err := f.Seek(head, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := io.WriteString(f, TruncationMarker(dropped, "middle")); err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(tail); err != nil {
+		return 0, err
+	}
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	return dropped, f.Truncate(pos)
+}
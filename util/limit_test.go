@@ -0,0 +1,143 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type closeBuffer struct {
+	bytes.Buffer
+}
+
+func (*closeBuffer) Close() error { return nil }
+
+func TestHeadTailWriter(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		limit   int64
+		writes  []string
+		out     string
+		dropped int64
+	}{
+		{"unlimited", 0, []string{"abc", "def"}, "abcdef", 0},
+		{"within the limit", 8, []string{"abc", "def"}, "abcdef", 0},
+		{"exactly the limit", 6, []string{"abcdef"}, "abcdef", 0},
+		{"over the head", 6, []string{"abcdefg"}, "abc" + TruncationMarker(1, "middle") + "efg", 1},
+		{"wrapping the tail", 6, []string{"abcd", "ef", "gh", "ij"}, "abc" + TruncationMarker(4, "middle") + "hij", 4},
+		{"wrapping byte by byte", 4, []string{"a", "b", "c", "d", "e", "f", "g"}, "ab" + TruncationMarker(3, "middle") + "fg", 3},
+		{"larger than the tail", 6, []string{"abcd", "0123456789"}, "abc" + TruncationMarker(8, "middle") + "789", 8},
+		{"larger than the tail once full", 6, []string{"abcdefg", "0123456789"}, "abc" + TruncationMarker(11, "middle") + "789", 11},
+		{"no room for a tail", 1, []string{"abc"}, "a" + TruncationMarker(2, "middle"), 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf closeBuffer
+			w := NewHeadTailWriter(&buf, tt.limit)
+			var truncated int64
+			w.Truncated = func(dropped int64) { truncated = dropped }
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			if w.Dropped() != tt.dropped {
+				t.Errorf("dropped %d bytes, want %d", w.Dropped(), tt.dropped)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.out {
+				t.Errorf("wrote %q, want %q", buf.String(), tt.out)
+			}
+			if truncated != tt.dropped {
+				t.Errorf("Truncated called with %d, want %d", truncated, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestHeadTailWriterGrowsTail(t *testing.T) {
+	var buf closeBuffer
+	w := NewHeadTailWriter(&buf, 1<<30)
+	w.Write(bytes.Repeat([]byte("x"), 1<<20))
+	if cap(w.tail) != 0 {
+		t.Errorf("tail of %d bytes allocated for output within the head", cap(w.tail))
+	}
+}
+
+func TestTruncateString(t *testing.T) {
+	// the 100 bytes of s leave 35 of 80 beside a marker of 45 bytes
+	s := strings.Repeat("a", 50) + strings.Repeat("b", 50)
+	for _, tt := range []struct {
+		name    string
+		limit   int64
+		out     string
+		dropped int64
+	}{
+		{"unlimited", 0, s, 0},
+		{"within the limit", 100, s, 0},
+		{"over the limit", 80, strings.Repeat("a", 18) + TruncationMarker(65, "middle") + strings.Repeat("b", 17), 65},
+		{"room for the marker only", 45, TruncationMarker(100, "middle"), 100},
+		{"smaller than the marker", 10, strings.Repeat("a", 10), 90},
+		{"limit of one", 1, "a", 99},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out, dropped := TruncateString(s, tt.limit)
+			if out != tt.out || dropped != tt.dropped {
+				t.Errorf("got %q, dropping %d bytes; want %q, dropping %d", out, dropped, tt.out, tt.dropped)
+			}
+			if tt.limit > 0 && int64(len(out)) > tt.limit {
+				t.Errorf("result of %d bytes over the limit of %d", len(out), tt.limit)
+			}
+		})
+	}
+}
+
+func TestTruncateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-limit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	long := strings.Repeat("a", 50) + strings.Repeat("b", 50)
+	for _, limit := range []int64{0, 1, 10, 60, 80, 100, 200} {
+		path := filepath.Join(dir, "file")
+		if err := ioutil.WriteFile(path, []byte(long), 0666); err != nil {
+			t.Fatal(err)
+		}
+		dropped, err := TruncateFile(path, limit)
+		if err != nil {
+			t.Fatalf("limit %d: %v", limit, err)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, wantDropped := TruncateString(long, limit)
+		if string(b) != want || dropped != wantDropped {
+			t.Errorf("limit %d: truncated to %q, dropping %d bytes; want %q, dropping %d", limit, b, dropped, want, wantDropped)
+		}
+	}
+
+	if _, err := TruncateFile(filepath.Join(dir, "missing"), 10); !os.IsNotExist(err) {
+		t.Errorf("truncating a missing file: %v", err)
+	}
+}
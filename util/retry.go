@@ -16,6 +16,7 @@ package util
 
 import (
 	"fmt"
+	"math/rand"
 	"time"
 )
 
@@ -48,6 +49,58 @@ func RetryConditional(attempts int, delay time.Duration, shouldRetry func(err er
 	return err
 }
 
+// Backoff is an exponential backoff between calls retried by
+// RetryBackoff.
+type Backoff struct {
+	Attempts int           // calls to make, at least one
+	Base     time.Duration // delay after the first failure, doubled after each later one
+	Max      time.Duration // limit of the delay, or 0 for none
+}
+
+// Delay returns the delay after the failed call number failed, counting
+// from 1. jitter, in [0, 1), picks the delay between half and all of the
+// exponential one, so that callers failing together spread out.
+func (b Backoff) Delay(failed int, jitter float64) time.Duration {
+	d := b.Base
+	for i := 1; i < failed && (b.Max == 0 || d < b.Max); i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	return d/2 + time.Duration(jitter*float64(d/2))
+}
+
+// RetryError is the error of the last call made by RetryBackoff.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	if e.Attempts == 1 {
+		return fmt.Sprintf("%v (after 1 attempt)", e.Err)
+	}
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts)
+}
+
+// RetryBackoff calls f until it succeeds, it has been called b.Attempts
+// times, or shouldRetry returns false for its error, sleeping as b says
+// between calls. If f does not succeed, the error is a *RetryError
+// holding the last error of f.
+func RetryBackoff(b Backoff, shouldRetry func(err error) bool, f func() error) error {
+	for i := 1; ; i++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if i >= b.Attempts || !shouldRetry(err) {
+			return &RetryError{Attempts: i, Err: err}
+		}
+		time.Sleep(b.Delay(i, rand.Float64()))
+	}
+}
+
 func WaitUntilReady(timeout, delay time.Duration, checkFunction func() (bool, error)) error {
 	after := time.After(timeout)
 	for {
@@ -16,3 +16,6 @@ package version
 
 // Version is the current version of mantle.
 var Version = "0.0.0"
+
+// Commit is the git commit mantle was built from, if known.
+var Commit = ""